@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+)
+
+const (
+	pointArenaChunkSize = 64 * 1024
+)
+
+// pointArena hands out Point slices carved from large chunks, to avoid
+// allocating thousands of small slices when assembling rings. Returned slices
+// have their capacity set to their length so appending to them never
+// overwrites neighbouring allocations. Slices are only valid until the next
+// Reset().
+type pointArena struct {
+	chunks  [][]Point
+	current int
+	offset  int
+}
+
+// Returns a slice of n points. Content is undefined. A nil arena allocates
+// from the heap.
+func (a *pointArena) Alloc(n int) []Point {
+	if a == nil || n > pointArenaChunkSize/4 {
+		return make([]Point, n)
+	}
+	for {
+		if a.current >= len(a.chunks) {
+			a.chunks = append(a.chunks, make([]Point, pointArenaChunkSize))
+		}
+		chunk := a.chunks[a.current]
+		if a.offset+n <= len(chunk) {
+			p := chunk[a.offset : a.offset+n : a.offset+n]
+			a.offset += n
+			return p
+		}
+		a.current++
+		a.offset = 0
+	}
+}
+
+// Returns a copy of points allocated in the arena.
+func (a *pointArena) Copy(points []Point) []Point {
+	p := a.Alloc(len(points))
+	copy(p, points)
+	return p
+}
+
+// Makes all chunks available again. Previously returned slices must no longer
+// be used.
+func (a *pointArena) Reset() {
+	a.current = 0
+	a.offset = 0
+}
+
+var (
+	pointArenas = sync.Pool{
+		New: func() interface{} {
+			return &pointArena{}
+		},
+	}
+)
+
+func getPointArena() *pointArena {
+	return pointArenas.Get().(*pointArena)
+}
+
+func putPointArena(a *pointArena) {
+	a.Reset()
+	pointArenas.Put(a)
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestPointArena(t *testing.T) {
+	a := &pointArena{}
+	p1 := a.Alloc(3)
+	p2 := a.Copy([]Point{{1, 2}, {3, 4}})
+	if len(p1) != 3 || cap(p1) != 3 {
+		t.Fatalf("unexpected slice: len=%d cap=%d", len(p1), cap(p1))
+	}
+	// Appending must not overwrite the next allocation
+	p1 = append(p1, Point{5, 6})
+	if p2[0] != (Point{1, 2}) {
+		t.Fatalf("allocation was overwritten: %v", p2[0])
+	}
+	// Large allocations bypass chunks
+	big := a.Alloc(pointArenaChunkSize)
+	if len(big) != pointArenaChunkSize || len(a.chunks) != 1 {
+		t.Fatalf("unexpected chunks count: %d", len(a.chunks))
+	}
+	// Fill more than one chunk
+	for i := 0; i < 8; i++ {
+		a.Alloc(pointArenaChunkSize / 4)
+	}
+	if len(a.chunks) != 3 {
+		t.Fatalf("unexpected chunks count: %d", len(a.chunks))
+	}
+	a.Reset()
+	a.Alloc(10)
+	if len(a.chunks) != 3 || a.current != 0 || a.offset != 10 {
+		t.Fatalf("chunks were not reused")
+	}
+	var nilArena *pointArena
+	if len(nilArena.Alloc(4)) != 4 {
+		t.Fatalf("nil arena allocation failed")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RegionOptions are pipeline options which can be set for all regions of a
+// manifest and overridden per region.
+type RegionOptions struct {
+	Workers   int    `json:"workers,omitempty"`
+	Keep      string `json:"keep,omitempty"`
+	Protected bool   `json:"protected_areas,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Compress  string `json:"compress,omitempty"`
+	Parents   bool   `json:"parents,omitempty"`
+	// osmfilter --keep expression applied to the input before indexing
+	Osmfilter string `json:"osmfilter,omitempty"`
+}
+
+// Returns options with fields unset in o taken from defaults. Boolean
+// options can only be enabled by overrides.
+func (o RegionOptions) Merge(defaults RegionOptions) RegionOptions {
+	if o.Workers == 0 {
+		o.Workers = defaults.Workers
+	}
+	if o.Keep == "" {
+		o.Keep = defaults.Keep
+	}
+	o.Protected = o.Protected || defaults.Protected
+	if o.Format == "" {
+		o.Format = defaults.Format
+	}
+	if o.Compress == "" {
+		o.Compress = defaults.Compress
+	}
+	o.Parents = o.Parents || defaults.Parents
+	if o.Osmfilter == "" {
+		o.Osmfilter = defaults.Osmfilter
+	}
+	return o
+}
+
+// ManifestRegion is either a Geofabrik region to download or a local o5m
+// file.
+type ManifestRegion struct {
+	Name    string        `json:"name"`
+	Region  string        `json:"region,omitempty"`
+	Path    string        `json:"path,omitempty"`
+	Options RegionOptions `json:"options"`
+}
+
+type Manifest struct {
+	// Per region databases and outputs are written in WorkDir/<name>
+	WorkDir string `json:"workdir"`
+	// Downloads shared by all runs, reused when present
+	CacheDir string           `json:"cache"`
+	Defaults RegionOptions    `json:"defaults"`
+	Regions  []ManifestRegion `json:"regions"`
+}
+
+func parseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	err := json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %s", err)
+	}
+	if m.WorkDir == "" {
+		m.WorkDir = "."
+	}
+	if m.CacheDir == "" {
+		m.CacheDir = filepath.Join(m.WorkDir, "cache")
+	}
+	seen := map[string]bool{}
+	for i, r := range m.Regions {
+		if r.Name == "" || strings.ContainsAny(r.Name, `/\`) {
+			return nil, fmt.Errorf("invalid region name: %q", r.Name)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("duplicate region name: %s", r.Name)
+		}
+		seen[r.Name] = true
+		if (r.Region == "") == (r.Path == "") {
+			return nil, fmt.Errorf("%s: exactly one of region and path must be set",
+				r.Name)
+		}
+		m.Regions[i].Options = r.Options.Merge(m.Defaults)
+	}
+	return m, nil
+}
+
+func readManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+// Returns the directory where fetch downloads region, and the path of the
+// converted o5m file in it.
+func getRegionCache(cacheDir, region string) (string, string) {
+	dir := filepath.Join(cacheDir, filepath.FromSlash(strings.Trim(region, "/")))
+	return dir, filepath.Join(dir, path.Base(region)+"-latest.o5m")
+}
+
+// PipelineStep is an external command to run. Commands starting with "osm"
+// run this tool.
+type PipelineStep struct {
+	Name string
+	Args []string
+}
+
+func appendSelectionArgs(args []string, opts RegionOptions) []string {
+	if opts.Keep != "" {
+		args = append(args, "--keep", opts.Keep)
+	}
+	if opts.Protected {
+		args = append(args, "--protected-areas")
+	}
+	return args
+}
+
+// Returns the steps building the boundaries of region r. Downloads are kept
+// in cacheDir and other outputs are written in dir.
+func makePipeline(r *ManifestRegion, cacheDir, dir string) []PipelineStep {
+	opts := r.Options
+	steps := []PipelineStep{}
+	input := r.Path
+	if r.Region != "" {
+		regionDir, o5mPath := getRegionCache(cacheDir, r.Region)
+		input = o5mPath
+		steps = append(steps, PipelineStep{"fetch", []string{"osm", "fetch",
+			"--o5m", "--outdir", regionDir, r.Region}})
+	}
+	if opts.Osmfilter != "" {
+		filtered := filepath.Join(dir, "filtered.o5m")
+		steps = append(steps, PipelineStep{"osmfilter", []string{"osmfilter",
+			"--keep=" + opts.Osmfilter, input, "-o=" + filtered}})
+		input = filtered
+	}
+	db := filepath.Join(dir, r.Name+".db")
+	workers := []string{}
+	if opts.Workers > 0 {
+		workers = []string{"--workers", strconv.Itoa(opts.Workers)}
+	}
+	steps = append(steps,
+		PipelineStep{"indexways", []string{"osm", "indexways", input, db}},
+		PipelineStep{"indexrelations", []string{"osm", "indexrelations", input,
+			db}},
+		PipelineStep{"indexlocations", appendSelectionArgs(append(
+			[]string{"osm", "indexlocations", input, db}, workers...), opts)},
+		PipelineStep{"indexcenters", appendSelectionArgs(
+			[]string{"osm", "indexcenters", input, db}, opts)},
+	)
+	if opts.Parents {
+		steps = append(steps, PipelineStep{"indexparents",
+			[]string{"osm", "indexparents", db}})
+	}
+	export := appendSelectionArgs(append([]string{"osm", "geojson", input, db,
+		filepath.Join(dir, r.Name+".jsonl")}, workers...), opts)
+	if opts.Format != "" {
+		export = append(export, "--format", opts.Format)
+	}
+	if opts.Compress != "" {
+		export = append(export, "--compress", opts.Compress)
+	}
+	steps = append(steps, PipelineStep{"geojson", export})
+	return steps
+}
+
+// RegionReport is the outcome of one region pipeline.
+type RegionReport struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Step     string  `json:"failed_step,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_s"`
+	Output   string  `json:"output,omitempty"`
+}
+
+// Runs region steps, logging their output in dir/pipeline.log. The fetch
+// step is skipped when the converted download is already cached.
+func runPipeline(self string, r *ManifestRegion, cacheDir, dir string) *RegionReport {
+	start := time.Now()
+	report := &RegionReport{
+		Name:   r.Name,
+		Status: "ok",
+	}
+	fail := func(step string, err error) *RegionReport {
+		report.Status = "failed"
+		report.Step = step
+		report.Error = err.Error()
+		report.Duration = time.Now().Sub(start).Seconds()
+		return report
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fail("setup", err)
+	}
+	log, err := os.Create(filepath.Join(dir, "pipeline.log"))
+	if err != nil {
+		return fail("setup", err)
+	}
+	defer log.Close()
+	for _, step := range makePipeline(r, cacheDir, dir) {
+		if step.Name == "fetch" {
+			regionDir, cached := getRegionCache(cacheDir, r.Region)
+			if _, err := os.Stat(cached); err == nil {
+				fmt.Fprintf(log, "using cached %s\n", cached)
+				continue
+			}
+			err := os.MkdirAll(regionDir, 0755)
+			if err != nil {
+				return fail(step.Name, err)
+			}
+		}
+		exe := step.Args[0]
+		if exe == "osm" {
+			exe = self
+		}
+		slog.Info("running", "region", r.Name, "step", step.Name)
+		fmt.Fprintf(log, "+ %s\n", strings.Join(step.Args, " "))
+		cmd := exec.Command(exe, step.Args[1:]...)
+		cmd.Stdout = log
+		cmd.Stderr = log
+		err = cmd.Run()
+		if err != nil {
+			return fail(step.Name, err)
+		}
+	}
+	report.Output = filepath.Join(dir, r.Name+".jsonl")
+	report.Duration = time.Now().Sub(start).Seconds()
+	return report
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	m, err := parseManifest([]byte(`{
+		"workdir": "work",
+		"defaults": {"workers": 4, "format": "features", "parents": true},
+		"regions": [
+			{"name": "france", "region": "europe/france",
+				"options": {"workers": 8}},
+			{"name": "parks", "path": "parks.o5m",
+				"options": {"protected_areas": true, "compress": "zstd"}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CacheDir != filepath.Join("work", "cache") {
+		t.Fatalf("unexpected cache dir: %s", m.CacheDir)
+	}
+	expected := RegionOptions{Workers: 8, Format: "features", Parents: true}
+	if !reflect.DeepEqual(m.Regions[0].Options, expected) {
+		t.Fatalf("unexpected france options: %+v", m.Regions[0].Options)
+	}
+	expected = RegionOptions{Workers: 4, Format: "features", Parents: true,
+		Protected: true, Compress: "zstd"}
+	if !reflect.DeepEqual(m.Regions[1].Options, expected) {
+		t.Fatalf("unexpected parks options: %+v", m.Regions[1].Options)
+	}
+
+	for _, invalid := range []string{
+		`{"regions": [{"name": "a"}]}`,
+		`{"regions": [{"name": "a", "path": "a.o5m", "region": "europe/a"}]}`,
+		`{"regions": [{"name": "a/b", "path": "a.o5m"}]}`,
+		`{"regions": [{"name": "a", "path": "a.o5m"}, {"name": "a", "path": "b.o5m"}]}`,
+		`{"regions": `,
+	} {
+		if _, err := parseManifest([]byte(invalid)); err == nil {
+			t.Fatalf("invalid manifest accepted: %s", invalid)
+		}
+	}
+}
+
+func TestMakePipeline(t *testing.T) {
+	r := &ManifestRegion{
+		Name:   "france",
+		Region: "europe/france",
+		Options: RegionOptions{
+			Workers:   2,
+			Keep:      "admin_level<=8",
+			Osmfilter: "boundary=",
+		},
+	}
+	steps := makePipeline(r, "cache", "work")
+	names := []string{}
+	for _, s := range steps {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, " ") != "fetch osmfilter indexways indexrelations "+
+		"indexlocations indexcenters geojson" {
+		t.Fatalf("unexpected steps: %v", names)
+	}
+	fetch := strings.Join(steps[0].Args, " ")
+	if fetch != "osm fetch --o5m --outdir "+filepath.Join("cache", "europe",
+		"france")+" europe/france" {
+		t.Fatalf("unexpected fetch step: %s", fetch)
+	}
+	locations := strings.Join(steps[4].Args, " ")
+	expected := "osm indexlocations " + filepath.Join("work", "filtered.o5m") +
+		" " + filepath.Join("work", "france.db") +
+		" --workers 2 --keep admin_level<=8"
+	if locations != expected {
+		t.Fatalf("unexpected indexlocations step: %s", locations)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Policies applied to boundary values missing from both boundary lists.
+const (
+	UnknownBoundaryAccept = "accept"
+	UnknownBoundaryReject = "reject"
+	UnknownBoundaryError  = "error"
+)
+
+var (
+	// Accepted and rejected boundary tag values, replaced with --boundaries.
+	boundaryLists = defaultBoundaryLists()
+	// How unknown boundary values are handled, set by --unknown-boundary.
+	unknownBoundaryPolicy = UnknownBoundaryError
+)
+
+// BoundaryLists tells which boundary tag values are administrative, values
+// are compared in lower case.
+type BoundaryLists struct {
+	Accepted []string `json:"accepted"`
+	Rejected []string `json:"rejected"`
+	values   map[string]bool
+}
+
+func newBoundaryLists(accepted, rejected []string) (*BoundaryLists, error) {
+	b := &BoundaryLists{
+		Accepted: accepted,
+		Rejected: rejected,
+		values:   map[string]bool{},
+	}
+	for _, v := range accepted {
+		b.values[strings.ToLower(v)] = true
+	}
+	for _, v := range rejected {
+		v = strings.ToLower(v)
+		if b.values[v] {
+			return nil, fmt.Errorf("boundary value is both accepted and "+
+				"rejected: '%s'", v)
+		}
+		b.values[v] = false
+	}
+	return b, nil
+}
+
+func defaultBoundaryLists() *BoundaryLists {
+	b, err := newBoundaryLists(_ACCEPTED_BOUNDARIES, _REJECTED_BOUNDARIES)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Lookup returns whether boundary value v is accepted and whether it is
+// listed at all.
+func (b *BoundaryLists) Lookup(v string) (bool, bool) {
+	accepted, found := b.values[strings.ToLower(v)]
+	return accepted, found
+}
+
+func parseBoundaryLists(r io.Reader) (*BoundaryLists, error) {
+	b := &BoundaryLists{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(b); err != nil {
+		return nil, err
+	}
+	return newBoundaryLists(b.Accepted, b.Rejected)
+}
+
+func readBoundaryLists(path string) (*BoundaryLists, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	b, err := parseBoundaryLists(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return b, nil
+}
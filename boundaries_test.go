@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnknownBoundaryPolicy(t *testing.T) {
+	rel := &Relation{
+		Id: 1,
+		Tags: []StringPair{
+			{Key: "type", Value: "boundary"},
+			{Key: "boundary", Value: "Adminisrative"},
+			{Key: "admin_level", Value: "8"},
+			{Key: "name", Value: "a"},
+		},
+	}
+	defer func() {
+		unknownBoundaryPolicy = UnknownBoundaryError
+		boundaryLists = defaultBoundaryLists()
+	}()
+	if _, err := ignoreRelation(rel); err == nil {
+		t.Fatal("unknown boundary should fail by default")
+	}
+	for policy, ignored := range map[string]bool{
+		UnknownBoundaryAccept: false,
+		UnknownBoundaryReject: true,
+	} {
+		unknownBoundaryPolicy = policy
+		ok, err := ignoreRelation(rel)
+		if err != nil || ok != ignored {
+			t.Fatalf("%s: unexpected result: %v, %v", policy, ok, err)
+		}
+	}
+
+	unknownBoundaryPolicy = UnknownBoundaryError
+	lists, err := parseBoundaryLists(strings.NewReader(
+		`{"accepted": ["adminisrative"], "rejected": ["census"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	boundaryLists = lists
+	if ok, err := ignoreRelation(rel); ok || err != nil {
+		t.Fatalf("listed boundary should be accepted: %v, %v", ok, err)
+	}
+	_, err = parseBoundaryLists(strings.NewReader(
+		`{"accepted": ["census"], "rejected": ["Census"]}`))
+	if err == nil {
+		t.Fatal("conflicting lists should be rejected")
+	}
+}
+
+func TestAdminLevelRange(t *testing.T) {
+	rel := &Relation{
+		Id: 1,
+		Tags: []StringPair{
+			{Key: "type", Value: "boundary"},
+			{Key: "boundary", Value: "administrative"},
+			{Key: "admin_level", Value: "10"},
+			{Key: "name", Value: "a"},
+		},
+	}
+	loc := &Location{
+		Type:        "MultiPolygon",
+		Coordinates: [][][][]float64{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+	}
+	if ok, err := ignoreRelation(rel); !ok || err != nil {
+		t.Fatalf("level 10 should be ignored by default: %v, %v", ok, err)
+	}
+	if _, err := makeJsonRelation(rel, &Centroid{}, loc); err == nil {
+		t.Fatal("level 10 should not be exported by default")
+	}
+	defer func() {
+		minAdminLevel = 1
+		maxAdminLevel = 8
+	}()
+	maxAdminLevel = 11
+	if ok, err := ignoreRelation(rel); ok || err != nil {
+		t.Fatalf("level 10 should be processed: %v, %v", ok, err)
+	}
+	js, err := makeJsonRelation(rel, &Centroid{}, loc)
+	if err != nil || js.AdminLevel != 10 {
+		t.Fatalf("unexpected relation: %+v, %v", js, err)
+	}
+	for _, r := range [][2]int{{0, 8}, {4, 12}, {6, 4}} {
+		if checkAdminLevelRange(r[0], r[1]) == nil {
+			t.Fatalf("range %v should be rejected", r)
+		}
+	}
+}
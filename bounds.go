@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+)
+
+func (b *BBox) BoundingBox() BoundingBox {
+	return BoundingBox{
+		X1: b.MinLon,
+		Y1: b.MinLat,
+		X2: b.MaxLon,
+		Y2: b.MaxLat,
+	}
+}
+
+// Compares the declared header bounding box with actual nodes extent.
+// Returned errors are problems making the header unreliable, warnings flag
+// a header much larger than the data, which is harmless but suspicious.
+// slack is the fraction of the declared extent allowed around actual data.
+func checkBoundingBox(declared *BoundingBox, actual BoundingBox,
+	slack float64) (errors []string, warnings []string) {
+
+	if declared == nil {
+		return []string{"header bounding box is missing"}, nil
+	}
+	// Coordinates are stored with 7 decimals
+	const eps = 1e-7
+	if declared.X1 > declared.X2 || declared.Y1 > declared.Y2 {
+		errors = append(errors, fmt.Sprintf(
+			"header bounding box is inverted: %s", formatBoundingBox(*declared)))
+	}
+	if actual.X1 < declared.X1-eps || actual.Y1 < declared.Y1-eps ||
+		actual.X2 > declared.X2+eps || actual.Y2 > declared.Y2+eps {
+		errors = append(errors, fmt.Sprintf(
+			"nodes extent %s exceeds header bounding box %s",
+			formatBoundingBox(actual), formatBoundingBox(*declared)))
+	}
+	dx := (declared.X2 - declared.X1) * slack
+	dy := (declared.Y2 - declared.Y1) * slack
+	if actual.X1-declared.X1 > dx || declared.X2-actual.X2 > dx ||
+		actual.Y1-declared.Y1 > dy || declared.Y2-actual.Y2 > dy {
+		warnings = append(warnings, fmt.Sprintf(
+			"header bounding box %s is much larger than nodes extent %s",
+			formatBoundingBox(*declared), formatBoundingBox(actual)))
+	}
+	return errors, warnings
+}
+
+func formatBoundingBox(b BoundingBox) string {
+	return fmt.Sprintf("[%.7f,%.7f,%.7f,%.7f]", b.X1, b.Y1, b.X2, b.Y2)
+}
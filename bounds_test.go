@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckBoundingBox(t *testing.T) {
+	actual := BoundingBox{X1: 1, Y1: 2, X2: 3, Y2: 4}
+	tests := []struct {
+		Declared *BoundingBox
+		Errors   int
+		Warnings int
+	}{
+		{nil, 1, 0},
+		{&BoundingBox{X1: 1, Y1: 2, X2: 3, Y2: 4}, 0, 0},
+		{&BoundingBox{X1: 0.99, Y1: 1.99, X2: 3.01, Y2: 4.01}, 0, 0},
+		{&BoundingBox{X1: 1.5, Y1: 2, X2: 3, Y2: 4}, 1, 0},
+		{&BoundingBox{X1: 3, Y1: 4, X2: 1, Y2: 2}, 2, 0},
+		{&BoundingBox{X1: -180, Y1: -90, X2: 180, Y2: 90}, 0, 1},
+	}
+	for i, test := range tests {
+		errors, warnings := checkBoundingBox(test.Declared, actual, 0.1)
+		if len(errors) != test.Errors || len(warnings) != test.Warnings {
+			t.Fatalf("%d: unexpected errors %v or warnings %v", i, errors,
+				warnings)
+		}
+	}
+}
@@ -2,31 +2,124 @@ package main
 
 import (
 	"fmt"
-
-	"github.com/pmezard/gogeos/geos"
+	"strconv"
+	"strings"
 )
 
 type Centroid struct {
 	Lon    float64 `json:"lon"`
 	Lat    float64 `json:"lat"`
 	NodeId int64   `json:"nodeid"`
+	// Source tells where the center comes from, one of the CenterSource*
+	// values. It is empty for centers indexed before it was stored.
+	Source string `json:"source,omitempty"`
+}
+
+const (
+	CenterSourceLabel       = "label"
+	CenterSourceAdminCentre = "admin_centre"
+	CenterSourceCapital     = "capital"
+	CenterSourceCentroid    = "centroid"
+
+	DefaultCenterPreference = "label,admin_centre,capital,centroid"
+)
+
+// Parses a comma separated list of center sources, most preferred first.
+func parseCenterPreference(s string) ([]string, error) {
+	chain := []string{}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		source := strings.TrimSpace(part)
+		switch source {
+		case CenterSourceLabel, CenterSourceAdminCentre, CenterSourceCapital,
+			CenterSourceCentroid:
+		default:
+			return nil, fmt.Errorf("unknown center source %q in %q", source, s)
+		}
+		if seen[source] {
+			return nil, fmt.Errorf("duplicate center source %q in %q", source, s)
+		}
+		seen[source] = true
+		chain = append(chain, source)
+	}
+	return chain, nil
 }
 
-func makeGeometriesFromLocation(loc *Location) ([]*geos.Geometry, error) {
+// Returns the administrative level a node is the capital of, 2 for
+// capital=yes, or -1 if it is not a capital.
+func getCapitalLevel(tags []StringPair) int {
+	v, ok := findTag(tags, "capital")
+	if !ok {
+		return -1
+	}
+	if v == "yes" {
+		return 2
+	}
+	level, err := strconv.Atoi(v)
+	if err != nil || level < 1 || level > 11 {
+		return -1
+	}
+	return level
+}
+
+// Returns the first center of chain available in found, keyed by source, or
+// nil. Computed centroids are not looked up.
+func pickCenter(chain []string, found map[string]*Centroid) *Centroid {
+	for _, source := range chain {
+		if c := found[source]; c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// AdminCentre describes the admin_centre or label node of a relation, the
+// official seat of administration rather than a computed point. Its place,
+// capital and population tags are kept, the latter estimating the
+// population of boundaries which do not tell it.
+type AdminCentre struct {
+	NodeId     int64   `json:"node_id,string"`
+	Role       string  `json:"role"`
+	Name       string  `json:"name,omitempty"`
+	Lon        float64 `json:"lon"`
+	Lat        float64 `json:"lat"`
+	Place      string  `json:"place,omitempty"`
+	Capital    string  `json:"capital,omitempty"`
+	Population int64   `json:"population,omitempty"`
+}
+
+// Returns the admin centre described by node n, referenced with role.
+func makeAdminCentre(n *Node, role string) *AdminCentre {
+	c := &AdminCentre{
+		NodeId: n.Id,
+		Role:   role,
+		Lon:    float64(n.Lon) / 1e7,
+		Lat:    float64(n.Lat) / 1e7,
+	}
+	c.Name, _ = findTag(n.Tags, "name")
+	c.Place, _ = findTag(n.Tags, "place")
+	c.Capital, _ = findTag(n.Tags, "capital")
+	if v, ok := findTag(n.Tags, "population"); ok {
+		c.Population = parsePopulation(v)
+	}
+	return c
+}
+
+func makeGeometriesFromLocation(loc *Location) ([]Geometry, error) {
 	polygons := [][][][]float64{}
 	if loc.Type == "multipolygon" {
 		polygons = append(polygons, loc.Coordinates...)
 	} else {
 		return nil, fmt.Errorf("unsupported location type: %s", loc.Type)
 	}
-	geoms := []*geos.Geometry{}
+	geoms := []Geometry{}
 	for _, poly := range polygons {
 		// Assume first ring is outer, remaining ones, inner rings.
-		rings := [][]geos.Coord{}
+		rings := [][]Coord{}
 		for _, ring := range poly {
-			r := make([]geos.Coord, len(ring))
+			r := make([]Coord, len(ring))
 			for i, p := range ring {
-				r[i] = geos.Coord{
+				r[i] = Coord{
 					X: p[0],
 					Y: p[1],
 				}
@@ -37,7 +130,7 @@ func makeGeometriesFromLocation(loc *Location) ([]*geos.Geometry, error) {
 			geoms = append(geoms, nil)
 			continue
 		}
-		g, err := geos.NewPolygon(rings[0], rings[1:]...)
+		g, err := newPolygon(rings[0], rings[1:]...)
 		if err != nil {
 			return nil, err
 		}
@@ -144,19 +237,11 @@ func computeSimplePolygonCentroid(ring [][]float64) (*Centroid, error) {
 	}, nil
 }
 
-func isCentroidInPolygon(c *Centroid, poly *geos.Geometry) (bool, error) {
-	p, err := geos.NewPoint(geos.Coord{
+func isCentroidInPolygon(c *Centroid, poly Geometry) (bool, error) {
+	return poly.ContainsPoint(Coord{
 		X: c.Lon,
 		Y: c.Lat,
 	})
-	if err != nil {
-		return false, err
-	}
-	ok, err := poly.Contains(p)
-	if err != nil {
-		return false, err
-	}
-	return ok, nil
 }
 
 func computeCentroid(loc *Location) (*Centroid, error) {
@@ -168,6 +253,9 @@ func computeCentroid(loc *Location) (*Centroid, error) {
 	maxArea := float64(0)
 	maxPoly := -1
 	for i, p := range polygons {
+		if p == nil {
+			continue
+		}
 		area, err := p.Area()
 		if err != nil {
 			return nil, err
@@ -184,6 +272,9 @@ func computeCentroid(loc *Location) (*Centroid, error) {
 	if len(poly) <= 0 {
 		return nil, fmt.Errorf("invalid empty polygon")
 	}
+	if centroidMethod == CentroidPolylabel {
+		return computePolylabelCentroid(poly), nil
+	}
 	outer := poly[0]
 
 	// Cheap attempt with barycenter
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func checkCentroid(t *testing.T, coords [][][][]float64, x, y float64) {
 	c, err := computeCentroid(&Location{
@@ -82,3 +85,79 @@ func TestComputeCentroid(t *testing.T) {
 		t.Fatal("unexpected centroid")
 	}
 }
+
+func TestPolylabelCentroid(t *testing.T) {
+	defer func() {
+		centroidMethod = CentroidHeuristic
+	}()
+	centroidMethod = CentroidPolylabel
+	// Square with a hole, rejected by the heuristic
+	coords := [][][][]float64{{
+		{{0, 0}, {0, 3}, {3, 3}, {3, 0}, {0, 0}},
+		{{1, 1}, {2, 1}, {2, 2}, {1, 2}, {1, 1}},
+	}}
+	c, err := computeCentroid(&Location{
+		Type:        "multipolygon",
+		Coordinates: coords,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || !isInMultiPolygon(coords, c.Lon, c.Lat) {
+		t.Fatalf("centroid is not inside polygon: %+v", c)
+	}
+	// The label lies in a corner, on the diagonal, 2-sqrt(2) away from both
+	// the hole corner and the outer sides
+	d := polygonSignedDistance(c.Lon, c.Lat, coords[0])
+	if math.Abs(d-(2-math.Sqrt2)) > 1e-3 {
+		t.Fatalf("unexpected distance to boundary: %f", d)
+	}
+
+	// Crescent, its barycenter lies outside
+	crescent := [][][]float64{{
+		{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 3}, {3, 3}, {3, 1}, {0, 1}, {0, 0},
+	}}
+	p := polylabel(crescent, 1e-6)
+	d = polygonSignedDistance(p[0], p[1], crescent)
+	if p[0] < 3 || math.Abs(d-(2-math.Sqrt2)) > 1e-3 {
+		t.Fatalf("unexpected crescent label: %v", p)
+	}
+}
+
+func TestCenterPreference(t *testing.T) {
+	chain, err := parseCenterPreference(DefaultCenterPreference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 4 || chain[0] != CenterSourceLabel {
+		t.Fatalf("unexpected chain: %v", chain)
+	}
+	for _, s := range []string{"label,label", "capital,middle", ""} {
+		if _, err := parseCenterPreference(s); err == nil {
+			t.Fatalf("%q should have been rejected", s)
+		}
+	}
+
+	found := map[string]*Centroid{
+		CenterSourceAdminCentre: {NodeId: 1, Source: CenterSourceAdminCentre},
+		CenterSourceCapital:     {NodeId: 2, Source: CenterSourceCapital},
+	}
+	c := pickCenter(chain, found)
+	if c == nil || c.NodeId != 1 {
+		t.Fatalf("unexpected center: %+v", c)
+	}
+	c = pickCenter([]string{CenterSourceCapital, CenterSourceAdminCentre}, found)
+	if c == nil || c.NodeId != 2 {
+		t.Fatalf("unexpected center: %+v", c)
+	}
+	if c := pickCenter([]string{CenterSourceLabel}, found); c != nil {
+		t.Fatalf("unexpected center: %+v", c)
+	}
+
+	for v, level := range map[string]int{"yes": 2, "4": 4, "no": -1, "12": -1} {
+		tags := []StringPair{{Key: "capital", Value: v}}
+		if l := getCapitalLevel(tags); l != level {
+			t.Fatalf("capital=%s: expected level %d, got %d", v, level, l)
+		}
+	}
+}
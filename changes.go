@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// Change actions reported by ChangeReader
+const (
+	ChangeCreate = 1
+	ChangeModify = 2
+	ChangeDelete = 3
+)
+
+var (
+	changeActions = map[string]int{
+		"create": ChangeCreate,
+		"modify": ChangeModify,
+		"delete": ChangeDelete,
+	}
+)
+
+// ChangeReader iterates over the elements of a change file along with the
+// action applied to them. Deleted elements only have their id set.
+type ChangeReader interface {
+	Next() bool
+	Err() error
+	Close() error
+	Action() int
+	Kind() int
+	Node() *Node
+	Way() *Way
+	Relation() *Relation
+}
+
+// OSCReader reads OsmChange XML files, as published by the replication
+// services.
+type OSCReader struct {
+	r      *OSMXMLReader
+	err    error
+	action int
+	kind   int
+}
+
+func NewOSCReader(path string) (*OSCReader, error) {
+	r, err := NewOSMXMLReader(path)
+	if err != nil {
+		return nil, err
+	}
+	return &OSCReader{r: r}, nil
+}
+
+func (r *OSCReader) Close() error {
+	return r.r.Close()
+}
+
+func (r *OSCReader) Err() error {
+	return r.err
+}
+
+func (r *OSCReader) Action() int {
+	return r.action
+}
+
+func (r *OSCReader) Kind() int {
+	return r.kind
+}
+
+func (r *OSCReader) Node() *Node {
+	return &r.r.node
+}
+
+func (r *OSCReader) Way() *Way {
+	return &r.r.way
+}
+
+func (r *OSCReader) Relation() *Relation {
+	return &r.r.relation
+}
+
+// Parses a deleted element, which may come without coordinates or members.
+func (r *OSCReader) parseDeleted(e *xml.StartElement, kind int) error {
+	id, err := parseXmlInt(e, "id", true)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case NodeKind:
+		r.r.node = Node{Id: id}
+	case WayKind:
+		r.r.way = Way{Id: id}
+	case RelationKind:
+		r.r.relation = Relation{Id: id}
+	}
+	return r.r.skipElement()
+}
+
+func (r *OSCReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	for {
+		offset := r.r.offset()
+		t, err := r.r.d.RawToken()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if e, ok := t.(xml.EndElement); ok {
+			if changeActions[e.Name.Local] != 0 {
+				r.action = 0
+			}
+			continue
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if action := changeActions[e.Name.Local]; action != 0 {
+			r.action = action
+			continue
+		}
+		kind := 0
+		switch e.Name.Local {
+		case "node":
+			kind = NodeKind
+		case "way":
+			kind = WayKind
+		case "relation":
+			kind = RelationKind
+		}
+		if kind == 0 {
+			if e.Name.Local == "osmChange" {
+				continue
+			}
+			err = r.r.skipElement()
+		} else if r.action == 0 {
+			err = fmt.Errorf("element outside of a change block")
+		} else if r.action == ChangeDelete {
+			err = r.parseDeleted(&e, kind)
+		} else {
+			switch kind {
+			case NodeKind:
+				err = r.r.parseNode(&e)
+			case WayKind:
+				err = r.r.parseWay(&e)
+			case RelationKind:
+				err = r.r.parseRelation(&e)
+			}
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			r.err = fmt.Errorf("cannot parse <%s> at %d: %s", e.Name.Local,
+				offset, err)
+			return false
+		}
+		if kind != 0 {
+			r.kind = kind
+			return true
+		}
+	}
+}
+
+// O5CReader reads o5c change files, as written by osmconvert. o5c does not
+// tell created elements from modified ones, elements with version 1 are
+// reported as created.
+type O5CReader struct {
+	r      *O5MReader
+	action int
+}
+
+func NewO5CReader(path string) (*O5CReader, error) {
+	r, err := NewO5MReader(path)
+	if err != nil {
+		return nil, err
+	}
+	if !r.IsChange() {
+		r.Close()
+		return nil, fmt.Errorf("not an o5c file: %s", path)
+	}
+	return &O5CReader{r: r}, nil
+}
+
+func (r *O5CReader) Close() error {
+	return r.r.Close()
+}
+
+func (r *O5CReader) Err() error {
+	return r.r.Err()
+}
+
+func (r *O5CReader) Action() int {
+	return r.action
+}
+
+func (r *O5CReader) Kind() int {
+	return r.r.Kind()
+}
+
+func (r *O5CReader) Node() *Node {
+	return r.r.Node()
+}
+
+func (r *O5CReader) Way() *Way {
+	return r.r.Way()
+}
+
+func (r *O5CReader) Relation() *Relation {
+	return r.r.Relation()
+}
+
+func (r *O5CReader) Next() bool {
+	for r.r.Next() {
+		var meta *Metadata
+		switch r.r.Kind() {
+		case NodeKind:
+			meta = &r.r.Node().Meta
+		case WayKind:
+			meta = &r.r.Way().Meta
+		case RelationKind:
+			meta = &r.r.Relation().Meta
+		default:
+			continue
+		}
+		if r.r.Deleted() {
+			r.action = ChangeDelete
+		} else if meta.Version == 1 {
+			r.action = ChangeCreate
+		} else {
+			r.action = ChangeModify
+		}
+		return true
+	}
+	return false
+}
+
+// Opens path with the change reader matching its format, o5c files start
+// with a reset marker like o5m ones.
+func OpenChangeReader(path string) (ChangeReader, error) {
+	fp, err := o5m.OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newChangeReader(fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newChangeReader(fp *o5m.Input) (ChangeReader, error) {
+	head, err := fp.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %s", fp.Path(), err)
+	}
+	if head[0] != 0xff {
+		r, err := newOSMXMLReader(fp)
+		if err != nil {
+			return nil, err
+		}
+		return &OSCReader{r: r}, nil
+	}
+	r, err := o5m.NewInputReader(fp)
+	if err != nil {
+		return nil, err
+	}
+	if !r.IsChange() {
+		return nil, fmt.Errorf("not an o5c file: %s", fp.Path())
+	}
+	return &O5CReader{r: r}, nil
+}
+
+// ChangeSet records the last action applied to changed elements.
+type ChangeSet struct {
+	Nodes     map[int64]int
+	Ways      map[int64]int
+	Relations map[int64]int
+}
+
+func readChangeSet(r ChangeReader) (*ChangeSet, error) {
+	cs := &ChangeSet{
+		Nodes:     map[int64]int{},
+		Ways:      map[int64]int{},
+		Relations: map[int64]int{},
+	}
+	for r.Next() {
+		switch r.Kind() {
+		case NodeKind:
+			cs.Nodes[r.Node().Id] = r.Action()
+		case WayKind:
+			cs.Ways[r.Way().Id] = r.Action()
+		case RelationKind:
+			cs.Relations[r.Relation().Id] = r.Action()
+		}
+	}
+	return cs, r.Err()
+}
+
+// ChangeStats summarizes the db updates made by applyChanges.
+type ChangeStats struct {
+	Ways             int
+	DeletedWays      int
+	Relations        int
+	DeletedRelations int
+	Locations        int
+	DeletedLocations int
+}
+
+// Updates db with the changes of cs. r is the input file with the changes
+// already applied, it provides the new content of changed elements. Ways
+// changed or referencing changed nodes are rebuilt, stored sub-relations
+// are updated, and the locations of relations depending on changed
+// elements, directly or through sub-relations, are rebuilt. Their centroids
+// are dropped and must be computed again by indexcenters.
+func applyChanges(cs *ChangeSet, r OSMReader, db *WaysDb) (*ChangeStats, error) {
+	stats := &ChangeStats{}
+
+	// Collect changed ways and their nodes
+	err := r.SeekToKind(WayKind)
+	if err != nil {
+		return nil, err
+	}
+	ways := []*Way{}
+	nodeIds := map[int64]bool{}
+	for r.Next() {
+		if r.Kind() != WayKind {
+			continue
+		}
+		w := r.Way()
+		changed := cs.Ways[w.Id] != 0
+		for _, id := range w.Nodes {
+			if cs.Nodes[id] != 0 {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			continue
+		}
+		ways = append(ways, &Way{
+			Id:    w.Id,
+			Nodes: append([]int64{}, w.Nodes...),
+		})
+		for _, id := range w.Nodes {
+			nodeIds[id] = true
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	err = r.SeekToKind(NodeKind)
+	if err != nil {
+		return nil, err
+	}
+	nodes := NewNodePoints(len(nodeIds))
+	for r.Next() && r.Kind() != WayKind {
+		if r.Kind() != NodeKind {
+			continue
+		}
+		n := r.Node()
+		if !nodeIds[n.Id] {
+			continue
+		}
+		err := nodes.Append(n.Id, Point{Lon: n.Lon, Lat: n.Lat})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	if nodes.Len() != len(nodeIds) {
+		return nil, fmt.Errorf("%d nodes of changed ways are missing",
+			len(nodeIds)-nodes.Len())
+	}
+	changedWays := map[int64]bool{}
+	batch := db.NewBatch(writeBatchSize)
+	for _, w := range ways {
+		line, err := buildLinestring(w, nodes)
+		if err != nil {
+			return nil, err
+		}
+		line.RemoveRepeatedPoints()
+		if err := batch.Put(line); err != nil {
+			return nil, err
+		}
+		changedWays[w.Id] = true
+		stats.Ways++
+	}
+	if err := batch.Flush(); err != nil {
+		return nil, err
+	}
+	for id, action := range cs.Ways {
+		if action != ChangeDelete {
+			continue
+		}
+		if err := db.DeleteWay(id); err != nil {
+			return nil, err
+		}
+		changedWays[id] = true
+		stats.DeletedWays++
+	}
+
+	// Find relations depending on changed ways or relations
+	err = r.SeekToKind(RelationKind)
+	if err != nil {
+		return nil, err
+	}
+	changed := map[int64]bool{}
+	subRelations := map[int64][]int64{}
+	for id := range cs.Relations {
+		changed[id] = true
+	}
+	for r.Next() {
+		if r.Kind() != RelationKind {
+			continue
+		}
+		rel := r.Relation()
+		for _, ref := range rel.Refs {
+			if ref.Type == 1 && changedWays[ref.Id] {
+				changed[rel.Id] = true
+			} else if ref.Type == 2 {
+				subRelations[rel.Id] = append(subRelations[rel.Id], ref.Id)
+			}
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	for {
+		added := 0
+		for id, subs := range subRelations {
+			if changed[id] {
+				continue
+			}
+			for _, sub := range subs {
+				if changed[sub] {
+					changed[id] = true
+					added++
+					break
+				}
+			}
+		}
+		if added == 0 {
+			break
+		}
+	}
+	existing, err := db.ListLocationIds()
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	for id := range cs.Relations {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if cs.Relations[id] != ChangeDelete {
+			continue
+		}
+		if existing[id] {
+			if err := db.DeleteLocation(id); err != nil {
+				return nil, err
+			}
+			stats.DeletedLocations++
+		}
+		if err := db.DeleteRelation(id); err != nil {
+			return nil, err
+		}
+		stats.DeletedRelations++
+	}
+
+	// Update stored sub-relations first, then rebuild locations
+	err = r.SeekToKind(RelationKind)
+	if err != nil {
+		return nil, err
+	}
+	rebuilt := []*Relation{}
+	for r.Next() {
+		if r.Kind() != RelationKind {
+			continue
+		}
+		rel := r.Relation()
+		if !changed[rel.Id] {
+			continue
+		}
+		if cs.Relations[rel.Id] != 0 {
+			stored, err := db.GetRelation(rel.Id)
+			if err != nil {
+				return nil, err
+			}
+			if stored != nil {
+				if err := db.PutRelation(rel); err != nil {
+					return nil, err
+				}
+				stats.Relations++
+			}
+		}
+		if existing[rel.Id] || cs.Relations[rel.Id] == ChangeCreate {
+			rebuilt = append(rebuilt, rel.Clone())
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	for _, rel := range rebuilt {
+		if existing[rel.Id] {
+			if err := db.DeleteLocation(rel.Id); err != nil {
+				return nil, err
+			}
+		}
+		loc, err := buildLocation(rel, db)
+		if err != nil {
+			err = elementError(relationElement(rel), "cannot build location",
+				err, relationAttr(rel))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if loc != nil {
+			stats.Locations++
+		} else if existing[rel.Id] {
+			stats.DeletedLocations++
+		}
+	}
+	if stats.Locations > 0 || stats.DeletedLocations > 0 {
+		if _, err := db.BuildSpatialIndex(); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
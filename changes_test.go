@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+const testChangeOsm = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6" generator="test">
+ <node id="1" lat="0" lon="0"/>
+ <node id="2" lat="0" lon="1"/>
+ <node id="3" lat="%s" lon="1"/>
+ <node id="4" lat="1" lon="0"/>
+ <node id="5" lat="5" lon="5"/>
+ <node id="6" lat="5" lon="6"/>
+ <node id="7" lat="6" lon="6"/>
+ <way id="10">
+  <nd ref="1"/><nd ref="2"/><nd ref="3"/><nd ref="4"/><nd ref="1"/>
+ </way>
+ <way id="11">
+  <nd ref="5"/><nd ref="6"/><nd ref="7"/><nd ref="5"/>
+ </way>
+ <relation id="100">
+  <member type="way" ref="10" role="outer"/>
+  <tag k="type" v="boundary"/>
+  <tag k="boundary" v="administrative"/>
+  <tag k="admin_level" v="8"/>
+  <tag k="name" v="square"/>
+ </relation>
+ <relation id="101">
+  <member type="way" ref="11" role="outer"/>
+  <tag k="type" v="boundary"/>
+  <tag k="boundary" v="administrative"/>
+  <tag k="admin_level" v="8"/>
+  <tag k="name" v="triangle"/>
+ </relation>
+</osm>
+`
+
+const testOsc = `<?xml version="1.0" encoding="UTF-8"?>
+<osmChange version="0.6" generator="test">
+ <modify>
+  <node id="3" lat="2" lon="1" version="2"/>
+ </modify>
+ <delete>
+  <relation id="102" version="3"/>
+ </delete>
+</osmChange>
+`
+
+func TestOSCReader(t *testing.T) {
+	path := writeTestXml(t, testOsc)
+	defer os.Remove(path)
+	r, err := NewOSCReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cs, err := readChangeSet(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cs.Nodes, map[int64]int{3: ChangeModify}) ||
+		len(cs.Ways) != 0 ||
+		!reflect.DeepEqual(cs.Relations, map[int64]int{102: ChangeDelete}) {
+		t.Fatalf("unexpected change set: %+v", cs)
+	}
+
+	path = writeTestXml(t, `<osmChange><node id="1" lat="0" lon="0"/></osmChange>`)
+	defer os.Remove(path)
+	r, err = NewOSCReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := readChangeSet(r); err == nil {
+		t.Fatalf("element outside of a change block was accepted")
+	}
+}
+
+func TestO5CReader(t *testing.T) {
+	dataset := func(kind int, data []byte) []byte {
+		return append(o5m.AppendUnsigned([]byte{byte(kind)}, uint64(len(data))),
+			data...)
+	}
+	buf := []byte{0xff, 0xe0, 0x04, 'o', '5', 'c', '2'}
+	// Modified node 3, version 2, without timestamp
+	data := o5m.AppendSigned(nil, 3)
+	data = o5m.AppendUnsigned(data, 2)
+	data = o5m.AppendSigned(data, 0)
+	data = o5m.AppendSigned(data, 1e7)
+	data = o5m.AppendSigned(data, 2e7)
+	buf = append(buf, dataset(NodeKind, data)...)
+	// Deleted node 4
+	data = o5m.AppendSigned(nil, 1)
+	data = o5m.AppendUnsigned(data, 3)
+	data = o5m.AppendSigned(data, 0)
+	buf = append(buf, dataset(NodeKind, data)...)
+	// Created way 12
+	buf = append(buf, byte(ResetKind))
+	data = o5m.AppendSigned(nil, 12)
+	data = o5m.AppendUnsigned(data, 1)
+	data = o5m.AppendSigned(data, 0)
+	refs := o5m.AppendSigned(o5m.AppendSigned(nil, 1), 1)
+	data = o5m.AppendUnsigned(data, uint64(len(refs)))
+	data = append(data, refs...)
+	buf = append(buf, dataset(WayKind, data)...)
+	// Deleted relation 102
+	buf = append(buf, byte(ResetKind))
+	data = o5m.AppendSigned(nil, 102)
+	data = o5m.AppendUnsigned(data, 3)
+	data = o5m.AppendSigned(data, 0)
+	buf = append(buf, dataset(RelationKind, data)...)
+	buf = append(buf, byte(EndKind))
+
+	fp, err := ioutil.TempFile("", "osm-*.o5c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	_, err = fp.Write(buf)
+	fp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenChangeReader(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	cs, err := readChangeSet(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cs.Nodes, map[int64]int{3: ChangeModify, 4: ChangeDelete}) ||
+		!reflect.DeepEqual(cs.Ways, map[int64]int{12: ChangeCreate}) ||
+		!reflect.DeepEqual(cs.Relations, map[int64]int{102: ChangeDelete}) {
+		t.Fatalf("unexpected change set: %+v", cs)
+	}
+
+	// o5m files are not change files
+	path := writeTestFile(t, []Node{{Id: 1}}, nil, nil)
+	defer os.Remove(path)
+	if _, err := NewO5CReader(path); err == nil {
+		t.Fatalf("o5m file was accepted as o5c")
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+
+	build := func(path string) {
+		r, err := OpenOSMReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		nodes, err := buildNodeArray(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := indexWays(r, nodes, db, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SeekToKind(RelationKind); err != nil {
+			t.Fatal(err)
+		}
+		for r.Next() {
+			if r.Kind() != RelationKind {
+				continue
+			}
+			if _, err := buildLocation(r.Relation(), db); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	original := writeTestXml(t, fmt.Sprintf(testChangeOsm, "1"))
+	defer os.Remove(original)
+	build(original)
+	// A deleted relation
+	err := db.PutLocation(102, &Location{Type: "MultiPolygon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := writeTestXml(t, fmt.Sprintf(testChangeOsm, "2"))
+	defer os.Remove(updated)
+	osc := writeTestXml(t, testOsc)
+	defer os.Remove(osc)
+	cr, err := NewOSCReader(osc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close()
+	cs, err := readChangeSet(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenOSMReader(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stats, err := applyChanges(cs, r, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ChangeStats{Ways: 1, DeletedRelations: 1, Locations: 1,
+		DeletedLocations: 1}
+	if *stats != expected {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	w, err := db.Get(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Points[2] != (Point{1e7, 2e7}) {
+		t.Fatalf("way was not updated: %v", w.Points)
+	}
+	ids, err := db.GetLocationsContaining(0.9, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{100}) {
+		t.Fatalf("location was not rebuilt: %v", ids)
+	}
+	if loc, err := db.GetLocation(102); err != nil || loc != nil {
+		t.Fatalf("deleted relation location was kept: %v", err)
+	}
+}
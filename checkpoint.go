@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+var (
+	checkpointsBucket = []byte("checkpoints")
+)
+
+// Checkpoint records the progress of an indexing command: Id is the last
+// processed element of Kind, which follows the reset point at Offset. Index
+// and Section complete the reset point of PBF files.
+type Checkpoint struct {
+	Kind    int   `json:"kind"`
+	Id      int64 `json:"id"`
+	Offset  int   `json:"offset"`
+	Index   int   `json:"index"`
+	Section int   `json:"section"`
+}
+
+func (c *Checkpoint) ResetPoint() ResetPoint {
+	return ResetPoint{
+		Offset:  c.Offset,
+		Index:   c.Index,
+		Section: c.Section,
+	}
+}
+
+func (db *WaysDb) GetCheckpoint(name string) (*Checkpoint, error) {
+	var cp *Checkpoint
+	err := db.db.View(func(tx kvTx) error {
+		data, err := tx.Get(checkpointsBucket, []byte(name))
+		if data == nil || err != nil {
+			return err
+		}
+		cp = &Checkpoint{}
+		return json.Unmarshal(data, cp)
+	})
+	return cp, err
+}
+
+func (db *WaysDb) PutCheckpoint(name string, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return db.db.Update(func(tx kvTx) error {
+		return tx.Put(checkpointsBucket, []byte(name), data)
+	})
+}
+
+func (db *WaysDb) DeleteCheckpoint(name string) error {
+	return db.db.Update(func(tx kvTx) error {
+		return tx.Delete(checkpointsBucket, []byte(name))
+	})
+}
+
+// PutCheckpoint stores cp along with the batched entries, so it is committed
+// with them by the next flush.
+func (b *WaysBatch) PutCheckpoint(name string, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return b.putKey(checkpointsBucket, []byte(name), data)
+}
+
+// checkpointTracker follows the reset points and elements of kind returned by
+// a reader to build checkpoints. When resuming, it skips the elements up to
+// the checkpoint one.
+type checkpointTracker struct {
+	kind   int
+	reset  ResetPoint
+	resume *Checkpoint
+}
+
+// Creates a tracker for elements of kind and moves r to the reset point of
+// resume, if not nil.
+func newCheckpointTracker(r OSMReader, kind int, resume *Checkpoint) (
+	*checkpointTracker, error) {
+
+	if resume != nil {
+		if resume.Kind != kind {
+			return nil, fmt.Errorf("checkpoint kind mismatch: %x != %x",
+				resume.Kind, kind)
+		}
+		err := r.Seek(resume.ResetPoint())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &checkpointTracker{
+		kind:   kind,
+		resume: resume,
+	}, nil
+}
+
+func getElementId(r OSMReader) int64 {
+	switch r.Kind() {
+	case NodeKind:
+		return r.Node().Id
+	case WayKind:
+		return r.Way().Id
+	case RelationKind:
+		return r.Relation().Id
+	}
+	return 0
+}
+
+// Returns true if the current element of r is of the tracked kind and was not
+// processed before the resumed checkpoint.
+func (t *checkpointTracker) Next(r OSMReader) bool {
+	switch r.Kind() {
+	case ResetKind:
+		t.reset = r.ResetPoint()
+		return false
+	case t.kind:
+	default:
+		return false
+	}
+	if t.resume != nil {
+		if getElementId(r) == t.resume.Id {
+			t.resume = nil
+		}
+		return false
+	}
+	return true
+}
+
+// Returns an error if the resumed checkpoint element was not found.
+func (t *checkpointTracker) Err() error {
+	if t.resume != nil {
+		return fmt.Errorf("checkpoint element %d not found", t.resume.Id)
+	}
+	return nil
+}
+
+// Returns the checkpoint of element id, the current one.
+func (t *checkpointTracker) Checkpoint(id int64) *Checkpoint {
+	return &Checkpoint{
+		Kind:    t.kind,
+		Id:      id,
+		Offset:  t.reset.Offset,
+		Index:   t.reset.Index,
+		Section: t.reset.Section,
+	}
+}
+
+// checkpointQueue orders the checkpoints of elements processed concurrently.
+// Last returns the checkpoint of the last element whose predecessors are all
+// done.
+type checkpointQueue struct {
+	lock    sync.Mutex
+	pending []*Checkpoint
+	done    map[int]bool
+	first   int
+	last    *Checkpoint
+}
+
+func newCheckpointQueue() *checkpointQueue {
+	return &checkpointQueue{
+		done: map[int]bool{},
+	}
+}
+
+// Adds the checkpoint of an element about to be processed and returns its
+// sequence number.
+func (q *checkpointQueue) Add(cp *Checkpoint) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.pending = append(q.pending, cp)
+	return q.first + len(q.pending) - 1
+}
+
+func (q *checkpointQueue) Done(seq int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.done[seq] = true
+	for len(q.pending) > 0 && q.done[q.first] {
+		delete(q.done, q.first)
+		q.last = q.pending[0]
+		q.pending = q.pending[1:]
+		q.first++
+	}
+}
+
+func (q *checkpointQueue) Last() *Checkpoint {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.last
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheckpointQueue(t *testing.T) {
+	q := newCheckpointQueue()
+	seqs := []int{}
+	for id := int64(1); id <= 3; id++ {
+		seqs = append(seqs, q.Add(&Checkpoint{Id: id}))
+	}
+	q.Done(seqs[1])
+	if q.Last() != nil {
+		t.Fatalf("checkpoint set before first element is done: %+v", q.Last())
+	}
+	q.Done(seqs[0])
+	if cp := q.Last(); cp == nil || cp.Id != 2 {
+		t.Fatalf("unexpected checkpoint: %+v", cp)
+	}
+	q.Done(seqs[2])
+	if cp := q.Last(); cp == nil || cp.Id != 3 {
+		t.Fatalf("unexpected checkpoint: %+v", cp)
+	}
+	if seq := q.Add(&Checkpoint{Id: 4}); seq != 3 {
+		t.Fatalf("unexpected sequence number: %d", seq)
+	}
+}
+
+func TestIndexWaysResume(t *testing.T) {
+	nodes := []Node{
+		{Id: 1, Lon: 0, Lat: 0},
+		{Id: 2, Lon: 1e7, Lat: 0},
+		{Id: 3, Lon: 1e7, Lat: 1e7},
+	}
+	ways := []Way{
+		{Id: 10, Nodes: []int64{1, 2}},
+		{Id: 11, Nodes: []int64{2, 3}},
+		{Id: 12, Nodes: []int64{3, 1}},
+	}
+	path := writeTestFile(t, nodes, ways, nil)
+	defer os.Remove(path)
+
+	// Checkpoint of the second way, as an interrupted run would store it
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	tracker, err := newCheckpointTracker(r, WayKind, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cp *Checkpoint
+	for r.Next() {
+		if tracker.Next(r) && r.Way().Id == 11 {
+			cp = tracker.Checkpoint(11)
+		}
+	}
+	if cp == nil {
+		t.Fatalf("way checkpoint not found")
+	}
+
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+	if err := db.PutCheckpoint(indexWaysCheckpoint, cp); err != nil {
+		t.Fatal(err)
+	}
+	resume, err := db.GetCheckpoint(indexWaysCheckpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resume == nil || *resume != *cp {
+		t.Fatalf("unexpected stored checkpoint: %+v", resume)
+	}
+	if err := r.SeekToKind(NodeKind); err != nil {
+		t.Fatal(err)
+	}
+	points, err := buildNodeArray(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := indexWays(r, points, db, resume); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range ways {
+		line, err := db.Get(w.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (line != nil) != (w.Id == 12) {
+			t.Fatalf("unexpected way %d: %v", w.Id, line)
+		}
+	}
+	if cp, err := db.GetCheckpoint(indexWaysCheckpoint); err != nil || cp != nil {
+		t.Fatalf("checkpoint was not deleted: %+v, %v", cp, err)
+	}
+
+	// Resuming from an unknown element fails
+	err = indexWays(r, points, db, &Checkpoint{Kind: WayKind, Id: 13,
+		Offset: cp.Offset})
+	if err == nil {
+		t.Fatalf("unknown checkpoint element was accepted")
+	}
+}
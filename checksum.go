@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// ContentHasher computes a digest of OSM elements semantic content: ids,
+// coordinates, way nodes, relation members and tags. Metadata and encoding
+// details like string table references or tag order are ignored, so two
+// files with the same elements hash the same.
+type ContentHasher struct {
+	h    hash.Hash
+	buf  []byte
+	tags []StringPair
+}
+
+func NewContentHasher() *ContentHasher {
+	return &ContentHasher{
+		h:   sha256.New(),
+		buf: make([]byte, binary.MaxVarintLen64),
+	}
+}
+
+func (c *ContentHasher) writeInt(n int64) {
+	l := binary.PutVarint(c.buf, n)
+	c.h.Write(c.buf[:l])
+}
+
+func (c *ContentHasher) writeString(s string) {
+	c.writeInt(int64(len(s)))
+	c.h.Write([]byte(s))
+}
+
+func (c *ContentHasher) writeTags(tags []StringPair) {
+	c.tags = append(c.tags[:0], tags...)
+	sort.Slice(c.tags, func(i, j int) bool {
+		if c.tags[i].Key != c.tags[j].Key {
+			return c.tags[i].Key < c.tags[j].Key
+		}
+		return c.tags[i].Value < c.tags[j].Value
+	})
+	c.writeInt(int64(len(c.tags)))
+	for _, tag := range c.tags {
+		c.writeString(tag.Key)
+		c.writeString(tag.Value)
+	}
+}
+
+func (c *ContentHasher) AddNode(n *Node) {
+	c.writeInt(int64(NodeKind))
+	c.writeInt(n.Id)
+	c.writeInt(n.Lon)
+	c.writeInt(n.Lat)
+	c.writeTags(n.Tags)
+}
+
+func (c *ContentHasher) AddWay(w *Way) {
+	c.writeInt(int64(WayKind))
+	c.writeInt(w.Id)
+	c.writeInt(int64(len(w.Nodes)))
+	for _, id := range w.Nodes {
+		c.writeInt(id)
+	}
+	c.writeTags(w.Tags)
+}
+
+func (c *ContentHasher) AddRelation(r *Relation) {
+	c.writeInt(int64(RelationKind))
+	c.writeInt(r.Id)
+	c.writeInt(int64(len(r.Refs)))
+	for _, ref := range r.Refs {
+		c.writeInt(ref.Id)
+		c.writeInt(int64(ref.Type))
+		c.writeString(ref.Role)
+	}
+	c.writeTags(r.Tags)
+}
+
+// AddLinestring adds a way geometry as stored in a WaysDb.
+func (c *ContentHasher) AddLinestring(ls *Linestring) {
+	c.writeInt(int64(WayKind))
+	c.writeInt(ls.Id)
+	c.writeInt(int64(len(ls.Points)))
+	for _, p := range ls.Points {
+		c.writeInt(p.Lon)
+		c.writeInt(p.Lat)
+	}
+}
+
+// Returns the hexadecimal digest of elements added so far.
+func (c *ContentHasher) Sum() string {
+	return hex.EncodeToString(c.h.Sum(nil))
+}
+
+// FileStats summarizes the content of an o5m file.
+type FileStats struct {
+	Nodes     int
+	Ways      int
+	Relations int
+	Tags      int
+	Checksum  string
+}
+
+func (s *FileStats) String() string {
+	return fmt.Sprintf("nodes=%d ways=%d relations=%d tags=%d checksum=%s",
+		s.Nodes, s.Ways, s.Relations, s.Tags, s.Checksum)
+}
+
+// Reads the file at path and returns its statistics. If w is not nil, every
+// element is written to it as well.
+func computeFileStats(path string, w *O5MWriter) (*FileStats, error) {
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	h := NewContentHasher()
+	stats := &FileStats{}
+	for r.Next() {
+		switch r.Kind() {
+		case BBoxKind:
+			if w != nil {
+				err = w.WriteBoundingBox(r.BoundingBox())
+			}
+		case NodeKind:
+			n := r.Node()
+			h.AddNode(n)
+			stats.Nodes++
+			stats.Tags += len(n.Tags)
+			if w != nil {
+				err = w.WriteNode(n)
+			}
+		case WayKind:
+			way := r.Way()
+			h.AddWay(way)
+			stats.Ways++
+			stats.Tags += len(way.Tags)
+			if w != nil {
+				err = w.WriteWay(way)
+			}
+		case RelationKind:
+			rel := r.Relation()
+			h.AddRelation(rel)
+			stats.Relations++
+			stats.Tags += len(rel.Tags)
+			if w != nil {
+				err = w.WriteRelation(rel)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	stats.Checksum = h.Sum()
+	return stats, nil
+}
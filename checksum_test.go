@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestContentHasher(t *testing.T) {
+	hash := func(tags []StringPair, lon int64) string {
+		c := NewContentHasher()
+		c.AddNode(&Node{Id: 1, Lon: lon, Lat: 2, Tags: tags,
+			Meta: Metadata{Version: int(lon)}})
+		c.AddWay(&Way{Id: 2, Nodes: []int64{1, 3}})
+		c.AddRelation(&Relation{Id: 3, Refs: []Ref{{Id: 2, Type: 1, Role: "outer"}}})
+		return c.Sum()
+	}
+	h1 := hash([]StringPair{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}, 1)
+	h2 := hash([]StringPair{{Key: "b", Value: "2"}, {Key: "a", Value: "1"}}, 1)
+	if h1 != h2 {
+		t.Fatalf("tag order changed the checksum")
+	}
+	if h1 == hash([]StringPair{{Key: "a", Value: "1"}, {Key: "b", Value: "3"}}, 1) {
+		t.Fatalf("tag values did not change the checksum")
+	}
+	if h1 == hash([]StringPair{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}, 2) {
+		t.Fatalf("coordinates did not change the checksum")
+	}
+	// Strings are length prefixed
+	if hash([]StringPair{{Key: "ab", Value: ""}}, 1) == hash([]StringPair{{Key: "a", Value: "b"}}, 1) {
+		t.Fatalf("tag boundaries are ambiguous")
+	}
+}
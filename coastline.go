@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+var (
+	// Land areas country polygons are clipped against, nil to keep them as
+	// they are.
+	landPolygons *LandPolygons
+)
+
+// LandPolygons are land areas built from OSM coastlines, like the land
+// polygons published by osmdata.openstreetmap.de converted to GeoJSON.
+// Countries including their territorial waters are intersected with them to
+// get their land mass, which their land-only relations, when they exist, are
+// often too broken to provide.
+type LandPolygons struct {
+	polygons []Geometry
+	bboxes   []*BBox
+}
+
+func jsonRingToCoords(ring [][]float64) ([]Coord, error) {
+	coords := make([]Coord, len(ring))
+	for i, p := range ring {
+		if len(p) < 2 {
+			return nil, fmt.Errorf("invalid position: %v", p)
+		}
+		coords[i] = Coord{X: p[0], Y: p[1]}
+	}
+	return coords, nil
+}
+
+func (l *LandPolygons) add(rings [][][]float64) error {
+	if len(rings) == 0 {
+		return nil
+	}
+	shell, err := jsonRingToCoords(rings[0])
+	if err != nil {
+		return err
+	}
+	holes := make([][]Coord, 0, len(rings)-1)
+	for _, ring := range rings[1:] {
+		hole, err := jsonRingToCoords(ring)
+		if err != nil {
+			return err
+		}
+		holes = append(holes, hole)
+	}
+	g, err := newPolygon(shell, holes...)
+	if err != nil {
+		return err
+	}
+	bbox := NewBBox()
+	for _, c := range shell {
+		bbox.Add(c.X, c.Y)
+	}
+	l.polygons = append(l.polygons, g)
+	l.bboxes = append(l.bboxes, bbox)
+	return nil
+}
+
+// Reads a GeoJSON feature collection of polygons and multipolygons. path can
+// be compressed or a http(s) URL.
+func loadLandPolygons(path string) (*LandPolygons, error) {
+	in, err := o5m.OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	collection := struct {
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}{}
+	err = json.NewDecoder(in).Decode(&collection)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode land polygons: %s", err)
+	}
+	l := &LandPolygons{}
+	for i, f := range collection.Features {
+		var err error
+		switch f.Geometry.Type {
+		case "Polygon":
+			rings := [][][]float64{}
+			err = json.Unmarshal(f.Geometry.Coordinates, &rings)
+			if err == nil {
+				err = l.add(rings)
+			}
+		case "MultiPolygon":
+			polygons := [][][][]float64{}
+			err = json.Unmarshal(f.Geometry.Coordinates, &polygons)
+			for _, rings := range polygons {
+				if err != nil {
+					break
+				}
+				err = l.add(rings)
+			}
+		default:
+			err = fmt.Errorf("unsupported geometry type: %s", f.Geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid land polygon %d: %s", i, err)
+		}
+	}
+	return l, nil
+}
+
+func (l *LandPolygons) Len() int {
+	return len(l.polygons)
+}
+
+// Clip intersects polygons with the land areas. Land polygons are usually
+// split in tiles, so pieces are merged back. It returns no polygon if none
+// overlaps land.
+func (l *LandPolygons) Clip(polygons []Geometry) ([]Geometry, error) {
+	pieces := []Geometry{}
+	for _, g := range polygons {
+		shell, _, err := g.Rings()
+		if err != nil {
+			return nil, err
+		}
+		bbox := NewBBox()
+		for _, c := range shell {
+			bbox.Add(c.X, c.Y)
+		}
+		for i, land := range l.polygons {
+			if !bboxesIntersect(bbox, l.bboxes[i]) {
+				continue
+			}
+			inter, err := g.Intersection(land)
+			if err != nil {
+				return nil, err
+			}
+			parts, err := inter.Polygons()
+			if err != nil {
+				return nil, err
+			}
+			pieces = append(pieces, parts...)
+		}
+	}
+	if len(pieces) <= 1 {
+		return pieces, nil
+	}
+	merged, err := unaryUnion(pieces)
+	if err != nil {
+		return nil, err
+	}
+	return merged.Polygons()
+}
+
+// Clips country polygons against landPolygons, if set. Polygons are kept as
+// they are when they do not overlap land at all, which usually means the
+// land polygons do not cover the relation area.
+func clipCountryToLand(rel *Relation, polygons []Geometry) ([]Geometry, error) {
+	if landPolygons == nil {
+		return polygons, nil
+	}
+	rt, err := NewRelationTags(rel)
+	if err != nil {
+		return nil, err
+	}
+	if level, _ := rt.AdminLevel(); level != 2 {
+		return polygons, nil
+	}
+	clipped, err := landPolygons.Clip(polygons)
+	if err != nil {
+		return nil, fmt.Errorf("cannot clip to land: %s", err)
+	}
+	if len(clipped) == 0 {
+		slog.Warn("country does not overlap land polygons", relationAttr(rel))
+		return polygons, nil
+	}
+	return clipped, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestClipCountryToLand(t *testing.T) {
+	defer func() {
+		landPolygons = nil
+	}()
+	fp, err := ioutil.TempFile("", "osm-land-*.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	// Two adjacent land tiles
+	_, err = fp.WriteString(`{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{},"geometry":{"type":"Polygon",
+"coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+{"type":"Feature","properties":{},"geometry":{"type":"MultiPolygon",
+"coordinates":[[[[1,0],[2,0],[2,1],[1,1],[1,0]]]]}}
+]}`)
+	fp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	land, err := loadLandPolygons(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if land.Len() != 2 {
+		t.Fatalf("unexpected land polygons: %d", land.Len())
+	}
+	landPolygons = land
+
+	square := func(x, y float64) Geometry {
+		g, err := newPolygon([]Coord{
+			{X: x, Y: y}, {X: x + 1, Y: y}, {X: x + 1, Y: y + 1},
+			{X: x, Y: y + 1}, {X: x, Y: y},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	country := &Relation{Id: 1, Tags: []StringPair{
+		{Key: "admin_level", Value: "2"},
+	}}
+	polygons, err := clipCountryToLand(country, []Geometry{square(0.5, -0.5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(polygons) != 1 {
+		t.Fatalf("tile pieces were not merged: %d", len(polygons))
+	}
+	area, err := polygons[0].Area()
+	if err != nil || math.Abs(area-0.5) > 1e-9 {
+		t.Fatalf("unexpected clipped area: %f, %v", area, err)
+	}
+
+	// Countries at sea and other levels are kept as they are
+	polygons, err = clipCountryToLand(country, []Geometry{square(5, 5)})
+	if err != nil || len(polygons) != 1 {
+		t.Fatalf("unexpected sea polygons: %v, %v", polygons, err)
+	}
+	region := &Relation{Id: 2, Tags: []StringPair{
+		{Key: "admin_level", Value: "4"},
+	}}
+	polygons, err = clipCountryToLand(region, []Geometry{square(0.5, -0.5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if area, _ := polygons[0].Area(); math.Abs(area-1) > 1e-9 {
+		t.Fatalf("region was clipped: %f", area)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// WaysDb values used to be stored as JSON. They are now encoded in a compact
+// binary format starting with a version byte, which cannot be confused with
+// the first byte of a JSON document. Decoders accept both so existing
+// databases remain readable, migratedb rewrites them in binary format.
+const (
+	codecVersion1 = 0x01
+)
+
+func isJsonValue(data []byte) bool {
+	return len(data) > 0 && (data[0] == '{' || data[0] == '[' || data[0] == 'n')
+}
+
+func appendCodecString(buf []byte, s string) []byte {
+	buf = o5m.AppendUnsigned(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendCodecFloat(buf []byte, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+}
+
+// Coordinates are stored as o5m fixed point values, 1e-7 degree is the
+// precision of OSM data.
+func quantizeCoord(v float64) int64 {
+	return int64(math.Round(v * 1e7))
+}
+
+type codecDecoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// Returns a decoder positioned after the version byte of data.
+func newCodecDecoder(data []byte) (*codecDecoder, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	if data[0] != codecVersion1 {
+		return nil, fmt.Errorf("unsupported value format: %d", data[0])
+	}
+	return &codecDecoder{data: data, pos: 1}, nil
+}
+
+func (d *codecDecoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.pos = len(d.data)
+}
+
+func (d *codecDecoder) Unsigned() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail(fmt.Errorf("invalid varint at %d", d.pos))
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+// Inverse of o5m.AppendSigned.
+func (d *codecDecoder) Signed() int64 {
+	u := d.Unsigned()
+	if u&1 != 0 {
+		return -int64(u>>1) - 1
+	}
+	return int64(u >> 1)
+}
+
+// Returns a count of items, each taking at least one byte.
+func (d *codecDecoder) Count() int {
+	n := d.Unsigned()
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail(fmt.Errorf("invalid count at %d: %d", d.pos, n))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *codecDecoder) String() string {
+	n := d.Count()
+	s := string(d.data[d.pos : d.pos+n])
+	d.pos += n
+	return s
+}
+
+func (d *codecDecoder) Float() float64 {
+	if len(d.data)-d.pos < 8 {
+		d.fail(fmt.Errorf("truncated float at %d", d.pos))
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.data[d.pos:]))
+	d.pos += 8
+	return v
+}
+
+// Returns the decoding error, or an error if data was not fully consumed.
+func (d *codecDecoder) Close() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("%d trailing bytes", len(d.data)-d.pos)
+	}
+	return nil
+}
+
+func encodeLinestring(ls *Linestring) []byte {
+	buf := []byte{codecVersion1}
+	buf = o5m.AppendSigned(buf, ls.Id)
+	buf = appendCodecString(buf, ls.Role)
+	buf = o5m.AppendUnsigned(buf, uint64(len(ls.Points)))
+	prev := Point{}
+	for _, p := range ls.Points {
+		buf = o5m.AppendSigned(buf, p.Lon-prev.Lon)
+		buf = o5m.AppendSigned(buf, p.Lat-prev.Lat)
+		prev = p
+	}
+	return buf
+}
+
+func decodeLinestring(data []byte, ls *Linestring) error {
+	if isJsonValue(data) {
+		return json.Unmarshal(data, ls)
+	}
+	d, err := newCodecDecoder(data)
+	if err != nil {
+		return err
+	}
+	ls.Id = d.Signed()
+	ls.Role = d.String()
+	n := d.Count()
+	ls.Points = nil
+	if n > 0 {
+		ls.Points = make([]Point, 0, n)
+	}
+	prev := Point{}
+	for i := 0; i < n; i++ {
+		prev.Lon += d.Signed()
+		prev.Lat += d.Signed()
+		ls.Points = append(ls.Points, prev)
+	}
+	return d.Close()
+}
+
+func encodeRelation(r *Relation) []byte {
+	buf := []byte{codecVersion1}
+	buf = o5m.AppendSigned(buf, r.Id)
+	buf = o5m.AppendSigned(buf, int64(r.Meta.Version))
+	buf = o5m.AppendSigned(buf, int64(r.Meta.Timestamp))
+	buf = o5m.AppendSigned(buf, int64(r.Meta.Changeset))
+	buf = appendCodecString(buf, r.Meta.Uid)
+	buf = appendCodecString(buf, r.Meta.Author)
+	buf = o5m.AppendUnsigned(buf, uint64(len(r.Refs)))
+	prev := int64(0)
+	for _, ref := range r.Refs {
+		buf = o5m.AppendSigned(buf, int64(ref.Type))
+		buf = o5m.AppendSigned(buf, ref.Id-prev)
+		buf = appendCodecString(buf, ref.Role)
+		prev = ref.Id
+	}
+	buf = o5m.AppendUnsigned(buf, uint64(len(r.Tags)))
+	for _, tag := range r.Tags {
+		buf = appendCodecString(buf, tag.Key)
+		buf = appendCodecString(buf, tag.Value)
+	}
+	return buf
+}
+
+func decodeRelation(data []byte, r *Relation) error {
+	if isJsonValue(data) {
+		return json.Unmarshal(data, r)
+	}
+	d, err := newCodecDecoder(data)
+	if err != nil {
+		return err
+	}
+	r.Id = d.Signed()
+	r.Meta.Version = int(d.Signed())
+	r.Meta.Timestamp = int(d.Signed())
+	r.Meta.Changeset = int(d.Signed())
+	r.Meta.Uid = d.String()
+	r.Meta.Author = d.String()
+	n := d.Count()
+	r.Refs = nil
+	if n > 0 {
+		r.Refs = make([]Ref, 0, n)
+	}
+	prev := int64(0)
+	for i := 0; i < n; i++ {
+		typ := int(d.Signed())
+		prev += d.Signed()
+		r.Refs = append(r.Refs, Ref{
+			Id:   prev,
+			Type: typ,
+			Role: d.String(),
+		})
+	}
+	n = d.Count()
+	r.Tags = nil
+	if n > 0 {
+		r.Tags = make([]StringPair, 0, n)
+	}
+	for i := 0; i < n; i++ {
+		k := d.String()
+		v := d.String()
+		r.Tags = append(r.Tags, StringPair{
+			Key:   k,
+			Value: v,
+		})
+	}
+	return d.Close()
+}
+
+// Encodes loc with coordinates rounded to 1e-7 degree and delta encoded
+// across the whole location, followed by its measures. Locations with other than 2D points, which
+// geos does not produce, are stored as JSON.
+func encodeLocation(loc *Location) ([]byte, error) {
+	buf := []byte{codecVersion1}
+	buf = appendCodecString(buf, loc.Type)
+	buf = o5m.AppendUnsigned(buf, uint64(len(loc.Coordinates)))
+	prevLon, prevLat := int64(0), int64(0)
+	for _, poly := range loc.Coordinates {
+		buf = o5m.AppendUnsigned(buf, uint64(len(poly)))
+		for _, ring := range poly {
+			buf = o5m.AppendUnsigned(buf, uint64(len(ring)))
+			for _, p := range ring {
+				if len(p) != 2 {
+					return json.Marshal(loc)
+				}
+				lon, lat := quantizeCoord(p[0]), quantizeCoord(p[1])
+				buf = o5m.AppendSigned(buf, lon-prevLon)
+				buf = o5m.AppendSigned(buf, lat-prevLat)
+				prevLon, prevLat = lon, lat
+			}
+		}
+	}
+	buf = appendCodecFloat(buf, loc.AreaKm2)
+	return appendCodecFloat(buf, loc.PerimeterKm), nil
+}
+
+func decodeLocation(data []byte, loc *Location) error {
+	if isJsonValue(data) {
+		return json.Unmarshal(data, loc)
+	}
+	d, err := newCodecDecoder(data)
+	if err != nil {
+		return err
+	}
+	loc.Type = d.String()
+	loc.Coordinates = make([][][][]float64, d.Count())
+	lon, lat := int64(0), int64(0)
+	for i := range loc.Coordinates {
+		poly := make([][][]float64, d.Count())
+		for j := range poly {
+			ring := make([][]float64, d.Count())
+			for k := range ring {
+				lon += d.Signed()
+				lat += d.Signed()
+				ring[k] = []float64{float64(lon) / 1e7, float64(lat) / 1e7}
+			}
+			poly[j] = ring
+		}
+		loc.Coordinates[i] = poly
+	}
+	if d.err == nil && d.pos < len(d.data) {
+		// Measures were added later, older values end with coordinates
+		loc.AreaKm2 = d.Float()
+		loc.PerimeterKm = d.Float()
+	}
+	return d.Close()
+}
+
+// Centroids are computed points, they are stored exactly.
+func encodeCentroid(c *Centroid) []byte {
+	buf := make([]byte, 17, 27)
+	buf[0] = codecVersion1
+	binary.LittleEndian.PutUint64(buf[1:], math.Float64bits(c.Lon))
+	binary.LittleEndian.PutUint64(buf[9:], math.Float64bits(c.Lat))
+	buf = o5m.AppendSigned(buf, c.NodeId)
+	return appendCodecString(buf, c.Source)
+}
+
+func decodeCentroid(data []byte, c *Centroid) error {
+	if isJsonValue(data) {
+		return json.Unmarshal(data, c)
+	}
+	d, err := newCodecDecoder(data)
+	if err != nil {
+		return err
+	}
+	c.Lon = d.Float()
+	c.Lat = d.Float()
+	c.NodeId = d.Signed()
+	if d.err == nil && d.pos < len(d.data) {
+		// Sources were added later
+		c.Source = d.String()
+	}
+	return d.Close()
+}
+
+// Returns a function rewriting values of bucket in binary format, or nil if
+// bucket values are still stored as JSON.
+func getBucketMigration(bucket string) func(data []byte) ([]byte, error) {
+	switch bucket {
+	case string(waysBucket):
+		return func(data []byte) ([]byte, error) {
+			ls := &Linestring{}
+			err := decodeLinestring(data, ls)
+			return encodeLinestring(ls), err
+		}
+	case string(relationsBucket):
+		return func(data []byte) ([]byte, error) {
+			r := &Relation{}
+			err := decodeRelation(data, r)
+			return encodeRelation(r), err
+		}
+	case string(locationsBucket):
+		return func(data []byte) ([]byte, error) {
+			loc := &Location{}
+			err := decodeLocation(data, loc)
+			if err != nil {
+				return nil, err
+			}
+			return encodeLocation(loc)
+		}
+	case string(centroidsBucket):
+		return func(data []byte) ([]byte, error) {
+			c := &Centroid{}
+			err := decodeCentroid(data, c)
+			return encodeCentroid(c), err
+		}
+	}
+	return nil
+}
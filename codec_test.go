@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCodecRoundtrip(t *testing.T) {
+	ls := &Linestring{Id: 12, Role: "outer", Points: []Point{
+		{Lon: 1799999999, Lat: -899999999}, {Lon: -1799999999, Lat: 5}, {}}}
+	data := encodeLinestring(ls)
+	ls2 := &Linestring{}
+	if err := decodeLinestring(data, ls2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ls, ls2) {
+		t.Fatalf("linestring mismatch: %+v != %+v", ls, ls2)
+	}
+
+	rel := &Relation{Id: -3,
+		Meta: Metadata{Version: 2, Timestamp: 1500000000, Changeset: 7,
+			Uid: "\x81\x01", Author: "alice"},
+		Refs: []Ref{
+			{Id: 10, Type: 1, Role: "outer"},
+			{Id: 2, Type: 0, Role: "admin_centre"},
+			{Id: 8, Type: 2, Role: ""},
+		},
+		Tags: []StringPair{
+			{Key: "name", Value: "Écrins"},
+			{Key: "type", Value: "boundary"},
+		}}
+	rel2 := &Relation{}
+	if err := decodeRelation(encodeRelation(rel), rel2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rel, rel2) {
+		t.Fatalf("relation mismatch: %+v != %+v", rel, rel2)
+	}
+
+	loc := &Location{Type: "MultiPolygon", Coordinates: [][][][]float64{
+		{{{5.7346073, 45.191733}, {-0.0000001, 45.2}, {5.7346073, 45.191733}}},
+		{{{1, 2}, {3, 4}, {1, 2}}, {}},
+	}, AreaKm2: 12.5, PerimeterKm: 3.25}
+	data, err := encodeLocation(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isJsonValue(data) {
+		t.Fatalf("location was stored as JSON")
+	}
+	loc2 := &Location{}
+	if err := decodeLocation(data, loc2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loc, loc2) {
+		t.Fatalf("location mismatch: %+v != %+v", loc, loc2)
+	}
+	// Locations stored without measures
+	loc3 := &Location{}
+	if err := decodeLocation(data[:len(data)-16], loc3); err != nil {
+		t.Fatal(err)
+	}
+	if loc3.AreaKm2 != 0 || !reflect.DeepEqual(loc.Coordinates, loc3.Coordinates) {
+		t.Fatalf("location mismatch: %+v != %+v", loc, loc3)
+	}
+
+	c := &Centroid{Lon: 5.123456789123, Lat: -45.5, NodeId: 42,
+		Source: CenterSourceLabel}
+	c2 := &Centroid{}
+	if err := decodeCentroid(encodeCentroid(c), c2); err != nil {
+		t.Fatal(err)
+	}
+	if *c != *c2 {
+		t.Fatalf("centroid mismatch: %+v != %+v", c, c2)
+	}
+}
+
+func TestCodecLegacyJson(t *testing.T) {
+	ls := &Linestring{Id: 12, Role: "inner", Points: []Point{{1, 2}, {3, 4}}}
+	data, _ := json.Marshal(ls)
+	ls2 := &Linestring{}
+	if err := decodeLinestring(data, ls2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ls, ls2) {
+		t.Fatalf("linestring mismatch: %+v != %+v", ls, ls2)
+	}
+	// 3D points are kept as JSON
+	loc := &Location{Type: "MultiPolygon",
+		Coordinates: [][][][]float64{{{{1, 2, 3}}}}}
+	data, err := encodeLocation(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc2 := &Location{}
+	if err := decodeLocation(data, loc2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loc, loc2) {
+		t.Fatalf("location mismatch: %+v != %+v", loc, loc2)
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	data := encodeLinestring(&Linestring{Id: 1, Points: []Point{{1, 2}}})
+	tests := [][]byte{
+		nil,
+		{0x02, 0x00},
+		data[:len(data)-1],
+		append(append([]byte{}, data...), 0),
+		// Huge points count
+		{codecVersion1, 0x02, 0x00, 0xff, 0xff, 0x03},
+	}
+	for _, test := range tests {
+		if err := decodeLinestring(test, &Linestring{}); err == nil {
+			t.Fatalf("invalid value decoded: %x", test)
+		}
+	}
+}
+
+func TestMigrateBucket(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+	var err error
+	expected := []*Linestring{}
+	for i := 0; i < 25; i++ {
+		ls := &Linestring{Id: int64(i), Points: []Point{{int64(i), 1}}}
+		expected = append(expected, ls)
+		if i%3 == 0 {
+			err = db.Put(ls)
+		} else {
+			err = db.putJson(waysBucket, ls.Id, ls)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := db.MigrateBucket(waysBucket, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 16 {
+		t.Fatalf("unexpected migrated count: %d", n)
+	}
+	for _, ls := range expected {
+		ok, err := db.getData(waysBucket, ls.Id, func(data []byte) error {
+			if isJsonValue(data) {
+				t.Fatalf("way %d was not migrated", ls.Id)
+			}
+			return nil
+		})
+		if err != nil || !ok {
+			t.Fatalf("could not get way %d: %v", ls.Id, err)
+		}
+		actual, err := db.Get(ls.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ls, actual) {
+			t.Fatalf("way mismatch: %+v != %+v", ls, actual)
+		}
+	}
+	n, err = db.MigrateBucket(waysBucket, 4)
+	if err != nil || n != 0 {
+		t.Fatalf("second migration rewrote %d values: %v", n, err)
+	}
+}
+
+func TestCodecEmptySlices(t *testing.T) {
+	rel := &Relation{Id: 1}
+	rel2 := &Relation{}
+	if err := decodeRelation(encodeRelation(rel), rel2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rel, rel2) {
+		t.Fatalf("relation mismatch: %+v != %+v", rel, rel2)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// RelationCompleteness counts the dangling references of a relation,
+// including the missing nodes of its member ways.
+type RelationCompleteness struct {
+	Id               int64
+	Name             string
+	Level            string
+	MissingNodes     int
+	MissingWayNodes  int
+	IncompleteWays   int
+	MissingWays      int
+	MissingRelations int
+}
+
+func (c *RelationCompleteness) Missing() int {
+	return c.MissingNodes + c.MissingWayNodes + c.MissingWays +
+		c.MissingRelations
+}
+
+// CompletenessReport lists incomplete relations, in file order, and counts
+// dangling references over the whole file.
+type CompletenessReport struct {
+	Relations           []*RelationCompleteness
+	IncompleteWays      int
+	IncompleteRelations int
+	MissingNodes        int
+	MissingWays         int
+	MissingRelations    int
+}
+
+// Scans path and reports ways and relations referencing elements absent from
+// it. Only the missing nodes counts of incomplete ways are kept in memory, so
+// the cost is dominated by the element ids sets.
+func checkCompleteness(path string) (*CompletenessReport, error) {
+	report := &CompletenessReport{}
+	nodes := &IdSet{}
+	ways := &IdSet{}
+	relations := &IdSet{}
+	incompleteWays := map[int64]int{}
+	plan := &ScanPlan{}
+	plan.Add(&ScanStage{
+		Kinds: []int{NodeKind},
+		Handler: o5m.HandlerFuncs{
+			Node: func(n *Node) error {
+				nodes.Add(n.Id)
+				return nil
+			},
+		},
+	})
+	plan.Add(&ScanStage{
+		Kinds: []int{WayKind, RelationKind},
+		Handler: o5m.HandlerFuncs{
+			Way: func(w *Way) error {
+				ways.Add(w.Id)
+				missing := findMissingWayRefs(w, nodes)
+				if len(missing.Nodes) > 0 {
+					incompleteWays[w.Id] = len(missing.Nodes)
+					report.IncompleteWays++
+					report.MissingNodes += len(missing.Nodes)
+				}
+				return nil
+			},
+			Relation: func(rel *Relation) error {
+				relations.Add(rel.Id)
+				return nil
+			},
+		},
+	})
+	plan.Add(&ScanStage{
+		Kinds: []int{RelationKind},
+		Handler: o5m.HandlerFuncs{
+			Relation: func(rel *Relation) error {
+				missing := findMissingRelationRefs(rel, nodes, ways, relations)
+				c := &RelationCompleteness{
+					Id:               rel.Id,
+					MissingNodes:     len(missing.Nodes),
+					MissingWays:      len(missing.Ways),
+					MissingRelations: len(missing.Relations),
+				}
+				for _, ref := range rel.Refs {
+					if n := incompleteWays[ref.Id]; ref.Type == 1 && n > 0 {
+						c.IncompleteWays++
+						c.MissingWayNodes += n
+					}
+				}
+				if c.Missing() == 0 {
+					return nil
+				}
+				c.Name = rel.Name()
+				c.Level = rel.AdminLevel()
+				report.Relations = append(report.Relations, c)
+				report.IncompleteRelations++
+				report.MissingNodes += c.MissingNodes
+				report.MissingWays += c.MissingWays
+				report.MissingRelations += c.MissingRelations
+				return nil
+			},
+		},
+	})
+	err := plan.Run(context.Background(), path)
+	return report, err
+}
+
+// Writes one CSV line per incomplete relation, after a header line.
+func writeCompletenessCsv(w io.Writer, report *CompletenessReport) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"relation_id", "name", "admin_level",
+		"missing_nodes", "missing_way_nodes", "incomplete_ways",
+		"missing_ways", "missing_relations"})
+	if err != nil {
+		return err
+	}
+	for _, c := range report.Relations {
+		err := cw.Write([]string{
+			strconv.FormatInt(c.Id, 10),
+			c.Name,
+			c.Level,
+			strconv.Itoa(c.MissingNodes),
+			strconv.Itoa(c.MissingWayNodes),
+			strconv.Itoa(c.IncompleteWays),
+			strconv.Itoa(c.MissingWays),
+			strconv.Itoa(c.MissingRelations),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCheckCompleteness(t *testing.T) {
+	nodes := []Node{{Id: 1}, {Id: 2}}
+	ways := []Way{
+		{Id: 10, Nodes: []int64{1, 2, 3}},
+		{Id: 11, Nodes: []int64{1, 2}},
+	}
+	relations := []Relation{
+		{Id: 20, Refs: []Ref{
+			{Id: 10, Type: 1, Role: "outer"},
+			{Id: 12, Type: 1, Role: "outer"},
+			{Id: 4, Type: 0, Role: "admin_centre"},
+			{Id: 21, Type: 2, Role: "subarea"},
+			{Id: 22, Type: 2, Role: "subarea"},
+		}, Tags: []StringPair{
+			{Key: "name", Value: "Isère"},
+			{Key: "admin_level", Value: "6"},
+		}},
+		{Id: 21, Refs: []Ref{{Id: 11, Type: 1, Role: "outer"}}},
+	}
+	path := writeTestFile(t, nodes, ways, relations)
+	defer os.Remove(path)
+
+	report, err := checkCompleteness(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &CompletenessReport{
+		Relations: []*RelationCompleteness{{
+			Id:               20,
+			Name:             "Isère",
+			Level:            "6",
+			MissingNodes:     1,
+			MissingWayNodes:  1,
+			IncompleteWays:   1,
+			MissingWays:      1,
+			MissingRelations: 1,
+		}},
+		IncompleteWays:      1,
+		IncompleteRelations: 1,
+		MissingNodes:        2,
+		MissingWays:         1,
+		MissingRelations:    1,
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeCompletenessCsv(buf, report); err != nil {
+		t.Fatal(err)
+	}
+	csv := "relation_id,name,admin_level,missing_nodes,missing_way_nodes," +
+		"incomplete_ways,missing_ways,missing_relations\n" +
+		"20,Isère,6,1,1,1,1,1\n"
+	if buf.String() != csv {
+		t.Fatalf("unexpected CSV:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FlagInfo, ArgInfo and CommandInfo describe the command line interface,
+// for completion scripts and wrapper tools.
+type FlagInfo struct {
+	Name     string   `json:"name"`
+	Help     string   `json:"help"`
+	Default  []string `json:"default,omitempty"`
+	Bool     bool     `json:"bool,omitempty"`
+	Required bool     `json:"required,omitempty"`
+	Envars   []string `json:"envars,omitempty"`
+}
+
+type ArgInfo struct {
+	Name     string `json:"name"`
+	Help     string `json:"help"`
+	Required bool   `json:"required,omitempty"`
+}
+
+type CommandInfo struct {
+	Name  string     `json:"name"`
+	Help  string     `json:"help"`
+	Flags []FlagInfo `json:"flags"`
+	Args  []ArgInfo  `json:"args"`
+}
+
+type CLIInfo struct {
+	Name     string        `json:"name"`
+	Flags    []FlagInfo    `json:"flags"`
+	Commands []CommandInfo `json:"commands"`
+}
+
+func (c *CLIInfo) sortedCommands() []CommandInfo {
+	cmds := append([]CommandInfo{}, c.Commands...)
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	return cmds
+}
+
+func formatFlagNames(flags []FlagInfo) string {
+	names := []string{}
+	for _, f := range flags {
+		names = append(names, "--"+f.Name)
+		if f.Bool {
+			names = append(names, "--no-"+f.Name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func writeBashCompletion(buf *bytes.Buffer, c *CLIInfo) {
+	fn := "_" + strings.Replace(c.Name, "-", "_", -1)
+	cmdNames := []string{}
+	for _, cmd := range c.sortedCommands() {
+		cmdNames = append(cmdNames, cmd.Name)
+	}
+	fmt.Fprintf(buf, "%s() {\n", fn)
+	fmt.Fprintf(buf, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\" cmd=\"\" opts=\"\" w\n")
+	fmt.Fprintf(buf, "    for w in \"${COMP_WORDS[@]:1:COMP_CWORD-1}\"; do\n")
+	fmt.Fprintf(buf, "        case \"$w\" in -*) ;; *) cmd=\"$w\"; break ;; esac\n")
+	fmt.Fprintf(buf, "    done\n")
+	fmt.Fprintf(buf, "    if [ -z \"$cmd\" ]; then\n")
+	fmt.Fprintf(buf, "        COMPREPLY=($(compgen -W \"%s %s\" -- \"$cur\"))\n",
+		strings.Join(cmdNames, " "), formatFlagNames(c.Flags))
+	fmt.Fprintf(buf, "        return\n")
+	fmt.Fprintf(buf, "    fi\n")
+	fmt.Fprintf(buf, "    case \"$cmd\" in\n")
+	for _, cmd := range c.sortedCommands() {
+		fmt.Fprintf(buf, "    %s) opts=\"%s\" ;;\n", cmd.Name,
+			formatFlagNames(cmd.Flags))
+	}
+	fmt.Fprintf(buf, "    esac\n")
+	fmt.Fprintf(buf, "    if [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(buf, "        COMPREPLY=($(compgen -W \"$opts %s\" -- \"$cur\"))\n",
+		formatFlagNames(c.Flags))
+	fmt.Fprintf(buf, "    else\n")
+	fmt.Fprintf(buf, "        COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprintf(buf, "    fi\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "complete -o filenames -F %s %s\n", fn, c.Name)
+}
+
+func quoteFish(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+func writeFishCompletion(buf *bytes.Buffer, c *CLIInfo) {
+	for _, f := range c.Flags {
+		fmt.Fprintf(buf, "complete -c %s -l %s -d %s\n", c.Name, f.Name,
+			quoteFish(f.Help))
+	}
+	for _, cmd := range c.sortedCommands() {
+		fmt.Fprintf(buf, "complete -c %s -f -n __fish_use_subcommand -a %s -d %s\n",
+			c.Name, cmd.Name, quoteFish(cmd.Help))
+		for _, f := range cmd.Flags {
+			fmt.Fprintf(buf,
+				"complete -c %s -n '__fish_seen_subcommand_from %s' -l %s -d %s\n",
+				c.Name, cmd.Name, f.Name, quoteFish(f.Help))
+		}
+	}
+}
+
+// Generates a completion script for bash, zsh or fish.
+func generateCompletion(shell string, c *CLIInfo) (string, error) {
+	buf := &bytes.Buffer{}
+	switch shell {
+	case "bash":
+		writeBashCompletion(buf, c)
+	case "zsh":
+		// zsh runs bash completion functions through bashcompinit
+		fmt.Fprintf(buf, "autoload -U +X bashcompinit && bashcompinit\n")
+		writeBashCompletion(buf, c)
+	case "fish":
+		writeFishCompletion(buf, c)
+	default:
+		return "", fmt.Errorf("unsupported shell: %s", shell)
+	}
+	return buf.String(), nil
+}
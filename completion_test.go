@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateCompletion(t *testing.T) {
+	c := &CLIInfo{
+		Name: "osm",
+		Flags: []FlagInfo{
+			{Name: "mem-report", Help: "memory usage report interval"},
+		},
+		Commands: []CommandInfo{
+			{
+				Name: "geojson",
+				Help: "convert o5m to geojson",
+				Flags: []FlagInfo{
+					{Name: "workers", Help: "workers count"},
+					{Name: "protected-areas", Help: "select parks'", Bool: true},
+				},
+			},
+			{Name: "count", Help: "count o5m elements"},
+		},
+	}
+	bash, err := generateCompletion("bash", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`compgen -W "count geojson --mem-report"`,
+		`geojson) opts="--workers --protected-areas --no-protected-areas" ;;`,
+		"complete -o filenames -F _osm osm",
+	} {
+		if !strings.Contains(bash, expected) {
+			t.Fatalf("%q not found in bash completion:\n%s", expected, bash)
+		}
+	}
+	zsh, err := generateCompletion("zsh", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(zsh, "autoload") || !strings.HasSuffix(zsh, bash) {
+		t.Fatalf("unexpected zsh completion:\n%s", zsh)
+	}
+	fish, err := generateCompletion("fish", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `complete -c osm -n '__fish_seen_subcommand_from geojson' ` +
+		`-l protected-areas -d 'select parks\''`
+	if !strings.Contains(fish, expected) {
+		t.Fatalf("%q not found in fish completion:\n%s", expected, fish)
+	}
+	if _, err := generateCompletion("tcsh", c); err == nil {
+		t.Fatalf("unsupported shell accepted")
+	}
+}
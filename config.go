@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Config holds flag defaults by command name. Defaults of the "" section
+// apply to all commands defining the flag.
+//
+// The configuration file looks like:
+//
+//	# Applies to all commands with a --workers flag
+//	workers = 4
+//
+//	[geojson]
+//	format = features
+//	compress = zstd
+type Config map[string]map[string]string
+
+func parseConfig(r io.Reader) (Config, error) {
+	cfg := Config{"": map[string]string{}}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if cfg[section] == nil {
+				cfg[section] = map[string]string{}
+			}
+			continue
+		}
+		pos := strings.Index(line, "=")
+		if pos <= 0 {
+			return nil, fmt.Errorf("invalid configuration line %d: %s",
+				lineNum, line)
+		}
+		key := strings.TrimPrefix(strings.TrimSpace(line[:pos]), "--")
+		cfg[section][key] = strings.TrimSpace(line[pos+1:])
+	}
+	return cfg, scanner.Err()
+}
+
+func readConfig(path string) (Config, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	cfg, err := parseConfig(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// Returns the environment variable names for a command flag, most specific
+// first: OSM_GEOJSON_WORKERS then OSM_WORKERS for geojson --workers.
+func getFlagEnvars(cmd, flag string) []string {
+	normalize := func(s string) string {
+		return strings.ToUpper(strings.Replace(s, "-", "_", -1))
+	}
+	names := []string{}
+	if cmd != "" {
+		names = append(names, "OSM_"+normalize(cmd)+"_"+normalize(flag))
+	}
+	return append(names, "OSM_"+normalize(flag))
+}
+
+// Returns a function looking up a command flag default in the environment,
+// then in cfg.
+func makeDefaultsLookup(cfg Config, getenv func(string) string) func(
+	cmd, flag string) (string, bool) {
+
+	return func(cmd, flag string) (string, bool) {
+		for _, name := range getFlagEnvars(cmd, flag) {
+			if v := getenv(name); v != "" {
+				return v, true
+			}
+		}
+		for _, section := range []string{cmd, ""} {
+			if v, ok := cfg[section][flag]; ok {
+				return v, true
+			}
+		}
+		return "", false
+	}
+}
+
+// flagSpec describes a command line flag for defaults resolution.
+type flagSpec struct {
+	Name string
+	Bool bool
+}
+
+func findFlagSpec(flags []flagSpec, name string) (flagSpec, bool) {
+	for _, f := range flags {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return flagSpec{}, false
+}
+
+// kingpin parses a lone "-", used for stdin and stdout, as an empty short
+// flag. Rewrites it as "--flag=-" when it is the value of a flag, and moves
+// positional ones and the arguments following them after a "--", except
+// flags which stay before it.
+func rewriteDashArgs(args []string, globals, flags []flagSpec) []string {
+	specs := append(append([]flagSpec{}, globals...), flags...)
+	takesValue := func(arg string) bool {
+		if !strings.HasPrefix(arg, "--") || strings.Contains(arg, "=") {
+			return false
+		}
+		f, ok := findFlagSpec(specs, strings.TrimPrefix(arg, "--"))
+		return ok && !f.Bool
+	}
+	head := []string{}
+	// Positional arguments from the first lone "-", nil without one
+	var tail []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			if tail == nil {
+				return append(head, args[i:]...)
+			}
+			return append(append(append(head, "--"), tail...), args[i+1:]...)
+		case arg == "-":
+			tail = append(tail, arg)
+		case strings.HasPrefix(arg, "-"):
+			head = append(head, arg)
+			if takesValue(arg) && i+1 < len(args) {
+				i++
+				if args[i] == "-" {
+					head[len(head)-1] = arg + "=-"
+				} else {
+					head = append(head, args[i])
+				}
+			}
+		case tail != nil:
+			tail = append(tail, arg)
+		default:
+			head = append(head, arg)
+		}
+	}
+	if tail == nil {
+		return head
+	}
+	return append(append(head, "--"), tail...)
+}
+
+// Returns the command name in args, skipping global flags and their values.
+func findCommand(args []string, globals []flagSpec) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+		if strings.Contains(arg, "=") {
+			continue
+		}
+		f, ok := findFlagSpec(globals, strings.TrimLeft(arg, "-"))
+		if ok && !f.Bool {
+			i++
+		}
+	}
+	return ""
+}
+
+// Returns the flags explicitly set in args.
+func getSetFlags(args []string) map[string]bool {
+	set := map[string]bool{}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		name := strings.TrimPrefix(arg, "--")
+		if pos := strings.Index(name, "="); pos >= 0 {
+			name = name[:pos]
+		}
+		set[name] = true
+		set[strings.TrimPrefix(name, "no-")] = true
+	}
+	return set
+}
+
+// Appends to args the defaults of flags not set on the command line, for
+// global flags and those of cmd. They are inserted before "--", after which
+// arguments are positional.
+func applyFlagDefaults(args []string, cmd string, globals, flags []flagSpec,
+	lookup func(cmd, flag string) (string, bool)) ([]string, error) {
+
+	set := getSetFlags(args)
+	end := len(args)
+	for i, arg := range args {
+		if arg == "--" {
+			end = i
+			break
+		}
+	}
+	result := append([]string{}, args[:end]...)
+	add := func(f flagSpec, cmd string) error {
+		if set[f.Name] {
+			return nil
+		}
+		v, ok := lookup(cmd, f.Name)
+		if !ok {
+			return nil
+		}
+		if !f.Bool {
+			result = append(result, "--"+f.Name+"="+v)
+			return nil
+		}
+		switch strings.ToLower(v) {
+		case "true", "yes", "1":
+			result = append(result, "--"+f.Name)
+		case "false", "no", "0":
+			result = append(result, "--no-"+f.Name)
+		default:
+			return fmt.Errorf("invalid boolean default for %s: %s", f.Name, v)
+		}
+		return nil
+	}
+	for _, f := range globals {
+		if err := add(f, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range flags {
+		if err := add(f, cmd); err != nil {
+			return nil, err
+		}
+	}
+	return append(result, args[end:]...), nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyFlagDefaults(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(`
+# comment
+workers = 4
+mem-report = 5m
+
+[geojson]
+--format = features
+protected-areas = yes
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"OSM_GEOJSON_COMPRESS": "zstd",
+		"OSM_WORKERS":          "8",
+	}
+	lookup := makeDefaultsLookup(cfg, func(name string) string {
+		return env[name]
+	})
+	globals := []flagSpec{{"mem-report", false}, {"help", true}}
+	flags := []flagSpec{
+		{"workers", false},
+		{"format", false},
+		{"compress", false},
+		{"protected-areas", true},
+		{"keep", false},
+	}
+	args := []string{"--mem-report", "1m", "geojson", "in.o5m", "in.db",
+		"out.jsonl", "--format=es"}
+	cmd := findCommand(args, globals)
+	if cmd != "geojson" {
+		t.Fatalf("unexpected command: %s", cmd)
+	}
+	res, err := applyFlagDefaults(args, cmd, globals, flags, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(args, "--workers=8", "--compress=zstd",
+		"--protected-areas")
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected args: %v", res)
+	}
+
+	args = []string{"geojson", "--no-protected-areas"}
+	res, err = applyFlagDefaults(args, "geojson", globals, flags,
+		makeDefaultsLookup(cfg, func(string) string { return "" }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(args, "--mem-report=5m", "--workers=4", "--format=features")
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected args: %v", res)
+	}
+
+	// Defaults go before positional arguments following "--"
+	args = []string{"geojson", "--", "in.o5m", "in.db", "out.jsonl"}
+	res, err = applyFlagDefaults(args, "geojson", globals, flags, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"geojson", "--mem-report=5m", "--workers=8",
+		"--format=features", "--compress=zstd", "--protected-areas", "--",
+		"in.o5m", "in.db", "out.jsonl"}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected args: %v", res)
+	}
+
+	if _, err := parseConfig(strings.NewReader("workers")); err == nil {
+		t.Fatalf("invalid configuration accepted")
+	}
+}
+
+func TestRewriteDashArgs(t *testing.T) {
+	globals := []flagSpec{{"summary-json", false}, {"help", true}}
+	flags := []flagSpec{{"workers", false}, {"force", true}}
+	tests := []struct {
+		Args     []string
+		Expected []string
+	}{
+		{
+			[]string{"count", "in.o5m"},
+			[]string{"count", "in.o5m"},
+		},
+		{
+			[]string{"count", "-"},
+			[]string{"count", "--", "-"},
+		},
+		{
+			[]string{"convert", "-", "out.o5m", "--workers", "2", "--force"},
+			[]string{"convert", "--workers", "2", "--force", "--", "-", "out.o5m"},
+		},
+		{
+			[]string{"convert", "--workers", "-", "in.o5m"},
+			[]string{"convert", "--workers=-", "in.o5m"},
+		},
+		{
+			[]string{"count", "--force", "-", "--", "-x"},
+			[]string{"count", "--force", "--", "-", "-x"},
+		},
+		{
+			[]string{"count", "--", "-"},
+			[]string{"count", "--", "-"},
+		},
+	}
+	for _, test := range tests {
+		res := rewriteDashArgs(test.Args, globals, flags)
+		if !reflect.DeepEqual(res, test.Expected) {
+			t.Fatalf("unexpected args for %v: %v", test.Args, res)
+		}
+	}
+}
+
+// Parses args like the command line, returning the selected command.
+func parseTestArgs(t *testing.T, args ...string) string {
+	t.Helper()
+	expanded, err := expandFlagDefaults(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := app.Parse(expanded)
+	if err != nil {
+		t.Fatalf("cannot parse %v: %s", args, err)
+	}
+	return cmd
+}
+
+func TestParseStdinArg(t *testing.T) {
+	t.Setenv("OSM_CONFIG", "")
+	cmd := parseTestArgs(t, "count", "-")
+	if cmd != countCmd.FullCommand() || *countPath != "-" {
+		t.Fatalf("unexpected command: %s %q", cmd, *countPath)
+	}
+}
+
+func TestParseDefaultsBeforeDoubleDash(t *testing.T) {
+	t.Setenv("OSM_CONFIG", "")
+	t.Setenv("OSM_WORKERS", "3")
+	defer func(workers int) {
+		*geojsonWorkers = workers
+	}(*geojsonWorkers)
+	cmd := parseTestArgs(t, "geojson", "--", "in.o5m", "in.db", "out")
+	if cmd != geojsonCmd.FullCommand() || *geojsonWorkers != 3 ||
+		*geojsonPath != "in.o5m" || *geojsonOutpath != "out" {
+		t.Fatalf("unexpected parsing: %s %d %q %q", cmd, *geojsonWorkers,
+			*geojsonPath, *geojsonOutpath)
+	}
+}
+
+func TestParseSummaryJsonStdout(t *testing.T) {
+	t.Setenv("OSM_CONFIG", "")
+	defer func() {
+		*summaryJson = ""
+	}()
+	tests := []struct {
+		Args []string
+		Path string
+	}{
+		{[]string{"--summary-json", "-", "count", "in.o5m"}, "in.o5m"},
+		{[]string{"count", "in.o5m", "--summary-json", "-"}, "in.o5m"},
+		{[]string{"count", "--summary-json", "-", "-"}, "-"},
+	}
+	for _, test := range tests {
+		*summaryJson = ""
+		cmd := parseTestArgs(t, test.Args...)
+		if cmd != countCmd.FullCommand() || *summaryJson != "-" ||
+			*countPath != test.Path {
+			t.Fatalf("unexpected parsing of %v: %s %q %q", test.Args, cmd,
+				*summaryJson, *countPath)
+		}
+	}
+}
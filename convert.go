@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ConvertAuto    = "auto"
+	ConvertO5M     = "o5m"
+	ConvertPBF     = "pbf"
+	ConvertXML     = "osm"
+	ConvertGeoJSON = "geojson"
+)
+
+var (
+	ConvertFormats = []string{
+		ConvertAuto,
+		ConvertO5M,
+		ConvertPBF,
+		ConvertXML,
+		ConvertGeoJSON,
+	}
+)
+
+// ElementWriter serializes the content of an OSMReader. Elements must be
+// written by kind, nodes first, then ways, then relations.
+type ElementWriter interface {
+	WriteBoundingBox(bb BoundingBox) error
+	WriteNode(n *Node) error
+	WriteWay(w *Way) error
+	WriteRelation(r *Relation) error
+	// Close flushes buffered data but does not close the underlying writer
+	Close() error
+}
+
+// nodeLocator is implemented by writers needing the location of nodes they
+// do not write, to build the geometry of ways.
+type nodeLocator interface {
+	LocateNode(n *Node) error
+}
+
+// Returns the output format of path. "auto" is resolved using the file
+// extension, ignoring compression ones, like "planet.osm.gz".
+func resolveConvertFormat(path, format string) (string, error) {
+	if format != ConvertAuto && format != "" {
+		return format, nil
+	}
+	name := strings.ToLower(filepath.Base(path))
+	for _, ext := range []string{".gz", ".zst", ".zstd"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	switch filepath.Ext(name) {
+	case ".o5m":
+		return ConvertO5M, nil
+	case ".pbf":
+		return ConvertPBF, nil
+	case ".osm", ".xml":
+		return ConvertXML, nil
+	case ".geojson", ".json":
+		return ConvertGeoJSON, nil
+	}
+	return "", fmt.Errorf("cannot guess output format of %s, use --format", path)
+}
+
+func newElementWriter(w io.Writer, format string) (ElementWriter, error) {
+	switch format {
+	case ConvertO5M:
+		return NewO5MWriter(w)
+	case ConvertPBF:
+		return NewPBFWriter(w)
+	case ConvertXML:
+		return NewOSMXMLWriter(w)
+	case ConvertGeoJSON:
+		return NewGeoJSONElementWriter(w)
+	}
+	return nil, fmt.Errorf("unknown output format: %s", format)
+}
+
+type ConvertStats struct {
+	Nodes     int
+	Ways      int
+	Relations int
+}
+
+func (s *ConvertStats) String() string {
+	return fmt.Sprintf("nodes=%d ways=%d relations=%d", s.Nodes, s.Ways,
+		s.Relations)
+}
+
+// Copies the bounding box and elements of r to w, except those of dropped
+// kinds, indexed by kind. Dropped nodes are still passed to writers
+// implementing nodeLocator. w is closed on success.
+func convertElements(r OSMReader, w ElementWriter, dropped []bool) (
+	*ConvertStats, error) {
+
+	locator, _ := w.(nodeLocator)
+	stats := &ConvertStats{}
+	var err error
+	for r.Next() {
+		kind := r.Kind()
+		if kind >= 0 && kind < len(dropped) && dropped[kind] {
+			if kind == NodeKind && locator != nil {
+				err = locator.LocateNode(r.Node())
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch kind {
+		case BBoxKind:
+			err = w.WriteBoundingBox(r.BoundingBox())
+		case NodeKind:
+			stats.Nodes++
+			err = w.WriteNode(r.Node())
+		case WayKind:
+			stats.Ways++
+			err = w.WriteWay(r.Way())
+		case RelationKind:
+			stats.Relations++
+			err = w.WriteRelation(r.Relation())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return stats, w.Close()
+}
+
+// Converts path into output, in format, without the elements of dropped
+// kinds.
+func convertFile(path, output, format, compression string, dropped []bool) (
+	*ConvertStats, error) {
+
+	ignored := []int{}
+	for _, kind := range []int{NodeKind, WayKind, RelationKind} {
+		// GeoJSON ways are located with the nodes, even dropped ones
+		if dropped[kind] && (kind != NodeKind || format != ConvertGeoJSON ||
+			dropped[WayKind]) {
+			ignored = append(ignored, kind)
+		}
+	}
+	r, err := OpenOSMReader(path, ignored...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := CreateOutputFile(output, compression)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Abort()
+	w, err := newElementWriter(out, format)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := convertElements(r, w, dropped)
+	if err != nil {
+		return nil, err
+	}
+	return stats, out.Commit()
+}
+
+// GeoJSONElementWriter writes elements as a GeoJSON FeatureCollection, one
+// feature per line: nodes as points, ways as linestrings and relations
+// without geometry, their members listed in a "members" property. Node
+// locations are kept in memory to build the ways, which is fine for
+// extracts but not for the planet.
+type GeoJSONElementWriter struct {
+	w      *bufio.Writer
+	err    error
+	points *NodePoints
+	count  int
+}
+
+type elementMember struct {
+	Type string `json:"type"`
+	Ref  int64  `json:"ref"`
+	Role string `json:"role"`
+}
+
+type elementFeature struct {
+	Type       string                 `json:"type"`
+	Id         string                 `json:"id"`
+	Geometry   *debugGeometry         `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func NewGeoJSONElementWriter(w io.Writer) (*GeoJSONElementWriter, error) {
+	gw := &GeoJSONElementWriter{
+		w:      bufio.NewWriter(w),
+		points: NewNodePoints(0),
+	}
+	_, err := gw.w.WriteString(`{"type":"FeatureCollection","features":[` + "\n")
+	if err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+func makeElementProperties(tags []StringPair, meta *Metadata) map[string]interface{} {
+	props := map[string]interface{}{}
+	for _, tag := range tags {
+		props[tag.Key] = tag.Value
+	}
+	if exportMetadata {
+		if js := makeMetadataJson(meta); js != nil {
+			props["meta"] = js
+		}
+	}
+	return props
+}
+
+func (w *GeoJSONElementWriter) writeFeature(f *elementFeature) error {
+	if w.err != nil {
+		return w.err
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if w.count > 0 {
+		w.w.WriteString(",\n")
+	}
+	w.count++
+	_, w.err = w.w.Write(data)
+	return w.err
+}
+
+// The bounding box is not written.
+func (w *GeoJSONElementWriter) WriteBoundingBox(bb BoundingBox) error {
+	return w.err
+}
+
+// LocateNode records the location of a node without writing it.
+func (w *GeoJSONElementWriter) LocateNode(n *Node) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.points.Append(n.Id, Point{Lon: n.Lon, Lat: n.Lat})
+	return w.err
+}
+
+func (w *GeoJSONElementWriter) WriteNode(n *Node) error {
+	if err := w.LocateNode(n); err != nil {
+		return err
+	}
+	return w.writeFeature(&elementFeature{
+		Type: "Feature",
+		Id:   fmt.Sprintf("node/%d", n.Id),
+		Geometry: &debugGeometry{
+			Type:        "Point",
+			Coordinates: []float64{float64(n.Lon) / 1e7, float64(n.Lat) / 1e7},
+		},
+		Properties: makeElementProperties(n.Tags, &n.Meta),
+	})
+}
+
+// WriteWay writes way as a linestring of its located nodes, the number of
+// missing ones being reported in a "missing_nodes" property.
+func (w *GeoJSONElementWriter) WriteWay(way *Way) error {
+	points := make([]Point, 0, len(way.Nodes))
+	missing := 0
+	for _, id := range way.Nodes {
+		p, err := w.points.FindPoint(id)
+		if err != nil {
+			missing++
+			continue
+		}
+		points = append(points, p.Point)
+	}
+	props := makeElementProperties(way.Tags, &way.Meta)
+	if missing > 0 {
+		props["missing_nodes"] = missing
+	}
+	return w.writeFeature(&elementFeature{
+		Type: "Feature",
+		Id:   fmt.Sprintf("way/%d", way.Id),
+		Geometry: &debugGeometry{
+			Type:        "LineString",
+			Coordinates: pointsToJson(points),
+		},
+		Properties: props,
+	})
+}
+
+func (w *GeoJSONElementWriter) WriteRelation(r *Relation) error {
+	members := make([]elementMember, 0, len(r.Refs))
+	for _, ref := range r.Refs {
+		if ref.Type < 0 || ref.Type > 2 {
+			w.err = fmt.Errorf("invalid reference type: %d", ref.Type)
+			return w.err
+		}
+		members = append(members, elementMember{
+			Type: xmlMemberNames[ref.Type],
+			Ref:  ref.Id,
+			Role: ref.Role,
+		})
+	}
+	props := makeElementProperties(r.Tags, &r.Meta)
+	props["members"] = members
+	return w.writeFeature(&elementFeature{
+		Type:       "Feature",
+		Id:         fmt.Sprintf("relation/%d", r.Id),
+		Properties: props,
+	})
+}
+
+// Close ends the collection and flushes buffered data. It does not close
+// the underlying writer.
+func (w *GeoJSONElementWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := w.w.WriteString("\n]}\n"); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
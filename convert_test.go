@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// Writes an o5m file with a bounding box, more nodes than fit in a PBF
+// block, with and without metadata, and strings needing XML escaping.
+func writeConvertTestFile(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "input.o5m")
+	fp, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	w, err := NewO5MWriter(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteBoundingBox(BoundingBox{X1: -5.6, Y1: 45.1, X2: 5.8, Y2: 45.3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < pbfBlockSize+10; i++ {
+		n := Node{
+			Id:  int64(i*3 + 1),
+			Lon: int64(1799999999 - i*71999),
+			Lat: int64(-899999999 + i*35999),
+		}
+		switch i % 3 {
+		case 0:
+			n.Tags = []StringPair{
+				{Key: "name", Value: fmt.Sprintf("<node> \"%d\" & co", i)},
+				{Key: "place", Value: "village"},
+			}
+		case 1:
+			n.Meta = Metadata{Version: 2, Timestamp: 1500000000 + i,
+				Changeset: 100 + i, Uid: string(o5m.AppendUnsigned(nil, 42)),
+				Author: "alice"}
+		}
+		if err := w.WriteNode(&n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ways := []Way{
+		{Id: 10, Nodes: []int64{1, 4, 7, 1}, Tags: []StringPair{{Key: "boundary", Value: "administrative"}},
+			Meta: Metadata{Version: 3, Timestamp: 1600000000, Changeset: 7, Author: "bob"}},
+		{Id: 12, Nodes: []int64{7, 4, 1000000}},
+	}
+	for i := range ways {
+		if err := w.WriteWay(&ways[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	relations := []Relation{
+		{Id: 5, Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}, {Id: 12, Type: 1, Role: "inner"}, {Id: 1, Type: 0, Role: "admin_centre"}},
+			Tags: []StringPair{{Key: "name", Value: "Grenoble"}, {Key: "type", Value: "boundary"}}},
+		{Id: 7, Refs: []Ref{{Id: 5, Type: 2, Role: "subarea"}}},
+	}
+	for i := range relations {
+		if err := w.WriteRelation(&relations[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Returns the bounding box and elements of path, without reset points
+// which depend on the format.
+func readConvertedEvents(t *testing.T, path string) []string {
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	events, _ := readEvents(t, r)
+	kept := []string{}
+	for _, e := range events {
+		if !strings.HasPrefix(e, "reset ") {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func TestConvertRoundtrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osm-convert-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := writeConvertTestFile(t, dir)
+	expected := readConvertedEvents(t, input)
+
+	noDrop := make([]bool, RelationKind+1)
+	for _, name := range []string{"output.o5m", "output.osm.pbf",
+		"output.osm", "output.osm.gz"} {
+
+		output := filepath.Join(dir, name)
+		format, err := resolveConvertFormat(output, ConvertAuto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := convertFile(input, output, format, CompressAuto, noDrop)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if stats.Nodes != pbfBlockSize+10 || stats.Ways != 2 ||
+			stats.Relations != 2 {
+			t.Fatalf("%s: unexpected stats: %s", name, stats)
+		}
+		events := readConvertedEvents(t, output)
+		if !reflect.DeepEqual(events, expected) {
+			for i, e := range events {
+				if i >= len(expected) || e != expected[i] {
+					t.Fatalf("%s: unexpected element %d:\n%s\n!=\n%s", name,
+						i, e, expected[i])
+				}
+			}
+			t.Fatalf("%s: missing elements: %d != %d", name, len(events),
+				len(expected))
+		}
+		// Converting back to o5m gives the same file
+		if format != ConvertO5M {
+			back := filepath.Join(dir, "back.o5m")
+			_, err := convertFile(output, back, ConvertO5M, CompressNone, noDrop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := ioutil.ReadFile(back)
+			original, _ := ioutil.ReadFile(input)
+			if !reflect.DeepEqual(data, original) {
+				t.Fatalf("%s: o5m output differs from input", name)
+			}
+		}
+	}
+}
+
+func TestConvertDropKinds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osm-convert-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := writeConvertTestFile(t, dir)
+	output := filepath.Join(dir, "output.pbf")
+
+	dropped := make([]bool, RelationKind+1)
+	dropped[NodeKind] = true
+	dropped[RelationKind] = true
+	stats, err := convertFile(input, output, ConvertPBF, CompressNone, dropped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.String() != "nodes=0 ways=2 relations=0" {
+		t.Fatalf("unexpected stats: %s", stats)
+	}
+	events := readConvertedEvents(t, output)
+	if len(events) != 3 || !strings.HasPrefix(events[0], "bbox ") ||
+		!strings.HasPrefix(events[1], "way ") {
+		t.Fatalf("unexpected elements: %v", events)
+	}
+}
+
+func TestConvertGeoJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osm-convert-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := writeConvertTestFile(t, dir)
+	output := filepath.Join(dir, "output.geojson")
+
+	// Ways are located with dropped nodes
+	dropped := make([]bool, RelationKind+1)
+	dropped[NodeKind] = true
+	_, err = convertFile(input, output, ConvertGeoJSON, CompressNone, dropped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := struct {
+		Type     string
+		Features []struct {
+			Id       string
+			Geometry *struct {
+				Type        string
+				Coordinates [][]float64
+			}
+			Properties map[string]interface{}
+		}
+	}{}
+	if err := json.Unmarshal(data, &collection); err != nil {
+		t.Fatalf("invalid GeoJSON: %s\n%s", err, data)
+	}
+	features := collection.Features
+	if collection.Type != "FeatureCollection" || len(features) != 4 {
+		t.Fatalf("unexpected collection: %s", data)
+	}
+	way := features[0]
+	if way.Id != "way/10" || way.Geometry.Type != "LineString" ||
+		len(way.Geometry.Coordinates) != 4 ||
+		way.Geometry.Coordinates[1][0] != 179.9928 ||
+		way.Properties["boundary"] != "administrative" {
+		t.Fatalf("unexpected way: %+v", way)
+	}
+	if features[1].Properties["missing_nodes"] != 1.0 {
+		t.Fatalf("missing node not reported: %+v", features[1])
+	}
+	rel := features[2]
+	members, _ := json.Marshal(rel.Properties["members"])
+	if rel.Id != "relation/5" || rel.Geometry != nil || string(members) !=
+		`[{"ref":10,"role":"outer","type":"way"},{"ref":12,"role":"inner","type":"way"},{"ref":1,"role":"admin_centre","type":"node"}]` {
+		t.Fatalf("unexpected relation: %+v", rel)
+	}
+}
+
+func TestResolveConvertFormat(t *testing.T) {
+	tests := []struct {
+		Path     string
+		Format   string
+		Expected string
+	}{
+		{"planet.o5m", ConvertAuto, ConvertO5M},
+		{"planet.osm.pbf", ConvertAuto, ConvertPBF},
+		{"dir.pbf/planet.OSM.gz", ConvertAuto, ConvertXML},
+		{"admin.geojson.zst", ConvertAuto, ConvertGeoJSON},
+		{"admin.o5m", ConvertPBF, ConvertPBF},
+		{"admin.dat", ConvertAuto, ""},
+	}
+	for _, test := range tests {
+		format, err := resolveConvertFormat(test.Path, test.Format)
+		if test.Expected == "" {
+			if err == nil {
+				t.Fatalf("%s: format should not be guessed: %s", test.Path,
+					format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != test.Expected {
+			t.Fatalf("%s: unexpected format: %s != %s", test.Path, format,
+				test.Expected)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+type EntrySize struct {
+	Key  []byte
+	Size int
+}
+
+// Returns the entry id if the key is a WaysDb varint key, or its hex dump.
+func (e EntrySize) String() string {
+	id, n := binary.Varint(e.Key)
+	if n == len(e.Key) && n > 0 {
+		return fmt.Sprintf("%d", id)
+	}
+	return fmt.Sprintf("%x", e.Key)
+}
+
+// BucketStats summarizes the content of a WaysDb bucket.
+type BucketStats struct {
+	Name       string
+	Keys       int
+	KeyBytes   int64
+	ValueBytes int64
+	// Values still stored as JSON
+	JsonValues int
+	// Largest values, by decreasing size
+	Largest []EntrySize
+	// Bytes allocated to and used in the bucket pages, bolt only. Small
+	// buckets stored inline in their parent page report zero.
+	PageAlloc int64
+	PageInuse int64
+}
+
+// pageStatser is implemented by stores able to report their page usage.
+type pageStatser interface {
+	PageStats(bucket []byte) (alloc, inuse int64, err error)
+}
+
+func (s *boltStore) PageStats(bucket []byte) (int64, int64, error) {
+	alloc, inuse := int64(0), int64(0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		st := b.Stats()
+		alloc = int64(st.BranchAlloc + st.LeafAlloc)
+		inuse = int64(st.BranchInuse + st.LeafInuse)
+		return nil
+	})
+	return alloc, inuse, err
+}
+
+// Keeps the n largest entries of sizes, which is sorted by decreasing size.
+func addLargest(sizes []EntrySize, n int, key []byte, size int) []EntrySize {
+	if n <= 0 || (len(sizes) >= n && sizes[len(sizes)-1].Size >= size) {
+		return sizes
+	}
+	i := sort.Search(len(sizes), func(i int) bool {
+		return sizes[i].Size < size
+	})
+	if len(sizes) < n {
+		sizes = append(sizes, EntrySize{})
+	}
+	copy(sizes[i+1:], sizes[i:])
+	sizes[i] = EntrySize{
+		Key:  append([]byte{}, key...),
+		Size: size,
+	}
+	return sizes
+}
+
+// Scans all buckets and returns their statistics, with up to largest
+// entries each.
+func (db *WaysDb) Stats(largest int) ([]*BucketStats, error) {
+	result := []*BucketStats{}
+	for _, bucket := range waysDbBuckets {
+		st := &BucketStats{Name: string(bucket)}
+		err := db.db.View(func(tx kvTx) error {
+			return tx.ForEach(bucket, nil, func(k, v []byte) error {
+				st.Keys++
+				st.KeyBytes += int64(len(k))
+				st.ValueBytes += int64(len(v))
+				if isJsonValue(v) {
+					st.JsonValues++
+				}
+				st.Largest = addLargest(st.Largest, largest, k, len(v))
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ps, ok := db.db.(pageStatser); ok {
+			st.PageAlloc, st.PageInuse, err = ps.PageStats(bucket)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, st)
+	}
+	return result, nil
+}
+
+func printBucketStats(w io.Writer, stats []*BucketStats) {
+	for _, st := range stats {
+		fmt.Fprintf(w, "%s: keys=%d keybytes=%d valuebytes=%d json=%d\n",
+			st.Name, st.Keys, st.KeyBytes, st.ValueBytes, st.JsonValues)
+		if st.PageAlloc > 0 {
+			fmt.Fprintf(w, "  pages: alloc=%d inuse=%d utilization=%.1f%%\n",
+				st.PageAlloc, st.PageInuse,
+				100*float64(st.PageInuse)/float64(st.PageAlloc))
+		}
+		for _, e := range st.Largest {
+			fmt.Fprintf(w, "  %s: %d\n", e, e.Size)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddLargest(t *testing.T) {
+	sizes := []EntrySize{}
+	for i, size := range []int{3, 1, 4, 1, 5, 9, 2} {
+		sizes = addLargest(sizes, 3, []byte{byte(i)}, size)
+	}
+	want := []EntrySize{{[]byte{5}, 9}, {[]byte{4}, 5}, {[]byte{2}, 4}}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Fatalf("unexpected largest entries: %v", sizes)
+	}
+}
+
+func TestWaysDbStats(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+
+	batch := db.NewBatch(100)
+	for i := 1; i <= 10; i++ {
+		err := batch.Put(&Linestring{Id: int64(i), Points: make([]Point, i*100)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	err := db.PutAdminCentre(3, &AdminCentre{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := db.Stats(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]*BucketStats{}
+	for _, st := range stats {
+		byName[st.Name] = st
+	}
+	ways := byName[string(waysBucket)]
+	if ways.Keys != 10 || ways.JsonValues != 0 || len(ways.Largest) != 2 ||
+		ways.Largest[0].String() != "10" || ways.Largest[1].String() != "9" {
+		t.Fatalf("unexpected ways stats: %+v", ways)
+	}
+	if ways.PageAlloc <= 0 || ways.PageInuse > ways.PageAlloc {
+		t.Fatalf("unexpected ways page stats: %+v", ways)
+	}
+	centres := byName[string(centresBucket)]
+	if centres.Keys != 1 || centres.JsonValues != 1 {
+		t.Fatalf("unexpected centres stats: %+v", centres)
+	}
+	if byName[string(relationsBucket)].Keys != 0 {
+		t.Fatalf("unexpected relations stats: %+v", byName[string(relationsBucket)])
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// CountryCandidate is one of several relations representing the same
+// country.
+type CountryCandidate struct {
+	Id           int64  `json:"id"`
+	Name         string `json:"name"`
+	Iso2         string `json:"iso2"`
+	Level        int    `json:"admin_level"`
+	Tags         int    `json:"tags"`
+	Ways         int    `json:"ways"`
+	ResolvedWays int    `json:"resolved_ways"`
+	// Inner, outer and subarea relation members, resolved ones being
+	// stored by indexrelations
+	Relations         int    `json:"relations"`
+	ResolvedRelations int    `json:"resolved_relations"`
+	Variant           string `json:"variant,omitempty"`
+	// Forced is set for relations preferred by a patch rule, which always
+	// win
+	Forced bool    `json:"forced,omitempty"`
+	Score  float64 `json:"score"`
+}
+
+func (c *CountryCandidate) String() string {
+	variant := c.Variant
+	if variant == "" {
+		variant = "unknown"
+	}
+	s := fmt.Sprintf("%s(%d)[tags=%d ways=%d/%d", c.Name, c.Id, c.Tags,
+		c.ResolvedWays, c.Ways)
+	if c.Relations > 0 {
+		s += fmt.Sprintf(" relations=%d/%d", c.ResolvedRelations, c.Relations)
+	}
+	if c.Forced {
+		s += " forced"
+	}
+	return s + fmt.Sprintf(" variant=%s score=%.1f]", variant, c.Score)
+}
+
+const (
+	VariantAuto     = "auto"
+	VariantLand     = "land"
+	VariantMaritime = "maritime"
+)
+
+// Returns VariantLand if relation tags describe a land-only boundary,
+// VariantMaritime if it includes territorial waters and an empty string if
+// tags do not tell.
+func getBoundaryVariant(rt *RelationTags) string {
+	boundary := strings.ToLower(rt.Tag("boundary"))
+	boundaryType := strings.ToLower(rt.Tag("boundary_type"))
+	if rt.Tag("maritime") == "yes" || boundary == "maritime" ||
+		boundaryType == "maritime" {
+		return VariantMaritime
+	}
+	if rt.Tag("land_area") != "" || boundary == "land_area" ||
+		boundaryType == "land_area" {
+		return VariantLand
+	}
+	return ""
+}
+
+// Scores a candidate given the preferred boundary variant. Complete
+// relations are strongly preferred, since missing ways or sub-relations
+// prevent building the geometry, then relations with richer tags, which
+// tend to be better maintained. An explicit variant preference overrides
+// everything but patch rules, "auto" favors land boundaries like most
+// consumers expect.
+func scoreCountryCandidate(c *CountryCandidate, preferred string) float64 {
+	score := float64(c.Tags)
+	if members := c.Ways + c.Relations; members > 0 {
+		resolved := c.ResolvedWays + c.ResolvedRelations
+		score += 100 * float64(resolved) / float64(members)
+	}
+	if c.Forced {
+		score += 10000
+	}
+	switch preferred {
+	case VariantLand, VariantMaritime:
+		if c.Variant == preferred {
+			score += 1000
+		} else if c.Variant != "" {
+			score -= 1000
+		}
+	default:
+		if c.Variant == VariantMaritime {
+			score -= 50
+		}
+	}
+	return score
+}
+
+func makeCountryCandidate(rel *Relation, db *WaysDb, preferred string) (
+	*CountryCandidate, error) {
+
+	rt, err := NewRelationTags(rel)
+	if err != nil {
+		return nil, err
+	}
+	level, _ := rt.AdminLevel()
+	c := &CountryCandidate{
+		Id:      rel.Id,
+		Name:    rel.Name(),
+		Iso2:    strings.ToUpper(rt.CountryIso2()),
+		Level:   level,
+		Tags:    len(rel.Tags),
+		Variant: getBoundaryVariant(rt),
+	}
+	if rule := patchRules.Get(rel.Id); rule != nil && rule.Prefer {
+		c.Forced = true
+	}
+	for _, ref := range rel.Refs {
+		switch {
+		case ref.Type == 1:
+			c.Ways++
+			if db == nil {
+				continue
+			}
+			w, err := db.Get(ref.Id)
+			if err != nil {
+				return nil, err
+			}
+			if w != nil {
+				c.ResolvedWays++
+			}
+		case ref.Type == 2 && (ref.Role == "outer" || ref.Role == "inner" ||
+			ref.Role == "subarea"):
+			c.Relations++
+			if db == nil {
+				continue
+			}
+			sub, err := db.GetRelation(ref.Id)
+			if err != nil {
+				return nil, err
+			}
+			if sub != nil {
+				c.ResolvedRelations++
+			}
+		}
+	}
+	c.Score = scoreCountryCandidate(c, preferred)
+	return c, nil
+}
+
+// CountryDecision records which candidate was kept among relations sharing
+// the same ISO code and admin_level.
+type CountryDecision struct {
+	Iso2     string              `json:"iso2"`
+	Level    int                 `json:"admin_level"`
+	Kept     *CountryCandidate   `json:"kept"`
+	Rejected []*CountryCandidate `json:"rejected"`
+}
+
+// Groups candidates by ISO code and admin level and keeps the best scoring
+// one in each group with more than one candidate. Ties are broken by
+// keeping the smallest id, so decisions are stable across runs.
+func dedupCountries(candidates []*CountryCandidate) []*CountryDecision {
+	type groupKey struct {
+		Iso2  string
+		Level int
+	}
+	groups := map[groupKey][]*CountryCandidate{}
+	keys := []groupKey{}
+	for _, c := range candidates {
+		if c.Iso2 == "" {
+			continue
+		}
+		k := groupKey{c.Iso2, c.Level}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], c)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Iso2 != keys[j].Iso2 {
+			return keys[i].Iso2 < keys[j].Iso2
+		}
+		return keys[i].Level < keys[j].Level
+	})
+	decisions := []*CountryDecision{}
+	for _, k := range keys {
+		group := groups[k]
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			if group[i].Score != group[j].Score {
+				return group[i].Score > group[j].Score
+			}
+			return group[i].Id < group[j].Id
+		})
+		decisions = append(decisions, &CountryDecision{
+			Iso2:     k.Iso2,
+			Level:    k.Level,
+			Kept:     group[0],
+			Rejected: group[1:],
+		})
+	}
+	return decisions
+}
+
+// Scores the country relations of r, which must be indexed by
+// indexrelations in db, keeps one per ISO code and stores the rejected ones
+// in db, replacing previous decisions. Decisions are printed to w.
+func runCountryDedup(r OSMReader, db *WaysDb, preferred string,
+	w io.Writer) ([]*CountryDecision, error) {
+
+	err := r.SeekToKind(RelationKind)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []*CountryCandidate{}
+	for r.Next() {
+		if r.Kind() != RelationKind {
+			continue
+		}
+		rel := r.Relation()
+		if ok, err := ignoreRelation(rel); ok || err != nil {
+			continue
+		}
+		c, err := makeCountryCandidate(rel, db, preferred)
+		if err != nil {
+			return nil, err
+		}
+		if c.Iso2 == "" || c.Level != 2 {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	decisions := dedupCountries(candidates)
+	rejected := map[int64]int64{}
+	for _, d := range decisions {
+		fmt.Fprintf(w, "%s[level=%d]: keep %s\n", d.Iso2, d.Level, d.Kept)
+		for _, c := range d.Rejected {
+			fmt.Fprintf(w, "  reject %s\n", c)
+			rejected[c.Id] = d.Kept.Id
+		}
+	}
+	fmt.Fprintf(w, "rejected %d relations among %d countries\n", len(rejected),
+		len(candidates))
+	return decisions, db.PutDuplicates(rejected)
+}
+
+// Writes decisions as a JSON array, for review.
+func writeDedupReport(path string, decisions []*CountryDecision) error {
+	data, err := json.MarshalIndent(decisions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+var (
+	// Relations rejected by dedupcountries, loaded from the db by commands
+	// honoring its decisions.
+	duplicateRelations = map[int64]bool{}
+)
+
+func loadDuplicateRelations(db *WaysDb) error {
+	ids, err := db.ListDuplicates()
+	if err != nil {
+		return err
+	}
+	duplicateRelations = ids
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDedupCountries(t *testing.T) {
+	makeCandidate := func(id int64, iso2 string, tags, ways, resolved int,
+		variant string) *CountryCandidate {
+		c := &CountryCandidate{
+			Id:           id,
+			Iso2:         iso2,
+			Level:        2,
+			Tags:         tags,
+			Ways:         ways,
+			ResolvedWays: resolved,
+			Variant:      variant,
+		}
+		c.Score = scoreCountryCandidate(c, VariantAuto)
+		return c
+	}
+	candidates := []*CountryCandidate{
+		// Richer tags but incomplete
+		makeCandidate(1, "FR", 40, 10, 5, ""),
+		makeCandidate(2, "FR", 20, 10, 10, ""),
+		// Maritime loses against land
+		makeCandidate(3, "BE", 30, 10, 10, VariantMaritime),
+		makeCandidate(4, "BE", 25, 10, 10, ""),
+		// Ties keep the smallest id
+		makeCandidate(6, "MC", 10, 1, 1, ""),
+		makeCandidate(5, "MC", 10, 1, 1, ""),
+		// Singletons and missing codes are left alone
+		makeCandidate(7, "DE", 10, 1, 1, ""),
+		makeCandidate(8, "", 10, 1, 1, ""),
+		makeCandidate(9, "", 10, 1, 1, ""),
+	}
+	decisions := dedupCountries(candidates)
+	expected := map[string]int64{"FR": 2, "BE": 4, "MC": 5}
+	if len(decisions) != len(expected) {
+		t.Fatalf("unexpected decisions count: %d", len(decisions))
+	}
+	for _, d := range decisions {
+		if d.Kept.Id != expected[d.Iso2] {
+			t.Fatalf("%s: unexpected kept relation: %s", d.Iso2, d.Kept)
+		}
+		if len(d.Rejected) != 1 {
+			t.Fatalf("%s: unexpected rejected count: %d", d.Iso2, len(d.Rejected))
+		}
+	}
+}
+
+func TestBoundaryVariantPreference(t *testing.T) {
+	land := &CountryCandidate{Id: 1, Tags: 10, Ways: 4, ResolvedWays: 4,
+		Variant: VariantLand}
+	maritime := &CountryCandidate{Id: 2, Tags: 30, Ways: 4, ResolvedWays: 4,
+		Variant: VariantMaritime}
+	for _, preferred := range []string{VariantAuto, VariantLand, VariantMaritime} {
+		expected := land
+		if preferred == VariantMaritime {
+			expected = maritime
+		}
+		land.Score = scoreCountryCandidate(land, preferred)
+		maritime.Score = scoreCountryCandidate(maritime, preferred)
+		decisions := dedupCountries([]*CountryCandidate{
+			{Id: land.Id, Iso2: "XX", Score: land.Score},
+			{Id: maritime.Id, Iso2: "XX", Score: maritime.Score},
+		})
+		if len(decisions) != 1 || decisions[0].Kept.Id != expected.Id {
+			t.Fatalf("%s: unexpected decision", preferred)
+		}
+	}
+}
+
+func TestDefaultRulesBoundaryVariant(t *testing.T) {
+	country := func(id int64, extra ...StringPair) *Relation {
+		return &Relation{
+			Id:   id,
+			Refs: []Ref{{Id: 1, Type: 1, Role: "outer"}},
+			Tags: append([]StringPair{
+				{Key: "type", Value: "boundary"},
+				{Key: "boundary", Value: "administrative"},
+				{Key: "admin_level", Value: "2"},
+				{Key: "name", Value: "France"},
+				{Key: "ISO3166-1:alpha2", Value: "FR"},
+			}, extra...),
+		}
+	}
+	// France and its representation with water areas are not forced by
+	// default rules, the variant preference decides
+	for _, preferred := range []string{VariantAuto, VariantMaritime} {
+		france, err := makeCountryCandidate(country(11980), nil, preferred)
+		if err != nil {
+			t.Fatal(err)
+		}
+		water, err := makeCountryCandidate(country(2202162,
+			StringPair{Key: "maritime", Value: "yes"}), nil, preferred)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := france.Id
+		if preferred == VariantMaritime {
+			expected = water.Id
+		}
+		decisions := dedupCountries([]*CountryCandidate{france, water})
+		if len(decisions) != 1 || decisions[0].Kept.Id != expected ||
+			france.Forced {
+			t.Fatalf("%s: unexpected decision: %s", preferred, decisions[0].Kept)
+		}
+	}
+}
+
+func TestRunCountryDedup(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+	for _, id := range []int64{10, 11} {
+		if err := db.Put(&Linestring{Id: id, Points: []Point{{1, 2}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutRelation(&Relation{Id: 20}); err != nil {
+		t.Fatal(err)
+	}
+	country := func(id int64, iso2 string, refs []Ref, extra ...StringPair) Relation {
+		return Relation{
+			Id:   id,
+			Refs: refs,
+			Tags: append([]StringPair{
+				{Key: "type", Value: "boundary"},
+				{Key: "boundary", Value: "administrative"},
+				{Key: "admin_level", Value: "2"},
+				{Key: "name", Value: iso2},
+				{Key: "ISO3166-1:alpha2", Value: iso2},
+			}, extra...),
+		}
+	}
+	relations := []Relation{
+		// Complete through its sub-relation
+		country(1, "AA", []Ref{{Id: 20, Type: 2, Role: "outer"}}),
+		// Richer tags but missing half of its members
+		country(2, "AA", []Ref{{Id: 10, Type: 1, Role: "outer"},
+			{Id: 21, Type: 2, Role: "outer"}},
+			StringPair{Key: "wikidata", Value: "Q1"},
+			StringPair{Key: "wikipedia", Value: "en:AA"}),
+		// Preferred by a patch rule despite its missing ways
+		country(3, "BB", []Ref{{Id: 30, Type: 1, Role: "outer"}}),
+		country(4, "BB", []Ref{{Id: 11, Type: 1, Role: "outer"}}),
+		country(5, "CC", []Ref{{Id: 11, Type: 1, Role: "outer"}}),
+	}
+	path := writeTestFile(t, nil, nil, relations)
+	defer os.Remove(path)
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	defer func(rules *PatchRules) {
+		patchRules = rules
+	}(patchRules)
+	rules, err := newPatchRules([]*RelationRule{
+		{Id: 3, Prefer: true},
+		// Keep only bypasses tag filters
+		{Id: 2, Keep: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchRules = rules
+
+	out := &bytes.Buffer{}
+	decisions, err := runCountryDedup(r, db, VariantAuto, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 2 || decisions[0].Kept.Id != 1 ||
+		decisions[1].Kept.Id != 3 {
+		t.Fatalf("unexpected decisions:\n%s", out.String())
+	}
+	aa := decisions[0]
+	if aa.Kept.Relations != 1 || aa.Kept.ResolvedRelations != 1 ||
+		aa.Rejected[0].ResolvedWays != 1 || aa.Rejected[0].ResolvedRelations != 0 {
+		t.Fatalf("unexpected members: %s, %s", aa.Kept, aa.Rejected[0])
+	}
+	if aa.Rejected[0].Forced || !decisions[1].Kept.Forced ||
+		!strings.Contains(out.String(), "rejected 2 relations among 5 countries") {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+	duplicates, err := db.ListDuplicates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(duplicates, map[int64]bool{2: true, 4: true}) {
+		t.Fatalf("unexpected duplicates: %v", duplicates)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+type hausdorffSegment struct {
+	ax, ay, bx, by float64
+}
+
+func (s *hausdorffSegment) Distance(x, y float64) float64 {
+	dx := s.bx - s.ax
+	dy := s.by - s.ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((x-s.ax)*dx+(y-s.ay)*dy)/l))
+	}
+	return math.Hypot(s.ax+t*dx-x, s.ay+t*dy-y)
+}
+
+// segmentGrid buckets multipolygon segments in square cells to find the one
+// nearest to a point.
+type segmentGrid struct {
+	size  float64
+	bbox  *BBox
+	cells map[TileKey][]*hausdorffSegment
+}
+
+func newSegmentGrid(coords [][][][]float64) *segmentGrid {
+	segments := []*hausdorffSegment{}
+	bbox := NewBBox()
+	for _, poly := range coords {
+		for _, ring := range poly {
+			for i := 0; i+1 < len(ring); i++ {
+				segments = append(segments, &hausdorffSegment{
+					ring[i][0], ring[i][1], ring[i+1][0], ring[i+1][1],
+				})
+				bbox.Add(ring[i][0], ring[i][1])
+			}
+		}
+	}
+	g := &segmentGrid{
+		bbox:  bbox,
+		cells: map[TileKey][]*hausdorffSegment{},
+	}
+	if len(segments) == 0 {
+		return g
+	}
+	// Aim at a few segments per cell
+	extent := math.Max(bbox.MaxLon-bbox.MinLon, bbox.MaxLat-bbox.MinLat)
+	g.size = math.Max(extent/math.Sqrt(float64(len(segments))), 1e-7)
+	for _, s := range segments {
+		min := g.cell(math.Min(s.ax, s.bx), math.Min(s.ay, s.by))
+		max := g.cell(math.Max(s.ax, s.bx), math.Max(s.ay, s.by))
+		for x := min.X; x <= max.X; x++ {
+			for y := min.Y; y <= max.Y; y++ {
+				k := TileKey{x, y}
+				g.cells[k] = append(g.cells[k], s)
+			}
+		}
+	}
+	return g
+}
+
+func (g *segmentGrid) cell(x, y float64) TileKey {
+	return TileKey{
+		X: int(math.Floor((x - g.bbox.MinLon) / g.size)),
+		Y: int(math.Floor((y - g.bbox.MinLat) / g.size)),
+	}
+}
+
+// Returns the distance from (x, y) to the nearest segment, looking at cells
+// in growing squares around the point until no unvisited cell can hold a
+// closer one. Returns +Inf if there are no segments.
+func (g *segmentGrid) Nearest(x, y float64) float64 {
+	best := math.Inf(1)
+	if len(g.cells) == 0 {
+		return best
+	}
+	c := g.cell(x, y)
+	last := g.cell(g.bbox.MaxLon, g.bbox.MaxLat)
+	maxK := 0
+	for _, v := range []int{c.X, last.X - c.X, c.Y, last.Y - c.Y} {
+		if v < 0 {
+			v = -v
+		}
+		if v > maxK {
+			maxK = v
+		}
+	}
+	visit := func(k TileKey) {
+		for _, s := range g.cells[k] {
+			if d := s.Distance(x, y); d < best {
+				best = d
+			}
+		}
+	}
+	for k := 0; k <= maxK; k++ {
+		if k == 0 {
+			visit(c)
+		} else {
+			for i := -k; i <= k; i++ {
+				visit(TileKey{c.X + i, c.Y - k})
+				visit(TileKey{c.X + i, c.Y + k})
+			}
+			for i := -k + 1; i < k; i++ {
+				visit(TileKey{c.X - k, c.Y + i})
+				visit(TileKey{c.X + k, c.Y + i})
+			}
+		}
+		if best <= float64(k)*g.size {
+			break
+		}
+	}
+	return best
+}
+
+// Returns the largest distance from a vertex of a to the segments of grid.
+func directedHausdorff(a [][][][]float64, grid *segmentGrid) float64 {
+	d := 0.0
+	for _, poly := range a {
+		for _, ring := range poly {
+			for _, p := range ring {
+				d = math.Max(d, grid.Nearest(p[0], p[1]))
+			}
+		}
+	}
+	return d
+}
+
+// Returns the Hausdorff distance between multipolygons a and b in degrees,
+// measured from their vertices to the other boundary. Returns +Inf if only
+// one of them is empty.
+func hausdorffDistance(a, b [][][][]float64) float64 {
+	return math.Max(
+		directedHausdorff(a, newSegmentGrid(b)),
+		directedHausdorff(b, newSegmentGrid(a)))
+}
+
+// DiffRelationJson describes an added, removed or changed relation.
+type DiffRelationJson struct {
+	Id         string  `json:"id"`
+	Name       string  `json:"name,omitempty"`
+	AdminLevel int     `json:"admin_level,omitempty"`
+	AreaKm2    float64 `json:"area_km2,omitempty"`
+	// Previous area and distance between the geometries, for changed ones
+	PreviousAreaKm2 float64 `json:"previous_area_km2,omitempty"`
+	Hausdorff       float64 `json:"hausdorff,omitempty"`
+}
+
+// DbDiffJson lists the locations differing between two databases.
+type DbDiffJson struct {
+	Added     []DiffRelationJson `json:"added"`
+	Removed   []DiffRelationJson `json:"removed"`
+	Changed   []DiffRelationJson `json:"changed"`
+	Unchanged int                `json:"unchanged"`
+}
+
+func makeDiffRelation(db *WaysDb, id int64, loc *Location) (
+	DiffRelationJson, error) {
+
+	r := DiffRelationJson{
+		Id:      strconv.FormatInt(id, 10),
+		AreaKm2: loc.AreaKm2,
+	}
+	if r.AreaKm2 == 0 {
+		measured := *loc
+		measureLocation(&measured)
+		r.AreaKm2 = measured.AreaKm2
+	}
+	rel, err := db.GetRelation(id)
+	if err != nil || rel == nil {
+		return r, err
+	}
+	rt, err := NewRelationTags(rel)
+	if err != nil {
+		return r, err
+	}
+	r.Name = rt.Name()
+	if level, _ := rt.AdminLevel(); level > 0 {
+		r.AdminLevel = level
+	}
+	return r, nil
+}
+
+func sortedIds(ids map[int64]bool) []int64 {
+	sorted := make([]int64, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// Compares the locations of previous and current databases. Locations
+// present in both are reported as changed when their Hausdorff distance
+// exceeds threshold, in degrees.
+func diffDbs(previous, current *WaysDb, threshold float64) (*DbDiffJson, error) {
+	prevIds, err := previous.ListLocationIds()
+	if err != nil {
+		return nil, err
+	}
+	curIds, err := current.ListLocationIds()
+	if err != nil {
+		return nil, err
+	}
+	diff := &DbDiffJson{
+		Added:   []DiffRelationJson{},
+		Removed: []DiffRelationJson{},
+		Changed: []DiffRelationJson{},
+	}
+	for _, id := range sortedIds(prevIds) {
+		prev, err := previous.GetLocation(id)
+		if err != nil {
+			return nil, err
+		}
+		if !curIds[id] {
+			r, err := makeDiffRelation(previous, id, prev)
+			if err != nil {
+				return nil, err
+			}
+			diff.Removed = append(diff.Removed, r)
+			continue
+		}
+		cur, err := current.GetLocation(id)
+		if err != nil {
+			return nil, err
+		}
+		if reflect.DeepEqual(prev.Coordinates, cur.Coordinates) {
+			diff.Unchanged++
+			continue
+		}
+		d := hausdorffDistance(prev.Coordinates, cur.Coordinates)
+		if d <= threshold {
+			diff.Unchanged++
+			continue
+		}
+		r, err := makeDiffRelation(current, id, cur)
+		if err != nil {
+			return nil, err
+		}
+		p, err := makeDiffRelation(previous, id, prev)
+		if err != nil {
+			return nil, err
+		}
+		r.PreviousAreaKm2 = p.AreaKm2
+		if !math.IsInf(d, 1) {
+			r.Hausdorff = d
+		}
+		diff.Changed = append(diff.Changed, r)
+	}
+	for _, id := range sortedIds(curIds) {
+		if prevIds[id] {
+			continue
+		}
+		cur, err := current.GetLocation(id)
+		if err != nil {
+			return nil, err
+		}
+		r, err := makeDiffRelation(current, id, cur)
+		if err != nil {
+			return nil, err
+		}
+		diff.Added = append(diff.Added, r)
+	}
+	return diff, nil
+}
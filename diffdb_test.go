@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func squareCoords(x, y, size float64) [][][][]float64 {
+	return [][][][]float64{{{
+		{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}, {x, y},
+	}}}
+}
+
+func TestHausdorffDistance(t *testing.T) {
+	a := squareCoords(0, 0, 1)
+	if d := hausdorffDistance(a, a); d != 0 {
+		t.Fatalf("unexpected distance: %f", d)
+	}
+	if d := hausdorffDistance(a, squareCoords(0.5, 0, 1)); math.Abs(d-0.5) > 1e-9 {
+		t.Fatalf("unexpected distance: %f", d)
+	}
+	// A spike on one side, far from every vertex of the other polygon
+	spiked := [][][][]float64{{{
+		{0, 0}, {0.5, 0}, {0.5, -3}, {0.6, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0},
+	}}}
+	if d := hausdorffDistance(a, spiked); math.Abs(d-3) > 1e-9 {
+		t.Fatalf("unexpected distance: %f", d)
+	}
+	if d := hausdorffDistance(a, nil); !math.IsInf(d, 1) {
+		t.Fatalf("unexpected distance: %f", d)
+	}
+}
+
+func TestDiffDbs(t *testing.T) {
+	dir, _, previousPath := runTestPipeline(t)
+	defer os.RemoveAll(dir)
+	// The region grows, the country is unchanged
+	grown := strings.Replace(testPipelineOsm,
+		`<node id="7" lat="3" lon="3"/>`, `<node id="7" lat="3.5" lon="3.5"/>`, 1)
+	dir2, _, currentPath := runTestPipelineOn(t, grown)
+	defer os.RemoveAll(dir2)
+	previous, err := OpenWaysDb(previousPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer previous.Close()
+	current, err := OpenWaysDb(currentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Close()
+	// A boundary only found in previous
+	err = previous.PutLocation(102, &Location{
+		Type:        "multipolygon",
+		Coordinates: squareCoords(5, 5, 1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := diffDbs(previous, current, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Id != "102" ||
+		len(diff.Added) != 0 || diff.Unchanged != 1 {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	if len(diff.Changed) != 1 {
+		t.Fatalf("unexpected changes: %+v", diff.Changed)
+	}
+	c := diff.Changed[0]
+	if c.Id != "101" || c.Name != "Region" || c.AdminLevel != 4 ||
+		math.Abs(c.Hausdorff-math.Sqrt(0.5)) > 1e-9 ||
+		c.AreaKm2 <= c.PreviousAreaKm2 {
+		t.Fatalf("unexpected change: %+v", c)
+	}
+}
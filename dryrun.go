@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+const (
+	// Rough sizes of binary encoded values, delta encoded coordinates take
+	// a few bytes each
+	wayPointBytes      = 6
+	locationPointBytes = 5
+	relationBytes      = 250
+)
+
+// DryRunReport summarizes the work a pipeline run would do on an input.
+type DryRunReport struct {
+	Nodes     int
+	Ways      int
+	WayPoints int
+	Relations int
+	// Relations which would be processed
+	Kept     int
+	Skipped  map[string]int
+	Examples map[string][]string
+	// Processed relations having a location already, by rebuild reason
+	Rebuilt map[string]int
+	// Points of the ways directly referenced by relations passing
+	// ignoreRelation
+	LocationPoints int
+	existing       *DryRunLocations
+}
+
+// DryRunLocations tells how indexlocations treats existing locations.
+type DryRunLocations struct {
+	Db *WaysDb
+	// Ids of the relations with a location in Db
+	Ids map[int64]bool
+	// Rebuild all existing locations, like --force-locations
+	Force bool
+	// Rebuild these existing locations, like --rebuild-ids
+	RebuildIds map[int64]bool
+	// Rebuild outdated locations, like --rebuild
+	Rebuild bool
+}
+
+// Returns a report skipping or rebuilding relations with a location in
+// existing like indexlocations would, or ignoring them if it is nil.
+func NewDryRunReport(existing *DryRunLocations) *DryRunReport {
+	return &DryRunReport{
+		Skipped:  map[string]int{},
+		Examples: map[string][]string{},
+		Rebuilt:  map[string]int{},
+		existing: existing,
+	}
+}
+
+func (d *DryRunReport) skip(rel *Relation, reason string) {
+	d.Skipped[reason]++
+	if len(d.Examples[reason]) < 5 {
+		d.Examples[reason] = append(d.Examples[reason], rel.String())
+	}
+}
+
+// Classifies rel like indexlocations and geojson would. wayPoints maps way
+// ids to their number of points.
+func (d *DryRunReport) AddRelation(rel *Relation, wayPoints map[int64]int) error {
+	d.Relations++
+	reason, err := getIgnoreReason(rel)
+	if err != nil {
+		reason = fmt.Sprintf("%s (error)", reason)
+	}
+	if reason != "" {
+		d.skip(rel, reason)
+		return nil
+	}
+	for _, ref := range rel.Refs {
+		if ref.Type == 1 {
+			d.LocationPoints += wayPoints[ref.Id]
+		}
+	}
+	if e := d.existing; e != nil && e.Ids[rel.Id] {
+		reason := "forced"
+		if !e.Force && !e.RebuildIds[rel.Id] {
+			reason, err = getLocationRebuildReason(rel, e.Db, e.Rebuild)
+			if err != nil {
+				return err
+			}
+		}
+		if reason == "" {
+			d.skip(rel, "existing")
+			return nil
+		}
+		d.Rebuilt[reason]++
+	}
+	d.Kept++
+	return nil
+}
+
+func (d *DryRunReport) EstimatedDbBytes() int64 {
+	return int64(d.WayPoints)*wayPointBytes +
+		int64(d.Relations)*relationBytes +
+		int64(d.LocationPoints)*locationPointBytes
+}
+
+func (d *DryRunReport) Print() {
+	fmt.Printf("nodes: %d\n", d.Nodes)
+	fmt.Printf("ways: %d (%d points)\n", d.Ways, d.WayPoints)
+	fmt.Printf("relations: %d, processed: %d\n", d.Relations, d.Kept)
+	reasons := []string{}
+	for reason := range d.Rebuilt {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("  rebuilt %s: %d\n", reason, d.Rebuilt[reason])
+	}
+	reasons = reasons[:0]
+	for reason := range d.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("  skipped %s: %d, e.g. %v\n", reason, d.Skipped[reason],
+			d.Examples[reason])
+	}
+	fmt.Printf("estimated db size: %.1fMB\n",
+		float64(d.EstimatedDbBytes())/(1<<20))
+}
+
+// Scans path without writing anything and reports what a pipeline run with
+// the current selection rules would process, given the existing locations,
+// if not nil.
+func dryRun(path string, existing *DryRunLocations) (*DryRunReport, error) {
+	r, err := OpenOSMReader(path, NodeKind)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	d := NewDryRunReport(existing)
+	wayPoints := map[int64]int{}
+	err = o5m.NewScanner(r, o5m.HandlerFuncs{
+		Node: func(n *Node) error {
+			d.Nodes++
+			return nil
+		},
+		Way: func(w *Way) error {
+			d.Ways++
+			d.WayPoints += len(w.Nodes)
+			wayPoints[w.Id] = len(w.Nodes)
+			return nil
+		},
+		Relation: func(rel *Relation) error {
+			return d.AddRelation(rel, wayPoints)
+		},
+	}).Scan(context.Background())
+	return d, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDryRunReport(t *testing.T) {
+	d := NewDryRunReport(nil)
+	wayPoints := map[int64]int{1: 10, 2: 5}
+	kept := &Relation{
+		Id: 1000,
+		Refs: []Ref{
+			{Id: 1, Type: 1, Role: "outer"},
+			{Id: 2, Type: 1, Role: "outer"},
+			{Id: 3, Type: 1, Role: "outer"},
+		},
+		Tags: []StringPair{
+			{Key: "type", Value: "boundary"},
+			{Key: "boundary", Value: "administrative"},
+			{Key: "admin_level", Value: "8"},
+			{Key: "name", Value: "Somewhere"},
+		},
+	}
+	for _, rel := range []*Relation{
+		kept,
+		{
+			Id: 1001,
+			Tags: []StringPair{
+				{Key: "admin_level", Value: "8"},
+			},
+		},
+		{
+			Id: 1002,
+			Tags: []StringPair{
+				{Key: "name", Value: "Nowhere"},
+			},
+		},
+	} {
+		if err := d.AddRelation(rel, wayPoints); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.Relations != 3 || d.Kept != 1 || d.LocationPoints != 15 {
+		t.Fatalf("unexpected report: %+v", d)
+	}
+	if d.Skipped[IgnoreNoName] != 1 || d.Skipped[IgnoreAdminLevel] != 1 {
+		t.Fatalf("unexpected skip reasons: %v", d.Skipped)
+	}
+	if d.EstimatedDbBytes() != 3*relationBytes+15*locationPointBytes {
+		t.Fatalf("unexpected estimated size: %d", d.EstimatedDbBytes())
+	}
+}
+
+func TestDryRunExistingLocations(t *testing.T) {
+	dir, input, path := runTestPipeline(t)
+	defer os.RemoveAll(dir)
+	db, err := OpenWaysDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ids, err := db.ListLocationIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ids[100] || !ids[101] {
+		t.Fatalf("unexpected locations: %v", ids)
+	}
+
+	tests := []struct {
+		Existing *DryRunLocations
+		Kept     int
+		Skipped  int
+		Rebuilt  map[string]int
+	}{
+		{nil, 2, 0, map[string]int{}},
+		{&DryRunLocations{Db: db, Ids: ids}, 0, 2, map[string]int{}},
+		{&DryRunLocations{Db: db, Ids: ids, Force: true}, 2, 0,
+			map[string]int{"forced": 2}},
+		{&DryRunLocations{Db: db, Ids: ids, RebuildIds: map[int64]bool{101: true}},
+			1, 1, map[string]int{"forced": 1}},
+	}
+	for i, test := range tests {
+		d, err := dryRun(input, test.Existing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Relations != 2 || d.Kept != test.Kept ||
+			d.Skipped["existing"] != test.Skipped {
+			t.Fatalf("%d: unexpected report: %+v", i, d)
+		}
+		if len(d.Rebuilt) != len(test.Rebuilt) ||
+			d.Rebuilt["forced"] != test.Rebuilt["forced"] {
+			t.Fatalf("%d: unexpected rebuilt: %v", i, d.Rebuilt)
+		}
+	}
+
+	// Members changed since the region was built, an older build is outdated
+	err = db.PutLocationBuild(101, &LocationBuild{Version: 1, Hash: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, err := db.GetLocationBuild(100)
+	if err != nil || build == nil {
+		t.Fatalf("cannot get location build: %v %v", build, err)
+	}
+	err = db.PutLocationBuild(100, &LocationBuild{Hash: build.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dryRun(input, &DryRunLocations{Db: db, Ids: ids})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Kept != 1 || d.Skipped["existing"] != 1 ||
+		d.Rebuilt[LocationStale] != 1 {
+		t.Fatalf("unexpected stale report: %+v", d)
+	}
+	d, err = dryRun(input, &DryRunLocations{Db: db, Ids: ids, Rebuild: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Kept != 2 || d.Rebuilt[LocationStale] != 1 ||
+		d.Rebuilt[LocationOutdated] != 1 {
+		t.Fatalf("unexpected outdated report: %+v", d)
+	}
+}
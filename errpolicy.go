@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+)
+
+const (
+	OnErrorFail    = "fail"
+	OnErrorSkip    = "skip"
+	OnErrorCollect = "collect"
+)
+
+var (
+	// What commands do when an element cannot be processed: stop, log and
+	// continue, or continue and fail with a report of all failures at the
+	// end.
+	errorPolicy = OnErrorSkip
+)
+
+// ElementFailure is an element which could not be processed.
+type ElementFailure struct {
+	// Element type and id, like relation/123
+	Element string `json:"element"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+// Applies errorPolicy to err, raised while processing element, like
+// "relation/123". msg and attrs are logged unless the command must stop, in
+// which case the returned error is not nil.
+func elementError(element, msg string, err error, attrs ...any) error {
+	if errorPolicy == OnErrorFail {
+		return fmt.Errorf("%s: %s: %w", msg, element, err)
+	}
+	slog.Error(msg, append(attrs, "error", err)...)
+	if errorPolicy == OnErrorCollect {
+		runSummary.Fail(element, msg, err)
+	}
+	return nil
+}
+
+func relationElement(rel *Relation) string {
+	return fmt.Sprintf("relation/%d", rel.Id)
+}
+
+// Writes failures grouped by message, then returns an error counting them,
+// or nil if there are none.
+func reportFailures(w io.Writer, failures []ElementFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	byMsg := map[string][]ElementFailure{}
+	msgs := []string{}
+	for _, f := range failures {
+		if byMsg[f.Message] == nil {
+			msgs = append(msgs, f.Message)
+		}
+		byMsg[f.Message] = append(byMsg[f.Message], f)
+	}
+	sort.Strings(msgs)
+	fmt.Fprintf(w, "%d elements failed:\n", len(failures))
+	for _, msg := range msgs {
+		fmt.Fprintf(w, "%s (%d):\n", msg, len(byMsg[msg]))
+		for _, f := range byMsg[msg] {
+			fmt.Fprintf(w, "  %s: %s\n", f.Element, f.Error)
+		}
+	}
+	return fmt.Errorf("%d elements failed", len(failures))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"testing"
+)
+
+func TestElementError(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	defer func() {
+		errorPolicy = OnErrorSkip
+		runSummary = NewCommandSummary()
+	}()
+	if err := setupLogging(ioutil.Discard, "error", LogFormatText); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("missing way 12")
+
+	for _, policy := range []string{OnErrorFail, OnErrorSkip, OnErrorCollect} {
+		errorPolicy = policy
+		runSummary = NewCommandSummary()
+		err := elementError("relation/1", "cannot build location", failure)
+		if (err != nil) != (policy == OnErrorFail) {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		if err != nil && !errors.Is(err, failure) {
+			t.Fatalf("%s: cause is not wrapped: %v", policy, err)
+		}
+		collected := len(runSummary.GetFailures())
+		if (collected > 0) != (policy == OnErrorCollect) {
+			t.Fatalf("%s: unexpected failures: %d", policy, collected)
+		}
+		if policy != OnErrorFail && runSummary.Errors != 1 {
+			t.Fatalf("%s: error was not logged", policy)
+		}
+	}
+}
+
+func TestReportFailures(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := reportFailures(buf, nil); err != nil || buf.Len() > 0 {
+		t.Fatalf("no failures should not be reported: %v, %q", err, buf.String())
+	}
+	failures := []ElementFailure{
+		{Element: "relation/2", Message: "cannot build relation", Error: "b"},
+		{Element: "way/1", Message: "cannot build way", Error: "c"},
+		{Element: "relation/1", Message: "cannot build location", Error: "a"},
+		{Element: "relation/3", Message: "cannot build relation", Error: "d"},
+	}
+	err := reportFailures(buf, failures)
+	if err == nil || err.Error() != "4 elements failed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `4 elements failed:
+cannot build location (1):
+  relation/1: a
+cannot build relation (2):
+  relation/2: b
+  relation/3: d
+cannot build way (1):
+  way/1: c
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected report:\n%s", buf.String())
+	}
+}
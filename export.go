@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// LineMarshaler is implemented by documents ParallelMarshaler writes in
+// another format than JSON. The returned line must not contain newlines.
+type LineMarshaler interface {
+	MarshalLine() ([]byte, error)
+}
+
+// DocBuilder is implemented by documents ParallelMarshaler builds in its
+// workers before serializing them. Build returns the document to write, or
+// nil to skip it.
+type DocBuilder interface {
+	Build() (interface{}, error)
+}
+
+// DocBuilderFunc adapts a function to DocBuilder.
+type DocBuilderFunc func() (interface{}, error)
+
+func (f DocBuilderFunc) Build() (interface{}, error) {
+	return f()
+}
+
+type marshalRequest struct {
+	Seq  int
+	Doc  interface{}
+	Data []byte
+	Skip bool
+	Err  error
+}
+
+func (rq *marshalRequest) marshal() {
+	if b, ok := rq.Doc.(DocBuilder); ok {
+		rq.Doc, rq.Err = b.Build()
+		if rq.Err != nil {
+			return
+		}
+		if rq.Doc == nil {
+			rq.Skip = true
+			return
+		}
+	}
+	if lm, ok := rq.Doc.(LineMarshaler); ok {
+		rq.Data, rq.Err = lm.MarshalLine()
+	} else {
+		rq.Data, rq.Err = json.Marshal(rq.Doc)
+	}
+}
+
+// ParallelMarshaler serializes documents to JSON in worker goroutines and
+// writes them as JSON lines in submission order. Large documents like country
+// boundaries take long enough to encode that a single encoding goroutine
+// becomes the bottleneck of exports. Documents implementing DocBuilder are
+// built by the workers too.
+type ParallelMarshaler struct {
+	w        io.Writer
+	pendings chan marshalRequest
+	results  chan marshalRequest
+	running  sync.WaitGroup
+	done     chan bool
+	seq      int
+	written  int
+	closed   bool
+	sep      []byte
+
+	lock sync.Mutex
+	err  error
+}
+
+func NewParallelMarshaler(w io.Writer, workers int) *ParallelMarshaler {
+	if workers < 1 {
+		workers = 1
+	}
+	m := &ParallelMarshaler{
+		w:        w,
+		pendings: make(chan marshalRequest, 2*workers),
+		results:  make(chan marshalRequest, 2*workers),
+		done:     make(chan bool),
+	}
+	for i := 0; i < workers; i++ {
+		m.running.Add(1)
+		go func() {
+			defer m.running.Done()
+			for rq := range m.pendings {
+				rq.marshal()
+				rq.Doc = nil
+				m.results <- rq
+			}
+		}()
+	}
+	go func() {
+		m.running.Wait()
+		close(m.results)
+	}()
+	go m.writeResults()
+	return m
+}
+
+func (m *ParallelMarshaler) setErr(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+func (m *ParallelMarshaler) getErr() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.err
+}
+
+func (m *ParallelMarshaler) writeResults() {
+	defer close(m.done)
+	next := 0
+	waiting := map[int]marshalRequest{}
+	for rq := range m.results {
+		waiting[rq.Seq] = rq
+		for {
+			rq, ok := waiting[next]
+			if !ok {
+				break
+			}
+			delete(waiting, next)
+			next++
+			if m.getErr() != nil {
+				continue
+			}
+			if rq.Err != nil {
+				m.setErr(rq.Err)
+				continue
+			}
+			if rq.Skip {
+				continue
+			}
+			if m.written > 0 && len(m.sep) > 0 {
+				if _, err := m.w.Write(m.sep); err != nil {
+					m.setErr(err)
+					continue
+				}
+			}
+			_, err := m.w.Write(append(rq.Data, '\n'))
+			if err != nil {
+				m.setErr(err)
+				continue
+			}
+			m.written++
+		}
+	}
+}
+
+// SetSeparator sets data written between documents, after the newline
+// ending the previous one. It must be called before the first Write().
+func (m *ParallelMarshaler) SetSeparator(sep []byte) {
+	m.sep = sep
+}
+
+// Write queues doc for serialization. It returns the first error which
+// occurred while marshaling or writing previous documents, if any.
+func (m *ParallelMarshaler) Write(doc interface{}) error {
+	if err := m.getErr(); err != nil {
+		return err
+	}
+	m.pendings <- marshalRequest{
+		Seq: m.seq,
+		Doc: doc,
+	}
+	m.seq++
+	return nil
+}
+
+// Close waits for all queued documents to be written and returns the number
+// of written documents and the first error which occurred. It can be called
+// more than once.
+func (m *ParallelMarshaler) Close() (int, error) {
+	if !m.closed {
+		m.closed = true
+		close(m.pendings)
+	}
+	<-m.done
+	return m.written, m.getErr()
+}
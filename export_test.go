@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParallelMarshaler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := NewParallelMarshaler(buf, 4)
+	expected := []string{}
+	for i := 0; i < 1000; i++ {
+		doc := map[string]int{"id": i}
+		if err := m.Write(doc); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, fmt.Sprintf(`{"id":%d}`, i))
+	}
+	written, err := m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != len(expected) {
+		t.Fatalf("unexpected written count: %d != %d", written, len(expected))
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("documents were not written in order")
+	}
+}
+
+func TestParallelMarshalerError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := NewParallelMarshaler(buf, 2)
+	m.Write(map[string]int{"id": 0})
+	m.Write(func() {})
+	m.Write(map[string]int{"id": 2})
+	_, err := m.Close()
+	if err == nil {
+		t.Fatalf("marshaling error was not reported")
+	}
+	if buf.String() != "{\"id\":0}\n" {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestParallelMarshalerBuilder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := NewParallelMarshaler(buf, 4)
+	expected := []string{}
+	for i := 0; i < 100; i++ {
+		id := i
+		err := m.Write(DocBuilderFunc(func() (interface{}, error) {
+			if id%3 == 0 {
+				return nil, nil
+			}
+			return map[string]int{"id": id}, nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id%3 != 0 {
+			expected = append(expected, fmt.Sprintf(`{"id":%d}`, id))
+		}
+	}
+	written, err := m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != len(expected) {
+		t.Fatalf("unexpected written count: %d != %d", written, len(expected))
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("documents were not written in order")
+	}
+}
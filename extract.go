@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ExtractStats counts the elements written by extract functions.
+type ExtractStats struct {
+	Relations int
+	Ways      int
+	Nodes     int
+}
+
+func (s *ExtractStats) String() string {
+	return fmt.Sprintf("relations=%d ways=%d nodes=%d", s.Relations, s.Ways,
+		s.Nodes)
+}
+
+// Writes to w the relations of path matching expr, with the ways and nodes
+// they reference, the nodes of these ways included. Referenced relations are
+// only written if they match expr themselves. The input is read three times:
+// relations first to collect way references, ways to collect their nodes,
+// then everything to write the selected elements.
+func extractRelations(path string, expr TagExpr, w *O5MWriter) (
+	*ExtractStats, error) {
+
+	relations := &IdSet{}
+	ways := &IdSet{}
+	nodes := &IdSet{}
+	r, err := OpenOSMReader(path, NodeKind, WayKind)
+	if err != nil {
+		return nil, err
+	}
+	for r.Next() {
+		if r.Kind() != RelationKind {
+			continue
+		}
+		rel := r.Relation()
+		if expr != nil && !expr.Match(rel.Tags) {
+			continue
+		}
+		relations.Add(rel.Id)
+		for _, ref := range rel.Refs {
+			switch ref.Type {
+			case 0:
+				nodes.Add(ref.Id)
+			case 1:
+				ways.Add(ref.Id)
+			}
+		}
+	}
+	r.Close()
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+
+	r, err = OpenOSMReader(path, NodeKind, RelationKind)
+	if err != nil {
+		return nil, err
+	}
+	for r.Next() {
+		if r.Kind() != WayKind {
+			continue
+		}
+		way := r.Way()
+		if !ways.Contains(way.Id) {
+			continue
+		}
+		for _, id := range way.Nodes {
+			nodes.Add(id)
+		}
+	}
+	r.Close()
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+
+	r, err = OpenOSMReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	stats := &ExtractStats{}
+	for r.Next() {
+		switch r.Kind() {
+		case BBoxKind:
+			err = w.WriteBoundingBox(r.BoundingBox())
+		case NodeKind:
+			n := r.Node()
+			if nodes.Contains(n.Id) {
+				stats.Nodes++
+				err = w.WriteNode(n)
+			}
+		case WayKind:
+			way := r.Way()
+			if ways.Contains(way.Id) {
+				stats.Ways++
+				err = w.WriteWay(way)
+			}
+		case RelationKind:
+			rel := r.Relation()
+			if relations.Contains(rel.Id) {
+				stats.Relations++
+				err = w.WriteRelation(rel)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return stats, w.Close()
+}
+
+// Parses a "minlon,minlat,maxlon,maxlat" bounding box, like osmconvert -b
+// option.
+func parseBBoxArg(s string) (BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, fmt.Errorf(
+			"invalid bounding box %q: minlon,minlat,maxlon,maxlat expected", s)
+	}
+	values := []float64{}
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return BoundingBox{}, fmt.Errorf("invalid bounding box %q: %s", s, err)
+		}
+		values = append(values, v)
+	}
+	bb := BoundingBox{
+		X1: values[0],
+		Y1: values[1],
+		X2: values[2],
+		Y2: values[3],
+	}
+	if bb.X1 > bb.X2 || bb.Y1 > bb.Y2 || bb.X1 < -180 || bb.X2 > 180 ||
+		bb.Y1 < -90 || bb.Y2 > 90 {
+		return BoundingBox{}, fmt.Errorf("invalid bounding box %q", s)
+	}
+	return bb, nil
+}
+
+// Returns a function telling whether o5m fixed point coordinates are in bb,
+// borders included.
+func containsInBBox(bb BoundingBox) func(lon, lat int64) bool {
+	x1 := int64(math.Round(bb.X1 * 1e7))
+	y1 := int64(math.Round(bb.Y1 * 1e7))
+	x2 := int64(math.Round(bb.X2 * 1e7))
+	y2 := int64(math.Round(bb.Y2 * 1e7))
+	return func(lon, lat int64) bool {
+		return lon >= x1 && lon <= x2 && lat >= y1 && lat <= y2
+	}
+}
+
+// Writes to w the nodes of path for which contains returns true, the ways
+// referencing at least one of them and the relations referencing these
+// nodes or ways. Like osmconvert without --complete-ways, written ways may
+// reference nodes outside of the area. Elements are sorted by kind, so a
+// single pass is enough. bbox, if not nil, replaces the input bounding box.
+func extractArea(path string, contains func(lon, lat int64) bool,
+	bbox *BoundingBox, w ElementWriter) (*ExtractStats, error) {
+
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if bbox != nil {
+		err = w.WriteBoundingBox(*bbox)
+		if err != nil {
+			return nil, err
+		}
+	}
+	nodes := &IdSet{}
+	ways := &IdSet{}
+	stats := &ExtractStats{}
+	for r.Next() {
+		switch r.Kind() {
+		case BBoxKind:
+			if bbox == nil {
+				err = w.WriteBoundingBox(r.BoundingBox())
+			}
+		case NodeKind:
+			n := r.Node()
+			if contains(n.Lon, n.Lat) {
+				nodes.Add(n.Id)
+				stats.Nodes++
+				err = w.WriteNode(n)
+			}
+		case WayKind:
+			way := r.Way()
+			for _, id := range way.Nodes {
+				if nodes.Contains(id) {
+					ways.Add(way.Id)
+					stats.Ways++
+					err = w.WriteWay(way)
+					break
+				}
+			}
+		case RelationKind:
+			rel := r.Relation()
+			for _, ref := range rel.Refs {
+				if (ref.Type == 0 && nodes.Contains(ref.Id)) ||
+					(ref.Type == 1 && ways.Contains(ref.Id)) {
+					stats.Relations++
+					err = w.WriteRelation(rel)
+					break
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return stats, w.Close()
+}
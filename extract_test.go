@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExtractRelations(t *testing.T) {
+	nodes := []Node{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}, {Id: 5}}
+	ways := []Way{
+		{Id: 10, Nodes: []int64{1, 2, 1}},
+		{Id: 11, Nodes: []int64{3, 4}},
+		{Id: 12, Nodes: []int64{4, 5}},
+	}
+	relations := []Relation{
+		{Id: 20, Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}, {Id: 3, Type: 0, Role: "admin_centre"}},
+			Tags: []StringPair{{Key: "boundary", Value: "administrative"},
+				{Key: "admin_level", Value: "8"}}},
+		{Id: 21, Refs: []Ref{{Id: 12, Type: 1, Role: "outer"}},
+			Tags: []StringPair{{Key: "boundary", Value: "administrative"},
+				{Key: "admin_level", Value: "10"}}},
+		{Id: 22, Refs: []Ref{{Id: 20, Type: 2, Role: "subarea"}, {Id: 11, Type: 1, Role: "outer"}},
+			Tags: []StringPair{{Key: "boundary", Value: "administrative"},
+				{Key: "admin_level", Value: "6"}}},
+	}
+	path := writeTestFile(t, nodes, ways, relations)
+	defer os.Remove(path)
+
+	expr, err := ParseOsmFilter("boundary=administrative and admin_level<=8",
+		"admin_level=6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := ioutil.TempFile("", "osm-extract-*.o5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+	w, err := NewO5MWriter(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := extractRelations(path, expr, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (ExtractStats{Relations: 1, Ways: 1, Nodes: 3}) {
+		t.Fatalf("unexpected stats: %s", stats)
+	}
+
+	r, err := NewO5MReader(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ids := []int64{}
+	for r.Next() {
+		switch r.Kind() {
+		case NodeKind:
+			ids = append(ids, r.Node().Id)
+		case WayKind:
+			ids = append(ids, r.Way().Id)
+		case RelationKind:
+			ids = append(ids, r.Relation().Id)
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 10, 20}) {
+		t.Fatalf("unexpected extracted ids: %v", ids)
+	}
+}
+
+func TestParseBBoxArg(t *testing.T) {
+	bb, err := parseBBoxArg("-1.5, 2,3.25,4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bb != (BoundingBox{X1: -1.5, Y1: 2, X2: 3.25, Y2: 4}) {
+		t.Fatalf("unexpected bounding box: %+v", bb)
+	}
+	for _, s := range []string{"", "1,2,3", "1,2,x,4", "3,2,1,4", "1,-91,2,3"} {
+		if _, err := parseBBoxArg(s); err == nil {
+			t.Fatalf("invalid bounding box accepted: %q", s)
+		}
+	}
+}
+
+func TestExtractArea(t *testing.T) {
+	nodes := []Node{
+		{Id: 1, Lon: 10000000, Lat: 10000000},
+		{Id: 2, Lon: 30000000, Lat: 10000000},
+		{Id: 3, Lon: 20000000, Lat: 20000000},
+		{Id: 4, Lon: -10000000, Lat: 10000000},
+	}
+	ways := []Way{
+		{Id: 10, Nodes: []int64{1, 2}},
+		{Id: 11, Nodes: []int64{2, 4}},
+	}
+	relations := []Relation{
+		{Id: 20, Refs: []Ref{{Id: 11, Type: 1, Role: "outer"}, {Id: 10, Type: 1, Role: "outer"}}},
+		{Id: 21, Refs: []Ref{{Id: 11, Type: 1, Role: "outer"}, {Id: 4, Type: 0, Role: "admin_centre"}}},
+		{Id: 22, Refs: []Ref{{Id: 3, Type: 0, Role: "label"}}},
+	}
+	path := writeTestFile(t, nodes, ways, relations)
+	defer os.Remove(path)
+
+	fp, err := ioutil.TempFile("", "osm-extract-*.o5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+	w, err := NewO5MWriter(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bbox := BoundingBox{X1: 0, Y1: 0, X2: 2, Y2: 2}
+	stats, err := extractArea(path, containsInBBox(bbox), &bbox, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (ExtractStats{Relations: 2, Ways: 1, Nodes: 2}) {
+		t.Fatalf("unexpected stats: %s", stats)
+	}
+	r, err := NewO5MReader(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ids := []int64{}
+	for r.Next() {
+		switch r.Kind() {
+		case BBoxKind:
+			if r.BoundingBox() != bbox {
+				t.Fatalf("unexpected bounding box: %+v", r.BoundingBox())
+			}
+		case NodeKind:
+			ids = append(ids, r.Node().Id)
+		case WayKind:
+			ids = append(ids, r.Way().Id)
+		case RelationKind:
+			ids = append(ids, r.Relation().Id)
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 3, 10, 20, 22}) {
+		t.Fatalf("unexpected extracted ids: %v", ids)
+	}
+}
+
+func TestExtractGeoJSON(t *testing.T) {
+	t.Setenv("OSM_CONFIG", "")
+	nodes := []Node{
+		{Id: 1, Lon: 10000000, Lat: 10000000},
+		{Id: 2, Lon: 30000000, Lat: 10000000},
+	}
+	ways := []Way{{Id: 10, Nodes: []int64{1, 2}}}
+	relations := []Relation{
+		{Id: 20, Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}}},
+	}
+	path := writeTestFile(t, nodes, ways, relations)
+	defer os.Remove(path)
+	dir, err := ioutil.TempDir("", "osm-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The format is guessed from the output extension
+	output := filepath.Join(dir, "extract.geojson")
+	runTestCommand(t, "extract", "--bbox", "0,0,2,2", path, output)
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := struct {
+		Type     string
+		Features []struct {
+			Id       string
+			Geometry *struct {
+				Type        string
+				Coordinates json.RawMessage
+			}
+			Properties map[string]interface{}
+		}
+	}{}
+	if err := json.Unmarshal(data, &collection); err != nil {
+		t.Fatalf("invalid GeoJSON: %s\n%s", err, data)
+	}
+	features := collection.Features
+	if collection.Type != "FeatureCollection" || len(features) != 3 {
+		t.Fatalf("unexpected collection: %s", data)
+	}
+	if features[0].Id != "node/1" || features[0].Geometry.Type != "Point" ||
+		string(features[0].Geometry.Coordinates) != "[1,1]" {
+		t.Fatalf("unexpected node: %+v", features[0])
+	}
+	// Nodes outside the area are missing from the crossing way
+	if features[1].Id != "way/10" ||
+		string(features[1].Geometry.Coordinates) != "[[1,1]]" ||
+		features[1].Properties["missing_nodes"] != 1.0 {
+		t.Fatalf("unexpected way: %+v", features[1])
+	}
+	if features[2].Id != "relation/20" || features[2].Geometry != nil {
+		t.Fatalf("unexpected relation: %+v", features[2])
+	}
+
+	// --format overrides the extension
+	output = filepath.Join(dir, "extract.out")
+	runTestCommand(t, "extract", "--bbox", "0,0,2,2", "--format", "o5m",
+		path, output)
+	r, err := NewO5MReader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	count := 0
+	for r.Next() {
+		switch r.Kind() {
+		case NodeKind, WayKind, RelationKind:
+			count++
+		}
+	}
+	if r.Err() != nil || count != 3 {
+		t.Fatalf("unexpected o5m extract: %d elements, %v", count, r.Err())
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	FormatES         = "es"
+	FormatFeatures   = "features"
+	FormatCollection = "collection"
+)
+
+// FeatureGeometry is a RFC 7946 geometry.
+type FeatureGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates [][][][]float64 `json:"coordinates"`
+}
+
+// Feature is a RFC 7946 feature.
+type Feature struct {
+	Type       string                 `json:"type"`
+	Id         string                 `json:"id"`
+	BBox       []float64              `json:"bbox,omitempty"`
+	Geometry   FeatureGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// BBox accumulates the [west, south, east, north] extent of coordinates.
+type BBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+	empty                          bool
+}
+
+func NewBBox() *BBox {
+	return &BBox{
+		MinLon: math.Inf(1),
+		MinLat: math.Inf(1),
+		MaxLon: math.Inf(-1),
+		MaxLat: math.Inf(-1),
+		empty:  true,
+	}
+}
+
+func (b *BBox) Add(lon, lat float64) {
+	b.MinLon = math.Min(b.MinLon, lon)
+	b.MinLat = math.Min(b.MinLat, lat)
+	b.MaxLon = math.Max(b.MaxLon, lon)
+	b.MaxLat = math.Max(b.MaxLat, lat)
+	b.empty = false
+}
+
+func (b *BBox) AddMultiPolygon(coords [][][][]float64) {
+	for _, poly := range coords {
+		for _, ring := range poly {
+			for _, p := range ring {
+				b.Add(p[0], p[1])
+			}
+		}
+	}
+}
+
+func (b *BBox) Merge(other *BBox) {
+	if other.empty {
+		return
+	}
+	b.Add(other.MinLon, other.MinLat)
+	b.Add(other.MaxLon, other.MaxLat)
+}
+
+// Returns the bbox as a RFC 7946 bbox member, or nil if it is empty.
+func (b *BBox) Slice() []float64 {
+	if b.empty {
+		return nil
+	}
+	return []float64{b.MinLon, b.MinLat, b.MaxLon, b.MaxLat}
+}
+
+// Converts an exported relation into a RFC 7946 feature. The returned bbox
+// is the feature extent.
+func makeFeature(js *RelationJson) (*Feature, *BBox) {
+	bbox := NewBBox()
+	bbox.AddMultiPolygon(js.Location.Coordinates)
+	props := map[string]interface{}{
+		"name":         js.Name,
+		"center":       []float64{js.Center.Lon, js.Center.Lat},
+		"area_km2":     js.AreaKm2,
+		"perimeter_km": js.PerimeterKm,
+	}
+	if js.Wikidata != "" {
+		props["wikidata"] = js.Wikidata
+	}
+	if js.Wikipedia != "" {
+		props["wikipedia"] = js.Wikipedia
+	}
+	if js.Names != nil {
+		props["names"] = js.Names
+	}
+	if js.Center.Source != "" {
+		props["center_source"] = js.Center.Source
+	}
+	if js.AdminLevel > 0 {
+		props["admin_level"] = js.AdminLevel
+	}
+	if js.CountryIso2 != "" {
+		props["country_iso2"] = js.CountryIso2
+	}
+	if js.CountryIso3 != "" {
+		props["country_iso3"] = js.CountryIso3
+	}
+	if js.AdminCentre != nil {
+		props["admin_centre"] = js.AdminCentre
+	}
+	if js.Population > 0 {
+		props["population"] = js.Population
+		props["population_source"] = js.PopulationSource
+	}
+	if js.Timezone != "" {
+		props["timezone"] = js.Timezone
+		props["timezone_source"] = js.TimezoneSource
+	}
+	if js.Parents != nil {
+		props["parents"] = js.Parents
+		props["parent_path"] = js.ParentPath
+	}
+	if js.ProtectedArea != nil {
+		props["protected_area"] = js.ProtectedArea
+	}
+	tags := map[string]string{}
+	for _, tag := range js.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	props["tags"] = tags
+	if js.Meta != nil {
+		props["meta"] = js.Meta
+	}
+	return &Feature{
+		Type: "Feature",
+		Id:   js.Id,
+		BBox: bbox.Slice(),
+		Geometry: FeatureGeometry{
+			Type:        "MultiPolygon",
+			Coordinates: js.Location.Coordinates,
+		},
+		Properties: props,
+	}, bbox
+}
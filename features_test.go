@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFeatureBBox(t *testing.T) {
+	js := &RelationJson{
+		Id:   "1",
+		Name: "test",
+		Location: Location{
+			Type: "multipolygon",
+			Coordinates: [][][][]float64{
+				{{{0, 0}, {2, 0}, {2, 1}, {0, 0}}},
+				{{{-3, 4}, {-2, 5}, {-3, 5}, {-3, 4}}},
+			},
+		},
+	}
+	f, bbox := makeFeature(js)
+	expected := []float64{-3, 0, 2, 5}
+	if !reflect.DeepEqual(f.BBox, expected) {
+		t.Fatalf("unexpected feature bbox: %v", f.BBox)
+	}
+	all := NewBBox()
+	if all.Slice() != nil {
+		t.Fatalf("empty bbox is not nil")
+	}
+	all.Merge(bbox)
+	other := NewBBox()
+	other.Add(10, -10)
+	all.Merge(other)
+	if !reflect.DeepEqual(all.Slice(), []float64{-3, -10, 10, 5}) {
+		t.Fatalf("unexpected merged bbox: %v", all.Slice())
+	}
+	if f.Geometry.Type != "MultiPolygon" || f.Type != "Feature" {
+		t.Fatalf("invalid feature types: %s, %s", f.Type, f.Geometry.Type)
+	}
+}
+
+func TestFeatureAdminCentrePopulation(t *testing.T) {
+	n := &Node{
+		Id:  12,
+		Lon: 57200000,
+		Lat: 451900000,
+		Tags: []StringPair{
+			{Key: "name", Value: "Grenoble"},
+			{Key: "place", Value: "city"},
+			{Key: "capital", Value: "6"},
+			{Key: "population", Value: "158 198"},
+		},
+	}
+	centre := makeAdminCentre(n, "admin_centre")
+	expected := &AdminCentre{NodeId: 12, Role: "admin_centre", Name: "Grenoble",
+		Lon: 5.72, Lat: 45.19, Place: "city", Capital: "6", Population: 158198}
+	if !reflect.DeepEqual(centre, expected) {
+		t.Fatalf("unexpected admin centre: %+v", centre)
+	}
+
+	js := &RelationJson{Id: "1", AdminCentre: centre}
+	mergeAdminCentre(js)
+	f, _ := makeFeature(js)
+	if f.Properties["population"] != int64(158198) ||
+		f.Properties["population_source"] != "admin_centre" {
+		t.Fatalf("admin centre population was not merged: %v", f.Properties)
+	}
+	// The relation population tag wins
+	js = &RelationJson{Id: "1", AdminCentre: centre, Population: 160000,
+		PopulationSource: PopulationSourceRelation}
+	mergeAdminCentre(js)
+	if js.Population != 160000 || js.PopulationSource != PopulationSourceRelation {
+		t.Fatalf("relation population was overridden: %d, %s", js.Population,
+			js.PopulationSource)
+	}
+	f, _ = makeFeature(&RelationJson{Id: "1", AdminCentre: &AdminCentre{}})
+	if _, ok := f.Properties["population"]; ok {
+		t.Fatalf("unknown population was exported: %v", f.Properties)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	GeofabrikURL = "https://download.geofabrik.de"
+)
+
+// Returns the URL of the latest PBF extract of a Geofabrik region like
+// "europe/france".
+func geofabrikRegionURL(baseURL, region string) (string, error) {
+	region = strings.Trim(region, "/")
+	if region == "" || strings.Contains(region, "..") {
+		return "", fmt.Errorf("invalid region: %q", region)
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + region + "-latest.osm.pbf",
+		nil
+}
+
+// Parses md5sum formatted content, returning the first checksum.
+func parseMd5File(data string) (string, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum file")
+	}
+	sum := strings.ToLower(fields[0])
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != md5.Size {
+		return "", fmt.Errorf("invalid md5 checksum: %q", fields[0])
+	}
+	return sum, nil
+}
+
+func httpGet(url string) (*http.Response, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("cannot fetch %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+func fetchMd5(url string) (string, error) {
+	resp, err := httpGet(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	return parseMd5File(string(data))
+}
+
+// Downloads url into outPath, checking its content against the md5 checksum
+// published next to it. outPath is only created once the download is
+// complete and verified.
+func downloadVerified(url, outPath string) (int64, error) {
+	expected, err := fetchMd5(url + ".md5")
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpGet(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	out, err := CreateOutputFile(outPath, CompressNone)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Abort()
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(out, h), resp.Body)
+	if err != nil {
+		return n, err
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		return n, fmt.Errorf("checksum mismatch for %s: expected %s, got %s",
+			url, expected, actual)
+	}
+	return n, out.Commit()
+}
+
+// Converts a PBF file to o5m, with osmconvert if not empty or with the
+// convert command otherwise.
+func convertToO5m(osmconvert, pbfPath, o5mPath string) error {
+	if osmconvert == "" {
+		_, err := convertFile(pbfPath, o5mPath, ConvertO5M, CompressNone,
+			make([]bool, RelationKind+1))
+		return err
+	}
+	cmd := exec.Command(osmconvert, pbfPath, "-o="+o5mPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		os.Remove(o5mPath)
+		return fmt.Errorf("osmconvert failed: %s", err)
+	}
+	return nil
+}
+
+// Returns the default file name of a region download, "france-latest.osm.pbf"
+// for "europe/france".
+func regionFileName(url string) string {
+	return path.Base(url)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeofabrikRegionURL(t *testing.T) {
+	url, err := geofabrikRegionURL(GeofabrikURL+"/", "/europe/france/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://download.geofabrik.de/europe/france-latest.osm.pbf" {
+		t.Fatalf("unexpected url: %s", url)
+	}
+	if regionFileName(url) != "france-latest.osm.pbf" {
+		t.Fatalf("unexpected file name: %s", regionFileName(url))
+	}
+	for _, invalid := range []string{"", "/", "../etc"} {
+		if _, err := geofabrikRegionURL(GeofabrikURL, invalid); err == nil {
+			t.Fatalf("invalid region accepted: %q", invalid)
+		}
+	}
+}
+
+func TestDownloadVerified(t *testing.T) {
+	data := "some pbf data"
+	sum := "1df536b0c710e01501289bee63660560"
+	files := map[string]string{
+		"/good.osm.pbf":     data,
+		"/good.osm.pbf.md5": sum + "  good.osm.pbf\n",
+		"/bad.osm.pbf":      data + "!",
+		"/bad.osm.pbf.md5":  sum + "  bad.osm.pbf\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			content, ok := files[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(content))
+		}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "osm-fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "good.osm.pbf")
+	n, err := downloadVerified(srv.URL+"/good.osm.pbf", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || string(written) != data {
+		t.Fatalf("unexpected downloaded content: %q", written)
+	}
+
+	out = filepath.Join(dir, "bad.osm.pbf")
+	if _, err := downloadVerified(srv.URL+"/bad.osm.pbf", out); err == nil {
+		t.Fatalf("checksum mismatch not detected")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("corrupted download was kept")
+	}
+	if _, err := downloadVerified(srv.URL+"/missing.osm.pbf", out); err == nil {
+		t.Fatalf("missing file not reported")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// TagExpr is a boolean expression evaluated against element tags.
+type TagExpr interface {
+	Match(tags []StringPair) bool
+	String() string
+}
+
+func findTag(tags []StringPair, key string) (string, bool) {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+type andExpr struct {
+	Left, Right TagExpr
+}
+
+func (e *andExpr) Match(tags []StringPair) bool {
+	return e.Left.Match(tags) && e.Right.Match(tags)
+}
+
+func (e *andExpr) String() string {
+	return fmt.Sprintf("(%s and %s)", e.Left, e.Right)
+}
+
+type orExpr struct {
+	Left, Right TagExpr
+}
+
+func (e *orExpr) Match(tags []StringPair) bool {
+	return e.Left.Match(tags) || e.Right.Match(tags)
+}
+
+func (e *orExpr) String() string {
+	return fmt.Sprintf("(%s or %s)", e.Left, e.Right)
+}
+
+type notExpr struct {
+	Expr TagExpr
+}
+
+func (e *notExpr) Match(tags []StringPair) bool {
+	return !e.Expr.Match(tags)
+}
+
+func (e *notExpr) String() string {
+	return fmt.Sprintf("not %s", e.Expr)
+}
+
+var (
+	// Longest operators first
+	tagOperators = []string{"!=", "<=", ">=", "=", "<", ">"}
+)
+
+// tagTerm compares a tag value with a constant. "key=*" and a bare "key"
+// match when the tag exists, "key!=*" when it does not. Ordering operators
+// compare numerically and fail on non-numeric values.
+type tagTerm struct {
+	Key   string
+	Op    string
+	Value string
+	num   float64
+}
+
+func parseTagTerm(s string) (*tagTerm, error) {
+	for _, op := range tagOperators {
+		pos := strings.Index(s, op)
+		if pos < 0 {
+			continue
+		}
+		t := &tagTerm{
+			Key:   s[:pos],
+			Op:    op,
+			Value: s[pos+len(op):],
+		}
+		if t.Key == "" {
+			return nil, fmt.Errorf("missing key in %q", s)
+		}
+		if op != "=" && op != "!=" {
+			n, err := strconv.ParseFloat(t.Value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s expects a number in %q", op, s)
+			}
+			t.num = n
+		}
+		return t, nil
+	}
+	return &tagTerm{Key: s, Op: "=", Value: "*"}, nil
+}
+
+func (t *tagTerm) Match(tags []StringPair) bool {
+	v, ok := findTag(tags, t.Key)
+	switch t.Op {
+	case "=":
+		return ok && (t.Value == "*" || v == t.Value)
+	case "!=":
+		if t.Value == "*" {
+			return !ok
+		}
+		return !ok || v != t.Value
+	}
+	if !ok {
+		return false
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return false
+	}
+	switch t.Op {
+	case "<":
+		return n < t.num
+	case "<=":
+		return n <= t.num
+	case ">":
+		return n > t.num
+	case ">=":
+		return n >= t.num
+	}
+	return false
+}
+
+func (t *tagTerm) String() string {
+	return t.Key + t.Op + t.Value
+}
+
+func tokenizeTagExpr(s string) []string {
+	tokens := []string{}
+	current := []rune{}
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, string(current))
+			current = current[:0]
+		}
+	}
+	for _, c := range s {
+		if unicode.IsSpace(c) {
+			flush()
+		} else if c == '(' || c == ')' {
+			flush()
+			tokens = append(tokens, string(c))
+		} else {
+			current = append(current, c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type tagExprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagExprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagExprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// or := and ("or" and)*
+func (p *tagExprParser) parseOr() (TagExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.ToLower(p.peek()) == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left, right}
+	}
+	return left, nil
+}
+
+// and := unary ("and" unary)*
+func (p *tagExprParser) parseAnd() (TagExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.ToLower(p.peek()) == "and" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left, right}
+	}
+	return left, nil
+}
+
+// unary := "not" unary | "(" or ")" | term
+func (p *tagExprParser) parseUnary() (TagExpr, error) {
+	t := p.next()
+	switch strings.ToLower(t) {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "not":
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e}, nil
+	case "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return e, nil
+	case ")", "and", "or":
+		return nil, fmt.Errorf("unexpected token: %s", t)
+	}
+	return parseTagTerm(t)
+}
+
+// Parses a tag expression like:
+//
+//	boundary=protected_area and (protect_class=2 or protect_class=3)
+//
+// Terms are "key", "key=value", "key=*", "key!=value" or numeric comparisons
+// like "admin_level<=8". They are combined with "and", "or", "not" and
+// parentheses. "and" has precedence over "or".
+func ParseTagExpr(s string) (TagExpr, error) {
+	p := &tagExprParser{
+		tokens: tokenizeTagExpr(s),
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %s", s, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected token: %s",
+			s, p.peek())
+	}
+	return e, nil
+}
+
+var (
+	// When set, relations are selected by this expression instead of the
+	// administrative boundaries rules of ignoreRelation.
+	keepFilter TagExpr
+)
+
+// Sets keepFilter from expr. In protected areas mode, expr further restricts
+// the selected protected areas.
+func setKeepFilter(expr string, protected bool) error {
+	protectedAreas = protected
+	if protected {
+		if expr == "" {
+			expr = protectedAreasExpr
+		} else {
+			expr = "(" + protectedAreasExpr + ") and (" + expr + ")"
+		}
+	}
+	if expr == "" {
+		keepFilter = nil
+		return nil
+	}
+	e, err := ParseTagExpr(expr)
+	if err != nil {
+		return err
+	}
+	keepFilter = e
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestTagExpr(t *testing.T) {
+	tags := []StringPair{
+		{Key: "boundary", Value: "protected_area"},
+		{Key: "protect_class", Value: "2"},
+		{Key: "name", Value: "Vanoise"},
+	}
+	tests := []struct {
+		Expr  string
+		Match bool
+	}{
+		{"boundary=protected_area", true},
+		{"boundary=administrative", false},
+		{"boundary=protected_area and protect_class=2", true},
+		{"boundary=protected_area and protect_class=3", false},
+		{"protect_class=3 or name=Vanoise", true},
+		{"not boundary=administrative", true},
+		{"name", true},
+		{"admin_level", false},
+		{"admin_level=*", false},
+		{"admin_level!=*", true},
+		{"name!=Vanoise", false},
+		{"protect_class<=2 and protect_class>1", true},
+		{"protect_class<2", false},
+		{"name<2", false},
+		{"boundary=x or boundary=y and name=Vanoise", false},
+		{"(boundary=x or boundary=protected_area) and name=Vanoise", true},
+		{"NOT (protect_class>=2 AND name)", false},
+	}
+	for _, test := range tests {
+		e, err := ParseTagExpr(test.Expr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Expr, err)
+		}
+		if e.Match(tags) != test.Match {
+			t.Fatalf("%s: expected %v, parsed as %s", test.Expr, test.Match, e)
+		}
+	}
+	for _, invalid := range []string{"", "a=b and", "(a=b", "a=b)", "=b",
+		"a<b", "and a=b", "a=b c=d"} {
+		if _, err := ParseTagExpr(invalid); err == nil {
+			t.Fatalf("invalid expression accepted: %q", invalid)
+		}
+	}
+}
+
+func TestProtectedAreasFilter(t *testing.T) {
+	defer setKeepFilter("", false)
+	park := []StringPair{
+		{Key: "boundary", Value: "national_park"},
+		{Key: "operator", Value: "Parcs nationaux de France"},
+	}
+	admin := []StringPair{
+		{Key: "boundary", Value: "administrative"},
+	}
+	err := setKeepFilter("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !protectedAreas || !keepFilter.Match(park) || keepFilter.Match(admin) {
+		t.Fatalf("invalid protected areas filter: %s", keepFilter)
+	}
+	err = setKeepFilter("operator=other", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepFilter.Match(park) {
+		t.Fatalf("user expression ignored: %s", keepFilter)
+	}
+	err = setKeepFilter("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protectedAreas || keepFilter != nil {
+		t.Fatalf("protected areas mode not reset")
+	}
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/pmezard/gogeos/geos"
 )
 
 type Point struct {
@@ -14,24 +13,14 @@ type Point struct {
 	Lat int64 `json:"lat"`
 }
 
-type NodePoint struct {
-	Id    int64
-	Point Point
-}
-
-type NodePoints []NodePoint
-
-func (points NodePoints) FindPoint(id int64) (NodePoint, error) {
-	i := sort.Search(len(points), func(i int) bool {
-		return points[i].Id >= id
-	})
-	if i == len(points) {
-		return NodePoint{}, fmt.Errorf("cannot resolve node: %d", id)
-	}
-	return points[i], nil
+type nodeAppender interface {
+	Append(id int64, p Point) error
+	Len() int
 }
 
-func buildNodeArray(r *O5MReader) (NodePoints, error) {
+// Counts the nodes of r, then appends them to the store returned by
+// newPoints. r is left at the beginning of ways.
+func collectNodes(r OSMReader, newPoints func(count int) nodeAppender) error {
 	// Count nodes
 	resets := []ResetPoint{}
 	count := 0
@@ -43,53 +32,104 @@ func buildNodeArray(r *O5MReader) (NodePoints, error) {
 			}
 		} else if r.Kind() == NodeKind {
 			if len(resets) == 0 {
-				return nil, fmt.Errorf("node found before first reset")
+				return fmt.Errorf("node found before first reset")
 			}
 			count += 1
 		}
 	}
 	if r.Err() != nil {
-		return nil, r.Err()
+		return r.Err()
 	}
 	if len(resets) != 2 {
-		return nil, fmt.Errorf("more or less than 2 resets until nodes end")
+		return fmt.Errorf("more or less than 2 resets until nodes end")
 	}
 
 	// Collect nodes
-	points := make([]NodePoint, count)
+	points := newPoints(count)
 	err := r.Seek(resets[0])
 	if err != nil {
-		return nil, err
+		return err
 	}
-	i := 0
 	for r.Next() {
 		if r.Kind() != NodeKind {
 			continue
 		}
 		n := r.Node()
-		points[i] = NodePoint{
-			Id: n.Id,
-			Point: Point{
-				Lon: n.Lon,
-				Lat: n.Lat,
-			},
-		}
-		if i > 0 && points[i-1].Id >= points[i].Id {
-			return nil, fmt.Errorf("nodes are not sorted by id: %d >= %d",
-				points[i-1].Id, points[i].Id)
-		}
-		i += 1
-		if i == len(points) {
+		err := points.Append(n.Id, Point{
+			Lon: n.Lon,
+			Lat: n.Lat,
+		})
+		if err != nil {
+			return err
+		}
+		if points.Len() == count {
 			break
 		}
 	}
 	if r.Err() != nil {
-		return nil, r.Err()
+		return r.Err()
 	}
-	if i != len(points) {
-		return nil, fmt.Errorf("could not collect all nodes")
+	if points.Len() != count {
+		return fmt.Errorf("could not collect all nodes")
 	}
-	return NodePoints(points), r.Seek(resets[1])
+	return r.Seek(resets[1])
+}
+
+func buildNodeArray(r OSMReader) (*NodePoints, error) {
+	var points *NodePoints
+	err := collectNodes(r, func(count int) nodeAppender {
+		points = NewNodePoints(count)
+		return points
+	})
+	return points, err
+}
+
+// Like buildNodeArray but stores nodes in a file at path, with cacheSize
+// blocks cached in memory.
+func buildDiskNodeArray(r OSMReader, path string, cacheSize int) (
+	*DiskNodePoints, error) {
+
+	points, err := NewDiskNodePoints(path, cacheSize)
+	if err != nil {
+		return nil, err
+	}
+	err = collectNodes(r, func(count int) nodeAppender {
+		return points
+	})
+	if err == nil {
+		err = points.Flush()
+	}
+	if err != nil {
+		points.Close()
+		return nil, err
+	}
+	return points, nil
+}
+
+// Like buildNodeArray but stores nodes in db, with cacheSize blocks cached
+// in memory. Nodes stored by a previous run are reused if keep is true.
+func buildDenseNodeArray(r OSMReader, db *WaysDb, cacheSize int, keep bool) (
+	*DenseNodePoints, error) {
+
+	points, err := NewDenseNodePoints(db, cacheSize)
+	if err != nil {
+		return nil, err
+	}
+	if keep {
+		err = r.SeekToKind(WayKind)
+		return points, err
+	}
+	err = points.Clear()
+	if err != nil {
+		return nil, err
+	}
+	err = collectNodes(r, func(count int) nodeAppender {
+		return points
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points, points.Flush()
 }
 
 var (
@@ -100,7 +140,7 @@ var (
 	}
 )
 
-func buildGeometry(rings []*Linestring) ([]*geos.Geometry, error) {
+func buildGeometry(rings []*Linestring) ([]Geometry, error) {
 	// Bail out on non-ring inputs
 	for _, ring := range rings {
 		if ring.Role == "inner" || ring.Role == "outer" || ring.Role == "" {
@@ -112,7 +152,10 @@ func buildGeometry(rings []*Linestring) ([]*geos.Geometry, error) {
 			return nil, fmt.Errorf("unsupported ring role: %s", ring.Role)
 		}
 	}
-	all, err := makeRings(rings)
+	// Rings points only live until they are converted into geometries
+	arena := getPointArena()
+	defer putPointArena(arena)
+	all, err := makeRingsIn(rings, arena)
 	if err != nil {
 		return nil, err
 	}
@@ -122,29 +165,18 @@ func buildGeometry(rings []*Linestring) ([]*geos.Geometry, error) {
 type Location struct {
 	Type        string          `json:"type"`
 	Coordinates [][][][]float64 `json:"coordinates"`
+	// Geodesic measures, exported as relation properties since
+	// Elasticsearch rejects unknown geo_shape members
+	AreaKm2     float64 `json:"-"`
+	PerimeterKm float64 `json:"-"`
 }
 
-func linearRingToJson(r *geos.Geometry) ([][]float64, error) {
-	typ, err := r.Type()
-	if typ != geos.LINEARRING {
-		return nil, err
-	}
-	pointCount, err := r.NPoint()
-	if err != nil {
-		return nil, err
-	}
-	if pointCount <= 0 {
-		return nil, fmt.Errorf("empty linear ring")
-	}
-	coords, err := r.Coords()
-	if err != nil {
-		return nil, fmt.Errorf("cannot get coordinates: %s", err)
-	}
+func coordsToJson(coords []Coord) [][]float64 {
 	ring := make([][]float64, len(coords))
 	for j, p := range coords {
 		ring[j] = []float64{p.X, p.Y}
 	}
-	return ring, nil
+	return ring
 }
 
 func isClockwise(ring [][]float64) bool {
@@ -171,52 +203,60 @@ func reverseJsonRing(ring [][]float64) {
 	}
 }
 
-func polygonsToJson(polygons []*geos.Geometry) (*Location, error) {
+// Ring orientations of exported multipolygons. es and rfc7946 both orient
+// outer rings counter-clockwise and holes clockwise, the RFC 7946 right-hand
+// rule which is also Elasticsearch default. preserve keeps the orientation of
+// the geometry backend.
+const (
+	WindingES       = "es"
+	WindingRFC7946  = "rfc7946"
+	WindingPreserve = "preserve"
+)
+
+var (
+	windingOrder = WindingES
+)
+
+// Returns coords with rings oriented following winding. Reversed rings are
+// copied, coords is not modified.
+func orientMultiPolygon(coords [][][][]float64, winding string) [][][][]float64 {
+	if winding == WindingPreserve {
+		return coords
+	}
+	shapes := make([][][][]float64, len(coords))
+	for i, poly := range coords {
+		rings := make([][][]float64, len(poly))
+		for j, ring := range poly {
+			// Outer rings counter-clockwise, holes clockwise
+			if isClockwise(ring) == (j == 0) {
+				reversed := make([][]float64, len(ring))
+				copy(reversed, ring)
+				reverseJsonRing(reversed)
+				ring = reversed
+			}
+			rings[j] = ring
+		}
+		shapes[i] = rings
+	}
+	return shapes
+}
+
+// Converts polygons to a location, keeping the backend rings orientation.
+// Exports orient them with orientMultiPolygon.
+func polygonsToJson(polygons []Geometry) (*Location, error) {
 	loc := &Location{
 		Type: "multipolygon",
 	}
 	shapes := [][][][]float64{}
 	for _, g := range polygons {
-		typ, err := g.Type()
-		if err != nil {
-			return nil, err
-		}
-		if typ != geos.POLYGON {
-			return nil, fmt.Errorf("cannot handle geometry type: %d", typ)
-		}
-		geomCount, err := g.NGeometry()
-		if err != nil {
-			return nil, err
-		}
-		if geomCount <= 0 {
-			return nil, fmt.Errorf("empty geometry")
-		}
-		shell, err := g.Shell()
-		if err != nil {
-			return nil, err
-		}
-		holes, err := g.Holes()
+		shell, holes, err := g.Rings()
 		if err != nil {
 			return nil, err
 		}
 		rings := make([][][]float64, 0, len(holes)+1)
-		inner, err := linearRingToJson(shell)
-		if err != nil {
-			return nil, fmt.Errorf("cannot extract inner ring: %s", err)
-		}
-		if isClockwise(inner) {
-			reverseJsonRing(inner)
-		}
-		rings = append(rings, inner)
+		rings = append(rings, coordsToJson(shell))
 		for _, hole := range holes {
-			outer, err := linearRingToJson(hole)
-			if err != nil {
-				return nil, fmt.Errorf("cannot extract outer ring: %s", err)
-			}
-			if !isClockwise(outer) {
-				reverseJsonRing(outer)
-			}
-			rings = append(rings, outer)
+			rings = append(rings, coordsToJson(hole))
 		}
 		shapes = append(shapes, rings)
 	}
@@ -225,34 +265,83 @@ func polygonsToJson(polygons []*geos.Geometry) (*Location, error) {
 }
 
 type RelationJson struct {
-	Id          string `json:"id"`
-	Name        string `json:"name"`
-	AdminLevel  int    `json:"admin_level,omitempty"`
-	CountryIso2 string `json:"country_iso2,omitempty"`
-	CountryIso3 string `json:"country_iso3,omitempty"`
+	Id          string     `json:"id"`
+	Name        string     `json:"name"`
+	Names       *NamesJson `json:"names,omitempty"`
+	AdminLevel  int        `json:"admin_level,omitempty"`
+	CountryIso2 string     `json:"country_iso2,omitempty"`
+	CountryIso3 string     `json:"country_iso3,omitempty"`
+	Wikidata    string     `json:"wikidata,omitempty"`
+	Wikipedia   string     `json:"wikipedia,omitempty"`
 	Center      struct {
-		Lon float64 `json:"lon"`
-		Lat float64 `json:"lat"`
+		Lon    float64 `json:"lon"`
+		Lat    float64 `json:"lat"`
+		Source string  `json:"source,omitempty"`
 	} `json:"center"`
-	Location Location     `json:"shape"`
-	Tags     []StringPair `json:"tags"`
+	AdminCentre *AdminCentre `json:"admin_centre,omitempty"`
+	// Population of the boundary, from its population tag or estimated
+	// with its admin centre one, as told by PopulationSource.
+	Population       int64  `json:"population,omitempty"`
+	PopulationSource string `json:"population_source,omitempty"`
+	// Timezone of the boundary, from its timezone tag or the timezone
+	// boundaries containing its center, as told by TimezoneSource.
+	Timezone       string             `json:"timezone,omitempty"`
+	TimezoneSource string             `json:"timezone_source,omitempty"`
+	Parents        []AdminAreaJson    `json:"parents,omitempty"`
+	ParentPath     string             `json:"parent_path,omitempty"`
+	AreaKm2        float64            `json:"area_km2"`
+	PerimeterKm    float64            `json:"perimeter_km"`
+	Location       Location           `json:"shape"`
+	ProtectedArea  *ProtectedAreaJson `json:"protected_area,omitempty"`
+	Tags           []StringPair       `json:"tags"`
+	Meta           *MetadataJson      `json:"meta,omitempty"`
 }
 
+const (
+	DuplicateTagsFirst = "first"
+	DuplicateTagsLast  = "last"
+	DuplicateTagsError = "error"
+)
+
+var (
+	// How NewRelationTags handles keys appearing more than once. Tags
+	// patched by patchTags come last and override the original ones with the
+	// default policy.
+	duplicateTagsPolicy = DuplicateTagsLast
+	// Douglas-Peucker tolerance in degrees applied to ways before assembling
+	// rings, zero to disable. Adjacent boundaries share ways, simplifying
+	// them individually keeps common borders identical.
+	waySimplifyTolerance = 0.
+	// Distance in degrees under which unmatched way endpoints are snapped
+	// together before assembling rings, zero to disable.
+	ringSnapTolerance = 0.
+)
+
 type RelationTags struct {
 	tags map[string]string
+	// Keys which appeared more than once, in order of appearance
+	Duplicates []string
 }
 
 func NewRelationTags(rel *Relation) (*RelationTags, error) {
 	tags := patchTags(rel)
 	dict := map[string]string{}
+	duplicates := []string(nil)
 	for _, tag := range tags {
 		if _, ok := dict[tag.Key]; ok {
-			return nil, fmt.Errorf("duplicate tag: %s=%s", tag.Key, tag.Value)
+			if duplicateTagsPolicy == DuplicateTagsError {
+				return nil, fmt.Errorf("duplicate tag: %s=%s", tag.Key, tag.Value)
+			}
+			duplicates = append(duplicates, tag.Key)
+			if duplicateTagsPolicy == DuplicateTagsFirst {
+				continue
+			}
 		}
 		dict[tag.Key] = tag.Value
 	}
 	return &RelationTags{
-		tags: dict,
+		tags:       dict,
+		Duplicates: duplicates,
 	}, nil
 }
 
@@ -312,16 +401,30 @@ func makeJsonRelation(rel *Relation, center *Centroid, loc *Location) (
 		Id:       strconv.Itoa(int(rel.Id)),
 		Location: *loc,
 	}
+	r.Location.Coordinates = orientMultiPolygon(loc.Coordinates, windingOrder)
+	if loc.AreaKm2 == 0 {
+		// Locations built before measures were stored
+		measureLocation(&r.Location)
+	}
+	r.AreaKm2 = r.Location.AreaKm2
+	r.PerimeterKm = r.Location.PerimeterKm
 	r.Center.Lon = center.Lon
 	r.Center.Lat = center.Lat
+	r.Center.Source = center.Source
 
 	tags, err := NewRelationTags(rel)
 	if err != nil {
 		return nil, err
 	}
 	r.Name = tags.Name()
+	r.Names = makeNamesJson(rel.Tags, nameLanguages)
 	level, levelStr := tags.AdminLevel()
-	if level < 1 || level > 11 {
+	if keepFilter != nil {
+		// Arbitrary selections are not necessarily administrative boundaries
+		if level >= 1 && level <= 11 {
+			r.AdminLevel = level
+		}
+	} else if level < minAdminLevel || level > maxAdminLevel {
 		placeType := tags.PlaceType()
 		if placeType != "city" && placeType != "town" {
 			return nil, fmt.Errorf("unexpected admin_level: %s", levelStr)
@@ -331,7 +434,24 @@ func makeJsonRelation(rel *Relation, center *Centroid, loc *Location) (
 	}
 	r.CountryIso2 = tags.CountryIso2()
 	r.CountryIso3 = tags.CountryIso3()
+	if wikidata, ok := checkWikidata(tags.Tag("wikidata")); ok {
+		r.Wikidata = wikidata
+	} else {
+		slog.Warn("invalid wikidata tag", relationAttr(rel),
+			"value", tags.Tag("wikidata"))
+	}
+	r.Wikipedia = strings.TrimSpace(tags.Tag("wikipedia"))
+	if population := parsePopulation(tags.Tag("population")); population > 0 {
+		r.Population = population
+		r.PopulationSource = PopulationSourceRelation
+	}
+	if protectedAreas {
+		r.ProtectedArea = makeProtectedAreaJson(tags)
+	}
 	r.Tags = append(r.Tags, rel.Tags...)
+	if exportMetadata {
+		r.Meta = makeMetadataJson(&rel.Meta)
+	}
 	return r, nil
 }
 
@@ -406,6 +526,9 @@ func collectWayGeometries(wayIds []Ref, db *WaysDb) ([]*Linestring, error) {
 		}
 		// People insist on typing "Outer" instead of "outer".
 		ring.Role = strings.ToLower(ref.Role)
+		if waySimplifyTolerance > 0 {
+			ring.Points = simplifyPoints(ring.Points, waySimplifyTolerance)
+		}
 		rings = append(rings, ring)
 	}
 	return rings, nil
@@ -458,37 +581,34 @@ func isMultilineString(rel *Relation) bool {
 }
 
 func patchRings(rel *Relation, rings []*Linestring) []*Linestring {
-	if rel.Id != 1362232 {
+	rule := patchRules.Get(rel.Id)
+	if rule == nil {
 		return rings
 	}
-	// Metropolitan France polygon is not closed
-	rings = append(rings,
-		&Linestring{
-			Id: 0,
-			Points: []Point{
-				{-17641958, 433431448},
-				{-17668244, 433425557},
-			},
-		},
-		&Linestring{
-			Id: 1,
-			Points: []Point{
-				{37501395, 434237009},
-				{37469067, 434193643},
-			},
+	// Some polygons are not closed
+	for i, points := range rule.Segments {
+		rings = append(rings, &Linestring{
+			Id:     int64(i),
+			Points: append([]Point{}, points...),
 		})
+	}
 	return rings
 }
 
-func buildSpecialRelations(rel *Relation, db *WaysDb) ([]*geos.Geometry, error) {
-	if rel.Id != 11980 {
+// Returns true if rel geometry is built from its "subarea" relations.
+func isSubareaRelation(rel *Relation) bool {
+	rule := patchRules.Get(rel.Id)
+	return rule != nil && rule.Subareas
+}
+
+func buildSpecialRelations(rel *Relation, db *WaysDb) ([]Geometry, error) {
+	if !isSubareaRelation(rel) {
 		return nil, nil
 	}
-	// France (11980)
-	// The main France relation is build from subrelations with "subarea" role.
-	// Usually subareas are ignored but in this case we want to build the
-	// geometry from them.
-	geoms := []*geos.Geometry{}
+	// Some relations, like France (11980), are built from subrelations with
+	// "subarea" role. Usually subareas are ignored but in this case we want
+	// to build the geometry from them.
+	geoms := []Geometry{}
 	for _, ref := range rel.Refs {
 		if ref.Type != 2 || ref.Role != "subarea" {
 			continue
@@ -501,7 +621,7 @@ func buildSpecialRelations(rel *Relation, db *WaysDb) ([]*geos.Geometry, error)
 			// Ignore missing relations to handle non-planet files
 			continue
 		}
-		fmt.Printf("Processing subrelation %s(%d)\n", sub.Name(), sub.Id)
+		slog.Debug("processing subrelation", relationAttr(sub))
 		parts, err := buildRelationPolygons(sub, db)
 		if err != nil {
 			return nil, fmt.Errorf("cannot build subrelation %s(%d): %s",
@@ -516,12 +636,12 @@ func isRecursiveRelation(rel *Relation) bool {
 	// In general, geometries are only built from the ways contained by the
 	// relation. For historical reasons there seems to be a few exceptions,
 	// where we have to extract the ways recursively from inner and outer
-	// sub-relations.
-	return rel.Id == 1111111 || // Germany
-		rel.Id == 1362232 // France metropolitaine
+	// sub-relations, like Germany (1111111) or Metropolitan France (1362232).
+	rule := patchRules.Get(rel.Id)
+	return rule != nil && rule.Recursive
 }
 
-func buildRelationPolygons(rel *Relation, db *WaysDb) ([]*geos.Geometry, error) {
+func buildRelationPolygons(rel *Relation, db *WaysDb) ([]Geometry, error) {
 	// Collect way and relation ids and sort them
 	wayIds, relIds, err := collectWayRefs(rel)
 	if err != nil {
@@ -538,6 +658,19 @@ func buildRelationPolygons(rel *Relation, db *WaysDb) ([]*geos.Geometry, error)
 		}
 		rings = append(rings, subRings...)
 	}
+	rings, dropped := dedupLines(rings)
+	if dropped > 0 {
+		slog.Warn("dropped duplicate ways", relationAttr(rel), "count", dropped)
+	}
+	bridged := snapEndpoints(rings, ringSnapTolerance)
+	if bridged > 0 {
+		slog.Info("bridged ring gaps", relationAttr(rel), "count", bridged)
+	}
+	rings, repeated := removeRepeatedPoints(rings)
+	if repeated > 0 {
+		slog.Warn("removed repeated points", relationAttr(rel),
+			"count", repeated)
+	}
 	rings = patchRings(rel, rings)
 	return buildGeometry(rings)
 }
@@ -633,18 +766,8 @@ var (
 		"ezzouhour",
 		"bir ali ben khalifa",
 	}
-	_BOUNDARIES = map[string]bool{}
 )
 
-func init() {
-	for _, key := range _ACCEPTED_BOUNDARIES {
-		_BOUNDARIES[key] = true
-	}
-	for _, key := range _REJECTED_BOUNDARIES {
-		_BOUNDARIES[key] = false
-	}
-}
-
 func copyTags(tags []StringPair) []StringPair {
 	other := make([]StringPair, len(tags))
 	copy(other, tags)
@@ -653,120 +776,123 @@ func copyTags(tags []StringPair) []StringPair {
 
 func patchTags(rel *Relation) []StringPair {
 	tags := rel.Tags
-	if rel.Id == 937244 {
-		// Belgium
-		tags = copyTags(tags)
-		tags = append(tags,
-			StringPair{"ISO3166-1:alpha2", "BE"},
-			StringPair{"ISO3166-1:alpha3", "BEL"})
-	} else if rel.Id == 1711283 {
-		// Jersey
+	if rule := patchRules.Get(rel.Id); rule != nil && len(rule.Tags) > 0 {
 		tags = copyTags(tags)
-		tags = append(tags,
-			StringPair{"ISO3166-1:alpha2", "JE"},
-			StringPair{"ISO3166-1:alpha3", "JEY"})
-	} else if rel.Id == 6571872 {
-		// Guernsey
-		tags = copyTags(tags)
-		tags = append(tags,
-			StringPair{"ISO3166-1:alpha2", "GG"},
-			StringPair{"ISO3166-1:alpha3", "GBG"})
-	} else if rel.Id == 2850940 || rel.Id == 4263589 {
-		// Philippines
-		tags = copyTags(tags)
-		tags = append(tags,
-			StringPair{"ISO3166-1:alpha2", "PH"},
-			StringPair{"ISO3166-1:alpha3", "PHL"})
+		tags = append(tags, rule.Tags...)
 	}
-
 	return tags
 }
 
+var (
+	// Range of admin_level values of processed boundaries, set by
+	// --min-admin-level and --max-admin-level. City and town places are
+	// processed at any level.
+	minAdminLevel = 1
+	maxAdminLevel = 8
+)
+
+// Returns an error unless min..max is a valid, non-empty admin_level range.
+func checkAdminLevelRange(min, max int) error {
+	if min < 1 || max > 11 || min > max {
+		return fmt.Errorf("invalid admin level range: %d..%d, expected "+
+			"levels within 1..11", min, max)
+	}
+	return nil
+}
+
+// Reasons returned by getIgnoreReason
+const (
+	IgnoreDuplicate   = "duplicate"
+	IgnoreExcluded    = "excluded"
+	IgnoreType        = "relation type"
+	IgnoreKeepFilter  = "keep filter"
+	IgnoreAdminLevel  = "admin level"
+	IgnoreNoName      = "no name"
+	IgnoreBoundary    = "rejected boundary"
+	IgnoreInvalidTags = "invalid tags"
+)
+
 func ignoreRelation(rel *Relation) (bool, error) {
+	reason, err := getIgnoreReason(rel)
+	return reason != "", err
+}
+
+// Returns why a relation should be ignored, or an empty string if it should
+// be processed.
+func getIgnoreReason(rel *Relation) (string, error) {
 	rt, err := NewRelationTags(rel)
 	if err != nil {
-		return true, err
-	}
-	switch rel.Id {
-	case 2202162, 11980:
-		// France has 2 representation, with and without water areas. Let's
-		// keep the second one (11980).
-		return rel.Id != 11980, nil
-	case 1401905:
-		// Tuamotu-Gambier(1401905)[level=7]
-		// Crashes indexlocations somewhere in a geos finalizer
-		return true, nil
-	case 62781, 51477:
-		// Germany has 3 relations of admin_level=2
-		// 51477: outer ways without linestrings
-		// 62781: landmass only (no water area)
-		// 111111: outer ways with linestring
-		// Let's keep the last one for no special reason but we have to pick
-		// one.
-		return true, nil
-	case 1124039:
-		// Monaco has 2 representations, with and without water areas. Keep the
-		// one without water areas (36990)
-		return true, nil
-	case 936128:
-		// Poland, we used to keep 936128 because it had only land areas but
-		// 49715 has more attributes and seems to be more maintained.
-		return true, nil
-	case 52411:
-		// Belgium, keep the land mass (937244)
-		// TODO: keep the other, the tags are more interesting
-		return true, nil
-	case 1711283:
-		// Ignore Jersey land area
-		return true, nil
-	case 270009:
-		// Keep Guernsey land mass (6571872)
-		return true, nil
-	case 2850940, 4263589:
-		// Ignore Philippines maritime boundary and continental shell. Keep 443174.
-		return true, nil
-	case 5441968:
-		// Sahrawi Arab Democratic Republic, disputed, no iso code, ignore it
-		return true, nil
-	case 3263728:
-		// British Sovereign Base Areas, disputed, ignore them
-		return true, nil
-	case 6858045:
-		// Liberland, because it does not really exist
-		return true, nil
+		return IgnoreInvalidTags, err
+	}
+	if duplicateRelations[rel.Id] {
+		return IgnoreDuplicate, nil
+	}
+	if rule := patchRules.Get(rel.Id); rule != nil {
+		if rule.Ignore {
+			return IgnoreExcluded, nil
+		}
+		if rule.IgnoreBackend != "" {
+			if backend, _ := geometryBackend(); backend == rule.IgnoreBackend {
+				return IgnoreExcluded, nil
+			}
+		}
+		if rule.Keep {
+			return "", nil
+		}
 	}
 	typ := rt.Tag("type")
 	if typ == "collection" || typ == "multilinestring" {
-		return true, nil
+		return IgnoreType, nil
+	}
+	if keepFilter != nil {
+		if !keepFilter.Match(rel.Tags) {
+			return IgnoreKeepFilter, nil
+		}
+		return "", nil
 	}
 	level, _ := rt.AdminLevel()
-	if level < 1 || level > 8 {
+	if level < minAdminLevel || level > maxAdminLevel {
 		placeType := rt.PlaceType()
 		if placeType != "city" && placeType != "town" {
-			return true, nil
+			return IgnoreAdminLevel, nil
 		}
 	}
 	if rt.Name() == "" {
-		return true, nil
+		return IgnoreNoName, nil
 	}
 	boundary := strings.ToLower(rt.Tag("boundary"))
 	if len(boundary) > 0 {
-		accepted, found := _BOUNDARIES[boundary]
+		accepted, found := boundaryLists.Lookup(boundary)
 		if !found {
-			return true, fmt.Errorf("unknown boundary value for %s: '%s'",
-				rel.String(), boundary)
+			switch unknownBoundaryPolicy {
+			case UnknownBoundaryAccept:
+				accepted = true
+			case UnknownBoundaryError:
+				return IgnoreBoundary, fmt.Errorf(
+					"unknown boundary value for %s: '%s'", rel.String(), boundary)
+			}
 		}
 		if !accepted {
-			return true, nil
+			return IgnoreBoundary, nil
 		}
 	}
-	return false, nil
+	return "", nil
 }
 
 func buildLocation(rel *Relation, db *WaysDb) (*Location, error) {
+	return buildLocationTo(rel, db, db)
+}
+
+// Builds relation location from ways stored in db and writes it, with the
+// relation, in out.
+func buildLocationTo(rel *Relation, db, out *WaysDb) (*Location, error) {
 	if ok, err := ignoreRelation(rel); ok || err != nil {
 		return nil, err
 	}
+	if rt, err := NewRelationTags(rel); err == nil && len(rt.Duplicates) > 0 {
+		slog.Warn("duplicate tags", relationAttr(rel),
+			"tags", strings.Join(rt.Duplicates, ", "))
+	}
 	polygons, err := buildSpecialRelations(rel, db)
 	if err != nil {
 		return nil, err
@@ -777,6 +903,10 @@ func buildLocation(rel *Relation, db *WaysDb) (*Location, error) {
 			return nil, err
 		}
 	}
+	polygons, err = clipCountryToLand(rel, polygons)
+	if err != nil {
+		return nil, err
+	}
 	loc, err := polygonsToJson(polygons)
 	if err != nil {
 		return nil, err
@@ -784,10 +914,134 @@ func buildLocation(rel *Relation, db *WaysDb) (*Location, error) {
 	if loc == nil {
 		return nil, nil
 	}
-	err = db.PutLocation(rel.Id, loc)
+	measureLocation(loc)
+	hash, err := hashLocationMembers(rel, db)
+	if err != nil {
+		return nil, err
+	}
+	err = out.PutLocation(rel.Id, loc)
+	if err != nil {
+		return nil, err
+	}
+	// indexrelations only stores sub-relations, commands reading the
+	// boundaries from the db need their names and levels too.
+	err = out.PutRelation(rel)
+	if err != nil {
+		return nil, err
+	}
+	err = out.PutLocationBuild(rel.Id, &LocationBuild{
+		Version: locationBuildVersion,
+		Hash:    hash,
+	})
 	return loc, err
 }
 
+// Version of the location building code. Increment it when a fix changes
+// built geometries, so indexlocations --rebuild refreshes existing locations.
+const locationBuildVersion = 1
+
+// LocationBuild records how a location was built.
+type LocationBuild struct {
+	// locationBuildVersion of the code which built the location
+	Version int `json:"version"`
+	// Digest of the members, see hashLocationMembers
+	Hash string `json:"hash"`
+}
+
+// Returns a digest of what rel location is built from: rel members and tags,
+// the geometry of its ways in db and, for recursive relations, the members
+// of its sub-relations. Way versions are not stored and would miss moved
+// nodes anyway. Missing members are hashed as such so their later
+// appearance changes the digest.
+func hashLocationMembers(rel *Relation, db *WaysDb) (string, error) {
+	h := NewContentHasher()
+	seen := map[int64]bool{}
+	var add func(rel *Relation, recursive bool) error
+	add = func(rel *Relation, recursive bool) error {
+		seen[rel.Id] = true
+		h.AddRelation(rel)
+		for _, ref := range rel.Refs {
+			switch {
+			case ref.Type == 1:
+				ls, err := db.Get(ref.Id)
+				if err != nil {
+					return err
+				}
+				if ls == nil {
+					ls = &Linestring{Id: ref.Id}
+				}
+				h.AddLinestring(ls)
+			case ref.Type == 2 && recursive && !seen[ref.Id]:
+				sub, err := db.GetRelation(ref.Id)
+				if err != nil {
+					return err
+				}
+				if sub == nil {
+					sub = &Relation{Id: ref.Id}
+				}
+				// Like collectRelationWays, sub-relations are always
+				// walked recursively
+				err = add(sub, true)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := add(rel, isRecursiveRelation(rel)); err != nil {
+		return "", err
+	}
+	return h.Sum(), nil
+}
+
+const (
+	// Members changed or are unknown
+	LocationStale = "stale"
+	// Built by an older locationBuildVersion
+	LocationOutdated = "outdated"
+)
+
+// Returns why rel existing location in db must be rebuilt, or an empty
+// string if it is fresh. Outdated locations are only reported when
+// checkVersion is set.
+func getLocationRebuildReason(rel *Relation, db *WaysDb, checkVersion bool) (
+	string, error) {
+
+	build, err := db.GetLocationBuild(rel.Id)
+	if err != nil || build == nil {
+		return LocationStale, err
+	}
+	hash, err := hashLocationMembers(rel, db)
+	if err != nil {
+		return "", err
+	}
+	if hash != build.Hash {
+		return LocationStale, nil
+	}
+	if checkVersion && build.Version < locationBuildVersion {
+		return LocationOutdated, nil
+	}
+	return "", nil
+}
+
+const (
+	PopulationSourceRelation = "relation"
+)
+
+// Completes js with the population of its admin centre node, when the
+// relation does not tell it. Population sources are then the node role,
+// admin_centre or label: consumers can tell a municipality estimated with
+// its town from a county with its seat.
+func mergeAdminCentre(js *RelationJson) {
+	c := js.AdminCentre
+	if c == nil || js.Population > 0 || c.Population <= 0 {
+		return
+	}
+	js.Population = c.Population
+	js.PopulationSource = c.Role
+}
+
 func buildRelation(rel *Relation, db *WaysDb) (
 	*RelationJson, error) {
 
@@ -805,5 +1059,26 @@ func buildRelation(rel *Relation, db *WaysDb) (
 	if center == nil {
 		return nil, nil
 	}
-	return makeJsonRelation(rel, center, loc)
+	js, err := makeJsonRelation(rel, center, loc)
+	if err != nil {
+		return nil, err
+	}
+	js.AdminCentre, err = db.GetAdminCentre(rel.Id)
+	if err != nil {
+		return nil, err
+	}
+	mergeAdminCentre(js)
+	parents, err := db.GetParents(rel.Id)
+	if err != nil {
+		return nil, err
+	}
+	if parents != nil {
+		js.Parents = parents
+		js.ParentPath = formatParentPath(parents, js.Name)
+	}
+	if attachTimezones {
+		js.Timezone, js.TimezoneSource = findTimezone(js.Tags,
+			timezoneBoundaries, js.Center.Lon, js.Center.Lat)
+	}
+	return js, nil
 }
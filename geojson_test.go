@@ -1,6 +1,13 @@
 package main
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func makeSegment(id int64, p1, p2 Point) *Linestring {
 	return &Linestring{
@@ -53,3 +60,192 @@ func TestCloseRings(t *testing.T) {
 		}
 	}
 }
+
+func TestDuplicateRelationTags(t *testing.T) {
+	rel := &Relation{
+		Id: 1,
+		Tags: []StringPair{
+			{Key: "name", Value: "first"},
+			{Key: "admin_level", Value: "8"},
+			{Key: "name", Value: "last"},
+		},
+	}
+	defer func() {
+		duplicateTagsPolicy = DuplicateTagsLast
+	}()
+	for _, policy := range []string{DuplicateTagsFirst, DuplicateTagsLast} {
+		duplicateTagsPolicy = policy
+		rt, err := NewRelationTags(rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.Name() != policy {
+			t.Fatalf("%s: unexpected name: %s", policy, rt.Name())
+		}
+		if len(rt.Duplicates) != 1 || rt.Duplicates[0] != "name" {
+			t.Fatalf("%s: unexpected duplicates: %v", policy, rt.Duplicates)
+		}
+	}
+	duplicateTagsPolicy = DuplicateTagsError
+	if _, err := NewRelationTags(rel); err == nil {
+		t.Fatalf("duplicate tags were accepted")
+	}
+}
+
+func TestOrientMultiPolygon(t *testing.T) {
+	// Clockwise shell with a counter-clockwise hole
+	coords := [][][][]float64{{
+		{{0, 0}, {0, 4}, {4, 4}, {4, 0}, {0, 0}},
+		{{1, 1}, {2, 1}, {2, 2}, {1, 2}, {1, 1}},
+	}}
+	for _, winding := range []string{WindingES, WindingRFC7946} {
+		oriented := orientMultiPolygon(coords, winding)
+		if isClockwise(oriented[0][0]) || !isClockwise(oriented[0][1]) {
+			t.Fatalf("%s: unexpected orientation: %v", winding, oriented)
+		}
+	}
+	if !isClockwise(coords[0][0]) || isClockwise(coords[0][1]) {
+		t.Fatalf("input was modified: %v", coords)
+	}
+	preserved := orientMultiPolygon(coords, WindingPreserve)
+	if !reflect.DeepEqual(preserved, coords) {
+		t.Fatalf("orientation was not preserved: %v", preserved)
+	}
+}
+
+func TestGetLocationRebuildReason(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+
+	square := func(size int64) *Linestring {
+		return &Linestring{Id: 10, Points: []Point{{0, 0}, {size, 0},
+			{size, size}, {0, size}, {0, 0}}}
+	}
+	if err := db.Put(square(1e7)); err != nil {
+		t.Fatal(err)
+	}
+	rel := &Relation{
+		Id:   100,
+		Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}},
+		Tags: []StringPair{
+			{Key: "type", Value: "boundary"},
+			{Key: "boundary", Value: "administrative"},
+			{Key: "admin_level", Value: "8"},
+			{Key: "name", Value: "square"},
+		},
+	}
+	checkStale := func(expected bool) {
+		t.Helper()
+		reason, err := getLocationRebuildReason(rel, db, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (reason == LocationStale) != expected {
+			t.Fatalf("unexpected rebuild reason: %q", reason)
+		}
+	}
+	// Locations built before builds were stored
+	if err := db.PutLocation(rel.Id, &Location{Type: "MultiPolygon"}); err != nil {
+		t.Fatal(err)
+	}
+	checkStale(true)
+	if _, err := buildLocation(rel, db); err != nil {
+		t.Fatal(err)
+	}
+	checkStale(false)
+
+	// Moved nodes and new members invalidate the location
+	if err := db.Put(square(2e7)); err != nil {
+		t.Fatal(err)
+	}
+	checkStale(true)
+	if _, err := buildLocation(rel, db); err != nil {
+		t.Fatal(err)
+	}
+	checkStale(false)
+	build, err := db.GetLocationBuild(rel.Id)
+	if err != nil || build == nil || build.Version != locationBuildVersion {
+		t.Fatalf("unexpected location build: %+v, %v", build, err)
+	}
+
+	// Locations built by older versions are only reported on demand
+	err = db.PutLocationBuild(rel.Id, &LocationBuild{
+		Version: locationBuildVersion - 1,
+		Hash:    build.Hash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, checkVersion := range []bool{false, true} {
+		reason, err := getLocationRebuildReason(rel, db, checkVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (reason == LocationOutdated) != checkVersion {
+			t.Fatalf("unexpected rebuild reason: %v %q", checkVersion, reason)
+		}
+	}
+	rel.Refs = append(rel.Refs, Ref{Id: 11, Type: 1, Role: "inner"})
+	checkStale(true)
+
+	// Builds are dropped with their location
+	if err := db.DeleteLocation(rel.Id); err != nil {
+		t.Fatal(err)
+	}
+	if build, err := db.GetLocationBuild(rel.Id); err != nil || build != nil {
+		t.Fatalf("location build was kept: %+v, %v", build, err)
+	}
+}
+
+func TestReindexRebuildsChangedLocations(t *testing.T) {
+	dir, _, path := runTestPipeline(t)
+	defer os.RemoveAll(dir)
+	defer func() { runSummary = NewCommandSummary() }()
+
+	// The region grows on the newer input, the country is unchanged
+	input := filepath.Join(dir, "newer.osm")
+	data := strings.Replace(testPipelineOsm,
+		`<node id="7" lat="3" lon="3"/>`, `<node id="7" lat="3.5" lon="3.5"/>`, 1)
+	if data == testPipelineOsm {
+		t.Fatalf("test input was not changed")
+	}
+	err := ioutil.WriteFile(input, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"indexways", "indexrelations"} {
+		runTestCommand(t, cmd, input, path)
+	}
+	runSummary = NewCommandSummary()
+	runTestCommand(t, "indexlocations", input, path)
+	if runSummary.Counts["converted"] != 1 ||
+		runSummary.Counts[LocationStale] != 1 ||
+		runSummary.Skipped["existing"] != 1 {
+		t.Fatalf("unexpected rebuilds: %v, skipped %v", runSummary.Counts,
+			runSummary.Skipped)
+	}
+
+	db, err := OpenWaysDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	loc, err := db.GetLocation(101)
+	if err != nil || loc == nil {
+		t.Fatalf("cannot get region location: %v %v", loc, err)
+	}
+	found := false
+	for _, p := range loc.Coordinates[0][0] {
+		found = found || (p[0] == 3.5 && p[1] == 3.5)
+	}
+	if !found {
+		t.Fatalf("region was not rebuilt: %v", loc.Coordinates)
+	}
+	// Skipped locations keep their relation
+	for _, id := range []int64{100, 101} {
+		rel, err := db.GetRelation(id)
+		if err != nil || rel == nil {
+			t.Fatalf("relation %d is missing: %v", id, err)
+		}
+	}
+}
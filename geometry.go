@@ -0,0 +1,53 @@
+package main
+
+// Polygon operations are delegated to a geometry backend selected at build
+// time. GEOS is used by default, building with "-tags purego" replaces it
+// with a pure Go implementation, dropping the cgo dependency. Backends
+// provide:
+//
+//	newPolygon(shell []Coord, holes ...[]Coord) (Geometry, error)
+//	newValidPolygon(ring []Coord) (Geometry, error)
+//	unaryUnion(geoms []Geometry) (Geometry, error)
+//	isSimpleRing(ring []Coord) bool
+//	geometryBackend() (name, version string)
+//
+// newValidPolygon builds a polygon from a possibly self-intersecting ring,
+// like GEOS Buffer(0). isSimpleRing tells whether ring is closed and does not
+// intersect itself.
+
+type Coord struct {
+	X, Y float64
+}
+
+// Geometry is a polygon or a multipolygon.
+type Geometry interface {
+	Area() (float64, error)
+	// Contains follows the OGC definition, other must not have points in the
+	// geometry exterior and must share at least one interior point.
+	Contains(other Geometry) (bool, error)
+	// ContainsPoint returns true if c is in the geometry interior.
+	ContainsPoint(c Coord) (bool, error)
+	Intersection(other Geometry) (Geometry, error)
+	Difference(other Geometry) (Geometry, error)
+	// Rings returns the shell and holes of a single polygon, and fails on
+	// other geometries. Rings are closed.
+	Rings() ([]Coord, [][]Coord, error)
+	// Polygons splits the geometry into single polygons. Parts without
+	// area, like the lines of an intersection collection, are dropped.
+	Polygons() ([]Geometry, error)
+}
+
+func pointToCoord(p Point) Coord {
+	return Coord{
+		X: float64(p.Lon) / 1e7,
+		Y: float64(p.Lat) / 1e7,
+	}
+}
+
+func pointsToCoords(points []Point) []Coord {
+	coords := make([]Coord, len(points))
+	for i, p := range points {
+		coords[i] = pointToCoord(p)
+	}
+	return coords
+}
@@ -0,0 +1,236 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pmezard/gogeos/geos"
+)
+
+// geosGeometry is the GEOS geometry backend.
+type geosGeometry struct {
+	g *geos.Geometry
+}
+
+func toGeosCoords(coords []Coord) []geos.Coord {
+	result := make([]geos.Coord, len(coords))
+	for i, c := range coords {
+		result[i] = geos.Coord{X: c.X, Y: c.Y}
+	}
+	return result
+}
+
+func fromGeosRing(r *geos.Geometry) ([]Coord, error) {
+	typ, err := r.Type()
+	if err != nil {
+		return nil, err
+	}
+	if typ != geos.LINEARRING {
+		return nil, fmt.Errorf("cannot handle geometry type: %d", typ)
+	}
+	pointCount, err := r.NPoint()
+	if err != nil {
+		return nil, err
+	}
+	if pointCount <= 0 {
+		return nil, fmt.Errorf("empty linear ring")
+	}
+	coords, err := r.Coords()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get coordinates: %s", err)
+	}
+	ring := make([]Coord, len(coords))
+	for i, c := range coords {
+		ring[i] = Coord{X: c.X, Y: c.Y}
+	}
+	return ring, nil
+}
+
+func toGeos(other Geometry) (*geos.Geometry, error) {
+	g, ok := other.(*geosGeometry)
+	if !ok {
+		return nil, fmt.Errorf("not a GEOS geometry: %T", other)
+	}
+	return g.g, nil
+}
+
+func geometryBackend() (string, string) {
+	return "geos", geos.Version()
+}
+
+func newPolygon(shell []Coord, holes ...[]Coord) (Geometry, error) {
+	geosHoles := make([][]geos.Coord, len(holes))
+	for i, h := range holes {
+		geosHoles[i] = toGeosCoords(h)
+	}
+	g, err := geos.NewPolygon(toGeosCoords(shell), geosHoles...)
+	if err != nil {
+		return nil, err
+	}
+	return &geosGeometry{g}, nil
+}
+
+func newValidPolygon(ring []Coord) (Geometry, error) {
+	poly, err := geos.NewPolygon(toGeosCoords(ring))
+	if err != nil {
+		return nil, err
+	}
+	// Poor man's solution to handle invalid polygons
+	g, err := poly.Buffer(0)
+	if err != nil {
+		return nil, err
+	}
+	return &geosGeometry{g}, nil
+}
+
+func unaryUnion(geoms []Geometry) (Geometry, error) {
+	parts := make([]*geos.Geometry, len(geoms))
+	for i, g := range geoms {
+		p, err := toGeos(g)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = p
+	}
+	collection, err := geos.NewCollection(geos.MULTIPOLYGON, parts...)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := collection.UnaryUnion()
+	if err != nil {
+		return nil, err
+	}
+	return &geosGeometry{merged}, nil
+}
+
+func isSimpleRing(coords []Coord) bool {
+	ring, err := geos.NewLinearRing(toGeosCoords(coords)...)
+	if err != nil {
+		return false
+	}
+	if ok, err := ring.IsRing(); err != nil || !ok {
+		return false
+	}
+	if ok, err := ring.IsSimple(); err != nil || !ok {
+		return false
+	}
+	return true
+}
+
+func (g *geosGeometry) Area() (float64, error) {
+	return g.g.Area()
+}
+
+func (g *geosGeometry) Contains(other Geometry) (bool, error) {
+	o, err := toGeos(other)
+	if err != nil {
+		return false, err
+	}
+	return g.g.Contains(o)
+}
+
+func (g *geosGeometry) ContainsPoint(c Coord) (bool, error) {
+	p, err := geos.NewPoint(geos.Coord{X: c.X, Y: c.Y})
+	if err != nil {
+		return false, err
+	}
+	return g.g.Contains(p)
+}
+
+func (g *geosGeometry) Intersection(other Geometry) (Geometry, error) {
+	o, err := toGeos(other)
+	if err != nil {
+		return nil, err
+	}
+	inter, err := g.g.Intersection(o)
+	if err != nil {
+		return nil, err
+	}
+	return &geosGeometry{inter}, nil
+}
+
+func (g *geosGeometry) Difference(other Geometry) (Geometry, error) {
+	o, err := toGeos(other)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := g.g.Difference(o)
+	if err != nil {
+		return nil, err
+	}
+	return &geosGeometry{diff}, nil
+}
+
+func (g *geosGeometry) Rings() ([]Coord, [][]Coord, error) {
+	typ, err := g.g.Type()
+	if err != nil {
+		return nil, nil, err
+	}
+	if typ != geos.POLYGON {
+		return nil, nil, fmt.Errorf("cannot handle geometry type: %d", typ)
+	}
+	geomCount, err := g.g.NGeometry()
+	if err != nil {
+		return nil, nil, err
+	}
+	if geomCount <= 0 {
+		return nil, nil, fmt.Errorf("empty geometry")
+	}
+	shell, err := g.g.Shell()
+	if err != nil {
+		return nil, nil, err
+	}
+	holes, err := g.g.Holes()
+	if err != nil {
+		return nil, nil, err
+	}
+	outer, err := fromGeosRing(shell)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot extract inner ring: %s", err)
+	}
+	inners := make([][]Coord, 0, len(holes))
+	for _, hole := range holes {
+		inner, err := fromGeosRing(hole)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot extract outer ring: %s", err)
+		}
+		inners = append(inners, inner)
+	}
+	return outer, inners, nil
+}
+
+func (g *geosGeometry) Polygons() ([]Geometry, error) {
+	typ, err := g.g.Type()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case geos.POLYGON:
+		empty, err := g.g.IsEmpty()
+		if err != nil || empty {
+			return nil, err
+		}
+		return []Geometry{g}, nil
+	case geos.MULTIPOLYGON, geos.GEOMETRYCOLLECTION:
+	default:
+		return nil, nil
+	}
+	count, err := g.g.NGeometry()
+	if err != nil {
+		return nil, err
+	}
+	polygons := []Geometry{}
+	for i := 0; i < count; i++ {
+		part, err := g.g.Geometry(i)
+		if err != nil {
+			return nil, err
+		}
+		parts, err := (&geosGeometry{part}).Polygons()
+		if err != nil {
+			return nil, err
+		}
+		polygons = append(polygons, parts...)
+	}
+	return polygons, nil
+}
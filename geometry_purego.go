@@ -0,0 +1,43 @@
+//go:build purego
+
+package main
+
+func geometryBackend() (string, string) {
+	return "purego", ""
+}
+
+func newPolygon(shell []Coord, holes ...[]Coord) (Geometry, error) {
+	g, err := newPurePolygon(shell, holes...)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func newValidPolygon(ring []Coord) (Geometry, error) {
+	g, err := newValidPurePolygon(ring)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func unaryUnion(geoms []Geometry) (Geometry, error) {
+	parts := make([]*pureGeometry, len(geoms))
+	for i, g := range geoms {
+		p, err := toPure(g)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = p
+	}
+	merged, err := unaryPureUnion(parts)
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func isSimpleRing(ring []Coord) bool {
+	return isSimplePureRing(ring)
+}
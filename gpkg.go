@@ -0,0 +1,336 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// GeoPackage output, see https://www.geopackage.org/spec120/. A GeoPackage is
+// an SQLite database with metadata tables describing feature tables and
+// their coordinate systems. Geometries are stored as WKB prefixed by a small
+// header, and each feature table gets an R-tree spatial index.
+const (
+	gpkgApplicationId = 0x47504B47 // "GPKG"
+	gpkgVersion       = 10200
+	// Little-endian header with a XY envelope
+	gpkgFlagsEnvelope = 0x03
+	// Little-endian header without envelope
+	gpkgFlagsNoEnvelope = 0x01
+	gpkgFlagEmpty       = 0x10
+	gpkgRtreeExtension  = "http://www.geopackage.org/spec120/#extension_rtree"
+)
+
+var (
+	gpkgSchema = []string{
+		fmt.Sprintf("PRAGMA application_id = %d", gpkgApplicationId),
+		fmt.Sprintf("PRAGMA user_version = %d", gpkgVersion),
+		`CREATE TABLE gpkg_spatial_ref_sys (
+			srs_name TEXT NOT NULL,
+			srs_id INTEGER NOT NULL PRIMARY KEY,
+			organization TEXT NOT NULL,
+			organization_coordsys_id INTEGER NOT NULL,
+			definition TEXT NOT NULL,
+			description TEXT)`,
+		`CREATE TABLE gpkg_contents (
+			table_name TEXT NOT NULL PRIMARY KEY,
+			data_type TEXT NOT NULL,
+			identifier TEXT UNIQUE,
+			description TEXT DEFAULT '',
+			last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+			min_x DOUBLE,
+			min_y DOUBLE,
+			max_x DOUBLE,
+			max_y DOUBLE,
+			srs_id INTEGER,
+			CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id))`,
+		`CREATE TABLE gpkg_geometry_columns (
+			table_name TEXT NOT NULL,
+			column_name TEXT NOT NULL,
+			geometry_type_name TEXT NOT NULL,
+			srs_id INTEGER NOT NULL,
+			z TINYINT NOT NULL,
+			m TINYINT NOT NULL,
+			CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+			CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+			CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id))`,
+		`CREATE TABLE gpkg_extensions (
+			table_name TEXT,
+			column_name TEXT,
+			extension_name TEXT NOT NULL,
+			definition TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name))`,
+		`INSERT INTO gpkg_spatial_ref_sys VALUES
+			('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', NULL),
+			('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', NULL),
+			('WGS 84 geodetic', 4326, 'EPSG', 4326, '` + gpkgWGS84 + `', NULL)`,
+	}
+	gpkgWGS84 = `GEOGCS["WGS 84",DATUM["WGS_1984",` +
+		`SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],` +
+		`AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],` +
+		`UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],` +
+		`AUTHORITY["EPSG","4326"]]`
+)
+
+// Appends the GeoPackage binary header of a geometry whose extent is bbox.
+// Points do not need an envelope, pass nil.
+func appendGpkgHeader(buf []byte, bbox *BBox) []byte {
+	flags := byte(gpkgFlagsNoEnvelope)
+	if bbox != nil {
+		flags = gpkgFlagsEnvelope
+		if bbox.empty {
+			flags = gpkgFlagsNoEnvelope | gpkgFlagEmpty
+		}
+	}
+	buf = append(buf, 'G', 'P', 0, flags)
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, sridWGS84)
+	buf = append(buf, b...)
+	if flags == gpkgFlagsEnvelope {
+		buf = appendWKBFloat(buf, bbox.MinLon)
+		buf = appendWKBFloat(buf, bbox.MaxLon)
+		buf = appendWKBFloat(buf, bbox.MinLat)
+		buf = appendWKBFloat(buf, bbox.MaxLat)
+	}
+	return buf
+}
+
+// gpkgExecer is the part of *sql.Tx used to fill the GeoPackage.
+type gpkgExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type gpkgLayer struct {
+	table    string
+	geomType string
+	bbox     *BBox
+	count    int
+}
+
+// Returns the name of a layer holding features of adminLevel, or relations
+// without level if it is zero.
+func gpkgLayerName(prefix string, adminLevel int) string {
+	if adminLevel <= 0 {
+		return prefix
+	}
+	return prefix + "_" + strconv.Itoa(adminLevel)
+}
+
+// gpkgBuilder writes relations boundaries and centroids into one pair of
+// layers per admin_level, created on first use.
+type gpkgBuilder struct {
+	ex     gpkgExecer
+	layers map[string]*gpkgLayer
+	order  []*gpkgLayer
+}
+
+func newGpkgBuilder(ex gpkgExecer) (*gpkgBuilder, error) {
+	for _, q := range gpkgSchema {
+		if _, err := ex.Exec(q); err != nil {
+			return nil, fmt.Errorf("cannot create GeoPackage schema: %s", err)
+		}
+	}
+	return &gpkgBuilder{
+		ex:     ex,
+		layers: map[string]*gpkgLayer{},
+	}, nil
+}
+
+func (b *gpkgBuilder) layer(table, geomType string) (*gpkgLayer, error) {
+	if l, ok := b.layers[table]; ok {
+		return l, nil
+	}
+	queries := []string{
+		fmt.Sprintf(`CREATE TABLE "%s" (
+			fid INTEGER PRIMARY KEY AUTOINCREMENT,
+			geom %s,
+			osm_id TEXT,
+			name TEXT,
+			admin_level INTEGER,
+			iso2 TEXT,
+			iso3 TEXT)`, table, geomType),
+		fmt.Sprintf(`INSERT INTO gpkg_contents (table_name, data_type, identifier, srs_id)
+			VALUES ('%s', 'features', '%s', %d)`, table, table, sridWGS84),
+		fmt.Sprintf(`INSERT INTO gpkg_geometry_columns VALUES
+			('%s', 'geom', '%s', %d, 0, 0)`, table, geomType, sridWGS84),
+		fmt.Sprintf(`CREATE VIRTUAL TABLE "rtree_%s_geom"
+			USING rtree(id, minx, maxx, miny, maxy)`, table),
+		// The spec also defines triggers maintaining the index, they call
+		// spatial SQL functions missing from plain SQLite and are left out.
+		fmt.Sprintf(`INSERT INTO gpkg_extensions VALUES
+			('%s', 'geom', 'gpkg_rtree_index', '%s', 'write-only')`,
+			table, gpkgRtreeExtension),
+	}
+	for _, q := range queries {
+		if _, err := b.ex.Exec(q); err != nil {
+			return nil, fmt.Errorf("cannot create layer %s: %s", table, err)
+		}
+	}
+	l := &gpkgLayer{
+		table:    table,
+		geomType: geomType,
+		bbox:     NewBBox(),
+	}
+	b.layers[table] = l
+	b.order = append(b.order, l)
+	return l, nil
+}
+
+func (b *gpkgBuilder) insert(l *gpkgLayer, js *RelationJson, geom []byte,
+	bbox *BBox) error {
+
+	var level interface{}
+	if js.AdminLevel > 0 {
+		level = js.AdminLevel
+	}
+	res, err := b.ex.Exec(fmt.Sprintf(`INSERT INTO "%s"
+		(geom, osm_id, name, admin_level, iso2, iso3)
+		VALUES (?, ?, ?, ?, ?, ?)`, l.table),
+		geom, js.Id, js.Name, level, js.CountryIso2, js.CountryIso3)
+	if err != nil {
+		return err
+	}
+	l.count++
+	if bbox.empty {
+		return nil
+	}
+	l.bbox.Merge(bbox)
+	fid, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = b.ex.Exec(fmt.Sprintf(`INSERT INTO "rtree_%s_geom" VALUES (?, ?, ?, ?, ?)`,
+		l.table), fid, bbox.MinLon, bbox.MaxLon, bbox.MinLat, bbox.MaxLat)
+	return err
+}
+
+// Write adds the relation boundary and centroid to the layers of its
+// admin_level.
+func (b *gpkgBuilder) Write(js *RelationJson) error {
+	l, err := b.layer(gpkgLayerName("boundaries", js.AdminLevel), "MULTIPOLYGON")
+	if err != nil {
+		return err
+	}
+	bbox := NewBBox()
+	bbox.AddMultiPolygon(js.Location.Coordinates)
+	geom := appendGpkgHeader(nil, bbox)
+	geom = appendWKB(geom, js.Location.Coordinates)
+	err = b.insert(l, js, geom, bbox)
+	if err != nil {
+		return err
+	}
+
+	l, err = b.layer(gpkgLayerName("centroids", js.AdminLevel), "POINT")
+	if err != nil {
+		return err
+	}
+	bbox = NewBBox()
+	bbox.Add(js.Center.Lon, js.Center.Lat)
+	geom = appendGpkgHeader(nil, nil)
+	geom = appendWKBPoint(geom, js.Center.Lon, js.Center.Lat)
+	return b.insert(l, js, geom, bbox)
+}
+
+// Finish records layers extents.
+func (b *gpkgBuilder) Finish() error {
+	for _, l := range b.order {
+		if l.bbox.empty {
+			continue
+		}
+		_, err := b.ex.Exec(`UPDATE gpkg_contents
+			SET min_x = ?, min_y = ?, max_x = ?, max_y = ?
+			WHERE table_name = ?`,
+			l.bbox.MinLon, l.bbox.MinLat, l.bbox.MaxLon, l.bbox.MaxLat, l.table)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GpkgWriter writes a GeoPackage in a single transaction. Like OutputFile,
+// the database is built in a temporary file renamed into its final path on
+// Commit.
+type GpkgWriter struct {
+	*gpkgBuilder
+	path    string
+	tmpPath string
+	db      *sql.DB
+	tx      *sql.Tx
+	done    bool
+}
+
+func CreateGpkg(path string) (*GpkgWriter, error) {
+	w := &GpkgWriter{
+		path:    path,
+		tmpPath: path + ".tmp",
+	}
+	err := os.Remove(w.tmpPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	w.db, err = sql.Open("sqlite3", w.tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	err = func() error {
+		w.tx, err = w.db.Begin()
+		if err != nil {
+			return err
+		}
+		w.gpkgBuilder, err = newGpkgBuilder(w.tx)
+		return err
+	}()
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Abort discards the temporary database. It does nothing once committed.
+func (w *GpkgWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if w.tx != nil {
+		w.tx.Rollback()
+	}
+	w.db.Close()
+	return os.Remove(w.tmpPath)
+}
+
+func (w *GpkgWriter) Commit() error {
+	if w.done {
+		return fmt.Errorf("GeoPackage already closed: %s", w.path)
+	}
+	err := w.Finish()
+	if err == nil {
+		err = w.tx.Commit()
+		w.tx = nil
+	}
+	if err == nil {
+		err = w.db.Close()
+	}
+	if err != nil {
+		w.Abort()
+		return fmt.Errorf("could not write %s: %s", w.path, err)
+	}
+	w.done = true
+	return os.Rename(w.tmpPath, w.path)
+}
+
+// Returns the layers row counts by table name.
+func (w *GpkgWriter) Counts() map[string]int {
+	counts := map[string]int{}
+	for _, l := range w.order {
+		counts[l.table] = l.count
+	}
+	return counts
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+type gpkgResult struct {
+	id int64
+}
+
+func (r gpkgResult) LastInsertId() (int64, error) { return r.id, nil }
+func (r gpkgResult) RowsAffected() (int64, error) { return 1, nil }
+
+// gpkgRecorder records executed statements instead of running them.
+type gpkgRecorder struct {
+	queries []string
+	args    [][]interface{}
+	ids     map[string]int64
+}
+
+func (r *gpkgRecorder) Exec(query string, args ...interface{}) (sql.Result, error) {
+	r.queries = append(r.queries, query)
+	r.args = append(r.args, args)
+	id := int64(0)
+	if strings.HasPrefix(query, "INSERT INTO \"") {
+		table := strings.Split(query, "\"")[1]
+		r.ids[table]++
+		id = r.ids[table]
+	}
+	return gpkgResult{id}, nil
+}
+
+func (r *gpkgRecorder) find(prefix string) [][]interface{} {
+	found := [][]interface{}{}
+	for i, q := range r.queries {
+		if strings.HasPrefix(q, prefix) {
+			found = append(found, r.args[i])
+		}
+	}
+	return found
+}
+
+func TestGpkgHeader(t *testing.T) {
+	bbox := NewBBox()
+	bbox.Add(1, 2)
+	bbox.Add(3, 4)
+	h := appendGpkgHeader(nil, bbox)
+	if string(h[:2]) != "GP" || h[2] != 0 || h[3] != gpkgFlagsEnvelope ||
+		len(h) != 40 {
+		t.Fatalf("invalid header: %s", hex.EncodeToString(h))
+	}
+	if binary.LittleEndian.Uint32(h[4:]) != sridWGS84 {
+		t.Fatalf("invalid srs_id")
+	}
+	env := []float64{}
+	for i := 8; i < len(h); i += 8 {
+		env = append(env, math.Float64frombits(binary.LittleEndian.Uint64(h[i:])))
+	}
+	// minx, maxx, miny, maxy
+	if env[0] != 1 || env[1] != 3 || env[2] != 2 || env[3] != 4 {
+		t.Fatalf("invalid envelope: %v", env)
+	}
+	if h := appendGpkgHeader(nil, nil); len(h) != 8 || h[3] != gpkgFlagsNoEnvelope {
+		t.Fatalf("invalid point header: %s", hex.EncodeToString(h))
+	}
+	if h := appendGpkgHeader(nil, NewBBox()); len(h) != 8 || h[3]&gpkgFlagEmpty == 0 {
+		t.Fatalf("invalid empty header: %s", hex.EncodeToString(h))
+	}
+}
+
+func TestGpkgBuilder(t *testing.T) {
+	rec := &gpkgRecorder{ids: map[string]int64{}}
+	b, err := newGpkgBuilder(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	square := func(x float64) [][][][]float64 {
+		return [][][][]float64{{{{x, 0}, {x + 1, 0}, {x + 1, 1}, {x, 0}}}}
+	}
+	rels := []*RelationJson{
+		{Id: "1", Name: "France", AdminLevel: 2, CountryIso2: "FR",
+			Location: Location{Coordinates: square(0)}},
+		{Id: "2", Name: "Paris", AdminLevel: 8, Location: Location{Coordinates: square(1)}},
+		{Id: "3", Name: "Belgique", AdminLevel: 2, Location: Location{Coordinates: square(2)}},
+		{Id: "4", Name: "Park", Location: Location{Coordinates: square(3)}},
+	}
+	for i, js := range rels {
+		js.Center.Lon = float64(i)
+		if err := b.Write(js); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	layers := []string{}
+	for _, l := range b.order {
+		layers = append(layers, l.table+":"+l.geomType)
+	}
+	got := strings.Join(layers, ",")
+	want := "boundaries_2:MULTIPOLYGON,centroids_2:POINT," +
+		"boundaries_8:MULTIPOLYGON,centroids_8:POINT," +
+		"boundaries:MULTIPOLYGON,centroids:POINT"
+	if got != want {
+		t.Fatalf("unexpected layers:\n%s\n!=\n%s", got, want)
+	}
+	if n := len(rec.find("INSERT INTO gpkg_contents")); n != 6 {
+		t.Fatalf("unexpected contents: %d", n)
+	}
+	if n := len(rec.find(`CREATE VIRTUAL TABLE "rtree_`)); n != 6 {
+		t.Fatalf("unexpected spatial indexes: %d", n)
+	}
+
+	rows := rec.find(`INSERT INTO "boundaries_2"`)
+	if len(rows) != 2 || rows[1][1] != "3" || rows[0][4] != "FR" ||
+		rows[0][3] != 2 {
+		t.Fatalf("unexpected boundaries_2 rows: %v", rows)
+	}
+	geom := rows[1][0].([]byte)
+	wkb := appendWKB(nil, rels[2].Location.Coordinates)
+	if string(geom[40:]) != string(wkb) {
+		t.Fatalf("unexpected geometry: %s", hex.EncodeToString(geom))
+	}
+	if rows := rec.find("INSERT INTO \"boundaries\"\n"); len(rows) != 1 ||
+		rows[0][3] != nil {
+		t.Fatalf("unexpected level-less rows: %v", rows)
+	}
+	index := rec.find(`INSERT INTO "rtree_boundaries_2_geom"`)
+	if len(index) != 2 || index[1][0] != int64(2) || index[1][1] != 2. ||
+		index[1][2] != 3. {
+		t.Fatalf("unexpected index entries: %v", index)
+	}
+	points := rec.find(`INSERT INTO "centroids_8"`)
+	if len(points) != 1 ||
+		string(points[0][0].([]byte)[8:]) != string(appendWKBPoint(nil, 1, 0)) {
+		t.Fatalf("unexpected centroids: %v", points)
+	}
+
+	extents := rec.find("UPDATE gpkg_contents")
+	if len(extents) != 6 || extents[0][4] != "boundaries_2" ||
+		extents[0][0] != 0. || extents[0][2] != 3. {
+		t.Fatalf("unexpected extents: %v", extents)
+	}
+}
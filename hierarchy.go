@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+)
+
+// Returns the ancestors of area located at (lon, lat), from the top level one
+// down. An ancestor contains the point and has a lower admin level than area,
+// or any level when area level is unknown. Only one area is kept per level,
+// the one with the smallest id when several overlap.
+func findParents(idx *AdminIndex, area *AdminArea, lon, lat float64) []*AdminArea {
+	parents := []*AdminArea{}
+	for _, other := range idx.Lookup(lon, lat) {
+		if other.Id == area.Id || other.Level < 1 {
+			continue
+		}
+		if area.Level >= 1 && other.Level >= area.Level {
+			continue
+		}
+		// Lookup sorts by level then id
+		if n := len(parents); n > 0 && parents[n-1].Level == other.Level {
+			continue
+		}
+		parents = append(parents, other)
+	}
+	return parents
+}
+
+// Formats the chain of names from the top level ancestor down to name, like
+// "France > Île-de-France > Paris".
+func formatParentPath(parents []AdminAreaJson, name string) string {
+	names := []string{}
+	for _, p := range parents {
+		names = append(names, p.Name)
+	}
+	names = append(names, name)
+	return strings.Join(names, " > ")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindParents(t *testing.T) {
+	square := func(x0, y0, x1, y1 float64) [][][][]float64 {
+		return [][][][]float64{{
+			{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}, {x0, y0}},
+		}}
+	}
+	idx := NewAdminIndex()
+	areas := []*AdminArea{
+		{Id: 1, Name: "France", Level: 2, Coordinates: square(0, 0, 10, 10)},
+		{Id: 2, Name: "Île-de-France", Level: 4, Coordinates: square(1, 1, 5, 5)},
+		{Id: 5, Name: "Overlap", Level: 4, Coordinates: square(1, 1, 5, 5)},
+		{Id: 3, Name: "Paris", Level: 8, Coordinates: square(2, 2, 3, 3)},
+		{Id: 4, Name: "Paris", Level: -1, Coordinates: square(2, 2, 3, 3)},
+	}
+	for _, a := range areas {
+		idx.Add(a)
+	}
+	parents := findParents(idx, areas[3], 2.5, 2.5)
+	json := []AdminAreaJson{}
+	for _, p := range parents {
+		json = append(json, AdminAreaJson{Name: p.Name, AdminLevel: p.Level})
+	}
+	path := formatParentPath(json, "Paris")
+	if path != "France > Île-de-France > Paris" {
+		t.Fatalf("unexpected path: %s", path)
+	}
+	parents = findParents(idx, areas[4], 2.5, 2.5)
+	if len(parents) != 3 || parents[2].Id != 3 {
+		t.Fatalf("unexpected parents for unknown level: %v", parents)
+	}
+	parents = findParents(idx, areas[0], 2.5, 2.5)
+	if len(parents) != 0 {
+		t.Fatalf("top level area has parents: %v", parents)
+	}
+}
+
+func TestIndexParentsPipeline(t *testing.T) {
+	dir, input, db := runTestPipeline(t)
+	defer os.RemoveAll(dir)
+	runTestCommand(t, "indexparents", db)
+	output := filepath.Join(dir, "admin.jsonl")
+	runTestCommand(t, "geojson", input, db, output, "--format", "features")
+
+	fp, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	type Feature struct {
+		Id         string
+		Properties struct {
+			Parents    []AdminAreaJson
+			ParentPath string `json:"parent_path"`
+		}
+	}
+	features := map[string]Feature{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		f := Feature{}
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			t.Fatalf("invalid feature: %s\n%s", err, scanner.Text())
+		}
+		features[f.Id] = f
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	region, ok := features["101"]
+	if !ok {
+		t.Fatalf("region was not exported: %v", features)
+	}
+	expected := []AdminAreaJson{{Id: "100", Name: "Country", AdminLevel: 2}}
+	if !reflect.DeepEqual(region.Properties.Parents, expected) ||
+		region.Properties.ParentPath != "Country > Region" {
+		t.Fatalf("unexpected region parents: %+v", region.Properties)
+	}
+	if country := features["100"]; len(country.Properties.Parents) != 0 {
+		t.Fatalf("country has parents: %+v", country.Properties)
+	}
+}
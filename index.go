@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+)
+
+// FileIndex records the reset points of an OSM file and where each kind of
+// element starts, so multi-pass algorithms can jump to a kind instead of
+// scanning the elements preceding it.
+type FileIndex struct {
+	Resets []ResetPoint
+	// Last reset point preceding the first element of each kind
+	Kinds map[int]ResetPoint
+}
+
+// Scans path, without decoding elements, and returns its index.
+func IndexOSMFile(path string) (*FileIndex, error) {
+	r, err := OpenOSMReader(path, NodeKind, WayKind, RelationKind)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	idx := &FileIndex{
+		Kinds: map[int]ResetPoint{},
+	}
+	for r.Next() {
+		kind := r.Kind()
+		switch kind {
+		case ResetKind:
+			idx.Resets = append(idx.Resets, r.ResetPoint())
+		case NodeKind, WayKind, RelationKind:
+			if _, ok := idx.Kinds[kind]; ok {
+				continue
+			}
+			if len(idx.Resets) == 0 {
+				return nil, fmt.Errorf("element of kind %x found before first reset",
+					kind)
+			}
+			idx.Kinds[kind] = idx.Resets[len(idx.Resets)-1]
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return idx, nil
+}
+
+// Returns the reset point to seek to before reading elements of kind. If
+// the file has no reset between sections, elements of previous kinds may
+// come first.
+func (idx *FileIndex) Start(kind int) (ResetPoint, error) {
+	p, ok := idx.Kinds[kind]
+	if !ok {
+		return ResetPoint{}, fmt.Errorf("no element of kind %x", kind)
+	}
+	return p, nil
+}
+
+// fileIndexer builds the index of a reader file on first use.
+type fileIndexer struct {
+	path  string
+	index *FileIndex
+}
+
+func (fi *fileIndexer) start(kind int) (ResetPoint, error) {
+	if fi.index == nil {
+		idx, err := IndexOSMFile(fi.path)
+		if err != nil {
+			return ResetPoint{}, err
+		}
+		fi.index = idx
+	}
+	return fi.index.Start(kind)
+}
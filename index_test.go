@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSeekToKind(t *testing.T) {
+	nodes := []Node{{Id: 1}, {Id: 2}}
+	ways := []Way{{Id: 10, Nodes: []int64{1, 2}}}
+	relations := []Relation{{Id: 5, Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}}}}
+	o5mPath := writeTestFile(t, nodes, ways, relations)
+	defer os.Remove(o5mPath)
+	xmlPath := writeTestXml(t, testOsmXml)
+	defer os.Remove(xmlPath)
+
+	for _, path := range []string{o5mPath, xmlPath} {
+		idx, err := IndexOSMFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(idx.Resets) != 3 || len(idx.Kinds) != 3 {
+			t.Fatalf("unexpected index for %s: %+v", path, idx)
+		}
+		r, err := OpenOSMReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kind := range []int{RelationKind, NodeKind, WayKind} {
+			if err := r.SeekToKind(kind); err != nil {
+				t.Fatal(err)
+			}
+			// The reset point comes first
+			if !r.Next() || r.Kind() != ResetKind ||
+				r.ResetPoint() != idx.Kinds[kind] {
+				t.Fatalf("reset point expected for %x in %s", kind, path)
+			}
+			if !r.Next() || r.Kind() != kind {
+				t.Fatalf("element of kind %x expected in %s, got %x", kind,
+					path, r.Kind())
+			}
+		}
+		r.Close()
+	}
+
+	// Files without relations
+	path := writeTestFile(t, nodes, ways, nil)
+	defer os.Remove(path)
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.SeekToKind(RelationKind) == nil {
+		t.Fatalf("seeking to missing relations should fail")
+	}
+}
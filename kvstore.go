@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	BoltBackend    = "bolt"
+	LevelDbBackend = "leveldb"
+)
+
+var (
+	// Backend used by OpenWaysDb to create new databases. Existing ones are
+	// opened with the backend they were created with.
+	waysDbBackend = BoltBackend
+
+	// Returned by ForEach callbacks to stop the iteration without error.
+	errStopIteration = errors.New("stop iteration")
+)
+
+// kvStore is the key/value store backing WaysDb. Entries are grouped in
+// buckets and ordered bytewise by key within a bucket.
+type kvStore interface {
+	View(fn func(tx kvTx) error) error
+	Update(fn func(tx kvTx) error) error
+	Close() error
+}
+
+// kvTx is a transaction on a kvStore. Returned slices are only valid during
+// the transaction.
+type kvTx interface {
+	// Returns nil if key does not exist.
+	Get(bucket, key []byte) ([]byte, error)
+	Put(bucket, key, value []byte) error
+	Delete(bucket, key []byte) error
+	// Calls fn with bucket entries in key order, starting at from or at the
+	// first entry if from is nil. fn can return errStopIteration to stop.
+	ForEach(bucket, from []byte, fn func(k, v []byte) error) error
+	// Deletes all entries of bucket.
+	ClearBucket(bucket []byte) error
+}
+
+// Opens the store at path, or creates it with waysDbBackend. LevelDB stores
+// are directories, bolt ones are files.
+func openKVStore(path string) (kvStore, error) {
+	backend := waysDbBackend
+	st, err := os.Stat(path)
+	if err == nil {
+		backend = BoltBackend
+		if st.IsDir() {
+			backend = LevelDbBackend
+		}
+	}
+	switch backend {
+	case BoltBackend:
+		return openBoltStore(path)
+	case LevelDbBackend:
+		return openLevelDbStore(path)
+	}
+	return nil, fmt.Errorf("unknown db backend: %s", backend)
+}
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) View(fn func(tx kvTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx kvTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// Returns bucket, creating it in writable transactions.
+func (t *boltTx) bucket(name []byte) (*bolt.Bucket, error) {
+	b := t.tx.Bucket(name)
+	if b != nil {
+		return b, nil
+	}
+	if t.tx.Writable() {
+		return t.tx.CreateBucket(name)
+	}
+	return nil, fmt.Errorf("unknown bucket: %s", name)
+}
+
+func (t *boltTx) Get(bucket, key []byte) ([]byte, error) {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil, nil
+	}
+	return b.Get(key), nil
+}
+
+func (t *boltTx) Put(bucket, key, value []byte) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+func (t *boltTx) Delete(bucket, key []byte) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	return b.Delete(key)
+}
+
+func (t *boltTx) ForEach(bucket, from []byte,
+	fn func(k, v []byte) error) error {
+
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	c := b.Cursor()
+	k, v := c.First()
+	if from != nil {
+		k, v = c.Seek(from)
+	}
+	for ; k != nil; k, v = c.Next() {
+		err := fn(k, v)
+		if err == errStopIteration {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *boltTx) ClearBucket(bucket []byte) error {
+	if t.tx.Bucket(bucket) != nil {
+		err := t.tx.DeleteBucket(bucket)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := t.tx.CreateBucket(bucket)
+	return err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// levelDbStore maps buckets to key prefixes in a single LevelDB keyspace.
+// Transactions read from a snapshot and commit their writes in one batch, so
+// reads do not see writes made earlier in the same transaction. Updates are
+// serialized like bolt ones.
+type levelDbStore struct {
+	db   *leveldb.DB
+	lock sync.Mutex
+}
+
+func openLevelDbStore(path string) (*levelDbStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &levelDbStore{db: db}, nil
+}
+
+func (s *levelDbStore) run(fn func(tx kvTx) error) (*leveldb.Batch, error) {
+	snapshot, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+	tx := &levelDbTx{
+		snapshot: snapshot,
+		batch:    &leveldb.Batch{},
+	}
+	return tx.batch, fn(tx)
+}
+
+func (s *levelDbStore) View(fn func(tx kvTx) error) error {
+	_, err := s.run(fn)
+	return err
+}
+
+func (s *levelDbStore) Update(fn func(tx kvTx) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	batch, err := s.run(fn)
+	if err != nil || batch.Len() == 0 {
+		return err
+	}
+	return s.db.Write(batch, nil)
+}
+
+func (s *levelDbStore) Close() error {
+	return s.db.Close()
+}
+
+type levelDbTx struct {
+	snapshot *leveldb.Snapshot
+	batch    *leveldb.Batch
+}
+
+func makeLevelDbKey(bucket, key []byte) []byte {
+	k := make([]byte, 0, len(bucket)+1+len(key))
+	k = append(k, bucket...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+func (t *levelDbTx) Get(bucket, key []byte) ([]byte, error) {
+	v, err := t.snapshot.Get(makeLevelDbKey(bucket, key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return v, err
+}
+
+func (t *levelDbTx) Put(bucket, key, value []byte) error {
+	t.batch.Put(makeLevelDbKey(bucket, key), value)
+	return nil
+}
+
+func (t *levelDbTx) Delete(bucket, key []byte) error {
+	t.batch.Delete(makeLevelDbKey(bucket, key))
+	return nil
+}
+
+func (t *levelDbTx) ForEach(bucket, from []byte,
+	fn func(k, v []byte) error) error {
+
+	prefix := makeLevelDbKey(bucket, nil)
+	it := t.snapshot.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+	ok := it.First()
+	if from != nil {
+		ok = it.Seek(makeLevelDbKey(bucket, from))
+	}
+	for ; ok; ok = it.Next() {
+		err := fn(it.Key()[len(prefix):], it.Value())
+		if err == errStopIteration {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+func (t *levelDbTx) ClearBucket(bucket []byte) error {
+	return t.ForEach(bucket, nil, func(k, v []byte) error {
+		return t.Delete(bucket, k)
+	})
+}
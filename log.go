@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// Configures the default slog logger used for progress, warnings and errors.
+// Logs go to w, stderr in practice, so results printed on stdout by commands
+// can be parsed.
+func setupLogging(w io.Writer, level, format string) error {
+	lvl := slog.LevelInfo
+	if level != "" {
+		err := lvl.UnmarshalText([]byte(strings.ToUpper(level)))
+		if err != nil {
+			return fmt.Errorf("invalid log level: %s", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch format {
+	case LogFormatText, "":
+		h = slog.NewTextHandler(w, opts)
+	case LogFormatJson:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	slog.SetDefault(slog.New(&summaryHandler{h}))
+	return nil
+}
+
+// Returns attributes identifying rel in log records.
+func relationAttr(rel *Relation) slog.Attr {
+	return slog.Group("relation", "id", rel.Id, "name", rel.Name(),
+		"level", rel.AdminLevel())
+}
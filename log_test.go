@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetupLogging(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	buf := &bytes.Buffer{}
+	if err := setupLogging(buf, "warn", LogFormatJson); err != nil {
+		t.Fatal(err)
+	}
+	rel := &Relation{
+		Id:   7,
+		Tags: []StringPair{{Key: "name", Value: "Paris"}, {Key: "admin_level", Value: "8"}},
+	}
+	slog.Info("ignored")
+	slog.Warn("cannot compute centroid", relationAttr(rel))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("unexpected records: %q", buf.String())
+	}
+	record := struct {
+		Level    string
+		Msg      string
+		Relation struct {
+			Id    int64
+			Name  string
+			Level string
+		}
+	}{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Level != "WARN" || record.Msg != "cannot compute centroid" ||
+		record.Relation.Id != 7 || record.Relation.Name != "Paris" ||
+		record.Relation.Level != "8" {
+		t.Fatalf("unexpected record: %s", lines[0])
+	}
+
+	if err := setupLogging(buf, "verbose", LogFormatText); err == nil {
+		t.Fatalf("invalid level was accepted")
+	}
+	if err := setupLogging(buf, "info", "xml"); err == nil {
+		t.Fatalf("invalid format was accepted")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LookupJson lists the administrative areas containing a point.
+type LookupJson struct {
+	Lon   float64         `json:"lon"`
+	Lat   float64         `json:"lat"`
+	Admin []AdminAreaJson `json:"admin"`
+}
+
+// Returns the areas of idx containing (lon, lat), sorted by increasing admin
+// level.
+func lookupAdminAreas(idx *AdminIndex, lon, lat float64) []AdminAreaJson {
+	admins := []AdminAreaJson{}
+	for _, area := range idx.Lookup(lon, lat) {
+		admins = append(admins, AdminAreaJson{
+			Id:         fmt.Sprintf("%d", area.Id),
+			Name:       area.Name,
+			AdminLevel: area.Level,
+		})
+	}
+	return admins
+}
+
+func parseLonLat(lonStr, latStr string) (float64, float64, error) {
+	lon, err := strconv.ParseFloat(lonStr, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude: %s", lonStr)
+	}
+	lat, err := strconv.ParseFloat(latStr, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude: %s", latStr)
+	}
+	if lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("coordinates out of range: %s %s", lonStr, latStr)
+	}
+	return lon, lat, nil
+}
+
+// Parses "lon lat" or "lon,lat" lines.
+func parseLookupLine(line string) (float64, float64, error) {
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("expected lon and lat: %s", line)
+	}
+	return parseLonLat(fields[0], fields[1])
+}
+
+// Returns the areas of db containing (lon, lat) using its spatial index,
+// sorted by increasing admin level. Relations are selected by ignoreRelation
+// like the geojson command does.
+func lookupDbAreas(db *WaysDb, lon, lat float64) ([]AdminAreaJson, error) {
+	ids, err := db.GetLocationsContaining(lon, lat)
+	if err != nil {
+		return nil, err
+	}
+	admins := []AdminAreaJson{}
+	for _, id := range ids {
+		rel, err := db.GetRelation(id)
+		if err != nil {
+			return nil, err
+		}
+		if rel == nil {
+			continue
+		}
+		if ok, err := ignoreRelation(rel); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rt, err := NewRelationTags(rel)
+		if err != nil {
+			return nil, err
+		}
+		level, _ := rt.AdminLevel()
+		admins = append(admins, AdminAreaJson{
+			Id:         fmt.Sprintf("%d", id),
+			Name:       rt.Name(),
+			AdminLevel: level,
+		})
+	}
+	// ids are sorted, keep them sorted within a level like AdminIndex does
+	sort.SliceStable(admins, func(i, j int) bool {
+		return admins[i].AdminLevel < admins[j].AdminLevel
+	})
+	return admins, nil
+}
+
+// Reads coordinates from r, one point per line, and writes the areas
+// returned by lookup to w as JSON lines. Empty and comment lines are skipped.
+// Returns the number of looked up points.
+func runLookups(lookup func(lon, lat float64) ([]AdminAreaJson, error),
+	r io.Reader, w io.Writer) (int, error) {
+
+	scanner := bufio.NewScanner(r)
+	encoder := json.NewEncoder(w)
+	count := 0
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lon, lat, err := parseLookupLine(line)
+		if err != nil {
+			return count, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		admins, err := lookup(lon, lat)
+		if err != nil {
+			return count, err
+		}
+		err = encoder.Encode(&LookupJson{
+			Lon:   lon,
+			Lat:   lat,
+			Admin: admins,
+		})
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, scanner.Err()
+}
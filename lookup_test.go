@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A country containing a region, with a town and a cafe in the region.
+const testPipelineOsm = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6" generator="test">
+ <node id="1" lat="0" lon="0"/>
+ <node id="2" lat="0" lon="4"/>
+ <node id="3" lat="4" lon="4"/>
+ <node id="4" lat="4" lon="0"/>
+ <node id="5" lat="1" lon="1"/>
+ <node id="6" lat="1" lon="3"/>
+ <node id="7" lat="3" lon="3"/>
+ <node id="8" lat="3" lon="1"/>
+ <node id="20" lat="2" lon="2">
+  <tag k="place" v="town"/>
+  <tag k="name" v="Town"/>
+  <tag k="population" v="1000"/>
+ </node>
+ <node id="21" lat="2.5" lon="1.5">
+  <tag k="amenity" v="cafe"/>
+  <tag k="name" v="Cafe"/>
+ </node>
+ <way id="10">
+  <nd ref="1"/><nd ref="2"/><nd ref="3"/><nd ref="4"/><nd ref="1"/>
+ </way>
+ <way id="11">
+  <nd ref="5"/><nd ref="6"/><nd ref="7"/><nd ref="8"/><nd ref="5"/>
+ </way>
+ <relation id="100">
+  <member type="way" ref="10" role="outer"/>
+  <tag k="type" v="boundary"/>
+  <tag k="boundary" v="administrative"/>
+  <tag k="admin_level" v="2"/>
+  <tag k="name" v="Country"/>
+  <tag k="ISO3166-1:alpha2" v="AA"/>
+ </relation>
+ <relation id="101">
+  <member type="way" ref="11" role="outer"/>
+  <tag k="type" v="boundary"/>
+  <tag k="boundary" v="administrative"/>
+  <tag k="admin_level" v="4"/>
+  <tag k="name" v="Region"/>
+ </relation>
+</osm>
+`
+
+// Parses and runs a command like the command line does.
+func runTestCommand(t *testing.T, args ...string) {
+	t.Helper()
+	cmd := parseTestArgs(t, args...)
+	if err := runCommand(cmd); err != nil {
+		t.Fatalf("%v failed: %s", args, err)
+	}
+}
+
+// Runs the documented indexing pipeline on testPipelineOsm in a temporary
+// directory. Returns the directory, the input and db paths.
+func runTestPipeline(t *testing.T) (string, string, string) {
+	t.Helper()
+	return runTestPipelineOn(t, testPipelineOsm)
+}
+
+// Runs the documented indexing pipeline on OSM XML data like
+// runTestPipeline.
+func runTestPipelineOn(t *testing.T, data string) (string, string, string) {
+	t.Helper()
+	t.Setenv("OSM_CONFIG", "")
+	dir, err := ioutil.TempDir("", "osm-pipeline-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "admin.osm")
+	err = ioutil.WriteFile(input, []byte(data), 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	db := filepath.Join(dir, "admin.db")
+	for _, cmd := range []string{"indexways", "indexrelations",
+		"indexlocations", "indexcenters"} {
+		runTestCommand(t, cmd, input, db)
+	}
+	return dir, input, db
+}
+
+func TestParseLookupLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		lon, lat float64
+		ok       bool
+	}{
+		{"2.35 48.85", 2.35, 48.85, true},
+		{"2.35,48.85", 2.35, 48.85, true},
+		{"-1, -2", -1, -2, true},
+		{"2.35", 0, 0, false},
+		{"a b", 0, 0, false},
+		{"200 0", 0, 0, false},
+		{"1 2 3", 0, 0, false},
+	}
+	for _, test := range tests {
+		lon, lat, err := parseLookupLine(test.line)
+		if (err == nil) != test.ok {
+			t.Fatalf("%q: unexpected error: %v", test.line, err)
+		}
+		if lon != test.lon || lat != test.lat {
+			t.Fatalf("%q: unexpected coordinates: %f %f", test.line, lon, lat)
+		}
+	}
+}
+
+func TestRunLookups(t *testing.T) {
+	idx := NewAdminIndex()
+	idx.Add(&AdminArea{
+		Id:    1,
+		Name:  "country",
+		Level: 2,
+		Coordinates: [][][][]float64{{
+			{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+		}},
+	})
+	input := "# comment\n1 1\n\n5,5\n"
+	w := &bytes.Buffer{}
+	lookup := func(lon, lat float64) ([]AdminAreaJson, error) {
+		return lookupAdminAreas(idx, lon, lat), nil
+	}
+	n, err := runLookups(lookup, strings.NewReader(input), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"lon":1,"lat":1,"admin":[{"id":"1","name":"country","admin_level":2}]}
+{"lon":5,"lat":5,"admin":[]}
+`
+	if n != 2 || w.String() != want {
+		t.Fatalf("unexpected output: %d\n%s", n, w.String())
+	}
+	_, err = runLookups(lookup, strings.NewReader("1 1\nfoo\n"), w)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLookupPipeline(t *testing.T) {
+	dir, _, path := runTestPipeline(t)
+	defer os.RemoveAll(dir)
+	db, err := OpenWaysDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		Lon, Lat float64
+		Expected []AdminAreaJson
+	}{
+		{2, 2, []AdminAreaJson{
+			{Id: "100", Name: "Country", AdminLevel: 2},
+			{Id: "101", Name: "Region", AdminLevel: 4},
+		}},
+		{0.5, 0.5, []AdminAreaJson{
+			{Id: "100", Name: "Country", AdminLevel: 2},
+		}},
+		{5, 5, []AdminAreaJson{}},
+	}
+	for _, test := range tests {
+		admins, err := lookupDbAreas(db, test.Lon, test.Lat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(admins, test.Expected) {
+			t.Fatalf("unexpected areas at %f %f: %+v", test.Lon, test.Lat,
+				admins)
+		}
+	}
+}
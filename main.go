@@ -1,22 +1,87 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
 	"os"
-	"regexp"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/alecthomas/kingpin"
+	"github.com/pmezard/osm/o5m"
 )
 
 var (
-	app = kingpin.New("o5m", "openstreetmap o5m manipulation tool")
+	app       = kingpin.New("o5m", "openstreetmap o5m manipulation tool")
+	memReport = app.Flag("mem-report",
+		"memory usage report interval, 0 to disable").Default("1m").Duration()
+	memAbortOver = app.Flag("abort-over",
+		"abort if memory usage exceeds this many GB").Float64()
+	summaryJson = app.Flag("summary-json",
+		"write a JSON summary of the command outcome, counters and skipped "+
+			"relations to this file, - for stdout").String()
+	onError = app.Flag("on-error",
+		"when an element cannot be processed, stop, log and continue, or "+
+			"continue and report all failures at the end").
+		Default(OnErrorSkip).Enum(OnErrorFail, OnErrorSkip, OnErrorCollect)
+	duplicateTags = app.Flag("duplicate-tags",
+		"keep the first or last value of duplicate relation tags, or fail").
+		Default(DuplicateTagsLast).
+		Enum(DuplicateTagsFirst, DuplicateTagsLast, DuplicateTagsError)
+	configPath = app.Flag("config",
+		"flag defaults file, overridden by OSM_* environment variables").
+		Envar("OSM_CONFIG").String()
+	commandsJson = app.Flag("commands-json",
+		"print commands and flags as JSON and exit").Bool()
+	dbBackend = app.Flag("db-backend",
+		"key/value store used to create feature dbs, existing ones are "+
+			"opened with their own").
+		Default(BoltBackend).Enum(BoltBackend, LevelDbBackend)
+	decodeWorkers = app.Flag("decode-workers",
+		"number of goroutines decoding o5m input, 0 or 1 to decode sequentially").
+		Default("0").Int()
+	rulesPath = app.Flag("rules",
+		"JSON relation patch rules replacing the built-in ones, see the rules "+
+			"command").Envar("OSM_RULES").String()
+	boundariesPath = app.Flag("boundaries",
+		"JSON accepted and rejected boundary values replacing the built-in "+
+			"ones, see the boundaries command").Envar("OSM_BOUNDARIES").String()
+	unknownBoundary = app.Flag("unknown-boundary",
+		"how relations with a boundary value missing from both lists are "+
+			"handled").
+		Default(UnknownBoundaryError).
+		Enum(UnknownBoundaryAccept, UnknownBoundaryReject, UnknownBoundaryError)
+	minAdminLevelFlag = app.Flag("min-admin-level",
+		"lowest admin_level of processed boundaries").Default("1").Int()
+	maxAdminLevelFlag = app.Flag("max-admin-level",
+		"highest admin_level of processed boundaries, up to 11 for "+
+			"neighbourhoods").Default("8").Int()
+	logLevel = app.Flag("log-level",
+		"minimum level of messages logged on stderr").
+		Default("info").Enum("debug", "info", "warn", "error")
+	logFormat = app.Flag("log-format", "log records format").
+			Default(LogFormatText).Enum(LogFormatText, LogFormatJson)
+	winding = app.Flag("winding",
+		"rings orientation of exported polygons, counter-clockwise outer rings "+
+			"for es and rfc7946, or as built by the geometry backend").
+		Default(WindingES).Enum(WindingES, WindingRFC7946, WindingPreserve)
+	withMetadata = app.Flag("with-metadata",
+		"include element version, timestamp and author in printed nodes and "+
+			"exported features").Bool()
+	nameLanguagesFlag = app.Flag("name-languages",
+		"comma separated languages of the name:<lang> tags exported in names, "+
+			"all of them if empty").String()
+	validateWikidataFlag = app.Flag("validate-wikidata",
+		"drop exported wikidata tags which are not a single Q item identifier").
+		Bool()
 )
 
 var (
@@ -25,7 +90,7 @@ var (
 )
 
 func countFn() error {
-	r, err := NewO5MReader(*countPath, NodeKind, WayKind, RelationKind)
+	r, err := OpenOSMReader(*countPath, NodeKind, WayKind, RelationKind)
 	if err != nil {
 		return err
 	}
@@ -55,18 +120,170 @@ func countFn() error {
 	return nil
 }
 
+var (
+	statsCmd  = app.Command("stats", "report o5m elements and tags statistics")
+	statsPath = statsCmd.Arg("path", "o5m file path").Required().String()
+	statsTop  = statsCmd.Flag("top", "number of most frequent tag keys reported").
+			Default("20").Int()
+	statsJson = statsCmd.Flag("json", "print statistics as JSON").Bool()
+)
+
+func statsFn() error {
+	r, err := OpenOSMReader(*statsPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	stats, err := computeContentStats(r, *statsTop)
+	if err != nil {
+		return err
+	}
+	if !*statsJson {
+		printContentStats(os.Stdout, stats)
+		return nil
+	}
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+var (
+	checksumCmd  = app.Command("checksum", "hash o5m elements content")
+	checksumPath = checksumCmd.Arg("path", "o5m file path").Required().String()
+)
+
+func checksumFn() error {
+	stats, err := computeFileStats(*checksumPath, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("nodes=%d ways=%d relations=%d\n", stats.Nodes, stats.Ways,
+		stats.Relations)
+	fmt.Println(stats.Checksum)
+	return nil
+}
+
+var (
+	selfCheckCmd = app.Command("selfcheck",
+		"check o5m file survives a write/read roundtrip")
+	selfCheckPath = selfCheckCmd.Arg("path", "o5m file path").Required().String()
+)
+
+func selfCheckFn() error {
+	fp, err := ioutil.TempFile("", "osm-selfcheck-*.o5m")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+	w, err := NewO5MWriter(fp)
+	if err != nil {
+		return err
+	}
+	expected, err := computeFileStats(*selfCheckPath, w)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	actual, err := computeFileStats(fp.Name(), nil)
+	if err != nil {
+		return fmt.Errorf("cannot read written file: %s", err)
+	}
+	fmt.Println("input:  ", expected)
+	fmt.Println("written:", actual)
+	if *expected != *actual {
+		return fmt.Errorf("roundtrip mismatch")
+	}
+	fmt.Println("OK")
+	return nil
+}
+
 var (
 	locationsCmd     = app.Command("indexlocations", "convert o5m to geojson")
 	locationsPath    = locationsCmd.Arg("path", "o5m file path").Required().String()
 	locationsDb      = locationsCmd.Arg("db", "output locations db path").Required().String()
 	locationsId      = locationsCmd.Flag("id", "relation id").String()
 	locationsWorkers = locationsCmd.Flag("workers", "workers count").Default("1").Int()
+	locationsKeep    = locationsCmd.Flag("keep",
+		"select relations matching this tag expression instead of administrative boundaries").
+		String()
+	locationsShard = locationsCmd.Flag("shard",
+		"only process relations of shard i/N").String()
+	locationsProtected = locationsCmd.Flag("protected-areas",
+		"select protected areas and national parks instead of administrative boundaries").
+		Bool()
+	locationsForce = locationsCmd.Flag("force-locations",
+		"rebuild existing locations, invalidating their centroids").Bool()
+	locationsOnlyIds = locationsCmd.Flag("only-ids",
+		"only process these comma separated relation ids").String()
+	locationsRebuild = locationsCmd.Flag("rebuild",
+		"rebuild existing locations built by an older version").Bool()
+	locationsRebuildIds = locationsCmd.Flag("rebuild-ids",
+		"rebuild the existing locations of these comma separated relation ids").
+		String()
+	locationsDryRun = locationsCmd.Flag("dry-run",
+		"report what would be processed without writing anything").Bool()
+	locationsTileSize = locationsCmd.Flag("tile-size",
+		"partition relations in tiles of this size in degrees, each tile being "+
+			"processed in a separate db shard").Float64()
+	locationsSimplify = locationsCmd.Flag("simplify",
+		"simplify ways with this tolerance in degrees before assembling rings").
+		Float64()
+	locationsResume = locationsCmd.Flag("resume",
+		"continue an interrupted run from its last checkpoint").Bool()
+	locationsSnap = locationsCmd.Flag("snap-tolerance",
+		"snap unmatched way endpoints closer than this, in degrees or in "+
+			"meters with a m suffix, before assembling rings").String()
+	locationsLand = locationsCmd.Flag("land-polygons",
+		"clip countries against the land polygons of this GeoJSON file or URL, "+
+			"dropping their territorial waters").String()
+	locationsDebugRings = locationsCmd.Flag("debug-rings",
+		"write the lines, partial rings and unmatched endpoints of relations "+
+			"failing to build in this directory").String()
 )
 
 func locationsFn() error {
+	if *locationsDryRun {
+		err := setKeepFilter(*locationsKeep, *locationsProtected)
+		if err != nil {
+			return err
+		}
+		return dryRunLocationsFn()
+	}
+	if *locationsSimplify < 0 {
+		return fmt.Errorf("invalid simplification tolerance: %f", *locationsSimplify)
+	}
+	waySimplifyTolerance = *locationsSimplify
+	snapTolerance, err := parseDistance(*locationsSnap)
+	if err != nil {
+		return err
+	}
+	ringSnapTolerance = snapTolerance
+	if *locationsLand != "" {
+		slog.Info("loading land polygons", "path", *locationsLand)
+		land, err := loadLandPolygons(*locationsLand)
+		if err != nil {
+			return err
+		}
+		slog.Info("loaded land polygons", "count", land.Len())
+		landPolygons = land
+	}
+	debugDir := *locationsDebugRings
+	if debugDir != "" {
+		err := os.MkdirAll(debugDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
 	start := time.Now()
 	workers := *locationsWorkers
-	r, err := NewO5MReader(*locationsPath, NodeKind, WayKind)
+	r, err := OpenOSMReader(*locationsPath, NodeKind, WayKind)
 	if err != nil {
 		return err
 	}
@@ -75,18 +292,61 @@ func locationsFn() error {
 		return err
 	}
 	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
 
 	relId, err := parseRelId(*locationsId)
 	if err != nil {
 		return err
 	}
-	type Request struct {
-		Relation *Relation
-		Location *Location
-		Err      error
+	shard, err := parseShard(*locationsShard)
+	if err != nil {
+		return err
+	}
+	err = setKeepFilter(*locationsKeep, *locationsProtected)
+	if err != nil {
+		return err
+	}
+	onlyIds, err := parseIdList(*locationsOnlyIds)
+	if err != nil {
+		return err
+	}
+	rebuildIds, err := parseIdList(*locationsRebuildIds)
+	if err != nil {
+		return err
+	}
+	tileSize := *locationsTileSize
+	// Only full runs are checkpointed, tiled relations are processed after
+	// all others so they cannot be.
+	var queue *checkpointQueue
+	if relId < 0 && onlyIds == nil && tileSize <= 0 {
+		queue = newCheckpointQueue()
+	}
+	var resume *Checkpoint
+	if *locationsResume {
+		if queue == nil {
+			return fmt.Errorf("--resume cannot be combined with --id, " +
+				"--only-ids or --tile-size")
+		}
+		resume, err = getResumeCheckpoint(db, indexLocationsCheckpoint)
+		if err != nil {
+			return err
+		}
+	}
+	tracker, err := newCheckpointTracker(r, RelationKind, resume)
+	if err != nil {
+		return err
+	}
+	slog.Info("loading existing locations")
+	existing, err := db.ListLocationIds()
+	if err != nil {
+		return err
 	}
-	pendings := make(chan Request)
-	results := make(chan Request)
+	slog.Info("existing locations", "count", len(existing))
+	pendings := make(chan locationResult)
+	results := make(chan locationResult)
 	running := sync.WaitGroup{}
 	done := make(chan bool)
 	for i := 0; i < workers; i++ {
@@ -110,30 +370,69 @@ func locationsFn() error {
 	}()
 	seen := 0
 	converted := 0
-	go func() {
-		for rq := range results {
-			seen++
-			if seen%100 == 0 {
-				fmt.Printf("converted %d/%d\n", converted, seen)
-			}
-			rel := rq.Relation
-			if rq.Err != nil {
-				level := getTag(rel, "admin_level")
-				fmt.Printf("ERROR %s(%d)[level=%s]: %s\n", rel.Name(), rel.Id,
-					level, rq.Err)
-				continue
+	// First failure stopping the command, with --on-error fail
+	var failure error
+	failureLock := sync.Mutex{}
+	getFailure := func() error {
+		failureLock.Lock()
+		defer failureLock.Unlock()
+		return failure
+	}
+	report := func(rq locationResult) {
+		seen++
+		if queue != nil {
+			queue.Done(rq.Seq)
+		}
+		if seen%100 == 0 {
+			slog.Info("converted", "count", converted, "seen", seen)
+			if queue != nil && queue.Last() != nil {
+				err := db.PutCheckpoint(indexLocationsCheckpoint, queue.Last())
+				if err != nil {
+					slog.Error("cannot save checkpoint", "error", err)
+				}
 			}
-			if rq.Location == nil {
-				continue
+		}
+		rel := rq.Relation
+		if rq.Err != nil {
+			runSummary.Add("failed", 1)
+			err := elementError(relationElement(rel), "cannot build location",
+				rq.Err, relationAttr(rel))
+			if err != nil {
+				failureLock.Lock()
+				if failure == nil {
+					failure = err
+				}
+				failureLock.Unlock()
+			}
+			if debugDir != "" {
+				err := dumpRingDebug(debugDir, rel, db, rq.Err)
+				if err != nil {
+					slog.Error("cannot dump rings", relationAttr(rel),
+						"error", err)
+				}
 			}
-			converted++
+			return
+		}
+		if rq.Location == nil {
+			return
+		}
+		converted++
+	}
+	go func() {
+		for rq := range results {
+			report(rq)
 		}
 		close(done)
 	}()
 
+	tiles := map[TileKey][]*Relation{}
+
 	stop := false
 	for r.Next() && !stop {
-		if r.Kind() != RelationKind {
+		if getFailure() != nil {
+			break
+		}
+		if !tracker.Next(r) {
 			continue
 		}
 		rel := r.Relation()
@@ -144,22 +443,58 @@ func locationsFn() error {
 				stop = true
 			}
 		}
-		if ok, err := ignoreRelation(rel); ok || err != nil {
+		if !shard.Contains(rel.Id) {
+			continue
+		}
+		if onlyIds != nil && !onlyIds[rel.Id] {
+			continue
+		}
+		if ok, err := skipRelation(rel); ok || err != nil {
 			if err != nil {
 				return err
 			}
 			continue
 		}
-		ok, err := db.HasLocation(rel.Id)
-		if err != nil {
-			return err
+		if existing[rel.Id] {
+			if !*locationsForce && !rebuildIds[rel.Id] {
+				reason, err := getLocationRebuildReason(rel, db,
+					*locationsRebuild)
+				if err != nil {
+					return err
+				}
+				if reason == "" {
+					// Locations built by older versions were stored
+					// without their relation
+					err = storeMissingRelation(rel, db)
+					if err != nil {
+						return err
+					}
+					runSummary.Skip("existing")
+					continue
+				}
+				runSummary.Add(reason, 1)
+			}
+			err = db.DeleteLocation(rel.Id)
+			if err != nil {
+				return err
+			}
 		}
-		if ok {
-			continue
+		if tileSize > 0 {
+			k, err := getRelationTile(rel, db, tileSize)
+			if err != nil {
+				return err
+			}
+			if k != noTile {
+				tiles[k] = append(tiles[k], rel.Clone())
+				continue
+			}
 		}
-		rq := Request{
+		rq := locationResult{
 			Relation: rel.Clone(),
 		}
+		if queue != nil {
+			rq.Seq = queue.Add(tracker.Checkpoint(rel.Id))
+		}
 		pendings <- rq
 	}
 	close(pendings)
@@ -167,9 +502,96 @@ func locationsFn() error {
 		return r.Err()
 	}
 	<-done
+	if err := getFailure(); err != nil {
+		return err
+	}
+	if err := tracker.Err(); err != nil {
+		return err
+	}
+	if len(tiles) > 0 {
+		slog.Info("processing tiles", "count", len(tiles))
+		err = buildLocationsByTile(tiles, db, *locationsDb, workers, report)
+		if err != nil {
+			return err
+		}
+		if err := getFailure(); err != nil {
+			return err
+		}
+	}
+	indexed, err := db.BuildSpatialIndex()
+	if err != nil {
+		return err
+	}
+	if queue != nil {
+		err = db.DeleteCheckpoint(indexLocationsCheckpoint)
+		if err != nil {
+			return err
+		}
+	}
 	end := time.Now()
 	duration := (end.Sub(start) / time.Second)
-	fmt.Printf("written: %d/%d in %ds\n", converted, seen, duration)
+	slog.Info("written", "count", converted, "seen", seen,
+		"duration_s", int64(duration), "indexed", indexed)
+	runSummary.Add("converted", converted)
+	runSummary.Add("seen", seen)
+	return nil
+}
+
+func storeMissingRelation(rel *Relation, db *WaysDb) error {
+	stored, err := db.GetRelation(rel.Id)
+	if err != nil || stored != nil {
+		return err
+	}
+	return db.PutRelation(rel)
+}
+
+// Runs dryRunFn on the indexlocations input, skipping or rebuilding the
+// locations already in its db, if any, like a real run would.
+func dryRunLocationsFn() error {
+	if _, err := os.Stat(*locationsDb); err != nil {
+		if os.IsNotExist(err) {
+			return dryRunFn(*locationsPath, nil)
+		}
+		return err
+	}
+	db, err := OpenWaysDb(*locationsDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	ids, err := db.ListLocationIds()
+	if err != nil {
+		return err
+	}
+	rebuildIds, err := parseIdList(*locationsRebuildIds)
+	if err != nil {
+		return err
+	}
+	return dryRunFn(*locationsPath, &DryRunLocations{
+		Db:         db,
+		Ids:        ids,
+		Force:      *locationsForce,
+		RebuildIds: rebuildIds,
+		Rebuild:    *locationsRebuild,
+	})
+}
+
+// Prints what indexing and exporting path would process with the current
+// selection rules, given the existing locations if not nil. Other db entries
+// and --id or --shard restrictions are not taken into account.
+func dryRunFn(path string, existing *DryRunLocations) error {
+	start := time.Now()
+	d, err := dryRun(path, existing)
+	if err != nil {
+		return err
+	}
+	d.Print()
+	slog.Info("scanned", "duration_s",
+		int64(time.Now().Sub(start)/time.Second))
 	return nil
 }
 
@@ -184,12 +606,77 @@ func parseRelId(s string) (int64, error) {
 	return id, nil
 }
 
+// Parses a distance in degrees, or in meters with a "m" suffix, converted
+// with the length of a latitude degree. Returns 0 for an empty string.
+func parseDistance(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	meters := strings.HasSuffix(s, "m")
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "m"), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid distance: %s", s)
+	}
+	if meters {
+		v /= 111320
+	}
+	return v, nil
+}
+
+// Parses a comma separated list of ids. Returns nil for an empty list.
+func parseIdList(s string) (map[int64]bool, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	ids := map[int64]bool{}
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id list %q: %s", s, err)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
 var (
 	geojsonCmd     = app.Command("geojson", "convert o5m to geojson")
 	geojsonPath    = geojsonCmd.Arg("path", "o5m file path").Required().String()
 	geojsonDb      = geojsonCmd.Arg("db", "db path").Required().String()
 	geojsonOutpath = geojsonCmd.Arg("outpath", "jsonl output path").Required().String()
 	geojsonId      = geojsonCmd.Flag("id", "relation id").String()
+	geojsonWorkers = geojsonCmd.Flag("workers",
+		"relation building and JSON encoding workers count").
+		Default("1").Int()
+	geojsonKeep = geojsonCmd.Flag("keep",
+		"select relations matching this tag expression instead of administrative boundaries").
+		String()
+	geojsonShard = geojsonCmd.Flag("shard",
+		"only export relations of shard i/N").String()
+	geojsonProtected = geojsonCmd.Flag("protected-areas",
+		"select protected areas and national parks instead of administrative boundaries").
+		Bool()
+	geojsonFormat = geojsonCmd.Flag("format",
+		"output Elasticsearch documents, RFC 7946 features, a feature collection "+
+			"or PostgreSQL COPY lines with WKT or hex EWKB geometries").
+		Default(FormatES).Enum(FormatES, FormatFeatures, FormatCollection,
+		FormatWKT, FormatWKB)
+	geojsonPrecision = geojsonCmd.Flag("precision",
+		"round coordinates to this number of decimals, -1 to keep them").
+		Default("-1").Int()
+	geojsonMaxPoints = geojsonCmd.Flag("max-points",
+		"simplify shapes with more points than this, 0 to disable").
+		Default("0").Int()
+	geojsonDryRun = geojsonCmd.Flag("dry-run",
+		"report what would be processed without writing anything").Bool()
+	geojsonCompress = geojsonCmd.Flag("compress",
+		"output compression, auto uses the output file extension").
+		Default(CompressAuto).Enum(CompressionValues...)
+	geojsonTimezone = geojsonCmd.Flag("timezone",
+		"attach the timezone tag of boundaries").Bool()
+	geojsonTimezoneBoundaries = geojsonCmd.Flag("timezone-boundaries",
+		"attach the timezone of this GeoJSON polygons file containing the "+
+			"boundaries center, when they have no timezone tag").String()
 )
 
 func geojsonFn() error {
@@ -202,9 +689,31 @@ func geojsonFn() error {
 	if err != nil {
 		return err
 	}
+	shard, err := parseShard(*geojsonShard)
+	if err != nil {
+		return err
+	}
+	err = setKeepFilter(*geojsonKeep, *geojsonProtected)
+	if err != nil {
+		return err
+	}
+	if *geojsonDryRun {
+		return dryRunFn(*geojsonPath, nil)
+	}
+	attachTimezones = *geojsonTimezone || *geojsonTimezoneBoundaries != ""
+	if *geojsonTimezoneBoundaries != "" {
+		slog.Info("loading timezone boundaries",
+			"path", *geojsonTimezoneBoundaries)
+		idx, err := loadTimezoneBoundaries(*geojsonTimezoneBoundaries)
+		if err != nil {
+			return err
+		}
+		slog.Info("loaded timezone boundaries", "count", len(idx.Areas))
+		timezoneBoundaries = idx
+	}
 
 	start := time.Now()
-	r, err := NewO5MReader(*geojsonPath, NodeKind, WayKind)
+	r, err := OpenOSMReader(*geojsonPath, NodeKind, WayKind)
 	if err != nil {
 		return err
 	}
@@ -212,11 +721,77 @@ func geojsonFn() error {
 	if err != nil {
 		return err
 	}
-	outFp, err := os.Create(*geojsonOutpath)
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	outFp, err := CreateOutputFile(*geojsonOutpath, *geojsonCompress)
 	if err != nil {
 		return err
 	}
-	defer outFp.Close()
+	defer outFp.Abort()
+	format := *geojsonFormat
+	if format == FormatCollection {
+		_, err = outFp.Write([]byte(`{"type":"FeatureCollection","features":[` + "\n"))
+		if err != nil {
+			return err
+		}
+	}
+	out := NewParallelMarshaler(outFp, *geojsonWorkers)
+	defer out.Close()
+	if format == FormatCollection {
+		out.SetSeparator([]byte(","))
+	}
+	bbox := NewBBox()
+	bboxLock := sync.Mutex{}
+	// Builds the document of rel, or returns nil if it cannot be built. It
+	// runs in the marshaler workers.
+	convert := func(rel *Relation) (interface{}, error) {
+		js, err := buildRelation(rel, db)
+		if err != nil {
+			runSummary.Add("failed", 1)
+			return nil, elementError(relationElement(rel),
+				"cannot build relation", err, relationAttr(rel))
+		}
+		if js == nil {
+			return nil, nil
+		}
+		if *geojsonPrecision >= 0 {
+			js.Location = *quantizeLocation(&js.Location, *geojsonPrecision)
+		}
+		if *geojsonMaxPoints > 0 {
+			before := countLocationPoints(&js.Location)
+			loc, tolerance := simplifyLocation(&js.Location, *geojsonMaxPoints)
+			if tolerance > 0 {
+				slog.Debug("simplified", relationAttr(rel), "before", before,
+					"after", countLocationPoints(loc), "tolerance", tolerance)
+			}
+			js.Location = *loc
+		}
+		if len(js.Location.Coordinates) == 0 {
+			return nil, elementError(relationElement(rel),
+				"cannot export relation", errEmptyShape, relationAttr(rel))
+		}
+		switch format {
+		case FormatES:
+			return &ESDoc{
+				Id:     js.Id,
+				Type:   "boundary",
+				Source: js,
+			}, nil
+		case FormatWKT, FormatWKB:
+			return &geometryLine{
+				js:  js,
+				wkb: format == FormatWKB,
+			}, nil
+		}
+		feature, featureBBox := makeFeature(js)
+		bboxLock.Lock()
+		bbox.Merge(featureBBox)
+		bboxLock.Unlock()
+		return feature, nil
+	}
 
 	seen := 0
 	stop := false
@@ -231,391 +806,270 @@ func geojsonFn() error {
 			}
 			stop = true
 		}
-		if ok, err := ignoreRelation(rel); ok || err != nil {
+		if !shard.Contains(rel.Id) {
+			continue
+		}
+		if ok, err := skipRelation(rel); ok || err != nil {
 			if err != nil {
 				return err
 			}
 			continue
 		}
-		js, err := buildRelation(rel, db)
+		rel = rel.Clone()
+		err = out.Write(DocBuilderFunc(func() (interface{}, error) {
+			return convert(rel)
+		}))
 		if err != nil {
-			fmt.Printf("ERROR: %s(%d): %s\n", rel.Name(), rel.Id, err)
-			continue
-		}
-		if js == nil {
-			continue
-		}
-		doc := ESDoc{
-			Id:     js.Id,
-			Type:   "boundary",
-			Source: js,
+			return err
 		}
-		data, err := json.Marshal(&doc)
-		fmt.Fprintln(outFp, string(data))
 		seen++
 		if seen%1000 == 0 {
-			fmt.Println("converted", seen)
+			slog.Info("converted", "count", seen)
 		}
 	}
 	if r.Err() != nil {
 		return r.Err()
 	}
-	end := time.Now()
-	duration := (end.Sub(start) / time.Second)
-	fmt.Printf("written: %d in %ds\n", seen, duration)
-	return nil
-}
-
-func indexWays(r *O5MReader, nodes NodePoints, db *WaysDb) error {
-	i := 0
-	for r.Next() {
-		if r.Kind() != WayKind {
-			continue
-		}
-		w := r.Way()
-		ring, err := buildLinestring(w, nodes)
-		if err != nil {
-			return err
+	written, err := out.Close()
+	if err != nil {
+		return err
+	}
+	if format == FormatCollection {
+		footer := `]`
+		if b := bbox.Slice(); b != nil {
+			footer += fmt.Sprintf(`,"bbox":[%v,%v,%v,%v]`, b[0], b[1], b[2], b[3])
 		}
-		err = db.Put(ring)
+		_, err = outFp.Write([]byte(footer + "}\n"))
 		if err != nil {
 			return err
 		}
-		i++
-		if (i % 100) == 0 {
-			fmt.Println("indexed", i)
-		}
 	}
-	return r.Err()
-}
-
-var (
-	indexWaysCmd = app.Command("indexways", "index ways in k/v store")
-	indexWaysO5m = indexWaysCmd.Arg("o5mPath", "o5m file path").Required().String()
-	indexWaysDb  = indexWaysCmd.Arg("dbPath", "output DB path").Required().String()
+	err = outFp.Commit()
+	if err != nil {
+		return err
+	}
+	end := time.Now()
+	duration := (end.Sub(start) / time.Second)
+	slog.Info("written", "count", written, "duration_s", int64(duration))
+	runSummary.Add("written", written)
+	return nil
+}
+
+var (
+	topojsonCmd = app.Command("topojson",
+		"export boundaries as TopoJSON, sharing borders between areas")
+	topojsonPath    = topojsonCmd.Arg("o5mPath", "o5m file path").Required().String()
+	topojsonDb      = topojsonCmd.Arg("db", "locations db path").Required().String()
+	topojsonOutpath = topojsonCmd.Arg("outpath", "output TopoJSON file").
+			Required().String()
+	topojsonKeep = topojsonCmd.Flag("keep",
+		"select relations matching this tag expression instead of administrative boundaries").
+		String()
+	topojsonProtected = topojsonCmd.Flag("protected-areas",
+		"select protected areas and national parks instead of administrative boundaries").
+		Bool()
+	topojsonPrecision = topojsonCmd.Flag("precision",
+		"quantize coordinates to this number of decimals").Default("7").Int()
+	topojsonCompress = topojsonCmd.Flag("compress",
+		"output compression, auto uses the output file extension").
+		Default(CompressAuto).Enum(CompressionValues...)
 )
 
-func indexWaysFn() error {
-	r, err := NewO5MReader(*indexWaysO5m)
+// Shapes are kept in memory until all relations are read, since arcs can
+// only be computed once every border is known.
+func topojsonFn() error {
+	err := setKeepFilter(*topojsonKeep, *topojsonProtected)
 	if err != nil {
 		return err
 	}
-	if _, err := os.Stat(*indexWaysDb); err == nil {
-		err = os.Remove(*indexWaysDb)
-		if err != nil {
-			return err
-		}
+	if *topojsonPrecision < 0 || *topojsonPrecision > 9 {
+		return fmt.Errorf("invalid precision: %d", *topojsonPrecision)
 	}
-	db, err := OpenWaysDb(*indexWaysDb)
+	r, err := OpenOSMReader(*topojsonPath, NodeKind, WayKind)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	db, err := OpenWaysDb(*topojsonDb)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	nodes, err := buildNodeArray(r)
+	err = loadDuplicateRelations(db)
 	if err != nil {
 		return err
 	}
-	return indexWays(r, nodes, db)
-}
-
-func indexRelations(r *O5MReader, db *WaysDb) error {
-	// List relations to collect
-	fmt.Println("listing relations to collect")
-	kept := map[int64]bool{}
-	resets := []ResetPoint{}
+	features := []*Feature{}
 	for r.Next() {
 		if r.Kind() != RelationKind {
-			if r.Kind() == ResetKind {
-				resets = append(resets, r.ResetPoint())
-			}
 			continue
 		}
 		rel := r.Relation()
-		if isMultilineString(rel) {
-			kept[rel.Id] = true
+		if ok, err := ignoreRelation(rel); ok || err != nil {
+			if err != nil {
+				return err
+			}
 			continue
 		}
-		for _, ref := range rel.Refs {
-			if ref.Type != 2 {
-				continue
-			}
-			if ref.Role == "inner" || ref.Role == "outer" ||
-				rel.Id == 11980 && ref.Role == "subarea" {
-				kept[ref.Id] = true
+		js, err := buildRelation(rel, db)
+		if err != nil {
+			err = elementError(relationElement(rel), "cannot build relation",
+				err, relationAttr(rel))
+			if err != nil {
+				return err
 			}
+			continue
+		}
+		if js == nil {
+			continue
 		}
+		feature, _ := makeFeature(js)
+		feature.BBox = nil
+		features = append(features, feature)
 	}
-	if len(resets) != 3 {
-		return fmt.Errorf("could not collect reset points")
+	if r.Err() != nil {
+		return r.Err()
 	}
-	fmt.Println("collecting")
-	err := r.Seek(resets[2])
+	topo := buildTopology(features, *topojsonPrecision)
+	data, err := json.Marshal(topo)
 	if err != nil {
 		return err
 	}
-	i := 0
-	for r.Next() {
-		if r.Kind() != RelationKind {
-			continue
-		}
-		rel := r.Relation()
-		if !kept[rel.Id] {
-			continue
-		}
-		fmt.Println("indexing", rel.Id, rel.Name())
-		err := db.PutRelation(rel)
-		if err != nil {
-			return err
-		}
-		i++
-		if (i % 100) == 0 {
-			fmt.Println("indexed", i)
-		}
+	outFp, err := CreateOutputFile(*topojsonOutpath, *topojsonCompress)
+	if err != nil {
+		return err
 	}
-	fmt.Println("indexed", i)
-	return r.Err()
-}
-
-var (
-	indexRelationsCmd = app.Command("indexrelations",
-		"index multistring relations in k/v store")
-	indexRelationsO5m = indexRelationsCmd.Arg("o5mPath", "o5m file path").
-				Required().String()
-	indexRelationsDb = indexRelationsCmd.Arg("dbPath", "output DB path").
-				Required().String()
-)
-
-func indexRelationsFn() error {
-	r, err := NewO5MReader(*indexRelationsO5m, NodeKind, WayKind)
+	defer outFp.Abort()
+	_, err = outFp.Write(append(data, '\n'))
 	if err != nil {
 		return err
 	}
-	db, err := OpenWaysDb(*indexRelationsDb)
+	err = outFp.Commit()
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	return indexRelations(r, db)
+	slog.Info("written", "boundaries", len(features), "arcs", len(topo.Arcs))
+	return nil
 }
 
 var (
-	indexCentersCmd = app.Command("indexcenters", "index relations admin_center")
-	indexCentersO5m = indexCentersCmd.Arg("o5mPath", "o5m file path").
-			Required().String()
-	indexCentersDb = indexCentersCmd.Arg("db", "locations db path").
-			Required().String()
-	indexCentersId = indexCentersCmd.Flag("id", "relation id").String()
+	shapefileCmd = app.Command("shapefile",
+		"export boundaries as an ESRI shapefile")
+	shapefilePath    = shapefileCmd.Arg("o5mPath", "o5m file path").Required().String()
+	shapefileDb      = shapefileCmd.Arg("db", "locations db path").Required().String()
+	shapefileOutpath = shapefileCmd.Arg("outpath",
+		"output .shp file, .shx, .dbf, .prj and .cpg files are written next to it").
+		Required().String()
+	shapefileKeep = shapefileCmd.Flag("keep",
+		"select relations matching this tag expression instead of administrative boundaries").
+		String()
+	shapefileProtected = shapefileCmd.Flag("protected-areas",
+		"select protected areas and national parks instead of administrative boundaries").
+		Bool()
 )
 
-func indexCentersFn() error {
-	// Collect admin_center nodes
-	db, err := OpenWaysDb(*indexCentersDb)
+func shapefileFn() error {
+	err := setKeepFilter(*shapefileKeep, *shapefileProtected)
+	if err != nil {
+		return err
+	}
+	r, err := OpenOSMReader(*shapefilePath, NodeKind, WayKind)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	db, err := OpenWaysDb(*shapefileDb)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	nodeIds := map[int64][]int64{}
-	r, err := NewO5MReader(*indexCentersO5m, NodeKind, WayKind)
+	err = loadDuplicateRelations(db)
 	if err != nil {
 		return err
 	}
-	relId, err := parseRelId(*indexCentersId)
+	base := strings.TrimSuffix(*shapefileOutpath, filepath.Ext(*shapefileOutpath))
+	w, err := CreateShapefile(base)
 	if err != nil {
 		return err
 	}
-	stop := false
-	polygons := 0
-	indexed := 0
-	for r.Next() && !stop {
+	defer w.Abort()
+	for r.Next() {
 		if r.Kind() != RelationKind {
 			continue
 		}
 		rel := r.Relation()
-		if relId >= 0 {
-			if relId != rel.Id {
-				continue
-			}
-			stop = true
-		}
 		if ok, err := ignoreRelation(rel); ok || err != nil {
 			if err != nil {
 				return err
 			}
 			continue
 		}
-		loc, err := db.GetLocation(rel.Id)
+		js, err := buildRelation(rel, db)
 		if err != nil {
-			return err
-		}
-		polygons++
-		if loc == nil || len(loc.Coordinates) == 0 {
-			continue
-		}
-		centerId := int64(-1)
-		for _, ref := range rel.Refs {
-			if ref.Type == 0 && (ref.Role == "admin_center" || ref.Role == "admin_centre") {
-				centerId = ref.Id
+			err = elementError(relationElement(rel), "cannot build relation",
+				err, relationAttr(rel))
+			if err != nil {
+				return err
 			}
-		}
-		if centerId >= 0 {
-			nodeIds[centerId] = append(nodeIds[centerId], rel.Id)
 			continue
 		}
-		c, err := computeCentroid(loc)
-		if err != nil {
-			level := getTag(rel, "admin_level")
-			fmt.Printf("cannot compute centroid: %s(%d)[level=%s]: %s\n",
-				rel.Name(), rel.Id, level, err)
+		if js == nil {
 			continue
 		}
-		if c != nil {
-			/*
-				level := getTag(rel, "admin_level")
-					fmt.Printf("CENTROID %s(%d)[level=%s]: %f,%f\n", rel.Name(), rel.Id, level,
-						c.Lon, c.Lat)
-			*/
-			indexed++
-			err = db.PutCentroid(rel.Id, c)
-			if err != nil {
-				return err
-			}
-		} else {
-			level := getTag(rel, "admin_level")
-			fmt.Printf("cannot get admin_center: %s(%d)[level=%s]\n",
-				rel.Name(), rel.Id, level)
+		err = w.Write(js)
+		if err != nil {
+			return err
 		}
 	}
 	if r.Err() != nil {
 		return r.Err()
 	}
-
-	r, err = NewO5MReader(*indexCentersO5m)
+	err = w.Commit()
 	if err != nil {
 		return err
 	}
-	seenNode := false
-	for r.Next() && len(nodeIds) > 0 {
-		if r.Kind() != NodeKind {
-			if seenNode && r.Kind() == ResetKind {
-				break
-			}
-			continue
-		}
-		seenNode = true
-		n := r.Node()
-		c := &Centroid{
-			NodeId: n.Id,
-			Lon:    float64(n.Lon) / 1e7,
-			Lat:    float64(n.Lat) / 1e7,
-		}
-		relIds := nodeIds[n.Id]
-		for _, relId := range relIds {
-			err = db.PutCentroid(relId, c)
-			if err != nil {
-				return err
-			}
-			indexed++
-		}
-		delete(nodeIds, n.Id)
-	}
-	fmt.Printf("indexed: %d/%d\n", indexed, polygons)
+	slog.Info("written", "boundaries", w.count)
 	return nil
 }
 
 var (
-	printNodesCmd = app.Command("printnodes", "print node ids and lat/lng")
-	printNodesO5m = printNodesCmd.Arg("o5mPath", "o5m file path").
-			Required().String()
+	gpkgCmd = app.Command("gpkg",
+		"export boundaries and centroids as a GeoPackage, one layer per admin_level")
+	gpkgPath    = gpkgCmd.Arg("o5mPath", "o5m file path").Required().String()
+	gpkgDb      = gpkgCmd.Arg("db", "locations db path").Required().String()
+	gpkgOutpath = gpkgCmd.Arg("outpath", "output GeoPackage file").Required().String()
+	gpkgKeep    = gpkgCmd.Flag("keep",
+		"select relations matching this tag expression instead of administrative boundaries").
+		String()
+	gpkgProtected = gpkgCmd.Flag("protected-areas",
+		"select protected areas and national parks instead of administrative boundaries").
+		Bool()
 )
 
-func formatCoord(c int64) string {
-	s := fmt.Sprintf("%f", float64(c)/1e7)
-	if !strings.ContainsRune(s, '.') {
-		s += ".0"
-	}
-	return s
-}
-
-func printNodesFn() error {
-	r, err := NewO5MReader(*printNodesO5m, WayKind, RelationKind)
+func gpkgFn() error {
+	err := setKeepFilter(*gpkgKeep, *gpkgProtected)
 	if err != nil {
 		return err
 	}
-	count := 0
-	resets := 0
-	for r.Next() {
-		if r.Kind() != NodeKind {
-			if r.Kind() == ResetKind {
-				resets++
-				if resets > 1 {
-					break
-				}
-			}
-			continue
-		}
-		n := r.Node()
-		fmt.Printf("%d %s %s\n", n.Id, formatCoord(n.Lat), formatCoord(n.Lon))
-		count++
+	r, err := OpenOSMReader(*gpkgPath, NodeKind, WayKind)
+	if err != nil {
+		return err
 	}
-	fmt.Println(count, "nodes")
-	return r.Err()
-}
-
-var (
-	printXmlNodesCmd = app.Command("printxmlnodes",
-		"print node ids and lat/lng from osm file")
-	printXmlNodesPath = printXmlNodesCmd.Arg("osmPath", "osm file path").
-				Required().String()
-)
-
-var (
-	// <node id="135821" lat="45.191733" lon="5.7346073"
-	reNode = regexp.MustCompile(
-		`^\s*<node\s+id="([^"]+)"\s+lat="([^"]+)"\s+lon="([^"]+)"`)
-)
-
-func printXmlNodesFn() error {
-	fp, err := os.Open(*printXmlNodesPath)
+	defer r.Close()
+	db, err := OpenWaysDb(*gpkgDb)
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
-
-	count := 0
-	prefix := []byte("<node")
-	scanner := bufio.NewScanner(fp)
-	for scanner.Scan() {
-		data := scanner.Bytes()
-		data = bytes.TrimSpace(data)
-		if !bytes.HasPrefix(data, prefix) {
-			continue
-		}
-		count++
-		m := reNode.FindSubmatch(data)
-		if m == nil {
-			return fmt.Errorf("could not match node line: %s", string(data))
-		}
-		id := string(m[1])
-		lat := string(m[2])
-		lon := string(m[3])
-		fmt.Println(id, lat, lon)
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
 	}
-	fmt.Println(count, "nodes")
-	return scanner.Err()
-}
-
-var (
-	recursiveRelCmd = app.Command("recursiverel",
-		"print relations made of other relations")
-	recursiveRelO5m = recursiveRelCmd.Arg("o5mPath", "o5m file path").
-			Required().String()
-)
-
-func recursiveRelFn() error {
-	r, err := NewO5MReader(*recursiveRelO5m, NodeKind, WayKind)
+	w, err := CreateGpkg(*gpkgOutpath)
 	if err != nil {
 		return err
 	}
+	defer w.Abort()
 	for r.Next() {
 		if r.Kind() != RelationKind {
 			continue
@@ -627,110 +1081,2460 @@ func recursiveRelFn() error {
 			}
 			continue
 		}
-		ways := 0
-		relations := 0
-		for _, ref := range rel.Refs {
-			if ref.Type == 1 {
-				ways++
-			} else if ref.Type == 2 {
-				if ref.Role == "outer" || ref.Role == "inner" {
-					relations++
-				}
+		js, err := buildRelation(rel, db)
+		if err != nil {
+			err = elementError(relationElement(rel), "cannot build relation",
+				err, relationAttr(rel))
+			if err != nil {
+				return err
 			}
+			continue
 		}
-		if relations == 0 {
+		if js == nil {
 			continue
 		}
-		fmt.Printf("%s ways=%d\n", rel.String(), ways)
+		err = w.Write(js)
+		if err != nil {
+			return err
+		}
 	}
-	return r.Err()
+	if r.Err() != nil {
+		return r.Err()
+	}
+	counts := w.Counts()
+	err = w.Commit()
+	if err != nil {
+		return err
+	}
+	tables := []string{}
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		slog.Info("written", "table", table, "count", counts[table])
+	}
+	return nil
 }
 
-var (
-	checkCmd = app.Command("check", "check various properties of relations")
-	checkO5m = checkCmd.Arg("o5mPath", "o5m file path").Required().String()
+const (
+	// Number of entries written per db transaction by indexing commands
+	writeBatchSize = 10000
 )
 
-func checkFn() error {
-	r, err := NewO5MReader(*checkO5m, NodeKind, WayKind)
+const (
+	indexWaysCheckpoint      = "indexways"
+	indexLocationsCheckpoint = "indexlocations"
+)
+
+// Stores the ways of r, checkpointing progress with every batch. Ways up to
+// the resume checkpoint, if any, are skipped.
+func indexWays(r OSMReader, nodes NodeStore, db *WaysDb,
+	resume *Checkpoint) error {
+
+	tracker, err := newCheckpointTracker(r, WayKind, resume)
 	if err != nil {
 		return err
 	}
-	iso2Codes := map[string]string{}
-	iso3Codes := map[string]string{}
+	i := 0
+	repeated := 0
+	skipped := 0
+	batch := db.NewBatch(writeBatchSize)
 	for r.Next() {
-		if r.Kind() != RelationKind {
+		if !tracker.Next(r) {
 			continue
 		}
-		rel := r.Relation()
-		if ok, err := ignoreRelation(rel); ok || err != nil {
+		w := r.Way()
+		ring, err := buildLinestring(w, nodes)
+		if err != nil {
+			if _, ok := err.(*NodeNotFoundError); ok {
+				if missingNodesPolicy == MissingNodesSkip {
+					slog.Warn("skipping way", "way", w.Id, "error", err)
+					skipped++
+					continue
+				}
+				err = elementError(fmt.Sprintf("way/%d", w.Id),
+					"cannot build way", err, "way", w.Id)
+				if err != nil {
+					return err
+				}
+				runSummary.Add("failed", 1)
+				continue
+			}
+			return err
+		}
+		repeated += ring.RemoveRepeatedPoints()
+		err = batch.Put(ring)
+		if err != nil {
+			return err
+		}
+		i++
+		if (i % 100) == 0 {
+			slog.Info("indexed", "count", i)
+		}
+		if (i % writeBatchSize) == 0 {
+			err = batch.PutCheckpoint(indexWaysCheckpoint, tracker.Checkpoint(w.Id))
 			if err != nil {
-				fmt.Printf("error: %s: %s\n", rel.String(), err)
+				return err
+			}
+			err = batch.Flush()
+			if err != nil {
+				return err
 			}
-			continue
 		}
-		rt, err := NewRelationTags(rel)
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+	if err := tracker.Err(); err != nil {
+		return err
+	}
+	slog.Info("removed repeated points", "count", repeated)
+	if skipped > 0 {
+		slog.Warn("skipped ways with missing nodes", "count", skipped)
+	}
+	runSummary.Add("ways", i)
+	runSummary.Add("missing_nodes_ways", skipped)
+	err = batch.Flush()
+	if err != nil {
+		return err
+	}
+	return db.DeleteCheckpoint(indexWaysCheckpoint)
+}
+
+var (
+	indexWaysCmd    = app.Command("indexways", "index ways in k/v store")
+	indexWaysO5m    = indexWaysCmd.Arg("o5mPath", "o5m file path").Required().String()
+	indexWaysDb     = indexWaysCmd.Arg("dbPath", "output DB path").Required().String()
+	indexWaysDryRun = indexWaysCmd.Flag("dry-run",
+		"report what would be processed without writing anything").Bool()
+	indexWaysNodeCache = indexWaysCmd.Flag("node-cache",
+		"store nodes in this temporary file instead of memory").String()
+	indexWaysNodeCacheSize = indexWaysCmd.Flag("node-cache-size",
+		"number of 4KB node blocks cached in memory with --node-cache or "+
+			"--dense-nodes").
+		Default("65536").Int()
+	indexWaysDenseNodes = indexWaysCmd.Flag("dense-nodes",
+		"store nodes in the db by blocks of consecutive ids, for planet "+
+			"sized inputs").Bool()
+	indexWaysMissingNodes = indexWaysCmd.Flag("missing-nodes",
+		"fail on ways referencing missing nodes, skip them, or substitute "+
+			"the closest node of the way").
+		Default(MissingNodesError).
+		Enum(MissingNodesError, MissingNodesSkip, MissingNodesSubstitute)
+	indexWaysResume = indexWaysCmd.Flag("resume",
+		"continue an interrupted run from its last checkpoint instead of "+
+			"replacing the ways of the db").Bool()
+)
+
+func indexWaysFn() error {
+	if *indexWaysDryRun {
+		return dryRunFn(*indexWaysO5m, nil)
+	}
+	missingNodesPolicy = *indexWaysMissingNodes
+	r, err := OpenOSMReader(*indexWaysO5m)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(*indexWaysDb)
+	existing := err == nil
+	db, err := OpenWaysDb(*indexWaysDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if existing && !*indexWaysResume {
+		// Keep locations so indexlocations only rebuilds those whose
+		// members changed
+		slog.Info("replacing ways and relations, keeping locations")
+		err = db.ClearInputBuckets()
 		if err != nil {
-			fmt.Printf("error: %s: invalid tags: %s\n", rel.String(), err)
-			continue
+			return err
 		}
-		level, levelStr := rt.AdminLevel()
-		if level < 1 {
-			fmt.Printf("error: %s: invalid admin level: %s\n", rel.String(), levelStr)
-			continue
+	}
+	var resume *Checkpoint
+	if *indexWaysResume {
+		resume, err = getResumeCheckpoint(db, indexWaysCheckpoint)
+		if err != nil {
+			return err
 		}
-		if level != 2 {
-			continue
+	}
+	if *indexWaysDenseNodes {
+		// Dense blocks are 128 times larger than node cache ones
+		cacheSize := *indexWaysNodeCacheSize/128 + 1
+		nodes, err := buildDenseNodeArray(r, db, cacheSize, resume != nil)
+		if err != nil {
+			return err
 		}
-		iso2 := rt.CountryIso2()
-		if iso2 == "" {
-			fmt.Printf("error: %s: missing iso2 code\n", rel.String())
+		err = indexWays(r, nodes, db, resume)
+		slog.Info("node cache", "hits", nodes.Hits, "misses", nodes.Misses)
+		return err
+	}
+	if *indexWaysNodeCache != "" {
+		nodes, err := buildDiskNodeArray(r, *indexWaysNodeCache,
+			*indexWaysNodeCacheSize)
+		if err != nil {
+			return err
+		}
+		defer nodes.Close()
+		err = indexWays(r, nodes, db, resume)
+		slog.Info("node cache", "hits", nodes.Hits, "misses", nodes.Misses)
+		return err
+	}
+	nodes, err := buildNodeArray(r)
+	if err != nil {
+		return err
+	}
+	return indexWays(r, nodes, db, resume)
+}
+
+// Returns the checkpoint name of db, or nil to start from the beginning.
+func getResumeCheckpoint(db *WaysDb, name string) (*Checkpoint, error) {
+	cp, err := db.GetCheckpoint(name)
+	if err != nil {
+		return nil, err
+	}
+	if cp == nil {
+		slog.Warn("no checkpoint, starting from the beginning", "name", name)
+	} else {
+		slog.Info("resuming", "name", name, "id", cp.Id, "offset", cp.Offset)
+	}
+	return cp, nil
+}
+
+func indexRelations(r OSMReader, db *WaysDb) error {
+	// List relations to collect
+	slog.Info("listing relations to collect")
+	kept := map[int64]bool{}
+	err := r.SeekToKind(RelationKind)
+	if err != nil {
+		return err
+	}
+	for r.Next() {
+		if r.Kind() != RelationKind {
 			continue
 		}
-		if other, ok := iso2Codes[iso2]; ok {
-			fmt.Printf("error: %s: duplicate iso2 code: %s\n", rel.String(), other)
+		rel := r.Relation()
+		if isMultilineString(rel) {
+			kept[rel.Id] = true
 			continue
 		}
-		iso2Codes[iso2] = rel.String()
-
-		iso3 := rt.CountryIso3()
-		if iso3 == "" {
-			fmt.Printf("error: %s: missing iso3 code\n", rel.String())
+		for _, ref := range rel.Refs {
+			if ref.Type != 2 {
+				continue
+			}
+			if ref.Role == "inner" || ref.Role == "outer" ||
+				ref.Role == "subarea" && isSubareaRelation(rel) {
+				kept[ref.Id] = true
+			}
+		}
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+	slog.Info("collecting")
+	err = r.SeekToKind(RelationKind)
+	if err != nil {
+		return err
+	}
+	i := 0
+	batch := db.NewBatch(writeBatchSize)
+	for r.Next() {
+		if r.Kind() != RelationKind {
 			continue
 		}
-		if other, ok := iso3Codes[iso3]; ok {
-			fmt.Printf("error: %s: duplicate iso3 code: %s\n", rel.String(), other)
+		rel := r.Relation()
+		if !kept[rel.Id] {
 			continue
 		}
-		iso3Codes[iso3] = rel.String()
-		//fmt.Println(rt.Name(), rt.CountryIso2(), rt.CountryIso3())
+		slog.Debug("indexing", relationAttr(rel))
+		err := batch.PutRelation(rel)
+		if err != nil {
+			return err
+		}
+		i++
+		if (i % 100) == 0 {
+			slog.Info("indexed", "count", i)
+		}
 	}
-	return r.Err()
+	if r.Err() != nil {
+		return r.Err()
+	}
+	slog.Info("indexed", "count", i)
+	runSummary.Add("relations", i)
+	return batch.Flush()
 }
 
 var (
-	resetDbCmd    = app.Command("resetdb", "delete a bucket from feature db")
-	resetDbPath   = resetDbCmd.Arg("dbPath", "db path").Required().String()
-	resetDbBucket = resetDbCmd.Arg("bucket", "name of bucket to delete").Required().String()
+	indexRelationsCmd = app.Command("indexrelations",
+		"index multistring relations in k/v store")
+	indexRelationsO5m = indexRelationsCmd.Arg("o5mPath", "o5m file path").
+				Required().String()
+	indexRelationsDb = indexRelationsCmd.Arg("dbPath", "output DB path").
+				Required().String()
+	indexRelationsSkipDedup = indexRelationsCmd.Flag("skip-dedup-countries",
+		"do not pick one relation among those representing the same country").
+		Bool()
+	indexRelationsVariant = indexRelationsCmd.Flag("boundary-variant",
+		"preferred representation when a country has land and maritime boundaries").
+		Default(VariantAuto).Enum(VariantAuto, VariantLand, VariantMaritime)
+	indexRelationsDedupReport = indexRelationsCmd.Flag("dedup-report",
+		"write the country deduplication decisions to this JSON file").String()
 )
 
-func resetDbFn() error {
-	db, err := OpenWaysDb(*resetDbPath)
+func indexRelationsFn() error {
+	r, err := OpenOSMReader(*indexRelationsO5m, NodeKind, WayKind)
+	if err != nil {
+		return err
+	}
+	db, err := OpenWaysDb(*indexRelationsDb)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-
-	return db.DeleteBucket(*resetDbBucket)
+	err = indexRelations(r, db)
+	if err != nil || *indexRelationsSkipDedup {
+		return err
+	}
+	// Sub-relations are indexed, countries made of them can be scored
+	decisions, err := runCountryDedup(r, db, *indexRelationsVariant,
+		ioutil.Discard)
+	if err != nil {
+		return err
+	}
+	for _, d := range decisions {
+		rejected := []int64{}
+		for _, c := range d.Rejected {
+			rejected = append(rejected, c.Id)
+		}
+		slog.Info("country deduplicated", "iso2", d.Iso2, "kept", d.Kept.Id,
+			"rejected", rejected)
+	}
+	runSummary.Add("duplicate_countries", len(decisions))
+	if *indexRelationsDedupReport != "" {
+		return writeDedupReport(*indexRelationsDedupReport, decisions)
+	}
+	return nil
 }
 
-func dispatch() error {
-	cmd := kingpin.MustParse(app.Parse(os.Args[1:]))
+var (
+	indexCentersCmd = app.Command("indexcenters", "index relations admin_center")
+	indexCentersO5m = indexCentersCmd.Arg("o5mPath", "o5m file path").
+			Required().String()
+	indexCentersDb = indexCentersCmd.Arg("db", "locations db path").
+			Required().String()
+	indexCentersId   = indexCentersCmd.Flag("id", "relation id").String()
+	indexCentersKeep = indexCentersCmd.Flag("keep",
+		"select relations matching this tag expression instead of administrative boundaries").
+		String()
+	indexCentersShard = indexCentersCmd.Flag("shard",
+		"only process relations of shard i/N").String()
+	indexCentersDryRun = indexCentersCmd.Flag("dry-run",
+		"report what would be processed without writing anything").Bool()
+	indexCentersForce = indexCentersCmd.Flag("force-centroids",
+		"recompute existing centroids").Bool()
+	indexCentersOnlyIds = indexCentersCmd.Flag("only-ids",
+		"only process these comma separated relation ids").String()
+	indexCentersProtected = indexCentersCmd.Flag("protected-areas",
+		"select protected areas and national parks instead of administrative boundaries").
+		Bool()
+	indexCentersMethod = indexCentersCmd.Flag("centroid",
+		"centroid computation, the barycenter or a convex vertex diagonal, or "+
+			"the pole of inaccessibility which is always inside the polygon").
+		Default(CentroidHeuristic).Enum(CentroidHeuristic, CentroidPolylabel)
+	indexCentersPreference = indexCentersCmd.Flag("center-preference",
+		"comma separated center sources by decreasing preference, among "+
+			"label, admin_centre, capital and centroid").
+		Default(DefaultCenterPreference).String()
+)
+
+func indexCentersFn() error {
+	if *indexCentersDryRun {
+		err := setKeepFilter(*indexCentersKeep, *indexCentersProtected)
+		if err != nil {
+			return err
+		}
+		return dryRunFn(*indexCentersO5m, nil)
+	}
+	centroidMethod = *indexCentersMethod
+	// Collect admin_center nodes
+	db, err := OpenWaysDb(*indexCentersDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	// Relation references by label or admin_centre node, role being the
+	// center source
+	nodeIds := map[int64][]Ref{}
+	// Relation references by admin_centre or label node, role being the node
+	// role, for admin centre details
+	centreIds := map[int64][]Ref{}
+	relId, err := parseRelId(*indexCentersId)
+	if err != nil {
+		return err
+	}
+	shard, err := parseShard(*indexCentersShard)
+	if err != nil {
+		return err
+	}
+	err = setKeepFilter(*indexCentersKeep, *indexCentersProtected)
+	if err != nil {
+		return err
+	}
+	onlyIds, err := parseIdList(*indexCentersOnlyIds)
+	if err != nil {
+		return err
+	}
+	existing, err := db.ListCentroidIds()
+	if err != nil {
+		return err
+	}
+	preference, err := parseCenterPreference(*indexCentersPreference)
+	if err != nil {
+		return err
+	}
+	// Relations waiting for their node centers and the centers found so far,
+	// by relation and source
+	pending := []*Relation{}
+	found := map[int64]map[string]*Centroid{}
+	addFound := func(relId int64, source string, c *Centroid) {
+		if found[relId] == nil {
+			found[relId] = map[string]*Centroid{}
+		}
+		cc := *c
+		cc.Source = source
+		found[relId][source] = &cc
+	}
+	computeCentroids := false
+	for _, source := range preference {
+		if source == CenterSourceCentroid {
+			computeCentroids = true
+		}
+	}
+	capitals := NewAdminIndex()
+	capitalLevels := map[int64]int{}
+	polygons := 0
+	indexed := 0
+	skipped := 0
+	putComputedCentroid := func(rel *Relation, loc *Location) error {
+		c, err := computeCentroid(loc)
+		if err != nil {
+			slog.Warn("cannot compute centroid", relationAttr(rel),
+				"error", err)
+			return nil
+		}
+		if c == nil {
+			slog.Warn("cannot get admin_center", relationAttr(rel))
+			return nil
+		}
+		c.Source = CenterSourceCentroid
+		slog.Debug("centroid", relationAttr(rel), "lon", c.Lon,
+			"lat", c.Lat)
+		indexed++
+		return db.PutCentroid(rel.Id, c)
+	}
+	indexRelation := func(rel *Relation) error {
+		if relId >= 0 && relId != rel.Id {
+			return nil
+		}
+		if !shard.Contains(rel.Id) {
+			return nil
+		}
+		if onlyIds != nil && !onlyIds[rel.Id] {
+			return nil
+		}
+		if ok, err := skipRelation(rel); ok || err != nil {
+			return err
+		}
+		if existing[rel.Id] {
+			if !*indexCentersForce {
+				runSummary.Skip("existing")
+				skipped++
+				return nil
+			}
+			err := db.DeleteCentroid(rel.Id)
+			if err != nil {
+				return err
+			}
+		}
+		loc, err := db.GetLocation(rel.Id)
+		if err != nil {
+			return err
+		}
+		polygons++
+		if loc == nil || len(loc.Coordinates) == 0 {
+			return nil
+		}
+		centerId := int64(-1)
+		labelId := int64(-1)
+		for _, ref := range rel.Refs {
+			if ref.Type == 0 && (ref.Role == "admin_center" || ref.Role == "admin_centre") {
+				centerId = ref.Id
+			} else if ref.Type == 0 && ref.Role == "label" && labelId < 0 {
+				labelId = ref.Id
+			}
+		}
+		if centerId >= 0 {
+			centreIds[centerId] = append(centreIds[centerId],
+				Ref{Id: rel.Id, Type: 2, Role: "admin_centre"})
+		} else if labelId >= 0 {
+			centreIds[labelId] = append(centreIds[labelId],
+				Ref{Id: rel.Id, Type: 2, Role: "label"})
+		}
+		// Register every node source of the chain, the best one available is
+		// picked once nodes are read.
+		candidates := false
+		for _, source := range preference {
+			switch source {
+			case CenterSourceLabel:
+				if labelId >= 0 {
+					nodeIds[labelId] = append(nodeIds[labelId],
+						Ref{Id: rel.Id, Type: 2, Role: source})
+					candidates = true
+				}
+			case CenterSourceAdminCentre:
+				if centerId >= 0 {
+					nodeIds[centerId] = append(nodeIds[centerId],
+						Ref{Id: rel.Id, Type: 2, Role: source})
+					candidates = true
+				}
+			case CenterSourceCapital:
+				rt, err := NewRelationTags(rel)
+				if err != nil {
+					return err
+				}
+				level, _ := rt.AdminLevel()
+				capitals.Add(&AdminArea{
+					Id:          rel.Id,
+					Level:       level,
+					Coordinates: loc.Coordinates,
+				})
+				candidates = true
+			}
+		}
+		if candidates {
+			// Readers reuse relations, keep what logging needs
+			pending = append(pending, &Relation{
+				Id:   rel.Id,
+				Tags: append([]StringPair(nil), rel.Tags...),
+			})
+			return nil
+		}
+		if !computeCentroids {
+			slog.Warn("no preferred center found", relationAttr(rel))
+			return nil
+		}
+		return putComputedCentroid(rel, loc)
+	}
+	centres := 0
+	indexNode := func(n *Node) error {
+		if len(nodeIds) == 0 && len(centreIds) == 0 && len(capitals.Areas) == 0 {
+			return nil
+		}
+		c := &Centroid{
+			NodeId: n.Id,
+			Lon:    float64(n.Lon) / 1e7,
+			Lat:    float64(n.Lat) / 1e7,
+		}
+		for _, ref := range nodeIds[n.Id] {
+			addFound(ref.Id, ref.Role, c)
+		}
+		delete(nodeIds, n.Id)
+		if level := getCapitalLevel(n.Tags); level > 0 {
+			for _, area := range capitals.Lookup(c.Lon, c.Lat) {
+				// The capital of a region is not the capital of its
+				// country, unless it is the country capital too.
+				if area.Level >= 1 && level > area.Level {
+					continue
+				}
+				prev := capitalLevels[area.Id]
+				if prev > 0 && prev <= level {
+					continue
+				}
+				capitalLevels[area.Id] = level
+				addFound(area.Id, CenterSourceCapital, c)
+			}
+		}
+		for _, ref := range centreIds[n.Id] {
+			err := db.PutAdminCentre(ref.Id, makeAdminCentre(n, ref.Role))
+			if err != nil {
+				return err
+			}
+			centres++
+		}
+		delete(centreIds, n.Id)
+		return nil
+	}
+	plan := &ScanPlan{}
+	plan.Add(&ScanStage{
+		Kinds:   []int{RelationKind},
+		Handler: o5m.HandlerFuncs{Relation: indexRelation},
+	})
+	plan.Add(&ScanStage{
+		Kinds:   []int{NodeKind},
+		Handler: o5m.HandlerFuncs{Node: indexNode},
+	})
+	err = plan.Run(context.Background(), *indexCentersO5m)
+	if err != nil {
+		return err
+	}
+	for _, rel := range pending {
+		c := pickCenter(preference, found[rel.Id])
+		if c != nil {
+			indexed++
+			err = db.PutCentroid(rel.Id, c)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !computeCentroids {
+			slog.Warn("no preferred center found", relationAttr(rel))
+			continue
+		}
+		loc, err := db.GetLocation(rel.Id)
+		if err != nil {
+			return err
+		}
+		err = putComputedCentroid(rel, loc)
+		if err != nil {
+			return err
+		}
+	}
+	slog.Info("indexed", "count", indexed, "polygons", polygons,
+		"admin_centres", centres, "skipped", skipped)
+	runSummary.Add("indexed", indexed)
+	runSummary.Add("polygons", polygons)
+	runSummary.Add("admin_centres", centres)
+	return nil
+}
+
+var (
+	printNodesCmd = app.Command("printnodes", "print node ids and lat/lng")
+	printNodesO5m = printNodesCmd.Arg("o5mPath", "o5m file path").
+			Required().String()
+)
+
+func formatCoord(c int64) string {
+	s := fmt.Sprintf("%f", float64(c)/1e7)
+	if !strings.ContainsRune(s, '.') {
+		s += ".0"
+	}
+	return s
+}
+
+func printNodesFn() error {
+	r, err := OpenOSMReader(*printNodesO5m, WayKind, RelationKind)
+	if err != nil {
+		return err
+	}
+	count := 0
+	resets := 0
+	for r.Next() {
+		if r.Kind() != NodeKind {
+			if r.Kind() == ResetKind {
+				resets++
+				if resets > 1 {
+					break
+				}
+			}
+			continue
+		}
+		n := r.Node()
+		fmt.Printf("%d %s %s", n.Id, formatCoord(n.Lat), formatCoord(n.Lon))
+		if exportMetadata {
+			fmt.Print(formatMetadata(&n.Meta))
+		}
+		fmt.Println()
+		count++
+	}
+	fmt.Println(count, "nodes")
+	return r.Err()
+}
+
+var (
+	printXmlNodesCmd = app.Command("printxmlnodes",
+		"print node ids and lat/lng from osm file")
+	printXmlNodesPath = printXmlNodesCmd.Arg("osmPath", "osm file path").
+				Required().String()
+)
+
+func printXmlNodesFn() error {
+	r, err := NewOSMXMLReader(*printXmlNodesPath, WayKind, RelationKind)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	count := 0
+	for r.Next() {
+		if r.Kind() != NodeKind {
+			continue
+		}
+		n := r.Node()
+		count++
+		line := fmt.Sprint(n.Id, " ", formatCoord(n.Lat), " ", formatCoord(n.Lon))
+		if exportMetadata {
+			line += formatMetadata(&n.Meta)
+		}
+		fmt.Println(line)
+	}
+	fmt.Println(count, "nodes")
+	return r.Err()
+}
+
+var (
+	recursiveRelCmd = app.Command("recursiverel",
+		"print relations made of other relations")
+	recursiveRelO5m = recursiveRelCmd.Arg("o5mPath", "o5m file path").
+			Required().String()
+)
+
+func recursiveRelFn() error {
+	r, err := OpenOSMReader(*recursiveRelO5m, NodeKind, WayKind)
+	if err != nil {
+		return err
+	}
+	for r.Next() {
+		if r.Kind() != RelationKind {
+			continue
+		}
+		rel := r.Relation()
+		if ok, err := ignoreRelation(rel); ok || err != nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		ways := 0
+		relations := 0
+		for _, ref := range rel.Refs {
+			if ref.Type == 1 {
+				ways++
+			} else if ref.Type == 2 {
+				if ref.Role == "outer" || ref.Role == "inner" {
+					relations++
+				}
+			}
+		}
+		if relations == 0 {
+			continue
+		}
+		fmt.Printf("%s ways=%d\n", rel.String(), ways)
+	}
+	return r.Err()
+}
+
+var (
+	printRelationCmd = app.Command("printrelation",
+		"print a relation tags and members, with its stored location")
+	printRelationO5m = printRelationCmd.Arg("o5mPath", "o5m file path").
+				Required().String()
+	printRelationId = printRelationCmd.Arg("id", "relation id").
+			Required().Int64()
+	printRelationDb = printRelationCmd.Flag("db",
+		"also print the location and centroid stored in this db").String()
+)
+
+// Prints a relation read from the input file, resolving its members names
+// with a second scan.
+func printRelationFn() error {
+	var dump *RelationDump
+	plan := &ScanPlan{}
+	plan.Add(&ScanStage{
+		Kinds: []int{RelationKind},
+		Handler: o5m.HandlerFuncs{Relation: func(rel *Relation) error {
+			if rel.Id == *printRelationId {
+				dump = newRelationDump(rel)
+			}
+			return nil
+		}},
+		Done: func() error {
+			if dump == nil {
+				return fmt.Errorf("relation %d not found", *printRelationId)
+			}
+			return nil
+		},
+	})
+	plan.Add(&ScanStage{
+		Kinds: []int{NodeKind, WayKind, RelationKind},
+		Handler: o5m.HandlerFuncs{
+			Node: func(n *Node) error {
+				if dump.Wants(0, n.Id) {
+					dump.AddMember(0, n.Id, n.Tags)
+				}
+				return nil
+			},
+			Way: func(w *Way) error {
+				if dump.Wants(1, w.Id) {
+					dump.AddMember(1, w.Id, w.Tags)
+				}
+				return nil
+			},
+			Relation: func(rel *Relation) error {
+				if dump.Wants(2, rel.Id) {
+					dump.AddMember(2, rel.Id, rel.Tags)
+				}
+				return nil
+			},
+		},
+	})
+	err := plan.Run(context.Background(), *printRelationO5m)
+	if err != nil {
+		return err
+	}
+	if *printRelationDb != "" {
+		db, err := OpenWaysDb(*printRelationDb)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		dump.Location, err = db.GetLocation(dump.Relation.Id)
+		if err != nil {
+			return err
+		}
+		dump.Centroid, err = db.GetCentroid(dump.Relation.Id)
+		if err != nil {
+			return err
+		}
+	}
+	dump.Print(os.Stdout)
+	return nil
+}
+
+var (
+	printWayCmd = app.Command("printway",
+		"print a way as a GeoJSON LineString feature")
+	printWayId  = printWayCmd.Arg("id", "way id").Required().Int64()
+	printWayO5m = printWayCmd.Flag("o5m",
+		"read the way, its tags and nodes from this file").String()
+	printWayDb = printWayCmd.Flag("db",
+		"read the way from the ways of this db, without tags").String()
+)
+
+func printWayFn() error {
+	if (*printWayO5m == "") == (*printWayDb == "") {
+		return fmt.Errorf("either --o5m or --db must be set")
+	}
+	var feature debugFeature
+	if *printWayDb != "" {
+		db, err := OpenWaysDb(*printWayDb)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		ls, err := db.Get(*printWayId)
+		if err != nil {
+			return err
+		}
+		if ls == nil {
+			return fmt.Errorf("way %d not found", *printWayId)
+		}
+		feature = makeWayFeature(ls.Id, nil, ls.Points, 0)
+	} else {
+		var way *Way
+		wanted := map[int64]bool{}
+		points := map[int64]Point{}
+		plan := &ScanPlan{}
+		plan.Add(&ScanStage{
+			Kinds: []int{WayKind},
+			Handler: o5m.HandlerFuncs{Way: func(w *Way) error {
+				if w.Id == *printWayId {
+					way = &Way{
+						Id:    w.Id,
+						Nodes: append([]int64{}, w.Nodes...),
+						Tags:  copyTags(w.Tags),
+					}
+				}
+				return nil
+			}},
+			Done: func() error {
+				if way == nil {
+					return fmt.Errorf("way %d not found", *printWayId)
+				}
+				for _, id := range way.Nodes {
+					wanted[id] = true
+				}
+				return nil
+			},
+		})
+		plan.Add(&ScanStage{
+			Kinds: []int{NodeKind},
+			Handler: o5m.HandlerFuncs{Node: func(n *Node) error {
+				if wanted[n.Id] {
+					points[n.Id] = Point{Lon: n.Lon, Lat: n.Lat}
+				}
+				return nil
+			}},
+		})
+		err := plan.Run(context.Background(), *printWayO5m)
+		if err != nil {
+			return err
+		}
+		resolved := []Point{}
+		missing := 0
+		for _, id := range way.Nodes {
+			p, ok := points[id]
+			if !ok {
+				missing++
+				continue
+			}
+			resolved = append(resolved, p)
+		}
+		feature = makeWayFeature(way.Id, way.Tags, resolved, missing)
+	}
+	data, err := json.MarshalIndent(feature, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+var (
+	checkCmd        = app.Command("check", "check various properties of relations")
+	checkO5m        = checkCmd.Arg("o5mPath", "o5m file path").Required().String()
+	checkReferences = checkCmd.Flag("references",
+		"also report ways and relations referencing missing elements").Bool()
+	checkCsv = checkCmd.Flag("csv",
+		"write incomplete relations to this CSV file, implies --references").
+		String()
+)
+
+func checkFn() error {
+	r, err := OpenOSMReader(*checkO5m, NodeKind, WayKind)
+	if err != nil {
+		return err
+	}
+	iso2Codes := map[string]string{}
+	iso3Codes := map[string]string{}
+	for r.Next() {
+		if r.Kind() != RelationKind {
+			continue
+		}
+		rel := r.Relation()
+		if ok, err := ignoreRelation(rel); ok || err != nil {
+			if err != nil {
+				fmt.Printf("error: %s: %s\n", rel.String(), err)
+			}
+			continue
+		}
+		rt, err := NewRelationTags(rel)
+		if err != nil {
+			fmt.Printf("error: %s: invalid tags: %s\n", rel.String(), err)
+			continue
+		}
+		if len(rt.Duplicates) > 0 {
+			fmt.Printf("warning: %s: duplicate tags: %s\n", rel.String(),
+				strings.Join(rt.Duplicates, ", "))
+		}
+		level, levelStr := rt.AdminLevel()
+		if level < 1 {
+			fmt.Printf("error: %s: invalid admin level: %s\n", rel.String(), levelStr)
+			continue
+		}
+		if level != 2 {
+			continue
+		}
+		iso2 := rt.CountryIso2()
+		if iso2 == "" {
+			fmt.Printf("error: %s: missing iso2 code\n", rel.String())
+			continue
+		}
+		if other, ok := iso2Codes[iso2]; ok {
+			fmt.Printf("error: %s: duplicate iso2 code: %s\n", rel.String(), other)
+			continue
+		}
+		iso2Codes[iso2] = rel.String()
+
+		iso3 := rt.CountryIso3()
+		if iso3 == "" {
+			fmt.Printf("error: %s: missing iso3 code\n", rel.String())
+			continue
+		}
+		if other, ok := iso3Codes[iso3]; ok {
+			fmt.Printf("error: %s: duplicate iso3 code: %s\n", rel.String(), other)
+			continue
+		}
+		iso3Codes[iso3] = rel.String()
+		//fmt.Println(rt.Name(), rt.CountryIso2(), rt.CountryIso3())
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+	if !*checkReferences && *checkCsv == "" {
+		return nil
+	}
+	return checkReferencesFn()
+}
+
+func checkReferencesFn() error {
+	report, err := checkCompleteness(*checkO5m)
+	if err != nil {
+		return err
+	}
+	for _, c := range report.Relations {
+		fmt.Printf("error: relation %d[%s][level=%s]: missing nodes %d, "+
+			"way nodes %d in %d ways, ways %d, relations %d\n", c.Id, c.Name,
+			c.Level, c.MissingNodes, c.MissingWayNodes, c.IncompleteWays,
+			c.MissingWays, c.MissingRelations)
+	}
+	fmt.Printf("incomplete ways: %d, incomplete relations: %d\n",
+		report.IncompleteWays, report.IncompleteRelations)
+	fmt.Printf("missing references: nodes %d, ways %d, relations %d\n",
+		report.MissingNodes, report.MissingWays, report.MissingRelations)
+	if *checkCsv == "" {
+		return nil
+	}
+	fp, err := CreateOutputFile(*checkCsv, CompressAuto)
+	if err != nil {
+		return err
+	}
+	defer fp.Abort()
+	err = writeCompletenessCsv(fp, report)
+	if err != nil {
+		return err
+	}
+	return fp.Commit()
+}
+
+var (
+	validateCmd = app.Command("validate",
+		"report relations whose geometry cannot be built, without writing anything")
+	validatePath = validateCmd.Arg("path", "o5m file path").Required().String()
+	validateDb   = validateCmd.Arg("db", "db path").Required().String()
+	validateId   = validateCmd.Flag("id", "relation id").String()
+	validateKeep = validateCmd.Flag("keep",
+		"select relations matching this tag expression instead of administrative boundaries").
+		String()
+	validateProtected = validateCmd.Flag("protected-areas",
+		"select protected areas and national parks instead of administrative boundaries").
+		Bool()
+	validateSnap = validateCmd.Flag("snap-tolerance",
+		"snap unmatched way endpoints closer than this, like indexlocations").
+		String()
+)
+
+func validateFn() error {
+	relId, err := parseRelId(*validateId)
+	if err != nil {
+		return err
+	}
+	err = setKeepFilter(*validateKeep, *validateProtected)
+	if err != nil {
+		return err
+	}
+	ringSnapTolerance, err = parseDistance(*validateSnap)
+	if err != nil {
+		return err
+	}
+	db, err := OpenWaysDb(*validateDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	report, err := validateRelations(*validatePath, db, relId)
+	if err != nil {
+		return err
+	}
+	for _, rv := range report.Relations {
+		for _, p := range rv.Problems {
+			fmt.Printf("error: %s: %s: %s\n", rv.Name, p.Category, p.Message)
+		}
+	}
+	fmt.Printf("checked relations: %d, invalid: %d\n", report.Checked,
+		len(report.Relations))
+	for _, category := range ProblemCategories {
+		if n := report.Counts[category]; n > 0 {
+			fmt.Printf("%s: %d\n", category, n)
+		}
+	}
+	return nil
+}
+
+var (
+	resetDbCmd    = app.Command("resetdb", "delete a bucket from feature db")
+	resetDbPath   = resetDbCmd.Arg("dbPath", "db path").Required().String()
+	resetDbBucket = resetDbCmd.Arg("bucket", "name of bucket to delete").Required().String()
+)
+
+func resetDbFn() error {
+	db, err := OpenWaysDb(*resetDbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteBucket(*resetDbBucket)
+}
+
+var (
+	dedupCountriesCmd = app.Command("dedupcountries",
+		"pick one relation among those representing the same country")
+	dedupCountriesO5m = dedupCountriesCmd.Arg("o5mPath", "o5m file path").
+				Required().String()
+	dedupCountriesDb = dedupCountriesCmd.Arg("dbPath", "db path").
+				Required().String()
+	dedupCountriesVariant = dedupCountriesCmd.Flag("boundary-variant",
+		"preferred representation when a country has land and maritime boundaries").
+		Default(VariantAuto).Enum(VariantAuto, VariantLand, VariantMaritime)
+	dedupCountriesReport = dedupCountriesCmd.Flag("report",
+		"write the decisions to this JSON file").String()
+)
+
+func dedupCountriesFn() error {
+	r, err := OpenOSMReader(*dedupCountriesO5m, NodeKind, WayKind)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	db, err := OpenWaysDb(*dedupCountriesDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	decisions, err := runCountryDedup(r, db, *dedupCountriesVariant, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if *dedupCountriesReport != "" {
+		return writeDedupReport(*dedupCountriesReport, decisions)
+	}
+	return nil
+}
+
+var (
+	migrateDbCmd = app.Command("migratedb",
+		"rewrite db values stored as JSON in binary format")
+	migrateDbPath = migrateDbCmd.Arg("dbPath", "db path").Required().String()
+)
+
+func migrateDbFn() error {
+	db, err := OpenWaysDb(*migrateDbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	buckets := [][]byte{waysBucket, relationsBucket, locationsBucket,
+		centroidsBucket}
+	for _, bucket := range buckets {
+		n, err := db.MigrateBucket(bucket, 10000)
+		if err != nil {
+			return err
+		}
+		slog.Info("migrated", "bucket", string(bucket), "count", n)
+	}
+	return nil
+}
+
+var (
+	dbStatsCmd  = app.Command("dbstats", "report db buckets content and size")
+	dbStatsPath = dbStatsCmd.Arg("dbPath", "db path").Required().String()
+	dbStatsTop  = dbStatsCmd.Flag("top",
+		"number of largest entries reported per bucket").Default("5").Int()
+)
+
+func dbStatsFn() error {
+	db, err := OpenWaysDb(*dbStatsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	stats, err := db.Stats(*dbStatsTop)
+	if err != nil {
+		return err
+	}
+	printBucketStats(os.Stdout, stats)
+	return nil
+}
+
+var (
+	mergeDbCmd = app.Command("mergedb",
+		"merge locations and centroids computed by sharded runs")
+	mergeDbPath    = mergeDbCmd.Arg("dbPath", "target db path").Required().String()
+	mergeDbSources = mergeDbCmd.Arg("sources", "source db paths").
+			Required().Strings()
+)
+
+func mergeDbFn() error {
+	db, err := OpenWaysDb(*mergeDbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, path := range *mergeDbSources {
+		src, err := OpenWaysDb(path)
+		if err != nil {
+			return err
+		}
+		locations, err := db.CopyBucket(src, locationsBucket)
+		if err != nil {
+			src.Close()
+			return err
+		}
+		centroids, err := db.CopyBucket(src, centroidsBucket)
+		if err != nil {
+			src.Close()
+			return err
+		}
+		_, err = db.CopyBucket(src, centresBucket)
+		if err == nil {
+			_, err = db.CopyBucket(src, locationBuildsBucket)
+		}
+		if err == nil {
+			_, err = db.CopyBucket(src, relationsBucket)
+		}
+		src.Close()
+		if err != nil {
+			return err
+		}
+		slog.Info("merged", "path", path, "locations", locations,
+			"centroids", centroids)
+	}
+	indexed, err := db.BuildSpatialIndex()
+	if err != nil {
+		return err
+	}
+	slog.Info("spatial index", "locations", indexed)
+	return nil
+}
+
+var (
+	diffDbCmd = app.Command("diffdb",
+		"report relations added, removed or changed between two dbs as JSON")
+	diffDbPrevious = diffDbCmd.Arg("previous", "previous db path").
+			Required().String()
+	diffDbCurrent = diffDbCmd.Arg("current", "current db path").
+			Required().String()
+	diffDbThreshold = diffDbCmd.Flag("threshold",
+		"minimum Hausdorff distance of changed geometries, in degrees or in "+
+			"meters with a m suffix").Default("10m").String()
+)
+
+func diffDbFn() error {
+	threshold, err := parseDistance(*diffDbThreshold)
+	if err != nil {
+		return err
+	}
+	previous, err := OpenWaysDb(*diffDbPrevious)
+	if err != nil {
+		return err
+	}
+	defer previous.Close()
+	current, err := OpenWaysDb(*diffDbCurrent)
+	if err != nil {
+		return err
+	}
+	defer current.Close()
+	diff, err := diffDbs(previous, current, threshold)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	slog.Info("compared", "added", len(diff.Added), "removed",
+		len(diff.Removed), "changed", len(diff.Changed),
+		"unchanged", diff.Unchanged)
+	return nil
+}
+
+var (
+	poisCmd = app.Command("pois",
+		"extract nodes and polygon centers matching a tag expression")
+	poisO5m     = poisCmd.Arg("o5mPath", "o5m file path").Required().String()
+	poisDb      = poisCmd.Arg("db", "locations db path").Required().String()
+	poisOutpath = poisCmd.Arg("outpath", "jsonl output path").Required().String()
+	poisFilter  = poisCmd.Flag("filter", "tag expression selecting elements, "+
+		"like amenity=hospital").Required().String()
+	poisWorkers = poisCmd.Flag("workers", "JSON encoding workers count").
+			Default("1").Int()
+	poisCompress = poisCmd.Flag("compress",
+		"output compression, auto uses the output file extension").
+		Default(CompressAuto).Enum(CompressionValues...)
+)
+
+// Writes elements matching a tag expression as GeoJSON points, annotated with
+// the administrative boundaries of db containing them. Closed ways are
+// represented by a point inside them, relations by their indexed centroid.
+func poisFn() error {
+	filter, err := ParseTagExpr(*poisFilter)
+	if err != nil {
+		return err
+	}
+	db, err := OpenWaysDb(*poisDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	slog.Info("loading administrative boundaries")
+	idx, err := loadAdminIndex(db)
+	if err != nil {
+		return err
+	}
+	slog.Info("loaded administrative boundaries", "count", len(idx.Areas))
+
+	outFp, err := CreateOutputFile(*poisOutpath, *poisCompress)
+	if err != nil {
+		return err
+	}
+	defer outFp.Abort()
+	out := NewParallelMarshaler(outFp, *poisWorkers)
+	defer out.Close()
+
+	// Collect matching ways and write matching relations
+	ways := []*Way{}
+	points := map[int64][]float64{}
+	r, err := OpenOSMReader(*poisO5m, NodeKind)
+	if err != nil {
+		return err
+	}
+	for r.Next() {
+		switch r.Kind() {
+		case WayKind:
+			w := r.Way()
+			if !filter.Match(w.Tags) || len(w.Nodes) < 4 ||
+				w.Nodes[0] != w.Nodes[len(w.Nodes)-1] {
+				continue
+			}
+			ways = append(ways, &Way{
+				Id:    w.Id,
+				Meta:  w.Meta,
+				Nodes: append([]int64{}, w.Nodes...),
+				Tags:  copyTags(w.Tags),
+			})
+			for _, id := range w.Nodes {
+				points[id] = nil
+			}
+		case RelationKind:
+			rel := r.Relation()
+			if !filter.Match(rel.Tags) {
+				continue
+			}
+			c, err := db.GetCentroid(rel.Id)
+			if err != nil {
+				return err
+			}
+			if c == nil {
+				slog.Warn("no centroid", relationAttr(rel))
+				continue
+			}
+			err = out.Write(makePointFeature("relation", rel.Id, c.Lon, c.Lat,
+				rel.Tags, &rel.Meta, idx))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+
+	// Write matching nodes and resolve ways nodes
+	r, err = OpenOSMReader(*poisO5m, WayKind, RelationKind)
+	if err != nil {
+		return err
+	}
+	seenNode := false
+	for r.Next() {
+		if r.Kind() != NodeKind {
+			if seenNode && r.Kind() == ResetKind {
+				break
+			}
+			continue
+		}
+		seenNode = true
+		n := r.Node()
+		lon := float64(n.Lon) / 1e7
+		lat := float64(n.Lat) / 1e7
+		if _, ok := points[n.Id]; ok {
+			points[n.Id] = []float64{lon, lat}
+		}
+		if len(n.Tags) == 0 || !filter.Match(n.Tags) {
+			continue
+		}
+		err = out.Write(makePointFeature("node", n.Id, lon, lat, n.Tags, &n.Meta,
+			idx))
+		if err != nil {
+			return err
+		}
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+
+	for _, w := range ways {
+		ring := make([][]float64, 0, len(w.Nodes))
+		for _, id := range w.Nodes {
+			if p := points[id]; p != nil {
+				ring = append(ring, p)
+			}
+		}
+		if len(ring) != len(w.Nodes) {
+			slog.Warn("missing nodes", "way", w.Id)
+			continue
+		}
+		c, ok := computeRingCenter(ring)
+		if !ok {
+			slog.Warn("cannot compute center", "way", w.Id)
+			continue
+		}
+		err = out.Write(makePointFeature("way", w.Id, c[0], c[1], w.Tags, &w.Meta,
+			idx))
+		if err != nil {
+			return err
+		}
+	}
+	written, err := out.Close()
+	if err != nil {
+		return err
+	}
+	err = outFp.Commit()
+	if err != nil {
+		return err
+	}
+	slog.Info("written", "count", written)
+	runSummary.Add("written", written)
+	return nil
+}
+
+var (
+	indexPlacesCmd = app.Command("indexplaces",
+		"extract named place nodes with their enclosing boundaries")
+	indexPlacesO5m     = indexPlacesCmd.Arg("o5mPath", "o5m file path").Required().String()
+	indexPlacesDb      = indexPlacesCmd.Arg("db", "locations db path").Required().String()
+	indexPlacesOutpath = indexPlacesCmd.Arg("outpath", "jsonl output path").
+				Required().String()
+	indexPlacesTypes = indexPlacesCmd.Flag("types",
+		"comma separated place tag values to extract").
+		Default("city,town,village").String()
+	indexPlacesWorkers = indexPlacesCmd.Flag("workers", "JSON encoding workers count").
+				Default("1").Int()
+	indexPlacesCompress = indexPlacesCmd.Flag("compress",
+		"output compression, auto uses the output file extension").
+		Default(CompressAuto).Enum(CompressionValues...)
+)
+
+// Writes place nodes, many populated places having no boundary relation, as
+// JSON lines like the geojson command relations, with the boundaries of db
+// containing them as parents. indexlocations must have been run first to
+// build the spatial index.
+func indexPlacesFn() error {
+	types := map[string]bool{}
+	for _, t := range strings.Split(*indexPlacesTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[t] = true
+		}
+	}
+	if len(types) == 0 {
+		return fmt.Errorf("no place type to extract")
+	}
+	db, err := OpenWaysDb(*indexPlacesDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	lookup := func(lon, lat float64) ([]AdminAreaJson, error) {
+		return lookupDbAreas(db, lon, lat)
+	}
+
+	outFp, err := CreateOutputFile(*indexPlacesOutpath, *indexPlacesCompress)
+	if err != nil {
+		return err
+	}
+	defer outFp.Abort()
+	out := NewParallelMarshaler(outFp, *indexPlacesWorkers)
+	defer out.Close()
+
+	r, err := OpenOSMReader(*indexPlacesO5m, WayKind, RelationKind)
+	if err != nil {
+		return err
+	}
+	seenNode := false
+	orphans := 0
+	for r.Next() {
+		if r.Kind() != NodeKind {
+			if seenNode && r.Kind() == ResetKind {
+				break
+			}
+			continue
+		}
+		seenNode = true
+		n := r.Node()
+		if len(n.Tags) == 0 {
+			continue
+		}
+		p, err := makePlaceJson(n, types, lookup)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			continue
+		}
+		if len(p.Parents) == 0 {
+			orphans++
+		}
+		err = out.Write(p)
+		if err != nil {
+			return err
+		}
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+	written, err := out.Close()
+	if err != nil {
+		return err
+	}
+	err = outFp.Commit()
+	if err != nil {
+		return err
+	}
+	slog.Info("written", "count", written, "without_boundary", orphans)
+	runSummary.Add("written", written)
+	runSummary.Add("without_boundary", orphans)
+	return nil
+}
+
+var (
+	indexParentsCmd = app.Command("indexparents",
+		"index the ancestors of administrative boundaries")
+	indexParentsDb = indexParentsCmd.Arg("db", "locations db path").
+			Required().String()
+)
+
+// Locates boundaries centroids in other boundaries to find their ancestors.
+// indexlocations and indexcenters must have been run first.
+func indexParentsFn() error {
+	db, err := OpenWaysDb(*indexParentsDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	slog.Info("loading administrative boundaries")
+	idx, err := loadAdminIndex(db)
+	if err != nil {
+		return err
+	}
+	indexed := 0
+	for i, area := range idx.Areas {
+		c, err := db.GetCentroid(area.Id)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			slog.Warn("no centroid", slog.Group("relation", "id", area.Id,
+				"name", area.Name, "level", area.Level))
+			continue
+		}
+		parents := []AdminAreaJson{}
+		for _, p := range findParents(idx, area, c.Lon, c.Lat) {
+			parents = append(parents, AdminAreaJson{
+				Id:         strconv.FormatInt(p.Id, 10),
+				Name:       p.Name,
+				AdminLevel: p.Level,
+			})
+		}
+		err = db.PutParents(area.Id, parents)
+		if err != nil {
+			return err
+		}
+		indexed++
+		if (i+1)%1000 == 0 {
+			slog.Info("indexed", "count", i+1)
+		}
+	}
+	slog.Info("indexed", "count", indexed, "areas", len(idx.Areas))
+	runSummary.Add("indexed", indexed)
+	return nil
+}
+
+var (
+	duplicateBoundariesCmd = app.Command("duplicateboundaries",
+		"report distinct boundaries with the same name and level overlapping "+
+			"each other")
+	duplicateBoundariesDb = duplicateBoundariesCmd.Arg("db", "locations db path").
+				Required().String()
+	duplicateBoundariesOverlap = duplicateBoundariesCmd.Flag("min-overlap",
+		"minimum intersection area relative to the smallest boundary").
+		Default("0.5").Float64()
+)
+
+func duplicateBoundariesFn() error {
+	db, err := OpenWaysDb(*duplicateBoundariesDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	slog.Info("loading administrative boundaries")
+	idx, err := loadAdminIndex(db)
+	if err != nil {
+		return err
+	}
+	// Assign areas to the country containing their centroid
+	countries := map[int64]string{}
+	for _, area := range idx.Areas {
+		if area.Level == 2 && area.Country != "" {
+			countries[area.Id] = area.Country
+			continue
+		}
+		c, err := db.GetCentroid(area.Id)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			continue
+		}
+		for _, other := range idx.Lookup(c.Lon, c.Lat) {
+			if other.Level == 2 && other.Country != "" {
+				countries[area.Id] = other.Country
+				break
+			}
+		}
+	}
+	country := ""
+	reported := 0
+	for _, g := range groupSameNameAreas(idx.Areas, countries) {
+		lines := []string{}
+		for i, a := range g.Areas {
+			for _, b := range g.Areas[i+1:] {
+				if !a.bboxIntersects(b) {
+					continue
+				}
+				ratio, err := computeOverlapRatio(a, b)
+				if err != nil {
+					slog.Error("cannot compute overlap", "first", a.Id,
+						"second", b.Id, "error", err)
+					continue
+				}
+				if ratio < *duplicateBoundariesOverlap {
+					continue
+				}
+				lines = append(lines, fmt.Sprintf("  %s(%d) / %s(%d): overlap=%.2f",
+					a.Name, a.Id, b.Name, b.Id, ratio))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if g.Country != country || reported == 0 {
+			country = g.Country
+			name := country
+			if name == "" {
+				name = "unknown country"
+			}
+			fmt.Printf("%s:\n", name)
+		}
+		fmt.Printf(" %q[level=%d]\n", g.Name, g.Level)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		reported++
+	}
+	fmt.Printf("duplicate groups: %d\n", reported)
+	return nil
+}
+
+var (
+	unresolvedCmd = app.Command("unresolved",
+		"list way and relation references missing from a file")
+	unresolvedPath = unresolvedCmd.Arg("path", "o5m file path").
+			Required().String()
+	unresolvedMax = unresolvedCmd.Flag("max",
+		"maximum number of ids listed per element and kind").
+		Default("20").Int()
+)
+
+func formatMissingIds(kind string, ids []int64, max int) string {
+	s := fmt.Sprintf("%d %s", len(ids), kind)
+	for i, id := range ids {
+		if i >= max {
+			s += " ..."
+			break
+		}
+		if i == 0 {
+			s += ":"
+		}
+		s += fmt.Sprintf(" %d", id)
+	}
+	return s
+}
+
+func unresolvedFn() error {
+	nodes := &IdSet{}
+	ways := &IdSet{}
+	relations := &IdSet{}
+	plan := &ScanPlan{}
+	plan.Add(&ScanStage{
+		Kinds: []int{NodeKind},
+		Handler: o5m.HandlerFuncs{
+			Node: func(n *Node) error {
+				nodes.Add(n.Id)
+				return nil
+			},
+		},
+	})
+	plan.Add(&ScanStage{
+		Kinds: []int{WayKind, RelationKind},
+		Handler: o5m.HandlerFuncs{
+			Way: func(w *Way) error {
+				ways.Add(w.Id)
+				return nil
+			},
+			Relation: func(rel *Relation) error {
+				relations.Add(rel.Id)
+				return nil
+			},
+		},
+		Done: func() error {
+			fmt.Printf("nodes %d, ways %d, relations %d\n", nodes.Len(),
+				ways.Len(), relations.Len())
+			return nil
+		},
+	})
+
+	missingNodes, missingWays, missingRelations := 0, 0, 0
+	incompleteWays := 0
+	incompleteRelations := 0
+	report := func(name string, missing *MissingRefs) {
+		parts := []string{}
+		if len(missing.Nodes) > 0 {
+			parts = append(parts, formatMissingIds("nodes", missing.Nodes,
+				*unresolvedMax))
+		}
+		if len(missing.Ways) > 0 {
+			parts = append(parts, formatMissingIds("ways", missing.Ways,
+				*unresolvedMax))
+		}
+		if len(missing.Relations) > 0 {
+			parts = append(parts, formatMissingIds("relations",
+				missing.Relations, *unresolvedMax))
+		}
+		fmt.Printf("%s: missing %s\n", name, strings.Join(parts, ", "))
+		missingNodes += len(missing.Nodes)
+		missingWays += len(missing.Ways)
+		missingRelations += len(missing.Relations)
+	}
+	plan.Add(&ScanStage{
+		Kinds: []int{WayKind, RelationKind},
+		Handler: o5m.HandlerFuncs{
+			Way: func(w *Way) error {
+				missing := findMissingWayRefs(w, nodes)
+				if missing.Len() > 0 {
+					incompleteWays++
+					report(fmt.Sprintf("way %d", w.Id), missing)
+				}
+				return nil
+			},
+			Relation: func(rel *Relation) error {
+				missing := findMissingRelationRefs(rel, nodes, ways, relations)
+				if missing.Len() > 0 {
+					incompleteRelations++
+					report("relation "+rel.String(), missing)
+				}
+				return nil
+			},
+		},
+	})
+	err := plan.Run(context.Background(), *unresolvedPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("incomplete ways: %d, incomplete relations: %d\n",
+		incompleteWays, incompleteRelations)
+	fmt.Printf("missing references: nodes %d, ways %d, relations %d\n",
+		missingNodes, missingWays, missingRelations)
+	return nil
+}
+
+var (
+	boundsCmd = app.Command("bounds",
+		"compare nodes extent with the header bounding box")
+	boundsPath = boundsCmd.Arg("path", "o5m file path").Required().String()
+)
+
+func boundsFn() error {
+	r, err := OpenOSMReader(*boundsPath, WayKind, RelationKind)
+	if err != nil {
+		return err
+	}
+	var declared *BoundingBox
+	actual := NewBBox()
+	seenNode := false
+	for r.Next() {
+		if r.Kind() == BBoxKind {
+			bb := r.BoundingBox()
+			declared = &bb
+		} else if r.Kind() == NodeKind {
+			seenNode = true
+			n := r.Node()
+			actual.Add(float64(n.Lon)/1e7, float64(n.Lat)/1e7)
+		} else if r.Kind() == ResetKind && seenNode {
+			break
+		}
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+	if actual.empty {
+		return fmt.Errorf("no node found")
+	}
+	bounds := actual.BoundingBox()
+	fmt.Println("nodes extent", formatBoundingBox(bounds))
+	if declared != nil {
+		fmt.Println("header bounding box", formatBoundingBox(*declared))
+	}
+	errors, warnings := checkBoundingBox(declared, bounds, 0.1)
+	for _, w := range warnings {
+		fmt.Println("WARNING", w)
+	}
+	for _, e := range errors {
+		fmt.Println("ERROR", e)
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("invalid header bounding box")
+	}
+	return nil
+}
+
+var (
+	filterCmd = app.Command("filter",
+		"write matching relations with their ways and nodes to a new o5m file")
+	filterPath   = filterCmd.Arg("path", "input file path").Required().String()
+	filterOutput = filterCmd.Arg("output", "output o5m file path").Required().
+			String()
+	filterKeep = filterCmd.Flag("keep",
+		"osmfilter expression selecting relations, like admin_level<=8").
+		String()
+	filterDrop = filterCmd.Flag("drop",
+		"osmfilter expression excluding relations").String()
+)
+
+func filterFn() error {
+	expr, err := ParseOsmFilter(*filterKeep, *filterDrop)
+	if err != nil {
+		return err
+	}
+	if expr == nil {
+		return fmt.Errorf("--keep or --drop is required")
+	}
+	out, err := CreateOutputFile(*filterOutput, CompressNone)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	w, err := NewO5MWriter(out)
+	if err != nil {
+		return err
+	}
+	stats, err := extractRelations(*filterPath, expr, w)
+	if err != nil {
+		return err
+	}
+	slog.Info("written", "stats", stats.String())
+	return out.Commit()
+}
+
+var (
+	extractCmd = app.Command("extract",
+		"write the elements inside a bounding box or polygon to a new o5m, "+
+			"PBF, XML or GeoJSON file")
+	extractPath   = extractCmd.Arg("path", "input file path").Required().String()
+	extractOutput = extractCmd.Arg("output", "output file path").
+			Required().String()
+	extractFormat = extractCmd.Flag("format",
+		"output format, auto uses the output file extension").
+		Default(ConvertAuto).Enum(ConvertFormats...)
+	extractBBox = extractCmd.Flag("bbox",
+		"area to extract, as minlon,minlat,maxlon,maxlat").String()
+	extractPoly = extractCmd.Flag("poly",
+		"area to extract, as an Osmosis .poly file").String()
+)
+
+func extractFn() error {
+	if (*extractBBox == "") == (*extractPoly == "") {
+		return fmt.Errorf("one of --bbox or --poly is required")
+	}
+	format, err := resolveConvertFormat(*extractOutput, *extractFormat)
+	if err != nil {
+		return err
+	}
+	var contains func(lon, lat int64) bool
+	var bbox BoundingBox
+	if *extractPoly != "" {
+		poly, err := readPolyFile(*extractPoly)
+		if err != nil {
+			return err
+		}
+		slog.Info("polygon", "name", poly.Name, "outers", len(poly.Outers),
+			"holes", len(poly.Holes))
+		contains = containsInPoly(poly)
+		bbox = poly.BoundingBox()
+	} else {
+		bb, err := parseBBoxArg(*extractBBox)
+		if err != nil {
+			return err
+		}
+		contains = containsInBBox(bb)
+		bbox = bb
+	}
+	out, err := CreateOutputFile(*extractOutput, CompressNone)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	w, err := newElementWriter(out, format)
+	if err != nil {
+		return err
+	}
+	stats, err := extractArea(*extractPath, contains, &bbox, w)
+	if err != nil {
+		return err
+	}
+	slog.Info("written", "format", format, "stats", stats.String())
+	return out.Commit()
+}
+
+var (
+	convertCmd = app.Command("convert",
+		"convert an o5m, PBF or XML file to o5m, PBF, XML or GeoJSON")
+	convertPath   = convertCmd.Arg("path", "input file path").Required().String()
+	convertOutput = convertCmd.Arg("output", "output file path").
+			Required().String()
+	convertFormat = convertCmd.Flag("format",
+		"output format, auto uses the output file extension").
+		Default(ConvertAuto).Enum(ConvertFormats...)
+	convertDropNodes     = convertCmd.Flag("drop-nodes", "do not write nodes").Bool()
+	convertDropWays      = convertCmd.Flag("drop-ways", "do not write ways").Bool()
+	convertDropRelations = convertCmd.Flag("drop-relations",
+		"do not write relations").Bool()
+	convertCompress = convertCmd.Flag("compress",
+		"output compression, auto uses the output file extension").
+		Default(CompressAuto).Enum(CompressionValues...)
+)
+
+func convertFn() error {
+	format, err := resolveConvertFormat(*convertOutput, *convertFormat)
+	if err != nil {
+		return err
+	}
+	dropped := make([]bool, RelationKind+1)
+	dropped[NodeKind] = *convertDropNodes
+	dropped[WayKind] = *convertDropWays
+	dropped[RelationKind] = *convertDropRelations
+	stats, err := convertFile(*convertPath, *convertOutput, format,
+		*convertCompress, dropped)
+	if err != nil {
+		return err
+	}
+	slog.Info("written", "format", format, "stats", stats.String())
+	runSummary.Add("nodes", stats.Nodes)
+	runSummary.Add("ways", stats.Ways)
+	runSummary.Add("relations", stats.Relations)
+	return nil
+}
+
+var (
+	fetchCmd    = app.Command("fetch", "download a Geofabrik region extract")
+	fetchRegion = fetchCmd.Arg("region", "Geofabrik region, like europe/france").
+			Required().String()
+	fetchOutDir = fetchCmd.Flag("outdir", "output directory").Default(".").
+			String()
+	fetchBaseURL = fetchCmd.Flag("base-url", "download server URL").
+			Default(GeofabrikURL).String()
+	fetchO5m        = fetchCmd.Flag("o5m", "convert the extract to o5m").Bool()
+	fetchOsmconvert = fetchCmd.Flag("osmconvert",
+		"convert with this osmconvert executable instead of the convert command").
+		String()
+)
+
+func fetchFn() error {
+	url, err := geofabrikRegionURL(*fetchBaseURL, *fetchRegion)
+	if err != nil {
+		return err
+	}
+	pbfPath := filepath.Join(*fetchOutDir, regionFileName(url))
+	slog.Info("downloading", "url", url)
+	start := time.Now()
+	n, err := downloadVerified(url, pbfPath)
+	if err != nil {
+		return err
+	}
+	slog.Info("written", "path", pbfPath, "bytes", n,
+		"duration_s", int64(time.Now().Sub(start)/time.Second))
+	if !*fetchO5m {
+		return nil
+	}
+	o5mPath := strings.TrimSuffix(pbfPath, ".osm.pbf") + ".o5m"
+	slog.Info("converting", "path", o5mPath)
+	return convertToO5m(*fetchOsmconvert, pbfPath, o5mPath)
+}
+
+var (
+	batchCmd = app.Command("batch",
+		"run the whole pipeline for all regions of a manifest")
+	batchManifest = batchCmd.Arg("manifest", "JSON manifest path").
+			Required().String()
+	batchOnly = batchCmd.Flag("only", "only process these regions").Strings()
+)
+
+func batchFn() error {
+	m, err := readManifest(*batchManifest)
+	if err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	only := map[string]bool{}
+	for _, name := range *batchOnly {
+		only[name] = true
+	}
+	reports := []*RegionReport{}
+	failed := 0
+	for i := range m.Regions {
+		r := &m.Regions[i]
+		if len(only) > 0 && !only[r.Name] {
+			continue
+		}
+		report := runPipeline(self, r, m.CacheDir,
+			filepath.Join(m.WorkDir, r.Name))
+		if report.Status != "ok" {
+			failed++
+			slog.Error("region failed", "region", r.Name, "step", report.Step,
+				"error", report.Error)
+		}
+		reports = append(reports, report)
+	}
+	for _, report := range reports {
+		fmt.Printf("%-20s %-6s %6.0fs %s%s\n", report.Name, report.Status,
+			report.Duration, report.Step, report.Output)
+	}
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	reportPath := filepath.Join(m.WorkDir, "report.json")
+	err = ioutil.WriteFile(reportPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	slog.Info("report written", "path", reportPath)
+	if failed > 0 {
+		return fmt.Errorf("%d/%d regions failed", failed, len(reports))
+	}
+	return nil
+}
+
+var (
+	completionCmd = app.Command("completion",
+		"print a shell completion script")
+	completionShell = completionCmd.Arg("shell", "bash, zsh or fish").
+			Required().Enum("bash", "zsh", "fish")
+)
+
+func getFlagsInfo(cmd string, flags []*kingpin.FlagModel) []FlagInfo {
+	infos := []FlagInfo{}
+	for _, f := range flags {
+		if f.Hidden {
+			continue
+		}
+		info := FlagInfo{
+			Name:     f.Name,
+			Help:     f.Help,
+			Default:  f.Default,
+			Bool:     f.IsBoolFlag(),
+			Required: f.Required,
+		}
+		if f.Name != "help" {
+			info.Envars = getFlagEnvars(cmd, f.Name)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func getCLIInfo() *CLIInfo {
+	model := app.Model()
+	info := &CLIInfo{
+		Name:  "osm",
+		Flags: getFlagsInfo("", model.Flags),
+	}
+	for _, c := range model.Commands {
+		if c.Hidden {
+			continue
+		}
+		cmd := CommandInfo{
+			Name:  c.Name,
+			Help:  c.Help,
+			Flags: getFlagsInfo(c.Name, c.Flags),
+			Args:  []ArgInfo{},
+		}
+		for _, a := range c.Args {
+			cmd.Args = append(cmd.Args, ArgInfo{
+				Name:     a.Name,
+				Help:     a.Help,
+				Required: a.Required,
+			})
+		}
+		info.Commands = append(info.Commands, cmd)
+	}
+	return info
+}
+
+func completionFn() error {
+	script, err := generateCompletion(*completionShell, getCLIInfo())
+	if err != nil {
+		return err
+	}
+	fmt.Print(script)
+	return nil
+}
+
+var (
+	lookupCmd = app.Command("lookup", "print the administrative areas "+
+		"containing a point, or points read from stdin as lon/lat lines")
+	lookupDb  = lookupCmd.Arg("db", "locations db path").Required().String()
+	lookupLon = lookupCmd.Arg("lon", "longitude").String()
+	lookupLat = lookupCmd.Arg("lat", "latitude").String()
+)
+
+func lookupFn() error {
+	if (*lookupLon == "") != (*lookupLat == "") {
+		return fmt.Errorf("lon and lat must be passed together")
+	}
+	db, err := OpenWaysDb(*lookupDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	lookup := func(lon, lat float64) ([]AdminAreaJson, error) {
+		return lookupDbAreas(db, lon, lat)
+	}
+	if *lookupLon == "" {
+		_, err = runLookups(lookup, os.Stdin, os.Stdout)
+		return err
+	}
+	lon, lat, err := parseLonLat(*lookupLon, *lookupLat)
+	if err != nil {
+		return err
+	}
+	admins, err := lookup(lon, lat)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&LookupJson{
+		Lon:   lon,
+		Lat:   lat,
+		Admin: admins,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+var (
+	applyChangesCmd = app.Command("applychanges",
+		"update ways, relations and locations of a db from an OsmChange file")
+	applyChangesPath = applyChangesCmd.Arg("path",
+		"input file with the changes applied").Required().String()
+	applyChangesDb  = applyChangesCmd.Arg("db", "db path").Required().String()
+	applyChangesOsc = applyChangesCmd.Arg("changes", "OsmChange or o5c file path").
+			Required().String()
+	applyChangesKeep = applyChangesCmd.Flag("keep",
+		"select relations matching this tag expression instead of administrative boundaries").
+		String()
+	applyChangesProtected = applyChangesCmd.Flag("protected-areas",
+		"select protected areas and national parks instead of administrative boundaries").
+		Bool()
+)
+
+func applyChangesFn() error {
+	err := setKeepFilter(*applyChangesKeep, *applyChangesProtected)
+	if err != nil {
+		return err
+	}
+	cr, err := OpenChangeReader(*applyChangesOsc)
+	if err != nil {
+		return err
+	}
+	cs, err := readChangeSet(cr)
+	cr.Close()
+	if err != nil {
+		return err
+	}
+	slog.Info("changes", "nodes", len(cs.Nodes), "ways", len(cs.Ways),
+		"relations", len(cs.Relations))
+	r, err := OpenOSMReader(*applyChangesPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	db, err := OpenWaysDb(*applyChangesDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	stats, err := applyChanges(cs, r, db)
+	if err != nil {
+		return err
+	}
+	slog.Info("ways", "updated", stats.Ways, "deleted", stats.DeletedWays)
+	slog.Info("relations", "updated", stats.Relations,
+		"deleted", stats.DeletedRelations)
+	slog.Info("locations", "rebuilt", stats.Locations,
+		"deleted", stats.DeletedLocations)
+	return nil
+}
+
+var (
+	serveCmd = app.Command("serve",
+		"serve boundaries, reverse geocoding and name search over HTTP")
+	serveDb     = serveCmd.Arg("db", "locations db path").Required().String()
+	serveListen = serveCmd.Flag("listen", "HTTP listening address").
+			Default("localhost:8080").String()
+)
+
+func serveFn() error {
+	db, err := OpenWaysDb(*serveDb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = loadDuplicateRelations(db)
+	if err != nil {
+		return err
+	}
+	slog.Info("loading boundary names")
+	srv, err := NewBoundaryServer(db)
+	if err != nil {
+		return err
+	}
+	slog.Info("listening", "address", *serveListen)
+	return http.ListenAndServe(*serveListen, srv.Handler())
+}
+
+var (
+	rulesCmd = app.Command("rules",
+		"print relation patch rules as JSON, to be edited and passed to --rules")
+)
+
+func rulesFn() error {
+	data, err := json.MarshalIndent(patchRules, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+var (
+	boundariesCmd = app.Command("boundaries",
+		"print accepted and rejected boundary values as JSON, to be edited "+
+			"and passed to --boundaries")
+)
+
+func boundariesFn() error {
+	data, err := json.MarshalIndent(boundaryLists, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+var (
+	versionCmd  = app.Command("version", "print version and build information")
+	versionJson = versionCmd.Flag("json", "print information as JSON").Bool()
+)
+
+func versionFn() error {
+	info := getBuildInfo()
+	if !*versionJson {
+		fmt.Println(info)
+		return nil
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func getFlagSpecs(flags []*kingpin.FlagModel) []flagSpec {
+	specs := []flagSpec{}
+	for _, f := range flags {
+		if f.Name == "help" || f.Name == "config" {
+			continue
+		}
+		specs = append(specs, flagSpec{
+			Name: f.Name,
+			Bool: f.IsBoolFlag(),
+		})
+	}
+	return specs
+}
+
+// Completes command line arguments with flag defaults from the environment
+// and the configuration file, and rewrites lone "-" arguments for kingpin.
+func expandFlagDefaults(args []string) ([]string, error) {
+	path := os.Getenv("OSM_CONFIG")
+	for i, arg := range args {
+		if arg == "--config" && i+1 < len(args) {
+			path = args[i+1]
+		} else if strings.HasPrefix(arg, "--config=") {
+			path = strings.TrimPrefix(arg, "--config=")
+		}
+	}
+	cfg := Config{}
+	if path != "" {
+		c, err := readConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg = c
+	}
+	model := app.Model()
+	globals := getFlagSpecs(model.Flags)
+	name := findCommand(args, globals)
+	flags := []flagSpec{}
+	for _, c := range model.Commands {
+		if c.Name == name {
+			flags = getFlagSpecs(c.Flags)
+		}
+	}
+	args, err := applyFlagDefaults(args, name, globals, flags,
+		makeDefaultsLookup(cfg, os.Getenv))
+	if err != nil {
+		return nil, err
+	}
+	return rewriteDashArgs(args, globals, flags), nil
+}
+
+func dispatch() error {
+	for _, arg := range os.Args[1:] {
+		if arg == "--commands-json" {
+			// Commands are not required to list them
+			data, err := json.MarshalIndent(getCLIInfo(), "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+	}
+	args, err := expandFlagDefaults(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	cmd := kingpin.MustParse(app.Parse(args))
+	err = setupLogging(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		return err
+	}
+	startMemoryMonitor(*memReport, *memAbortOver)
+	duplicateTagsPolicy = *duplicateTags
+	waysDbBackend = *dbBackend
+	o5mDecodeWorkers = *decodeWorkers
+	exportMetadata = *withMetadata
+	nameLanguages = parseNameLanguages(*nameLanguagesFlag)
+	validateWikidata = *validateWikidataFlag
+	windingOrder = *winding
+	unknownBoundaryPolicy = *unknownBoundary
+	errorPolicy = *onError
+	err = checkAdminLevelRange(*minAdminLevelFlag, *maxAdminLevelFlag)
+	if err != nil {
+		return err
+	}
+	minAdminLevel = *minAdminLevelFlag
+	maxAdminLevel = *maxAdminLevelFlag
+	if *boundariesPath != "" {
+		lists, err := readBoundaryLists(*boundariesPath)
+		if err != nil {
+			return err
+		}
+		boundaryLists = lists
+	}
+	if *rulesPath != "" {
+		rules, err := readPatchRules(*rulesPath)
+		if err != nil {
+			return err
+		}
+		patchRules = rules
+	}
+	err = runCommand(cmd)
+	if err == nil {
+		err = reportFailures(os.Stderr, runSummary.GetFailures())
+	}
+	if *summaryJson != "" {
+		serr := runSummary.Finish(cmd, err, *summaryJson)
+		if serr != nil {
+			slog.Error("cannot write summary", "error", serr)
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+	return err
+}
+
+func runCommand(cmd string) error {
 	switch cmd {
 	case countCmd.FullCommand():
 		return countFn()
+	case statsCmd.FullCommand():
+		return statsFn()
+	case checksumCmd.FullCommand():
+		return checksumFn()
+	case selfCheckCmd.FullCommand():
+		return selfCheckFn()
 	case geojsonCmd.FullCommand():
 		return geojsonFn()
+	case topojsonCmd.FullCommand():
+		return topojsonFn()
+	case shapefileCmd.FullCommand():
+		return shapefileFn()
+	case gpkgCmd.FullCommand():
+		return gpkgFn()
 	case indexWaysCmd.FullCommand():
 		return indexWaysFn()
 	case indexRelationsCmd.FullCommand():
@@ -745,10 +3549,62 @@ func dispatch() error {
 		return printXmlNodesFn()
 	case recursiveRelCmd.FullCommand():
 		return recursiveRelFn()
+	case printRelationCmd.FullCommand():
+		return printRelationFn()
+	case printWayCmd.FullCommand():
+		return printWayFn()
 	case resetDbCmd.FullCommand():
 		return resetDbFn()
 	case checkCmd.FullCommand():
 		return checkFn()
+	case validateCmd.FullCommand():
+		return validateFn()
+	case dedupCountriesCmd.FullCommand():
+		return dedupCountriesFn()
+	case mergeDbCmd.FullCommand():
+		return mergeDbFn()
+	case migrateDbCmd.FullCommand():
+		return migrateDbFn()
+	case dbStatsCmd.FullCommand():
+		return dbStatsFn()
+	case diffDbCmd.FullCommand():
+		return diffDbFn()
+	case poisCmd.FullCommand():
+		return poisFn()
+	case indexPlacesCmd.FullCommand():
+		return indexPlacesFn()
+	case indexParentsCmd.FullCommand():
+		return indexParentsFn()
+	case duplicateBoundariesCmd.FullCommand():
+		return duplicateBoundariesFn()
+	case unresolvedCmd.FullCommand():
+		return unresolvedFn()
+	case boundsCmd.FullCommand():
+		return boundsFn()
+	case filterCmd.FullCommand():
+		return filterFn()
+	case extractCmd.FullCommand():
+		return extractFn()
+	case convertCmd.FullCommand():
+		return convertFn()
+	case fetchCmd.FullCommand():
+		return fetchFn()
+	case batchCmd.FullCommand():
+		return batchFn()
+	case completionCmd.FullCommand():
+		return completionFn()
+	case lookupCmd.FullCommand():
+		return lookupFn()
+	case applyChangesCmd.FullCommand():
+		return applyChangesFn()
+	case serveCmd.FullCommand():
+		return serveFn()
+	case rulesCmd.FullCommand():
+		return rulesFn()
+	case boundariesCmd.FullCommand():
+		return boundariesFn()
+	case versionCmd.FullCommand():
+		return versionFn()
 	}
 	return fmt.Errorf("unknown command: %s", cmd)
 }
@@ -756,7 +3612,7 @@ func dispatch() error {
 func main() {
 	err := dispatch()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
 }
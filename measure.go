@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	// Radius in km of the sphere having the area of the WGS84 ellipsoid
+	authalicRadiusKm = 6371.0072
+)
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Returns the area in km² of ring on the authalic sphere, with the spherical
+// excess approximation used by d3 and turf. It is accurate to a fraction of
+// a percent for boundaries, whatever the ring orientation.
+func ringAreaKm2(ring [][]float64) float64 {
+	if len(ring) < 3 {
+		return 0
+	}
+	sum := 0.
+	for i := range ring {
+		p1 := ring[i]
+		p2 := ring[(i+1)%len(ring)]
+		sum += toRadians(p2[0]-p1[0]) *
+			(2 + math.Sin(toRadians(p1[1])) + math.Sin(toRadians(p2[1])))
+	}
+	return math.Abs(sum) * authalicRadiusKm * authalicRadiusKm / 2
+}
+
+// Returns the great circle distance in km between two [lon, lat] points.
+func haversineKm(p1, p2 []float64) float64 {
+	lat1, lat2 := toRadians(p1[1]), toRadians(p2[1])
+	dLat := lat2 - lat1
+	dLon := toRadians(p2[0] - p1[0])
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * authalicRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Returns the geodesic area in km² of a multipolygon, holes excluded, and
+// its perimeter in km, holes included.
+func measureMultiPolygon(coords [][][][]float64) (float64, float64) {
+	area, perimeter := 0., 0.
+	for _, poly := range coords {
+		for i, ring := range poly {
+			a := ringAreaKm2(ring)
+			if i == 0 {
+				area += a
+			} else {
+				area -= a
+			}
+			for j := 1; j < len(ring); j++ {
+				perimeter += haversineKm(ring[j-1], ring[j])
+			}
+		}
+	}
+	return math.Max(area, 0), perimeter
+}
+
+// Sets loc area and perimeter from its coordinates.
+func measureLocation(loc *Location) {
+	loc.AreaKm2, loc.PerimeterKm = measureMultiPolygon(loc.Coordinates)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMeasureMultiPolygon(t *testing.T) {
+	square := func(x, y, size float64) [][]float64 {
+		return [][]float64{
+			{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}, {x, y},
+		}
+	}
+	deg := math.Pi / 180
+	r2 := authalicRadiusKm * authalicRadiusKm
+	// Exact area of a spherical "rectangle"
+	expectedArea := r2 * deg * (math.Sin(deg) - math.Sin(0))
+	side := authalicRadiusKm * deg
+	tests := []struct {
+		Coords    [][][][]float64
+		Area      float64
+		Perimeter float64
+	}{
+		{[][][][]float64{{square(0, 0, 1)}}, expectedArea, 4 * side},
+		// Orientation does not matter
+		{[][][][]float64{{reverseRing(square(0, 0, 1))}}, expectedArea, 4 * side},
+		// Holes are subtracted from the area but extend the perimeter
+		{[][][][]float64{{square(0, 0, 1), square(0.25, 0.25, 0.5)}},
+			expectedArea * 0.75, 6 * side},
+		{[][][][]float64{{square(0, 0, 1)}, {square(10, 0, 1)}},
+			2 * expectedArea, 8 * side},
+	}
+	for i, test := range tests {
+		area, perimeter := measureMultiPolygon(test.Coords)
+		if math.Abs(area-test.Area)/test.Area > 0.005 {
+			t.Fatalf("%d: unexpected area: %f != %f", i, area, test.Area)
+		}
+		if math.Abs(perimeter-test.Perimeter)/test.Perimeter > 0.005 {
+			t.Fatalf("%d: unexpected perimeter: %f != %f", i, perimeter,
+				test.Perimeter)
+		}
+	}
+}
+
+func reverseRing(ring [][]float64) [][]float64 {
+	reversed := append([][]float64{}, ring...)
+	reverseJsonRing(reversed)
+	return reversed
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MemoryUsage struct {
+	Rss  uint64
+	Heap uint64
+	Sys  uint64
+}
+
+// Returns the process resident set size. Falls back on the memory obtained
+// from the OS by the Go runtime when /proc is not available.
+func readRss(sys uint64) uint64 {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return sys
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return sys
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return sys
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+func getMemoryUsage() MemoryUsage {
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+	return MemoryUsage{
+		Rss:  readRss(stats.Sys),
+		Heap: stats.HeapAlloc,
+		Sys:  stats.Sys,
+	}
+}
+
+func formatGB(n uint64) string {
+	return fmt.Sprintf("%.2fGB", float64(n)/(1<<30))
+}
+
+func (m MemoryUsage) String() string {
+	return fmt.Sprintf("rss=%s heap=%s sys=%s", formatGB(m.Rss),
+		formatGB(m.Heap), formatGB(m.Sys))
+}
+
+// Starts a goroutine sampling memory usage. Usage is printed every "report"
+// interval if positive. The process is terminated with an error message as
+// soon as RSS exceeds "abortOver" GB, if positive, instead of waiting for the
+// OOM killer to do it hours later.
+func startMemoryMonitor(report time.Duration, abortOver float64) {
+	if report <= 0 && abortOver <= 0 {
+		return
+	}
+	limit := uint64(abortOver * (1 << 30))
+	go func() {
+		lastReport := time.Now()
+		for range time.Tick(time.Second) {
+			m := getMemoryUsage()
+			if limit > 0 && m.Rss > limit {
+				slog.Error("memory usage exceeds limit, aborting",
+					"limit_gb", abortOver, "usage", m.String())
+				os.Exit(2)
+			}
+			if report > 0 && time.Since(lastReport) >= report {
+				slog.Info("memory", "usage", m.String())
+				lastReport = time.Now()
+			}
+		}
+	}()
+}
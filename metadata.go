@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// Include element version, timestamp and author in printed nodes and
+	// exported features, set by --with-metadata.
+	exportMetadata = false
+)
+
+// MetadataJson is the exported form of Metadata, with an RFC3339 timestamp.
+type MetadataJson struct {
+	Version   int    `json:"version"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Changeset int    `json:"changeset,omitempty"`
+	Uid       string `json:"uid,omitempty"`
+	User      string `json:"user,omitempty"`
+}
+
+func formatTimestamp(ts int) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(int64(ts), 0).UTC().Format(time.RFC3339)
+}
+
+// Returns nil for elements without metadata, like files written without
+// history information.
+func makeMetadataJson(m *Metadata) *MetadataJson {
+	if m.Version <= 0 {
+		return nil
+	}
+	return &MetadataJson{
+		Version:   m.Version,
+		Timestamp: formatTimestamp(m.Timestamp),
+		Changeset: m.Changeset,
+		Uid:       m.Uid,
+		User:      m.Author,
+	}
+}
+
+// Formats m as space separated key=value pairs, prefixed with a space, or
+// returns an empty string for elements without metadata.
+func formatMetadata(m *Metadata) string {
+	if m.Version <= 0 {
+		return ""
+	}
+	s := fmt.Sprintf(" version=%d", m.Version)
+	if m.Timestamp != 0 {
+		s += fmt.Sprintf(" timestamp=%s changeset=%d", formatTimestamp(m.Timestamp),
+			m.Changeset)
+	}
+	if m.Author != "" {
+		s += fmt.Sprintf(" user=%q", m.Author)
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMetadataJson(t *testing.T) {
+	if js := makeMetadataJson(&Metadata{}); js != nil {
+		t.Fatalf("unexpected metadata for element without version: %+v", js)
+	}
+	m := &Metadata{
+		Version:   3,
+		Timestamp: 1577836800,
+		Changeset: 42,
+		Uid:       "7",
+		Author:    "mapper",
+	}
+	data, err := json.Marshal(makeMetadataJson(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":3,"timestamp":"2020-01-01T00:00:00Z","changeset":42,` +
+		`"uid":"7","user":"mapper"}`
+	if string(data) != expected {
+		t.Fatalf("unexpected metadata: %s", data)
+	}
+	s := formatMetadata(m)
+	if s != ` version=3 timestamp=2020-01-01T00:00:00Z changeset=42 user="mapper"` {
+		t.Fatalf("unexpected formatted metadata: %s", s)
+	}
+
+	defer func() { exportMetadata = false }()
+	exportMetadata = true
+	rel := &Relation{
+		Id:   1,
+		Meta: *m,
+		Tags: []StringPair{{Key: "name", Value: "a"}, {Key: "admin_level", Value: "8"}},
+	}
+	js, err := makeJsonRelation(rel, &Centroid{}, &Location{
+		Type:        "MultiPolygon",
+		Coordinates: [][][][]float64{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := makeFeature(js)
+	if meta, ok := f.Properties["meta"].(*MetadataJson); !ok ||
+		meta.User != "mapper" || meta.Version != 3 {
+		t.Fatalf("unexpected feature metadata: %+v", f.Properties["meta"])
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Languages of name:<lang> tags exported in names, nil to export them
+	// all, set by --name-languages.
+	nameLanguages map[string]bool
+
+	// Matches language codes like "fr", "zh-Hans" or "be-tarask", which
+	// excludes name:prefix or name:etymology:wikidata.
+	languageCodeRe = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]+)*$`)
+)
+
+// NamesJson lists the names of an element besides its default one, for
+// multilingual geocoding.
+type NamesJson struct {
+	// Names by language code, from name:<lang> tags
+	Languages     map[string]string `json:"languages,omitempty"`
+	Official      string            `json:"official,omitempty"`
+	International string            `json:"international,omitempty"`
+	Alternatives  []string          `json:"alternatives,omitempty"`
+}
+
+// Parses a comma separated list of language codes, returning nil for an
+// empty list.
+func parseNameLanguages(s string) map[string]bool {
+	var languages map[string]bool
+	for _, lang := range strings.Split(s, ",") {
+		lang = strings.TrimSpace(lang)
+		if lang == "" {
+			continue
+		}
+		if languages == nil {
+			languages = map[string]bool{}
+		}
+		languages[lang] = true
+	}
+	return languages
+}
+
+// Collects the name:<lang> tags allowed by languages, or all of them if it is
+// nil, along with official_name, int_name and alt_name. alt_name holds
+// several values separated by semicolons. Returns nil if there are none.
+func makeNamesJson(tags []StringPair, languages map[string]bool) *NamesJson {
+	names := &NamesJson{}
+	for _, tag := range tags {
+		value := strings.TrimSpace(tag.Value)
+		if value == "" {
+			continue
+		}
+		switch tag.Key {
+		case "official_name":
+			names.Official = value
+		case "int_name":
+			names.International = value
+		case "alt_name":
+			for _, alt := range strings.Split(value, ";") {
+				if alt = strings.TrimSpace(alt); alt != "" {
+					names.Alternatives = append(names.Alternatives, alt)
+				}
+			}
+		default:
+			lang := strings.TrimPrefix(tag.Key, "name:")
+			if lang == tag.Key || !languageCodeRe.MatchString(lang) ||
+				(languages != nil && !languages[lang]) {
+				continue
+			}
+			if names.Languages == nil {
+				names.Languages = map[string]string{}
+			}
+			names.Languages[lang] = value
+		}
+	}
+	if names.Languages == nil && names.Official == "" &&
+		names.International == "" && len(names.Alternatives) == 0 {
+		return nil
+	}
+	return names
+}
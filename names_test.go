@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMakeNamesJson(t *testing.T) {
+	tags := []StringPair{
+		{Key: "name", Value: "Bruxelles - Brussel"},
+		{Key: "name:fr", Value: "Bruxelles"},
+		{Key: "name:nl", Value: "Brussel"},
+		{Key: "name:zh-Hans", Value: "布鲁塞尔"},
+		{Key: "name:prefix", Value: "Région"},
+		{Key: "official_name", Value: "Région de Bruxelles-Capitale"},
+		{Key: "int_name", Value: "Brussels"},
+		{Key: "alt_name", Value: "Brussels; Bruxelas"},
+	}
+	names := makeNamesJson(tags, nil)
+	expected := &NamesJson{
+		Languages: map[string]string{
+			"fr":      "Bruxelles",
+			"nl":      "Brussel",
+			"zh-Hans": "布鲁塞尔",
+		},
+		Official:      "Région de Bruxelles-Capitale",
+		International: "Brussels",
+		Alternatives:  []string{"Brussels", "Bruxelas"},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected names: %+v", names)
+	}
+
+	names = makeNamesJson(tags, parseNameLanguages("nl, de"))
+	if !reflect.DeepEqual(names.Languages, map[string]string{"nl": "Brussel"}) {
+		t.Fatalf("unexpected languages: %+v", names.Languages)
+	}
+	if names := makeNamesJson(tags[:1], nil); names != nil {
+		t.Fatalf("unexpected names: %+v", names)
+	}
+	if parseNameLanguages(" ") != nil {
+		t.Fatal("empty language list should select all languages")
+	}
+}
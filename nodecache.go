@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	// Node id, then 32-bits fixed-point longitude and latitude
+	diskNodeRecordSize = 16
+	diskNodeBlockSize  = 256
+	diskNodeBlockBytes = diskNodeBlockSize * diskNodeRecordSize
+)
+
+// NodeStore resolves node coordinates while building ways.
+type NodeStore interface {
+	// FindPoint returns node id, or a *NodeNotFoundError if it is missing.
+	FindPoint(id int64) (NodePoint, error)
+}
+
+// DiskNodePoints is a NodeStore keeping nodes in a file instead of memory.
+// Nodes are appended by increasing id as fixed-width records, grouped in
+// blocks of diskNodeBlockSize records. Only the first id of each block stays
+// in memory, blocks are read on demand and kept in an LRU cache. Ways
+// reference nodes close in id, so a modest cache absorbs most lookups.
+type DiskNodePoints struct {
+	path     string
+	fp       *os.File
+	w        *bufio.Writer
+	blockIds []int64
+	count    int
+	lastId   int64
+	buf      []byte
+
+	lock   sync.Mutex
+	cache  *list.List
+	cached map[int]*list.Element
+	// Maximum number of cached blocks
+	cacheSize int
+	Hits      int
+	Misses    int
+}
+
+type diskNodeBlock struct {
+	index int
+	data  []byte
+}
+
+// Creates a node file at path, which is removed by Close. cacheSize is the
+// number of blocks kept in memory.
+func NewDiskNodePoints(path string, cacheSize int) (*DiskNodePoints, error) {
+	if cacheSize < 1 {
+		return nil, fmt.Errorf("invalid node cache size: %d", cacheSize)
+	}
+	fp, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskNodePoints{
+		path:      path,
+		fp:        fp,
+		w:         bufio.NewWriterSize(fp, 1024*1024),
+		buf:       make([]byte, diskNodeRecordSize),
+		cache:     list.New(),
+		cached:    map[int]*list.Element{},
+		cacheSize: cacheSize,
+	}, nil
+}
+
+func (points *DiskNodePoints) Close() error {
+	err := points.fp.Close()
+	os.Remove(points.path)
+	return err
+}
+
+func (points *DiskNodePoints) Len() int {
+	return points.count
+}
+
+// Append adds a node to the file. Node ids must be strictly increasing and
+// Flush must be called before looking nodes up.
+func (points *DiskNodePoints) Append(id int64, p Point) error {
+	if points.count > 0 && id <= points.lastId {
+		return fmt.Errorf("nodes are not sorted by id: %d >= %d",
+			points.lastId, id)
+	}
+	if points.count%diskNodeBlockSize == 0 {
+		points.blockIds = append(points.blockIds, id)
+	}
+	binary.LittleEndian.PutUint64(points.buf, uint64(id))
+	binary.LittleEndian.PutUint32(points.buf[8:], uint32(int32(p.Lon)))
+	binary.LittleEndian.PutUint32(points.buf[12:], uint32(int32(p.Lat)))
+	_, err := points.w.Write(points.buf)
+	if err != nil {
+		return err
+	}
+	points.count++
+	points.lastId = id
+	return nil
+}
+
+func (points *DiskNodePoints) Flush() error {
+	return points.w.Flush()
+}
+
+// Returns block b, from the cache if possible.
+func (points *DiskNodePoints) block(b int) ([]byte, error) {
+	if e, ok := points.cached[b]; ok {
+		points.Hits++
+		points.cache.MoveToFront(e)
+		return e.Value.(*diskNodeBlock).data, nil
+	}
+	points.Misses++
+	n := points.count - b*diskNodeBlockSize
+	if n > diskNodeBlockSize {
+		n = diskNodeBlockSize
+	}
+	var data []byte
+	if points.cache.Len() >= points.cacheSize {
+		// Recycle the least recently used block
+		e := points.cache.Back()
+		old := e.Value.(*diskNodeBlock)
+		points.cache.Remove(e)
+		delete(points.cached, old.index)
+		data = old.data[:n*diskNodeRecordSize]
+	} else {
+		data = make([]byte, n*diskNodeRecordSize, diskNodeBlockBytes)
+	}
+	_, err := points.fp.ReadAt(data, int64(b)*diskNodeBlockBytes)
+	if err != nil {
+		return nil, err
+	}
+	points.cached[b] = points.cache.PushFront(&diskNodeBlock{
+		index: b,
+		data:  data,
+	})
+	return data, nil
+}
+
+func diskNodeRecord(data []byte, i int) NodePoint {
+	rec := data[i*diskNodeRecordSize:]
+	return NodePoint{
+		Id: int64(binary.LittleEndian.Uint64(rec)),
+		Point: Point{
+			Lon: int64(int32(binary.LittleEndian.Uint32(rec[8:]))),
+			Lat: int64(int32(binary.LittleEndian.Uint32(rec[12:]))),
+		},
+	}
+}
+
+// FindPoint returns node id, or a *NodeNotFoundError if it was not appended.
+func (points *DiskNodePoints) FindPoint(id int64) (NodePoint, error) {
+	points.lock.Lock()
+	defer points.lock.Unlock()
+
+	b := sort.Search(len(points.blockIds), func(i int) bool {
+		return points.blockIds[i] > id
+	}) - 1
+	if b < 0 {
+		return NodePoint{}, &NodeNotFoundError{Id: id}
+	}
+	data, err := points.block(b)
+	if err != nil {
+		return NodePoint{}, err
+	}
+	n := len(data) / diskNodeRecordSize
+	i := sort.Search(n, func(i int) bool {
+		return diskNodeRecord(data, i).Id >= id
+	})
+	if i >= n || diskNodeRecord(data, i).Id != id {
+		return NodePoint{}, &NodeNotFoundError{Id: id}
+	}
+	return diskNodeRecord(data, i), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	nodeBlockSize = 128
+)
+
+type NodePoint struct {
+	Id    int64
+	Point Point
+}
+
+// NodePoints is a compact in-memory node index. Nodes must be appended by
+// increasing id. They are grouped in blocks of nodeBlockSize entries: the
+// first id of each block is stored in full and used for binary search, the
+// other ones are stored as varint deltas from their predecessor. Coordinates
+// are stored as 32-bits fixed-point values, which is enough for o5m 1e-7
+// degree resolution.
+type NodePoints struct {
+	blockIds     []int64
+	blockOffsets []int
+	deltas       []byte
+	coords       []int32
+	lastId       int64
+	buf          []byte
+}
+
+func NewNodePoints(capacity int) *NodePoints {
+	blocks := capacity/nodeBlockSize + 1
+	return &NodePoints{
+		blockIds:     make([]int64, 0, blocks),
+		blockOffsets: make([]int, 0, blocks),
+		deltas:       make([]byte, 0, capacity*2),
+		coords:       make([]int32, 0, capacity*2),
+		buf:          make([]byte, binary.MaxVarintLen64),
+	}
+}
+
+func (points *NodePoints) Len() int {
+	return len(points.coords) / 2
+}
+
+// Append adds a node to the index. Node ids must be strictly increasing.
+func (points *NodePoints) Append(id int64, p Point) error {
+	n := points.Len()
+	if n > 0 && id <= points.lastId {
+		return fmt.Errorf("nodes are not sorted by id: %d >= %d",
+			points.lastId, id)
+	}
+	if n%nodeBlockSize == 0 {
+		points.blockIds = append(points.blockIds, id)
+		points.blockOffsets = append(points.blockOffsets, len(points.deltas))
+	} else {
+		l := binary.PutUvarint(points.buf, uint64(id-points.lastId))
+		points.deltas = append(points.deltas, points.buf[:l]...)
+	}
+	points.coords = append(points.coords, int32(p.Lon), int32(p.Lat))
+	points.lastId = id
+	return nil
+}
+
+func (points *NodePoints) point(i int) Point {
+	return Point{
+		Lon: int64(points.coords[2*i]),
+		Lat: int64(points.coords[2*i+1]),
+	}
+}
+
+// FindPoint returns node id, or a *NodeNotFoundError if it was not appended.
+func (points *NodePoints) FindPoint(id int64) (NodePoint, error) {
+	// Find the last block starting before or at id
+	b := sort.Search(len(points.blockIds), func(i int) bool {
+		return points.blockIds[i] > id
+	}) - 1
+	if b < 0 {
+		return NodePoint{}, &NodeNotFoundError{Id: id}
+	}
+	i := b * nodeBlockSize
+	current := points.blockIds[b]
+	offset := points.blockOffsets[b]
+	end := i + nodeBlockSize
+	if end > points.Len() {
+		end = points.Len()
+	}
+	for current < id {
+		i++
+		if i >= end {
+			break
+		}
+		delta, l := binary.Uvarint(points.deltas[offset:])
+		offset += l
+		current += int64(delta)
+	}
+	if current != id {
+		return NodePoint{}, &NodeNotFoundError{Id: id}
+	}
+	return NodePoint{
+		Id:    current,
+		Point: points.point(i),
+	}, nil
+}
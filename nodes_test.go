@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNodePoints(t *testing.T) {
+	points := NewNodePoints(0)
+	ids := []int64{}
+	id := int64(3)
+	for i := 0; i < 3*nodeBlockSize+7; i++ {
+		ids = append(ids, id)
+		p := Point{Lon: -id * 10, Lat: id * 5}
+		if err := points.Append(id, p); err != nil {
+			t.Fatal(err)
+		}
+		// Mix small and large deltas
+		id += int64(1 + (i%5)*1000)
+	}
+	if points.Len() != len(ids) {
+		t.Fatalf("unexpected length: %d != %d", points.Len(), len(ids))
+	}
+	for _, id := range ids {
+		n, err := points.FindPoint(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Id != id || n.Point.Lon != -id*10 || n.Point.Lat != id*5 {
+			t.Fatalf("unexpected node for %d: %+v", id, n)
+		}
+	}
+	// Missing ids are reported
+	for _, id := range []int64{0, ids[1] + 1, ids[len(ids)-1] + 1} {
+		_, err := points.FindPoint(id)
+		if _, ok := err.(*NodeNotFoundError); !ok {
+			t.Fatalf("missing node %d was resolved: %v", id, err)
+		}
+	}
+	if err := points.Append(ids[len(ids)-1], Point{}); err == nil {
+		t.Fatalf("unsorted node was accepted")
+	}
+}
+
+func TestDiskNodePoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osm-nodes-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	disk, err := NewDiskNodePoints(filepath.Join(dir, "nodes"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	mem := NewNodePoints(0)
+	id := int64(3)
+	for i := 0; i < 3*diskNodeBlockSize+7; i++ {
+		p := Point{Lon: -id * 10, Lat: id * 5}
+		if err := disk.Append(id, p); err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.Append(id, p); err != nil {
+			t.Fatal(err)
+		}
+		id += int64(1 + (i%5)*1000)
+	}
+	if err := disk.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Compare with NodePoints, including missing ids, out of order to
+	// exercise cache eviction
+	for _, step := range []int64{997, -1499} {
+		for i := int64(0); i < 300; i++ {
+			id := (1000000 + i*step) % (id + 10)
+			if id < 0 {
+				id = -id
+			}
+			want, wantErr := mem.FindPoint(id)
+			got, err := disk.FindPoint(id)
+			if (err != nil) != (wantErr != nil) || got != want {
+				t.Fatalf("unexpected node for %d: %+v, %v != %+v, %v",
+					id, got, err, want, wantErr)
+			}
+		}
+	}
+	if disk.Hits == 0 || disk.Misses == 0 {
+		t.Fatalf("unexpected cache stats: hits=%d misses=%d", disk.Hits,
+			disk.Misses)
+	}
+	if err := disk.Append(disk.lastId, Point{}); err == nil {
+		t.Fatalf("unsorted node was accepted")
+	}
+}
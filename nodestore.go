@@ -0,0 +1,235 @@
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+var (
+	nodesBucket = []byte("nodes")
+)
+
+const (
+	// Node ids per block, a node id is stored at offset id%denseNodeBlockIds
+	// of block id/denseNodeBlockIds
+	denseNodeBlockIds = 1 << 16
+	// 32-bits fixed-point longitude and latitude
+	denseNodeRecordSize = 8
+	denseNodeBlockBytes = denseNodeBlockIds * denseNodeRecordSize
+	// Blocks written per transaction
+	denseNodeBatchBlocks = 16
+	// Coordinates of missing nodes, out of valid longitude range
+	denseNodeMissing = math.MinInt32
+)
+
+// NodeNotFoundError is returned by node stores telling missing nodes apart,
+// instead of resolving them to the next node.
+type NodeNotFoundError struct {
+	Id int64
+}
+
+func (e *NodeNotFoundError) Error() string {
+	return fmt.Sprintf("node not found: %d", e.Id)
+}
+
+// DenseNodePoints is a NodeStore keeping node coordinates in the nodes
+// bucket of a WaysDb, like osm2pgsql flat node files. Coordinates are stored
+// in blocks of denseNodeBlockIds consecutive ids, whether the nodes exist or
+// not, so lookups are direct and missing nodes are detected. Blocks are
+// read on demand and kept in an LRU cache. It suits large inputs where node
+// ids are dense, like the planet, small extracts waste space on ids gaps.
+type DenseNodePoints struct {
+	db      *WaysDb
+	count   int
+	lastId  int64
+	block   []byte
+	index   int64
+	pending map[int64][]byte
+
+	lock   sync.Mutex
+	cache  *list.List
+	cached map[int64]*list.Element
+	// Maximum number of cached blocks
+	cacheSize int
+	Hits      int
+	Misses    int
+}
+
+type denseNodeBlock struct {
+	index int64
+	data  []byte
+}
+
+// Returns a store on db nodes bucket, caching cacheSize blocks. Call Clear
+// before appending nodes to replace the stored ones.
+func NewDenseNodePoints(db *WaysDb, cacheSize int) (*DenseNodePoints, error) {
+	if cacheSize < 1 {
+		return nil, fmt.Errorf("invalid node cache size: %d", cacheSize)
+	}
+	return &DenseNodePoints{
+		db:        db,
+		index:     -1,
+		pending:   map[int64][]byte{},
+		cache:     list.New(),
+		cached:    map[int64]*list.Element{},
+		cacheSize: cacheSize,
+	}, nil
+}
+
+// Clear deletes stored nodes.
+func (points *DenseNodePoints) Clear() error {
+	points.cache.Init()
+	points.cached = map[int64]*list.Element{}
+	return points.db.db.Update(func(tx kvTx) error {
+		return tx.ClearBucket(nodesBucket)
+	})
+}
+
+func makeDenseNodeKey(index int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(index))
+	return key
+}
+
+func newDenseNodeBlock() []byte {
+	data := make([]byte, denseNodeBlockBytes)
+	missing := int32(denseNodeMissing)
+	for i := 0; i < len(data); i += 4 {
+		binary.LittleEndian.PutUint32(data[i:], uint32(missing))
+	}
+	return data
+}
+
+func (points *DenseNodePoints) Len() int {
+	return points.count
+}
+
+// Append adds a node to the store. Node ids must be strictly increasing and
+// Flush must be called before looking nodes up.
+func (points *DenseNodePoints) Append(id int64, p Point) error {
+	if points.count > 0 && id <= points.lastId {
+		return fmt.Errorf("nodes are not sorted by id: %d >= %d",
+			points.lastId, id)
+	}
+	if id < 0 {
+		return fmt.Errorf("cannot store negative node id: %d", id)
+	}
+	index := id / denseNodeBlockIds
+	if index != points.index {
+		if points.block != nil {
+			points.pending[points.index] = points.block
+			if len(points.pending) >= denseNodeBatchBlocks {
+				if err := points.writePending(); err != nil {
+					return err
+				}
+			}
+		}
+		points.block = newDenseNodeBlock()
+		points.index = index
+	}
+	rec := points.block[(id%denseNodeBlockIds)*denseNodeRecordSize:]
+	binary.LittleEndian.PutUint32(rec, uint32(int32(p.Lon)))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(int32(p.Lat)))
+	points.count++
+	points.lastId = id
+	return nil
+}
+
+func (points *DenseNodePoints) writePending() error {
+	err := points.db.db.Update(func(tx kvTx) error {
+		for index, data := range points.pending {
+			err := tx.Put(nodesBucket, makeDenseNodeKey(index), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	points.pending = map[int64][]byte{}
+	return err
+}
+
+// Flush writes appended nodes to the db.
+func (points *DenseNodePoints) Flush() error {
+	if points.block != nil {
+		points.pending[points.index] = points.block
+		points.block = nil
+		points.index = -1
+	}
+	return points.writePending()
+}
+
+// Returns block index, from the cache if possible, or nil if it does not
+// exist.
+func (points *DenseNodePoints) getBlock(index int64) ([]byte, error) {
+	if e, ok := points.cached[index]; ok {
+		points.Hits++
+		points.cache.MoveToFront(e)
+		return e.Value.(*denseNodeBlock).data, nil
+	}
+	points.Misses++
+	var data []byte
+	err := points.db.db.View(func(tx kvTx) error {
+		value, err := tx.Get(nodesBucket, makeDenseNodeKey(index))
+		if value == nil || err != nil {
+			return err
+		}
+		if len(value) != denseNodeBlockBytes {
+			return fmt.Errorf("invalid node block %d size: %d", index,
+				len(value))
+		}
+		if points.cache.Len() >= points.cacheSize {
+			// Recycle the least recently used block
+			e := points.cache.Back()
+			old := e.Value.(*denseNodeBlock)
+			points.cache.Remove(e)
+			delete(points.cached, old.index)
+			data = old.data
+		} else {
+			data = make([]byte, denseNodeBlockBytes)
+		}
+		copy(data, value)
+		return nil
+	})
+	if data == nil || err != nil {
+		return nil, err
+	}
+	points.cached[index] = points.cache.PushFront(&denseNodeBlock{
+		index: index,
+		data:  data,
+	})
+	return data, nil
+}
+
+// FindPoint returns node id, or a *NodeNotFoundError if it is not stored.
+func (points *DenseNodePoints) FindPoint(id int64) (NodePoint, error) {
+	points.lock.Lock()
+	defer points.lock.Unlock()
+
+	if id < 0 {
+		return NodePoint{}, &NodeNotFoundError{Id: id}
+	}
+	data, err := points.getBlock(id / denseNodeBlockIds)
+	if err != nil {
+		return NodePoint{}, err
+	}
+	if data == nil {
+		return NodePoint{}, &NodeNotFoundError{Id: id}
+	}
+	rec := data[(id%denseNodeBlockIds)*denseNodeRecordSize:]
+	lon := int32(binary.LittleEndian.Uint32(rec))
+	lat := int32(binary.LittleEndian.Uint32(rec[4:]))
+	if lon == denseNodeMissing {
+		return NodePoint{}, &NodeNotFoundError{Id: id}
+	}
+	return NodePoint{
+		Id: id,
+		Point: Point{
+			Lon: int64(lon),
+			Lat: int64(lat),
+		},
+	}, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDenseNodePoints(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+	points, err := NewDenseNodePoints(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := points.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	nodes := []NodePoint{
+		{Id: 1, Point: Point{Lon: -1800000000, Lat: 900000000}},
+		{Id: 5, Point: Point{Lon: 10, Lat: -20}},
+		{Id: 70000, Point: Point{Lon: 30, Lat: 40}},
+	}
+	for _, n := range nodes {
+		if err := points.Append(n.Id, n.Point); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := points.Append(4, Point{}); err == nil {
+		t.Fatalf("unsorted node was accepted")
+	}
+	if err := points.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Alternate blocks to exercise the cache eviction
+	for i := 0; i < 2; i++ {
+		for _, n := range nodes {
+			p, err := points.FindPoint(n.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != n {
+				t.Fatalf("unexpected node: %+v != %+v", p, n)
+			}
+		}
+	}
+	for _, id := range []int64{-1, 0, 4, 6, 69999, 1 << 20} {
+		_, err := points.FindPoint(id)
+		if _, ok := err.(*NodeNotFoundError); !ok {
+			t.Fatalf("missing node %d was resolved: %v", id, err)
+		}
+	}
+}
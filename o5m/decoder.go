@@ -0,0 +1,281 @@
+package o5m
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxDatasetLength bounds the length of datasets, so corrupted files fail
+// instead of allocating huge buffers. The largest relations take a few
+// hundred KB.
+const MaxDatasetLength = 16 << 20
+
+var errVarintOverflow = errors.New("varint overflows 64 bits")
+
+// Reads a signed varint, its sign being stored in the lowest bit.
+func readSigned(r *bufio.Reader) (int64, int, error) {
+	u, read, err := readUnsigned(r)
+	if err != nil {
+		return 0, read, err
+	}
+	if u&1 != 0 {
+		return -int64(u>>1) - 1, read, nil
+	}
+	return int64(u >> 1), read, nil
+}
+
+// Reads an unsigned varint. Like encoding/binary, varints longer than 10
+// bytes or exceeding 64 bits are rejected.
+func readUnsigned(r *bufio.Reader) (uint64, int, error) {
+	n := uint64(0)
+	read := 0
+	for read < binary.MaxVarintLen64 {
+		b, err := r.ReadByte()
+		read += 1
+		if err != nil {
+			return 0, read, err
+		}
+		if read == binary.MaxVarintLen64 && b > 1 {
+			return 0, read, errVarintOverflow
+		}
+		n |= uint64(b&^0x80) << (7 * uint(read-1))
+		if b&0x80 == 0 {
+			return n, read, nil
+		}
+	}
+	return 0, read, errVarintOverflow
+}
+
+// StringsTableSize is the number of entries of the strings table, string
+// references range from 1 to StringsTableSize.
+const StringsTableSize = 15000
+
+// MaxStoredPairLength is the maximum combined length of the strings of a
+// pair stored in the strings table. Longer pairs are always written
+// literally and do not consume a reference.
+const MaxStoredPairLength = 250
+
+// IsStorable returns true if the pair made of k and v goes in the strings
+// table. Single strings are pairs with an empty value.
+func IsStorable(k, v string) bool {
+	return len(k)+len(v) <= MaxStoredPairLength
+}
+
+// StringsTable holds the strings recently read, which later strings can
+// reference by their index, 1 being the latest.
+type StringsTable struct {
+	entries []StringPair
+	latest  int
+	// Number of entries filled, up to len(entries)
+	size int
+}
+
+func NewStringsTable() *StringsTable {
+	return &StringsTable{
+		entries: make([]StringPair, StringsTableSize),
+		latest:  0,
+	}
+}
+
+// Push adds a pair to the table, unless it is too long to be referenced.
+func (st *StringsTable) Push(k, v string) {
+	if !IsStorable(k, v) {
+		return
+	}
+	p := StringPair{
+		Key:   k,
+		Value: v,
+	}
+	st.entries[st.latest] = p
+	st.latest = (st.latest + 1) % len(st.entries)
+	if st.size < len(st.entries) {
+		st.size++
+	}
+}
+
+// Get returns the pair pushed n pairs ago, 1 being the latest. References to
+// entries never filled are errors.
+func (st *StringsTable) Get(n int) (string, string, error) {
+	if n < 1 || n > st.size {
+		return "", "", fmt.Errorf("invalid string reference: %d", n)
+	}
+	n = st.latest - n
+	if n < 0 {
+		n = len(st.entries) + n
+	}
+	p := st.entries[n]
+	return p.Key, p.Value, nil
+}
+
+// Decoder reads the varints and strings o5m datasets are made of. Errors are
+// sticky: once a read fails, following ones return zero values and Err
+// reports the first error.
+type Decoder struct {
+	r       *bufio.Reader
+	strings *StringsTable
+	read    int
+	err     error
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderAt(r, 0)
+}
+
+// NewDecoderAt returns a decoder for r positioned at offset in the file, to
+// resume decoding after a seek.
+func NewDecoderAt(r io.Reader, offset int) *Decoder {
+	return &Decoder{
+		r:       bufio.NewReader(r),
+		strings: NewStringsTable(),
+		read:    offset,
+	}
+}
+
+// Reset clears the strings table, at reset points.
+func (r *Decoder) Reset() {
+	r.strings = NewStringsTable()
+}
+
+func (r *Decoder) Err() error {
+	return r.err
+}
+
+// Byte reads a single byte.
+func (r *Decoder) Byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.r.ReadByte()
+	r.err = err
+	r.read += 1
+	return b
+}
+
+// ReadFull fills buf, like io.ReadFull, and returns the number of bytes read.
+func (r *Decoder) ReadFull(buf []byte) int {
+	if r.err != nil {
+		return 0
+	}
+	n, err := io.ReadFull(r.r, buf)
+	r.err = err
+	r.read += n
+	return n
+}
+
+func (r *Decoder) ReadSigned() int64 {
+	if r.err != nil {
+		return 0
+	}
+	n, read, err := readSigned(r.r)
+	r.read += read
+	r.err = err
+	return n
+}
+
+func (r *Decoder) ReadUnsigned() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, read, err := readUnsigned(r.r)
+	r.read += read
+	r.err = err
+	return n
+}
+
+func (r *Decoder) ReadString() string {
+	k, _ := r.readStrings(true)
+	return k
+}
+
+func (r *Decoder) ReadStrings() (string, string) {
+	return r.readStrings(false)
+}
+
+func (r *Decoder) readStrings(single bool) (k string, v string) {
+	if r.err != nil {
+		return
+	}
+	b, err := r.r.ReadByte()
+	if err != nil {
+		r.err = err
+		return
+	}
+	if b == 0 {
+		r.read += 1
+		buf, err := r.r.ReadSlice(0)
+		if err != nil {
+			r.err = err
+			return
+		}
+		r.read += len(buf)
+		k = string(buf[:len(buf)-1])
+
+		if !single {
+			buf, err = r.r.ReadSlice(0)
+			if err != nil {
+				r.err = err
+				return
+			}
+			r.read += len(buf)
+			v = string(buf[:len(buf)-1])
+		}
+		r.strings.Push(k, v)
+	} else {
+		r.r.UnreadByte()
+		index := r.ReadUnsigned()
+		if r.err != nil {
+			return
+		}
+		key, value, err := r.strings.Get(int(index))
+		if err != nil {
+			r.err = err
+			return
+		}
+		k = key
+		v = value
+	}
+	return
+}
+
+// Offset returns the file offset of the next byte to decode.
+func (r *Decoder) Offset() int {
+	return r.read
+}
+
+func (r *Decoder) Discard(n int) (int, error) {
+	n, err := r.r.Discard(n)
+	r.read += n
+	return n, err
+}
+
+// ParseHeader parses the file header and returns true for o5c change files,
+// which use the o5m encoding plus delete markers.
+func ParseHeader(r *Decoder) (bool, error) {
+	h := r.Byte()
+	if r.Err() != nil || h != 0xff {
+		return false, fmt.Errorf("unexpected header byte: %d, %s", h, r.Err())
+	}
+	kind := r.Byte()
+	if r.Err() != nil || kind != 0xe0 {
+		return false, fmt.Errorf("unexpected header section: %x, %s", kind, r.Err())
+	}
+	l := r.ReadUnsigned()
+	if l != 4 {
+		return false, fmt.Errorf("unexpected header section length: %d", l)
+	}
+	buf := make([]byte, 4)
+	r.ReadFull(buf)
+	if r.Err() != nil {
+		return false, r.Err()
+	}
+	switch string(buf) {
+	case "o5m2":
+		return false, nil
+	case "o5c2":
+		return true, nil
+	}
+	return false, fmt.Errorf("unexpected o5m type: %s", string(buf))
+}
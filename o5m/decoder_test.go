@@ -0,0 +1,86 @@
+package o5m
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStringsTable(t *testing.T) {
+	st := NewStringsTable()
+	if _, _, err := st.Get(1); err == nil {
+		t.Fatalf("reference to an empty table was accepted")
+	}
+	long := strings.Repeat("x", MaxStoredPairLength)
+	for i := 0; i < StringsTableSize+10; i++ {
+		st.Push(fmt.Sprintf("k%d", i), "v")
+		// Long pairs do not consume references
+		st.Push(long, "y")
+	}
+	if !IsStorable(long, "") || IsStorable(long, "y") {
+		t.Fatalf("pairs are stored up to %d bytes", MaxStoredPairLength)
+	}
+	check := func(n int, expected string) {
+		k, v, err := st.Get(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != expected || v != "v" {
+			t.Fatalf("unexpected pair %d: %s=%s, expected %s", n, k, v,
+				expected)
+		}
+	}
+	check(1, fmt.Sprintf("k%d", StringsTableSize+9))
+	check(StringsTableSize, "k10")
+	for _, n := range []int{0, -1, StringsTableSize + 1} {
+		if _, _, err := st.Get(n); err == nil {
+			t.Fatalf("invalid reference %d was accepted", n)
+		}
+	}
+}
+
+// Nodes with one tag each: distinct pairs overflowing the strings table,
+// interleaved with pairs too long to be stored, then references to the
+// oldest and latest stored pairs.
+func TestReaderStringsWraparound(t *testing.T) {
+	data := []byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2', 0xff}
+	appendNode := func(tag []byte) {
+		ds := append([]byte{0x02, 0x00, 0x00, 0x00}, tag...)
+		data = append(data, byte(NodeKind))
+		data = AppendUnsigned(data, uint64(len(ds)))
+		data = append(data, ds...)
+	}
+	long := strings.Repeat("x", MaxStoredPairLength)
+	count := StringsTableSize + 5
+	for i := 0; i < count; i++ {
+		appendNode([]byte(fmt.Sprintf("\x00k%d\x00v\x00", i)))
+		if i%1000 == 0 {
+			appendNode([]byte("\x00" + long + "\x00y\x00"))
+		}
+	}
+	appendNode(AppendUnsigned(nil, StringsTableSize))
+	appendNode([]byte{0x01})
+	data = append(data, byte(EndKind))
+	path := writeTempFile(t, data)
+	defer os.Remove(path)
+
+	r, err := NewReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	keys := []string{}
+	for r.Next() {
+		if r.Kind() == NodeKind {
+			keys = append(keys, r.Node().Tags[0].Key)
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	n := len(keys)
+	if keys[n-2] != "k5" || keys[n-1] != fmt.Sprintf("k%d", count-1) {
+		t.Fatalf("unexpected referenced keys: %s, %s", keys[n-2], keys[n-1])
+	}
+}
@@ -0,0 +1,101 @@
+package o5m
+
+// AppendUnsigned appends n as an unsigned varint, the inverse of
+// Decoder.ReadUnsigned.
+func AppendUnsigned(buf []byte, n uint64) []byte {
+	for n >= 0x80 {
+		buf = append(buf, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(buf, byte(n))
+}
+
+// AppendSigned appends n as a signed varint, the inverse of
+// Decoder.ReadSigned: the sign is stored in the lowest bit, negative values
+// are offset by one.
+func AppendSigned(buf []byte, n int64) []byte {
+	u := uint64(n) << 1
+	if n < 0 {
+		u = (uint64(-(n + 1)) << 1) | 1
+	}
+	return AppendUnsigned(buf, u)
+}
+
+// Encoder appends the strings and metadata o5m datasets are made of, the
+// inverse of Decoder. It tracks the strings table of decoders, so pairs
+// written again are encoded as references while decoders still hold them.
+type Encoder struct {
+	// Strings table position of pairs, by pair
+	positions map[StringPair]int
+	// Number of pairs stored in the strings table
+	count int
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{
+		positions: map[StringPair]int{},
+	}
+}
+
+// Reset clears the strings table, at reset points.
+func (e *Encoder) Reset() {
+	e.positions = map[StringPair]int{}
+	e.count = 0
+}
+
+// Appends the encoded pair to buf, either as a back reference to an
+// identical pair written earlier or as a literal. Only pairs the decoder
+// stores in its table are stored here, so references stay in sync.
+func (e *Encoder) appendStrings(buf []byte, k, v string, single bool) []byte {
+	p := StringPair{
+		Key:   k,
+		Value: v,
+	}
+	if pos, ok := e.positions[p]; ok {
+		ref := e.count - pos
+		if ref <= StringsTableSize {
+			return AppendUnsigned(buf, uint64(ref))
+		}
+	}
+	buf = append(buf, 0)
+	buf = append(buf, k...)
+	buf = append(buf, 0)
+	if !single {
+		buf = append(buf, v...)
+		buf = append(buf, 0)
+	}
+	if IsStorable(k, v) {
+		e.positions[p] = e.count
+		e.count++
+	}
+	return buf
+}
+
+// AppendStrings appends a pair of strings, the inverse of
+// Decoder.ReadStrings.
+func (e *Encoder) AppendStrings(buf []byte, k, v string) []byte {
+	return e.appendStrings(buf, k, v, false)
+}
+
+// AppendString appends a single string, the inverse of Decoder.ReadString.
+func (e *Encoder) AppendString(buf []byte, s string) []byte {
+	return e.appendStrings(buf, s, "", true)
+}
+
+// AppendMeta appends m delta encoded against base, which it updates, the
+// inverse of the metadata decoding of readers. Elements without version
+// have no metadata at all and leave base unchanged.
+func (e *Encoder) AppendMeta(buf []byte, base *MetaBase, m *Metadata) []byte {
+	if m.Version <= 0 {
+		return append(buf, 0)
+	}
+	buf = AppendUnsigned(buf, uint64(m.Version))
+	buf = AppendSigned(buf, int64(m.Timestamp-base.Timestamp))
+	base.Timestamp = m.Timestamp
+	if m.Timestamp != 0 {
+		buf = AppendSigned(buf, int64(m.Changeset-base.Changeset))
+		buf = e.AppendStrings(buf, m.Uid, m.Author)
+		base.Changeset = m.Changeset
+	}
+	return buf
+}
@@ -0,0 +1,127 @@
+package o5m
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVarintEncoding(t *testing.T) {
+	values := []int64{0, 1, -1, 63, -64, 64, -65, 1 << 40, -(1 << 40),
+		9223372036854775807, -9223372036854775808}
+	for _, v := range values {
+		r := NewDecoder(bytes.NewReader(AppendSigned(nil, v)))
+		n := r.ReadSigned()
+		if r.Err() != nil || n != v {
+			t.Fatalf("signed roundtrip failed: %d != %d (%v)", n, v, r.Err())
+		}
+		r = NewDecoder(bytes.NewReader(AppendUnsigned(nil, uint64(v))))
+		u := r.ReadUnsigned()
+		if r.Err() != nil || u != uint64(v) {
+			t.Fatalf("unsigned roundtrip failed: %d != %d", u, uint64(v))
+		}
+	}
+}
+
+func TestEncoderStrings(t *testing.T) {
+	// Overflow the strings table with distinct pairs and pairs too long to
+	// be stored, then repeat pairs still referenceable or expired.
+	long := strings.Repeat("x", MaxStoredPairLength)
+	pairs := []StringPair{}
+	for i := 0; i < StringsTableSize; i++ {
+		pairs = append(pairs, StringPair{Key: fmt.Sprintf("k%d", i), Value: "v"})
+		if i%1000 == 0 {
+			pairs = append(pairs, StringPair{Key: long, Value: "v"})
+		}
+	}
+	pairs = append(pairs,
+		StringPair{Key: "k0", Value: "v"},
+		StringPair{Key: long, Value: "v"},
+		StringPair{Key: "new", Value: "v"},
+		StringPair{Key: "k0", Value: "v"},
+		StringPair{Key: "k1", Value: "v"},
+		StringPair{Key: "new", Value: "v"},
+	)
+	e := NewEncoder()
+	buf := []byte{}
+	refs := 0
+	for _, p := range pairs {
+		n := len(buf)
+		buf = e.AppendStrings(buf, p.Key, p.Value)
+		if buf[n] != 0 {
+			refs++
+		}
+	}
+	// Only the first k0 repeat and the last new are references
+	if refs != 2 {
+		t.Fatalf("unexpected references count: %d", refs)
+	}
+	d := NewDecoder(bytes.NewReader(buf))
+	for i, p := range pairs {
+		k, v := d.ReadStrings()
+		if d.Err() != nil {
+			t.Fatal(d.Err())
+		}
+		if k != p.Key || v != p.Value {
+			t.Fatalf("pair %d mismatch: %s=%s != %s=%s", i, k, v, p.Key, p.Value)
+		}
+	}
+
+	// Single strings share the table with pairs
+	e.Reset()
+	buf = e.AppendString(nil, "1outer")
+	buf = e.AppendString(buf, "1outer")
+	d = NewDecoder(bytes.NewReader(buf))
+	for i := 0; i < 2; i++ {
+		if s := d.ReadString(); s != "1outer" || d.Err() != nil {
+			t.Fatalf("unexpected string: %q, %v", s, d.Err())
+		}
+	}
+	if len(buf) != 9 {
+		t.Fatalf("repeated string was not referenced: %x", buf)
+	}
+}
+
+func TestEncoderMeta(t *testing.T) {
+	metas := []Metadata{
+		{Version: 1, Timestamp: 1000, Changeset: 10, Uid: "\x07",
+			Author: "alice"},
+		{},
+		{Version: 2, Timestamp: 1005, Changeset: 11, Uid: "\x07",
+			Author: "alice"},
+		{Version: 1},
+		{Version: 1, Timestamp: 2000, Changeset: 12, Uid: "\x07",
+			Author: "alice"},
+	}
+	e := NewEncoder()
+	base := MetaBase{}
+	buf := []byte{}
+	for i := range metas {
+		buf = e.AppendMeta(buf, &base, &metas[i])
+	}
+	// Same encoding as TestReaderMetadataDeltaBytes
+	expected := []byte{
+		0x01, 0xd0, 0x0f, 0x14, 0x00, 0x07, 0x00, 'a', 'l', 'i', 'c', 'e', 0x00,
+		0x00,
+		0x02, 0x0a, 0x02, 0x01,
+		0x01, 0xd9, 0x0f,
+		0x01, 0xa0, 0x1f, 0x02, 0x01,
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Fatalf("unexpected encoding:\n%x\n!=\n%x", buf, expected)
+	}
+	d := NewDecoder(bytes.NewReader(buf))
+	base = MetaBase{}
+	for i := range metas {
+		m := Metadata{}
+		parseMeta(d, &base, &m)
+		if d.Err() != nil {
+			t.Fatal(d.Err())
+		}
+		if !reflect.DeepEqual(m, metas[i]) {
+			t.Fatalf("metadata %d mismatch: %+v != %+v", i, m, metas[i])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package o5m
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func FuzzReadUnsigned(f *testing.F) {
+	for _, seed := range [][]byte{
+		{}, {0x00}, {0x7f}, {0x80, 0x01}, {0xff, 0xff, 0xff},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02},
+		{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01},
+		{0xff, 0x8f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x8f},
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, read, err := readUnsigned(bufio.NewReader(bytes.NewReader(data)))
+		expected, n := binary.Uvarint(data)
+		switch {
+		case n > 0:
+			if err != nil || v != expected || read != n {
+				t.Fatalf("%x: got %d, %d, %v, expected %d, %d", data, v, read,
+					err, expected, n)
+			}
+		case n == 0 && len(data) < binary.MaxVarintLen64:
+			if err != io.EOF {
+				t.Fatalf("%x: truncated varint not reported: %v", data, err)
+			}
+		default:
+			// Unlike encoding/binary, continuing past 10 bytes is an
+			// overflow even if the input ends there.
+			if err != errVarintOverflow {
+				t.Fatalf("%x: overflow not reported: %v", data, err)
+			}
+		}
+	})
+}
+
+func FuzzReadSigned(f *testing.F) {
+	for _, seed := range [][]byte{
+		{}, {0x00}, {0x01}, {0x02}, {0x81, 0x01}, {0x80},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, read, err := readSigned(bufio.NewReader(bytes.NewReader(data)))
+		// o5m signed varints are zigzag encoded like encoding/binary ones
+		expected, n := binary.Varint(data)
+		if n > 0 {
+			if err != nil || v != expected || read != n {
+				t.Fatalf("%x: got %d, %d, %v, expected %d, %d", data, v, read,
+					err, expected, n)
+			}
+		} else if err == nil {
+			t.Fatalf("%x: invalid varint accepted: %d", data, v)
+		}
+	})
+}
+
+// Returns a decoder over data, its strings table holding one pair.
+func newFuzzDecoder(data []byte) *Decoder {
+	d := NewDecoder(bytes.NewReader(data))
+	d.strings.Push("name", "Paris")
+	return d
+}
+
+// Checks parsing a dataset does not read beyond it when it succeeds.
+func checkFuzzDataset(t *testing.T, d *Decoder, data []byte, err error) {
+	if err == nil && d.Offset() > len(data) {
+		t.Fatalf("%x: read %d bytes out of %d", data, d.Offset(), len(data))
+	}
+}
+
+func FuzzParseNode(f *testing.F) {
+	f.Add(append([]byte{0x0a, 0x00, 0x06, 0x08, 0x00}, "name\x00Paris\x00"...))
+	f.Add([]byte{0x02, 0x00, 0x00, 0x00, 0x01})
+	f.Add([]byte{0x02, 0x01, 0xd0, 0x0f, 0x14, 0x00, 0x07, 0x00, 0x00, 0x00,
+		0x00, 0x00})
+	f.Add([]byte{0x02, 0x01, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := newFuzzDecoder(data)
+		_, err := parseNode(d, len(data), &Node{}, &MetaBase{})
+		checkFuzzDataset(t, d, data, err)
+	})
+}
+
+func FuzzParseWay(f *testing.F) {
+	f.Add([]byte{0x14, 0x00, 0x04, 0x02, 0x06, 0x05, 0x01})
+	f.Add([]byte{0x14, 0x00, 0x00})
+	f.Add([]byte{0x14, 0x00, 0xff, 0xff, 0x03, 0x02})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := newFuzzDecoder(data)
+		_, _, err := parseWay(d, len(data), &Way{}, &MetaBase{}, 0)
+		checkFuzzDataset(t, d, data, err)
+	})
+}
+
+func FuzzParseRelation(f *testing.F) {
+	f.Add(append([]byte{0x0a, 0x00, 0x0a, 0x14, 0x00},
+		"1outer\x00\x01"...))
+	f.Add(append([]byte{0x0a, 0x00, 0x08, 0x14, 0x00},
+		"3bad\x00"...))
+	f.Add([]byte{0x0a, 0x00, 0x02, 0x02, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := newFuzzDecoder(data)
+		_, err := parseRelation(d, len(data), &Relation{}, &MetaBase{},
+			make([]int64, 3))
+		checkFuzzDataset(t, d, data, err)
+	})
+}
@@ -0,0 +1,191 @@
+package o5m
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ulikunitz/xz"
+)
+
+// StdinPath is the input path reading from the standard input.
+const StdinPath = "-"
+
+const inputBufferSize = 1024 * 1024
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+	stdinLock   sync.Mutex
+	stdinOpened bool
+)
+
+// Returns a function wrapping a reader with the decompressor matching head,
+// the first bytes of a file, or nil if the file is not compressed.
+func getDecompressor(head []byte) func(r io.Reader) (io.Reader, error) {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		}
+	case bytes.HasPrefix(head, bzip2Magic):
+		return func(r io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(r), nil
+		}
+	case bytes.HasPrefix(head, xzMagic):
+		return func(r io.Reader) (io.Reader, error) {
+			return xz.NewReader(r)
+		}
+	}
+	return nil
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") ||
+		strings.HasPrefix(path, "https://")
+}
+
+// Opens the raw content of path, a file, a URL or stdin. Stdin can only be
+// opened once since its content cannot be read again.
+func openSource(path string) (io.ReadCloser, error) {
+	if path == StdinPath {
+		stdinLock.Lock()
+		defer stdinLock.Unlock()
+		if stdinOpened {
+			return nil, fmt.Errorf("stdin can only be read once, " +
+				"save it to a file first")
+		}
+		stdinOpened = true
+		return io.NopCloser(os.Stdin), nil
+	}
+	if isURL(path) {
+		resp, err := http.Get(path)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("cannot fetch %s: %s", path, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	return os.Open(path)
+}
+
+// Input reads a file, stdin or a URL download, decompressing gzip, bzip2 and
+// xz content on the fly, detected by their magic bytes. Only uncompressed
+// files seek efficiently. Other inputs seek backward by decompressing or
+// downloading their content again from the start, and stdin cannot seek
+// backward at all.
+type Input struct {
+	path   string
+	src    io.ReadCloser
+	fp     *os.File // src when it is a seekable plain file
+	r      *bufio.Reader
+	offset int64
+}
+
+// OpenInput opens path, which can be a file, StdinPath or a http(s) URL.
+func OpenInput(path string) (*Input, error) {
+	in := &Input{path: path}
+	if err := in.open(); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (in *Input) open() error {
+	src, err := openSource(in.path)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReaderSize(src, inputBufferSize)
+	// Short files are reported by the first read
+	head, _ := r.Peek(len(xzMagic))
+	decompress := getDecompressor(head)
+	if decompress != nil {
+		d, err := decompress(r)
+		if err != nil {
+			src.Close()
+			return fmt.Errorf("cannot decompress %s: %s", in.path, err)
+		}
+		r = bufio.NewReaderSize(d, inputBufferSize)
+	}
+	in.src = src
+	in.fp = nil
+	if fp, ok := src.(*os.File); ok && decompress == nil {
+		in.fp = fp
+	}
+	in.r = r
+	in.offset = 0
+	return nil
+}
+
+// Path returns the path the input was opened with.
+func (in *Input) Path() string {
+	return in.path
+}
+
+// Peek returns the next n bytes without consuming them.
+func (in *Input) Peek(n int) ([]byte, error) {
+	return in.r.Peek(n)
+}
+
+func (in *Input) Read(buf []byte) (int, error) {
+	n, err := in.r.Read(buf)
+	in.offset += int64(n)
+	return n, err
+}
+
+// Seek moves to an offset of the decompressed content. io.SeekEnd is not
+// supported.
+func (in *Input) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += in.offset
+	default:
+		return in.offset, fmt.Errorf("unsupported seek whence: %d", whence)
+	}
+	if offset < 0 {
+		return in.offset, fmt.Errorf("negative seek offset: %d", offset)
+	}
+	if in.fp != nil {
+		_, err := in.fp.Seek(offset, io.SeekStart)
+		if err != nil {
+			return in.offset, err
+		}
+		in.r.Reset(in.fp)
+		in.offset = offset
+		return offset, nil
+	}
+	if offset < in.offset {
+		if in.path == StdinPath {
+			return in.offset, fmt.Errorf("cannot seek backward in stdin, " +
+				"save it to a file first")
+		}
+		in.src.Close()
+		if err := in.open(); err != nil {
+			return in.offset, err
+		}
+	}
+	n, err := io.CopyN(io.Discard, in.r, offset-in.offset)
+	in.offset += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return in.offset, err
+}
+
+func (in *Input) Close() error {
+	return in.src.Close()
+}
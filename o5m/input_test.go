@@ -0,0 +1,115 @@
+package o5m
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestOpenInputGzip(t *testing.T) {
+	data := []byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2', 0xff}
+	data = append(data, byte(NodeKind), 0x04, 0x0a, 0x00, 0x06, 0x08)
+	data = append(data, 0xff, byte(WayKind), 0x04, 0x0e, 0x00, 0x01, 0x02)
+	data = append(data, byte(EndKind))
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	path := writeTempFile(t, buf.Bytes())
+	defer os.Remove(path)
+
+	fp, err := OpenInput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	read, err := io.ReadAll(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("unexpected decompressed data: %x", read)
+	}
+	if _, err := fp.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(fp, head); err != nil || string(head) != "o5m2" {
+		t.Fatalf("unexpected data after seek: %q, %v", head, err)
+	}
+
+	// Seeking backward decompresses the file again
+	r, err := NewReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	kinds := []int{}
+	for r.Next() {
+		kinds = append(kinds, r.Kind())
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if err := r.SeekToKind(NodeKind); err != nil {
+		t.Fatal(err)
+	}
+	for r.Next() {
+		if r.Kind() == NodeKind {
+			break
+		}
+	}
+	if r.Kind() != NodeKind || r.Node().Id != 5 {
+		t.Fatalf("could not read node after seeking: %v, %v", r.Kind(), r.Err())
+	}
+	if len(kinds) != 4 || kinds[3] != WayKind {
+		t.Fatalf("unexpected kinds: %v", kinds)
+	}
+}
+
+func TestOpenInputURL(t *testing.T) {
+	data := []byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2', 0xff}
+	data = append(data, byte(NodeKind), 0x04, 0x0a, 0x00, 0x06, 0x08)
+	data = append(data, byte(EndKind))
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/test.o5m" {
+				http.NotFound(w, req)
+				return
+			}
+			requests++
+			w.Write(data)
+		}))
+	defer server.Close()
+
+	r, err := NewReader(server.URL + "/test.o5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	// Seeking backward downloads the file again
+	if err := r.Seek(ResetPoint{Offset: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Next() || r.Kind() != ResetKind || !r.Next() ||
+		r.Kind() != NodeKind || r.Node().Id != 5 {
+		t.Fatalf("could not read node after seeking: %v", r.Err())
+	}
+	if requests != 2 {
+		t.Fatalf("unexpected requests count: %d", requests)
+	}
+
+	if _, err := OpenInput(server.URL + "/missing.o5m"); err == nil {
+		t.Fatalf("missing URL was opened")
+	}
+}
@@ -0,0 +1,129 @@
+// Package o5m reads OpenStreetMap files in o5m format, and o5c change files
+// which add delete markers to the same encoding. See
+// https://wiki.openstreetmap.org/wiki/O5m for the format description.
+package o5m
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dataset kinds. Reset points mark the start of a section of elements of the
+// same kind, where delta encoding and the strings table start over.
+const (
+	BBoxKind     int = 0xdb
+	NodeKind     int = 0x10
+	WayKind      int = 0x11
+	RelationKind int = 0x12
+	ResetKind    int = 0xff
+	EndKind      int = 0xfe
+)
+
+type BoundingBox struct {
+	X1, Y1, X2, Y2 float64
+}
+
+type StringPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Ref is a relation member. Type is 0 for nodes, 1 for ways and 2 for
+// relations.
+type Ref struct {
+	Id   int64  `json:"id"`
+	Type int    `json:"type"`
+	Role string `json:"role"`
+}
+
+// Metadata Uid holds the user id as stored in o5m files, an unsigned varint,
+// and is empty for anonymous edits.
+type Metadata struct {
+	Version   int    `json:"version"`
+	Timestamp int    `json:"timestamp"`
+	Changeset int    `json:"changeset"`
+	Uid       string `json:"uuid"`
+	Author    string `json:"author"`
+}
+
+// Node coordinates are in 1e-7 degrees.
+type Node struct {
+	Id   int64        `json:"id"`
+	Meta Metadata     `json:"meta"`
+	Lon  int64        `json:"lon"`
+	Lat  int64        `json:"lat"`
+	Tags []StringPair `json:"tags"`
+}
+
+type Way struct {
+	Id    int64
+	Meta  Metadata
+	Nodes []int64
+	Tags  []StringPair
+}
+
+type Relation struct {
+	Id   int64        `json:"id"`
+	Meta Metadata     `json:"meta"`
+	Refs []Ref        `json:"refs"`
+	Tags []StringPair `json:"tags"`
+}
+
+// Tag returns the value of the first tag with key, or an empty string.
+func (r *Relation) Tag(key string) string {
+	for _, tag := range r.Tags {
+		if tag.Key == key {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// Name returns the name tag value, or the value of the only name:xx tag.
+func (r *Relation) Name() string {
+	name := ""
+	names := 0
+	for _, tag := range r.Tags {
+		if tag.Key == "name" {
+			return tag.Value
+		}
+		if strings.HasPrefix(tag.Key, "name:") {
+			names++
+			if names > 1 {
+				return ""
+			}
+			name = tag.Value
+		}
+	}
+	return name
+}
+
+func (r *Relation) AdminLevel() string {
+	return r.Tag("admin_level")
+}
+
+func (r *Relation) String() string {
+	return fmt.Sprintf("%s(%d)[level=%s]", r.Name(), r.Id, r.AdminLevel())
+}
+
+func (r *Relation) Clone() *Relation {
+	refs := make([]Ref, len(r.Refs))
+	copy(refs, r.Refs)
+	tags := make([]StringPair, len(r.Tags))
+	copy(tags, r.Tags)
+	return &Relation{
+		Id:   r.Id,
+		Meta: r.Meta,
+		Refs: refs,
+		Tags: tags,
+	}
+}
+
+// ResetPoint marks the start of a section of elements of the same kind. PBF
+// sections may start in the middle of a block, Index is the position of the
+// first element in the block at Offset.
+type ResetPoint struct {
+	Offset  int
+	Index   int
+	Section int
+}
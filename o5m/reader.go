@@ -0,0 +1,488 @@
+package o5m
+
+import (
+	"fmt"
+)
+
+func parseBoundingBox(r *Decoder) (BoundingBox, error) {
+	bb := BoundingBox{}
+	box := make([]int64, 4)
+	for i := range box {
+		box[i] = r.ReadSigned()
+	}
+	bb.X1 = float64(box[0]) / 1e7
+	bb.Y1 = float64(box[1]) / 1e7
+	bb.X2 = float64(box[2]) / 1e7
+	bb.Y2 = float64(box[3]) / 1e7
+	return bb, r.Err()
+}
+
+// MetaBase holds the delta encoding bases of metadata fields. Like
+// osmconvert, elements without version leave them unchanged, as do elements
+// with a zero timestamp for the changeset. Only reset markers clear them.
+type MetaBase struct {
+	Timestamp int
+	Changeset int
+}
+
+// Decodes the metadata of an element into meta, using and updating base.
+// Fields absent from the element are zeroed.
+func parseMeta(r *Decoder, base *MetaBase, meta *Metadata) {
+	*meta = Metadata{}
+	version := r.ReadUnsigned()
+	if version == 0 {
+		return
+	}
+	meta.Version = int(version)
+	base.Timestamp += int(r.ReadSigned())
+	meta.Timestamp = base.Timestamp
+	if meta.Timestamp != 0 {
+		base.Changeset += int(r.ReadSigned())
+		meta.Changeset = base.Changeset
+		meta.Uid, meta.Author = r.ReadStrings()
+	}
+}
+
+func parseTags(r *Decoder, length int, tags []StringPair) ([]StringPair, error) {
+	for length > 0 {
+		start := r.Offset()
+		k, v := r.ReadStrings()
+		if r.Err() != nil {
+			return nil, fmt.Errorf("could not parse tag: %s", r.Err())
+		}
+		tags = append(tags, StringPair{
+			Key:   k,
+			Value: v,
+		})
+		length -= (r.Offset() - start)
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("overread")
+	}
+	return tags, nil
+}
+
+// Reads the length of way nodes or relation members, which must fit in the
+// remaining bytes of the dataset.
+func readSectionLength(r *Decoder, remaining int) (int, error) {
+	l := r.ReadUnsigned()
+	if r.Err() != nil {
+		return 0, r.Err()
+	}
+	if remaining < 0 || l > uint64(remaining) {
+		return 0, fmt.Errorf("section length exceeds dataset: %d > %d", l,
+			remaining)
+	}
+	return int(l), nil
+}
+
+// Delete markers are datasets ending right after the element metadata.
+func isDeleteMarker(r *Decoder, offset, length int) bool {
+	return r.Err() == nil && r.Offset()-offset == length
+}
+
+// Returns true if the node is a delete marker, in which case only its id and
+// metadata are set.
+func parseNode(r *Decoder, length int, prev *Node, meta *MetaBase) (
+	bool, error) {
+
+	offset := r.Offset()
+	prev.Id += r.ReadSigned()
+	prev.Tags = prev.Tags[:0]
+	parseMeta(r, meta, &prev.Meta)
+	if isDeleteMarker(r, offset, length) {
+		return true, nil
+	}
+	// Longitude delta encoding is applied using 32-bit signed arithmetic.
+	prev.Lon = int64(int32(prev.Lon) + int32(r.ReadSigned()))
+	prev.Lat += r.ReadSigned()
+	remaining := length - (r.Offset() - offset)
+	tags, err := parseTags(r, remaining, prev.Tags)
+	if err != nil {
+		return false, err
+	}
+	prev.Tags = tags
+	return false, r.Err()
+}
+
+func parseWay(r *Decoder, length int, prev *Way, meta *MetaBase,
+	nodeId int64) (int64, bool, error) {
+
+	offset := r.Offset()
+	prev.Id += r.ReadSigned()
+	prev.Nodes = prev.Nodes[:0]
+	prev.Tags = prev.Tags[:0]
+	parseMeta(r, meta, &prev.Meta)
+	if isDeleteMarker(r, offset, length) {
+		return nodeId, true, nil
+	}
+
+	nodesLength, err := readSectionLength(r, length-(r.Offset()-offset))
+	if err != nil {
+		return 0, false, err
+	}
+	for nodesLength > 0 {
+		start := r.Offset()
+		deltaId := r.ReadSigned()
+		if r.Err() != nil {
+			return 0, false, fmt.Errorf("could not parse node id: %s", r.Err())
+		}
+		nodeId += deltaId
+		prev.Nodes = append(prev.Nodes, nodeId)
+		end := r.Offset()
+		nodesLength -= (end - start)
+	}
+	if nodesLength < 0 {
+		return 0, false, fmt.Errorf("overread")
+	}
+	remaining := length - (r.Offset() - offset)
+	tags, err := parseTags(r, remaining, prev.Tags)
+	if err != nil {
+		return 0, false, err
+	}
+	prev.Tags = tags
+	return nodeId, false, r.Err()
+}
+
+func parseRelation(r *Decoder, length int, prev *Relation, meta *MetaBase,
+	refIds []int64) (bool, error) {
+
+	offset := r.Offset()
+	prev.Id += r.ReadSigned()
+	prev.Refs = prev.Refs[:0]
+	prev.Tags = prev.Tags[:0]
+	parseMeta(r, meta, &prev.Meta)
+	if isDeleteMarker(r, offset, length) {
+		return true, nil
+	}
+	refLength, err := readSectionLength(r, length-(r.Offset()-offset))
+	if err != nil {
+		return false, err
+	}
+	for refLength > 0 {
+		start := r.Offset()
+		deltaId := r.ReadSigned()
+		s := r.ReadString()
+		if r.Err() != nil {
+			return false, fmt.Errorf("could not parse reference: %s", r.Err())
+		}
+		if len(s) < 1 {
+			return false, fmt.Errorf("invalid ref string: %s", s)
+		}
+		typ := -1
+		switch s[:1] {
+		case "0":
+			typ = 0
+		case "1":
+			typ = 1
+		case "2":
+			typ = 2
+		}
+		if typ < 0 {
+			return false, fmt.Errorf("invalid reference type: %s", s)
+		}
+		refIds[typ] += deltaId
+		prev.Refs = append(prev.Refs, Ref{
+			Id:   refIds[typ],
+			Type: typ,
+			Role: s[1:],
+		})
+		end := r.Offset()
+		refLength -= (end - start)
+	}
+	if refLength < 0 {
+		return false, fmt.Errorf("overread")
+	}
+	remaining := length - (r.Offset() - offset)
+	tags, err := parseTags(r, remaining, prev.Tags)
+	if err != nil {
+		return false, err
+	}
+	prev.Tags = tags
+	return false, r.Err()
+}
+
+// Reader decodes o5m and o5c files. Elements returned by Node, Way and
+// Relation are only valid until the next call to Next.
+type Reader struct {
+	fp           *Input
+	kinds        map[int]ResetPoint
+	r            *Decoder
+	err          error
+	kind         int
+	ignoredKinds []bool
+	change       bool
+	deleted      bool
+
+	resetPoint  ResetPoint
+	boundingBox *BoundingBox
+	node        Node
+	way         Way
+	nodeId      int64
+	relation    Relation
+	refIds      []int64
+	// Metadata delta encoding bases by kind
+	nodeMeta     MetaBase
+	wayMeta      MetaBase
+	relationMeta MetaBase
+}
+
+// MakeIgnoredKinds returns a slice indexed by kind telling which kinds of
+// elements are skipped without being decoded.
+func MakeIgnoredKinds(ignoredKind []int) ([]bool, error) {
+	ignoredKinds := make([]bool, RelationKind+1)
+	for _, k := range ignoredKind {
+		if k < NodeKind || k >= len(ignoredKinds) {
+			return nil, fmt.Errorf("invalid ignored kind: %d", k)
+		}
+		ignoredKinds[k] = true
+	}
+	return ignoredKinds, nil
+}
+
+// NewReader opens path, elements of ignoredKind are skipped without being
+// decoded. See OpenInput for supported paths.
+func NewReader(path string, ignoredKind ...int) (*Reader, error) {
+	fp, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewInputReader(fp, ignoredKind...)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewInputReader reads fp, which is closed with the reader.
+func NewInputReader(fp *Input, ignoredKind ...int) (*Reader, error) {
+	ignoredKinds, err := MakeIgnoredKinds(ignoredKind)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{
+		fp:           fp,
+		r:            NewDecoder(fp),
+		ignoredKinds: ignoredKinds,
+	}
+	r.change, err = ParseHeader(r.r)
+	if err != nil {
+		return nil, err
+	}
+	r.reset()
+	return r, nil
+}
+
+func (r *Reader) Close() error {
+	return r.fp.Close()
+}
+
+func (r *Reader) reset() {
+	r.node = Node{}
+	r.way = Way{}
+	r.nodeId = 0
+	r.relation = Relation{}
+	r.r.Reset()
+	r.refIds = make([]int64, 3)
+	r.nodeMeta = MetaBase{}
+	r.wayMeta = MetaBase{}
+	r.relationMeta = MetaBase{}
+}
+
+func (r *Reader) Next() bool {
+	for {
+		k := r.r.Byte()
+		if r.r.Err() != nil {
+			r.err = fmt.Errorf("cannot read dataset header: %s", r.r.Err())
+			return false
+		}
+		kind := int(k)
+		r.kind = kind
+		if kind == ResetKind {
+			r.reset()
+			r.resetPoint.Offset = r.r.Offset() - 1
+			return true
+		}
+		if kind == EndKind {
+			return false
+		}
+		l := r.r.ReadUnsigned()
+		if r.r.Err() != nil {
+			r.err = r.r.Err()
+			return false
+		}
+		if l > MaxDatasetLength {
+			r.err = fmt.Errorf("dataset too long: %d", l)
+			return false
+		}
+		length := int(l)
+		start := r.r.Offset()
+		r.deleted = false
+		if kind < len(r.ignoredKinds) && r.ignoredKinds[kind] {
+			_, err := r.r.Discard(length)
+			if err != nil {
+				r.err = err
+				return false
+			}
+		} else {
+			switch kind {
+			case NodeKind:
+				deleted, err := parseNode(r.r, length, &r.node, &r.nodeMeta)
+				if err != nil {
+					r.err = err
+					return false
+				}
+				r.deleted = deleted
+			case WayKind:
+				nodeId, deleted, err := parseWay(r.r, length, &r.way, &r.wayMeta,
+					r.nodeId)
+				if err != nil {
+					r.err = err
+					return false
+				}
+				r.nodeId = nodeId
+				r.deleted = deleted
+			case RelationKind:
+				deleted, err := parseRelation(r.r, length, &r.relation,
+					&r.relationMeta, r.refIds)
+				r.deleted = deleted
+				if err != nil {
+					r.err = err
+					return false
+				}
+			case BBoxKind:
+				bb, err := parseBoundingBox(r.r)
+				if err != nil {
+					r.err = err
+					return false
+				}
+				r.boundingBox = &bb
+			default:
+				r.err = fmt.Errorf("unsupported dataset: %x", kind)
+				return false
+			}
+		}
+		end := r.r.Offset()
+		if (end - start) != length {
+			r.err = fmt.Errorf("section length and read data mismatch: %d != %d",
+				length, (end - start))
+			return false
+		}
+		return true
+	}
+}
+
+func (r *Reader) Seek(target ResetPoint) error {
+	_, err := r.fp.Seek(int64(target.Offset), 0)
+	if err != nil {
+		return err
+	}
+	r.r = NewDecoderAt(r.fp, target.Offset)
+	r.reset()
+	return nil
+}
+
+// SeekToKind moves the reader to the reset point preceding the first element
+// of kind. The file is indexed on first call.
+func (r *Reader) SeekToKind(kind int) error {
+	if r.kinds == nil {
+		kinds, err := indexKinds(r.fp.Path())
+		if err != nil {
+			return err
+		}
+		r.kinds = kinds
+	}
+	p, ok := r.kinds[kind]
+	if !ok {
+		return fmt.Errorf("no element of kind %x", kind)
+	}
+	return r.Seek(p)
+}
+
+// Scans path without decoding elements and returns the last reset point
+// preceding the first element of each kind.
+func indexKinds(path string) (map[int]ResetPoint, error) {
+	r, err := NewReader(path, NodeKind, WayKind, RelationKind)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	kinds := map[int]ResetPoint{}
+	var last *ResetPoint
+	for r.Next() {
+		kind := r.Kind()
+		switch kind {
+		case ResetKind:
+			p := r.ResetPoint()
+			last = &p
+		case NodeKind, WayKind, RelationKind:
+			if _, ok := kinds[kind]; ok {
+				continue
+			}
+			if last == nil {
+				return nil, fmt.Errorf("element of kind %x found before first reset",
+					kind)
+			}
+			kinds[kind] = *last
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return kinds, nil
+}
+
+// Deleted reports whether the current element is a delete marker, which
+// only o5c files contain. Delete markers only have their id and metadata set.
+func (r *Reader) Deleted() bool {
+	return r.deleted
+}
+
+// IsChange returns true if the file is an o5c change file.
+func (r *Reader) IsChange() bool {
+	return r.change
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
+
+func (r *Reader) Kind() int {
+	return r.kind
+}
+
+func (r *Reader) ResetPoint() ResetPoint {
+	if r.kind != ResetKind {
+		panic("not a reset point")
+	}
+	return r.resetPoint
+}
+
+func (r *Reader) BoundingBox() BoundingBox {
+	if r.kind != BBoxKind {
+		panic("not a bounding box")
+	}
+	return *r.boundingBox
+}
+
+func (r *Reader) Node() *Node {
+	if r.kind != NodeKind {
+		panic("not a node")
+	}
+	return &r.node
+}
+
+func (r *Reader) Way() *Way {
+	if r.kind != WayKind {
+		panic("not a way")
+	}
+	return &r.way
+}
+
+func (r *Reader) Relation() *Relation {
+	if r.kind != RelationKind {
+		panic("not a relation")
+	}
+	return &r.relation
+}
@@ -0,0 +1,261 @@
+package o5m
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, data []byte) string {
+	fp, err := ioutil.TempFile("", "o5m-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	if _, err := fp.Write(data); err != nil {
+		os.Remove(fp.Name())
+		t.Fatal(err)
+	}
+	return fp.Name()
+}
+
+func TestReader(t *testing.T) {
+	data := []byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2', 0xff}
+	// Node 5 at (3, 4) with a name tag, then node 6 at the same location
+	// referencing the same tag from the strings table.
+	node := append([]byte{0x0a, 0x00, 0x06, 0x08, 0x00}, "name\x00Paris\x00"...)
+	data = append(data, byte(NodeKind), byte(len(node)))
+	data = append(data, node...)
+	data = append(data, byte(NodeKind), 0x05, 0x02, 0x00, 0x00, 0x00, 0x01)
+	data = append(data, byte(EndKind))
+	path := writeTempFile(t, data)
+	defer os.Remove(path)
+
+	r, err := NewReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.IsChange() {
+		t.Fatalf("o5m file reported as change file")
+	}
+	nodes := []Node{}
+	for r.Next() {
+		if r.Kind() == NodeKind {
+			n := *r.Node()
+			n.Tags = append([]StringPair{}, n.Tags...)
+			nodes = append(nodes, n)
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("unexpected nodes: %+v", nodes)
+	}
+	for i, n := range nodes {
+		if n.Id != int64(5+i) || n.Lon != 3 || n.Lat != 4 || len(n.Tags) != 1 ||
+			n.Tags[0].Key != "name" || n.Tags[0].Value != "Paris" {
+			t.Fatalf("unexpected node: %+v", n)
+		}
+	}
+}
+
+// Nodes with interleaved metadata, hand encoded from the o5m format
+// description: elements without version and elements with a zero timestamp
+// leave the delta bases of the following ones unchanged.
+func TestReaderMetadataDeltaBytes(t *testing.T) {
+	data := []byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2', 0xff}
+	datasets := [][]byte{
+		// Version 1, timestamp 1000, changeset 10, uid 7 and alice
+		append([]byte{0x02, 0x01, 0xd0, 0x0f, 0x14, 0x00, 0x07, 0x00},
+			"alice\x00\x00\x00"...),
+		// No version
+		{0x02, 0x00, 0x00, 0x00},
+		// Version 2, timestamp +5, changeset +1, author from the strings
+		// table
+		{0x02, 0x02, 0x0a, 0x02, 0x01, 0x00, 0x00},
+		// Version 1, timestamp -1005, no author
+		{0x02, 0x01, 0xd9, 0x0f, 0x00, 0x00},
+		// Version 1, timestamp +2000, changeset +1, same author
+		{0x02, 0x01, 0xa0, 0x1f, 0x02, 0x01, 0x00, 0x00},
+	}
+	for _, ds := range datasets {
+		data = append(data, byte(NodeKind), byte(len(ds)))
+		data = append(data, ds...)
+	}
+	data = append(data, byte(EndKind))
+	path := writeTempFile(t, data)
+	defer os.Remove(path)
+
+	r, err := NewReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	metas := []Metadata{}
+	for r.Next() {
+		if r.Kind() == NodeKind {
+			metas = append(metas, r.Node().Meta)
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	alice := func(version, timestamp, changeset int) Metadata {
+		return Metadata{Version: version, Timestamp: timestamp,
+			Changeset: changeset, Uid: "\x07", Author: "alice"}
+	}
+	expected := []Metadata{
+		alice(1, 1000, 10),
+		{},
+		alice(2, 1005, 11),
+		{Version: 1},
+		alice(1, 2000, 12),
+	}
+	if !reflect.DeepEqual(metas, expected) {
+		t.Fatalf("unexpected metadata:\n%+v\n!=\n%+v", metas, expected)
+	}
+}
+
+// Opens an osmconvert fixture from testdata, generated by testdata/gen.sh.
+func openFixture(t *testing.T, name string) *Reader {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		t.Fatalf("%s is missing, generate it with testdata/gen.sh", path)
+	}
+	r, err := NewReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReaderMetadataDeltas(t *testing.T) {
+	r := openFixture(t, "metadata.o5m")
+	defer r.Close()
+	metas := map[int64]Metadata{}
+	for r.Next() {
+		switch r.Kind() {
+		case NodeKind:
+			metas[r.Node().Id] = r.Node().Meta
+		case WayKind:
+			metas[r.Way().Id] = r.Way().Meta
+		case RelationKind:
+			metas[r.Relation().Id] = r.Relation().Meta
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	meta := func(version, timestamp, changeset int, uid uint64,
+		author string) Metadata {
+		return Metadata{Version: version, Timestamp: timestamp,
+			Changeset: changeset, Uid: string(AppendUnsigned(nil, uid)),
+			Author: author}
+	}
+	// Values of testdata/metadata.osm
+	expected := map[int64]Metadata{
+		1: meta(1, 1577836800, 1000, 7, "alice"),
+		// No version
+		2: {},
+		// Author from the strings table
+		3: meta(2, 1577836805, 1001, 7, "alice"),
+		// No timestamp
+		4: {Version: 1},
+		// Negative changeset delta
+		5: meta(3, 1623760200, 900, 1234, "bob"),
+		// Negative timestamp delta
+		6: meta(1, 1577836799, 1002, 7, "alice"),
+		// Deltas restart with each section
+		10: meta(1, 1577836810, 1000, 7, "alice"),
+		20: meta(4, 1646121600, 1003, 1234, "bob"),
+	}
+	if !reflect.DeepEqual(metas, expected) {
+		t.Fatalf("unexpected metadata:\n%+v\n!=\n%+v", metas, expected)
+	}
+}
+
+func TestReaderInvalidDatasets(t *testing.T) {
+	header := []byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2', 0xff}
+	for _, ds := range [][]byte{
+		// Absurd dataset length
+		{byte(NodeKind), 0xff, 0xff, 0xff, 0xff, 0x0f, 0x02},
+		// Truncated dataset
+		{byte(NodeKind), 0x05, 0x02, 0x00},
+		// Way nodes exceeding the dataset
+		{byte(WayKind), 0x04, 0x02, 0x00, 0x7f, 0x02},
+		// Overlong varint
+		{byte(NodeKind), 0x0c, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			0xff, 0xff, 0x01, 0x00},
+		// String reference beyond the strings table
+		{byte(NodeKind), 0x07, 0x02, 0x00, 0x00, 0x00, 0x80, 0x80, 0x01},
+	} {
+		path := writeTempFile(t, append(append(header, ds...), byte(EndKind)))
+		defer os.Remove(path)
+		r, err := NewReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for r.Next() {
+		}
+		r.Close()
+		if r.Err() == nil {
+			t.Fatalf("invalid dataset was accepted: %x", ds)
+		}
+	}
+}
+
+func TestNewReaderInvalidHeader(t *testing.T) {
+	path := writeTempFile(t, []byte{0xff, 0xe0, 0x04, 'x', 'x', 'x', 'x'})
+	defer os.Remove(path)
+	if r, err := NewReader(path); err == nil {
+		r.Close()
+		t.Fatalf("invalid header was accepted")
+	}
+}
+
+func TestScanner(t *testing.T) {
+	data := []byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2', 0xff}
+	data = append(data, byte(NodeKind), 0x04, 0x0a, 0x00, 0x06, 0x08)
+	data = append(data, byte(NodeKind), 0x04, 0x02, 0x00, 0x00, 0x00)
+	data = append(data, byte(EndKind))
+	path := writeTempFile(t, data)
+	defer os.Remove(path)
+
+	ids := []int64{}
+	h := HandlerFuncs{
+		Node: func(n *Node) error {
+			ids = append(ids, n.Id)
+			return nil
+		},
+	}
+	if err := Scan(context.Background(), path, h); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 5 || ids[1] != 6 {
+		t.Fatalf("unexpected node ids: %v", ids)
+	}
+
+	// Handler errors stop the scan
+	stop := errors.New("stop")
+	ids = ids[:0]
+	h.Node = func(n *Node) error {
+		ids = append(ids, n.Id)
+		return stop
+	}
+	if err := Scan(context.Background(), path, h); err != stop || len(ids) != 1 {
+		t.Fatalf("scan did not stop: %v, %v", err, ids)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Scan(ctx, path, h); err != context.Canceled {
+		t.Fatalf("cancelled scan returned: %v", err)
+	}
+}
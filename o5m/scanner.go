@@ -0,0 +1,101 @@
+package o5m
+
+import (
+	"context"
+)
+
+// Source is the part of Reader used by Scanner. Other readers returning the
+// same element types, like PBF or XML ones, can be scanned as well.
+type Source interface {
+	Next() bool
+	Err() error
+	Kind() int
+	Node() *Node
+	Way() *Way
+	Relation() *Relation
+}
+
+// Handler receives the elements of a scanned file. Elements are reused once
+// the callback returns, copy them or Clone() relations to keep them.
+// Returning an error stops the scan.
+type Handler interface {
+	HandleNode(n *Node) error
+	HandleWay(w *Way) error
+	HandleRelation(r *Relation) error
+}
+
+// HandlerFuncs implements Handler with optional callbacks. Elements without
+// callback are skipped.
+type HandlerFuncs struct {
+	Node     func(n *Node) error
+	Way      func(w *Way) error
+	Relation func(r *Relation) error
+}
+
+func (h HandlerFuncs) HandleNode(n *Node) error {
+	if h.Node == nil {
+		return nil
+	}
+	return h.Node(n)
+}
+
+func (h HandlerFuncs) HandleWay(w *Way) error {
+	if h.Way == nil {
+		return nil
+	}
+	return h.Way(w)
+}
+
+func (h HandlerFuncs) HandleRelation(r *Relation) error {
+	if h.Relation == nil {
+		return nil
+	}
+	return h.Relation(r)
+}
+
+// Scanner feeds the elements of a Source to a Handler.
+type Scanner struct {
+	r Source
+	h Handler
+}
+
+func NewScanner(r Source, h Handler) *Scanner {
+	return &Scanner{
+		r: r,
+		h: h,
+	}
+}
+
+// Scan reads the remaining elements and passes them to the handler, until
+// the end of the source, a reader or handler error, or ctx cancellation.
+// Reset points and bounding boxes are skipped.
+func (s *Scanner) Scan(ctx context.Context) error {
+	for s.r.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		switch s.r.Kind() {
+		case NodeKind:
+			err = s.h.HandleNode(s.r.Node())
+		case WayKind:
+			err = s.h.HandleWay(s.r.Way())
+		case RelationKind:
+			err = s.h.HandleRelation(s.r.Relation())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.r.Err()
+}
+
+// Scan opens the o5m file at path and passes its elements to h.
+func Scan(ctx context.Context, path string, h Handler) error {
+	r, err := NewReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return NewScanner(r, h).Scan(ctx)
+}
@@ -0,0 +1,45 @@
+#!/bin/sh
+# Regenerates the o5m fixtures with osmconvert, which must be in PATH.
+set -e
+cd "$(dirname "$0")"
+
+osmconvert metadata.osm -o=metadata.o5m
+
+# More than 15000 distinct storable pairs, so the strings table wraps
+# around, pairs too long to be stored, then repeated pairs, expired or
+# still referenceable. Relation roles share the table with pairs.
+awk 'BEGIN {
+	long = ""
+	for (i = 0; i < 251; i++)
+		long = long "x"
+	print "<?xml version=\"1.0\" encoding=\"UTF-8\"?>"
+	print "<osm version=\"0.6\" generator=\"gen.sh\">"
+	for (i = 1; i <= 15100; i++) {
+		printf " <node id=\"%d\" lat=\"%d.%03d\" lon=\"%d.%02d\">\n", \
+			i, i % 10, i % 1000, i % 7, i % 100
+		printf "  <tag k=\"k%d\" v=\"v\"/>\n", i
+		if (i % 1000 == 0)
+			printf "  <tag k=\"%s\" v=\"v\"/>\n", long
+		print " </node>"
+	}
+	split("1 15100 2 15099 1", repeated, " ")
+	for (i = 1; i <= 5; i++) {
+		printf " <node id=\"%d\" lat=\"0\" lon=\"0\">\n", 15100 + i
+		printf "  <tag k=\"k%d\" v=\"v\"/>\n", repeated[i]
+		print " </node>"
+	}
+	print " <way id=\"1\">"
+	print "  <nd ref=\"1\"/>"
+	print "  <nd ref=\"2\"/>"
+	print "  <tag k=\"k15100\" v=\"v\"/>"
+	print " </way>"
+	print " <relation id=\"1\">"
+	print "  <member type=\"way\" ref=\"1\" role=\"outer\"/>"
+	print "  <member type=\"node\" ref=\"1\" role=\"label\"/>"
+	print "  <member type=\"way\" ref=\"1\" role=\"outer\"/>"
+	print "  <tag k=\"k1\" v=\"v\"/>"
+	print " </relation>"
+	print "</osm>"
+}' > strings.osm
+osmconvert strings.osm -o=strings.o5m
+rm strings.osm
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// globTerm matches tag values against a pattern with leading and trailing
+// "*" wildcards, like osmfilter "name=*Paris*".
+type globTerm struct {
+	Key     string
+	Pattern string
+	Negate  bool
+}
+
+func matchGlob(pattern, s string) bool {
+	prefix := strings.HasPrefix(pattern, "*")
+	suffix := len(pattern) > 1 && strings.HasSuffix(pattern, "*")
+	p := strings.TrimSuffix(strings.TrimPrefix(pattern, "*"), "*")
+	switch {
+	case prefix && suffix:
+		return strings.Contains(s, p)
+	case prefix:
+		return strings.HasSuffix(s, p)
+	case suffix:
+		return strings.HasPrefix(s, p)
+	}
+	return s == p
+}
+
+func (t *globTerm) Match(tags []StringPair) bool {
+	v, ok := findTag(tags, t.Key)
+	if t.Negate {
+		return !ok || !matchGlob(t.Pattern, v)
+	}
+	return ok && matchGlob(t.Pattern, v)
+}
+
+func (t *globTerm) String() string {
+	if t.Negate {
+		return t.Key + "!=" + t.Pattern
+	}
+	return t.Key + "=" + t.Pattern
+}
+
+// Parses an osmfilter term. A term without key, like "=secondary" in
+// "highway=primary =secondary", applies to the previous term key. A term
+// without value, like "boundary=", matches any value.
+func parseOsmFilterTerm(s, prevKey string) (TagExpr, string, error) {
+	for _, op := range tagOperators {
+		pos := strings.Index(s, op)
+		if pos < 0 {
+			continue
+		}
+		key := s[:pos]
+		if key == "" {
+			if prevKey == "" {
+				return nil, "", fmt.Errorf("missing key in %q", s)
+			}
+			key = prevKey
+		}
+		value := s[pos+len(op):]
+		if value == "" && (op == "=" || op == "!=") {
+			// "boundary=" matches any boundary value
+			value = "*"
+		}
+		if (op == "=" || op == "!=") && value != "*" &&
+			strings.Contains(value, "*") {
+			return &globTerm{
+				Key:     key,
+				Pattern: value,
+				Negate:  op == "!=",
+			}, key, nil
+		}
+		t, err := parseTagTerm(key + op + value)
+		return t, key, err
+	}
+	// A bare key matches any value
+	return &tagTerm{Key: s, Op: "=", Value: "*"}, s, nil
+}
+
+// Parses an osmfilter expression. Terms separated by "or" or only by spaces
+// are alternatives, "and" has precedence over "or". osmfilter does not support
+// parentheses and neither does this function.
+func parseOsmFilterExpr(s string) (TagExpr, error) {
+	var result, current TagExpr
+	pendingAnd := false
+	// True after an operator, until the next term
+	pendingTerm := false
+	prevKey := ""
+	for _, token := range strings.Fields(s) {
+		switch strings.ToLower(token) {
+		case "and", "or":
+			if current == nil || pendingTerm {
+				return nil, fmt.Errorf("unexpected token: %s", token)
+			}
+			pendingAnd = strings.ToLower(token) == "and"
+			pendingTerm = true
+			continue
+		}
+		term, key, err := parseOsmFilterTerm(token, prevKey)
+		if err != nil {
+			return nil, err
+		}
+		prevKey = key
+		pendingTerm = false
+		if pendingAnd {
+			current = &andExpr{current, term}
+			pendingAnd = false
+			continue
+		}
+		if current != nil {
+			if result == nil {
+				result = current
+			} else {
+				result = &orExpr{result, current}
+			}
+		}
+		current = term
+	}
+	if pendingTerm {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if current == nil {
+		return nil, nil
+	}
+	if result == nil {
+		return current, nil
+	}
+	return &orExpr{result, current}, nil
+}
+
+// Builds the expression equivalent to osmfilter --keep and --drop options:
+// elements matching keep, or everything when keep is empty, and not matching
+// drop.
+func ParseOsmFilter(keep, drop string) (TagExpr, error) {
+	keepExpr, err := parseOsmFilterExpr(keep)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keep expression %q: %s", keep, err)
+	}
+	dropExpr, err := parseOsmFilterExpr(drop)
+	if err != nil {
+		return nil, fmt.Errorf("invalid drop expression %q: %s", drop, err)
+	}
+	if dropExpr == nil {
+		return keepExpr, nil
+	}
+	if keepExpr == nil {
+		return &notExpr{dropExpr}, nil
+	}
+	return &andExpr{keepExpr, &notExpr{dropExpr}}, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseOsmFilter(t *testing.T) {
+	tags := []StringPair{
+		{Key: "boundary", Value: "administrative"},
+		{Key: "admin_level", Value: "8"},
+		{Key: "name", Value: "Saint-Martin-d'Hères"},
+	}
+	tests := []struct {
+		Keep  string
+		Drop  string
+		Match bool
+	}{
+		{"boundary=administrative", "", true},
+		{"boundary=postal_code =administrative", "", true},
+		{"boundary=postal_code =political", "", false},
+		{"boundary=postal_code or admin_level=8", "", true},
+		{"boundary=administrative and admin_level<=6", "", false},
+		{"boundary=administrative and admin_level>=6", "", true},
+		{"place=city boundary=administrative and admin_level=8", "", true},
+		{"name=Saint-*", "", true},
+		{"name=*Hères", "", true},
+		{"name=*Martin*", "", true},
+		{"name=*Paris*", "", false},
+		{"name!=*Paris*", "", true},
+		{"name", "", true},
+		{"place=", "", false},
+		{"boundary=", "", true},
+		{"place=country =state boundary=", "", true},
+		{"", "admin_level=8", false},
+		{"", "admin_level=2", true},
+		{"boundary=administrative", "name=*Martin*", false},
+	}
+	for _, test := range tests {
+		e, err := ParseOsmFilter(test.Keep, test.Drop)
+		if err != nil {
+			t.Fatalf("%q/%q: %s", test.Keep, test.Drop, err)
+		}
+		if e.Match(tags) != test.Match {
+			t.Fatalf("%q/%q: expected %v, parsed as %s", test.Keep, test.Drop,
+				test.Match, e)
+		}
+	}
+	for _, invalid := range []string{"a=b and", "and a=b", "=b", "a=b or and c",
+		"a<b"} {
+		if _, err := ParseOsmFilter(invalid, ""); err == nil {
+			t.Fatalf("invalid expression accepted: %q", invalid)
+		}
+	}
+	e, err := ParseOsmFilter("", "")
+	if err != nil || e != nil {
+		t.Fatalf("empty filter should be nil: %v, %v", e, err)
+	}
+}
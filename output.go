@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressAuto = "auto"
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+var (
+	CompressionValues = []string{
+		CompressAuto,
+		CompressNone,
+		CompressGzip,
+		CompressZstd,
+	}
+)
+
+// Returns the compression to apply on path. "auto" is resolved using the
+// file extension.
+func resolveCompression(path, compression string) (string, error) {
+	switch compression {
+	case CompressNone, CompressGzip, CompressZstd:
+		return compression, nil
+	case CompressAuto, "":
+		ext := strings.ToLower(filepath.Ext(path))
+		switch ext {
+		case ".gz":
+			return CompressGzip, nil
+		case ".zst", ".zstd":
+			return CompressZstd, nil
+		}
+		return CompressNone, nil
+	}
+	return "", fmt.Errorf("unknown compression: %s", compression)
+}
+
+// OutputFile is a buffered, optionally compressed, output file. Data is
+// written in a temporary file in the target directory and only renamed into
+// its final path by Commit(). Failed or interrupted runs therefore never
+// leave a partial output behind under the expected name.
+type OutputFile struct {
+	path    string
+	tmpPath string
+	fp      *os.File
+	buf     *bufio.Writer
+	comp    io.WriteCloser
+	w       io.Writer
+	done    bool
+}
+
+func CreateOutputFile(path, compression string) (*OutputFile, error) {
+	compression, err := resolveCompression(path, compression)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	f := &OutputFile{
+		path:    path,
+		tmpPath: fp.Name(),
+		fp:      fp,
+		buf:     bufio.NewWriterSize(fp, 1<<20),
+	}
+	f.w = f.buf
+	switch compression {
+	case CompressGzip:
+		f.comp = gzip.NewWriter(f.buf)
+	case CompressZstd:
+		f.comp, err = zstd.NewWriter(f.buf)
+		if err != nil {
+			f.Abort()
+			return nil, err
+		}
+	}
+	if f.comp != nil {
+		f.w = f.comp
+	}
+	return f, nil
+}
+
+func (f *OutputFile) Write(data []byte) (int, error) {
+	return f.w.Write(data)
+}
+
+// WriteAt flushes buffered data and overwrites the file at offset off. It is
+// meant to patch headers once the content is known and fails on compressed
+// files.
+func (f *OutputFile) WriteAt(data []byte, off int64) error {
+	if f.comp != nil {
+		return fmt.Errorf("cannot write at offset in compressed file: %s",
+			f.path)
+	}
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	_, err := f.fp.WriteAt(data, off)
+	return err
+}
+
+// Commit flushes and syncs written data, then renames the temporary file
+// into the output path.
+func (f *OutputFile) Commit() error {
+	if f.done {
+		return fmt.Errorf("output file already closed: %s", f.path)
+	}
+	err := func() error {
+		if f.comp != nil {
+			if err := f.comp.Close(); err != nil {
+				return err
+			}
+		}
+		if err := f.buf.Flush(); err != nil {
+			return err
+		}
+		// Temporary files are created with 0600 permissions
+		if err := f.fp.Chmod(0644); err != nil {
+			return err
+		}
+		if err := f.fp.Sync(); err != nil {
+			return err
+		}
+		return f.fp.Close()
+	}()
+	if err != nil {
+		f.Abort()
+		return fmt.Errorf("could not write %s: %s", f.path, err)
+	}
+	f.done = true
+	return os.Rename(f.tmpPath, f.path)
+}
+
+// Abort discards the temporary file. It does nothing if the file was
+// committed, so it can be deferred right after creation.
+func (f *OutputFile) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	f.fp.Close()
+	return os.Remove(f.tmpPath)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Normalizes boundary names for comparison: case, punctuation and spacing
+// differences are ignored, so "Saint-Martin" and "saint martin" are equal.
+func normalizeBoundaryName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	return strings.Join(words, " ")
+}
+
+func (a *AdminArea) bboxIntersects(other *AdminArea) bool {
+	return a.bbox.MinLon <= other.bbox.MaxLon &&
+		other.bbox.MinLon <= a.bbox.MaxLon &&
+		a.bbox.MinLat <= other.bbox.MaxLat &&
+		other.bbox.MinLat <= a.bbox.MaxLat
+}
+
+// SameNameGroup holds boundaries of a country sharing a normalized name and
+// an admin level.
+type SameNameGroup struct {
+	Country string
+	Name    string
+	Level   int
+	Areas   []*AdminArea
+}
+
+// Groups areas by country, normalized name and admin level. Only groups of
+// areas whose extents intersect are returned, sorted by country, name and
+// level. countries maps area ids to their country code.
+func groupSameNameAreas(areas []*AdminArea,
+	countries map[int64]string) []*SameNameGroup {
+
+	type groupKey struct {
+		Country string
+		Name    string
+		Level   int
+	}
+	groups := map[groupKey]*SameNameGroup{}
+	for _, area := range areas {
+		name := normalizeBoundaryName(area.Name)
+		if name == "" || area.bbox == nil {
+			continue
+		}
+		k := groupKey{countries[area.Id], name, area.Level}
+		g := groups[k]
+		if g == nil {
+			g = &SameNameGroup{
+				Country: k.Country,
+				Name:    k.Name,
+				Level:   k.Level,
+			}
+			groups[k] = g
+		}
+		g.Areas = append(g.Areas, area)
+	}
+	result := []*SameNameGroup{}
+	for _, g := range groups {
+		overlapping := map[*AdminArea]bool{}
+		for i, a := range g.Areas {
+			for _, b := range g.Areas[i+1:] {
+				if a.bboxIntersects(b) {
+					overlapping[a] = true
+					overlapping[b] = true
+				}
+			}
+		}
+		if len(overlapping) == 0 {
+			continue
+		}
+		kept := []*AdminArea{}
+		for _, a := range g.Areas {
+			if overlapping[a] {
+				kept = append(kept, a)
+			}
+		}
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].Id < kept[j].Id
+		})
+		g.Areas = kept
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Country != b.Country {
+			return a.Country < b.Country
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Level < b.Level
+	})
+	return result
+}
+
+// Returns the area of the intersection of a and b divided by the smallest of
+// their areas.
+func computeOverlapRatio(a, b *AdminArea) (float64, error) {
+	aPolys, err := makeGeometriesFromLocation(&Location{
+		Type:        "multipolygon",
+		Coordinates: a.Coordinates,
+	})
+	if err != nil {
+		return 0, err
+	}
+	bPolys, err := makeGeometriesFromLocation(&Location{
+		Type:        "multipolygon",
+		Coordinates: b.Coordinates,
+	})
+	if err != nil {
+		return 0, err
+	}
+	sumArea := func(polys []Geometry) (float64, error) {
+		total := 0.
+		for _, p := range polys {
+			area, err := p.Area()
+			if err != nil {
+				return 0, err
+			}
+			total += area
+		}
+		return total, nil
+	}
+	aArea, err := sumArea(aPolys)
+	if err != nil {
+		return 0, err
+	}
+	bArea, err := sumArea(bPolys)
+	if err != nil {
+		return 0, err
+	}
+	common := 0.
+	for _, pa := range aPolys {
+		for _, pb := range bPolys {
+			inter, err := pa.Intersection(pb)
+			if err != nil {
+				return 0, err
+			}
+			area, err := inter.Area()
+			if err != nil {
+				return 0, err
+			}
+			common += area
+		}
+	}
+	smallest := aArea
+	if bArea < smallest {
+		smallest = bArea
+	}
+	if smallest <= 0 {
+		return 0, nil
+	}
+	return common / smallest, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizeBoundaryName(t *testing.T) {
+	tests := [][2]string{
+		{"Saint-Martin", "saint martin"},
+		{"  saint   martin ", "saint martin"},
+		{"Saint-Martin-d'Hères", "saint martin d hères"},
+		{"---", ""},
+	}
+	for _, test := range tests {
+		res := normalizeBoundaryName(test[0])
+		if res != test[1] {
+			t.Fatalf("%q: expected %q, got %q", test[0], test[1], res)
+		}
+	}
+}
+
+func TestGroupSameNameAreas(t *testing.T) {
+	square := func(x0, y0, x1, y1 float64) [][][][]float64 {
+		return [][][][]float64{{
+			{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}, {x0, y0}},
+		}}
+	}
+	idx := NewAdminIndex()
+	areas := []*AdminArea{
+		{Id: 1, Name: "Saint-Martin", Level: 8, Coordinates: square(0, 0, 2, 2)},
+		{Id: 2, Name: "Saint Martin", Level: 8, Coordinates: square(1, 1, 3, 3)},
+		// Same name, far away
+		{Id: 3, Name: "Saint-Martin", Level: 8, Coordinates: square(10, 10, 11, 11)},
+		// Same name, other level
+		{Id: 4, Name: "Saint-Martin", Level: 6, Coordinates: square(0, 0, 2, 2)},
+		// Same name and place, other country
+		{Id: 5, Name: "Saint-Martin", Level: 8, Coordinates: square(0, 0, 2, 2)},
+	}
+	for _, a := range areas {
+		idx.Add(a)
+	}
+	countries := map[int64]string{1: "FR", 2: "FR", 3: "FR", 4: "FR", 5: "MF"}
+	groups := groupSameNameAreas(idx.Areas, countries)
+	if len(groups) != 1 {
+		t.Fatalf("unexpected groups count: %d", len(groups))
+	}
+	g := groups[0]
+	if g.Country != "FR" || g.Level != 8 || len(g.Areas) != 2 ||
+		g.Areas[0].Id != 1 || g.Areas[1].Id != 2 {
+		t.Fatalf("unexpected group: %+v", g)
+	}
+}
@@ -0,0 +1,823 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Pure Go polygon overlay, used by the purego geometry backend.
+//
+// Coordinates are snapped to the OSM fixed-point grid, 1e-7 degree, so
+// predicates can be evaluated exactly on integers. Segments of all input
+// rings are split at their intersections, rounded to the grid, until no two
+// segments cross. Each resulting edge bounds the result if the operation
+// evaluates differently on its sides, side values being derived from winding
+// numbers computed with a ray cast from the edge middle. Kept edges are then
+// linked into rings, the interior on their left.
+
+const (
+	overlayUnion = iota
+	overlayIntersection
+	overlayDifference
+
+	// Splitting segments at rounded intersections can make them cross
+	// others, stop when it does not settle.
+	maxSplitPasses = 16
+)
+
+// ipoint is a point on the OSM fixed-point grid.
+type ipoint struct {
+	X, Y int64
+}
+
+func toIPoint(c Coord) ipoint {
+	return ipoint{
+		X: int64(math.Round(c.X * 1e7)),
+		Y: int64(math.Round(c.Y * 1e7)),
+	}
+}
+
+func (p ipoint) Coord() Coord {
+	return Coord{
+		X: float64(p.X) / 1e7,
+		Y: float64(p.Y) / 1e7,
+	}
+}
+
+// Orders points upward, then rightward. Edges are stored from their lowest
+// point, so they point upward, or rightward when horizontal.
+func ipointLess(a, b ipoint) bool {
+	return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+}
+
+// iring is a ring without its closing point.
+type iring []ipoint
+
+type int128 struct {
+	hi int64
+	lo uint64
+}
+
+func mul128(a, b int64) int128 {
+	neg := (a < 0) != (b < 0)
+	ua, ub := uint64(a), uint64(b)
+	if a < 0 {
+		ua = uint64(-a)
+	}
+	if b < 0 {
+		ub = uint64(-b)
+	}
+	hi, lo := bits.Mul64(ua, ub)
+	if neg {
+		lo = ^lo + 1
+		hi = ^hi
+		if lo == 0 {
+			hi++
+		}
+	}
+	return int128{int64(hi), lo}
+}
+
+func (x int128) cmp(y int128) int {
+	switch {
+	case x.hi < y.hi:
+		return -1
+	case x.hi > y.hi:
+		return 1
+	case x.lo < y.lo:
+		return -1
+	case x.lo > y.lo:
+		return 1
+	}
+	return 0
+}
+
+// Returns the sign of a*b - c*d, computed without overflow.
+func cmpProducts(a, b, c, d int64) int {
+	return mul128(a, b).cmp(mul128(c, d))
+}
+
+// Returns 1 if c is left of (a, b), -1 if it is right of it and 0 if the
+// points are collinear.
+func orient(a, b, c ipoint) int {
+	return cmpProducts(b.X-a.X, c.Y-a.Y, b.Y-a.Y, c.X-a.X)
+}
+
+// Like orient() but c coordinates are doubled, so segment middles can be
+// passed exactly.
+func orientDoubled(a, b, c ipoint) int {
+	return cmpProducts(b.X-a.X, c.Y-2*a.Y, b.Y-a.Y, c.X-2*a.X)
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+type ovSegment struct {
+	a, b   ipoint
+	group  int
+	splits []ipoint
+}
+
+// Returns true if p is in the bounding box of s.
+func (s *ovSegment) boxContains(p ipoint) bool {
+	return minInt64(s.a.X, s.b.X) <= p.X && p.X <= maxInt64(s.a.X, s.b.X) &&
+		minInt64(s.a.Y, s.b.Y) <= p.Y && p.Y <= maxInt64(s.a.Y, s.b.Y)
+}
+
+// Records p as a split point unless it is one of s ends.
+func (s *ovSegment) split(p ipoint) bool {
+	if p == s.a || p == s.b {
+		return false
+	}
+	s.splits = append(s.splits, p)
+	return true
+}
+
+// Returns the crossing point of two properly intersecting segments, rounded
+// to the grid.
+func crossingPoint(s, t *ovSegment) ipoint {
+	dx1, dy1 := float64(s.b.X-s.a.X), float64(s.b.Y-s.a.Y)
+	dx2, dy2 := float64(t.b.X-t.a.X), float64(t.b.Y-t.a.Y)
+	den := dx1*dy2 - dy1*dx2
+	u := (float64(t.a.X-s.a.X)*dy2 - float64(t.a.Y-s.a.Y)*dx2) / den
+	return ipoint{
+		X: s.a.X + int64(math.Round(u*dx1)),
+		Y: s.a.Y + int64(math.Round(u*dy1)),
+	}
+}
+
+// Records where s and t must be split so they only meet at their ends.
+// Returns true if any split was recorded.
+func intersectSegments(s, t *ovSegment) bool {
+	o1 := orient(s.a, s.b, t.a)
+	o2 := orient(s.a, s.b, t.b)
+	if o1*o2 > 0 {
+		return false
+	}
+	o3 := orient(t.a, t.b, s.a)
+	o4 := orient(t.a, t.b, s.b)
+	if o3*o4 > 0 {
+		return false
+	}
+	split := false
+	if o1 != 0 && o2 != 0 && o3 != 0 && o4 != 0 {
+		p := crossingPoint(s, t)
+		if s.split(p) {
+			split = true
+		}
+		if t.split(p) {
+			split = true
+		}
+		return split
+	}
+	// Touching or collinear segments
+	if o1 == 0 && s.boxContains(t.a) && s.split(t.a) {
+		split = true
+	}
+	if o2 == 0 && s.boxContains(t.b) && s.split(t.b) {
+		split = true
+	}
+	if o3 == 0 && t.boxContains(s.a) && t.split(s.a) {
+		split = true
+	}
+	if o4 == 0 && t.boxContains(s.b) && t.split(s.b) {
+		split = true
+	}
+	return split
+}
+
+// Records the split points of all intersecting segments, using a sweep
+// along the x axis. Returns true if any split was recorded.
+func findIntersections(segs []*ovSegment) bool {
+	sort.Slice(segs, func(i, j int) bool {
+		return minInt64(segs[i].a.X, segs[i].b.X) <
+			minInt64(segs[j].a.X, segs[j].b.X)
+	})
+	found := false
+	active := []*ovSegment{}
+	for _, s := range segs {
+		minX := minInt64(s.a.X, s.b.X)
+		minY, maxY := minInt64(s.a.Y, s.b.Y), maxInt64(s.a.Y, s.b.Y)
+		kept := active[:0]
+		for _, t := range active {
+			if maxInt64(t.a.X, t.b.X) < minX {
+				continue
+			}
+			kept = append(kept, t)
+			if maxInt64(t.a.Y, t.b.Y) < minY || minInt64(t.a.Y, t.b.Y) > maxY {
+				continue
+			}
+			if intersectSegments(s, t) {
+				found = true
+			}
+		}
+		active = append(kept, s)
+	}
+	return found
+}
+
+// Replaces segments by their parts between recorded split points.
+func applySplits(segs []*ovSegment) []*ovSegment {
+	result := make([]*ovSegment, 0, len(segs))
+	for _, s := range segs {
+		if len(s.splits) == 0 {
+			result = append(result, s)
+			continue
+		}
+		// Order split points along the segment main axis
+		dx, dy := s.b.X-s.a.X, s.b.Y-s.a.Y
+		key := func(p ipoint) int64 {
+			if maxInt64(dx, -dx) >= maxInt64(dy, -dy) {
+				if dx < 0 {
+					return s.a.X - p.X
+				}
+				return p.X - s.a.X
+			}
+			if dy < 0 {
+				return s.a.Y - p.Y
+			}
+			return p.Y - s.a.Y
+		}
+		sort.Slice(s.splits, func(i, j int) bool {
+			return key(s.splits[i]) < key(s.splits[j])
+		})
+		prev := s.a
+		for _, p := range append(s.splits, s.b) {
+			if p == prev {
+				continue
+			}
+			result = append(result, &ovSegment{a: prev, b: p, group: s.group})
+			prev = p
+		}
+	}
+	return result
+}
+
+// Splits segments until none of them cross or overlap. Returns the
+// resulting segments and whether any was split.
+func splitSegments(segs []*ovSegment) ([]*ovSegment, bool, error) {
+	changed := false
+	for pass := 0; pass < maxSplitPasses; pass++ {
+		if !findIntersections(segs) {
+			return segs, changed, nil
+		}
+		changed = true
+		segs = applySplits(segs)
+	}
+	return nil, false, fmt.Errorf("segments intersections did not converge")
+}
+
+// ovEdge is a split segment, going upward or rightward, with the variation
+// of each group winding number from its right to its left side.
+type ovEdge struct {
+	a, b  ipoint
+	delta [2]int
+}
+
+// Merges identical segments into edges.
+func mergeSegments(segs []*ovSegment) []*ovEdge {
+	type key struct {
+		a, b ipoint
+	}
+	edges := []*ovEdge{}
+	byKey := map[key]*ovEdge{}
+	for _, s := range segs {
+		a, b, d := s.a, s.b, 1
+		if ipointLess(b, a) {
+			a, b, d = b, a, -1
+		}
+		e := byKey[key{a, b}]
+		if e == nil {
+			e = &ovEdge{a: a, b: b}
+			byKey[key{a, b}] = e
+			edges = append(edges, e)
+		}
+		e.delta[s.group] += d
+	}
+	return edges
+}
+
+// windingIndex buckets non-horizontal edges by their y range to compute
+// winding numbers without scanning all of them. Coordinates are doubled.
+type windingIndex struct {
+	edges   []*ovEdge
+	minY    int64
+	step    int64
+	buckets [][]int32
+}
+
+func newWindingIndex(edges []*ovEdge) *windingIndex {
+	idx := &windingIndex{}
+	minY, maxY := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, e := range edges {
+		if e.a.Y == e.b.Y || e.delta == [2]int{} {
+			continue
+		}
+		idx.edges = append(idx.edges, e)
+		minY = minInt64(minY, 2*e.a.Y)
+		maxY = maxInt64(maxY, 2*e.b.Y)
+	}
+	if len(idx.edges) == 0 {
+		return idx
+	}
+	n := int64(len(idx.edges)/4 + 1)
+	idx.minY = minY
+	idx.step = (maxY-minY)/n + 1
+	idx.buckets = make([][]int32, n)
+	for i, e := range idx.edges {
+		first := (2*e.a.Y - minY) / idx.step
+		last := (2*e.b.Y - 1 - minY) / idx.step
+		for b := first; b <= last; b++ {
+			idx.buckets[b] = append(idx.buckets[b], int32(i))
+		}
+	}
+	return idx
+}
+
+// Returns the winding numbers of each group at p, whose coordinates are
+// doubled. A point lying on a non-horizontal edge gets the winding number of
+// the edge right side, and on a horizontal one, of its upper side.
+func (idx *windingIndex) winding(p ipoint) [2]int {
+	w := [2]int{}
+	if len(idx.buckets) == 0 || p.Y < idx.minY {
+		return w
+	}
+	b := (p.Y - idx.minY) / idx.step
+	if b >= int64(len(idx.buckets)) {
+		return w
+	}
+	for _, i := range idx.buckets[b] {
+		e := idx.edges[i]
+		if 2*e.a.Y <= p.Y && p.Y < 2*e.b.Y && orientDoubled(e.a, e.b, p) > 0 {
+			w[0] += e.delta[0]
+			w[1] += e.delta[1]
+		}
+	}
+	return w
+}
+
+func overlayInside(op int, w [2]int) bool {
+	switch op {
+	case overlayIntersection:
+		return w[0] != 0 && w[1] != 0
+	case overlayDifference:
+		return w[0] != 0 && w[1] == 0
+	}
+	return w[0] != 0 || w[1] != 0
+}
+
+// Returns true if, rotating counter-clockwise from r, d1 comes before d2.
+func ccwLess(r, d1, d2 ipoint) bool {
+	half := func(d ipoint) int {
+		c := cmpProducts(r.X, d.Y, r.Y, d.X)
+		if c > 0 || (c == 0 && cmpProducts(r.X, d.X, -r.Y, d.Y) > 0) {
+			return 0
+		}
+		return 1
+	}
+	h1, h2 := half(d1), half(d2)
+	if h1 != h2 {
+		return h1 < h2
+	}
+	return cmpProducts(d1.X, d2.Y, d1.Y, d2.X) > 0
+}
+
+// Links directed edges bounding a region, interior on their left, into
+// rings. Rings touching at a vertex are kept separate by always following
+// the first edge clockwise from the incoming one.
+func linkRings(edges [][2]ipoint) ([]iring, error) {
+	outgoing := map[ipoint][]int{}
+	for i, e := range edges {
+		outgoing[e[0]] = append(outgoing[e[0]], i)
+	}
+	used := make([]bool, len(edges))
+	rings := []iring{}
+	for i := range edges {
+		if used[i] {
+			continue
+		}
+		ring := iring{}
+		for cur := i; ; {
+			used[cur] = true
+			e := edges[cur]
+			ring = append(ring, e[0])
+			back := ipoint{e[0].X - e[1].X, e[0].Y - e[1].Y}
+			next := -1
+			var nextDir ipoint
+			for _, j := range outgoing[e[1]] {
+				f := edges[j]
+				dir := ipoint{f[1].X - f[0].X, f[1].Y - f[0].Y}
+				if next < 0 || ccwLess(back, nextDir, dir) {
+					next = j
+					nextDir = dir
+				}
+			}
+			if next < 0 {
+				return nil, fmt.Errorf("unclosed overlay ring at %v", e[1])
+			}
+			if next == i {
+				break
+			}
+			if used[next] {
+				return nil, fmt.Errorf("inconsistent overlay ring at %v", e[1])
+			}
+			cur = next
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+// Computes op between the regions bounded by a and b rings, which are
+// counter-clockwise for shells and clockwise for holes. Returns the result
+// rings oriented the same way.
+func overlay(a, b []iring, op int) ([]iring, error) {
+	segs := []*ovSegment{}
+	for group, rings := range [][]iring{a, b} {
+		for _, ring := range rings {
+			for i, p := range ring {
+				q := ring[(i+1)%len(ring)]
+				if p == q {
+					continue
+				}
+				segs = append(segs, &ovSegment{a: p, b: q, group: group})
+			}
+		}
+	}
+	segs, _, err := splitSegments(segs)
+	if err != nil {
+		return nil, err
+	}
+	edges := mergeSegments(segs)
+	idx := newWindingIndex(edges)
+	kept := [][2]ipoint{}
+	for _, e := range edges {
+		if e.delta == [2]int{} {
+			// Cancelled edges do not separate anything
+			continue
+		}
+		w := idx.winding(ipoint{e.a.X + e.b.X, e.a.Y + e.b.Y})
+		left, right := w, w
+		for g := range w {
+			if e.a.Y == e.b.Y {
+				right[g] -= e.delta[g]
+			} else {
+				left[g] += e.delta[g]
+			}
+		}
+		inLeft, inRight := overlayInside(op, left), overlayInside(op, right)
+		if inLeft == inRight {
+			continue
+		}
+		if inLeft {
+			kept = append(kept, [2]ipoint{e.a, e.b})
+		} else {
+			kept = append(kept, [2]ipoint{e.b, e.a})
+		}
+	}
+	return linkRings(kept)
+}
+
+// Returns twice the signed area of ring, positive if it is counter-clockwise.
+func iringArea(ring iring) float64 {
+	area := 0.
+	o := ring[0]
+	for i := 1; i+1 < len(ring); i++ {
+		p, q := ring[i], ring[i+1]
+		area += float64(p.X-o.X)*float64(q.Y-o.Y) -
+			float64(q.X-o.X)*float64(p.Y-o.Y)
+	}
+	return area
+}
+
+// Returns the winding number of ring at p, whose coordinates are doubled, or
+// false if p lies on the ring.
+func iringWinding(ring iring, p ipoint) (int, bool) {
+	w := 0
+	for i, a := range ring {
+		b := ring[(i+1)%len(ring)]
+		o := orientDoubled(a, b, p)
+		if o == 0 && minInt64(a.X, b.X)*2 <= p.X && p.X <= maxInt64(a.X, b.X)*2 &&
+			minInt64(a.Y, b.Y)*2 <= p.Y && p.Y <= maxInt64(a.Y, b.Y)*2 {
+			return 0, false
+		}
+		if 2*a.Y <= p.Y {
+			if p.Y < 2*b.Y && o > 0 {
+				w++
+			}
+		} else if p.Y >= 2*b.Y && o < 0 {
+			w--
+		}
+	}
+	return w, true
+}
+
+// Returns the winding number of outer around inner, tested at the first
+// vertex or edge middle of inner not lying on outer.
+func iringContainsRing(outer, inner iring) (bool, bool) {
+	for i, p := range inner {
+		if w, ok := iringWinding(outer, ipoint{2 * p.X, 2 * p.Y}); ok {
+			return w != 0, true
+		}
+		q := inner[(i+1)%len(inner)]
+		if w, ok := iringWinding(outer, ipoint{p.X + q.X, p.Y + q.Y}); ok {
+			return w != 0, true
+		}
+	}
+	return false, false
+}
+
+// ipolygon is a shell, counter-clockwise, followed by clockwise holes.
+type ipolygon []iring
+
+// Groups rings into polygons, assigning holes to the smallest shell
+// containing them. Degenerate rings are dropped.
+func buildIPolygons(rings []iring) ([]ipolygon, error) {
+	type shell struct {
+		ring iring
+		area float64
+		bbox *BBox
+	}
+	shells := []*shell{}
+	holes := []iring{}
+	for _, r := range rings {
+		if len(r) < 3 {
+			continue
+		}
+		area := iringArea(r)
+		if area > 0 {
+			bbox := NewBBox()
+			for _, p := range r {
+				bbox.Add(float64(p.X), float64(p.Y))
+			}
+			shells = append(shells, &shell{r, area, bbox})
+		} else if area < 0 {
+			holes = append(holes, r)
+		}
+	}
+	polygons := make([]ipolygon, len(shells))
+	for i, s := range shells {
+		polygons[i] = ipolygon{s.ring}
+	}
+	for _, h := range holes {
+		best := -1
+		for i, s := range shells {
+			if float64(h[0].X) < s.bbox.MinLon || float64(h[0].X) > s.bbox.MaxLon ||
+				float64(h[0].Y) < s.bbox.MinLat || float64(h[0].Y) > s.bbox.MaxLat {
+				continue
+			}
+			if best >= 0 && shells[best].area <= s.area {
+				continue
+			}
+			inside, ok := iringContainsRing(s.ring, h)
+			if ok && inside {
+				best = i
+			}
+		}
+		if best < 0 {
+			return nil, fmt.Errorf("hole is not contained by any shell")
+		}
+		polygons[best] = append(polygons[best], h)
+	}
+	return polygons, nil
+}
+
+// Converts a closed ring into an iring, dropping repeated points, oriented
+// counter-clockwise if ccw is true, clockwise otherwise.
+func makeIRing(coords []Coord, ccw bool) iring {
+	ring := make(iring, 0, len(coords))
+	for _, c := range coords {
+		p := toIPoint(c)
+		if len(ring) > 0 && ring[len(ring)-1] == p {
+			continue
+		}
+		ring = append(ring, p)
+	}
+	for len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+		ring = ring[:len(ring)-1]
+	}
+	if len(ring) >= 3 && (iringArea(ring) > 0) != ccw {
+		for i := 0; i < len(ring)/2; i++ {
+			j := len(ring) - 1 - i
+			ring[i], ring[j] = ring[j], ring[i]
+		}
+	}
+	return ring
+}
+
+func iringCoords(ring iring) []Coord {
+	coords := make([]Coord, 0, len(ring)+1)
+	for _, p := range ring {
+		coords = append(coords, p.Coord())
+	}
+	return append(coords, ring[0].Coord())
+}
+
+// pureGeometry is the pure Go geometry backend.
+type pureGeometry struct {
+	polygons []ipolygon
+}
+
+func newPurePolygon(shell []Coord, holes ...[]Coord) (*pureGeometry, error) {
+	outer := makeIRing(shell, true)
+	if len(outer) < 3 {
+		return nil, fmt.Errorf("invalid ring: not enough points")
+	}
+	poly := ipolygon{outer}
+	for _, h := range holes {
+		inner := makeIRing(h, false)
+		if len(inner) < 3 {
+			return nil, fmt.Errorf("invalid ring: not enough points")
+		}
+		poly = append(poly, inner)
+	}
+	return &pureGeometry{[]ipolygon{poly}}, nil
+}
+
+func newPureGeometry(rings []iring) (*pureGeometry, error) {
+	polygons, err := buildIPolygons(rings)
+	if err != nil {
+		return nil, err
+	}
+	return &pureGeometry{polygons}, nil
+}
+
+func (g *pureGeometry) rings() []iring {
+	rings := []iring{}
+	for _, poly := range g.polygons {
+		rings = append(rings, poly...)
+	}
+	return rings
+}
+
+func (g *pureGeometry) bbox() *BBox {
+	bbox := NewBBox()
+	for _, poly := range g.polygons {
+		for _, p := range poly[0] {
+			bbox.Add(float64(p.X), float64(p.Y))
+		}
+	}
+	return bbox
+}
+
+func toPure(other Geometry) (*pureGeometry, error) {
+	g, ok := other.(*pureGeometry)
+	if !ok {
+		return nil, fmt.Errorf("not a pure Go geometry: %T", other)
+	}
+	return g, nil
+}
+
+// Builds a polygon from ring, resolving self-intersections by keeping every
+// area it winds around.
+func newValidPurePolygon(ring []Coord) (*pureGeometry, error) {
+	g, err := newPurePolygon(ring)
+	if err != nil {
+		return nil, err
+	}
+	if isSimpleIRing(g.polygons[0][0]) {
+		return g, nil
+	}
+	rings, err := overlay(g.rings(), nil, overlayUnion)
+	if err != nil {
+		return nil, err
+	}
+	return newPureGeometry(rings)
+}
+
+func isSimpleIRing(ring iring) bool {
+	seen := make(map[ipoint]bool, len(ring))
+	segs := make([]*ovSegment, 0, len(ring))
+	for i, p := range ring {
+		if seen[p] {
+			return false
+		}
+		seen[p] = true
+		segs = append(segs, &ovSegment{a: p, b: ring[(i+1)%len(ring)]})
+	}
+	return !findIntersections(segs)
+}
+
+func isSimplePureRing(coords []Coord) bool {
+	if len(coords) < 4 || coords[0] != coords[len(coords)-1] {
+		return false
+	}
+	ring := make(iring, 0, len(coords)-1)
+	for _, c := range coords[:len(coords)-1] {
+		ring = append(ring, toIPoint(c))
+	}
+	return isSimpleIRing(ring)
+}
+
+func unaryPureUnion(geoms []*pureGeometry) (*pureGeometry, error) {
+	if len(geoms) == 1 {
+		return geoms[0], nil
+	}
+	rings := []iring{}
+	for _, g := range geoms {
+		rings = append(rings, g.rings()...)
+	}
+	merged, err := overlay(rings, nil, overlayUnion)
+	if err != nil {
+		return nil, err
+	}
+	return newPureGeometry(merged)
+}
+
+func (g *pureGeometry) Area() (float64, error) {
+	area := 0.
+	for _, r := range g.rings() {
+		area += iringArea(r)
+	}
+	return area / 2 / 1e14, nil
+}
+
+func (g *pureGeometry) Contains(other Geometry) (bool, error) {
+	o, err := toPure(other)
+	if err != nil {
+		return false, err
+	}
+	if len(g.polygons) == 0 || len(o.polygons) == 0 {
+		return false, nil
+	}
+	b1, b2 := g.bbox(), o.bbox()
+	if b2.MinLon < b1.MinLon || b2.MaxLon > b1.MaxLon ||
+		b2.MinLat < b1.MinLat || b2.MaxLat > b1.MaxLat {
+		return false, nil
+	}
+	diff, err := overlay(o.rings(), g.rings(), overlayDifference)
+	if err != nil {
+		return false, err
+	}
+	return len(diff) == 0, nil
+}
+
+func (g *pureGeometry) ContainsPoint(c Coord) (bool, error) {
+	p := toIPoint(c)
+	p = ipoint{2 * p.X, 2 * p.Y}
+	w := 0
+	for _, r := range g.rings() {
+		rw, ok := iringWinding(r, p)
+		if !ok {
+			return false, nil
+		}
+		w += rw
+	}
+	return w != 0, nil
+}
+
+func (g *pureGeometry) overlay(other Geometry, op int) (Geometry, error) {
+	o, err := toPure(other)
+	if err != nil {
+		return nil, err
+	}
+	rings, err := overlay(g.rings(), o.rings(), op)
+	if err != nil {
+		return nil, err
+	}
+	return newPureGeometry(rings)
+}
+
+func (g *pureGeometry) Intersection(other Geometry) (Geometry, error) {
+	return g.overlay(other, overlayIntersection)
+}
+
+func (g *pureGeometry) Difference(other Geometry) (Geometry, error) {
+	return g.overlay(other, overlayDifference)
+}
+
+func (g *pureGeometry) Rings() ([]Coord, [][]Coord, error) {
+	if len(g.polygons) == 0 {
+		return nil, nil, fmt.Errorf("empty geometry")
+	}
+	if len(g.polygons) > 1 {
+		return nil, nil, fmt.Errorf("cannot handle geometry type: multipolygon")
+	}
+	poly := g.polygons[0]
+	holes := make([][]Coord, 0, len(poly)-1)
+	for _, h := range poly[1:] {
+		holes = append(holes, iringCoords(h))
+	}
+	return iringCoords(poly[0]), holes, nil
+}
+
+func (g *pureGeometry) Polygons() ([]Geometry, error) {
+	polygons := make([]Geometry, 0, len(g.polygons))
+	for _, poly := range g.polygons {
+		polygons = append(polygons, &pureGeometry{[]ipolygon{poly}})
+	}
+	return polygons, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func makeSquare(x, y, size float64) []Coord {
+	return []Coord{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size},
+		{x, y}}
+}
+
+func checkPureArea(t *testing.T, name string, g *pureGeometry, expected float64) {
+	area, err := g.Area()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(area-expected) > 1e-9 {
+		t.Fatalf("%s: unexpected area: %f != %f", name, area, expected)
+	}
+}
+
+func TestPureOverlay(t *testing.T) {
+	a, err := newPurePolygon(makeSquare(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newPurePolygon(makeSquare(1, 1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Shares an edge with a
+	c, err := newPurePolygon(makeSquare(2, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPureArea(t, "a", a, 4)
+
+	inter, err := a.Intersection(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPureArea(t, "a&b", inter.(*pureGeometry), 1)
+
+	diff, err := a.Difference(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPureArea(t, "a-b", diff.(*pureGeometry), 3)
+	outer, holes, err := diff.Rings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outer) != 7 || len(holes) != 0 {
+		t.Fatalf("unexpected a-b rings: %v %v", outer, holes)
+	}
+
+	union, err := unaryPureUnion([]*pureGeometry{a, b, c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPureArea(t, "a|b|c", union, 8)
+	if len(union.polygons) != 1 {
+		t.Fatalf("shared edges were not merged: %d polygons", len(union.polygons))
+	}
+
+	inter, err = a.Intersection(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inter.(*pureGeometry).polygons) != 0 {
+		t.Fatalf("edge contact should not intersect: %v", inter)
+	}
+}
+
+func TestPureHoles(t *testing.T) {
+	g, err := newPurePolygon(makeSquare(0, 0, 4), makeSquare(1, 1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPureArea(t, "holed", g, 12)
+	outer, holes, err := g.Rings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outer) != 5 || len(holes) != 1 || len(holes[0]) != 5 {
+		t.Fatalf("unexpected rings: %v %v", outer, holes)
+	}
+
+	tests := []struct {
+		c        Coord
+		expected bool
+	}{
+		{Coord{0.5, 0.5}, true},
+		{Coord{2, 2}, false},
+		{Coord{1, 2}, false},
+		{Coord{0, 2}, false},
+		{Coord{5, 5}, false},
+	}
+	for _, test := range tests {
+		ok, err := g.ContainsPoint(test.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != test.expected {
+			t.Fatalf("%v: expected %v", test.c, test.expected)
+		}
+	}
+
+	inside, err := newPurePolygon(makeSquare(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inHole, err := newPurePolygon(makeSquare(1.5, 1.5, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := g.Contains(inside); err != nil || !ok {
+		t.Fatalf("polygon touching the shell should be contained: %v", err)
+	}
+	if ok, err := g.Contains(inHole); err != nil || ok {
+		t.Fatalf("polygon in hole should not be contained: %v", err)
+	}
+}
+
+func TestPureSimpleRing(t *testing.T) {
+	if !isSimplePureRing(makeSquare(0, 0, 1)) {
+		t.Fatal("square should be simple")
+	}
+	bowtie := []Coord{{0, 0}, {2, 2}, {2, 0}, {0, 2}, {0, 0}}
+	if isSimplePureRing(bowtie) {
+		t.Fatal("bowtie should not be simple")
+	}
+	if isSimplePureRing([]Coord{{0, 0}, {1, 0}, {1, 1}}) {
+		t.Fatal("open ring should not be simple")
+	}
+	g, err := newValidPurePolygon(bowtie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPureArea(t, "bowtie", g, 2)
+	if len(g.polygons) != 2 {
+		t.Fatalf("bowtie should be split in 2 polygons: %d", len(g.polygons))
+	}
+	if _, _, err := g.Rings(); err == nil {
+		t.Fatal("multipolygon rings should fail")
+	}
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+const (
+	// Datasets and payload bytes per batch sent to decoding workers
+	o5mBatchRecords = 4096
+	o5mBatchBytes   = 1024 * 1024
+)
+
+var (
+	// Number of goroutines decoding o5m files opened by OpenOSMReader, the
+	// sequential O5MReader is used below 2.
+	o5mDecodeWorkers = 0
+)
+
+// o5mString is a literal string pair or a reference to the strings table,
+// which can only be resolved in stream order.
+type o5mString struct {
+	literal bool
+	ref     int
+	k, v    string
+}
+
+// o5mDataset is a dataset decoded independently from the previous ones:
+// ids, coordinates and metadata are still delta encoded and strings may be
+// references. Strings are stored in stream order: metadata author, relation
+// member roles, then tags.
+type o5mDataset struct {
+	kind      int
+	offset    int
+	ignored   bool
+	hasAuthor bool
+	id        int64
+	version   int
+	timestamp int64
+	changeset int64
+	lon, lat  int64
+	deltas    []int64
+	strings   []o5mString
+	bbox      BoundingBox
+	err       error
+}
+
+type o5mRecord struct {
+	kind       int
+	offset     int
+	start, end int
+	hasAuthor  bool
+}
+
+// o5mBatch is a run of consecutive datasets, numbered by seq so decoded
+// batches can be consumed in stream order.
+type o5mBatch struct {
+	seq      int
+	data     []byte
+	records  []o5mRecord
+	datasets []o5mDataset
+	deltas   []int64
+	strings  []o5mString
+	// Set on the last batch, err is reported after its datasets
+	last bool
+	err  error
+}
+
+func readO5MString(d *codecDecoder, single bool) o5mString {
+	if d.err != nil || d.pos >= len(d.data) {
+		d.fail(fmt.Errorf("truncated string at %d", d.pos))
+		return o5mString{}
+	}
+	if d.data[d.pos] != 0 {
+		return o5mString{ref: int(d.Unsigned())}
+	}
+	d.pos++
+	s := o5mString{literal: true}
+	for i := 0; i < 2; i++ {
+		n := bytes.IndexByte(d.data[d.pos:], 0)
+		if n < 0 {
+			d.fail(fmt.Errorf("unterminated string at %d", d.pos))
+			return o5mString{}
+		}
+		if i == 0 {
+			s.k = string(d.data[d.pos : d.pos+n])
+		} else {
+			s.v = string(d.data[d.pos : d.pos+n])
+		}
+		d.pos += n + 1
+		if single {
+			break
+		}
+	}
+	return s
+}
+
+// Decodes the dataset of rec, without the strings table and previous
+// datasets state. Deltas and strings are appended to the batch ones, which
+// datasets slice, to save allocations.
+func decodeO5MDataset(b *o5mBatch, rec *o5mRecord, ds *o5mDataset) {
+	d := &codecDecoder{data: b.data[rec.start:rec.end]}
+	deltas, strs := len(b.deltas), len(b.strings)
+	defer func() {
+		ds.deltas = b.deltas[deltas:len(b.deltas):len(b.deltas)]
+		ds.strings = b.strings[strs:len(b.strings):len(b.strings)]
+	}()
+	switch rec.kind {
+	case NodeKind, WayKind, RelationKind:
+	case BBoxKind:
+		ds.bbox.X1 = float64(d.Signed()) / 1e7
+		ds.bbox.Y1 = float64(d.Signed()) / 1e7
+		ds.bbox.X2 = float64(d.Signed()) / 1e7
+		ds.bbox.Y2 = float64(d.Signed()) / 1e7
+		ds.err = d.Close()
+		return
+	default:
+		ds.err = fmt.Errorf("unsupported dataset: %x", rec.kind)
+		return
+	}
+	ds.id = d.Signed()
+	ds.version = int(d.Unsigned())
+	if ds.version > 0 {
+		ds.timestamp = d.Signed()
+		if rec.hasAuthor {
+			ds.changeset = d.Signed()
+			b.strings = append(b.strings, readO5MString(d, false))
+		}
+	}
+	switch rec.kind {
+	case NodeKind:
+		ds.lon = d.Signed()
+		ds.lat = d.Signed()
+	case WayKind, RelationKind:
+		end := d.pos + d.Count()
+		for d.pos < end {
+			b.deltas = append(b.deltas, d.Signed())
+			if rec.kind == RelationKind {
+				b.strings = append(b.strings, readO5MString(d, true))
+			}
+		}
+		if d.pos > end {
+			d.fail(fmt.Errorf("overread"))
+		}
+	}
+	for d.pos < len(d.data) {
+		b.strings = append(b.strings, readO5MString(d, false))
+	}
+	ds.err = d.Close()
+}
+
+// ParallelO5MReader reads o5m files like O5MReader but decodes datasets in
+// a pool of workers. One goroutine splits the input in batches of datasets
+// using their lengths, workers decode them independently, then Next()
+// applies delta encoding and the strings table in stream order. Whether
+// metadata includes an author depends on the delta encoded timestamp, the
+// splitter tracks it to tell workers.
+type ParallelO5MReader struct {
+	fp           *o5m.Input
+	indexer      fileIndexer
+	workers      int
+	ignoredKinds []bool
+
+	quit     chan struct{}
+	done     chan *o5mBatch
+	inflight chan struct{}
+	running  sync.WaitGroup
+	pending  map[int]*o5mBatch
+	seq      int
+	batch    *o5mBatch
+	pos      int
+
+	err         error
+	kind        int
+	strings     *o5m.StringsTable
+	resetPoint  ResetPoint
+	boundingBox *BoundingBox
+	node        Node
+	way         Way
+	nodeId      int64
+	relation    Relation
+	refIds      []int64
+	// Metadata delta encoding bases by kind
+	nodeMeta     o5m.MetaBase
+	wayMeta      o5m.MetaBase
+	relationMeta o5m.MetaBase
+}
+
+func NewParallelO5MReader(path string, workers int, ignoredKind ...int) (
+	*ParallelO5MReader, error) {
+
+	fp, err := o5m.OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newParallelO5MReader(fp, workers, ignoredKind...)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newParallelO5MReader(fp *o5m.Input, workers int, ignoredKind ...int) (
+	*ParallelO5MReader, error) {
+
+	ignoredKinds, err := makeIgnoredKinds(ignoredKind)
+	if err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid workers count: %d", workers)
+	}
+	r := &ParallelO5MReader{
+		fp:           fp,
+		indexer:      fileIndexer{path: fp.Path()},
+		workers:      workers,
+		ignoredKinds: ignoredKinds,
+	}
+	br := o5m.NewDecoder(fp)
+	_, err = o5m.ParseHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	r.start(br)
+	return r, nil
+}
+
+func (r *ParallelO5MReader) ignored(kind int) bool {
+	return kind < len(r.ignoredKinds) && r.ignoredKinds[kind]
+}
+
+// Starts splitting and decoding br content.
+func (r *ParallelO5MReader) start(br *o5m.Decoder) {
+	r.quit = make(chan struct{})
+	r.done = make(chan *o5mBatch, r.workers)
+	r.inflight = make(chan struct{}, 2*r.workers)
+	r.pending = map[int]*o5mBatch{}
+	r.seq = 0
+	r.batch = nil
+	r.reset()
+	todo := make(chan *o5mBatch, r.workers)
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		defer close(todo)
+		r.split(br, todo)
+	}()
+	for i := 0; i < r.workers; i++ {
+		r.running.Add(1)
+		go func() {
+			defer r.running.Done()
+			for b := range todo {
+				b.datasets = make([]o5mDataset, len(b.records))
+				for i := range b.records {
+					rec := &b.records[i]
+					ds := &b.datasets[i]
+					ds.kind = rec.kind
+					ds.offset = rec.offset
+					ds.ignored = r.ignored(rec.kind)
+					ds.hasAuthor = rec.hasAuthor
+					if rec.kind != ResetKind && !ds.ignored {
+						decodeO5MDataset(b, rec, ds)
+					}
+				}
+				select {
+				case r.done <- b:
+				case <-r.quit:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Stops the pipeline goroutines.
+func (r *ParallelO5MReader) stop() {
+	if r.quit != nil {
+		close(r.quit)
+		r.running.Wait()
+		r.quit = nil
+	}
+}
+
+func (r *ParallelO5MReader) split(br *o5m.Decoder, todo chan *o5mBatch) {
+	// Timestamps by kind, to know which datasets have author information
+	timestamps := make([]int64, RelationKind+1)
+	for seq := 0; ; seq++ {
+		b := &o5mBatch{
+			seq:     seq,
+			data:    make([]byte, 0, o5mBatchBytes+4096),
+			records: make([]o5mRecord, 0, o5mBatchRecords),
+		}
+		for !b.last && len(b.records) < o5mBatchRecords &&
+			len(b.data) < o5mBatchBytes {
+			b.last, b.err = r.readRecord(br, b, timestamps)
+		}
+		select {
+		case r.inflight <- struct{}{}:
+		case <-r.quit:
+			return
+		}
+		select {
+		case todo <- b:
+		case <-r.quit:
+			return
+		}
+		if b.last {
+			return
+		}
+	}
+}
+
+// Appends the next dataset of br to b. Returns true at end of input.
+func (r *ParallelO5MReader) readRecord(br *o5m.Decoder, b *o5mBatch,
+	timestamps []int64) (bool, error) {
+
+	rec := o5mRecord{offset: br.Offset()}
+	k := br.Byte()
+	if br.Err() != nil {
+		return true, fmt.Errorf("cannot read dataset header: %s", br.Err())
+	}
+	rec.kind = int(k)
+	switch rec.kind {
+	case ResetKind:
+		for i := range timestamps {
+			timestamps[i] = 0
+		}
+		b.records = append(b.records, rec)
+		return false, nil
+	case EndKind:
+		return true, nil
+	}
+	l := br.ReadUnsigned()
+	if br.Err() != nil {
+		return true, br.Err()
+	}
+	if l > o5m.MaxDatasetLength {
+		return true, fmt.Errorf("dataset too long: %d", l)
+	}
+	length := int(l)
+	if r.ignored(rec.kind) {
+		_, err := br.Discard(length)
+		if err != nil {
+			return true, err
+		}
+		b.records = append(b.records, rec)
+		return false, nil
+	}
+	rec.start = len(b.data)
+	rec.end = rec.start + length
+	b.data = append(b.data, make([]byte, length)...)
+	br.ReadFull(b.data[rec.start:])
+	if br.Err() != nil {
+		return true, br.Err()
+	}
+	if rec.kind == NodeKind || rec.kind == WayKind ||
+		rec.kind == RelationKind {
+		// Decoding errors are reported by workers
+		d := &codecDecoder{data: b.data[rec.start:rec.end]}
+		d.Signed()
+		// Elements without version keep the timestamp base
+		if d.Unsigned() > 0 {
+			timestamps[rec.kind] += d.Signed()
+			rec.hasAuthor = timestamps[rec.kind] != 0
+		}
+	}
+	b.records = append(b.records, rec)
+	return false, nil
+}
+
+func (r *ParallelO5MReader) reset() {
+	r.node = Node{}
+	r.way = Way{}
+	r.nodeId = 0
+	r.relation = Relation{}
+	r.strings = o5m.NewStringsTable()
+	r.refIds = make([]int64, 3)
+	r.nodeMeta = o5m.MetaBase{}
+	r.wayMeta = o5m.MetaBase{}
+	r.relationMeta = o5m.MetaBase{}
+}
+
+// Returns the next batch in stream order.
+func (r *ParallelO5MReader) nextBatch() *o5mBatch {
+	for r.pending[r.seq] == nil {
+		b := <-r.done
+		r.pending[b.seq] = b
+	}
+	b := r.pending[r.seq]
+	delete(r.pending, r.seq)
+	r.seq++
+	<-r.inflight
+	return b
+}
+
+func (r *ParallelO5MReader) resolveString(s o5mString) (string, string,
+	error) {
+
+	if s.literal {
+		r.strings.Push(s.k, s.v)
+		return s.k, s.v, nil
+	}
+	return r.strings.Get(s.ref)
+}
+
+// Applies delta encoded metadata like parseMeta, returns the strings
+// following the author.
+func (r *ParallelO5MReader) resolveMeta(ds *o5mDataset, base *o5m.MetaBase,
+	meta *Metadata) ([]o5mString, error) {
+
+	strs := ds.strings
+	*meta = Metadata{}
+	if ds.version == 0 {
+		return strs, nil
+	}
+	meta.Version = ds.version
+	base.Timestamp += int(ds.timestamp)
+	meta.Timestamp = base.Timestamp
+	if ds.hasAuthor {
+		base.Changeset += int(ds.changeset)
+		meta.Changeset = base.Changeset
+		uid, author, err := r.resolveString(strs[0])
+		if err != nil {
+			return nil, err
+		}
+		meta.Uid, meta.Author = uid, author
+		strs = strs[1:]
+	}
+	return strs, nil
+}
+
+func (r *ParallelO5MReader) resolveTags(strs []o5mString,
+	tags []StringPair) ([]StringPair, error) {
+
+	for _, s := range strs {
+		k, v, err := r.resolveString(s)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, StringPair{
+			Key:   k,
+			Value: v,
+		})
+	}
+	return tags, nil
+}
+
+func (r *ParallelO5MReader) resolve(ds *o5mDataset) error {
+	if ds.err != nil {
+		return ds.err
+	}
+	switch ds.kind {
+	case BBoxKind:
+		bb := ds.bbox
+		r.boundingBox = &bb
+	case NodeKind:
+		n := &r.node
+		n.Id += ds.id
+		n.Tags = n.Tags[:0]
+		strs, err := r.resolveMeta(ds, &r.nodeMeta, &n.Meta)
+		if err != nil {
+			return err
+		}
+		n.Lon = int64(int32(n.Lon) + int32(ds.lon))
+		n.Lat += ds.lat
+		n.Tags, err = r.resolveTags(strs, n.Tags)
+		return err
+	case WayKind:
+		w := &r.way
+		w.Id += ds.id
+		w.Nodes = w.Nodes[:0]
+		w.Tags = w.Tags[:0]
+		strs, err := r.resolveMeta(ds, &r.wayMeta, &w.Meta)
+		if err != nil {
+			return err
+		}
+		for _, delta := range ds.deltas {
+			r.nodeId += delta
+			w.Nodes = append(w.Nodes, r.nodeId)
+		}
+		w.Tags, err = r.resolveTags(strs, w.Tags)
+		return err
+	case RelationKind:
+		rel := &r.relation
+		rel.Id += ds.id
+		rel.Refs = rel.Refs[:0]
+		rel.Tags = rel.Tags[:0]
+		strs, err := r.resolveMeta(ds, &r.relationMeta, &rel.Meta)
+		if err != nil {
+			return err
+		}
+		for i, delta := range ds.deltas {
+			s, _, err := r.resolveString(strs[i])
+			if err != nil {
+				return err
+			}
+			if len(s) < 1 || s[0] < '0' || s[0] > '2' {
+				return fmt.Errorf("invalid reference type: %s", s)
+			}
+			typ := int(s[0] - '0')
+			r.refIds[typ] += delta
+			rel.Refs = append(rel.Refs, Ref{
+				Id:   r.refIds[typ],
+				Type: typ,
+				Role: s[1:],
+			})
+		}
+		rel.Tags, err = r.resolveTags(strs[len(ds.deltas):], rel.Tags)
+		return err
+	}
+	return nil
+}
+
+func (r *ParallelO5MReader) Next() bool {
+	if r.err != nil || r.kind == EndKind {
+		return false
+	}
+	for r.batch == nil || r.pos >= len(r.batch.datasets) {
+		if r.batch != nil && r.batch.last {
+			r.err = r.batch.err
+			r.kind = EndKind
+			return false
+		}
+		r.batch = r.nextBatch()
+		r.pos = 0
+	}
+	ds := &r.batch.datasets[r.pos]
+	r.pos++
+	r.kind = ds.kind
+	if ds.kind == ResetKind {
+		r.reset()
+		r.resetPoint.Offset = ds.offset
+		return true
+	}
+	if ds.ignored {
+		return true
+	}
+	r.err = r.resolve(ds)
+	return r.err == nil
+}
+
+func (r *ParallelO5MReader) Seek(target ResetPoint) error {
+	r.stop()
+	_, err := r.fp.Seek(int64(target.Offset), 0)
+	if err != nil {
+		return err
+	}
+	br := o5m.NewDecoderAt(r.fp, target.Offset)
+	r.err = nil
+	r.kind = 0
+	r.start(br)
+	return nil
+}
+
+// SeekToKind moves the reader to the reset point preceding the first element
+// of kind. The file is indexed on first call.
+func (r *ParallelO5MReader) SeekToKind(kind int) error {
+	p, err := r.indexer.start(kind)
+	if err != nil {
+		return err
+	}
+	return r.Seek(p)
+}
+
+func (r *ParallelO5MReader) Close() error {
+	r.stop()
+	return r.fp.Close()
+}
+
+func (r *ParallelO5MReader) Err() error {
+	return r.err
+}
+
+func (r *ParallelO5MReader) Kind() int {
+	return r.kind
+}
+
+func (r *ParallelO5MReader) ResetPoint() ResetPoint {
+	if r.kind != ResetKind {
+		panic("not a reset point")
+	}
+	return r.resetPoint
+}
+
+func (r *ParallelO5MReader) BoundingBox() BoundingBox {
+	if r.kind != BBoxKind {
+		panic("not a bounding box")
+	}
+	return *r.boundingBox
+}
+
+func (r *ParallelO5MReader) Node() *Node {
+	if r.kind != NodeKind {
+		panic("not a node")
+	}
+	return &r.node
+}
+
+func (r *ParallelO5MReader) Way() *Way {
+	if r.kind != WayKind {
+		panic("not a way")
+	}
+	return &r.way
+}
+
+func (r *ParallelO5MReader) Relation() *Relation {
+	if r.kind != RelationKind {
+		panic("not a relation")
+	}
+	return &r.relation
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// Returns a description of the remaining events of r.
+func readEvents(t *testing.T, r OSMReader) ([]string, []ResetPoint) {
+	events := []string{}
+	resets := []ResetPoint{}
+	for r.Next() {
+		e := ""
+		switch r.Kind() {
+		case ResetKind:
+			resets = append(resets, r.ResetPoint())
+			e = fmt.Sprintf("reset %d", r.ResetPoint().Offset)
+		case BBoxKind:
+			e = fmt.Sprintf("bbox %+v", r.BoundingBox())
+		case NodeKind:
+			e = fmt.Sprintf("node %+v", *r.Node())
+		case WayKind:
+			e = fmt.Sprintf("way %+v", *r.Way())
+		case RelationKind:
+			e = fmt.Sprintf("relation %+v", *r.Relation())
+		}
+		events = append(events, e)
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	return events, resets
+}
+
+func TestParallelO5MReader(t *testing.T) {
+	nodes := []Node{}
+	for i := 0; i < 20000; i++ {
+		n := Node{
+			Id:  int64(i*3 + 1),
+			Lon: int64(1799999999 - i*71999),
+			Lat: int64(-899999999 + i*35999),
+		}
+		switch i % 4 {
+		case 0:
+			n.Tags = []StringPair{
+				{Key: "name", Value: fmt.Sprintf("node %d", i)},
+				{Key: "place", Value: "village"},
+			}
+		case 1:
+			n.Meta = Metadata{Version: 2, Timestamp: 1500000000 + i,
+				Changeset: 100 + i, Uid: string(o5m.AppendUnsigned(nil, 42)),
+				Author: "alice"}
+		case 2:
+			// Metadata without author
+			n.Meta = Metadata{Version: 1}
+		}
+		nodes = append(nodes, n)
+	}
+	ways := []Way{
+		{Id: 10, Nodes: []int64{1, 4, 7, 1}, Tags: []StringPair{{Key: "boundary", Value: "administrative"}}},
+		{Id: 12, Nodes: []int64{7, 4}, Meta: Metadata{Version: 3, Timestamp: 10,
+			Changeset: 1, Uid: string(o5m.AppendUnsigned(nil, 7)), Author: "bob"}},
+	}
+	relations := []Relation{
+		{Id: 5, Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}, {Id: 12, Type: 1, Role: "inner"}, {Id: 1, Type: 0, Role: "admin_centre"}},
+			Tags: []StringPair{{Key: "type", Value: "boundary"}}},
+		{Id: 7, Refs: []Ref{{Id: 5, Type: 2, Role: "subarea"}, {Id: 10, Type: 1, Role: "outer"}},
+			Tags: []StringPair{{Key: "type", Value: "boundary"}}},
+	}
+	path := writeTestFile(t, nodes, ways, relations)
+	defer os.Remove(path)
+
+	for _, ignored := range [][]int{nil, {NodeKind}, {WayKind, RelationKind}} {
+		seq, err := NewO5MReader(path, ignored...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, resets := readEvents(t, seq)
+		seq.Close()
+		if len(resets) != 3 {
+			t.Fatalf("unexpected resets: %v", resets)
+		}
+
+		r, err := NewParallelO5MReader(path, 3, ignored...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := readEvents(t, r)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("parallel reader differs with ignored=%v", ignored)
+		}
+		eventsFrom := func(reset ResetPoint) []string {
+			for i, e := range want {
+				if e == fmt.Sprintf("reset %d", reset.Offset) {
+					return want[i:]
+				}
+			}
+			return nil
+		}
+		for _, reset := range []ResetPoint{resets[1], resets[0]} {
+			if err := r.Seek(reset); err != nil {
+				t.Fatal(err)
+			}
+			got, _ = readEvents(t, r)
+			if !reflect.DeepEqual(got, eventsFrom(reset)) {
+				t.Fatalf("parallel reader differs after seek with ignored=%v",
+					ignored)
+			}
+		}
+		// Seek while the pipeline is still running
+		if err := r.Seek(resets[0]); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			r.Next()
+		}
+		if err := r.Seek(resets[2]); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = readEvents(t, r)
+		if !reflect.DeepEqual(got, eventsFrom(resets[2])) {
+			t.Fatalf("parallel reader differs after early seek with ignored=%v",
+				ignored)
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,1035 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// Limits from the PBF specification.
+const (
+	pbfMaxHeaderSize = 64 * 1024
+	pbfMaxBlobSize   = 32 * 1024 * 1024
+)
+
+// pbfBuffer decodes protocol buffers wire format messages. Decoding errors
+// are sticky, callers check Err() once done.
+type pbfBuffer struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newPbfBuffer(data []byte) *pbfBuffer {
+	return &pbfBuffer{data: data}
+}
+
+func (b *pbfBuffer) Err() error {
+	return b.err
+}
+
+func (b *pbfBuffer) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+	b.pos = len(b.data)
+}
+
+func (b *pbfBuffer) Varint() uint64 {
+	if b.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(b.data[b.pos:])
+	if n <= 0 {
+		b.fail(fmt.Errorf("invalid varint at %d", b.pos))
+		return 0
+	}
+	b.pos += n
+	return v
+}
+
+func (b *pbfBuffer) Signed() int64 {
+	return unzigzag(b.Varint())
+}
+
+func (b *pbfBuffer) Bytes() []byte {
+	n := b.Varint()
+	if b.err != nil {
+		return nil
+	}
+	if n > uint64(len(b.data)-b.pos) {
+		b.fail(fmt.Errorf("truncated field at %d", b.pos))
+		return nil
+	}
+	data := b.data[b.pos : b.pos+int(n)]
+	b.pos += int(n)
+	return data
+}
+
+// Returns the next field number and wire type, or false at end of message
+// or on error.
+func (b *pbfBuffer) Next() (int, int, bool) {
+	if b.err != nil || b.pos >= len(b.data) {
+		return 0, 0, false
+	}
+	key := b.Varint()
+	if b.err != nil {
+		return 0, 0, false
+	}
+	return int(key >> 3), int(key & 7), true
+}
+
+func (b *pbfBuffer) Skip(wire int) {
+	n := 0
+	switch wire {
+	case 0:
+		b.Varint()
+		return
+	case 1:
+		n = 8
+	case 2:
+		b.Bytes()
+		return
+	case 5:
+		n = 4
+	default:
+		b.fail(fmt.Errorf("unsupported wire type: %d", wire))
+		return
+	}
+	if n > len(b.data)-b.pos {
+		b.fail(fmt.Errorf("truncated field at %d", b.pos))
+		return
+	}
+	b.pos += n
+}
+
+// Appends the values of a repeated varint field, either packed or not.
+func (b *pbfBuffer) Varints(wire int, values []uint64) []uint64 {
+	if wire == 0 {
+		return append(values, b.Varint())
+	}
+	if wire != 2 {
+		b.fail(fmt.Errorf("invalid wire type for repeated field: %d", wire))
+		return values
+	}
+	p := newPbfBuffer(b.Bytes())
+	for p.pos < len(p.data) {
+		values = append(values, p.Varint())
+	}
+	if p.err != nil {
+		b.fail(p.err)
+	}
+	return values
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// Decodes a delta encoded packed sint64 field.
+func (b *pbfBuffer) Deltas(wire int, values []int64) []int64 {
+	raw := b.Varints(wire, nil)
+	prev := int64(0)
+	for _, v := range raw {
+		prev += unzigzag(v)
+		values = append(values, prev)
+	}
+	return values
+}
+
+func parseBlobHeader(data []byte) (string, int, error) {
+	b := newPbfBuffer(data)
+	typ := ""
+	size := -1
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			typ = string(b.Bytes())
+		case 3:
+			size = int(b.Varint())
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return "", 0, fmt.Errorf("invalid blob header: %s", b.Err())
+	}
+	if typ == "" || size < 0 {
+		return "", 0, fmt.Errorf("invalid blob header: missing type or size")
+	}
+	if size > pbfMaxBlobSize {
+		return "", 0, fmt.Errorf("blob is too large: %d", size)
+	}
+	return typ, size, nil
+}
+
+// Returns the uncompressed content of a Blob message. Only raw and zlib
+// blobs are supported, which is what common tools write.
+func decodeBlob(data []byte) ([]byte, error) {
+	b := newPbfBuffer(data)
+	var raw, compressed []byte
+	rawSize := -1
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			raw = b.Bytes()
+		case 2:
+			rawSize = int(b.Varint())
+		case 3:
+			compressed = b.Bytes()
+		case 4, 5, 6, 7:
+			return nil, fmt.Errorf("unsupported blob compression: %d", field)
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return nil, fmt.Errorf("invalid blob: %s", b.Err())
+	}
+	if raw != nil {
+		return raw, nil
+	}
+	if compressed == nil {
+		return nil, fmt.Errorf("invalid blob: no data")
+	}
+	if rawSize > pbfMaxBlobSize {
+		return nil, fmt.Errorf("blob is too large: %d", rawSize)
+	}
+	z, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(z, pbfMaxBlobSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot uncompress blob: %s", err)
+	}
+	if rawSize >= 0 && len(out) != rawSize {
+		return nil, fmt.Errorf("uncompressed blob size mismatch: %d != %d",
+			len(out), rawSize)
+	}
+	return out, nil
+}
+
+var (
+	pbfSupportedFeatures = map[string]bool{
+		"OsmSchema-V0.6": true,
+		"DenseNodes":     true,
+	}
+)
+
+// Parses a HeaderBlock, returning its bounding box if any.
+func parsePbfHeader(data []byte) (*BoundingBox, error) {
+	b := newPbfBuffer(data)
+	var bbox *BoundingBox
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			box := []float64{0, 0, 0, 0}
+			bb := newPbfBuffer(b.Bytes())
+			for {
+				f, w, ok := bb.Next()
+				if !ok {
+					break
+				}
+				if f < 1 || f > 4 {
+					bb.Skip(w)
+					continue
+				}
+				box[f-1] = float64(bb.Signed()) / 1e9
+			}
+			if bb.Err() != nil {
+				return nil, fmt.Errorf("invalid header bbox: %s", bb.Err())
+			}
+			// left, right, top, bottom
+			bbox = &BoundingBox{
+				X1: box[0],
+				Y1: box[3],
+				X2: box[1],
+				Y2: box[2],
+			}
+		case 4:
+			feature := string(b.Bytes())
+			if !pbfSupportedFeatures[feature] {
+				return nil, fmt.Errorf("unsupported required feature: %s", feature)
+			}
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return nil, fmt.Errorf("invalid header block: %s", b.Err())
+	}
+	return bbox, nil
+}
+
+type pbfEntity struct {
+	Kind     int
+	Node     *Node
+	Way      *Way
+	Relation *Relation
+}
+
+// pbfBlock holds the decoding parameters of a PrimitiveBlock.
+type pbfBlock struct {
+	strings         []string
+	granularity     int64
+	latOffset       int64
+	lonOffset       int64
+	dateGranularity int64
+	ignoredKinds    []bool
+}
+
+// Converts a PBF coordinate into o5m fixed point coordinates.
+func (p *pbfBlock) coord(offset, v int64) int64 {
+	return (offset + p.granularity*v) / 100
+}
+
+func (p *pbfBlock) str(i uint64) (string, error) {
+	if i >= uint64(len(p.strings)) {
+		return "", fmt.Errorf("invalid string index: %d", i)
+	}
+	return p.strings[i], nil
+}
+
+func (p *pbfBlock) tags(keys, values []uint64) ([]StringPair, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("keys and values count mismatch: %d != %d",
+			len(keys), len(values))
+	}
+	tags := make([]StringPair, 0, len(keys))
+	for i, k := range keys {
+		key, err := p.str(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.str(values[i])
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, StringPair{
+			Key:   key,
+			Value: value,
+		})
+	}
+	return tags, nil
+}
+
+func (p *pbfBlock) meta(version, timestamp, changeset, uid int64,
+	user uint64) (Metadata, error) {
+
+	author, err := p.str(user)
+	if err != nil {
+		return Metadata{}, err
+	}
+	m := Metadata{
+		Version:   int(version),
+		Timestamp: int(timestamp * p.dateGranularity / 1000),
+		Changeset: int(changeset),
+		Author:    author,
+	}
+	if uid > 0 {
+		m.Uid = string(o5m.AppendUnsigned(nil, uint64(uid)))
+	}
+	return m, nil
+}
+
+func (p *pbfBlock) parseInfo(data []byte) (Metadata, error) {
+	b := newPbfBuffer(data)
+	var version, timestamp, changeset, uid int64
+	user := uint64(0)
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			version = int64(int32(b.Varint()))
+		case 2:
+			timestamp = int64(b.Varint())
+		case 3:
+			changeset = int64(b.Varint())
+		case 4:
+			uid = int64(int32(b.Varint()))
+		case 5:
+			user = b.Varint()
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return Metadata{}, b.Err()
+	}
+	return p.meta(version, timestamp, changeset, uid, user)
+}
+
+func (p *pbfBlock) ignored(kind int) bool {
+	return p.ignoredKinds[kind]
+}
+
+func (p *pbfBlock) parseNode(data []byte) (*Node, error) {
+	b := newPbfBuffer(data)
+	n := &Node{}
+	var keys, values []uint64
+	var lon, lat int64
+	ignored := p.ignored(NodeKind)
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1:
+			n.Id = b.Signed()
+		case field == 8:
+			lat = b.Signed()
+		case field == 9:
+			lon = b.Signed()
+		case ignored:
+			b.Skip(wire)
+		case field == 2:
+			keys = b.Varints(wire, keys)
+		case field == 3:
+			values = b.Varints(wire, values)
+		case field == 4:
+			meta, err := p.parseInfo(b.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			n.Meta = meta
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return nil, b.Err()
+	}
+	n.Lon = p.coord(p.lonOffset, lon)
+	n.Lat = p.coord(p.latOffset, lat)
+	tags, err := p.tags(keys, values)
+	if err != nil {
+		return nil, err
+	}
+	n.Tags = tags
+	return n, nil
+}
+
+type pbfDenseInfo struct {
+	versions   []uint64
+	timestamps []int64
+	changesets []int64
+	uids       []int64
+	users      []int64
+}
+
+func parseDenseInfo(data []byte) (*pbfDenseInfo, error) {
+	b := newPbfBuffer(data)
+	info := &pbfDenseInfo{}
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			info.versions = b.Varints(wire, info.versions)
+		case 2:
+			info.timestamps = b.Deltas(wire, info.timestamps)
+		case 3:
+			info.changesets = b.Deltas(wire, info.changesets)
+		case 4:
+			info.uids = b.Deltas(wire, info.uids)
+		case 5:
+			info.users = b.Deltas(wire, info.users)
+		default:
+			b.Skip(wire)
+		}
+	}
+	return info, b.Err()
+}
+
+func (p *pbfBlock) parseDenseNodes(data []byte, entities []pbfEntity) (
+	[]pbfEntity, error) {
+
+	b := newPbfBuffer(data)
+	var ids, lats, lons []int64
+	var keysValues []uint64
+	var info *pbfDenseInfo
+	ignored := p.ignored(NodeKind)
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1:
+			ids = b.Deltas(wire, ids)
+		case ignored:
+			b.Skip(wire)
+		case field == 5:
+			di, err := parseDenseInfo(b.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			info = di
+		case field == 8:
+			lats = b.Deltas(wire, lats)
+		case field == 9:
+			lons = b.Deltas(wire, lons)
+		case field == 10:
+			keysValues = b.Varints(wire, keysValues)
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return nil, b.Err()
+	}
+	if !ignored && (len(lats) != len(ids) || len(lons) != len(ids)) {
+		return nil, fmt.Errorf("dense nodes ids and coordinates mismatch")
+	}
+	if info != nil && (len(info.versions) != len(ids) ||
+		len(info.timestamps) != len(ids) || len(info.changesets) != len(ids) ||
+		len(info.uids) != len(ids) || len(info.users) != len(ids)) {
+		return nil, fmt.Errorf("dense nodes ids and metadata mismatch")
+	}
+	kv := 0
+	for i, id := range ids {
+		n := &Node{
+			Id: id,
+		}
+		entities = append(entities, pbfEntity{Kind: NodeKind, Node: n})
+		if ignored {
+			continue
+		}
+		n.Lon = p.coord(p.lonOffset, lons[i])
+		n.Lat = p.coord(p.latOffset, lats[i])
+		if info != nil {
+			meta, err := p.meta(int64(info.versions[i]), info.timestamps[i],
+				info.changesets[i], info.uids[i], uint64(info.users[i]))
+			if err != nil {
+				return nil, err
+			}
+			n.Meta = meta
+		}
+		// Tags are stored as key/value indexes, each node list being
+		// terminated by a zero.
+		for kv < len(keysValues) && keysValues[kv] != 0 {
+			if kv+1 >= len(keysValues) {
+				return nil, fmt.Errorf("truncated dense nodes tags")
+			}
+			k, err := p.str(keysValues[kv])
+			if err != nil {
+				return nil, err
+			}
+			v, err := p.str(keysValues[kv+1])
+			if err != nil {
+				return nil, err
+			}
+			n.Tags = append(n.Tags, StringPair{
+				Key:   k,
+				Value: v,
+			})
+			kv += 2
+		}
+		kv++
+	}
+	return entities, nil
+}
+
+func (p *pbfBlock) parseWay(data []byte) (*Way, error) {
+	b := newPbfBuffer(data)
+	w := &Way{}
+	var keys, values []uint64
+	ignored := p.ignored(WayKind)
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1:
+			w.Id = int64(b.Varint())
+		case ignored:
+			b.Skip(wire)
+		case field == 2:
+			keys = b.Varints(wire, keys)
+		case field == 3:
+			values = b.Varints(wire, values)
+		case field == 4:
+			meta, err := p.parseInfo(b.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			w.Meta = meta
+		case field == 8:
+			w.Nodes = b.Deltas(wire, w.Nodes)
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return nil, b.Err()
+	}
+	tags, err := p.tags(keys, values)
+	if err != nil {
+		return nil, err
+	}
+	w.Tags = tags
+	return w, nil
+}
+
+func (p *pbfBlock) parseRelation(data []byte) (*Relation, error) {
+	b := newPbfBuffer(data)
+	r := &Relation{}
+	var keys, values, roles, types []uint64
+	var ids []int64
+	ignored := p.ignored(RelationKind)
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch {
+		case field == 1:
+			r.Id = int64(b.Varint())
+		case ignored:
+			b.Skip(wire)
+		case field == 2:
+			keys = b.Varints(wire, keys)
+		case field == 3:
+			values = b.Varints(wire, values)
+		case field == 4:
+			meta, err := p.parseInfo(b.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			r.Meta = meta
+		case field == 8:
+			roles = b.Varints(wire, roles)
+		case field == 9:
+			ids = b.Deltas(wire, ids)
+		case field == 10:
+			types = b.Varints(wire, types)
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return nil, b.Err()
+	}
+	if len(roles) != len(ids) || len(types) != len(ids) {
+		return nil, fmt.Errorf("relation %d members mismatch", r.Id)
+	}
+	for i, id := range ids {
+		role, err := p.str(roles[i])
+		if err != nil {
+			return nil, err
+		}
+		// Member types are NODE, WAY, RELATION like o5m references
+		if types[i] > 2 {
+			return nil, fmt.Errorf("invalid reference type: %d", types[i])
+		}
+		r.Refs = append(r.Refs, Ref{
+			Id:   id,
+			Type: int(types[i]),
+			Role: role,
+		})
+	}
+	tags, err := p.tags(keys, values)
+	if err != nil {
+		return nil, err
+	}
+	r.Tags = tags
+	return r, nil
+}
+
+func (p *pbfBlock) parseGroup(data []byte, entities []pbfEntity) (
+	[]pbfEntity, error) {
+
+	b := newPbfBuffer(data)
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		var err error
+		switch field {
+		case 1:
+			var n *Node
+			n, err = p.parseNode(b.Bytes())
+			if err == nil {
+				entities = append(entities, pbfEntity{Kind: NodeKind, Node: n})
+			}
+		case 2:
+			entities, err = p.parseDenseNodes(b.Bytes(), entities)
+		case 3:
+			var w *Way
+			w, err = p.parseWay(b.Bytes())
+			if err == nil {
+				entities = append(entities, pbfEntity{Kind: WayKind, Way: w})
+			}
+		case 4:
+			var r *Relation
+			r, err = p.parseRelation(b.Bytes())
+			if err == nil {
+				entities = append(entities,
+					pbfEntity{Kind: RelationKind, Relation: r})
+			}
+		default:
+			b.Skip(wire)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entities, b.Err()
+}
+
+// Decodes a PrimitiveBlock into its elements, in file order.
+func parsePrimitiveBlock(data []byte, ignoredKinds []bool) ([]pbfEntity, error) {
+	b := newPbfBuffer(data)
+	p := &pbfBlock{
+		granularity:     100,
+		dateGranularity: 1000,
+		ignoredKinds:    ignoredKinds,
+	}
+	// Groups usually precede the decoding parameters, keep them for later
+	groups := [][]byte{}
+	for {
+		field, wire, ok := b.Next()
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			st := newPbfBuffer(b.Bytes())
+			for {
+				f, w, ok := st.Next()
+				if !ok {
+					break
+				}
+				if f == 1 {
+					p.strings = append(p.strings, string(st.Bytes()))
+				} else {
+					st.Skip(w)
+				}
+			}
+			if st.Err() != nil {
+				return nil, fmt.Errorf("invalid string table: %s", st.Err())
+			}
+		case 2:
+			groups = append(groups, b.Bytes())
+		case 17:
+			p.granularity = int64(int32(b.Varint()))
+		case 18:
+			p.dateGranularity = int64(int32(b.Varint()))
+		case 19:
+			p.latOffset = int64(b.Varint())
+		case 20:
+			p.lonOffset = int64(b.Varint())
+		default:
+			b.Skip(wire)
+		}
+	}
+	if b.Err() != nil {
+		return nil, fmt.Errorf("invalid primitive block: %s", b.Err())
+	}
+	entities := []pbfEntity{}
+	for _, g := range groups {
+		var err error
+		entities, err = p.parseGroup(g, entities)
+		if err != nil {
+			return nil, fmt.Errorf("invalid primitive group: %s", err)
+		}
+	}
+	return entities, nil
+}
+
+func kindSection(kind int) int {
+	switch kind {
+	case NodeKind:
+		return nodeSection
+	case WayKind:
+		return waySection
+	}
+	return relationSection
+}
+
+// PBFReader reads OpenStreetMap PBF files. It mimics O5MReader: the header
+// bounding box is reported first, then elements by kind, each kind being
+// preceded by a reset point, including empty ones. Elements must be sorted
+// by kind, like in files produced by common tools.
+type PBFReader struct {
+	fp           *o5m.Input
+	indexer      fileIndexer
+	r            *bufio.Reader
+	err          error
+	kind         int
+	ignoredKinds []bool
+
+	// File offset of the next blob and of the current block
+	offset      int
+	blockOffset int
+	entities    []pbfEntity
+	pos         int
+	section     int
+	resetPoint  ResetPoint
+	boundingBox *BoundingBox
+	pendingBBox bool
+	entity      pbfEntity
+}
+
+func NewPBFReader(path string, ignoredKind ...int) (*PBFReader, error) {
+	fp, err := o5m.OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newPBFReader(fp, ignoredKind...)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newPBFReader(fp *o5m.Input, ignoredKind ...int) (*PBFReader, error) {
+	ignoredKinds, err := makeIgnoredKinds(ignoredKind)
+	if err != nil {
+		return nil, err
+	}
+	r := &PBFReader{
+		fp:           fp,
+		indexer:      fileIndexer{path: fp.Path()},
+		r:            bufio.NewReaderSize(fp, 1024*1024),
+		ignoredKinds: ignoredKinds,
+	}
+	typ, data, err := r.readBlob()
+	if err == nil && typ != "OSMHeader" {
+		err = fmt.Errorf("expected OSMHeader blob, got %q", typ)
+	}
+	if err == nil {
+		r.boundingBox, err = parsePbfHeader(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read PBF header: %s", err)
+	}
+	r.pendingBBox = r.boundingBox != nil
+	return r, nil
+}
+
+func (r *PBFReader) Close() error {
+	return r.fp.Close()
+}
+
+// Reads the next blob and returns its type and uncompressed content. Returns
+// io.EOF if there are no more blobs.
+func (r *PBFReader) readBlob() (string, []byte, error) {
+	head := make([]byte, 4)
+	_, err := io.ReadFull(r.r, head)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("truncated blob header size")
+		}
+		return "", nil, err
+	}
+	size := int(binary.BigEndian.Uint32(head))
+	if size > pbfMaxHeaderSize {
+		return "", nil, fmt.Errorf("blob header is too large: %d", size)
+	}
+	header := make([]byte, size)
+	_, err = io.ReadFull(r.r, header)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read blob header: %s", err)
+	}
+	typ, dataSize, err := parseBlobHeader(header)
+	if err != nil {
+		return "", nil, err
+	}
+	blob := make([]byte, dataSize)
+	_, err = io.ReadFull(r.r, blob)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read blob: %s", err)
+	}
+	r.offset += 4 + size + dataSize
+	data, err := decodeBlob(blob)
+	return typ, data, err
+}
+
+// Loads the next data block. Returns false at end of file or on error.
+func (r *PBFReader) readBlock() bool {
+	for {
+		offset := r.offset
+		typ, data, err := r.readBlob()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if typ != "OSMData" {
+			continue
+		}
+		entities, err := parsePrimitiveBlock(data, r.ignoredKinds)
+		if err != nil {
+			r.err = fmt.Errorf("cannot parse block at %d: %s", offset, err)
+			return false
+		}
+		r.blockOffset = offset
+		r.entities = entities
+		r.pos = 0
+		if len(entities) > 0 {
+			return true
+		}
+	}
+}
+
+func (r *PBFReader) enterSection(offset, index int) bool {
+	r.section++
+	r.kind = ResetKind
+	r.resetPoint = ResetPoint{
+		Offset:  offset,
+		Index:   index,
+		Section: r.section,
+	}
+	return true
+}
+
+func (r *PBFReader) Next() bool {
+	if r.err != nil || r.kind == EndKind {
+		return false
+	}
+	if r.pendingBBox {
+		r.pendingBBox = false
+		r.kind = BBoxKind
+		return true
+	}
+	for r.pos >= len(r.entities) {
+		if !r.readBlock() {
+			if r.err != nil {
+				return false
+			}
+			if r.section < relationSection {
+				r.entities = nil
+				r.pos = 0
+				return r.enterSection(r.offset, 0)
+			}
+			r.kind = EndKind
+			return false
+		}
+	}
+	e := r.entities[r.pos]
+	section := kindSection(e.Kind)
+	if section < r.section {
+		r.err = fmt.Errorf("elements are not sorted by kind at offset %d",
+			r.blockOffset)
+		return false
+	}
+	if section > r.section {
+		return r.enterSection(r.blockOffset, r.pos)
+	}
+	r.pos++
+	r.entity = e
+	r.kind = e.Kind
+	return true
+}
+
+// Seek moves the reader back to target, the next call to Next() returns the
+// reset point again.
+func (r *PBFReader) Seek(target ResetPoint) error {
+	_, err := r.fp.Seek(int64(target.Offset), 0)
+	if err != nil {
+		return err
+	}
+	r.r.Reset(r.fp)
+	r.offset = target.Offset
+	r.err = nil
+	r.kind = ResetKind
+	r.pendingBBox = false
+	r.entities = nil
+	r.pos = 0
+	if target.Index > 0 {
+		if !r.readBlock() {
+			if r.err == nil {
+				r.err = fmt.Errorf("cannot seek to %d: missing block", target.Offset)
+			}
+			return r.err
+		}
+		r.pos = target.Index
+	}
+	r.section = target.Section - 1
+	return nil
+}
+
+// SeekToKind moves the reader to the reset point preceding the first element
+// of kind. The file is indexed on first call.
+func (r *PBFReader) SeekToKind(kind int) error {
+	p, err := r.indexer.start(kind)
+	if err != nil {
+		return err
+	}
+	return r.Seek(p)
+}
+
+func (r *PBFReader) Err() error {
+	return r.err
+}
+
+func (r *PBFReader) Kind() int {
+	return r.kind
+}
+
+func (r *PBFReader) ResetPoint() ResetPoint {
+	if r.kind != ResetKind {
+		panic("not a reset point")
+	}
+	return r.resetPoint
+}
+
+func (r *PBFReader) BoundingBox() BoundingBox {
+	if r.kind != BBoxKind {
+		panic("not a bounding box")
+	}
+	return *r.boundingBox
+}
+
+func (r *PBFReader) Node() *Node {
+	if r.kind != NodeKind {
+		panic("not a node")
+	}
+	return r.entity.Node
+}
+
+func (r *PBFReader) Way() *Way {
+	if r.kind != WayKind {
+		panic("not a way")
+	}
+	return r.entity.Way
+}
+
+func (r *PBFReader) Relation() *Relation {
+	if r.kind != RelationKind {
+		panic("not a relation")
+	}
+	return r.entity.Relation
+}
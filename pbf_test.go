@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+func pbfKey(buf []byte, field, wire int) []byte {
+	return o5m.AppendUnsigned(buf, uint64(field<<3|wire))
+}
+
+func pbfVarint(buf []byte, field int, v uint64) []byte {
+	return o5m.AppendUnsigned(pbfKey(buf, field, 0), v)
+}
+
+func pbfZigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func pbfBytes(buf []byte, field int, data []byte) []byte {
+	buf = o5m.AppendUnsigned(pbfKey(buf, field, 2), uint64(len(data)))
+	return append(buf, data...)
+}
+
+func pbfPacked(buf []byte, field int, values ...uint64) []byte {
+	data := []byte{}
+	for _, v := range values {
+		data = o5m.AppendUnsigned(data, v)
+	}
+	return pbfBytes(buf, field, data)
+}
+
+func pbfDeltas(buf []byte, field int, values ...int64) []byte {
+	deltas := []uint64{}
+	prev := int64(0)
+	for _, v := range values {
+		deltas = append(deltas, pbfZigzag(v-prev))
+		prev = v
+	}
+	return pbfPacked(buf, field, deltas...)
+}
+
+func pbfBlob(typ string, data []byte, compress bool) []byte {
+	blob := []byte{}
+	if compress {
+		z := &bytes.Buffer{}
+		w := zlib.NewWriter(z)
+		w.Write(data)
+		w.Close()
+		blob = pbfVarint(blob, 2, uint64(len(data)))
+		blob = pbfBytes(blob, 3, z.Bytes())
+	} else {
+		blob = pbfBytes(blob, 1, data)
+	}
+	header := pbfBytes(nil, 1, []byte(typ))
+	header = pbfVarint(header, 3, uint64(len(blob)))
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, uint32(len(header)))
+	out = append(out, header...)
+	return append(out, blob...)
+}
+
+func writeTestPBF(t *testing.T) string {
+	bbox := pbfVarint(nil, 1, pbfZigzag(-1e9))
+	bbox = pbfVarint(bbox, 2, pbfZigzag(2e9))
+	bbox = pbfVarint(bbox, 3, pbfZigzag(4e9))
+	bbox = pbfVarint(bbox, 4, pbfZigzag(-3e9))
+	header := pbfBytes(nil, 1, bbox)
+	header = pbfBytes(header, 4, []byte("OsmSchema-V0.6"))
+	header = pbfBytes(header, 4, []byte("DenseNodes"))
+
+	strings := [][]byte{{}, []byte("name"), []byte("A"), []byte("highway"),
+		[]byte("stop"), []byte("outer"), []byte("admin_centre")}
+	st := []byte{}
+	for _, s := range strings {
+		st = pbfBytes(st, 1, s)
+	}
+	dense := pbfDeltas(nil, 1, 1, 2, 3)
+	dense = pbfDeltas(dense, 8, 10, -20, 30)
+	dense = pbfDeltas(dense, 9, 40, 50, -60)
+	dense = pbfPacked(dense, 10, 1, 2, 0, 0, 3, 4, 1, 2, 0)
+	way := pbfVarint(nil, 1, 10)
+	way = pbfPacked(way, 2, 3)
+	way = pbfPacked(way, 3, 4)
+	way = pbfDeltas(way, 8, 1, 3, 2)
+	// Groups come before the string table and granularity on purpose
+	block1 := pbfBytes(nil, 2, pbfBytes(nil, 2, dense))
+	block1 = pbfBytes(block1, 2, pbfBytes(nil, 3, way))
+	block1 = pbfBytes(block1, 1, st)
+	block1 = pbfVarint(block1, 17, 1000)
+
+	rel := pbfVarint(nil, 1, 5)
+	rel = pbfPacked(rel, 2, 1)
+	rel = pbfPacked(rel, 3, 2)
+	rel = pbfPacked(rel, 8, 5, 6)
+	rel = pbfDeltas(rel, 9, 10, 1)
+	rel = pbfPacked(rel, 10, 1, 0)
+	block2 := pbfBytes(nil, 1, st)
+	block2 = pbfBytes(block2, 2, pbfBytes(nil, 4, rel))
+
+	data := pbfBlob("OSMHeader", header, false)
+	data = append(data, pbfBlob("OSMData", block1, true)...)
+	data = append(data, pbfBlob("Unknown", []byte{1, 2, 3}, false)...)
+	data = append(data, pbfBlob("OSMData", block2, false)...)
+
+	fp, err := ioutil.TempFile("", "osm-*.osm.pbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	if _, err := fp.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return fp.Name()
+}
+
+func readPBFKinds(t *testing.T, r OSMReader) []int {
+	kinds := []int{}
+	for r.Next() {
+		kinds = append(kinds, r.Kind())
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	return kinds
+}
+
+func TestPBFReader(t *testing.T) {
+	path := writeTestPBF(t)
+	defer os.Remove(path)
+
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, ok := r.(*PBFReader); !ok {
+		t.Fatalf("unexpected reader: %T", r)
+	}
+	nodes := []Node{}
+	ways := []Way{}
+	relations := []Relation{}
+	kinds := []int{}
+	resets := []ResetPoint{}
+	for r.Next() {
+		kinds = append(kinds, r.Kind())
+		switch r.Kind() {
+		case BBoxKind:
+			bb := r.BoundingBox()
+			if bb != (BoundingBox{X1: -1, Y1: -3, X2: 2, Y2: 4}) {
+				t.Fatalf("unexpected bounding box: %+v", bb)
+			}
+		case ResetKind:
+			resets = append(resets, r.ResetPoint())
+		case NodeKind:
+			nodes = append(nodes, *r.Node())
+		case WayKind:
+			ways = append(ways, *r.Way())
+		case RelationKind:
+			relations = append(relations, *r.Relation())
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	wantKinds := []int{BBoxKind, ResetKind, NodeKind, NodeKind, NodeKind,
+		ResetKind, WayKind, ResetKind, RelationKind}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Fatalf("unexpected kinds: %v", kinds)
+	}
+	wantNodes := []Node{
+		{Id: 1, Lon: 400, Lat: 100, Tags: []StringPair{{Key: "name", Value: "A"}}},
+		{Id: 2, Lon: 500, Lat: -200},
+		{Id: 3, Lon: -600, Lat: 300,
+			Tags: []StringPair{{Key: "highway", Value: "stop"}, {Key: "name", Value: "A"}}},
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Fatalf("unexpected nodes:\n%+v\n!=\n%+v", nodes, wantNodes)
+	}
+	wantWay := Way{Id: 10, Nodes: []int64{1, 3, 2},
+		Tags: []StringPair{{Key: "highway", Value: "stop"}}}
+	if len(ways) != 1 || !reflect.DeepEqual(ways[0], wantWay) {
+		t.Fatalf("unexpected ways: %+v", ways)
+	}
+	wantRel := Relation{Id: 5,
+		Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}, {Id: 1, Type: 0, Role: "admin_centre"}},
+		Tags: []StringPair{{Key: "name", Value: "A"}}}
+	if len(relations) != 1 || !reflect.DeepEqual(relations[0], wantRel) {
+		t.Fatalf("unexpected relations: %+v", relations)
+	}
+
+	// Ways start in the middle of the first block
+	err = r.Seek(resets[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds = readPBFKinds(t, r)
+	wantKinds = []int{ResetKind, WayKind, ResetKind, RelationKind}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Fatalf("unexpected kinds after seek: %v", kinds)
+	}
+	err = r.Seek(resets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds = readPBFKinds(t, r)
+	wantKinds = []int{ResetKind, NodeKind, NodeKind, NodeKind,
+		ResetKind, WayKind, ResetKind, RelationKind}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Fatalf("unexpected kinds after seek: %v", kinds)
+	}
+}
+
+func TestPBFReaderIgnoredKinds(t *testing.T) {
+	path := writeTestPBF(t)
+	defer os.Remove(path)
+
+	r, err := NewPBFReader(path, NodeKind, WayKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ids := []int64{}
+	for r.Next() {
+		switch r.Kind() {
+		case NodeKind:
+			n := r.Node()
+			if len(n.Tags) != 0 || n.Lon != 0 {
+				t.Fatalf("ignored node was decoded: %+v", n)
+			}
+			ids = append(ids, n.Id)
+		case WayKind:
+			if len(r.Way().Nodes) != 0 {
+				t.Fatalf("ignored way was decoded: %+v", r.Way())
+			}
+		case RelationKind:
+			if len(r.Relation().Refs) != 2 {
+				t.Fatalf("relation was not decoded: %+v", r.Relation())
+			}
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("unexpected node ids: %v", ids)
+	}
+}
+
+func TestPBFUnsupportedFeature(t *testing.T) {
+	header := pbfBytes(nil, 4, []byte("HistoricalInformation"))
+	fp, err := ioutil.TempFile("", "osm-*.osm.pbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	fp.Write(pbfBlob("OSMHeader", header, true))
+	fp.Close()
+	_, err = OpenOSMReader(fp.Name())
+	if err == nil {
+		t.Fatalf("unsupported feature was accepted")
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+const (
+	// Maximum number of elements per PrimitiveBlock, like osmium and
+	// osmconvert write.
+	pbfBlockSize = 8000
+)
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func pbfAppendKey(buf []byte, field, wire int) []byte {
+	return o5m.AppendUnsigned(buf, uint64(field<<3|wire))
+}
+
+func pbfAppendVarint(buf []byte, field int, v uint64) []byte {
+	return o5m.AppendUnsigned(pbfAppendKey(buf, field, 0), v)
+}
+
+func pbfAppendBytes(buf []byte, field int, data []byte) []byte {
+	buf = o5m.AppendUnsigned(pbfAppendKey(buf, field, 2), uint64(len(data)))
+	return append(buf, data...)
+}
+
+// Appends values as a packed repeated field, omitted when empty.
+func pbfAppendPacked(buf, tmp []byte, field int, values []uint64) ([]byte,
+	[]byte) {
+
+	if len(values) == 0 {
+		return buf, tmp
+	}
+	tmp = tmp[:0]
+	for _, v := range values {
+		tmp = o5m.AppendUnsigned(tmp, v)
+	}
+	return pbfAppendBytes(buf, field, tmp), tmp
+}
+
+// Appends values delta and zigzag encoded as a packed repeated field.
+func pbfAppendDeltas(buf, tmp []byte, field int, values []int64) ([]byte,
+	[]byte) {
+
+	if len(values) == 0 {
+		return buf, tmp
+	}
+	tmp = tmp[:0]
+	prev := int64(0)
+	for _, v := range values {
+		tmp = o5m.AppendUnsigned(tmp, zigzag(v-prev))
+		prev = v
+	}
+	return pbfAppendBytes(buf, field, tmp), tmp
+}
+
+// Returns the user id of m, stored as an unsigned varint like in o5m files,
+// or 0 for anonymous edits.
+func metadataUid(m *Metadata) int64 {
+	uid, n := binary.Uvarint([]byte(m.Uid))
+	if n <= 0 {
+		return 0
+	}
+	return int64(uid)
+}
+
+// PBFWriter serializes nodes, ways and relations in PBF format, with zlib
+// compressed blobs, dense nodes and the default granularities, which match
+// o5m resolution. Like O5MWriter, elements must be written by kind, nodes
+// first, then ways, then relations, and the bounding box before them.
+type PBFWriter struct {
+	w       *bufio.Writer
+	err     error
+	section int
+	header  bool
+	buf     []byte
+	tmp     []byte
+
+	// Pending block string table, the first string is always empty
+	strings   []string
+	stringIds map[string]int
+	count     int
+	hasMeta   bool
+
+	// Dense nodes columns
+	ids        []int64
+	lats       []int64
+	lons       []int64
+	keysVals   []uint64
+	versions   []uint64
+	timestamps []int64
+	changesets []int64
+	uids       []int64
+	users      []int64
+
+	// Encoded ways or relations
+	group []byte
+	keys  []uint64
+	vals  []uint64
+	roles []uint64
+	types []uint64
+	refs  []int64
+}
+
+func NewPBFWriter(w io.Writer) (*PBFWriter, error) {
+	pw := &PBFWriter{
+		w: bufio.NewWriter(w),
+	}
+	pw.resetBlock()
+	return pw, nil
+}
+
+func (w *PBFWriter) resetBlock() {
+	w.strings = append(w.strings[:0], "")
+	w.stringIds = map[string]int{"": 0}
+	w.count = 0
+	w.hasMeta = false
+	w.ids = w.ids[:0]
+	w.lats = w.lats[:0]
+	w.lons = w.lons[:0]
+	w.keysVals = w.keysVals[:0]
+	w.versions = w.versions[:0]
+	w.timestamps = w.timestamps[:0]
+	w.changesets = w.changesets[:0]
+	w.uids = w.uids[:0]
+	w.users = w.users[:0]
+	w.group = w.group[:0]
+}
+
+func (w *PBFWriter) str(s string) uint64 {
+	id, ok := w.stringIds[s]
+	if !ok {
+		id = len(w.strings)
+		w.strings = append(w.strings, s)
+		w.stringIds[s] = id
+	}
+	return uint64(id)
+}
+
+func (w *PBFWriter) writeBlob(typ string, data []byte) error {
+	z := &bytes.Buffer{}
+	zw := zlib.NewWriter(z)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	blob := pbfAppendVarint(nil, 2, uint64(len(data)))
+	blob = pbfAppendBytes(blob, 3, z.Bytes())
+	header := pbfAppendBytes(nil, 1, []byte(typ))
+	header = pbfAppendVarint(header, 3, uint64(len(blob)))
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(header)))
+	for _, b := range [][]byte{size, header, blob} {
+		if _, err := w.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the OSMHeader blob, with bb if not nil.
+func (w *PBFWriter) writeHeader(bb *BoundingBox) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.header {
+		return nil
+	}
+	w.header = true
+	buf := []byte{}
+	if bb != nil {
+		box := []byte{}
+		// left, right, top, bottom in nanodegrees
+		for i, v := range []float64{bb.X1, bb.X2, bb.Y2, bb.Y1} {
+			box = pbfAppendVarint(box, i+1, zigzag(int64(math.Round(v*1e9))))
+		}
+		buf = pbfAppendBytes(buf, 1, box)
+	}
+	buf = pbfAppendBytes(buf, 4, []byte("OsmSchema-V0.6"))
+	buf = pbfAppendBytes(buf, 4, []byte("DenseNodes"))
+	buf = pbfAppendBytes(buf, 16, []byte("osm"))
+	w.err = w.writeBlob("OSMHeader", buf)
+	return w.err
+}
+
+// WriteBoundingBox writes the file bounding box. It must be called before
+// any element is written.
+func (w *PBFWriter) WriteBoundingBox(bb BoundingBox) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.header {
+		w.err = fmt.Errorf("bounding box must be written before elements")
+		return w.err
+	}
+	return w.writeHeader(&bb)
+}
+
+// Flushes the pending block and switches to section.
+func (w *PBFWriter) enterSection(section int) error {
+	if err := w.writeHeader(nil); err != nil {
+		return err
+	}
+	if section < w.section {
+		w.err = fmt.Errorf("elements must be written as nodes, ways then relations")
+		return w.err
+	}
+	if section != w.section || w.count >= pbfBlockSize {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+		w.section = section
+	}
+	w.count++
+	return nil
+}
+
+func (w *PBFWriter) appendInfo(buf []byte, m *Metadata) []byte {
+	if m.Version <= 0 {
+		return buf
+	}
+	info := pbfAppendVarint(nil, 1, uint64(m.Version))
+	info = pbfAppendVarint(info, 2, uint64(m.Timestamp))
+	info = pbfAppendVarint(info, 3, uint64(m.Changeset))
+	info = pbfAppendVarint(info, 4, uint64(metadataUid(m)))
+	info = pbfAppendVarint(info, 5, w.str(m.Author))
+	return pbfAppendBytes(buf, 4, info)
+}
+
+// Appends the tags of an element as keys and values fields.
+func (w *PBFWriter) appendTags(buf []byte, tags []StringPair) []byte {
+	w.keys = w.keys[:0]
+	w.vals = w.vals[:0]
+	for _, tag := range tags {
+		w.keys = append(w.keys, w.str(tag.Key))
+		w.vals = append(w.vals, w.str(tag.Value))
+	}
+	buf, w.tmp = pbfAppendPacked(buf, w.tmp, 2, w.keys)
+	buf, w.tmp = pbfAppendPacked(buf, w.tmp, 3, w.vals)
+	return buf
+}
+
+func (w *PBFWriter) WriteNode(n *Node) error {
+	if err := w.enterSection(nodeSection); err != nil {
+		return err
+	}
+	w.ids = append(w.ids, n.Id)
+	w.lats = append(w.lats, n.Lat)
+	w.lons = append(w.lons, n.Lon)
+	for _, tag := range n.Tags {
+		w.keysVals = append(w.keysVals, w.str(tag.Key), w.str(tag.Value))
+	}
+	w.keysVals = append(w.keysVals, 0)
+	m := &n.Meta
+	if m.Version > 0 {
+		w.hasMeta = true
+	}
+	w.versions = append(w.versions, uint64(m.Version))
+	w.timestamps = append(w.timestamps, int64(m.Timestamp))
+	w.changesets = append(w.changesets, int64(m.Changeset))
+	w.uids = append(w.uids, metadataUid(m))
+	w.users = append(w.users, int64(w.str(m.Author)))
+	return nil
+}
+
+func (w *PBFWriter) WriteWay(way *Way) error {
+	if err := w.enterSection(waySection); err != nil {
+		return err
+	}
+	buf := pbfAppendVarint(w.buf[:0], 1, uint64(way.Id))
+	buf = w.appendTags(buf, way.Tags)
+	buf = w.appendInfo(buf, &way.Meta)
+	buf, w.tmp = pbfAppendDeltas(buf, w.tmp, 8, way.Nodes)
+	w.group = pbfAppendBytes(w.group, 3, buf)
+	w.buf = buf
+	return nil
+}
+
+func (w *PBFWriter) WriteRelation(r *Relation) error {
+	if err := w.enterSection(relationSection); err != nil {
+		return err
+	}
+	w.roles = w.roles[:0]
+	w.types = w.types[:0]
+	w.refs = w.refs[:0]
+	for _, ref := range r.Refs {
+		if ref.Type < 0 || ref.Type > 2 {
+			w.err = fmt.Errorf("invalid reference type: %d", ref.Type)
+			return w.err
+		}
+		w.roles = append(w.roles, w.str(ref.Role))
+		w.types = append(w.types, uint64(ref.Type))
+		w.refs = append(w.refs, ref.Id)
+	}
+	buf := pbfAppendVarint(w.buf[:0], 1, uint64(r.Id))
+	buf = w.appendTags(buf, r.Tags)
+	buf = w.appendInfo(buf, &r.Meta)
+	buf, w.tmp = pbfAppendPacked(buf, w.tmp, 8, w.roles)
+	buf, w.tmp = pbfAppendDeltas(buf, w.tmp, 9, w.refs)
+	buf, w.tmp = pbfAppendPacked(buf, w.tmp, 10, w.types)
+	w.group = pbfAppendBytes(w.group, 4, buf)
+	w.buf = buf
+	return nil
+}
+
+func (w *PBFWriter) appendDenseNodes(buf []byte) []byte {
+	buf, w.tmp = pbfAppendDeltas(buf, w.tmp, 1, w.ids)
+	if w.hasMeta {
+		info := []byte{}
+		info, w.tmp = pbfAppendPacked(info, w.tmp, 1, w.versions)
+		info, w.tmp = pbfAppendDeltas(info, w.tmp, 2, w.timestamps)
+		info, w.tmp = pbfAppendDeltas(info, w.tmp, 3, w.changesets)
+		info, w.tmp = pbfAppendDeltas(info, w.tmp, 4, w.uids)
+		info, w.tmp = pbfAppendDeltas(info, w.tmp, 5, w.users)
+		buf = pbfAppendBytes(buf, 5, info)
+	}
+	buf, w.tmp = pbfAppendDeltas(buf, w.tmp, 8, w.lats)
+	buf, w.tmp = pbfAppendDeltas(buf, w.tmp, 9, w.lons)
+	// Omitted when no node has tags, like osmium does
+	for _, kv := range w.keysVals {
+		if kv != 0 {
+			buf, w.tmp = pbfAppendPacked(buf, w.tmp, 10, w.keysVals)
+			break
+		}
+	}
+	return buf
+}
+
+// Writes pending elements as a PrimitiveBlock.
+func (w *PBFWriter) flushBlock() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.count == 0 {
+		return nil
+	}
+	group := w.group
+	if w.section == nodeSection {
+		group = pbfAppendBytes(nil, 2, w.appendDenseNodes(nil))
+	}
+	table := []byte{}
+	for _, s := range w.strings {
+		table = pbfAppendBytes(table, 1, []byte(s))
+	}
+	block := pbfAppendBytes(nil, 1, table)
+	block = pbfAppendBytes(block, 2, group)
+	w.err = w.writeBlob("OSMData", block)
+	w.resetBlock()
+	return w.err
+}
+
+// Close writes pending elements and flushes buffered data. It does not
+// close the underlying writer.
+func (w *PBFWriter) Close() error {
+	if err := w.writeHeader(nil); err != nil {
+		return err
+	}
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// PlaceJson is a place node exported with the relations document schema. Its
+// shape is a point and its parents are the boundaries containing it.
+type PlaceJson struct {
+	RelationJson
+	Shape      PointGeometry `json:"shape"`
+	Place      string        `json:"place"`
+	Population int64         `json:"population,omitempty"`
+}
+
+// Parses population tags like "12345" or "12 345", returning 0 if the value
+// is not a count.
+func parsePopulation(s string) int64 {
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == ',' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Builds the document of place node n, or returns nil if its place type is
+// not in types or it has no name. lookup returns the boundaries containing a
+// point, sorted by increasing admin level.
+func makePlaceJson(n *Node, types map[string]bool,
+	lookup func(lon, lat float64) ([]AdminAreaJson, error)) (*PlaceJson, error) {
+
+	place, _ := findTag(n.Tags, "place")
+	name, _ := findTag(n.Tags, "name")
+	if !types[place] || name == "" {
+		return nil, nil
+	}
+	lon := float64(n.Lon) / 1e7
+	lat := float64(n.Lat) / 1e7
+	p := &PlaceJson{
+		Shape: PointGeometry{
+			Type:        "point",
+			Coordinates: []float64{lon, lat},
+		},
+		Place: place,
+	}
+	p.Id = fmt.Sprintf("node/%d", n.Id)
+	p.Name = name
+	p.Names = makeNamesJson(n.Tags, nameLanguages)
+	p.Center.Lon = lon
+	p.Center.Lat = lat
+	if v, ok := findTag(n.Tags, "population"); ok {
+		p.Population = parsePopulation(v)
+	}
+	if v, ok := findTag(n.Tags, "wikidata"); ok {
+		if wikidata, ok := checkWikidata(v); ok {
+			p.Wikidata = wikidata
+		} else {
+			slog.Warn("invalid wikidata tag", "node", n.Id, "value", v)
+		}
+	}
+	if v, ok := findTag(n.Tags, "wikipedia"); ok {
+		p.Wikipedia = strings.TrimSpace(v)
+	}
+	parents, err := lookup(lon, lat)
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) > 0 {
+		p.Parents = parents
+		p.ParentPath = formatParentPath(parents, name)
+	}
+	p.Tags = copyTags(n.Tags)
+	if exportMetadata {
+		p.Meta = makeMetadataJson(&n.Meta)
+	}
+	return p, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePopulation(t *testing.T) {
+	for s, n := range map[string]int64{
+		"12345":   12345,
+		"12 345":  12345,
+		"1,234":   1234,
+		"about 3": 0,
+		"-5":      0,
+	} {
+		if v := parsePopulation(s); v != n {
+			t.Fatalf("%q: expected %d, got %d", s, n, v)
+		}
+	}
+}
+
+func TestMakePlaceJson(t *testing.T) {
+	types := map[string]bool{"city": true, "town": true}
+	lookup := func(lon, lat float64) ([]AdminAreaJson, error) {
+		return []AdminAreaJson{
+			{Id: "1", Name: "France", AdminLevel: 2},
+			{Id: "2", Name: "Bretagne", AdminLevel: 4},
+		}, nil
+	}
+	n := &Node{Id: 7, Lon: -16800000, Lat: 481000000, Tags: []StringPair{
+		{Key: "place", Value: "town"},
+		{Key: "name", Value: "Quimperlé"},
+		{Key: "population", Value: "11 500"},
+	}}
+	p, err := makePlaceJson(n, types, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.Id != "node/7" || p.Population != 11500 ||
+		p.ParentPath != "France > Bretagne > Quimperlé" {
+		t.Fatalf("unexpected place: %+v", p)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data),
+		`"shape":{"type":"point","coordinates":[-1.68,48.1]}`) {
+		t.Fatalf("unexpected document: %s", data)
+	}
+
+	n.Tags[0].Value = "hamlet"
+	p, err = makePlaceJson(n, types, lookup)
+	if err != nil || p != nil {
+		t.Fatalf("hamlet should be ignored: %+v, %v", p, err)
+	}
+}
+
+func TestIndexPlacesPipeline(t *testing.T) {
+	dir, input, db := runTestPipeline(t)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "places.jsonl")
+	runTestCommand(t, "indexplaces", input, db, output)
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PlaceJson{}
+	if err := json.Unmarshal(data, p); err != nil {
+		t.Fatalf("invalid place: %s\n%s", err, data)
+	}
+	if p.Id != "node/20" || len(p.Parents) != 2 ||
+		p.ParentPath != "Country > Region > Town" {
+		t.Fatalf("place has no enclosing boundary: %s", data)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// ScanStage is a step of a multi-pass command. Its handler receives the
+// elements of Kinds, then Done, if not nil, is called once all of them were
+// read and before later stages receive anything.
+type ScanStage struct {
+	Kinds   []int
+	Handler o5m.Handler
+	Done    func() error
+}
+
+func (s *ScanStage) minKind() int {
+	k := s.Kinds[0]
+	for _, kind := range s.Kinds[1:] {
+		if kind < k {
+			k = kind
+		}
+	}
+	return k
+}
+
+func (s *ScanStage) maxKind() int {
+	k := s.Kinds[0]
+	for _, kind := range s.Kinds[1:] {
+		if kind > k {
+			k = kind
+		}
+	}
+	return k
+}
+
+func (s *ScanStage) reads(kind int) bool {
+	for _, k := range s.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanPlan runs stages in order with as few sequential scans as possible.
+// Since elements are sorted by kind, nodes first, a stage reading only kinds
+// after those of the previous stages shares their scan. Otherwise a new scan
+// starts, from the first element of the stage kinds. The input is opened
+// once and kinds no stage reads are not decoded.
+type ScanPlan struct {
+	stages []*ScanStage
+}
+
+func (p *ScanPlan) Add(stage *ScanStage) {
+	p.stages = append(p.stages, stage)
+}
+
+// Passes returns the stages grouped by scan.
+func (p *ScanPlan) Passes() [][]*ScanStage {
+	passes := [][]*ScanStage{}
+	last := -1
+	for _, s := range p.stages {
+		if len(passes) == 0 || s.minKind() <= last {
+			passes = append(passes, nil)
+		}
+		passes[len(passes)-1] = append(passes[len(passes)-1], s)
+		last = s.maxKind()
+	}
+	return passes
+}
+
+func (p *ScanPlan) Run(ctx context.Context, path string) error {
+	for _, s := range p.stages {
+		if len(s.Kinds) == 0 {
+			return fmt.Errorf("scan stage without kinds")
+		}
+	}
+	read := map[int]bool{}
+	for _, s := range p.stages {
+		for _, k := range s.Kinds {
+			read[k] = true
+		}
+	}
+	ignored := []int{}
+	for _, k := range []int{NodeKind, WayKind, RelationKind} {
+		if !read[k] {
+			ignored = append(ignored, k)
+		}
+	}
+	r, err := OpenOSMReader(path, ignored...)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// Reset points preceding the first element of each kind, recorded by
+	// previous scans, save indexing the file to seek
+	starts := map[int]ResetPoint{}
+	for i, pass := range p.Passes() {
+		kind := pass[0].minKind()
+		// Seeking the first scan skips the previous kinds without decoding
+		// them, since they are read by later scans
+		if i > 0 || kind != NodeKind {
+			if start, ok := starts[kind]; ok {
+				err = r.Seek(start)
+			} else {
+				err = r.SeekToKind(kind)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		err = runScanPass(ctx, r, pass, starts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runScanPass(ctx context.Context, r OSMReader, pass []*ScanStage,
+	starts map[int]ResetPoint) error {
+
+	var last *ResetPoint
+	done := 0
+	complete := func(kind int) error {
+		for ; done < len(pass) && pass[done].maxKind() < kind; done++ {
+			if pass[done].Done != nil {
+				if err := pass[done].Done(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for r.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		kind := r.Kind()
+		switch kind {
+		case ResetKind:
+			p := r.ResetPoint()
+			last = &p
+			continue
+		case NodeKind, WayKind, RelationKind:
+		default:
+			continue
+		}
+		if _, ok := starts[kind]; !ok && last != nil {
+			starts[kind] = *last
+		}
+		if err := complete(kind); err != nil {
+			return err
+		}
+		if done >= len(pass) {
+			break
+		}
+		s := pass[done]
+		if !s.reads(kind) {
+			continue
+		}
+		var err error
+		switch kind {
+		case NodeKind:
+			err = s.Handler.HandleNode(r.Node())
+		case WayKind:
+			err = s.Handler.HandleWay(r.Way())
+		case RelationKind:
+			err = s.Handler.HandleRelation(r.Relation())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+	return complete(EndKind)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+func TestScanPlanPasses(t *testing.T) {
+	plan := &ScanPlan{}
+	for _, kinds := range [][]int{
+		{NodeKind},
+		{WayKind, RelationKind},
+		{RelationKind},
+		{NodeKind},
+		{WayKind},
+	} {
+		plan.Add(&ScanStage{Kinds: kinds})
+	}
+	sizes := []int{}
+	for _, pass := range plan.Passes() {
+		sizes = append(sizes, len(pass))
+	}
+	if !reflect.DeepEqual(sizes, []int{2, 1, 2}) {
+		t.Fatalf("unexpected passes: %v", sizes)
+	}
+}
+
+func TestScanPlanRun(t *testing.T) {
+	nodes := []Node{{Id: 1}, {Id: 2}}
+	ways := []Way{{Id: 10, Nodes: []int64{1, 2}}}
+	relations := []Relation{{Id: 20, Refs: []Ref{{Id: 10, Type: 1}}}}
+	path := writeTestFile(t, nodes, ways, relations)
+	defer os.Remove(path)
+
+	events := []string{}
+	handler := func(stage string) o5m.Handler {
+		return o5m.HandlerFuncs{
+			Node: func(n *Node) error {
+				events = append(events, fmt.Sprintf("%s:n%d", stage, n.Id))
+				return nil
+			},
+			Way: func(w *Way) error {
+				events = append(events, fmt.Sprintf("%s:w%d", stage, w.Id))
+				return nil
+			},
+			Relation: func(r *Relation) error {
+				events = append(events, fmt.Sprintf("%s:r%d", stage, r.Id))
+				return nil
+			},
+		}
+	}
+	done := func(stage string) func() error {
+		return func() error {
+			events = append(events, stage+":done")
+			return nil
+		}
+	}
+	plan := &ScanPlan{}
+	plan.Add(&ScanStage{Kinds: []int{WayKind}, Handler: handler("a"),
+		Done: done("a")})
+	plan.Add(&ScanStage{Kinds: []int{RelationKind}, Handler: handler("b"),
+		Done: done("b")})
+	plan.Add(&ScanStage{Kinds: []int{NodeKind, WayKind},
+		Handler: handler("c"), Done: done("c")})
+	if err := plan.Run(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"a:w10", "a:done", "b:r20", "b:done",
+		"c:n1", "c:n2", "c:w10", "c:done",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("unexpected events:\n%v\n!=\n%v", events, expected)
+	}
+
+	// Handler errors stop the plan
+	failed := fmt.Errorf("failed")
+	plan = &ScanPlan{}
+	plan.Add(&ScanStage{Kinds: []int{NodeKind}, Handler: o5m.HandlerFuncs{
+		Node: func(n *Node) error {
+			return failed
+		},
+	}})
+	if err := plan.Run(context.Background(), path); err != failed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// AdminArea is an administrative polygon used to locate points.
+type AdminArea struct {
+	Id          int64
+	Name        string
+	Level       int
+	Country     string
+	Coordinates [][][][]float64
+	bbox        *BBox
+}
+
+// Returns true if (lon, lat) lies in ring, using the even-odd rule.
+func isInRing(ring [][]float64, lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > lat) != (b[1] > lat) &&
+			lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Returns true if (lon, lat) lies in a polygon of coords and not in one of its
+// holes.
+func isInMultiPolygon(coords [][][][]float64, lon, lat float64) bool {
+	for _, poly := range coords {
+		if len(poly) == 0 || !isInRing(poly[0], lon, lat) {
+			continue
+		}
+		inHole := false
+		for _, inner := range poly[1:] {
+			if isInRing(inner, lon, lat) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AdminArea) Contains(lon, lat float64) bool {
+	if lon < a.bbox.MinLon || lon > a.bbox.MaxLon ||
+		lat < a.bbox.MinLat || lat > a.bbox.MaxLat {
+		return false
+	}
+	return isInMultiPolygon(a.Coordinates, lon, lat)
+}
+
+// AdminIndex buckets admin areas in a grid of one degree cells.
+type AdminIndex struct {
+	cells map[TileKey][]*AdminArea
+	Areas []*AdminArea
+}
+
+func NewAdminIndex() *AdminIndex {
+	return &AdminIndex{
+		cells: map[TileKey][]*AdminArea{},
+	}
+}
+
+func getAdminCell(lon, lat float64) TileKey {
+	return TileKey{
+		X: int(math.Floor(lon + 180)),
+		Y: int(math.Floor(lat + 90)),
+	}
+}
+
+func (idx *AdminIndex) Add(area *AdminArea) {
+	area.bbox = NewBBox()
+	area.bbox.AddMultiPolygon(area.Coordinates)
+	if area.bbox.empty {
+		return
+	}
+	min := getAdminCell(area.bbox.MinLon, area.bbox.MinLat)
+	max := getAdminCell(area.bbox.MaxLon, area.bbox.MaxLat)
+	for x := min.X; x <= max.X; x++ {
+		for y := min.Y; y <= max.Y; y++ {
+			k := TileKey{x, y}
+			idx.cells[k] = append(idx.cells[k], area)
+		}
+	}
+	idx.Areas = append(idx.Areas, area)
+}
+
+// Returns the areas containing (lon, lat), sorted by increasing admin level.
+func (idx *AdminIndex) Lookup(lon, lat float64) []*AdminArea {
+	found := []*AdminArea{}
+	for _, area := range idx.cells[getAdminCell(lon, lat)] {
+		if area.Contains(lon, lat) {
+			found = append(found, area)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Level != found[j].Level {
+			return found[i].Level < found[j].Level
+		}
+		return found[i].Id < found[j].Id
+	})
+	return found
+}
+
+// Loads the administrative boundaries with a location from db. Relations are
+// selected by ignoreRelation like the geojson command does.
+func loadAdminIndex(db *WaysDb) (*AdminIndex, error) {
+	ids, err := db.ListLocationIds()
+	if err != nil {
+		return nil, err
+	}
+	idx := NewAdminIndex()
+	for id := range ids {
+		rel, err := db.GetRelation(id)
+		if err != nil {
+			return nil, err
+		}
+		if rel == nil {
+			continue
+		}
+		if ok, err := ignoreRelation(rel); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		loc, err := db.GetLocation(id)
+		if err != nil {
+			return nil, err
+		}
+		if loc == nil {
+			continue
+		}
+		rt, err := NewRelationTags(rel)
+		if err != nil {
+			return nil, err
+		}
+		level, _ := rt.AdminLevel()
+		idx.Add(&AdminArea{
+			Id:          id,
+			Name:        rt.Name(),
+			Level:       level,
+			Country:     strings.ToUpper(rt.CountryIso2()),
+			Coordinates: loc.Coordinates,
+		})
+	}
+	return idx, nil
+}
+
+type AdminAreaJson struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	AdminLevel int    `json:"admin_level,omitempty"`
+}
+
+type PointGeometry struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// PointFeature is a RFC 7946 point feature.
+type PointFeature struct {
+	Type       string                 `json:"type"`
+	Id         string                 `json:"id"`
+	Geometry   PointGeometry          `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Builds a point feature for element kind/id, enriched with the admin areas
+// containing it.
+func makePointFeature(kind string, id int64, lon, lat float64,
+	tags []StringPair, meta *Metadata, idx *AdminIndex) *PointFeature {
+
+	props := map[string]interface{}{}
+	for _, tag := range tags {
+		props[tag.Key] = tag.Value
+	}
+	if exportMetadata {
+		if js := makeMetadataJson(meta); js != nil {
+			props["meta"] = js
+		}
+	}
+	admins := []AdminAreaJson{}
+	if idx != nil {
+		admins = lookupAdminAreas(idx, lon, lat)
+	}
+	props["admin"] = admins
+	return &PointFeature{
+		Type: "Feature",
+		Id:   fmt.Sprintf("%s/%d", kind, id),
+		Geometry: PointGeometry{
+			Type:        "Point",
+			Coordinates: []float64{lon, lat},
+		},
+		Properties: props,
+	}
+}
+
+// Returns a point inside closed ring, trying its barycenter first, then the
+// middle of the widest interior segment of the horizontal line crossing the
+// middle of the ring extent. The second value is false if no such point was
+// found.
+func computeRingCenter(ring [][]float64) ([]float64, bool) {
+	if len(ring) < 4 {
+		return nil, false
+	}
+	c := computeBarycenter(ring[1:])
+	if isInRing(ring, c[0], c[1]) {
+		return c, true
+	}
+	bbox := NewBBox()
+	for _, p := range ring {
+		bbox.Add(p[0], p[1])
+	}
+	lat := (bbox.MinLat + bbox.MaxLat) / 2
+	// Crossings use the same half-open rule as isInRing
+	xs := []float64{}
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > lat) != (b[1] > lat) {
+			xs = append(xs, (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0])
+		}
+	}
+	sort.Float64s(xs)
+	best := -1
+	for i := 0; i+1 < len(xs); i += 2 {
+		if best < 0 || xs[i+1]-xs[i] > xs[best+1]-xs[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, false
+	}
+	return []float64{(xs[best] + xs[best+1]) / 2, lat}, true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAdminIndexLookup(t *testing.T) {
+	idx := NewAdminIndex()
+	// A square with a hole, spanning several cells
+	idx.Add(&AdminArea{
+		Id:    1,
+		Name:  "country",
+		Level: 2,
+		Coordinates: [][][][]float64{{
+			{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+			{{1, 1}, {2, 1}, {2, 2}, {1, 2}, {1, 1}},
+		}},
+	})
+	idx.Add(&AdminArea{
+		Id:    2,
+		Name:  "city",
+		Level: 8,
+		Coordinates: [][][][]float64{{
+			{{3, 3}, {3.5, 3}, {3.5, 3.5}, {3, 3.5}, {3, 3}},
+		}},
+	})
+	idx.Add(&AdminArea{
+		Id:          3,
+		Name:        "empty",
+		Level:       4,
+		Coordinates: [][][][]float64{},
+	})
+	if len(idx.Areas) != 2 {
+		t.Fatalf("unexpected area count: %d", len(idx.Areas))
+	}
+	tests := []struct {
+		Lon, Lat float64
+		Ids      []int64
+	}{
+		{0.5, 0.5, []int64{1}},
+		{1.5, 1.5, nil},
+		{3.2, 3.2, []int64{1, 2}},
+		{5, 5, nil},
+		{-0.5, 2, nil},
+	}
+	for _, test := range tests {
+		found := idx.Lookup(test.Lon, test.Lat)
+		ids := []int64{}
+		for _, area := range found {
+			ids = append(ids, area.Id)
+		}
+		if len(ids) != len(test.Ids) {
+			t.Fatalf("%f,%f: unexpected areas: %v", test.Lon, test.Lat, ids)
+		}
+		for i, id := range ids {
+			if id != test.Ids[i] {
+				t.Fatalf("%f,%f: unexpected areas: %v", test.Lon, test.Lat, ids)
+			}
+		}
+	}
+	f := makePointFeature("node", 42, 3.2, 3.2, []StringPair{
+		{Key: "amenity", Value: "hospital"}}, &Metadata{Version: 3}, idx)
+	admins := f.Properties["admin"].([]AdminAreaJson)
+	if f.Id != "node/42" || len(admins) != 2 || admins[1].Name != "city" {
+		t.Fatalf("unexpected feature: %+v", f)
+	}
+	if _, ok := f.Properties["meta"]; ok {
+		t.Fatalf("metadata exported without --with-metadata")
+	}
+}
+
+func TestComputeRingCenter(t *testing.T) {
+	// Counter-clockwise U shape, whose barycenter lies outside
+	ring := [][]float64{{0, 0}, {3, 0}, {3, 3}, {2, 3}, {2, 1}, {1, 1}, {1, 3},
+		{0, 3}, {0, 0}}
+	for i := 0; i < 2; i++ {
+		c, ok := computeRingCenter(ring)
+		if !ok {
+			t.Fatalf("no center found")
+		}
+		if !isInRing(ring, c[0], c[1]) {
+			t.Fatalf("center is not in ring: %v", c)
+		}
+		reverseJsonRing(ring)
+	}
+}
+
+func TestPoisPipeline(t *testing.T) {
+	dir, input, db := runTestPipeline(t)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "pois.jsonl")
+	runTestCommand(t, "pois", input, db, output, "--filter", "amenity=cafe")
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feature := struct {
+		Id         string
+		Properties struct {
+			Admin []AdminAreaJson
+		}
+	}{}
+	if err := json.Unmarshal(data, &feature); err != nil {
+		t.Fatalf("invalid feature: %s\n%s", err, data)
+	}
+	expected := []AdminAreaJson{
+		{Id: "100", Name: "Country", AdminLevel: 2},
+		{Id: "101", Name: "Region", AdminLevel: 4},
+	}
+	if feature.Id != "node/21" ||
+		!reflect.DeepEqual(feature.Properties.Admin, expected) {
+		t.Fatalf("unexpected feature: %s", data)
+	}
+}
@@ -2,13 +2,11 @@ package main
 
 import (
 	"fmt"
-
-	"github.com/pmezard/gogeos/geos"
 )
 
 // Returns the inclusion matrix where h[i][j] is true if rings[i] contains
 // rings[j]. Rings do not contain themselves.
-func computeInclusion(rings []*geos.Geometry) ([][]bool, error) {
+func computeInclusion(rings []Geometry) ([][]bool, error) {
 	h := make([][]bool, len(rings))
 	for i, outer := range rings {
 		h[i] = make([]bool, len(rings))
@@ -39,13 +37,13 @@ func computeInclusion(rings []*geos.Geometry) ([][]bool, error) {
 
 type inclusionNode struct {
 	Id       int
-	Shape    *geos.Geometry
+	Shape    Geometry
 	Children []*inclusionNode
 }
 
 // Returns a (id -> node) map of the inclusion DAG generated from the inclusion
 // matrix.
-func makeInclusionGraph(contains [][]bool, geoms []*geos.Geometry) map[int]*inclusionNode {
+func makeInclusionGraph(contains [][]bool, geoms []Geometry) map[int]*inclusionNode {
 	nodes := map[int]*inclusionNode{}
 	for i, row := range contains {
 		n, ok := nodes[i]
@@ -129,7 +127,7 @@ func makeInclusionTree(root *inclusionNode) error {
 	return nil
 }
 
-func makeInclusionTrees(geoms []*geos.Geometry) ([]*inclusionNode, error) {
+func makeInclusionTrees(geoms []Geometry) ([]*inclusionNode, error) {
 	// TODO: merge this step with the previous one
 	h, err := computeInclusion(geoms)
 	if err != nil {
@@ -157,54 +155,40 @@ func makeInclusionTrees(geoms []*geos.Geometry) ([]*inclusionNode, error) {
 	return roots, nil
 }
 
-func createGeosSimplePolygon(ring *Linestring) (*geos.Geometry, error) {
+func createSimplePolygon(ring *Linestring) (Geometry, error) {
 	if len(ring.Points) < 4 {
 		panic("not enough points")
 	}
 	if ring.Points[0] != ring.Points[len(ring.Points)-1] {
 		panic("unclosed")
 	}
-	coords := make([]geos.Coord, len(ring.Points))
-	for i := range coords {
-		coords[i] = createGeosPoint(ring.Points[i])
-	}
-	poly, err := geos.NewPolygon(coords)
-	if err != nil {
-		return nil, err
-	}
-	// Poor man's solution to handle invalid polygons
-	return poly.Buffer(0)
+	return newValidPolygon(pointsToCoords(ring.Points))
 }
 
-func createGeosPolygon(outer *geos.Geometry, inners []*geos.Geometry) (
-	*geos.Geometry, error) {
+func createPolygon(outer Geometry, inners []Geometry) (Geometry, error) {
 	// Merge inner polygons with a single call to UnaryUnion, much faster than
 	// calling Union repeatedly.
-	collection, err := geos.NewCollection(geos.MULTIPOLYGON, inners...)
-	if err != nil {
-		return nil, err
-	}
-	merged, err := collection.UnaryUnion()
+	merged, err := unaryUnion(inners)
 	if err != nil {
 		return nil, err
 	}
 	return outer.Difference(merged)
 }
 
-func treesToPolygons(roots []*inclusionNode) ([]*geos.Geometry, error) {
-	polygons := []*geos.Geometry{}
+func treesToPolygons(roots []*inclusionNode) ([]Geometry, error) {
+	polygons := []Geometry{}
 	for len(roots) > 0 {
 		root := roots[len(roots)-1]
 		roots = roots[:len(roots)-1]
 		outer := root.Shape
-		inners := []*geos.Geometry{}
+		inners := []Geometry{}
 		for _, c := range root.Children {
 			inners = append(inners, c.Shape)
 			for _, cc := range c.Children {
 				roots = append(roots, cc)
 			}
 		}
-		p, err := createGeosPolygon(outer, inners)
+		p, err := createPolygon(outer, inners)
 		if err != nil {
 			return nil, err
 		}
@@ -220,11 +204,11 @@ func treesToPolygons(roots []*inclusionNode) ([]*geos.Geometry, error) {
 // overlap.
 // - Turn the roots and immediate children into outer and inner rings and recurse
 // on the new roots produced by children children.
-func makePolygons(rings []*Linestring) ([]*geos.Geometry, error) {
+func makePolygons(rings []*Linestring) ([]Geometry, error) {
 	// TODO: Fast-path trivial cases
-	geoms := []*geos.Geometry{}
+	geoms := []Geometry{}
 	for _, r := range rings {
-		g, err := createGeosSimplePolygon(r)
+		g, err := createSimplePolygon(r)
 		if err != nil {
 			return nil, fmt.Errorf("cannot make linear ring: %s", err)
 		}
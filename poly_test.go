@@ -7,14 +7,12 @@ import (
 	"io"
 	"strings"
 	"testing"
-
-	"github.com/pmezard/gogeos/geos"
 )
 
-func makeGeosPolygons(rings []*Linestring) []*geos.Geometry {
-	geoms := []*geos.Geometry{}
+func makePolygonGeometries(rings []*Linestring) []Geometry {
+	geoms := []Geometry{}
 	for _, r := range rings {
-		g, err := createGeosSimplePolygon(r)
+		g, err := createSimplePolygon(r)
 		if err != nil {
 			panic(err)
 		}
@@ -51,7 +49,7 @@ func printNode(n *inclusionNode, w io.Writer, prefix string) {
 }
 
 func printTrees(t *testing.T, rings []*Linestring) string {
-	geoms := makeGeosPolygons(rings)
+	geoms := makePolygonGeometries(rings)
 	nodes, err := makeInclusionTrees(geoms)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// polyRing answers point in ring queries by testing only the edges
+// overlapping the latitude band of the point. Country boundaries have
+// thousands of points and extracts test millions of nodes.
+type polyRing struct {
+	points     [][]float64
+	minLat     float64
+	bandHeight float64
+	// Indexes of the edges ending at each point, by band
+	bands [][]int
+}
+
+func newPolyRing(points [][]float64) *polyRing {
+	minLat, maxLat := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		minLat = math.Min(minLat, p[1])
+		maxLat = math.Max(maxLat, p[1])
+	}
+	count := len(points)/8 + 1
+	r := &polyRing{
+		points:     points,
+		minLat:     minLat,
+		bandHeight: (maxLat - minLat) / float64(count),
+		bands:      make([][]int, count),
+	}
+	for i, j := 0, len(points)-1; i < len(points); j, i = i, i+1 {
+		lo := math.Min(points[i][1], points[j][1])
+		hi := math.Max(points[i][1], points[j][1])
+		for b := r.band(lo); b <= r.band(hi); b++ {
+			r.bands[b] = append(r.bands[b], i)
+		}
+	}
+	return r
+}
+
+func (r *polyRing) band(lat float64) int {
+	if r.bandHeight <= 0 {
+		return 0
+	}
+	b := int((lat - r.minLat) / r.bandHeight)
+	if b < 0 {
+		return 0
+	}
+	if b >= len(r.bands) {
+		return len(r.bands) - 1
+	}
+	return b
+}
+
+// Same crossing test as isInRing, restricted to the edges of lat band.
+func (r *polyRing) Contains(lon, lat float64) bool {
+	inside := false
+	n := len(r.points)
+	for _, i := range r.bands[r.band(lat)] {
+		a, b := r.points[i], r.points[(i+n-1)%n]
+		if (a[1] > lat) != (b[1] > lat) &&
+			lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// PolyFile is an Osmosis polygon filter file. Holes are removed from the
+// union of outer rings.
+type PolyFile struct {
+	Name   string
+	Outers []*polyRing
+	Holes  []*polyRing
+	bbox   *BBox
+}
+
+// Parses the Osmosis polygon filter file format:
+//
+//	name
+//	1
+//	   lon lat
+//	   ...
+//	END
+//	!2
+//	   ...
+//	END
+//	END
+//
+// Sections starting with "!" are holes.
+func parsePolyFile(r io.Reader) (*PolyFile, error) {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	next := func() (string, bool) {
+		for scanner.Scan() {
+			lineNum++
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				return line, true
+			}
+		}
+		return "", false
+	}
+	name, ok := next()
+	if !ok {
+		return nil, fmt.Errorf("empty polygon file")
+	}
+	poly := &PolyFile{
+		Name: name,
+		bbox: NewBBox(),
+	}
+	for {
+		section, ok := next()
+		if !ok {
+			return nil, fmt.Errorf("missing final END")
+		}
+		if section == "END" {
+			break
+		}
+		points := [][]float64{}
+		for {
+			line, ok := next()
+			if !ok {
+				return nil, fmt.Errorf("section %s is missing END", section)
+			}
+			if line == "END" {
+				break
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid coordinates at line %d: %q",
+					lineNum, line)
+			}
+			lon, err1 := strconv.ParseFloat(fields[0], 64)
+			lat, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid coordinates at line %d: %q",
+					lineNum, line)
+			}
+			points = append(points, []float64{lon, lat})
+		}
+		if len(points) < 3 {
+			return nil, fmt.Errorf("section %s has less than 3 points", section)
+		}
+		ring := newPolyRing(points)
+		if strings.HasPrefix(section, "!") {
+			poly.Holes = append(poly.Holes, ring)
+			continue
+		}
+		poly.Outers = append(poly.Outers, ring)
+		for _, p := range points {
+			poly.bbox.Add(p[0], p[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(poly.Outers) == 0 {
+		return nil, fmt.Errorf("polygon file has no outer ring")
+	}
+	return poly, nil
+}
+
+func readPolyFile(path string) (*PolyFile, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	poly, err := parsePolyFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", path, err)
+	}
+	return poly, nil
+}
+
+func (p *PolyFile) BoundingBox() BoundingBox {
+	return p.bbox.BoundingBox()
+}
+
+func (p *PolyFile) Contains(lon, lat float64) bool {
+	if lon < p.bbox.MinLon || lon > p.bbox.MaxLon ||
+		lat < p.bbox.MinLat || lat > p.bbox.MaxLat {
+		return false
+	}
+	inside := false
+	for _, r := range p.Outers {
+		if r.Contains(lon, lat) {
+			inside = true
+			break
+		}
+	}
+	if !inside {
+		return false
+	}
+	for _, r := range p.Holes {
+		if r.Contains(lon, lat) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a function telling whether o5m fixed point coordinates are in p.
+func containsInPoly(p *PolyFile) func(lon, lat int64) bool {
+	return func(lon, lat int64) bool {
+		return p.Contains(float64(lon)/1e7, float64(lat)/1e7)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const testPolyFile = `square_with_hole
+first_area
+   0.0E+00   0.0E+00
+   10 0
+   10 10
+   0 10
+END
+!hole
+   4 4
+   6 4
+   6 6
+   4 6
+END
+second_area
+   20 0
+   30 0
+   25 10
+END
+END
+`
+
+func TestParsePolyFile(t *testing.T) {
+	poly, err := parsePolyFile(strings.NewReader(testPolyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poly.Name != "square_with_hole" || len(poly.Outers) != 2 ||
+		len(poly.Holes) != 1 {
+		t.Fatalf("unexpected polygon: %s %d %d", poly.Name, len(poly.Outers),
+			len(poly.Holes))
+	}
+	if bb := poly.BoundingBox(); bb != (BoundingBox{X1: 0, Y1: 0, X2: 30, Y2: 10}) {
+		t.Fatalf("unexpected bounding box: %+v", bb)
+	}
+	tests := []struct {
+		Lon, Lat float64
+		Inside   bool
+	}{
+		{1, 1, true},
+		{5, 5, false},
+		{7, 5, true},
+		{15, 5, false},
+		{25, 5, true},
+		{21, 9, false},
+		{-1, 5, false},
+		{5, 11, false},
+	}
+	for _, test := range tests {
+		if poly.Contains(test.Lon, test.Lat) != test.Inside {
+			t.Fatalf("unexpected containment for %v,%v", test.Lon, test.Lat)
+		}
+	}
+}
+
+func TestPolyRingMatchesIsInRing(t *testing.T) {
+	// A star with many points spreads edges over several bands
+	points := [][]float64{}
+	for i := 0; i < 40; i++ {
+		r := 10.0
+		if i%2 == 1 {
+			r = 4
+		}
+		a := float64(i) * 2 * math.Pi / 40
+		points = append(points, []float64{r * math.Cos(a), r * math.Sin(a)})
+	}
+	ring := newPolyRing(points)
+	for x := -11.0; x <= 11; x += 0.37 {
+		for y := -11.0; y <= 11; y += 0.41 {
+			if ring.Contains(x, y) != isInRing(points, x, y) {
+				t.Fatalf("containment mismatch for %v,%v", x, y)
+			}
+		}
+	}
+}
+
+func TestParsePolyFileErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"name\n1\n0 0\n1 0\n1 1\nEND\n",
+		"name\n1\n0 0\n1 0\nEND\nEND\n",
+		"name\n1\n0 0 0\n1 0\n1 1\nEND\nEND\n",
+		"name\n!1\n0 0\n1 0\n1 1\nEND\nEND\n",
+		"name\n1\n0 0\n1 0\n1 x\nEND\nEND\n",
+	}
+	for _, test := range tests {
+		if _, err := parsePolyFile(strings.NewReader(test)); err == nil {
+			t.Fatalf("invalid polygon file accepted: %q", test)
+		}
+	}
+}
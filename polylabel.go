@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+)
+
+// Centroid methods of indexcenters
+const (
+	CentroidHeuristic = "heuristic"
+	CentroidPolylabel = "polylabel"
+)
+
+var (
+	centroidMethod = CentroidHeuristic
+)
+
+// Returns the signed distance from (x, y) to the boundary of poly rings,
+// positive inside the polygon and negative outside or in holes.
+func polygonSignedDistance(x, y float64, poly [][][]float64) float64 {
+	p := []float64{x, y}
+	inside := false
+	minDist := math.Inf(1)
+	for _, ring := range poly {
+		if isInRing(ring, x, y) {
+			inside = !inside
+		}
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			minDist = math.Min(minDist, segmentDistance(p, ring[j], ring[i]))
+		}
+	}
+	if !inside {
+		return -minDist
+	}
+	return minDist
+}
+
+type labelCell struct {
+	X, Y float64
+	// Half the cell size
+	H float64
+	// Distance from the cell center to the polygon
+	D float64
+	// Maximum distance to the polygon of a point in the cell
+	Max float64
+}
+
+func newLabelCell(x, y, h float64, poly [][][]float64) *labelCell {
+	d := polygonSignedDistance(x, y, poly)
+	return &labelCell{X: x, Y: y, H: h, D: d, Max: d + h*math.Sqrt2}
+}
+
+// labelCells is a max-heap of cells by potential distance.
+type labelCells []*labelCell
+
+func (c labelCells) Len() int            { return len(c) }
+func (c labelCells) Less(i, j int) bool  { return c[i].Max > c[j].Max }
+func (c labelCells) Swap(i, j int)       { c[i], c[j] = c[j], c[i] }
+func (c *labelCells) Push(x interface{}) { *c = append(*c, x.(*labelCell)) }
+
+func (c *labelCells) Pop() interface{} {
+	old := *c
+	cell := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return cell
+}
+
+// Returns a cell at the area centroid of ring, a good first guess.
+func ringCentroidCell(poly [][][]float64) *labelCell {
+	ring := poly[0]
+	area, x, y := 0., 0., 0.
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		f := a[0]*b[1] - b[0]*a[1]
+		x += (a[0] + b[0]) * f
+		y += (a[1] + b[1]) * f
+		area += f * 3
+	}
+	if area == 0 {
+		return newLabelCell(ring[0][0], ring[0][1], 0, poly)
+	}
+	return newLabelCell(x/area, y/area, 0, poly)
+}
+
+// Returns the pole of inaccessibility of poly, its interior point farthest
+// from the boundary, holes included, within precision, using the Mapbox
+// polylabel algorithm. The polygon bounding box is covered with square cells
+// refined in order of the best distance they can contain, until no cell can
+// improve the best point by more than precision.
+func polylabel(poly [][][]float64, precision float64) []float64 {
+	bbox := NewBBox()
+	for _, p := range poly[0] {
+		bbox.Add(p[0], p[1])
+	}
+	width := bbox.MaxLon - bbox.MinLon
+	height := bbox.MaxLat - bbox.MinLat
+	size := math.Min(width, height)
+	if size == 0 {
+		return []float64{bbox.MinLon, bbox.MinLat}
+	}
+	cells := &labelCells{}
+	h := size / 2
+	for x := bbox.MinLon; x < bbox.MaxLon; x += size {
+		for y := bbox.MinLat; y < bbox.MaxLat; y += size {
+			heap.Push(cells, newLabelCell(x+h, y+h, h, poly))
+		}
+	}
+	best := ringCentroidCell(poly)
+	center := newLabelCell(bbox.MinLon+width/2, bbox.MinLat+height/2, 0, poly)
+	if center.D > best.D {
+		best = center
+	}
+	for cells.Len() > 0 {
+		cell := heap.Pop(cells).(*labelCell)
+		if cell.D > best.D {
+			best = cell
+		}
+		if cell.Max-best.D <= precision {
+			continue
+		}
+		h := cell.H / 2
+		for _, d := range [][2]float64{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}} {
+			heap.Push(cells, newLabelCell(cell.X+d[0]*h, cell.Y+d[1]*h, h, poly))
+		}
+	}
+	return []float64{best.X, best.Y}
+}
+
+// Returns the polylabel centroid of poly, with a precision relative to its
+// size.
+func computePolylabelCentroid(poly [][][]float64) *Centroid {
+	bbox := NewBBox()
+	for _, p := range poly[0] {
+		bbox.Add(p[0], p[1])
+	}
+	size := math.Max(bbox.MaxLon-bbox.MinLon, bbox.MaxLat-bbox.MinLat)
+	p := polylabel(poly, math.Max(size*1e-4, 1e-7))
+	return &Centroid{
+		Lon: p[0],
+		Lat: p[1],
+	}
+}
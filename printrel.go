@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+var refTypeNames = []string{"node", "way", "relation"}
+
+type refKey struct {
+	Type int
+	Id   int64
+}
+
+// RelationDump holds a relation with what is known of its members and, when
+// read from a db, its stored location and centroid.
+type RelationDump struct {
+	Relation *Relation
+	// Names of the members found in the input, empty for unnamed ones
+	Members  map[refKey]string
+	Location *Location
+	Centroid *Centroid
+	refs     map[refKey]bool
+}
+
+func newRelationDump(rel *Relation) *RelationDump {
+	d := &RelationDump{
+		Relation: rel.Clone(),
+		Members:  map[refKey]string{},
+		refs:     map[refKey]bool{},
+	}
+	for _, ref := range rel.Refs {
+		d.refs[refKey{Type: ref.Type, Id: ref.Id}] = true
+	}
+	return d
+}
+
+// Wants returns true if the element of type typ and id is a member.
+func (d *RelationDump) Wants(typ int, id int64) bool {
+	return d.refs[refKey{Type: typ, Id: id}]
+}
+
+func (d *RelationDump) AddMember(typ int, id int64, tags []StringPair) {
+	name, _ := findTag(tags, "name")
+	d.Members[refKey{Type: typ, Id: id}] = name
+}
+
+func formatRefType(typ int) string {
+	if typ >= 0 && typ < len(refTypeNames) {
+		return refTypeNames[typ]
+	}
+	return fmt.Sprintf("type%d", typ)
+}
+
+func (d *RelationDump) Print(w io.Writer) {
+	rel := d.Relation
+	fmt.Fprintf(w, "relation %d", rel.Id)
+	if meta := formatMetadata(&rel.Meta); meta != "" {
+		fmt.Fprint(w, meta)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "tags (%d):\n", len(rel.Tags))
+	for _, tag := range rel.Tags {
+		fmt.Fprintf(w, "  %s=%s\n", tag.Key, tag.Value)
+	}
+	missing := 0
+	fmt.Fprintf(w, "members (%d):\n", len(rel.Refs))
+	for _, ref := range rel.Refs {
+		fmt.Fprintf(w, "  %s %d", formatRefType(ref.Type), ref.Id)
+		if ref.Role != "" {
+			fmt.Fprintf(w, " role=%s", ref.Role)
+		}
+		name, ok := d.Members[refKey{Type: ref.Type, Id: ref.Id}]
+		if !ok {
+			missing++
+			fmt.Fprint(w, " missing")
+		} else if name != "" {
+			fmt.Fprintf(w, " name=%q", name)
+		}
+		fmt.Fprintln(w)
+	}
+	if missing > 0 {
+		fmt.Fprintf(w, "missing members: %d\n", missing)
+	}
+	if d.Location != nil {
+		polygons := len(d.Location.Coordinates)
+		rings := 0
+		points := 0
+		for _, poly := range d.Location.Coordinates {
+			rings += len(poly)
+			for _, ring := range poly {
+				points += len(ring)
+			}
+		}
+		fmt.Fprintf(w, "location: %s polygons=%d rings=%d points=%d "+
+			"area_km2=%.3f perimeter_km=%.3f\n", d.Location.Type, polygons,
+			rings, points, d.Location.AreaKm2, d.Location.PerimeterKm)
+	}
+	if c := d.Centroid; c != nil {
+		fmt.Fprintf(w, "centroid: %f %f", c.Lon, c.Lat)
+		if c.NodeId > 0 {
+			fmt.Fprintf(w, " node=%d", c.NodeId)
+		}
+		if c.Source != "" {
+			fmt.Fprintf(w, " source=%s", c.Source)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// Returns way id as a GeoJSON LineString feature with its tags as
+// properties, plus the number of nodes which could not be resolved.
+func makeWayFeature(id int64, tags []StringPair, points []Point,
+	missing int) debugFeature {
+
+	props := map[string]interface{}{}
+	for _, tag := range tags {
+		props[tag.Key] = tag.Value
+	}
+	if missing > 0 {
+		props["missing_nodes"] = missing
+	}
+	return debugFeature{
+		Type: "Feature",
+		Id:   fmt.Sprintf("way/%d", id),
+		Geometry: debugGeometry{
+			Type:        "LineString",
+			Coordinates: pointsToJson(points),
+		},
+		Properties: props,
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestRelationDump(t *testing.T) {
+	rel := &Relation{
+		Id: 5,
+		Refs: []Ref{
+			{Id: 10, Type: 1, Role: "outer"},
+			{Id: 1, Type: 0, Role: "admin_centre"},
+			{Id: 7, Type: 2, Role: "subarea"},
+		},
+		Tags: []StringPair{{Key: "type", Value: "boundary"}},
+	}
+	d := newRelationDump(rel)
+	if !d.Wants(1, 10) || d.Wants(0, 10) {
+		t.Fatal("unexpected members")
+	}
+	d.AddMember(1, 10, nil)
+	d.AddMember(0, 1, []StringPair{{Key: "name", Value: "Town"}})
+	d.Location = &Location{
+		Type:        "multipolygon",
+		Coordinates: [][][][]float64{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+	}
+	d.Centroid = &Centroid{Lon: 1, Lat: 2, NodeId: 1, Source: CenterSourceAdminCentre}
+	buf := &bytes.Buffer{}
+	d.Print(buf)
+	expected := `relation 5
+tags (1):
+  type=boundary
+members (3):
+  way 10 role=outer
+  node 1 role=admin_centre name="Town"
+  relation 7 role=subarea missing
+missing members: 1
+location: multipolygon polygons=1 rings=1 points=4 area_km2=0.000 perimeter_km=0.000
+centroid: 1.000000 2.000000 node=1 source=admin_centre
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected dump:\n%s", buf.String())
+	}
+}
+
+func TestWayFeature(t *testing.T) {
+	f := makeWayFeature(10, []StringPair{{Key: "highway", Value: "road"}},
+		[]Point{{Lon: 10000000, Lat: 20000000}, {Lon: 15000000, Lat: 20000000}}, 1)
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"Feature","id":"way/10","geometry":{"type":"LineString",` +
+		`"coordinates":[[1,2],[1.5,2]]},"properties":{"highway":"road",` +
+		`"missing_nodes":1}}`
+	if string(data) != expected {
+		t.Fatalf("unexpected feature: %s", data)
+	}
+}
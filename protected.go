@@ -0,0 +1,30 @@
+package main
+
+const (
+	// Selects protected areas and national parks, which are otherwise
+	// rejected as non-administrative boundaries.
+	protectedAreasExpr = "boundary=protected_area or boundary=national_park"
+)
+
+var (
+	// When set, relations are selected by protectedAreasExpr and exported
+	// with their protection details.
+	protectedAreas = false
+)
+
+// ProtectedAreaJson holds protected area details of an exported relation.
+type ProtectedAreaJson struct {
+	Boundary     string `json:"boundary"`
+	ProtectClass string `json:"protect_class,omitempty"`
+	Title        string `json:"protection_title,omitempty"`
+	Operator     string `json:"operator,omitempty"`
+}
+
+func makeProtectedAreaJson(rt *RelationTags) *ProtectedAreaJson {
+	return &ProtectedAreaJson{
+		Boundary:     rt.Tag("boundary"),
+		ProtectClass: rt.Tag("protect_class"),
+		Title:        rt.Tag("protection_title"),
+		Operator:     rt.Tag("operator"),
+	}
+}
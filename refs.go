@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+)
+
+// IdSet is a set of element ids stored as a sorted slice, much smaller than
+// a map for the millions of ids of large files.
+type IdSet struct {
+	ids    []int64
+	sorted bool
+}
+
+func (s *IdSet) Add(id int64) {
+	if n := len(s.ids); n > 0 && s.ids[n-1] > id {
+		s.sorted = false
+	} else if n == 0 {
+		s.sorted = true
+	}
+	s.ids = append(s.ids, id)
+}
+
+func (s *IdSet) Len() int {
+	return len(s.ids)
+}
+
+func (s *IdSet) Contains(id int64) bool {
+	if !s.sorted {
+		// o5m files are usually sorted, so this rarely happens
+		sort.Slice(s.ids, func(i, j int) bool { return s.ids[i] < s.ids[j] })
+		s.sorted = true
+	}
+	i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+	return i < len(s.ids) && s.ids[i] == id
+}
+
+// MissingRefs lists the references of an element which cannot be resolved,
+// by referenced element kind.
+type MissingRefs struct {
+	Nodes     []int64
+	Ways      []int64
+	Relations []int64
+}
+
+func (m *MissingRefs) Len() int {
+	return len(m.Nodes) + len(m.Ways) + len(m.Relations)
+}
+
+func findMissingWayRefs(way *Way, nodes *IdSet) *MissingRefs {
+	missing := &MissingRefs{}
+	for _, id := range way.Nodes {
+		if !nodes.Contains(id) {
+			missing.Nodes = append(missing.Nodes, id)
+		}
+	}
+	return missing
+}
+
+func findMissingRelationRefs(rel *Relation, nodes, ways,
+	relations *IdSet) *MissingRefs {
+
+	missing := &MissingRefs{}
+	for _, ref := range rel.Refs {
+		switch ref.Type {
+		case 0:
+			if !nodes.Contains(ref.Id) {
+				missing.Nodes = append(missing.Nodes, ref.Id)
+			}
+		case 1:
+			if !ways.Contains(ref.Id) {
+				missing.Ways = append(missing.Ways, ref.Id)
+			}
+		case 2:
+			if !relations.Contains(ref.Id) {
+				missing.Relations = append(missing.Relations, ref.Id)
+			}
+		}
+	}
+	return missing
+}
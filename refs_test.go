@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdSet(t *testing.T) {
+	s := &IdSet{}
+	for _, id := range []int64{5, 1, 9, 3} {
+		s.Add(id)
+	}
+	for _, id := range []int64{1, 3, 5, 9} {
+		if !s.Contains(id) {
+			t.Fatalf("%d not found", id)
+		}
+	}
+	for _, id := range []int64{0, 2, 10} {
+		if s.Contains(id) {
+			t.Fatalf("%d found", id)
+		}
+	}
+}
+
+func TestFindMissingRefs(t *testing.T) {
+	nodes := &IdSet{}
+	ways := &IdSet{}
+	relations := &IdSet{}
+	for _, id := range []int64{1, 2, 3} {
+		nodes.Add(id)
+	}
+	ways.Add(10)
+	relations.Add(100)
+	missing := findMissingWayRefs(&Way{Id: 10, Nodes: []int64{1, 4, 3, 5}},
+		nodes)
+	if !reflect.DeepEqual(missing.Nodes, []int64{4, 5}) || missing.Len() != 2 {
+		t.Fatalf("unexpected missing way nodes: %+v", missing)
+	}
+	rel := &Relation{
+		Id: 100,
+		Refs: []Ref{
+			{Id: 1, Type: 0, Role: "admin_centre"},
+			{Id: 6, Type: 0, Role: "label"},
+			{Id: 10, Type: 1, Role: "outer"},
+			{Id: 11, Type: 1, Role: "outer"},
+			{Id: 100, Type: 2, Role: "subarea"},
+			{Id: 101, Type: 2, Role: "subarea"},
+		},
+	}
+	missing = findMissingRelationRefs(rel, nodes, ways, relations)
+	expected := &MissingRefs{
+		Nodes:     []int64{6},
+		Ways:      []int64{11},
+		Relations: []int64{101},
+	}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Fatalf("unexpected missing relation refs: %+v", missing)
+	}
+}
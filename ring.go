@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
-
-	"github.com/pmezard/gogeos/geos"
+	"hash/fnv"
+	"math"
+	"sort"
 )
 
 type Linestring struct {
@@ -22,8 +24,12 @@ func (ls *Linestring) End() Point {
 }
 
 func (ls *Linestring) Clone() *Linestring {
-	points := make([]Point, len(ls.Points))
-	copy(points, ls.Points)
+	return ls.CloneIn(nil)
+}
+
+// Like Clone() but allocates points from the supplied arena.
+func (ls *Linestring) CloneIn(arena *pointArena) *Linestring {
+	points := arena.Copy(ls.Points)
 	return &Linestring{
 		Id:     ls.Id,
 		Role:   ls.Role,
@@ -39,27 +45,258 @@ func (ls *Linestring) Reverse() {
 	}
 }
 
-func buildLinestring(way *Way, nodes NodePoints) (*Linestring, error) {
+// Removes consecutive duplicate points, which make GEOS reject otherwise
+// valid rings as non-simple. Returns the number of removed points.
+func (ls *Linestring) RemoveRepeatedPoints() int {
+	if len(ls.Points) < 2 {
+		return 0
+	}
+	points := ls.Points[:1]
+	for _, p := range ls.Points[1:] {
+		if p != points[len(points)-1] {
+			points = append(points, p)
+		}
+	}
+	removed := len(ls.Points) - len(points)
+	ls.Points = points
+	return removed
+}
+
+// Removes consecutive duplicate points from all lines and drops lines reduced
+// to a single point. Returns the kept lines and the number of removed points.
+func removeRepeatedPoints(lines []*Linestring) ([]*Linestring, int) {
+	removed := 0
+	kept := lines[:0]
+	for _, line := range lines {
+		removed += line.RemoveRepeatedPoints()
+		if len(line.Points) < 2 {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept, removed
+}
+
+// Moves the unmatched endpoints of lines, those shared by an odd number of
+// line ends, onto the closest other unmatched endpoint within tolerance
+// degrees, so small gaps between ways do not prevent rings from closing.
+// Returns the number of bridged gaps.
+func snapEndpoints(lines []*Linestring, tolerance float64) int {
+	if tolerance <= 0 {
+		return 0
+	}
+	counts := map[Point]int{}
+	for _, line := range lines {
+		if len(line.Points) == 0 {
+			continue
+		}
+		counts[line.Start()]++
+		counts[line.End()]++
+	}
+	dangling := []Point{}
+	for p, n := range counts {
+		if n%2 != 0 {
+			dangling = append(dangling, p)
+		}
+	}
+	sort.Slice(dangling, func(i, j int) bool {
+		return pointLess(dangling[i], dangling[j])
+	})
+	tol := tolerance * 1e7
+	snapped := map[Point]Point{}
+	used := make([]bool, len(dangling))
+	for i, p := range dangling {
+		if used[i] {
+			continue
+		}
+		best := -1
+		bestDist := 0.
+		for j := i + 1; j < len(dangling); j++ {
+			q := dangling[j]
+			dx := float64(q.Lon - p.Lon)
+			if dx > tol {
+				// Sorted by longitude, the next ones are even further
+				break
+			}
+			dy := float64(q.Lat - p.Lat)
+			d := math.Sqrt(dx*dx + dy*dy)
+			if used[j] || d > tol || (best >= 0 && d >= bestDist) {
+				continue
+			}
+			best = j
+			bestDist = d
+		}
+		if best < 0 {
+			continue
+		}
+		used[i] = true
+		used[best] = true
+		snapped[dangling[best]] = p
+	}
+	for _, line := range lines {
+		if len(line.Points) == 0 {
+			continue
+		}
+		if p, ok := snapped[line.Start()]; ok {
+			line.Points[0] = p
+		}
+		if p, ok := snapped[line.End()]; ok {
+			line.Points[len(line.Points)-1] = p
+		}
+	}
+	return len(snapped)
+}
+
+// Policies applied by indexways to ways referencing missing nodes.
+const (
+	MissingNodesError      = "error"
+	MissingNodesSkip       = "skip"
+	MissingNodesSubstitute = "substitute"
+)
+
+var (
+	missingNodesPolicy = MissingNodesError
+)
+
+// Returns the linestring of way. Missing nodes are reported with a
+// *NodeNotFoundError, unless missingNodesPolicy is MissingNodesSubstitute,
+// in which case they are replaced with the closest resolved node of the way,
+// preceding them if possible.
+func buildLinestring(way *Way, nodes NodeStore) (*Linestring, error) {
 	points := make([]Point, len(way.Nodes))
+	var missing []int
+	var notFound *NodeNotFoundError
 	for i, n := range way.Nodes {
 		p, err := nodes.FindPoint(n)
 		if err != nil {
-			return nil, err
+			e, ok := err.(*NodeNotFoundError)
+			if !ok || missingNodesPolicy != MissingNodesSubstitute {
+				return nil, err
+			}
+			missing = append(missing, i)
+			notFound = e
+			continue
 		}
 		points[i] = p.Point
 	}
+	if len(missing) > 0 {
+		if len(missing) == len(points) {
+			return nil, notFound
+		}
+		// Repeated node ids, like ring ends, get the same substitute
+		substitutes := map[int64]Point{}
+		for _, i := range missing {
+			p, ok := substitutes[way.Nodes[i]]
+			if !ok {
+				p = findSubstitutePoint(points, missing, i)
+				substitutes[way.Nodes[i]] = p
+			}
+			points[i] = p
+		}
+	}
 	return &Linestring{
 		Id:     way.Id,
 		Points: points,
 	}, nil
 }
 
+// Returns the resolved point closest to index i, preceding it if possible.
+// missing are the sorted indices of unresolved points.
+func findSubstitutePoint(points []Point, missing []int, i int) Point {
+	isMissing := func(j int) bool {
+		k := sort.SearchInts(missing, j)
+		return k < len(missing) && missing[k] == j
+	}
+	for d := 1; d < len(points); d++ {
+		if j := i - d; j >= 0 && !isMissing(j) {
+			return points[j]
+		}
+		if j := i + d; j < len(points) && !isMissing(j) {
+			return points[j]
+		}
+	}
+	return Point{}
+}
+
+func pointLess(p1, p2 Point) bool {
+	return p1.Lon < p2.Lon || (p1.Lon == p2.Lon && p1.Lat < p2.Lat)
+}
+
+// Returns a hash of line geometry independent of its orientation.
+func hashLineGeometry(line *Linestring) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 16)
+	write := func(p Point) {
+		binary.LittleEndian.PutUint64(buf, uint64(p.Lon))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(p.Lat))
+		h.Write(buf)
+	}
+	n := len(line.Points)
+	if n > 0 && pointLess(line.End(), line.Start()) {
+		for i := n - 1; i >= 0; i-- {
+			write(line.Points[i])
+		}
+	} else {
+		for _, p := range line.Points {
+			write(p)
+		}
+	}
+	return h.Sum64()
+}
+
+func sameLineGeometry(l1, l2 *Linestring) bool {
+	n := len(l1.Points)
+	if n != len(l2.Points) {
+		return false
+	}
+	forward, backward := true, true
+	for i, p := range l1.Points {
+		forward = forward && p == l2.Points[i]
+		backward = backward && p == l2.Points[n-1-i]
+		if !forward && !backward {
+			return false
+		}
+	}
+	return true
+}
+
+// Removes lines appearing more than once, either with the same id or the
+// same geometry regardless of orientation. Duplicates would otherwise
+// prevent rings from closing. Returns the kept lines and the number of
+// dropped ones.
+func dedupLines(lines []*Linestring) ([]*Linestring, int) {
+	ids := map[int64]bool{}
+	hashes := map[uint64][]*Linestring{}
+	kept := make([]*Linestring, 0, len(lines))
+	for _, line := range lines {
+		if ids[line.Id] {
+			continue
+		}
+		ids[line.Id] = true
+		h := hashLineGeometry(line)
+		duplicate := false
+		for _, other := range hashes[h] {
+			if sameLineGeometry(line, other) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		hashes[h] = append(hashes[h], line)
+		kept = append(kept, line)
+	}
+	return kept, len(lines) - len(kept)
+}
+
 // RingParts is used to iteratively add lines together to form a ring.
 type RingParts struct {
 	parts []*Linestring
 	start Point
 	end   Point
 	role  string
+	arena *pointArena
 }
 
 func (r *RingParts) Start() Point {
@@ -78,7 +315,7 @@ func (r *RingParts) IsClosed() bool {
 // Add a Linestring to the current set. Panic if linestring does not start or
 // end with the current end point. Input Linestring is copied.
 func (r *RingParts) Push(p *Linestring) {
-	p = p.Clone()
+	p = p.CloneIn(r.arena)
 	if p.End() == r.end {
 		p.Reverse()
 	}
@@ -111,20 +348,24 @@ func makeEndpoints(lines []*Linestring) map[Point][]*Linestring {
 	return endPoints
 }
 
-func mergeLines(l1, l2 *Linestring) {
+func mergeLines(l1, l2 *Linestring, arena *pointArena) {
 	if l1.Start() == l2.Start() || l1.End() == l2.End() {
 		l2.Reverse()
 	}
+	points := arena.Alloc(len(l1.Points) + len(l2.Points) - 1)
 	if l1.End() == l2.Start() {
-		l1.Points = append(l1.Points, l2.Points[1:]...)
+		n := copy(points, l1.Points)
+		copy(points[n:], l2.Points[1:])
 	} else if l1.Start() == l2.End() {
-		l1.Points = append(l2.Points, l1.Points[1:]...)
+		n := copy(points, l2.Points)
+		copy(points[n:], l1.Points[1:])
 	} else {
 		panic("unrelated lines")
 	}
+	l1.Points = points
 }
 
-func mergeArcs(lines []*Linestring) []*Linestring {
+func mergeArcs(lines []*Linestring, arena *pointArena) []*Linestring {
 	endPoints := map[Point][]int{}
 	for i, line := range lines {
 		start := line.Start()
@@ -144,7 +385,7 @@ func mergeArcs(lines []*Linestring) []*Linestring {
 			continue
 		}
 		uf.Merge(i, j)
-		mergeLines(lines[i], lines[j])
+		mergeLines(lines[i], lines[j], arena)
 		lines[uf.Find(i)] = lines[i]
 	}
 	kept := []*Linestring{}
@@ -165,7 +406,16 @@ func (r *RingParts) MakeRing() *Linestring {
 	if len(r.parts) == 0 {
 		panic("ring has no part")
 	}
-	base := r.parts[0].Clone()
+	size := len(r.parts[0].Points)
+	for _, other := range r.parts[1:] {
+		size += len(other.Points) - 1
+	}
+	base := &Linestring{
+		Id:     r.parts[0].Id,
+		Role:   r.parts[0].Role,
+		Points: r.arena.Alloc(size)[:0],
+	}
+	base.Points = append(base.Points, r.parts[0].Points...)
 	for _, other := range r.parts[1:] {
 		if base.End() != other.Start() {
 			panic("parts are not linked")
@@ -181,70 +431,165 @@ func (r *RingParts) MakeRing() *Linestring {
 	return base
 }
 
-func createGeosPoint(p Point) geos.Coord {
-	return geos.Coord{
-		X: float64(p.Lon) / 1e7,
-		Y: float64(p.Lat) / 1e7,
-	}
+// Returns true if Linestring is closed and non self-intersecting.
+func isValidRing(r *Linestring) bool {
+	return isSimpleRing(pointsToCoords(r.Points))
 }
 
-func makeLinearRing(r *Linestring) (*geos.Geometry, error) {
-	coords := make([]geos.Coord, len(r.Points))
-	for i, p := range r.Points {
-		coords[i] = createGeosPoint(p)
-	}
-	return geos.NewLinearRing(coords...)
-}
+var (
+	// Maximum number of lines makeRing adds while searching a single ring.
+	// Relations made of thousands of small segments sharing endpoints make
+	// the exhaustive search explode, makeRingsIn then falls back on
+	// makeEulerRings.
+	ringSearchBudget = 100000
+)
 
-// Returns true if Linestring is closed and non self-intersecting.
-func isValidRing(r *Linestring) bool {
-	ring, err := makeLinearRing(r)
-	if err != nil {
-		return false
-	}
-	if ok, err := ring.IsRing(); err != nil || !ok {
-		return false
-	}
-	if ok, err := ring.IsSimple(); err != nil || !ok {
-		return false
-	}
-	return true
+type ringFrame struct {
+	candidates []*Linestring
+	next       int
 }
 
+// Extends parts with unseen lines until they form a valid ring, backtracking
+// on dead ends and invalid rings. The search is iterative and adds at most
+// budget lines. It returns the ring, or nil and whether the search was
+// complete, in which case no ring can be made from parts. Lines of the
+// returned ring are marked as seen.
 func makeRing(parts RingParts, endPoints map[Point][]*Linestring,
-	seen map[int64]bool) *Linestring {
+	seen map[int64]bool, budget int) (*Linestring, bool) {
 
-	if parts.Start() == parts.End() {
+	if parts.IsClosed() {
 		r := parts.MakeRing()
 		if !isValidRing(r) {
-			return nil
+			return nil, true
 		}
-		return r
+		return r, true
 	}
-	for _, next := range endPoints[parts.End()] {
-		if seen[next.Id] {
-			continue
+	// stack[i+1] lists the candidates following pushed[i]
+	stack := []*ringFrame{{candidates: endPoints[parts.End()]}}
+	pushed := []*Linestring{}
+	pop := func() {
+		last := pushed[len(pushed)-1]
+		pushed = pushed[:len(pushed)-1]
+		parts.Pop()
+		seen[last.Id] = false
+	}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		var next *Linestring
+		for next == nil && f.next < len(f.candidates) {
+			c := f.candidates[f.next]
+			f.next++
+			if seen[c.Id] {
+				continue
+			}
+			if c.Start() != parts.End() && c.End() != parts.End() {
+				continue
+			}
+			next = c
 		}
-		if next.Start() != parts.End() && next.End() != parts.End() {
+		if next == nil {
+			stack = stack[:len(stack)-1]
+			if len(pushed) > 0 {
+				pop()
+			}
 			continue
 		}
+		if budget <= 0 {
+			for len(pushed) > 0 {
+				pop()
+			}
+			return nil, false
+		}
+		budget--
 		seen[next.Id] = true
 		parts.Push(next)
-		r := makeRing(parts, endPoints, seen)
-		if r != nil {
-			return r
+		if parts.IsClosed() {
+			r := parts.MakeRing()
+			if isValidRing(r) {
+				return r, true
+			}
+			parts.Pop()
+			seen[next.Id] = false
+			continue
+		}
+		pushed = append(pushed, next)
+		stack = append(stack, &ringFrame{candidates: endPoints[parts.End()]})
+	}
+	return nil, true
+}
+
+// Decomposes lines into rings in linear time, like finding an Eulerian
+// circuit: paths follow the first unused line at every endpoint and a ring is
+// cut each time a path comes back to one of its endpoints. There is no
+// backtracking, so it fails when an extracted ring intersects itself or a
+// path ends on an unmatched endpoint, but it completes on relations where
+// makeRing search does not.
+func makeEulerRings(lines []*Linestring, arena *pointArena) ([]*Linestring, error) {
+	endPoints := makeEndpoints(lines)
+	used := map[*Linestring]bool{}
+	rings := []*Linestring{}
+	for _, line := range lines {
+		if used[line] {
+			continue
+		}
+		used[line] = true
+		path := []*Linestring{line.CloneIn(arena)}
+		// Position of path endpoints, vertex i starts path[i] and the path
+		// end is vertex len(path)
+		vertices := map[Point]int{line.Start(): 0}
+		for len(path) > 0 {
+			end := path[len(path)-1].End()
+			if k, ok := vertices[end]; ok && k < len(path) {
+				parts := RingParts{
+					parts: path[k:],
+					start: path[k].Start(),
+					end:   end,
+					arena: arena,
+				}
+				r := parts.MakeRing()
+				if !isValidRing(r) {
+					return nil, fmt.Errorf("cannot close ring: %d", r.Id)
+				}
+				rings = append(rings, r)
+				for _, p := range path[k+1:] {
+					delete(vertices, p.Start())
+				}
+				path = path[:k]
+				continue
+			}
+			vertices[end] = len(path)
+			var next *Linestring
+			for _, c := range endPoints[end] {
+				if !used[c] {
+					next = c
+					break
+				}
+			}
+			if next == nil {
+				return nil, fmt.Errorf("cannot close ring: %d", path[0].Id)
+			}
+			used[next] = true
+			p := next.CloneIn(arena)
+			if p.Start() != end {
+				p.Reverse()
+			}
+			path = append(path, p)
 		}
-		parts.Pop()
-		seen[next.Id] = false
 	}
-	return nil
+	return rings, nil
 }
 
 // Take a collection of lines and combine them to form rings. Returned
 // Linestring first and last points are equal. The call fails if not all lines
 // end in a ring.
 func makeRings(lines []*Linestring) ([]*Linestring, error) {
-	lines = mergeArcs(lines)
+	return makeRingsIn(lines, nil)
+}
+
+// Like makeRings() but allocates intermediate and returned points from the
+// supplied arena. Input lines may be modified.
+func makeRingsIn(lines []*Linestring, arena *pointArena) ([]*Linestring, error) {
+	lines = mergeArcs(lines, arena)
 	endPoints := makeEndpoints(lines)
 
 	rings := []*Linestring{}
@@ -258,8 +603,22 @@ func makeRings(lines []*Linestring) ([]*Linestring, error) {
 			parts: []*Linestring{line},
 			start: line.Start(),
 			end:   line.End(),
+			arena: arena,
+		}
+		r, complete := makeRing(parts, endPoints, seen, ringSearchBudget)
+		if r == nil && !complete {
+			rest := []*Linestring{line}
+			for _, other := range lines {
+				if !seen[other.Id] {
+					rest = append(rest, other)
+				}
+			}
+			others, err := makeEulerRings(rest, arena)
+			if err != nil {
+				return nil, err
+			}
+			return append(rings, others...), nil
 		}
-		r := makeRing(parts, endPoints, seen)
 		if r == nil {
 			return nil, fmt.Errorf("cannot close ring: %d", line.Id)
 		}
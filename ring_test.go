@@ -0,0 +1,193 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDedupLines(t *testing.T) {
+	lines := []*Linestring{
+		{Id: 1, Points: []Point{{0, 0}, {1, 0}, {1, 1}}},
+		// Same id
+		{Id: 1, Points: []Point{{0, 0}, {1, 0}, {1, 1}}},
+		// Same geometry, reversed
+		{Id: 2, Points: []Point{{1, 1}, {1, 0}, {0, 0}}},
+		// Same endpoints, different path
+		{Id: 3, Points: []Point{{0, 0}, {0, 1}, {1, 1}}},
+		{Id: 4, Points: []Point{{0, 0}, {0, 1}, {1, 1}}},
+	}
+	kept, dropped := dedupLines(lines)
+	if dropped != 3 || len(kept) != 2 {
+		t.Fatalf("unexpected dedup result: kept=%d dropped=%d", len(kept),
+			dropped)
+	}
+	if kept[0].Id != 1 || kept[1].Id != 3 {
+		t.Fatalf("unexpected kept lines: %d, %d", kept[0].Id, kept[1].Id)
+	}
+}
+
+func TestRemoveRepeatedPoints(t *testing.T) {
+	lines := []*Linestring{
+		{Id: 1, Points: []Point{{0, 0}, {0, 0}, {1, 0}, {1, 0}, {1, 0}, {0, 0}}},
+		{Id: 2, Points: []Point{{2, 2}, {2, 2}}},
+		{Id: 3, Points: []Point{{0, 0}, {1, 1}}},
+	}
+	kept, removed := removeRepeatedPoints(lines)
+	if removed != 4 {
+		t.Fatalf("unexpected removed count: %d", removed)
+	}
+	if len(kept) != 2 || kept[0].Id != 1 || kept[1].Id != 3 {
+		t.Fatalf("unexpected kept lines: %d", len(kept))
+	}
+	expected := []Point{{0, 0}, {1, 0}, {0, 0}}
+	if len(kept[0].Points) != len(expected) {
+		t.Fatalf("unexpected points: %v", kept[0].Points)
+	}
+	for i, p := range expected {
+		if kept[0].Points[i] != p {
+			t.Fatalf("unexpected points: %v", kept[0].Points)
+		}
+	}
+}
+
+func TestBuildLinestringMissingNodes(t *testing.T) {
+	defer func() {
+		missingNodesPolicy = MissingNodesError
+	}()
+	nodes := NewNodePoints(0)
+	for _, id := range []int64{2, 3} {
+		if err := nodes.Append(id, Point{Lon: id, Lat: -id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ring := &Way{Id: 10, Nodes: []int64{1, 2, 4, 3, 1}}
+
+	missingNodesPolicy = MissingNodesError
+	_, err := buildLinestring(ring, nodes)
+	if e, ok := err.(*NodeNotFoundError); !ok || e.Id != 1 {
+		t.Fatalf("missing node was not reported: %v", err)
+	}
+
+	missingNodesPolicy = MissingNodesSubstitute
+	line, err := buildLinestring(ring, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Point{{2, -2}, {2, -2}, {2, -2}, {3, -3}, {2, -2}}
+	if !reflect.DeepEqual(line.Points, expected) {
+		t.Fatalf("unexpected points: %v", line.Points)
+	}
+	_, err = buildLinestring(&Way{Id: 11, Nodes: []int64{1, 5}}, nodes)
+	if _, ok := err.(*NodeNotFoundError); !ok {
+		t.Fatalf("way without nodes was built: %v", err)
+	}
+}
+
+func TestSnapEndpoints(t *testing.T) {
+	makeLines := func() []*Linestring {
+		return []*Linestring{
+			{Id: 1, Points: []Point{{0, 0}, {100, 0}, {100, 100}}},
+			// 3 units gap at (100, 100), 5 units gap at (0, 0)
+			{Id: 2, Points: []Point{{103, 100}, {0, 100}, {5, 0}}},
+		}
+	}
+	lines := makeLines()
+	if n := snapEndpoints(lines, 0); n != 0 {
+		t.Fatalf("endpoints were snapped without tolerance: %d", n)
+	}
+	if _, err := makeRings(lines); err == nil {
+		t.Fatalf("unclosed ring was closed")
+	}
+	lines = makeLines()
+	if n := snapEndpoints(lines, 4e-7); n != 1 {
+		t.Fatalf("unexpected bridged gaps: %d", n)
+	}
+	lines = makeLines()
+	if n := snapEndpoints(lines, 1e-6); n != 2 {
+		t.Fatalf("unexpected bridged gaps: %d", n)
+	}
+	rings, err := makeRings(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rings) != 1 || len(rings[0].Points) != 5 {
+		t.Fatalf("unexpected rings: %v", rings)
+	}
+
+	for s, expected := range map[string]float64{
+		"":        0,
+		"0.001":   0.001,
+		"11.132m": 0.0001,
+	} {
+		v, err := parseDistance(s)
+		if err != nil || math.Abs(v-expected) > 1e-12 {
+			t.Fatalf("unexpected tolerance for %q: %v, %v", s, v, err)
+		}
+	}
+	for _, s := range []string{"m", "-1", "1km"} {
+		if _, err := parseDistance(s); err == nil {
+			t.Fatalf("invalid tolerance was accepted: %q", s)
+		}
+	}
+}
+
+func TestEulerRings(t *testing.T) {
+	defer func() {
+		ringSearchBudget = 100000
+	}()
+	ringIds := func(rings []*Linestring) [][]int64 {
+		ids := [][]int64{}
+		for _, r := range rings {
+			ids = append(ids, []int64{r.Id, int64(len(r.Points))})
+		}
+		return ids
+	}
+	// Four arcs between (0, 0) and (10, 0), which mergeArcs cannot merge
+	makeLines := func() []*Linestring {
+		lines := []*Linestring{}
+		for i, y := range []int64{1, -1, 3, -3} {
+			lines = append(lines, &Linestring{
+				Id:     int64(i + 1),
+				Points: []Point{{0, 0}, {5, y}, {10, 0}},
+			})
+		}
+		return lines
+	}
+	expected := [][]int64{{1, 5}, {3, 5}}
+	rings, err := makeRings(makeLines())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ringIds(rings), expected) {
+		t.Fatalf("unexpected rings: %v", ringIds(rings))
+	}
+	// Exhaust the search budget to use the fallback
+	ringSearchBudget = 0
+	rings, err = makeRings(makeLines())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ringIds(rings), expected) {
+		t.Fatalf("unexpected fallback rings: %v", ringIds(rings))
+	}
+
+	// Rings are cut where paths come back to a previous endpoint
+	lines := []*Linestring{
+		{Id: 1, Points: []Point{{0, 0}, {5, 5}, {10, 0}}},
+		{Id: 2, Points: []Point{{10, 0}, {15, 5}, {20, 0}}},
+		{Id: 3, Points: []Point{{20, 0}, {15, -5}, {10, 0}}},
+		{Id: 4, Points: []Point{{10, 0}, {5, -5}, {0, 0}}},
+	}
+	rings, err = makeEulerRings(lines, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ringIds(rings), [][]int64{{2, 5}, {1, 5}}) {
+		t.Fatalf("unexpected rings: %v", ringIds(rings))
+	}
+	_, err = makeEulerRings(lines[:3], nil)
+	if err == nil {
+		t.Fatalf("unclosed rings were built")
+	}
+}
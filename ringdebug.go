@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type debugGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type debugFeature struct {
+	Type       string                 `json:"type"`
+	Id         string                 `json:"id,omitempty"`
+	Geometry   debugGeometry          `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func pointsToJson(points []Point) [][]float64 {
+	return coordsToJson(pointsToCoords(points))
+}
+
+func makeLineFeature(line *Linestring, kind string) debugFeature {
+	return debugFeature{
+		Type: "Feature",
+		Geometry: debugGeometry{
+			Type:        "LineString",
+			Coordinates: pointsToJson(line.Points),
+		},
+		Properties: map[string]interface{}{
+			"kind":   kind,
+			"way":    line.Id,
+			"role":   line.Role,
+			"closed": line.Start() == line.End(),
+			"points": len(line.Points),
+		},
+	}
+}
+
+// RingDebug holds the lines a relation geometry is built from, the partial
+// rings obtained by chaining them and the endpoints left unmatched.
+type RingDebug struct {
+	Lines    []*Linestring
+	Arcs     []*Linestring
+	Dangling map[Point][]*Linestring
+	Problems []ValidationProblem
+}
+
+// Collects rel ways from db and chains them like makeRings, without failing
+// on unmatched endpoints.
+func debugRings(rel *Relation, db *WaysDb) (*RingDebug, error) {
+	lines, problems, err := collectValidatedRings(rel, db)
+	if err != nil {
+		return nil, err
+	}
+	d := &RingDebug{
+		Dangling: map[Point][]*Linestring{},
+		Problems: problems,
+	}
+	for _, line := range lines {
+		if len(line.Points) > 0 {
+			d.Lines = append(d.Lines, line)
+		}
+	}
+	for p, ends := range makeEndpoints(d.Lines) {
+		if len(ends)%2 != 0 {
+			d.Dangling[p] = ends
+		}
+	}
+	arcs := make([]*Linestring, len(d.Lines))
+	for i, line := range d.Lines {
+		arcs[i] = line.Clone()
+	}
+	d.Arcs = mergeArcs(arcs, nil)
+	return d, nil
+}
+
+// Explains why rings could not be closed, one line per unmatched endpoint.
+func (d *RingDebug) Explain() string {
+	lines := []string{
+		fmt.Sprintf("lines: %d, partial rings: %d", len(d.Lines), len(d.Arcs)),
+	}
+	for _, p := range d.Problems {
+		lines = append(lines, fmt.Sprintf("%s: %s", p.Category, p.Message))
+	}
+	points := []Point{}
+	for p := range d.Dangling {
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return pointLess(points[i], points[j])
+	})
+	for _, p := range points {
+		ids := []string{}
+		for _, line := range d.Dangling[p] {
+			ids = append(ids, fmt.Sprintf("%d(%s)", line.Id, line.Role))
+		}
+		if len(ids) == 1 {
+			lines = append(lines, fmt.Sprintf(
+				"unmatched endpoint %s: only way %s ends there",
+				pointString(p), ids[0]))
+		} else {
+			lines = append(lines, fmt.Sprintf(
+				"unmatched endpoint %s: odd number of ways end there: %s",
+				pointString(p), strings.Join(ids, ", ")))
+		}
+	}
+	if len(d.Dangling) == 0 && len(d.Lines) > 0 {
+		lines = append(lines, "every endpoint is matched, closing the "+
+			"partial rings makes them intersect themselves")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// GeoJSON returns a feature collection with the collected lines, the partial
+// rings and the unmatched endpoints, told apart by their "kind" property.
+func (d *RingDebug) GeoJSON() ([]byte, error) {
+	features := []debugFeature{}
+	for _, line := range d.Lines {
+		features = append(features, makeLineFeature(line, "way"))
+	}
+	for _, arc := range d.Arcs {
+		features = append(features, makeLineFeature(arc, "partial"))
+	}
+	for p, ends := range d.Dangling {
+		ids := []int64{}
+		for _, line := range ends {
+			ids = append(ids, line.Id)
+		}
+		c := pointToCoord(p)
+		features = append(features, debugFeature{
+			Type: "Feature",
+			Geometry: debugGeometry{
+				Type:        "Point",
+				Coordinates: []float64{c.X, c.Y},
+			},
+			Properties: map[string]interface{}{
+				"kind": "unmatched",
+				"ways": ids,
+			},
+		})
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	}, "", "  ")
+}
+
+// Writes dir/<id>.geojson and dir/<id>.txt describing why rel failed to build
+// with buildErr.
+func dumpRingDebug(dir string, rel *Relation, db *WaysDb, buildErr error) error {
+	d, err := debugRings(rel, db)
+	if err != nil {
+		return err
+	}
+	data, err := d.GeoJSON()
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%d", rel.Id))
+	err = ioutil.WriteFile(base+".geojson", data, 0644)
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("%s: %s\n", rel.String(), buildErr) + d.Explain()
+	return ioutil.WriteFile(base+".txt", []byte(text), 0644)
+}
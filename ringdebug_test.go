@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpRingDebug(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+
+	ways := []*Linestring{
+		{Id: 1, Points: []Point{{Lon: 0, Lat: 0}, {Lon: 10, Lat: 0}}},
+		{Id: 2, Points: []Point{{Lon: 10, Lat: 0}, {Lon: 10, Lat: 10}}},
+		{Id: 3, Points: []Point{{Lon: 0, Lat: 10}, {Lon: 0, Lat: 0}}},
+	}
+	for _, w := range ways {
+		if err := db.Put(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rel := &Relation{Id: 100, Refs: []Ref{
+		{Id: 1, Type: 1, Role: "outer"},
+		{Id: 2, Type: 1, Role: "outer"},
+		{Id: 3, Type: 1, Role: "outer"},
+	}}
+	d, err := debugRings(rel, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Lines) != 3 || len(d.Arcs) != 1 || len(d.Dangling) != 2 {
+		t.Fatalf("unexpected rings: %d lines, %d arcs, %d dangling",
+			len(d.Lines), len(d.Arcs), len(d.Dangling))
+	}
+	text := d.Explain()
+	for _, s := range []string{
+		"unmatched endpoint 0.0000000,0.0000010: only way 3(outer) ends there",
+		"unmatched endpoint 0.0000010,0.0000010: only way 2(outer) ends there",
+	} {
+		if !strings.Contains(text, s) {
+			t.Fatalf("%q not found in:\n%s", s, text)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "osm-ringdebug-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = dumpRingDebug(dir, rel, db, fmt.Errorf("cannot close ring: 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "100.geojson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := struct {
+		Features []debugFeature `json:"features"`
+	}{}
+	if err := json.Unmarshal(data, &collection); err != nil {
+		t.Fatal(err)
+	}
+	if len(collection.Features) != 6 {
+		t.Fatalf("unexpected features count: %d", len(collection.Features))
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, "100.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "cannot close ring: 1\n") {
+		t.Fatalf("unexpected explanation:\n%s", data)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// RelationRule describes how a specific relation is patched when building
+// locations.
+type RelationRule struct {
+	Id      int64  `json:"id"`
+	Comment string `json:"comment,omitempty"`
+	// Ignore excludes the relation, or only with the named geometry backend
+	// when IgnoreBackend is set.
+	Ignore        bool   `json:"ignore,omitempty"`
+	IgnoreBackend string `json:"ignore_backend,omitempty"`
+	// Keep processes the relation without applying tag filters.
+	Keep bool `json:"keep,omitempty"`
+	// Prefer makes the relation win country deduplication over other
+	// relations with the same ISO code, overriding scores and the boundary
+	// variant preference. Default rules leave deduplication to scoring.
+	Prefer bool `json:"prefer,omitempty"`
+	// Tags are appended to the relation tags, overriding existing ones.
+	Tags []StringPair `json:"tags,omitempty"`
+	// Segments are added to the relation ways to close its rings.
+	Segments [][]Point `json:"segments,omitempty"`
+	// Subareas builds the relation geometry from its "subarea" relations.
+	Subareas bool `json:"subareas,omitempty"`
+	// Recursive collects ways from inner and outer sub-relations too.
+	Recursive bool `json:"recursive,omitempty"`
+}
+
+type PatchRules struct {
+	Relations []*RelationRule `json:"relations"`
+	byId      map[int64]*RelationRule
+}
+
+func newPatchRules(relations []*RelationRule) (*PatchRules, error) {
+	rules := &PatchRules{
+		Relations: relations,
+		byId:      map[int64]*RelationRule{},
+	}
+	for _, r := range relations {
+		if r.Id <= 0 {
+			return nil, fmt.Errorf("invalid relation id: %d", r.Id)
+		}
+		if rules.byId[r.Id] != nil {
+			return nil, fmt.Errorf("duplicate rule for relation %d", r.Id)
+		}
+		if r.Ignore && r.Keep {
+			return nil, fmt.Errorf("relation %d cannot be both ignored and kept",
+				r.Id)
+		}
+		if r.Ignore && r.Prefer {
+			return nil, fmt.Errorf("relation %d cannot be both ignored and "+
+				"preferred", r.Id)
+		}
+		for _, s := range r.Segments {
+			if len(s) < 2 {
+				return nil, fmt.Errorf("relation %d has a segment with less "+
+					"than 2 points", r.Id)
+			}
+		}
+		rules.byId[r.Id] = r
+	}
+	return rules, nil
+}
+
+// Get returns the rule of relation id or nil.
+func (r *PatchRules) Get(id int64) *RelationRule {
+	return r.byId[id]
+}
+
+func parsePatchRules(r io.Reader) (*PatchRules, error) {
+	rules := &PatchRules{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(rules); err != nil {
+		return nil, err
+	}
+	return newPatchRules(rules.Relations)
+}
+
+func readPatchRules(path string) (*PatchRules, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	rules, err := parsePatchRules(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return rules, nil
+}
+
+func defaultPatchRules() *PatchRules {
+	rules, err := newPatchRules([]*RelationRule{
+		{
+			Id: 11980,
+			Comment: "France has 2 representations, with and without water " +
+				"areas, keep this one. It is built from its subareas.",
+			Keep:     true,
+			Subareas: true,
+		},
+		{
+			Id:            1401905,
+			Comment:       "Tuamotu-Gambier, crashes in a geos finalizer",
+			IgnoreBackend: "geos",
+		},
+		{
+			Id: 1362232,
+			Comment: "Metropolitan France, built from sub-relations, its " +
+				"polygon is not closed",
+			Recursive: true,
+			Segments: [][]Point{
+				{{-17641958, 433431448}, {-17668244, 433425557}},
+				{{37501395, 434237009}, {37469067, 434193643}},
+			},
+		},
+		{
+			Id: 1111111,
+			Comment: "Germany, outer ways with linestrings, built from " +
+				"sub-relations",
+			Recursive: true,
+		},
+		{
+			Id:      937244,
+			Comment: "Belgium land mass",
+			Tags: []StringPair{
+				{Key: "ISO3166-1:alpha2", Value: "BE"},
+				{Key: "ISO3166-1:alpha3", Value: "BEL"},
+			},
+		},
+		{
+			Id:      1711283,
+			Comment: "Jersey land area",
+			Ignore:  true,
+			Tags: []StringPair{
+				{Key: "ISO3166-1:alpha2", Value: "JE"},
+				{Key: "ISO3166-1:alpha3", Value: "JEY"},
+			},
+		},
+		{Id: 270009, Comment: "Guernsey, keep the land mass (6571872)",
+			Ignore: true},
+		{
+			Id:      6571872,
+			Comment: "Guernsey land mass",
+			Tags: []StringPair{
+				{Key: "ISO3166-1:alpha2", Value: "GG"},
+				{Key: "ISO3166-1:alpha3", Value: "GBG"},
+			},
+		},
+		{
+			Id:      2850940,
+			Comment: "Philippines maritime boundary, keep 443174",
+			Ignore:  true,
+			Tags: []StringPair{
+				{Key: "ISO3166-1:alpha2", Value: "PH"},
+				{Key: "ISO3166-1:alpha3", Value: "PHL"},
+			},
+		},
+		{
+			Id:      4263589,
+			Comment: "Philippines continental shell, keep 443174",
+			Ignore:  true,
+			Tags: []StringPair{
+				{Key: "ISO3166-1:alpha2", Value: "PH"},
+				{Key: "ISO3166-1:alpha3", Value: "PHL"},
+			},
+		},
+		{Id: 5441968, Comment: "Sahrawi Arab Democratic Republic, disputed, " +
+			"no iso code", Ignore: true},
+		{Id: 3263728, Comment: "British Sovereign Base Areas, disputed",
+			Ignore: true},
+		{Id: 6858045, Comment: "Liberland, because it does not really exist",
+			Ignore: true},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// Relation patch rules applied when building locations, replaced with
+// --rules.
+var patchRules = defaultPatchRules()
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePatchRules(t *testing.T) {
+	input := `{"relations": [
+	{"id": 1, "ignore": true},
+	{"id": 2, "keep": true, "tags": [{"key": "ISO3166-1:alpha2", "value": "XX"}]},
+	{"id": 4, "prefer": true},
+	{"id": 3, "recursive": true,
+	 "segments": [[{"lon": 10, "lat": 20}, {"lon": 30, "lat": 40}]]}
+]}`
+	rules, err := parsePatchRules(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := rules.Get(1); r == nil || !r.Ignore {
+		t.Fatalf("unexpected rule 1: %+v", r)
+	}
+	if r := rules.Get(3); r == nil || !r.Recursive || len(r.Segments) != 1 ||
+		r.Segments[0][1] != (Point{30, 40}) {
+		t.Fatalf("unexpected rule 3: %+v", r)
+	}
+	if r := rules.Get(2); r == nil || !r.Keep || r.Prefer {
+		t.Fatalf("unexpected rule 2: %+v", r)
+	}
+	if r := rules.Get(4); r == nil || !r.Prefer || r.Keep {
+		t.Fatalf("unexpected rule 4: %+v", r)
+	}
+	if rules.Get(5) != nil {
+		t.Fatalf("unexpected rule 5")
+	}
+
+	defer func(rules *PatchRules) {
+		patchRules = rules
+	}(patchRules)
+	patchRules = rules
+
+	tests := []struct {
+		id     int64
+		reason string
+	}{
+		{1, IgnoreExcluded},
+		// Kept without name or admin level
+		{2, ""},
+		{3, IgnoreAdminLevel},
+		// Ignored by the default rules only
+		{62781, IgnoreAdminLevel},
+	}
+	for _, test := range tests {
+		rel := &Relation{Id: test.id}
+		reason, err := getIgnoreReason(rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason != test.reason {
+			t.Fatalf("%d: unexpected reason: %q != %q", test.id, reason,
+				test.reason)
+		}
+	}
+	tags := patchTags(&Relation{Id: 2, Tags: []StringPair{{Key: "name", Value: "x"}}})
+	if len(tags) != 2 || tags[1].Value != "XX" {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+	rings := patchRings(&Relation{Id: 3}, nil)
+	if len(rings) != 1 || len(rings[0].Points) != 2 {
+		t.Fatalf("unexpected rings: %v", rings)
+	}
+	if !isRecursiveRelation(&Relation{Id: 3}) ||
+		isRecursiveRelation(&Relation{Id: 1111111}) {
+		t.Fatalf("unexpected recursive relations")
+	}
+}
+
+func TestInvalidPatchRules(t *testing.T) {
+	tests := []string{
+		`{"relations": [{"id": 1}, {"id": 1}]}`,
+		`{"relations": [{"id": 0}]}`,
+		`{"relations": [{"id": 1, "ignore": true, "keep": true}]}`,
+		`{"relations": [{"id": 1, "ignore": true, "prefer": true}]}`,
+		`{"relations": [{"id": 1, "segments": [[{"lon": 1, "lat": 2}]]}]}`,
+		`{"relations": [{"id": 1, "unknown": true}]}`,
+	}
+	for _, test := range tests {
+		if _, err := parsePatchRules(strings.NewReader(test)); err == nil {
+			t.Fatalf("invalid rules were accepted: %s", test)
+		}
+	}
+}
+
+func TestDefaultPatchRules(t *testing.T) {
+	for _, id := range []int64{3263728, 6858045} {
+		reason, err := getIgnoreReason(&Relation{Id: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason != IgnoreExcluded {
+			t.Fatalf("%d should be excluded: %q", id, reason)
+		}
+	}
+	// Duplicate countries are left to dedupcountries scoring, preferring
+	// relations is an override for user rules only
+	for _, id := range []int64{2202162, 62781, 51477, 1124039, 936128, 52411} {
+		if rule := patchRules.Get(id); rule != nil {
+			t.Fatalf("%d should not have a rule: %+v", id, rule)
+		}
+	}
+	for _, rule := range patchRules.Relations {
+		if rule.Prefer {
+			t.Fatalf("default rule prefers %d", rule.Id)
+		}
+	}
+	if reason, err := getIgnoreReason(&Relation{Id: 11980}); err != nil ||
+		reason != "" {
+		t.Fatalf("France should be kept: %q %v", reason, err)
+	}
+	tags := patchTags(&Relation{Id: 937244})
+	if len(tags) != 2 || tags[0].Value != "BE" {
+		t.Fatalf("unexpected Belgium tags: %v", tags)
+	}
+	if len(patchRings(&Relation{Id: 1362232}, nil)) != 2 {
+		t.Fatalf("metropolitan France should be closed")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BoundaryServer answers boundary queries over a WaysDb with indexed
+// locations and centroids:
+//
+//	/boundary/{relationId}      the relation document, like geojson exports
+//	/reverse?lon=...&lat=...    the areas containing a point
+//	/search?name=...&level=...  the areas with a name, case-insensitive
+type BoundaryServer struct {
+	db *WaysDb
+	// Areas by lowercase name
+	names map[string][]AdminAreaJson
+}
+
+// Creates a server over db, loading the names of the relations with a
+// location. Relations are selected by ignoreRelation like the geojson command
+// does.
+func NewBoundaryServer(db *WaysDb) (*BoundaryServer, error) {
+	ids, err := db.ListLocationIds()
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]int64, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	names := map[string][]AdminAreaJson{}
+	for _, id := range sorted {
+		rel, err := db.GetRelation(id)
+		if err != nil {
+			return nil, err
+		}
+		if rel == nil {
+			continue
+		}
+		if ok, err := ignoreRelation(rel); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rt, err := NewRelationTags(rel)
+		if err != nil {
+			return nil, err
+		}
+		level, _ := rt.AdminLevel()
+		key := strings.ToLower(rt.Name())
+		names[key] = append(names[key], AdminAreaJson{
+			Id:         fmt.Sprintf("%d", id),
+			Name:       rt.Name(),
+			AdminLevel: level,
+		})
+	}
+	return &BoundaryServer{
+		db:    db,
+		names: names,
+	}, nil
+}
+
+func (s *BoundaryServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/boundary/", s.boundary)
+	mux.HandleFunc("/reverse", s.reverse)
+	mux.HandleFunc("/search", s.search)
+	return mux
+}
+
+type httpError struct {
+	Code int
+	Err  error
+}
+
+func (e *httpError) Error() string {
+	return e.Err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+// Writes result as JSON, or err with its status code.
+func writeJsonResponse(w http.ResponseWriter, result interface{}, err error) {
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusInternalServerError
+		if e, ok := err.(*httpError); ok {
+			code = e.Code
+		}
+		result = map[string]string{"error": err.Error()}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+func (s *BoundaryServer) boundary(w http.ResponseWriter, r *http.Request) {
+	js, err := s.getBoundary(strings.TrimPrefix(r.URL.Path, "/boundary/"))
+	writeJsonResponse(w, js, err)
+}
+
+func (s *BoundaryServer) getBoundary(idStr string) (*RelationJson, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, badRequest("invalid relation id: %q", idStr)
+	}
+	rel, err := s.db.GetRelation(id)
+	if err != nil {
+		return nil, err
+	}
+	if rel == nil {
+		return nil, notFound("unknown relation: %d", id)
+	}
+	if ok, err := ignoreRelation(rel); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, notFound("ignored relation: %d", id)
+	}
+	js, err := buildRelation(rel, s.db)
+	if err != nil {
+		return nil, err
+	}
+	if js == nil {
+		return nil, notFound("relation %d has no location or centroid", id)
+	}
+	return js, nil
+}
+
+func (s *BoundaryServer) reverse(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lon, lat, err := parseLonLat(q.Get("lon"), q.Get("lat"))
+	if err != nil {
+		writeJsonResponse(w, nil, badRequest("%s", err))
+		return
+	}
+	admins, err := lookupDbAreas(s.db, lon, lat)
+	writeJsonResponse(w, &LookupJson{Lon: lon, Lat: lat, Admin: admins}, err)
+}
+
+func (s *BoundaryServer) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := strings.ToLower(strings.TrimSpace(q.Get("name")))
+	if name == "" {
+		writeJsonResponse(w, nil, badRequest("name is required"))
+		return
+	}
+	level := 0
+	if v := q.Get("level"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			writeJsonResponse(w, nil, badRequest("invalid level: %q", v))
+			return
+		}
+		level = l
+	}
+	found := []AdminAreaJson{}
+	for _, area := range s.names[name] {
+		if level == 0 || area.AdminLevel == level {
+			found = append(found, area)
+		}
+	}
+	writeJsonResponse(w, found, nil)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestBoundaryServer(t *testing.T) {
+	dir, _, path := runTestPipeline(t)
+	defer os.RemoveAll(dir)
+	db, err := OpenWaysDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s, err := NewBoundaryServer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	get := func(path string, code int, result interface{}) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("%s: unexpected status: %d", path, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+	}
+
+	rel := &RelationJson{}
+	get("/boundary/101", http.StatusOK, rel)
+	if rel.Name != "Region" || rel.AdminLevel != 4 || rel.Center.Lon <= 1 ||
+		rel.Center.Lon >= 3 || rel.Center.Lat <= 1 || rel.Center.Lat >= 3 {
+		t.Fatalf("unexpected boundary: %+v", rel)
+	}
+	errJs := map[string]string{}
+	get("/boundary/102", http.StatusNotFound, &errJs)
+	get("/boundary/x", http.StatusBadRequest, &errJs)
+	if errJs["error"] == "" {
+		t.Fatalf("missing error message")
+	}
+
+	lookup := &LookupJson{}
+	get("/reverse?lon=2&lat=2.5", http.StatusOK, lookup)
+	if len(lookup.Admin) != 2 || lookup.Admin[0].Id != "100" ||
+		lookup.Admin[1].Id != "101" {
+		t.Fatalf("unexpected reverse result: %+v", lookup)
+	}
+	get("/reverse?lon=2", http.StatusBadRequest, &errJs)
+
+	found := []AdminAreaJson{}
+	get("/search?name=region", http.StatusOK, &found)
+	if len(found) != 1 || found[0].Id != "101" {
+		t.Fatalf("unexpected search result: %+v", found)
+	}
+	found = nil
+	get("/search?name=COUNTRY&level=2", http.StatusOK, &found)
+	if len(found) != 1 || found[0].Id != "100" {
+		t.Fatalf("unexpected search result: %+v", found)
+	}
+	found = nil
+	get("/search?name=country&level=4", http.StatusOK, &found)
+	if len(found) != 0 {
+		t.Fatalf("unexpected search result: %+v", found)
+	}
+	get("/search?level=6", http.StatusBadRequest, &errJs)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+var errEmptyShape = errors.New("empty shape after simplification")
+
+func countLocationPoints(loc *Location) int {
+	n := 0
+	for _, poly := range loc.Coordinates {
+		for _, ring := range poly {
+			n += len(ring)
+		}
+	}
+	return n
+}
+
+// Rounds ring coordinates to digits decimals and removes the consecutive
+// points merged by rounding.
+func quantizeRing(ring [][]float64, digits int) [][]float64 {
+	scale := math.Pow(10, float64(digits))
+	result := make([][]float64, 0, len(ring))
+	for _, p := range ring {
+		q := []float64{
+			math.Round(p[0]*scale) / scale,
+			math.Round(p[1]*scale) / scale,
+		}
+		if n := len(result); n > 0 && result[n-1][0] == q[0] &&
+			result[n-1][1] == q[1] {
+			continue
+		}
+		result = append(result, q)
+	}
+	return result
+}
+
+// Returns the distance from p to segment [a, b].
+func segmentDistance(p, a, b []float64) float64 {
+	dx := b[0] - a[0]
+	dy := b[1] - a[1]
+	t := 0.
+	if l := dx*dx + dy*dy; l > 0 {
+		t = ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / l
+		t = math.Max(0, math.Min(1, t))
+	}
+	x := a[0] + t*dx - p[0]
+	y := a[1] + t*dy - p[1]
+	return math.Sqrt(x*x + y*y)
+}
+
+// Marks the points of a line of n points kept by the Douglas-Peucker
+// algorithm. dist(i, a, b) returns the distance from point i to segment
+// [a, b]. Closed lines are split at their first point and the point farthest
+// from it, so both halves have distinct ends. farthest(i) returns the
+// squared distance of point i to the first one.
+func douglasPeucker(n int, closed bool, tolerance float64,
+	dist func(i, a, b int) float64, farthest func(i int) float64) []bool {
+
+	keep := make([]bool, n)
+	keep[0] = true
+	keep[n-1] = true
+	var simplify func(first, last int)
+	simplify = func(first, last int) {
+		maxDist := -1.
+		index := -1
+		for i := first + 1; i < last; i++ {
+			d := dist(i, first, last)
+			if d > maxDist {
+				maxDist = d
+				index = i
+			}
+		}
+		if index < 0 || maxDist <= tolerance {
+			return
+		}
+		keep[index] = true
+		simplify(first, index)
+		simplify(index, last)
+	}
+	if !closed {
+		simplify(0, n-1)
+		return keep
+	}
+	far := 1
+	farDist := -1.
+	for i := 1; i < n-1; i++ {
+		if d := farthest(i); d > farDist {
+			far = i
+			farDist = d
+		}
+	}
+	keep[far] = true
+	simplify(0, far)
+	simplify(far, n-1)
+	return keep
+}
+
+// Simplifies a closed ring with the Douglas-Peucker algorithm.
+func simplifyRing(ring [][]float64, tolerance float64) [][]float64 {
+	if len(ring) <= 4 {
+		return ring
+	}
+	keep := douglasPeucker(len(ring), true, tolerance,
+		func(i, a, b int) float64 {
+			return segmentDistance(ring[i], ring[a], ring[b])
+		},
+		func(i int) float64 {
+			dx := ring[i][0] - ring[0][0]
+			dy := ring[i][1] - ring[0][1]
+			return dx*dx + dy*dy
+		})
+	result := [][]float64{}
+	for i, p := range ring {
+		if keep[i] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Simplifies way points with the Douglas-Peucker algorithm, tolerance being
+// in degrees. Ends are always kept, so ways sharing endpoints stay
+// connected, and closed ways keep at least 4 points.
+func simplifyPoints(points []Point, tolerance float64) []Point {
+	n := len(points)
+	closed := n > 0 && points[0] == points[n-1]
+	if n <= 2 || (closed && n <= 4) {
+		return points
+	}
+	coords := make([][]float64, n)
+	for i, p := range points {
+		coords[i] = []float64{float64(p.Lon) / 1e7, float64(p.Lat) / 1e7}
+	}
+	keep := douglasPeucker(n, closed, tolerance,
+		func(i, a, b int) float64 {
+			return segmentDistance(coords[i], coords[a], coords[b])
+		},
+		func(i int) float64 {
+			dx := coords[i][0] - coords[0][0]
+			dy := coords[i][1] - coords[0][1]
+			return dx*dx + dy*dy
+		})
+	result := []Point{}
+	for i, p := range points {
+		if keep[i] {
+			result = append(result, p)
+		}
+	}
+	if closed && len(result) < 4 {
+		// Collapsed ring
+		return points
+	}
+	return result
+}
+
+// Applies fn to all rings of loc and returns the resulting location. Rings
+// with less than 4 points are dropped, and polygons losing their outer ring.
+func transformLocation(loc *Location, fn func([][]float64) [][]float64) *Location {
+	result := &Location{
+		Type:        loc.Type,
+		AreaKm2:     loc.AreaKm2,
+		PerimeterKm: loc.PerimeterKm,
+	}
+	for _, poly := range loc.Coordinates {
+		rings := [][][]float64{}
+		for i, ring := range poly {
+			ring = fn(ring)
+			if len(ring) < 4 {
+				if i == 0 {
+					break
+				}
+				continue
+			}
+			rings = append(rings, ring)
+		}
+		if len(rings) > 0 {
+			result.Coordinates = append(result.Coordinates, rings)
+		}
+	}
+	return result
+}
+
+func quantizeLocation(loc *Location, digits int) *Location {
+	return transformLocation(loc, func(ring [][]float64) [][]float64 {
+		return quantizeRing(ring, digits)
+	})
+}
+
+// Simplifies loc with increasing tolerances until it has at most maxPoints
+// points. Returns the simplified location and the tolerance used, zero if
+// loc was small enough already.
+func simplifyLocation(loc *Location, maxPoints int) (*Location, float64) {
+	if countLocationPoints(loc) <= maxPoints {
+		return loc, 0
+	}
+	tolerance := 1e-6
+	result := loc
+	for i := 0; i < 40; i++ {
+		result = transformLocation(loc, func(ring [][]float64) [][]float64 {
+			return simplifyRing(ring, tolerance)
+		})
+		if countLocationPoints(result) <= maxPoints {
+			break
+		}
+		tolerance *= 2
+	}
+	return result, tolerance
+}
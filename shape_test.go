@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestQuantizeLocation(t *testing.T) {
+	loc := &Location{
+		Type: "multipolygon",
+		Coordinates: [][][][]float64{{
+			{{0, 0}, {1.004, 0}, {1.001, 0.0001}, {1, 1}, {0, 1}, {0, 0}},
+			// Collapses after quantization
+			{{0.5, 0.5}, {0.501, 0.5}, {0.501, 0.501}, {0.5, 0.5}},
+		}},
+	}
+	res := quantizeLocation(loc, 2)
+	expected := [][][][]float64{{
+		{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}},
+	}}
+	if !reflect.DeepEqual(res.Coordinates, expected) {
+		t.Fatalf("unexpected quantized location: %v", res.Coordinates)
+	}
+}
+
+func TestSimplifyLocation(t *testing.T) {
+	// A circle-ish ring of 1000 points
+	ring := [][]float64{}
+	for i := 0; i < 1000; i++ {
+		a := 2 * math.Pi * float64(i) / 1000
+		ring = append(ring, []float64{math.Cos(a), math.Sin(a)})
+	}
+	ring = append(ring, ring[0])
+	loc := &Location{
+		Type:        "multipolygon",
+		Coordinates: [][][][]float64{{ring}},
+	}
+	res, tolerance := simplifyLocation(loc, 100)
+	if tolerance <= 0 {
+		t.Fatalf("location was not simplified")
+	}
+	n := countLocationPoints(res)
+	if n > 100 || n < 4 {
+		t.Fatalf("unexpected simplified points count: %d", n)
+	}
+	simplified := res.Coordinates[0][0]
+	if !reflect.DeepEqual(simplified[0], simplified[len(simplified)-1]) {
+		t.Fatalf("simplified ring is not closed")
+	}
+	same, tolerance := simplifyLocation(loc, 2000)
+	if same != loc || tolerance != 0 {
+		t.Fatalf("small location was simplified")
+	}
+}
+
+func TestSimplifyPoints(t *testing.T) {
+	// A slightly noisy tent shaped line
+	line := []Point{}
+	for i := int64(0); i <= 100; i++ {
+		h := i
+		if i > 50 {
+			h = 100 - i
+		}
+		line = append(line, Point{Lon: i * 1e5, Lat: h*1e5 + (i%2)*10})
+	}
+	res := simplifyPoints(line, 1e-4)
+	expected := []Point{line[0], line[50], line[100]}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected simplified line: %v", res)
+	}
+	if res := simplifyPoints(line, 0); len(res) != len(line) {
+		t.Fatalf("zero tolerance removed points: %d", len(res))
+	}
+
+	// Closed ways keep enough points to remain rings
+	ring := []Point{{0, 0}, {1e7, 0}, {1e7, 10}, {1e7, 1e7}, {0, 1e7}, {0, 0}}
+	res = simplifyPoints(ring, 1)
+	if len(res) < 4 || res[0] != res[len(res)-1] {
+		t.Fatalf("unexpected simplified ring: %v", res)
+	}
+	triangle := []Point{{0, 0}, {1e7, 0}, {0, 1e7}, {0, 0}}
+	if res := simplifyPoints(triangle, 1); len(res) != 4 {
+		t.Fatalf("small ring was simplified: %v", res)
+	}
+}
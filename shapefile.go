@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ESRI shapefile output, see
+// https://www.esri.com/library/whitepapers/pdfs/shapefile.pdf. A shapefile is
+// a set of files sharing a base name: .shp holds geometries, .shx their
+// offsets and .dbf the attributes, in a dBASE III table.
+const (
+	shpFileCode   = 9994
+	shpVersion    = 1000
+	shpPolygon    = 5
+	shpHeaderSize = 100
+	// Offsets and lengths are stored as signed 16-bit words counts
+	shpMaxSize = math.MaxInt32 * 2
+
+	dbfFieldSize = 32
+	dbfMaxString = 254
+)
+
+var (
+	shpPrjWGS84 = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",` +
+		`SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],` +
+		`UNIT["Degree",0.0174532925199433]]`
+)
+
+type dbfField struct {
+	Name string
+	// C for strings, N for numbers
+	Type   byte
+	Length int
+}
+
+var (
+	shapefileFields = []dbfField{
+		{"osm_id", 'C', 24},
+		{"name", 'C', dbfMaxString},
+		{"admin_lvl", 'N', 2},
+		{"iso2", 'C', 2},
+		{"iso3", 'C', 3},
+	}
+)
+
+// ShapefileWriter writes relations as polygons in base.shp, base.shx and
+// base.dbf, plus base.prj and base.cpg describing the coordinate system and
+// attributes encoding. Headers hold the record count and extent, they are
+// rewritten by Commit.
+type ShapefileWriter struct {
+	base  string
+	shp   *OutputFile
+	shx   *OutputFile
+	dbf   *OutputFile
+	bbox  *BBox
+	size  int64
+	count int
+	buf   []byte
+}
+
+func CreateShapefile(base string) (*ShapefileWriter, error) {
+	w := &ShapefileWriter{
+		base: base,
+		bbox: NewBBox(),
+		size: shpHeaderSize,
+	}
+	err := func() error {
+		var err error
+		w.shp, err = CreateOutputFile(base+".shp", CompressNone)
+		if err != nil {
+			return err
+		}
+		w.shx, err = CreateOutputFile(base+".shx", CompressNone)
+		if err != nil {
+			return err
+		}
+		w.dbf, err = CreateOutputFile(base+".dbf", CompressNone)
+		if err != nil {
+			return err
+		}
+		// Placeholders, see Commit
+		header := make([]byte, shpHeaderSize)
+		if _, err := w.shp.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.shx.Write(header); err != nil {
+			return err
+		}
+		_, err = w.dbf.Write(w.dbfHeader(time.Now()))
+		return err
+	}()
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *ShapefileWriter) Abort() {
+	for _, f := range []*OutputFile{w.shp, w.shx, w.dbf} {
+		if f != nil {
+			f.Abort()
+		}
+	}
+}
+
+func appendShpInt32(buf []byte, order binary.ByteOrder, v int) []byte {
+	b := make([]byte, 4)
+	order.PutUint32(b, uint32(int32(v)))
+	return append(buf, b...)
+}
+
+func appendShpFloat(buf []byte, v float64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	return append(buf, b...)
+}
+
+func appendShpBBox(buf []byte, bbox *BBox) []byte {
+	if bbox.empty {
+		return append(buf, make([]byte, 32)...)
+	}
+	buf = appendShpFloat(buf, bbox.MinLon)
+	buf = appendShpFloat(buf, bbox.MinLat)
+	buf = appendShpFloat(buf, bbox.MaxLon)
+	return appendShpFloat(buf, bbox.MaxLat)
+}
+
+// Returns the rings of coords, closed and oriented as shapefiles expect:
+// outer rings clockwise and holes counter-clockwise, the opposite of RFC
+// 7946.
+func shapefileRings(coords [][][][]float64) [][][]float64 {
+	rings := [][][]float64{}
+	for _, poly := range coords {
+		for i, ring := range poly {
+			if len(ring) < 3 {
+				continue
+			}
+			r := make([][]float64, len(ring), len(ring)+1)
+			copy(r, ring)
+			first, last := r[0], r[len(r)-1]
+			if first[0] != last[0] || first[1] != last[1] {
+				r = append(r, first)
+			}
+			if isClockwise(r) != (i == 0) {
+				reverseJsonRing(r)
+			}
+			rings = append(rings, r)
+		}
+	}
+	return rings
+}
+
+// Appends coords as a polygon record content. Shapefiles have no multipolygon
+// type, polygons store all rings as parts.
+func appendShpPolygon(buf []byte, coords [][][][]float64) []byte {
+	rings := shapefileRings(coords)
+	bbox := NewBBox()
+	points := 0
+	for _, ring := range rings {
+		points += len(ring)
+		for _, p := range ring {
+			bbox.Add(p[0], p[1])
+		}
+	}
+	buf = appendShpInt32(buf, binary.LittleEndian, shpPolygon)
+	buf = appendShpBBox(buf, bbox)
+	buf = appendShpInt32(buf, binary.LittleEndian, len(rings))
+	buf = appendShpInt32(buf, binary.LittleEndian, points)
+	offset := 0
+	for _, ring := range rings {
+		buf = appendShpInt32(buf, binary.LittleEndian, offset)
+		offset += len(ring)
+	}
+	for _, ring := range rings {
+		for _, p := range ring {
+			buf = appendShpFloat(buf, p[0])
+			buf = appendShpFloat(buf, p[1])
+		}
+	}
+	return buf
+}
+
+// Truncates s to at most n bytes without splitting a UTF-8 sequence.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func (w *ShapefileWriter) dbfRecordSize() int {
+	size := 1
+	for _, f := range shapefileFields {
+		size += f.Length
+	}
+	return size
+}
+
+func (w *ShapefileWriter) dbfHeader(now time.Time) []byte {
+	headerSize := 32 + dbfFieldSize*len(shapefileFields) + 1
+	buf := make([]byte, 32, headerSize)
+	buf[0] = 0x03
+	buf[1] = byte(now.Year() - 1900)
+	buf[2] = byte(now.Month())
+	buf[3] = byte(now.Day())
+	binary.LittleEndian.PutUint32(buf[4:], uint32(w.count))
+	binary.LittleEndian.PutUint16(buf[8:], uint16(headerSize))
+	binary.LittleEndian.PutUint16(buf[10:], uint16(w.dbfRecordSize()))
+	for _, f := range shapefileFields {
+		field := make([]byte, dbfFieldSize)
+		copy(field, f.Name)
+		field[11] = f.Type
+		field[16] = byte(f.Length)
+		buf = append(buf, field...)
+	}
+	return append(buf, 0x0D)
+}
+
+func appendDbfValue(buf []byte, f dbfField, value string) []byte {
+	value = truncateUTF8(value, f.Length)
+	pad := strings.Repeat(" ", f.Length-len(value))
+	if f.Type == 'N' {
+		return append(append(buf, pad...), value...)
+	}
+	return append(append(buf, value...), pad...)
+}
+
+func (w *ShapefileWriter) Write(js *RelationJson) error {
+	content := appendShpPolygon(w.buf[:0], js.Location.Coordinates)
+	w.buf = content
+	recordSize := int64(8 + len(content))
+	if w.size+recordSize > shpMaxSize {
+		return fmt.Errorf("shapefile size limit exceeded")
+	}
+	w.count++
+	header := appendShpInt32(nil, binary.BigEndian, w.count)
+	header = appendShpInt32(header, binary.BigEndian, len(content)/2)
+	if _, err := w.shp.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.shp.Write(content); err != nil {
+		return err
+	}
+	index := appendShpInt32(nil, binary.BigEndian, int(w.size/2))
+	index = appendShpInt32(index, binary.BigEndian, len(content)/2)
+	if _, err := w.shx.Write(index); err != nil {
+		return err
+	}
+	w.size += recordSize
+	w.bbox.AddMultiPolygon(js.Location.Coordinates)
+
+	level := ""
+	if js.AdminLevel > 0 {
+		level = strconv.Itoa(js.AdminLevel)
+	}
+	values := []string{js.Id, js.Name, level, js.CountryIso2, js.CountryIso3}
+	rec := make([]byte, 0, w.dbfRecordSize())
+	rec = append(rec, ' ')
+	for i, f := range shapefileFields {
+		rec = appendDbfValue(rec, f, values[i])
+	}
+	_, err := w.dbf.Write(rec)
+	return err
+}
+
+func (w *ShapefileWriter) shpHeader(size int64) []byte {
+	buf := appendShpInt32(nil, binary.BigEndian, shpFileCode)
+	buf = append(buf, make([]byte, 20)...)
+	buf = appendShpInt32(buf, binary.BigEndian, int(size/2))
+	buf = appendShpInt32(buf, binary.LittleEndian, shpVersion)
+	buf = appendShpInt32(buf, binary.LittleEndian, shpPolygon)
+	buf = appendShpBBox(buf, w.bbox)
+	// Z and M ranges
+	return append(buf, make([]byte, 32)...)
+}
+
+// Commit rewrites the headers and moves all files to their final paths.
+func (w *ShapefileWriter) Commit() error {
+	defer w.Abort()
+	err := w.shp.WriteAt(w.shpHeader(w.size), 0)
+	if err != nil {
+		return err
+	}
+	err = w.shx.WriteAt(w.shpHeader(shpHeaderSize+8*int64(w.count)), 0)
+	if err != nil {
+		return err
+	}
+	err = w.dbf.WriteAt(w.dbfHeader(time.Now()), 0)
+	if err != nil {
+		return err
+	}
+	_, err = w.dbf.Write([]byte{0x1A})
+	if err != nil {
+		return err
+	}
+	extra := map[string]string{
+		".prj": shpPrjWGS84,
+		".cpg": "UTF-8",
+	}
+	for ext, content := range extra {
+		fp, err := CreateOutputFile(w.base+ext, CompressNone)
+		if err != nil {
+			return err
+		}
+		_, err = fp.Write([]byte(content))
+		if err != nil {
+			fp.Abort()
+			return err
+		}
+		err = fp.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range []*OutputFile{w.shp, w.shx, w.dbf} {
+		err = f.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShapefileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "boundaries")
+	w, err := CreateShapefile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rels := []*RelationJson{
+		{
+			Id:          "1",
+			Name:        "Île-de-France",
+			AdminLevel:  4,
+			CountryIso2: "FR",
+			CountryIso3: "FRA",
+			Location: Location{
+				// Counter-clockwise shell with a clockwise hole
+				Coordinates: [][][][]float64{{
+					{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+					{{1, 1}, {1, 2}, {2, 2}, {2, 1}, {1, 1}},
+				}},
+			},
+		},
+		{
+			Id:   "2",
+			Name: strings.Repeat("é", 200),
+			Location: Location{
+				Coordinates: [][][][]float64{
+					{{{-1, -2}, {-1, -1}, {0, -1}}},
+					{{{5, 5}, {6, 5}, {6, 6}, {5, 5}}},
+				},
+			},
+		},
+	}
+	for _, js := range rels {
+		if err := w.Write(js); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(ext string) []byte {
+		data, err := ioutil.ReadFile(base + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	shp, shx, dbf := read(".shp"), read(".shx"), read(".dbf")
+	if s := string(read(".cpg")); s != "UTF-8" {
+		t.Fatalf("unexpected encoding: %q", s)
+	}
+	read(".prj")
+	tmp, err := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	if err != nil || len(tmp) > 0 {
+		t.Fatalf("temporary files left: %v %v", tmp, err)
+	}
+
+	be, le := binary.BigEndian, binary.LittleEndian
+	float := func(data []byte, off int) float64 {
+		return math.Float64frombits(le.Uint64(data[off:]))
+	}
+	if be.Uint32(shp) != shpFileCode || int(be.Uint32(shp[24:]))*2 != len(shp) {
+		t.Fatalf("invalid shp header")
+	}
+	if le.Uint32(shp[32:]) != shpPolygon {
+		t.Fatalf("invalid shape type: %d", le.Uint32(shp[32:]))
+	}
+	bbox := []float64{float(shp, 36), float(shp, 44), float(shp, 52), float(shp, 60)}
+	if bbox[0] != -1 || bbox[1] != -2 || bbox[2] != 6 || bbox[3] != 6 {
+		t.Fatalf("unexpected bbox: %v", bbox)
+	}
+	if int(be.Uint32(shx[24:]))*2 != len(shx) || len(shx) != 100+2*8 {
+		t.Fatalf("invalid shx length: %d", len(shx))
+	}
+
+	// Check each record through the index
+	parts := [][]int{{5, 5}, {4, 4}}
+	for i := range rels {
+		offset := int(be.Uint32(shx[100+i*8:])) * 2
+		length := int(be.Uint32(shx[104+i*8:])) * 2
+		if int(be.Uint32(shp[offset:])) != i+1 ||
+			int(be.Uint32(shp[offset+4:]))*2 != length {
+			t.Fatalf("invalid record %d header", i)
+		}
+		rec := shp[offset+8 : offset+8+length]
+		nparts, npoints := int(le.Uint32(rec[36:])), int(le.Uint32(rec[40:]))
+		if nparts != len(parts[i]) {
+			t.Fatalf("record %d: unexpected parts: %d", i, nparts)
+		}
+		points := rec[44+4*nparts:]
+		if len(points) != npoints*16 {
+			t.Fatalf("record %d: unexpected points size: %d", i, len(points))
+		}
+		for j := 0; j < nparts; j++ {
+			start := int(le.Uint32(rec[44+4*j:]))
+			end := npoints
+			if j+1 < nparts {
+				end = int(le.Uint32(rec[48+4*j:]))
+			}
+			if end-start != parts[i][j] {
+				t.Fatalf("record %d: part %d has %d points", i, j, end-start)
+			}
+			ring := [][]float64{}
+			for k := start; k < end; k++ {
+				ring = append(ring, []float64{float(points, k*16), float(points, k*16+8)})
+			}
+			// Outer rings are clockwise, holes counter-clockwise
+			if isClockwise(ring) != (i == 1 || j == 0) {
+				t.Fatalf("record %d: part %d has wrong orientation: %v", i, j, ring)
+			}
+		}
+	}
+
+	if le.Uint32(dbf[4:]) != 2 || dbf[len(dbf)-1] != 0x1A {
+		t.Fatalf("invalid dbf header or footer")
+	}
+	headerSize, recordSize := int(le.Uint16(dbf[8:])), int(le.Uint16(dbf[10:]))
+	if headerSize+2*recordSize+1 != len(dbf) {
+		t.Fatalf("invalid dbf size: %d", len(dbf))
+	}
+	fields := func(rec []byte) []string {
+		values := []string{}
+		rec = rec[1:]
+		for _, f := range shapefileFields {
+			values = append(values, strings.TrimSpace(string(rec[:f.Length])))
+			rec = rec[f.Length:]
+		}
+		return values
+	}
+	got := strings.Join(fields(dbf[headerSize:]), "|")
+	if got != "1|Île-de-France|4|FR|FRA" {
+		t.Fatalf("unexpected attributes: %s", got)
+	}
+	values := fields(dbf[headerSize+recordSize:])
+	if values[1] != strings.Repeat("é", 127) || values[2] != "" {
+		t.Fatalf("unexpected attributes: %v", values)
+	}
+}
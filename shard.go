@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Shard selects a disjoint subset of relations, so several processes or
+// machines can share the work of a command. Relations are assigned to shards
+// by id modulo the shard count, which balances shards better than contiguous
+// id ranges as recent relations tend to be smaller.
+type Shard struct {
+	Index int64
+	Count int64
+}
+
+// Parses an "i/N" shard specification where 0 <= i < N. An empty string
+// returns a shard containing everything.
+func parseShard(s string) (Shard, error) {
+	if s == "" {
+		return Shard{0, 1}, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return Shard{}, fmt.Errorf("invalid shard, expected i/N: %s", s)
+	}
+	index, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard index: %s", s)
+	}
+	count, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard count: %s", s)
+	}
+	if count < 1 || index < 0 || index >= count {
+		return Shard{}, fmt.Errorf("shard index must be in [0, N): %s", s)
+	}
+	return Shard{index, count}, nil
+}
+
+func (s Shard) Contains(id int64) bool {
+	if id < 0 {
+		id = -id
+	}
+	return id%s.Count == s.Index
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestParseShard(t *testing.T) {
+	s, err := parseShard("")
+	if err != nil || !s.Contains(12) {
+		t.Fatalf("default shard should contain everything: %v", err)
+	}
+	s, err = parseShard("1/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Contains(4) || s.Contains(5) || s.Contains(6) {
+		t.Fatalf("unexpected shard membership")
+	}
+	for _, invalid := range []string{"1", "3/3", "-1/2", "a/2", "1/0", "1/2/3"} {
+		if _, err := parseShard(invalid); err == nil {
+			t.Fatalf("invalid shard accepted: %s", invalid)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The spatial index stores location bounding boxes in a hierarchical grid.
+// Level L splits the world in 2^L x 2^L cells and each location is indexed
+// at the finest level where its bbox covers at most 2x2 cells. Keys are:
+//
+//	level (1 byte) | x (4 bytes) | y (4 bytes) | relation id (8 bytes)
+//
+// all big-endian, so the cells of a grid column are contiguous. Values hold
+// the bbox as 4 little-endian float64: min lon, min lat, max lon, max lat.
+var (
+	spatialBucket = []byte("spatial")
+)
+
+const (
+	maxSpatialLevel = 16
+	spatialKeyLen   = 17
+)
+
+func getSpatialCell(level int, lon, lat float64) (uint32, uint32) {
+	n := float64(uint32(1) << uint(level))
+	x := math.Floor((lon + 180) / 360 * n)
+	y := math.Floor((lat + 90) / 180 * n)
+	clamp := func(v float64) uint32 {
+		return uint32(math.Max(0, math.Min(n-1, v)))
+	}
+	return clamp(x), clamp(y)
+}
+
+// spatialRange is a range of cells at a given level.
+type spatialRange struct {
+	Level      int
+	MinX, MinY uint32
+	MaxX, MaxY uint32
+}
+
+func getSpatialRange(level int, b *BBox) spatialRange {
+	minX, minY := getSpatialCell(level, b.MinLon, b.MinLat)
+	maxX, maxY := getSpatialCell(level, b.MaxLon, b.MaxLat)
+	return spatialRange{
+		Level: level,
+		MinX:  minX,
+		MinY:  minY,
+		MaxX:  maxX,
+		MaxY:  maxY,
+	}
+}
+
+// Returns the cells where bbox b is indexed.
+func getSpatialCovering(b *BBox) spatialRange {
+	for level := maxSpatialLevel; level > 0; level-- {
+		r := getSpatialRange(level, b)
+		if r.MaxX-r.MinX <= 1 && r.MaxY-r.MinY <= 1 {
+			return r
+		}
+	}
+	return getSpatialRange(0, b)
+}
+
+func makeSpatialKey(level int, x, y uint32, id int64) []byte {
+	key := make([]byte, spatialKeyLen)
+	key[0] = byte(level)
+	binary.BigEndian.PutUint32(key[1:], x)
+	binary.BigEndian.PutUint32(key[5:], y)
+	binary.BigEndian.PutUint64(key[9:], uint64(id))
+	return key
+}
+
+func encodeSpatialBBox(b *BBox) []byte {
+	data := make([]byte, 32)
+	for i, v := range []float64{b.MinLon, b.MinLat, b.MaxLon, b.MaxLat} {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	return data
+}
+
+func decodeSpatialBBox(data []byte) (*BBox, error) {
+	if len(data) != 32 {
+		return nil, fmt.Errorf("invalid spatial bbox length: %d", len(data))
+	}
+	v := func(i int) float64 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+	}
+	b := NewBBox()
+	b.Add(v(0), v(1))
+	b.Add(v(2), v(3))
+	return b, nil
+}
+
+func bboxIntersects(a, b *BBox) bool {
+	return !a.empty && !b.empty &&
+		a.MinLon <= b.MaxLon && b.MinLon <= a.MaxLon &&
+		a.MinLat <= b.MaxLat && b.MinLat <= a.MaxLat
+}
+
+// Rebuilds the spatial index from the stored locations. Returns the number of
+// indexed locations.
+func (db *WaysDb) BuildSpatialIndex() (int, error) {
+	type entry struct {
+		id   int64
+		bbox *BBox
+	}
+	entries := []entry{}
+	err := db.db.View(func(tx kvTx) error {
+		return tx.ForEach(locationsBucket, nil, func(k, v []byte) error {
+			id, n := binary.Varint(k)
+			if n <= 0 {
+				return fmt.Errorf("invalid %s key: %x", locationsBucket, k)
+			}
+			loc := &Location{}
+			if err := decodeLocation(v, loc); err != nil {
+				return fmt.Errorf("cannot decode location %d: %s", id, err)
+			}
+			b := NewBBox()
+			b.AddMultiPolygon(loc.Coordinates)
+			if !b.empty {
+				entries = append(entries, entry{id, b})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = db.db.Update(func(tx kvTx) error {
+		return tx.ClearBucket(spatialBucket)
+	})
+	if err != nil {
+		return 0, err
+	}
+	batch := db.NewBatch(10000)
+	for _, e := range entries {
+		r := getSpatialCovering(e.bbox)
+		value := encodeSpatialBBox(e.bbox)
+		for x := r.MinX; x <= r.MaxX; x++ {
+			for y := r.MinY; y <= r.MaxY; y++ {
+				err := batch.putKey(spatialBucket,
+					makeSpatialKey(r.Level, x, y, e.id), value)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	return len(entries), batch.Flush()
+}
+
+// Returns the sorted ids of locations whose bbox intersects b.
+func (db *WaysDb) GetLocationsInBBox(b *BBox) ([]int64, error) {
+	found := map[int64]bool{}
+	err := db.db.View(func(tx kvTx) error {
+		for level := 0; level <= maxSpatialLevel; level++ {
+			r := getSpatialRange(level, b)
+			from := makeSpatialKey(level, r.MinX, 0, 0)
+			err := tx.ForEach(spatialBucket, from, func(k, v []byte) error {
+				if len(k) != spatialKeyLen {
+					return fmt.Errorf("invalid %s key: %x", spatialBucket, k)
+				}
+				x := binary.BigEndian.Uint32(k[1:])
+				if int(k[0]) != level || x > r.MaxX {
+					return errStopIteration
+				}
+				y := binary.BigEndian.Uint32(k[5:])
+				if y < r.MinY || y > r.MaxY {
+					return nil
+				}
+				id := int64(binary.BigEndian.Uint64(k[9:]))
+				if found[id] {
+					return nil
+				}
+				other, err := decodeSpatialBBox(v)
+				if err != nil {
+					return err
+				}
+				if bboxIntersects(b, other) {
+					found[id] = true
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// Returns the sorted ids of locations containing (lon, lat).
+func (db *WaysDb) GetLocationsContaining(lon, lat float64) ([]int64, error) {
+	b := NewBBox()
+	b.Add(lon, lat)
+	candidates, err := db.GetLocationsInBBox(b)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	for _, id := range candidates {
+		loc, err := db.GetLocation(id)
+		if err != nil {
+			return nil, err
+		}
+		if loc != nil && isInMultiPolygon(loc.Coordinates, lon, lat) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpatialCovering(t *testing.T) {
+	b := NewBBox()
+	b.Add(-180, -90)
+	b.Add(180, 90)
+	if r := getSpatialCovering(b); r.Level != 1 || r.MaxX != 1 || r.MaxY != 1 {
+		t.Fatalf("unexpected world covering: %+v", r)
+	}
+	b = NewBBox()
+	b.Add(2.35, 48.85)
+	if r := getSpatialCovering(b); r.Level != maxSpatialLevel ||
+		r.MinX != r.MaxX || r.MinY != r.MaxY {
+		t.Fatalf("unexpected point covering: %+v", r)
+	}
+	// Straddling the meridian, 2x2 cells of 1.4 x 0.7 degrees
+	b = NewBBox()
+	b.Add(-1, 10)
+	b.Add(1, 11)
+	r := getSpatialCovering(b)
+	if r.Level != 8 || r.MinX != 127 || r.MaxX != 128 || r.MaxY-r.MinY != 1 {
+		t.Fatalf("unexpected covering: %+v", r)
+	}
+}
+
+func TestSpatialIndex(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+
+	square := func(x, y, size float64) *Location {
+		return &Location{
+			Type: "MultiPolygon",
+			Coordinates: [][][][]float64{{{
+				{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size},
+				{x, y}}}},
+		}
+	}
+	locations := map[int64]*Location{
+		1: square(-10, 40, 20),
+		2: square(2, 48, 1),
+		3: square(2.2, 48.7, 0.01),
+		4: square(100, -10, 5),
+		5: {Type: "MultiPolygon"},
+	}
+	for id, loc := range locations {
+		if err := db.PutLocation(id, loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := db.BuildSpatialIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("unexpected indexed count: %d", n)
+	}
+
+	ids, err := db.GetLocationsContaining(2.205, 48.705)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("unexpected containing locations: %v", ids)
+	}
+	ids, err = db.GetLocationsContaining(2.5, 48.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Fatalf("unexpected containing locations: %v", ids)
+	}
+	ids, err = db.GetLocationsContaining(50, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("unexpected containing locations: %v", ids)
+	}
+
+	b := NewBBox()
+	b.Add(0, -20)
+	b.Add(101, 0)
+	ids, err = db.GetLocationsInBBox(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{4}) {
+		t.Fatalf("unexpected locations in bbox: %v", ids)
+	}
+
+	// Rebuilding drops deleted locations
+	if err := db.DeleteLocation(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.BuildSpatialIndex(); err != nil {
+		t.Fatal(err)
+	}
+	ids, err = db.GetLocationsContaining(2.5, 48.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{2}) {
+		t.Fatalf("unexpected containing locations: %v", ids)
+	}
+}
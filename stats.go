@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ElementStats summarizes the elements of one type.
+type ElementStats struct {
+	Count    int   `json:"count"`
+	Untagged int   `json:"untagged"`
+	MinId    int64 `json:"min_id"`
+	MaxId    int64 `json:"max_id"`
+}
+
+func (s *ElementStats) Add(id int64, tags int) {
+	if s.Count == 0 || id < s.MinId {
+		s.MinId = id
+	}
+	if s.Count == 0 || id > s.MaxId {
+		s.MaxId = id
+	}
+	s.Count++
+	if tags == 0 {
+		s.Untagged++
+	}
+}
+
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// ContentStats describes the content of an OSM file, to sanity check
+// extracts.
+type ContentStats struct {
+	Nodes     ElementStats `json:"nodes"`
+	Ways      ElementStats `json:"ways"`
+	Relations ElementStats `json:"relations"`
+	// Extent of the nodes, nil if there are none
+	BBox []float64 `json:"bbox,omitempty"`
+	// Edit timestamps range, when the file has metadata
+	MinTimestamp string `json:"min_timestamp,omitempty"`
+	MaxTimestamp string `json:"max_timestamp,omitempty"`
+	// Ratio of nodes without tags, usually way geometry nodes
+	UntaggedNodes float64 `json:"untagged_nodes_ratio"`
+	// Most frequent tag keys, by decreasing count
+	Keys []KeyCount `json:"keys"`
+}
+
+// Reads every element of r and returns its statistics with the top most
+// frequent tag keys.
+func computeContentStats(r OSMReader, top int) (*ContentStats, error) {
+	stats := &ContentStats{}
+	bbox := NewBBox()
+	keys := map[string]int{}
+	minTs, maxTs := 0, 0
+	addMeta := func(m *Metadata) {
+		if m.Timestamp <= 0 {
+			return
+		}
+		if minTs == 0 || m.Timestamp < minTs {
+			minTs = m.Timestamp
+		}
+		if m.Timestamp > maxTs {
+			maxTs = m.Timestamp
+		}
+	}
+	addTags := func(tags []StringPair) {
+		for _, tag := range tags {
+			keys[tag.Key]++
+		}
+	}
+	for r.Next() {
+		switch r.Kind() {
+		case NodeKind:
+			n := r.Node()
+			stats.Nodes.Add(n.Id, len(n.Tags))
+			bbox.Add(float64(n.Lon)/1e7, float64(n.Lat)/1e7)
+			addMeta(&n.Meta)
+			addTags(n.Tags)
+		case WayKind:
+			w := r.Way()
+			stats.Ways.Add(w.Id, len(w.Tags))
+			addMeta(&w.Meta)
+			addTags(w.Tags)
+		case RelationKind:
+			rel := r.Relation()
+			stats.Relations.Add(rel.Id, len(rel.Tags))
+			addMeta(&rel.Meta)
+			addTags(rel.Tags)
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	stats.BBox = bbox.Slice()
+	stats.MinTimestamp = formatTimestamp(minTs)
+	stats.MaxTimestamp = formatTimestamp(maxTs)
+	if stats.Nodes.Count > 0 {
+		stats.UntaggedNodes = float64(stats.Nodes.Untagged) /
+			float64(stats.Nodes.Count)
+	}
+	stats.Keys = []KeyCount{}
+	for k, n := range keys {
+		stats.Keys = append(stats.Keys, KeyCount{Key: k, Count: n})
+	}
+	sort.Slice(stats.Keys, func(i, j int) bool {
+		a, b := stats.Keys[i], stats.Keys[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Key < b.Key
+	})
+	if top >= 0 && len(stats.Keys) > top {
+		stats.Keys = stats.Keys[:top]
+	}
+	return stats, nil
+}
+
+func printContentStats(w io.Writer, s *ContentStats) {
+	for _, e := range []struct {
+		Name  string
+		Stats ElementStats
+	}{
+		{"nodes", s.Nodes},
+		{"ways", s.Ways},
+		{"relations", s.Relations},
+	} {
+		fmt.Fprintf(w, "%s: count=%d untagged=%d min_id=%d max_id=%d\n",
+			e.Name, e.Stats.Count, e.Stats.Untagged, e.Stats.MinId, e.Stats.MaxId)
+	}
+	fmt.Fprintf(w, "untagged nodes: %.1f%%\n", 100*s.UntaggedNodes)
+	if s.BBox != nil {
+		fmt.Fprintf(w, "bbox: %f,%f,%f,%f\n", s.BBox[0], s.BBox[1], s.BBox[2],
+			s.BBox[3])
+	}
+	if s.MinTimestamp != "" {
+		fmt.Fprintf(w, "timestamps: %s %s\n", s.MinTimestamp, s.MaxTimestamp)
+	}
+	fmt.Fprintln(w, "keys:")
+	for _, k := range s.Keys {
+		fmt.Fprintf(w, "  %s: %d\n", k.Key, k.Count)
+	}
+}
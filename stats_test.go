@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestContentStats(t *testing.T) {
+	path := writeTestXml(t, testOsmXml)
+	defer os.Remove(path)
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stats, err := computeContentStats(r, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Nodes != (ElementStats{Count: 2, Untagged: 1, MinId: 1, MaxId: 2}) ||
+		stats.Ways != (ElementStats{Count: 1, MinId: 10, MaxId: 10}) ||
+		stats.Relations.Count != 1 || stats.UntaggedNodes != 0.5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if !reflect.DeepEqual(stats.BBox, []float64{-179.9999999, -0.5, 5.7346073, 45.191733}) {
+		t.Fatalf("unexpected bbox: %v", stats.BBox)
+	}
+	if stats.MinTimestamp != "2020-01-02T03:04:05Z" ||
+		stats.MaxTimestamp != stats.MinTimestamp {
+		t.Fatalf("unexpected timestamps: %s %s", stats.MinTimestamp,
+			stats.MaxTimestamp)
+	}
+	expected := []KeyCount{{Key: "highway", Count: 1}, {Key: "name", Count: 1}}
+	if !reflect.DeepEqual(stats.Keys, expected) {
+		t.Fatalf("unexpected keys: %+v", stats.Keys)
+	}
+}
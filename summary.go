@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// Counters of the running command, written by --summary-json.
+	runSummary = NewCommandSummary()
+)
+
+// CommandSummary is the machine-readable outcome of a command, for
+// orchestration systems to check pipeline health.
+type CommandSummary struct {
+	Command   string  `json:"command"`
+	Start     string  `json:"start"`
+	DurationS float64 `json:"duration_s"`
+	Success   bool    `json:"success"`
+	Error     string  `json:"error,omitempty"`
+	// Number of warnings and errors logged
+	Warnings int64 `json:"warnings"`
+	Errors   int64 `json:"errors"`
+	// Command specific counters, like written documents
+	Counts map[string]int64 `json:"counts"`
+	// Relations skipped by reason
+	Skipped map[string]int64 `json:"skipped"`
+	// Elements which failed, with --on-error collect
+	Failures []ElementFailure `json:"failures,omitempty"`
+
+	lock  sync.Mutex
+	start time.Time
+}
+
+func NewCommandSummary() *CommandSummary {
+	return &CommandSummary{
+		Counts:  map[string]int64{},
+		Skipped: map[string]int64{},
+		start:   time.Now(),
+	}
+}
+
+// Add increments counter name by n.
+func (s *CommandSummary) Add(name string, n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Counts[name] += int64(n)
+}
+
+// Skip records a relation skipped for reason.
+func (s *CommandSummary) Skip(reason string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Skipped[reason]++
+}
+
+// Fail records element failed to be processed by msg.
+func (s *CommandSummary) Fail(element, msg string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Failures = append(s.Failures, ElementFailure{
+		Element: element,
+		Message: msg,
+		Error:   err.Error(),
+	})
+}
+
+// GetFailures returns a copy of the recorded failures.
+func (s *CommandSummary) GetFailures() []ElementFailure {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]ElementFailure(nil), s.Failures...)
+}
+
+func (s *CommandSummary) logged(level slog.Level) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if level >= slog.LevelError {
+		s.Errors++
+	} else if level >= slog.LevelWarn {
+		s.Warnings++
+	}
+}
+
+// Finish records the command outcome and writes the summary as JSON to
+// path, or stdout if path is "-".
+func (s *CommandSummary) Finish(cmd string, cmdErr error, path string) error {
+	s.lock.Lock()
+	s.Command = cmd
+	s.Start = s.start.UTC().Format(time.RFC3339)
+	s.DurationS = time.Since(s.start).Seconds()
+	s.Success = cmdErr == nil
+	if cmdErr != nil {
+		s.Error = cmdErr.Error()
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Like ignoreRelation, but records why relations are skipped in runSummary.
+func skipRelation(rel *Relation) (bool, error) {
+	reason, err := getIgnoreReason(rel)
+	if reason != "" {
+		runSummary.Skip(reason)
+	}
+	return reason != "", err
+}
+
+// summaryHandler counts warnings and errors in runSummary, including those
+// below the configured log level.
+type summaryHandler struct {
+	slog.Handler
+}
+
+func (h *summaryHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h *summaryHandler) Handle(ctx context.Context, r slog.Record) error {
+	runSummary.logged(r.Level)
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *summaryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &summaryHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *summaryHandler) WithGroup(name string) slog.Handler {
+	return &summaryHandler{h.Handler.WithGroup(name)}
+}
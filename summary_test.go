@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommandSummary(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	defer func() { runSummary = NewCommandSummary() }()
+	runSummary = NewCommandSummary()
+
+	buf := &bytes.Buffer{}
+	if err := setupLogging(buf, "error", LogFormatText); err != nil {
+		t.Fatal(err)
+	}
+	slog.Warn("not printed but counted")
+	slog.Error("printed and counted")
+	if bytes.Contains(buf.Bytes(), []byte("not printed")) {
+		t.Fatalf("warning should not be logged: %s", buf.String())
+	}
+
+	rel := &Relation{Id: 1, Tags: []StringPair{
+		{Key: "name", Value: "a"},
+		{Key: "admin_level", Value: "10"},
+	}}
+	if ok, err := skipRelation(rel); !ok || err != nil {
+		t.Fatalf("relation should be skipped: %v, %v", ok, err)
+	}
+	runSummary.Add("written", 3)
+
+	dir, err := ioutil.TempDir("", "osm-summary-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "summary.json")
+	err = runSummary.Finish("geojson", fmt.Errorf("failed"), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := &CommandSummary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Command != "geojson" || summary.Success ||
+		summary.Error != "failed" || summary.Warnings != 1 ||
+		summary.Errors != 1 || summary.Counts["written"] != 3 ||
+		summary.Skipped[IgnoreAdminLevel] != 1 {
+		t.Fatalf("unexpected summary: %s", data)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// TileKey identifies a cell of a regular lon/lat grid.
+type TileKey struct {
+	X int
+	Y int
+}
+
+func (k TileKey) String() string {
+	return fmt.Sprintf("%d_%d", k.X, k.Y)
+}
+
+var (
+	// Relations whose extent cannot be computed from their direct ways.
+	noTile = TileKey{math.MaxInt32, math.MaxInt32}
+)
+
+// Returns the extent of the ways directly referenced by rel, in o5m fixed
+// point coordinates. The returned boolean is false if no way could be
+// resolved.
+func getRelationBounds(rel *Relation, db *WaysDb) (Point, Point, bool, error) {
+	min := Point{math.MaxInt64, math.MaxInt64}
+	max := Point{math.MinInt64, math.MinInt64}
+	found := false
+	for _, ref := range rel.Refs {
+		if ref.Type != 1 {
+			continue
+		}
+		way, err := db.Get(ref.Id)
+		if err != nil {
+			return min, max, false, err
+		}
+		if way == nil {
+			continue
+		}
+		for _, p := range way.Points {
+			found = true
+			if p.Lon < min.Lon {
+				min.Lon = p.Lon
+			}
+			if p.Lat < min.Lat {
+				min.Lat = p.Lat
+			}
+			if p.Lon > max.Lon {
+				max.Lon = p.Lon
+			}
+			if p.Lat > max.Lat {
+				max.Lat = p.Lat
+			}
+		}
+	}
+	return min, max, found, nil
+}
+
+// Returns the tile containing the center of relation extent, for tiles of
+// tileSize degrees.
+func getRelationTile(rel *Relation, db *WaysDb, tileSize float64) (TileKey, error) {
+	min, max, ok, err := getRelationBounds(rel, db)
+	if err != nil || !ok {
+		return noTile, err
+	}
+	lon := float64(min.Lon+max.Lon) / 2e7
+	lat := float64(min.Lat+max.Lat) / 2e7
+	return TileKey{
+		X: int(math.Floor((lon + 180) / tileSize)),
+		Y: int(math.Floor((lat + 90) / tileSize)),
+	}, nil
+}
+
+// locationResult holds the outcome of building a relation location.
+type locationResult struct {
+	Relation *Relation
+	Location *Location
+	Err      error
+	// Checkpoint sequence number, see checkpointQueue
+	Seq int
+}
+
+// Builds the locations of a set of relations grouped by tile. Each tile is
+// processed by a single worker writing into its own shard database next to
+// the main one, so workers do not contend on the main database single writer
+// lock. Shards are merged back into db once all tiles are done. Results are
+// passed to report from a single goroutine.
+func buildLocationsByTile(tiles map[TileKey][]*Relation, db *WaysDb,
+	dbPath string, workers int, report func(locationResult)) error {
+
+	// Process the most loaded tiles first to balance workers
+	keys := []TileKey{}
+	for k := range tiles {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(tiles[keys[i]]) > len(tiles[keys[j]])
+	})
+
+	pendings := make(chan TileKey)
+	results := make(chan locationResult)
+	shards := make(chan string, len(keys))
+	var err error
+	errLock := sync.Mutex{}
+	setErr := func(e error) {
+		errLock.Lock()
+		defer errLock.Unlock()
+		if err == nil {
+			err = e
+		}
+	}
+	running := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for k := range pendings {
+				path := fmt.Sprintf("%s.tile.%s", dbPath, k)
+				shard, err := OpenWaysDb(path)
+				if err != nil {
+					setErr(err)
+					continue
+				}
+				shards <- path
+				for _, rel := range tiles[k] {
+					loc, err := buildLocationTo(rel, db, shard)
+					results <- locationResult{
+						Relation: rel,
+						Location: loc,
+						Err:      err,
+					}
+				}
+				err = shard.Close()
+				if err != nil {
+					setErr(err)
+				}
+			}
+		}()
+	}
+	go func() {
+		for _, k := range keys {
+			pendings <- k
+		}
+		close(pendings)
+	}()
+	go func() {
+		running.Wait()
+		close(results)
+		close(shards)
+	}()
+	for res := range results {
+		report(res)
+	}
+
+	// Merge shards, even after a failure, so completed work is kept
+	for path := range shards {
+		shard, e := OpenWaysDb(path)
+		if e != nil {
+			setErr(e)
+			continue
+		}
+		n, e := db.CopyBucket(shard, locationsBucket)
+		if e == nil {
+			_, e = db.CopyBucket(shard, locationBuildsBucket)
+		}
+		if e == nil {
+			_, e = db.CopyBucket(shard, relationsBucket)
+		}
+		shard.Close()
+		if e != nil {
+			setErr(e)
+			continue
+		}
+		slog.Info("merged", "path", path, "locations", n)
+		os.RemoveAll(path)
+	}
+	return err
+}
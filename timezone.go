@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+const (
+	TimezoneSourceTag        = "tag"
+	TimezoneSourceBoundaries = "boundaries"
+)
+
+var (
+	// Attach a timezone to exported boundaries, from their timezone tag or
+	// timezoneBoundaries.
+	attachTimezones bool
+	// Timezone polygons located boundaries centers are looked up in, nil
+	// to only use timezone tags.
+	timezoneBoundaries *AdminIndex
+)
+
+// Reads a GeoJSON feature collection of timezone polygons and
+// multipolygons, named by a "tzid" property, like the ones published by
+// timezone-boundary-builder. path can be compressed or a http(s) URL.
+func loadTimezoneBoundaries(path string) (*AdminIndex, error) {
+	in, err := o5m.OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	collection := struct {
+		Features []struct {
+			Properties struct {
+				Tzid string `json:"tzid"`
+			} `json:"properties"`
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}{}
+	err = json.NewDecoder(in).Decode(&collection)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode timezone boundaries: %s", err)
+	}
+	idx := NewAdminIndex()
+	for i, f := range collection.Features {
+		if f.Properties.Tzid == "" {
+			return nil, fmt.Errorf("timezone boundary %d has no tzid", i)
+		}
+		polygons := [][][][]float64{}
+		switch f.Geometry.Type {
+		case "Polygon":
+			rings := [][][]float64{}
+			err = json.Unmarshal(f.Geometry.Coordinates, &rings)
+			polygons = append(polygons, rings)
+		case "MultiPolygon":
+			err = json.Unmarshal(f.Geometry.Coordinates, &polygons)
+		default:
+			err = fmt.Errorf("unsupported geometry type: %s", f.Geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone boundary %s: %s",
+				f.Properties.Tzid, err)
+		}
+		idx.Add(&AdminArea{
+			Id:          int64(i),
+			Name:        f.Properties.Tzid,
+			Coordinates: polygons,
+		})
+	}
+	return idx, nil
+}
+
+// Returns the timezone of a boundary and where it comes from, or empty
+// strings if it has none. The timezone tag wins over the boundaries
+// containing (lon, lat). When several do, because the dataset overlaps or
+// the point lies on a border, the first one in file order is returned.
+func findTimezone(tags []StringPair, boundaries *AdminIndex, lon, lat float64) (
+	string, string) {
+
+	for _, tag := range tags {
+		if tag.Key == "timezone" {
+			if tz := strings.TrimSpace(tag.Value); tz != "" {
+				return tz, TimezoneSourceTag
+			}
+		}
+	}
+	if boundaries != nil {
+		if found := boundaries.Lookup(lon, lat); len(found) > 0 {
+			return found[0].Name, TimezoneSourceBoundaries
+		}
+	}
+	return "", ""
+}
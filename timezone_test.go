@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFindTimezone(t *testing.T) {
+	fp, err := ioutil.TempFile("", "osm-timezones-*.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	// A square with a hole covered by another timezone
+	_, err = fp.WriteString(`{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{"tzid":"Europe/Paris"},"geometry":{"type":"Polygon",
+"coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[2,1],[2,2],[1,2],[1,1]]]}},
+{"type":"Feature","properties":{"tzid":"Europe/Andorra"},"geometry":{"type":"MultiPolygon",
+"coordinates":[[[[1,1],[2,1],[2,2],[1,2],[1,1]]]]}}
+]}`)
+	fp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := loadTimezoneBoundaries(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Areas) != 2 {
+		t.Fatalf("unexpected timezone boundaries: %d", len(idx.Areas))
+	}
+
+	tagged := []StringPair{{Key: "timezone", Value: " Europe/Madrid "}}
+	tests := []struct {
+		Tags       []StringPair
+		Boundaries *AdminIndex
+		Lon        float64
+		Lat        float64
+		Timezone   string
+		Source     string
+	}{
+		{nil, idx, 3, 3, "Europe/Paris", TimezoneSourceBoundaries},
+		{nil, idx, 1.5, 1.5, "Europe/Andorra", TimezoneSourceBoundaries},
+		{nil, idx, 5, 5, "", ""},
+		{tagged, idx, 3, 3, "Europe/Madrid", TimezoneSourceTag},
+		{tagged, nil, 3, 3, "Europe/Madrid", TimezoneSourceTag},
+		{nil, nil, 3, 3, "", ""},
+	}
+	for i, test := range tests {
+		tz, source := findTimezone(test.Tags, test.Boundaries, test.Lon,
+			test.Lat)
+		if tz != test.Timezone || source != test.Source {
+			t.Fatalf("%d: unexpected timezone: %q %q", i, tz, source)
+		}
+	}
+
+	// Features without tzid are rejected
+	err = ioutil.WriteFile(fp.Name(), []byte(`{"features":[{"properties":{},
+"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,1],[0,0]]]}}]}`),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTimezoneBoundaries(fp.Name()); err == nil {
+		t.Fatalf("timezone boundary without tzid was accepted")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// TopoJSON output, see https://github.com/topojson/topojson-specification.
+type TopoTransform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+type TopoGeometry struct {
+	Type       string                 `json:"type"`
+	Id         string                 `json:"id,omitempty"`
+	Arcs       [][][]int              `json:"arcs"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type TopoCollection struct {
+	Type       string          `json:"type"`
+	Geometries []*TopoGeometry `json:"geometries"`
+}
+
+type Topology struct {
+	Type      string                     `json:"type"`
+	BBox      []float64                  `json:"bbox,omitempty"`
+	Transform TopoTransform              `json:"transform"`
+	Objects   map[string]*TopoCollection `json:"objects"`
+	// Quantized and delta encoded positions
+	Arcs [][][2]int64 `json:"arcs"`
+}
+
+type topoPoint struct {
+	X, Y int64
+}
+
+func topoPointLess(a, b topoPoint) bool {
+	return a.X < b.X || (a.X == b.X && a.Y < b.Y)
+}
+
+// Neighbours of a point, ordered so both ring orientations match.
+type topoNeighbours struct {
+	a, b     topoPoint
+	junction bool
+}
+
+// topoBuilder cuts quantized rings into arcs at junctions, points where
+// rings stop following each other, and stores arcs shared by several rings
+// once.
+type topoBuilder struct {
+	neighbours map[topoPoint]*topoNeighbours
+	arcs       [][]topoPoint
+	// Arcs index by their points
+	arcIds map[string]int
+	buf    []byte
+}
+
+// Returns a copy of ring starting at index start.
+func rotateTopoRing(ring []topoPoint, start int) []topoPoint {
+	rotated := make([]topoPoint, 0, len(ring)+1)
+	rotated = append(rotated, ring[start:]...)
+	return append(rotated, ring[:start]...)
+}
+
+func (b *topoBuilder) addNeighbours(ring []topoPoint) {
+	n := len(ring)
+	for i, p := range ring {
+		prev, next := ring[(i+n-1)%n], ring[(i+1)%n]
+		if topoPointLess(next, prev) {
+			prev, next = next, prev
+		}
+		nb := b.neighbours[p]
+		if nb == nil {
+			b.neighbours[p] = &topoNeighbours{a: prev, b: next}
+		} else if nb.a != prev || nb.b != next {
+			nb.junction = true
+		}
+	}
+}
+
+func (b *topoBuilder) arcKey(points []topoPoint, reversed bool) string {
+	b.buf = b.buf[:0]
+	for i := range points {
+		p := points[i]
+		if reversed {
+			p = points[len(points)-1-i]
+		}
+		b.buf = o5m.AppendSigned(b.buf, p.X)
+		b.buf = o5m.AppendSigned(b.buf, p.Y)
+	}
+	return string(b.buf)
+}
+
+// Returns the index of arc, ~index if it is stored reversed.
+func (b *topoBuilder) addArc(arc []topoPoint) int {
+	if id, ok := b.arcIds[b.arcKey(arc, false)]; ok {
+		return id
+	}
+	if id, ok := b.arcIds[b.arcKey(arc, true)]; ok {
+		return ^id
+	}
+	id := len(b.arcs)
+	b.arcs = append(b.arcs, arc)
+	b.arcIds[b.arcKey(arc, false)] = id
+	return id
+}
+
+// Cuts ring at junctions and returns its arcs indexes.
+func (b *topoBuilder) ringArcs(ring []topoPoint) []int {
+	start := -1
+	for i, p := range ring {
+		if b.neighbours[p].junction {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		// Isolated or fully shared ring, start at its lowest point so
+		// identical rings produce the same arc
+		start = 0
+		for i, p := range ring {
+			if topoPointLess(p, ring[start]) {
+				start = i
+			}
+		}
+	}
+	ring = rotateTopoRing(ring, start)
+	ring = append(ring, ring[0])
+	ids := []int{}
+	first := 0
+	for i := 1; i < len(ring); i++ {
+		if i == len(ring)-1 || b.neighbours[ring[i]].junction {
+			ids = append(ids, b.addArc(ring[first:i+1]))
+			first = i
+		}
+	}
+	return ids
+}
+
+// Quantizes coordinates relative to translate, drops closing and repeated
+// points.
+func quantizeTopoRing(ring [][]float64, tr *TopoTransform) []topoPoint {
+	points := make([]topoPoint, 0, len(ring))
+	for _, c := range ring {
+		p := topoPoint{
+			X: int64(math.Round((c[0] - tr.Translate[0]) / tr.Scale[0])),
+			Y: int64(math.Round((c[1] - tr.Translate[1]) / tr.Scale[1])),
+		}
+		if len(points) > 0 && points[len(points)-1] == p {
+			continue
+		}
+		points = append(points, p)
+	}
+	if len(points) > 1 && points[0] == points[len(points)-1] {
+		points = points[:len(points)-1]
+	}
+	return points
+}
+
+// Converts features into a topology where borders shared by adjacent areas
+// are stored once. Coordinates are rounded to precision decimals.
+func buildTopology(features []*Feature, precision int) *Topology {
+	bbox := NewBBox()
+	for _, f := range features {
+		bbox.AddMultiPolygon(f.Geometry.Coordinates)
+	}
+	scale := math.Pow(10, -float64(precision))
+	topo := &Topology{
+		Type: "Topology",
+		BBox: bbox.Slice(),
+		Transform: TopoTransform{
+			Scale: [2]float64{scale, scale},
+		},
+		Objects: map[string]*TopoCollection{},
+	}
+	if !bbox.empty {
+		topo.Transform.Translate = [2]float64{bbox.MinLon, bbox.MinLat}
+	}
+
+	rings := make([][][][]topoPoint, len(features))
+	b := &topoBuilder{
+		neighbours: map[topoPoint]*topoNeighbours{},
+		arcIds:     map[string]int{},
+	}
+	for i, f := range features {
+		for _, poly := range f.Geometry.Coordinates {
+			qpoly := [][]topoPoint{}
+			for _, ring := range poly {
+				q := quantizeTopoRing(ring, &topo.Transform)
+				if len(q) < 3 {
+					continue
+				}
+				b.addNeighbours(q)
+				qpoly = append(qpoly, q)
+			}
+			if len(qpoly) > 0 {
+				rings[i] = append(rings[i], qpoly)
+			}
+		}
+	}
+
+	collection := &TopoCollection{
+		Type:       "GeometryCollection",
+		Geometries: []*TopoGeometry{},
+	}
+	for i, f := range features {
+		g := &TopoGeometry{
+			Type:       "MultiPolygon",
+			Id:         f.Id,
+			Arcs:       [][][]int{},
+			Properties: f.Properties,
+		}
+		for _, poly := range rings[i] {
+			arcs := [][]int{}
+			for _, ring := range poly {
+				arcs = append(arcs, b.ringArcs(ring))
+			}
+			g.Arcs = append(g.Arcs, arcs)
+		}
+		collection.Geometries = append(collection.Geometries, g)
+	}
+	topo.Objects["boundaries"] = collection
+
+	topo.Arcs = make([][][2]int64, 0, len(b.arcs))
+	for _, arc := range b.arcs {
+		encoded := make([][2]int64, len(arc))
+		prev := topoPoint{}
+		for i, p := range arc {
+			encoded[i] = [2]int64{p.X - prev.X, p.Y - prev.Y}
+			prev = p
+		}
+		topo.Arcs = append(topo.Arcs, encoded)
+	}
+	return topo
+}
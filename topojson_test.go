@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Rebuilds the rings of geometry g with absolute coordinates.
+func decodeTopoRings(topo *Topology, g *TopoGeometry) [][][]float64 {
+	rings := [][][]float64{}
+	for _, poly := range g.Arcs {
+		for _, ringArcs := range poly {
+			ring := [][]float64{}
+			for _, id := range ringArcs {
+				reversed := id < 0
+				if reversed {
+					id = ^id
+				}
+				points := [][]float64{}
+				x, y := int64(0), int64(0)
+				for _, d := range topo.Arcs[id] {
+					x += d[0]
+					y += d[1]
+					points = append(points, []float64{
+						float64(x)*topo.Transform.Scale[0] + topo.Transform.Translate[0],
+						float64(y)*topo.Transform.Scale[1] + topo.Transform.Translate[1],
+					})
+				}
+				if reversed {
+					for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+						points[i], points[j] = points[j], points[i]
+					}
+				}
+				if len(ring) > 0 {
+					points = points[1:]
+				}
+				ring = append(ring, points...)
+			}
+			rings = append(rings, ring)
+		}
+	}
+	return rings
+}
+
+// Returns true if closed rings a and b have the same points in the same
+// order, up to rotation.
+func sameTopoRing(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	n := len(a) - 1
+	for start := 0; start < n; start++ {
+		ok := true
+		for i := 0; i < n && ok; i++ {
+			ok = reflect.DeepEqual(a[i], b[(start+i)%n])
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuildTopology(t *testing.T) {
+	square := func(x, y float64) [][]float64 {
+		return [][]float64{{x, y}, {x + 2, y}, {x + 2, y + 2}, {x, y + 2}, {x, y}}
+	}
+	reversed := [][]float64{{0, 0}, {0, 2}, {2, 2}, {2, 0}, {0, 0}}
+	features := []*Feature{
+		{Id: "a", Geometry: FeatureGeometry{Coordinates: [][][][]float64{{square(0, 0)}}}},
+		{Id: "b", Geometry: FeatureGeometry{Coordinates: [][][][]float64{{square(2, 0)}}}},
+		// Same shape as a, in the other direction
+		{Id: "c", Geometry: FeatureGeometry{Coordinates: [][][][]float64{{reversed}}}},
+		// Isolated area with a hole
+		{Id: "d", Geometry: FeatureGeometry{Coordinates: [][][][]float64{
+			{square(10, 10), {{11, 11}, {11, 11.5}, {11.5, 11.5}, {11, 11}}}}}},
+	}
+	topo := buildTopology(features, 7)
+	// Shared border, the rest of a and b, d outer ring and hole
+	if len(topo.Arcs) != 5 {
+		t.Fatalf("unexpected arcs count: %d", len(topo.Arcs))
+	}
+	if !reflect.DeepEqual(topo.BBox, []float64{0, 0, 12, 12}) {
+		t.Fatalf("unexpected bbox: %v", topo.BBox)
+	}
+	geometries := topo.Objects["boundaries"].Geometries
+	if len(geometries) != len(features) {
+		t.Fatalf("unexpected geometries: %d", len(geometries))
+	}
+	for i, f := range features {
+		g := geometries[i]
+		if g.Id != f.Id {
+			t.Fatalf("unexpected id: %s != %s", g.Id, f.Id)
+		}
+		rings := decodeTopoRings(topo, g)
+		want := f.Geometry.Coordinates[0]
+		if len(rings) != len(want) {
+			t.Fatalf("%s: unexpected rings count: %d", f.Id, len(rings))
+		}
+		for j := range want {
+			if !sameTopoRing(rings[j], want[j]) {
+				t.Fatalf("%s: ring %d differs: %v != %v", f.Id, j, rings[j],
+					want[j])
+			}
+		}
+	}
+}
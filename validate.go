@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// Validation problem categories, in report order
+const (
+	ProblemMissingMember    = "missing member"
+	ProblemUnsupportedRole  = "unsupported role"
+	ProblemUnclosedRing     = "unclosed ring"
+	ProblemSelfIntersection = "self-intersection"
+	ProblemCrossingPolygons = "crossing polygons"
+	ProblemOther            = "other"
+)
+
+var (
+	ProblemCategories = []string{
+		ProblemMissingMember,
+		ProblemUnsupportedRole,
+		ProblemUnclosedRing,
+		ProblemSelfIntersection,
+		ProblemCrossingPolygons,
+		ProblemOther,
+	}
+)
+
+type ValidationProblem struct {
+	Category string
+	Message  string
+}
+
+type RelationValidation struct {
+	Id       int64
+	Name     string
+	Problems []ValidationProblem
+}
+
+// ValidationReport lists invalid relations in file order.
+type ValidationReport struct {
+	Relations []*RelationValidation
+	Checked   int
+	Counts    map[string]int
+}
+
+func pointString(p Point) string {
+	return fmt.Sprintf("%.7f,%.7f", float64(p.Lon)/1e7, float64(p.Lat)/1e7)
+}
+
+// Returns the endpoints of lines shared by an odd number of line ends, where
+// no ring can close, with the id of one line ending there.
+func findDanglingEndpoints(lines []*Linestring) ([]Point, map[Point]int64) {
+	counts := map[Point]int{}
+	owners := map[Point]int64{}
+	for _, line := range lines {
+		if len(line.Points) == 0 {
+			continue
+		}
+		for _, p := range []Point{line.Start(), line.End()} {
+			counts[p]++
+			owners[p] = line.Id
+		}
+	}
+	points := []Point{}
+	for p, n := range counts {
+		if n%2 != 0 {
+			points = append(points, p)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return pointLess(points[i], points[j])
+	})
+	return points, owners
+}
+
+func ringBBox(ring *Linestring) *BBox {
+	bbox := NewBBox()
+	for _, p := range ring.Points {
+		bbox.Add(float64(p.Lon)/1e7, float64(p.Lat)/1e7)
+	}
+	return bbox
+}
+
+func bboxesIntersect(b1, b2 *BBox) bool {
+	return b1.MinLon <= b2.MaxLon && b2.MinLon <= b1.MaxLon &&
+		b1.MinLat <= b2.MaxLat && b2.MinLat <= b1.MaxLat
+}
+
+// Returns true if the polygons of two rings share some area without one
+// containing the other.
+func ringsCross(g1, g2 Geometry) (bool, error) {
+	for _, pair := range [][2]Geometry{{g1, g2}, {g2, g1}} {
+		ok, err := pair[0].Contains(pair[1])
+		if err != nil || ok {
+			return false, err
+		}
+	}
+	inter, err := g1.Intersection(g2)
+	if err != nil {
+		return false, err
+	}
+	area, err := inter.Area()
+	return area > 0, err
+}
+
+// Returns the pairs of assembled rings crossing each other, which
+// makePolygons cannot sort into shells and holes.
+func findCrossingRings(rings []*Linestring) ([]ValidationProblem, error) {
+	bboxes := make([]*BBox, len(rings))
+	geoms := make([]Geometry, len(rings))
+	for i, ring := range rings {
+		bboxes[i] = ringBBox(ring)
+		g, err := createSimplePolygon(ring)
+		if err != nil {
+			return nil, err
+		}
+		geoms[i] = g
+	}
+	problems := []ValidationProblem{}
+	for i := range rings {
+		for j := i + 1; j < len(rings); j++ {
+			if !bboxesIntersect(bboxes[i], bboxes[j]) {
+				continue
+			}
+			ok, err := ringsCross(geoms[i], geoms[j])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				problems = append(problems, ValidationProblem{
+					Category: ProblemCrossingPolygons,
+					Message: fmt.Sprintf("rings of ways %d and %d cross",
+						rings[i].Id, rings[j].Id),
+				})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Collects relation ways like buildRelationPolygons, reporting every missing
+// member instead of stopping at the first one.
+func collectValidatedRings(rel *Relation, db *WaysDb) ([]*Linestring,
+	[]ValidationProblem, error) {
+
+	problems := []ValidationProblem{}
+	wayIds, relIds, err := collectWayRefs(rel)
+	if err != nil {
+		problems = append(problems, ValidationProblem{
+			Category: ProblemUnsupportedRole,
+			Message:  err.Error(),
+		})
+		return nil, problems, nil
+	}
+	rings := []*Linestring{}
+	for _, ref := range wayIds {
+		way, err := db.Get(ref.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if way == nil {
+			problems = append(problems, ValidationProblem{
+				Category: ProblemMissingMember,
+				Message:  fmt.Sprintf("cannot resolve way: %d", ref.Id),
+			})
+			continue
+		}
+		lines, err := collectWayGeometries([]Ref{ref}, db)
+		if err != nil {
+			return nil, nil, err
+		}
+		rings = append(rings, lines...)
+	}
+	if isRecursiveRelation(rel) {
+		subRings, err := collectRelationWays(relIds, db)
+		if err != nil {
+			problems = append(problems, ValidationProblem{
+				Category: ProblemMissingMember,
+				Message:  err.Error(),
+			})
+		}
+		rings = append(rings, subRings...)
+	}
+	rings, _ = dedupLines(rings)
+	snapEndpoints(rings, ringSnapTolerance)
+	rings, _ = removeRepeatedPoints(rings)
+	return patchRings(rel, rings), problems, nil
+}
+
+// Attempts to build rel geometry from ways stored in db and returns the
+// problems preventing it. Only db errors are returned as errors.
+func validateRelation(rel *Relation, db *WaysDb) ([]ValidationProblem, error) {
+	if isSubareaRelation(rel) {
+		_, err := buildSpecialRelations(rel, db)
+		if err != nil {
+			return []ValidationProblem{{
+				Category: ProblemOther,
+				Message:  err.Error(),
+			}}, nil
+		}
+		return nil, nil
+	}
+	rings, problems, err := collectValidatedRings(rel, db)
+	if err != nil || len(problems) > 0 {
+		// Missing members leave unclosed rings, do not report them twice
+		return problems, err
+	}
+	roles := map[string]bool{}
+	for _, ring := range rings {
+		role := ring.Role
+		if role == "inner" || role == "outer" || role == "" ||
+			IgnoredRingRoles[role] || roles[role] {
+			continue
+		}
+		roles[role] = true
+		problems = append(problems, ValidationProblem{
+			Category: ProblemUnsupportedRole,
+			Message:  fmt.Sprintf("way %d has role %q", ring.Id, role),
+		})
+	}
+	dangling, owners := findDanglingEndpoints(rings)
+	for _, p := range dangling {
+		problems = append(problems, ValidationProblem{
+			Category: ProblemUnclosedRing,
+			Message: fmt.Sprintf("way %d ends at dangling point %s",
+				owners[p], pointString(p)),
+		})
+	}
+	for _, ring := range rings {
+		if len(ring.Points) >= 4 && ring.Start() == ring.End() &&
+			!isValidRing(ring) {
+			problems = append(problems, ValidationProblem{
+				Category: ProblemSelfIntersection,
+				Message:  fmt.Sprintf("closed way %d intersects itself", ring.Id),
+			})
+		}
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+	all, err := makeRings(rings)
+	if err != nil {
+		// Every endpoint is shared, rings can only be closed by crossing
+		// themselves
+		return []ValidationProblem{{
+			Category: ProblemSelfIntersection,
+			Message:  err.Error(),
+		}}, nil
+	}
+	problems, err = findCrossingRings(all)
+	if err != nil || len(problems) > 0 {
+		return problems, err
+	}
+	_, err = makePolygons(all)
+	if err != nil {
+		return []ValidationProblem{{
+			Category: ProblemOther,
+			Message:  err.Error(),
+		}}, nil
+	}
+	return nil, nil
+}
+
+// Validates the geometry of path relations passing ignoreRelation, or only
+// relId if it is positive, without writing anything.
+func validateRelations(path string, db *WaysDb, relId int64) (
+	*ValidationReport, error) {
+
+	report := &ValidationReport{
+		Counts: map[string]int{},
+	}
+	plan := &ScanPlan{}
+	plan.Add(&ScanStage{
+		Kinds: []int{RelationKind},
+		Handler: o5m.HandlerFuncs{
+			Relation: func(rel *Relation) error {
+				if relId > 0 && rel.Id != relId {
+					return nil
+				}
+				if ok, err := ignoreRelation(rel); ok || err != nil {
+					return nil
+				}
+				report.Checked++
+				problems, err := validateRelation(rel, db)
+				if err != nil {
+					return err
+				}
+				if len(problems) == 0 {
+					return nil
+				}
+				for _, p := range problems {
+					report.Counts[p.Category]++
+				}
+				report.Relations = append(report.Relations, &RelationValidation{
+					Id:       rel.Id,
+					Name:     rel.String(),
+					Problems: problems,
+				})
+				return nil
+			},
+		},
+	})
+	err := plan.Run(context.Background(), path)
+	return report, err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateRelation(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+
+	square := func(x, y, size int64) []Point {
+		return []Point{
+			{Lon: x, Lat: y},
+			{Lon: x + size, Lat: y},
+			{Lon: x + size, Lat: y + size},
+			{Lon: x, Lat: y + size},
+			{Lon: x, Lat: y},
+		}
+	}
+	ways := []*Linestring{
+		// Square split in two ways
+		{Id: 1, Points: square(0, 0, 10)[:3]},
+		{Id: 2, Points: square(0, 0, 10)[2:]},
+		// Bow tie
+		{Id: 3, Points: []Point{
+			{Lon: 0, Lat: 0},
+			{Lon: 10, Lat: 10},
+			{Lon: 10, Lat: 0},
+			{Lon: 0, Lat: 10},
+			{Lon: 0, Lat: 0},
+		}},
+		// Overlaps the first square
+		{Id: 4, Points: square(5, 5, 10)},
+		// Hole in the first square
+		{Id: 5, Points: square(2, 2, 2)},
+	}
+	for _, w := range ways {
+		if err := db.Put(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rel := func(refs ...Ref) *Relation {
+		return &Relation{Id: 100, Refs: refs}
+	}
+	way := func(id int64, role string) Ref {
+		return Ref{Id: id, Type: 1, Role: role}
+	}
+	tests := []struct {
+		Rel      *Relation
+		Expected []string
+	}{
+		{rel(way(1, "outer"), way(2, "outer"), way(5, "inner")), []string{}},
+		{rel(way(1, "outer"), way(2, "outer"), way(6, "outer")),
+			[]string{ProblemMissingMember}},
+		{rel(way(1, "outer")),
+			[]string{ProblemUnclosedRing, ProblemUnclosedRing}},
+		{rel(way(1, "outer"), way(2, "label")),
+			[]string{ProblemUnsupportedRole}},
+		{rel(way(3, "outer")), []string{ProblemSelfIntersection}},
+		{rel(way(1, "outer"), way(2, "outer"), way(4, "outer")),
+			[]string{ProblemCrossingPolygons}},
+		{rel(Ref{Id: 7, Type: 2, Role: "outer"}, way(1, "outer")),
+			[]string{ProblemUnclosedRing, ProblemUnclosedRing}},
+		{rel(Ref{Id: 7, Type: 3}), []string{ProblemUnsupportedRole}},
+	}
+	for i, test := range tests {
+		problems, err := validateRelation(test.Rel, db)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		categories := []string{}
+		for _, p := range problems {
+			categories = append(categories, p.Category)
+		}
+		if !reflect.DeepEqual(categories, test.Expected) {
+			t.Fatalf("%d: unexpected problems: %+v", i, problems)
+		}
+	}
+}
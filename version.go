@@ -0,0 +1,72 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+var (
+	// Set at build time with:
+	//
+	//	go build -ldflags "-X main.version=1.2 -X main.gitCommit=$(git rev-parse HEAD)"
+	version   = "dev"
+	gitCommit = ""
+)
+
+type BuildInfo struct {
+	Version       string   `json:"version"`
+	GitCommit     string   `json:"git_commit"`
+	GoVersion     string   `json:"go_version"`
+	Platform      string   `json:"platform"`
+	Geometry      string   `json:"geometry"`
+	GeosVersion   string   `json:"geos_version"`
+	InputFormats  []string `json:"input_formats"`
+	OutputFormats []string `json:"output_formats"`
+	Compressions  []string `json:"compressions"`
+}
+
+func getBuildInfo() *BuildInfo {
+	commit := gitCommit
+	if commit == "" {
+		// Recorded by go build since Go 1.18
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					commit = s.Value
+				}
+			}
+		}
+	}
+	geometry, geosVersion := geometryBackend()
+	return &BuildInfo{
+		Version:      version,
+		GitCommit:    commit,
+		GoVersion:    runtime.Version(),
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		Geometry:     geometry,
+		GeosVersion:  geosVersion,
+		InputFormats: []string{"o5m", "pbf", "osm"},
+		OutputFormats: []string{FormatES, FormatFeatures, FormatCollection,
+			FormatWKT, FormatWKB},
+		Compressions: []string{CompressNone, CompressGzip,
+			CompressZstd},
+	}
+}
+
+func (b *BuildInfo) String() string {
+	commit := b.GitCommit
+	if commit == "" {
+		commit = "unknown"
+	}
+	lines := []string{
+		"version: " + b.Version,
+		"commit: " + commit,
+		"go: " + b.GoVersion + " " + b.Platform,
+		"geometry: " + strings.TrimSpace(b.Geometry+" "+b.GeosVersion),
+		"inputs: " + strings.Join(b.InputFormats, ", "),
+		"outputs: " + strings.Join(b.OutputFormats, ", "),
+		"compressions: " + strings.Join(b.Compressions, ", "),
+	}
+	return strings.Join(lines, "\n")
+}
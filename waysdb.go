@@ -3,54 +3,50 @@ package main
 import (
 	"encoding/binary"
 	"encoding/json"
-
-	"github.com/boltdb/bolt"
+	"fmt"
 )
 
 var (
-	waysBucket      = []byte("ways")
-	relationsBucket = []byte("relations")
-	locationsBucket = []byte("locations")
-	centroidsBucket = []byte("centroids")
+	waysBucket       = []byte("ways")
+	relationsBucket  = []byte("relations")
+	locationsBucket  = []byte("locations")
+	centroidsBucket  = []byte("centroids")
+	duplicatesBucket = []byte("duplicates")
+	centresBucket    = []byte("centres")
+	parentsBucket    = []byte("parents")
+	// How locations were built, to tell when they must be rebuilt
+	locationBuildsBucket = []byte("locationbuilds")
 )
 
+// WaysDb stores ways, relations and the data derived from them in a kvStore.
 type WaysDb struct {
-	db *bolt.DB
+	db kvStore
 }
 
-func OpenWaysDb(path string) (*WaysDb, error) {
-	db, err := bolt.Open(path, 0666, nil)
-	if err != nil {
-		return nil, err
+var (
+	waysDbBuckets = [][]byte{
+		waysBucket,
+		relationsBucket,
+		locationsBucket,
+		centroidsBucket,
+		duplicatesBucket,
+		centresBucket,
+		parentsBucket,
+		locationBuildsBucket,
+		spatialBucket,
+		checkpointsBucket,
+		nodesBucket,
 	}
-	defer func() {
-		if db != nil {
-			db.Close()
-		}
-	}()
-	err = db.Update(func(tx *bolt.Tx) error {
-		names := [][]byte{
-			waysBucket,
-			relationsBucket,
-			locationsBucket,
-			centroidsBucket,
-		}
-		for _, name := range names {
-			_, err := tx.CreateBucketIfNotExists(name)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	})
+)
+
+func OpenWaysDb(path string) (*WaysDb, error) {
+	db, err := openKVStore(path)
 	if err != nil {
 		return nil, err
 	}
-	waysDb := &WaysDb{
+	return &WaysDb{
 		db: db,
-	}
-	db = nil
-	return waysDb, nil
+	}, nil
 }
 
 func (db *WaysDb) Close() error {
@@ -71,33 +67,49 @@ func (db *WaysDb) putJson(bucket []byte, id int64, o interface{}) error {
 	if err != nil {
 		return err
 	}
+	return db.putData(bucket, id, data)
+}
+
+func (db *WaysDb) getJson(bucket []byte, id int64, o interface{}) (bool, error) {
+	return db.getData(bucket, id, func(data []byte) error {
+		return json.Unmarshal(data, o)
+	})
+}
+
+func (db *WaysDb) putData(bucket []byte, id int64, data []byte) error {
 	key := makeByteKey(id)
-	return db.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(bucket).Put(key, data)
+	return db.db.Update(func(tx kvTx) error {
+		return tx.Put(bucket, key, data)
 	})
 }
 
-func (db *WaysDb) getJson(bucket []byte, id int64, o interface{}) (bool, error) {
+// Calls decode with the value of id in bucket, if any. data is only valid
+// during the call.
+func (db *WaysDb) getData(bucket []byte, id int64,
+	decode func(data []byte) error) (bool, error) {
+
 	key := makeByteKey(id)
 	found := false
-	err := db.db.View(func(tx *bolt.Tx) error {
-		data := tx.Bucket(bucket).Get(key)
-		if data == nil {
-			return nil
+	err := db.db.View(func(tx kvTx) error {
+		data, err := tx.Get(bucket, key)
+		if data == nil || err != nil {
+			return err
 		}
 		found = true
-		return json.Unmarshal(data, o)
+		return decode(data)
 	})
 	return found, err
 }
 
 func (db *WaysDb) Put(w *Linestring) error {
-	return db.putJson(waysBucket, w.Id, w)
+	return db.putData(waysBucket, w.Id, encodeLinestring(w))
 }
 
 func (db *WaysDb) Get(id int64) (*Linestring, error) {
 	w := &Linestring{}
-	ok, err := db.getJson(waysBucket, id, w)
+	ok, err := db.getData(waysBucket, id, func(data []byte) error {
+		return decodeLinestring(data, w)
+	})
 	if !ok {
 		w = nil
 	}
@@ -105,12 +117,14 @@ func (db *WaysDb) Get(id int64) (*Linestring, error) {
 }
 
 func (db *WaysDb) PutRelation(r *Relation) error {
-	return db.putJson(relationsBucket, r.Id, r)
+	return db.putData(relationsBucket, r.Id, encodeRelation(r))
 }
 
 func (db *WaysDb) GetRelation(id int64) (*Relation, error) {
 	r := &Relation{}
-	ok, err := db.getJson(relationsBucket, id, r)
+	ok, err := db.getData(relationsBucket, id, func(data []byte) error {
+		return decodeRelation(data, r)
+	})
 	if !ok {
 		r = nil
 	}
@@ -118,44 +132,341 @@ func (db *WaysDb) GetRelation(id int64) (*Relation, error) {
 }
 
 func (db *WaysDb) PutLocation(id int64, doc *Location) error {
-	return db.putJson(locationsBucket, id, doc)
+	data, err := encodeLocation(doc)
+	if err != nil {
+		return err
+	}
+	return db.putData(locationsBucket, id, data)
 }
 
 func (db *WaysDb) GetLocation(id int64) (*Location, error) {
 	doc := &Location{}
-	ok, err := db.getJson(locationsBucket, id, doc)
+	ok, err := db.getData(locationsBucket, id, func(data []byte) error {
+		return decodeLocation(data, doc)
+	})
 	if !ok {
 		doc = nil
 	}
 	return doc, err
 }
 
+func (db *WaysDb) PutLocationBuild(id int64, build *LocationBuild) error {
+	return db.putJson(locationBuildsBucket, id, build)
+}
+
+// Returns how relation location was built, or nil for locations built before
+// it was stored.
+func (db *WaysDb) GetLocationBuild(id int64) (*LocationBuild, error) {
+	build := &LocationBuild{}
+	ok, err := db.getJson(locationBuildsBucket, id, build)
+	if !ok {
+		build = nil
+	}
+	return build, err
+}
+
 func (db *WaysDb) HasLocation(id int64) (bool, error) {
 	ok := false
 	key := makeByteKey(id)
-	err := db.db.View(func(tx *bolt.Tx) error {
-		data := tx.Bucket(locationsBucket).Get(key)
+	err := db.db.View(func(tx kvTx) error {
+		data, err := tx.Get(locationsBucket, key)
 		ok = data != nil
-		return nil
+		return err
 	})
 	return ok, err
 }
 
+// WaysBatch buffers writes and commits them in a single transaction every
+// size entries, instead of one transaction per entry. Buffered entries are
+// not visible until flushed, callers must call Flush once done.
+type WaysBatch struct {
+	db      *WaysDb
+	size    int
+	buckets [][]byte
+	keys    [][]byte
+	values  [][]byte
+}
+
+func (db *WaysDb) NewBatch(size int) *WaysBatch {
+	return &WaysBatch{
+		db:   db,
+		size: size,
+	}
+}
+
+func (b *WaysBatch) put(bucket []byte, id int64, data []byte) error {
+	return b.putKey(bucket, makeByteKey(id), data)
+}
+
+func (b *WaysBatch) putKey(bucket, key, data []byte) error {
+	b.buckets = append(b.buckets, bucket)
+	b.keys = append(b.keys, key)
+	b.values = append(b.values, data)
+	if len(b.keys) >= b.size {
+		return b.Flush()
+	}
+	return nil
+}
+
+func (b *WaysBatch) Put(w *Linestring) error {
+	return b.put(waysBucket, w.Id, encodeLinestring(w))
+}
+
+func (b *WaysBatch) PutRelation(r *Relation) error {
+	return b.put(relationsBucket, r.Id, encodeRelation(r))
+}
+
+func (b *WaysBatch) Flush() error {
+	if len(b.keys) == 0 {
+		return nil
+	}
+	err := b.db.db.Update(func(tx kvTx) error {
+		for i, key := range b.keys {
+			err := tx.Put(b.buckets[i], key, b.values[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	b.buckets = b.buckets[:0]
+	b.keys = b.keys[:0]
+	b.values = b.values[:0]
+	return err
+}
+
+func (db *WaysDb) listIds(bucket []byte) (map[int64]bool, error) {
+	ids := map[int64]bool{}
+	err := db.db.View(func(tx kvTx) error {
+		return tx.ForEach(bucket, nil, func(k, v []byte) error {
+			id, n := binary.Varint(k)
+			if n <= 0 {
+				return fmt.Errorf("invalid %s key: %x", bucket, k)
+			}
+			ids[id] = true
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// Returns the set of relation ids having a location, collected in a single
+// cursor pass.
+func (db *WaysDb) ListLocationIds() (map[int64]bool, error) {
+	return db.listIds(locationsBucket)
+}
+
+func (db *WaysDb) ListCentroidIds() (map[int64]bool, error) {
+	return db.listIds(centroidsBucket)
+}
+
+func (db *WaysDb) deleteKeys(id int64, buckets ...[]byte) error {
+	key := makeByteKey(id)
+	return db.db.Update(func(tx kvTx) error {
+		for _, bucket := range buckets {
+			err := tx.Delete(bucket, key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *WaysDb) DeleteWay(id int64) error {
+	return db.deleteKeys(id, waysBucket)
+}
+
+func (db *WaysDb) DeleteRelation(id int64) error {
+	return db.deleteKeys(id, relationsBucket)
+}
+
+// Deletes relation location and the data derived from it.
+func (db *WaysDb) DeleteLocation(id int64) error {
+	return db.deleteKeys(id, locationsBucket, locationBuildsBucket,
+		centroidsBucket, centresBucket, parentsBucket)
+}
+
+// Deletes relation centroid and admin centre.
+func (db *WaysDb) DeleteCentroid(id int64) error {
+	return db.deleteKeys(id, centroidsBucket, centresBucket)
+}
+
 func (db *WaysDb) PutCentroid(id int64, doc *Centroid) error {
-	return db.putJson(centroidsBucket, id, doc)
+	return db.putData(centroidsBucket, id, encodeCentroid(doc))
 }
 
 func (db *WaysDb) GetCentroid(id int64) (*Centroid, error) {
 	doc := &Centroid{}
-	ok, err := db.getJson(centroidsBucket, id, doc)
+	ok, err := db.getData(centroidsBucket, id, func(data []byte) error {
+		return decodeCentroid(data, doc)
+	})
 	if !ok {
 		doc = nil
 	}
 	return doc, err
 }
 
-func (db *WaysDb) DeleteBucket(name string) error {
-	return db.db.Update(func(tx *bolt.Tx) error {
-		return tx.DeleteBucket([]byte(name))
+func (db *WaysDb) PutAdminCentre(id int64, doc *AdminCentre) error {
+	return db.putJson(centresBucket, id, doc)
+}
+
+func (db *WaysDb) GetAdminCentre(id int64) (*AdminCentre, error) {
+	doc := &AdminCentre{}
+	ok, err := db.getJson(centresBucket, id, doc)
+	if !ok {
+		doc = nil
+	}
+	return doc, err
+}
+
+// Stores the ancestors of a relation, from the top level one down.
+func (db *WaysDb) PutParents(id int64, parents []AdminAreaJson) error {
+	return db.putJson(parentsBucket, id, parents)
+}
+
+func (db *WaysDb) GetParents(id int64) ([]AdminAreaJson, error) {
+	parents := []AdminAreaJson{}
+	ok, err := db.getJson(parentsBucket, id, &parents)
+	if !ok {
+		parents = nil
+	}
+	return parents, err
+}
+
+// Replaces the set of duplicate relations. Each rejected relation id is
+// associated with the id of the relation kept instead.
+func (db *WaysDb) PutDuplicates(rejected map[int64]int64) error {
+	return db.db.Update(func(tx kvTx) error {
+		err := tx.ClearBucket(duplicatesBucket)
+		if err != nil {
+			return err
+		}
+		for id, kept := range rejected {
+			err := tx.Put(duplicatesBucket, makeByteKey(id), makeByteKey(kept))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *WaysDb) ListDuplicates() (map[int64]bool, error) {
+	ids := map[int64]bool{}
+	err := db.db.View(func(tx kvTx) error {
+		return tx.ForEach(duplicatesBucket, nil, func(k, v []byte) error {
+			id, n := binary.Varint(k)
+			if n <= 0 {
+				return fmt.Errorf("invalid duplicate key: %x", k)
+			}
+			ids[id] = true
+			return nil
+		})
 	})
+	return ids, err
+}
+
+// Copies all entries of bucket from other db into db, overwriting existing
+// keys. Returns the number of copied entries.
+func (db *WaysDb) CopyBucket(other *WaysDb, bucket []byte) (int, error) {
+	copied := 0
+	err := other.db.View(func(src kvTx) error {
+		return db.db.Update(func(dst kvTx) error {
+			return src.ForEach(bucket, nil, func(k, v []byte) error {
+				copied++
+				return dst.Put(bucket, k, v)
+			})
+		})
+	})
+	return copied, err
+}
+
+// Buckets filled from the input by indexways and indexrelations. The others
+// are derived from them and dropped per relation when it is rebuilt.
+var inputBuckets = [][]byte{
+	waysBucket,
+	relationsBucket,
+	nodesBucket,
+	duplicatesBucket,
+	checkpointsBucket,
+}
+
+// Deletes the entries of the input buckets, keeping locations and the data
+// derived from them, so they are only rebuilt if their members changed.
+func (db *WaysDb) ClearInputBuckets() error {
+	return db.db.Update(func(tx kvTx) error {
+		for _, bucket := range inputBuckets {
+			if err := tx.ClearBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Deletes all entries of the named bucket.
+func (db *WaysDb) DeleteBucket(name string) error {
+	for _, bucket := range waysDbBuckets {
+		if string(bucket) == name {
+			return db.db.Update(func(tx kvTx) error {
+				return tx.ClearBucket(bucket)
+			})
+		}
+	}
+	return fmt.Errorf("unknown bucket: %s", name)
+}
+
+// Rewrites the JSON values of bucket in binary format, batchSize entries per
+// transaction so large buckets do not build huge transactions. Returns the
+// number of rewritten values.
+func (db *WaysDb) MigrateBucket(bucket []byte, batchSize int) (int, error) {
+	migrate := getBucketMigration(string(bucket))
+	if migrate == nil {
+		return 0, fmt.Errorf("bucket %s has no binary format", bucket)
+	}
+	migrated := 0
+	var from []byte
+	for {
+		keys := [][]byte{}
+		values := [][]byte{}
+		var next []byte
+		err := db.db.Update(func(tx kvTx) error {
+			err := tx.ForEach(bucket, from, func(k, v []byte) error {
+				if len(keys) >= batchSize {
+					next = append([]byte{}, k...)
+					return errStopIteration
+				}
+				if !isJsonValue(v) {
+					return nil
+				}
+				data, err := migrate(v)
+				if err != nil {
+					id, _ := binary.Varint(k)
+					return fmt.Errorf("cannot migrate %s %d: %s", bucket, id, err)
+				}
+				keys = append(keys, append([]byte{}, k...))
+				values = append(values, data)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			// Iterators must not be used after the bucket is modified
+			for i, k := range keys {
+				if err := tx.Put(bucket, k, values[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, err
+		}
+		migrated += len(keys)
+		if next == nil {
+			return migrated, nil
+		}
+		from = next
+	}
 }
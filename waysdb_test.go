@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTestWaysDb(t *testing.T) (*WaysDb, func()) {
+	dir, err := ioutil.TempDir("", "osm-waysdb-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenWaysDb(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWaysBatch(t *testing.T) {
+	db, cleanup := openTestWaysDb(t)
+	defer cleanup()
+
+	batch := db.NewBatch(3)
+	for i := 1; i <= 4; i++ {
+		err := batch.Put(&Linestring{Id: int64(i), Points: []Point{{1, 2}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := batch.PutRelation(&Relation{Id: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first 3 entries were flushed when the batch filled up
+	for i := 1; i <= 4; i++ {
+		w, err := db.Get(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (w != nil) != (i <= 3) {
+			t.Fatalf("unexpected way %d visibility before flush", i)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w, err := db.Get(4)
+	if err != nil || w == nil {
+		t.Fatalf("way 4 was not flushed: %v", err)
+	}
+	rel, err := db.GetRelation(5)
+	if err != nil || rel == nil || rel.Id != 5 {
+		t.Fatalf("relation 5 was not flushed: %v", err)
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaysDbBackends(t *testing.T) {
+	defer func() {
+		waysDbBackend = BoltBackend
+	}()
+	for _, backend := range []string{BoltBackend, LevelDbBackend} {
+		waysDbBackend = backend
+		db, cleanup := openTestWaysDb(t)
+
+		err := db.PutDuplicates(map[int64]int64{1: 2, 3: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.PutDuplicates(map[int64]int64{4: 5, -6: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := db.ListDuplicates()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids, map[int64]bool{4: true, -6: true}) {
+			t.Fatalf("%s: unexpected duplicates: %v", backend, ids)
+		}
+		// Keys of other buckets sharing a prefix must not leak
+		err = db.PutCentroid(7, &Centroid{Lon: 1, Lat: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.PutLocation(7, &Location{Type: "MultiPolygon"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.DeleteBucket(string(centroidsBucket))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := db.GetCentroid(7)
+		if err != nil || c != nil {
+			t.Fatalf("%s: centroid was not deleted: %v", backend, err)
+		}
+		ok, err := db.HasLocation(7)
+		if err != nil || !ok {
+			t.Fatalf("%s: location was deleted: %v", backend, err)
+		}
+		if db.DeleteBucket("unknown") == nil {
+			t.Fatalf("%s: deleting unknown bucket should fail", backend)
+		}
+		cleanup()
+	}
+}
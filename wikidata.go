@@ -0,0 +1,24 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Drop wikidata tags which are not a single item identifier, set by
+	// --validate-wikidata.
+	validateWikidata = false
+
+	wikidataRe = regexp.MustCompile(`^Q[1-9][0-9]*$`)
+)
+
+// Returns the wikidata tag value to export, and false if it was dropped as
+// invalid. Values are only checked when validateWikidata is set.
+func checkWikidata(v string) (string, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" || !validateWikidata || wikidataRe.MatchString(v) {
+		return v, true
+	}
+	return "", false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWikidataTags(t *testing.T) {
+	rel := &Relation{
+		Id: 1,
+		Tags: []StringPair{
+			{Key: "name", Value: "a"},
+			{Key: "admin_level", Value: "8"},
+			{Key: "wikidata", Value: "Q90;Q1"},
+			{Key: "wikipedia", Value: "fr:Paris"},
+		},
+	}
+	loc := &Location{
+		Type:        "MultiPolygon",
+		Coordinates: [][][][]float64{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+	}
+	js, err := makeJsonRelation(rel, &Centroid{}, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if js.Wikidata != "Q90;Q1" || js.Wikipedia != "fr:Paris" {
+		t.Fatalf("unexpected tags: %q, %q", js.Wikidata, js.Wikipedia)
+	}
+	f, _ := makeFeature(js)
+	if f.Properties["wikipedia"] != "fr:Paris" {
+		t.Fatalf("unexpected feature properties: %+v", f.Properties)
+	}
+
+	defer func() { validateWikidata = false }()
+	validateWikidata = true
+	js, err = makeJsonRelation(rel, &Centroid{}, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if js.Wikidata != "" {
+		t.Fatalf("invalid wikidata was kept: %q", js.Wikidata)
+	}
+	for v, ok := range map[string]bool{"Q90": true, "Q0": false, "q90": false} {
+		if _, valid := checkWikidata(v); valid != ok {
+			t.Fatalf("%q: expected %v", v, ok)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatWKT = "wkt"
+	FormatWKB = "wkb"
+
+	wkbPoint        = 1
+	wkbPolygon      = 3
+	wkbMultiPolygon = 6
+	// EWKB flag telling a SRID follows the geometry type
+	ewkbSRIDFlag = 0x20000000
+	sridWGS84    = 4326
+)
+
+func appendWKTFloat(buf []byte, v float64) []byte {
+	return strconv.AppendFloat(buf, v, 'f', -1, 64)
+}
+
+// Appends coords as a WKT MULTIPOLYGON.
+func appendWKT(buf []byte, coords [][][][]float64) []byte {
+	if len(coords) == 0 {
+		return append(buf, "MULTIPOLYGON EMPTY"...)
+	}
+	buf = append(buf, "MULTIPOLYGON("...)
+	for i, poly := range coords {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '(')
+		for j, ring := range poly {
+			if j > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, '(')
+			for k, p := range ring {
+				if k > 0 {
+					buf = append(buf, ',')
+				}
+				buf = appendWKTFloat(buf, p[0])
+				buf = append(buf, ' ')
+				buf = appendWKTFloat(buf, p[1])
+			}
+			buf = append(buf, ')')
+		}
+		buf = append(buf, ')')
+	}
+	return append(buf, ')')
+}
+
+func appendWKBUint32(buf []byte, v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return append(buf, b...)
+}
+
+func appendWKBFloat(buf []byte, v float64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	return append(buf, b...)
+}
+
+// Appends coords as a little-endian EWKB MULTIPOLYGON in WGS84, the format
+// PostGIS uses for geometries in COPY.
+func appendEWKB(buf []byte, coords [][][][]float64) []byte {
+	buf = append(buf, 1)
+	buf = appendWKBUint32(buf, wkbMultiPolygon|ewkbSRIDFlag)
+	buf = appendWKBUint32(buf, sridWGS84)
+	return appendWKBPolygons(buf, coords)
+}
+
+// Appends coords as a little-endian WKB MULTIPOLYGON.
+func appendWKB(buf []byte, coords [][][][]float64) []byte {
+	buf = append(buf, 1)
+	buf = appendWKBUint32(buf, wkbMultiPolygon)
+	return appendWKBPolygons(buf, coords)
+}
+
+func appendWKBPoint(buf []byte, lon, lat float64) []byte {
+	buf = append(buf, 1)
+	buf = appendWKBUint32(buf, wkbPoint)
+	buf = appendWKBFloat(buf, lon)
+	return appendWKBFloat(buf, lat)
+}
+
+// Appends the polygons count and content of a MULTIPOLYGON.
+func appendWKBPolygons(buf []byte, coords [][][][]float64) []byte {
+	buf = appendWKBUint32(buf, uint32(len(coords)))
+	for _, poly := range coords {
+		buf = append(buf, 1)
+		buf = appendWKBUint32(buf, wkbPolygon)
+		buf = appendWKBUint32(buf, uint32(len(poly)))
+		for _, ring := range poly {
+			buf = appendWKBUint32(buf, uint32(len(ring)))
+			for _, p := range ring {
+				buf = appendWKBFloat(buf, p[0])
+				buf = appendWKBFloat(buf, p[1])
+			}
+		}
+	}
+	return buf
+}
+
+var (
+	copyTextEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`,
+		"\r", `\r`)
+)
+
+// geometryLine is a relation written as a PostgreSQL COPY text format line:
+// id, name, admin_level and geometry, tab separated. The geometry is WKT or
+// hex encoded EWKB.
+type geometryLine struct {
+	js  *RelationJson
+	wkb bool
+}
+
+func (l *geometryLine) MarshalLine() ([]byte, error) {
+	buf := []byte{}
+	buf = append(buf, copyTextEscaper.Replace(l.js.Id)...)
+	buf = append(buf, '\t')
+	buf = append(buf, copyTextEscaper.Replace(l.js.Name)...)
+	buf = append(buf, '\t')
+	if l.js.AdminLevel > 0 {
+		buf = strconv.AppendInt(buf, int64(l.js.AdminLevel), 10)
+	} else {
+		buf = append(buf, `\N`...)
+	}
+	buf = append(buf, '\t')
+	if l.wkb {
+		wkb := appendEWKB(nil, l.js.Location.Coordinates)
+		return append(buf, hex.EncodeToString(wkb)...), nil
+	}
+	return appendWKT(buf, l.js.Location.Coordinates), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAppendWKT(t *testing.T) {
+	coords := [][][][]float64{
+		{
+			{{0, 0}, {2, 0}, {2, 2}, {0, 0}},
+			{{0.5, 0.25}, {1, 0.25}, {1, 1}, {0.5, 0.25}},
+		},
+		{{{-1.5, 45.1234567}, {-1, 45}, {-1, 46}, {-1.5, 45.1234567}}},
+	}
+	got := string(appendWKT(nil, coords))
+	want := "MULTIPOLYGON(((0 0,2 0,2 2,0 0),(0.5 0.25,1 0.25,1 1,0.5 0.25))," +
+		"((-1.5 45.1234567,-1 45,-1 46,-1.5 45.1234567)))"
+	if got != want {
+		t.Fatalf("unexpected WKT:\n%s\n!=\n%s", got, want)
+	}
+	if s := string(appendWKT(nil, nil)); s != "MULTIPOLYGON EMPTY" {
+		t.Fatalf("unexpected empty WKT: %s", s)
+	}
+}
+
+func TestAppendEWKB(t *testing.T) {
+	coords := [][][][]float64{{{{0, 0}, {1, 0}, {0, 1}, {0, 0}}}}
+	got := hex.EncodeToString(appendEWKB(nil, coords))
+	// SELECT ST_AsEWKB('SRID=4326;MULTIPOLYGON(((0 0,1 0,0 1,0 0)))')
+	want := "0106000020e6100000" + "01000000" + "0103000000" + "01000000" +
+		"04000000" +
+		"0000000000000000" + "0000000000000000" +
+		"000000000000f03f" + "0000000000000000" +
+		"0000000000000000" + "000000000000f03f" +
+		"0000000000000000" + "0000000000000000"
+	if got != want {
+		t.Fatalf("unexpected EWKB:\n%s\n!=\n%s", got, want)
+	}
+}
+
+func TestGeometryLines(t *testing.T) {
+	js := &RelationJson{
+		Id:   "42",
+		Name: "A\tB\\C",
+		Location: Location{
+			Type:        "MultiPolygon",
+			Coordinates: [][][][]float64{{{{0, 0}, {1, 0}, {0, 1}, {0, 0}}}},
+		},
+	}
+	buf := &bytes.Buffer{}
+	out := NewParallelMarshaler(buf, 2)
+	if err := out.Write(&geometryLine{js: js}); err != nil {
+		t.Fatal(err)
+	}
+	js2 := *js
+	js2.AdminLevel = 8
+	if err := out.Write(&geometryLine{js: &js2, wkb: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "42\tA\\tB\\\\C\t\\N\tMULTIPOLYGON(((0 0,1 0,0 1,0 0)))\n" +
+		"42\tA\\tB\\\\C\t8\t" + hex.EncodeToString(appendEWKB(nil,
+		js.Location.Coordinates)) + "\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected lines:\n%q\n!=\n%q", buf.String(), want)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+const (
+	noSection = iota
+	nodeSection
+	waySection
+	relationSection
+)
+
+// O5MWriter serializes nodes, ways and relations in o5m format. Elements
+// must be written by kind, nodes first, then ways, then relations, like
+// O5MReader users expect: each kind is preceded by a reset marker, and all
+// three markers are written even for empty sections. Metadata are delta
+// encoded against the previous element of the same section, the way
+// readers decode them.
+type O5MWriter struct {
+	w       *bufio.Writer
+	err     error
+	section int
+	buf     []byte
+	enc     *o5m.Encoder
+	meta    o5m.MetaBase
+
+	nodeId   int64
+	lon      int64
+	lat      int64
+	wayId    int64
+	wayNode  int64
+	relId    int64
+	refIds   []int64
+	refsData []byte
+}
+
+func NewO5MWriter(w io.Writer) (*O5MWriter, error) {
+	ow := &O5MWriter{
+		w:   bufio.NewWriter(w),
+		enc: o5m.NewEncoder(),
+	}
+	ow.reset()
+	_, err := ow.w.Write([]byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2'})
+	if err != nil {
+		return nil, err
+	}
+	return ow, nil
+}
+
+func (w *O5MWriter) reset() {
+	w.enc.Reset()
+	w.meta = o5m.MetaBase{}
+	w.nodeId = 0
+	w.lon = 0
+	w.lat = 0
+	w.wayId = 0
+	w.wayNode = 0
+	w.relId = 0
+	w.refIds = make([]int64, 3)
+}
+
+// Emits reset markers until section is reached.
+func (w *O5MWriter) enterSection(section int) error {
+	if w.err != nil {
+		return w.err
+	}
+	if section < w.section {
+		w.err = fmt.Errorf("elements must be written as nodes, ways then relations")
+		return w.err
+	}
+	for w.section < section {
+		w.err = w.w.WriteByte(byte(ResetKind))
+		if w.err != nil {
+			return w.err
+		}
+		w.reset()
+		w.section++
+	}
+	return nil
+}
+
+func (w *O5MWriter) writeDataset(kind int, data []byte) error {
+	header := []byte{byte(kind)}
+	header = o5m.AppendUnsigned(header, uint64(len(data)))
+	if _, err := w.w.Write(header); err != nil {
+		w.err = err
+		return err
+	}
+	if _, err := w.w.Write(data); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *O5MWriter) appendTags(buf []byte, tags []StringPair) []byte {
+	for _, tag := range tags {
+		buf = w.enc.AppendStrings(buf, tag.Key, tag.Value)
+	}
+	return buf
+}
+
+// WriteBoundingBox writes the file bounding box. It must be called before
+// any element is written.
+func (w *O5MWriter) WriteBoundingBox(bb BoundingBox) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.section != noSection {
+		w.err = fmt.Errorf("bounding box must be written before elements")
+		return w.err
+	}
+	buf := w.buf[:0]
+	for _, v := range []float64{bb.X1, bb.Y1, bb.X2, bb.Y2} {
+		buf = o5m.AppendSigned(buf, int64(math.Round(v*1e7)))
+	}
+	w.buf = buf
+	return w.writeDataset(BBoxKind, buf)
+}
+
+func (w *O5MWriter) WriteNode(n *Node) error {
+	if err := w.enterSection(nodeSection); err != nil {
+		return err
+	}
+	buf := w.buf[:0]
+	buf = o5m.AppendSigned(buf, n.Id-w.nodeId)
+	buf = w.enc.AppendMeta(buf, &w.meta, &n.Meta)
+	// Longitude delta encoding is applied using 32-bit signed arithmetic.
+	buf = o5m.AppendSigned(buf, int64(int32(n.Lon)-int32(w.lon)))
+	buf = o5m.AppendSigned(buf, n.Lat-w.lat)
+	buf = w.appendTags(buf, n.Tags)
+	w.nodeId = n.Id
+	w.lon = n.Lon
+	w.lat = n.Lat
+	w.buf = buf
+	return w.writeDataset(NodeKind, buf)
+}
+
+func (w *O5MWriter) WriteWay(way *Way) error {
+	if err := w.enterSection(waySection); err != nil {
+		return err
+	}
+	refs := w.refsData[:0]
+	for _, id := range way.Nodes {
+		refs = o5m.AppendSigned(refs, id-w.wayNode)
+		w.wayNode = id
+	}
+	buf := w.buf[:0]
+	buf = o5m.AppendSigned(buf, way.Id-w.wayId)
+	buf = w.enc.AppendMeta(buf, &w.meta, &way.Meta)
+	buf = o5m.AppendUnsigned(buf, uint64(len(refs)))
+	buf = append(buf, refs...)
+	buf = w.appendTags(buf, way.Tags)
+	w.wayId = way.Id
+	w.buf = buf
+	w.refsData = refs
+	return w.writeDataset(WayKind, buf)
+}
+
+func (w *O5MWriter) WriteRelation(r *Relation) error {
+	if err := w.enterSection(relationSection); err != nil {
+		return err
+	}
+	refs := w.refsData[:0]
+	for _, ref := range r.Refs {
+		if ref.Type < 0 || ref.Type > 2 {
+			w.err = fmt.Errorf("invalid reference type: %d", ref.Type)
+			return w.err
+		}
+		refs = o5m.AppendSigned(refs, ref.Id-w.refIds[ref.Type])
+		w.refIds[ref.Type] = ref.Id
+		role := fmt.Sprintf("%d%s", ref.Type, ref.Role)
+		refs = w.enc.AppendString(refs, role)
+	}
+	buf := w.buf[:0]
+	buf = o5m.AppendSigned(buf, r.Id-w.relId)
+	buf = w.enc.AppendMeta(buf, &w.meta, &r.Meta)
+	buf = o5m.AppendUnsigned(buf, uint64(len(refs)))
+	buf = append(buf, refs...)
+	buf = w.appendTags(buf, r.Tags)
+	w.relId = r.Id
+	w.buf = buf
+	w.refsData = refs
+	return w.writeDataset(RelationKind, buf)
+}
+
+// Close writes missing reset markers and the end marker, and flushes
+// buffered data. It does not close the underlying writer.
+func (w *O5MWriter) Close() error {
+	if err := w.enterSection(relationSection); err != nil {
+		return err
+	}
+	if err := w.w.WriteByte(byte(EndKind)); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
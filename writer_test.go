@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+func writeTestFile(t *testing.T, nodes []Node, ways []Way,
+	relations []Relation) string {
+
+	fp, err := ioutil.TempFile("", "osm-*.o5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	w, err := NewO5MWriter(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range nodes {
+		if err := w.WriteNode(&nodes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range ways {
+		if err := w.WriteWay(&ways[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range relations {
+		if err := w.WriteRelation(&relations[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fp.Name()
+}
+
+func TestWriterRoundtrip(t *testing.T) {
+	nodes := []Node{}
+	for i := 0; i < 50000; i++ {
+		n := Node{
+			Id:  int64(i*3 + 1),
+			Lon: int64(1799999999 - i*71999),
+			Lat: int64(-899999999 + i*35999),
+		}
+		if i%3 == 0 {
+			// Enough distinct strings to wrap the strings table
+			n.Tags = []StringPair{
+				{Key: "name", Value: fmt.Sprintf("node %d", i)},
+				{Key: "place", Value: "village"},
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	ways := []Way{
+		{Id: 10, Nodes: []int64{1, 4, 7, 1}, Tags: []StringPair{{Key: "boundary", Value: "administrative"}}},
+		{Id: 12, Nodes: []int64{7, 4}},
+	}
+	long := strings.Repeat("x", 300)
+	relations := []Relation{
+		{Id: 5, Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}, {Id: 12, Type: 1, Role: "inner"}, {Id: 1, Type: 0, Role: "admin_centre"}},
+			Tags: []StringPair{{Key: "name", Value: long}, {Key: "type", Value: "boundary"}}},
+		{Id: 7, Refs: []Ref{{Id: 5, Type: 2, Role: "subarea"}, {Id: 10, Type: 1, Role: "outer"}},
+			Tags: []StringPair{{Key: "name", Value: long}, {Key: "type", Value: "boundary"}}},
+	}
+	path := writeTestFile(t, nodes, ways, relations)
+	defer os.Remove(path)
+
+	r, err := NewO5MReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	resets := 0
+	readNodes := []Node{}
+	readWays := []Way{}
+	readRelations := []Relation{}
+	for r.Next() {
+		switch r.Kind() {
+		case ResetKind:
+			resets++
+		case NodeKind:
+			n := *r.Node()
+			n.Tags = copyTags(n.Tags)
+			readNodes = append(readNodes, n)
+		case WayKind:
+			w := *r.Way()
+			w.Nodes = append([]int64{}, w.Nodes...)
+			w.Tags = copyTags(w.Tags)
+			readWays = append(readWays, w)
+		case RelationKind:
+			readRelations = append(readRelations, *r.Relation().Clone())
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if resets != 3 {
+		t.Fatalf("unexpected resets count: %d", resets)
+	}
+	normalize := func(tags []StringPair) []StringPair {
+		if len(tags) == 0 {
+			return nil
+		}
+		return tags
+	}
+	if len(readNodes) != len(nodes) {
+		t.Fatalf("unexpected nodes count: %d", len(readNodes))
+	}
+	for i, n := range nodes {
+		m := readNodes[i]
+		if n.Id != m.Id || n.Lon != m.Lon || n.Lat != m.Lat ||
+			!reflect.DeepEqual(normalize(n.Tags), normalize(m.Tags)) {
+			t.Fatalf("node mismatch: %+v != %+v", n, m)
+		}
+	}
+	for i, w := range ways {
+		m := readWays[i]
+		if w.Id != m.Id || !reflect.DeepEqual(w.Nodes, m.Nodes) ||
+			!reflect.DeepEqual(normalize(w.Tags), normalize(m.Tags)) {
+			t.Fatalf("way mismatch: %+v != %+v", w, m)
+		}
+	}
+	for i, rel := range relations {
+		m := readRelations[i]
+		if rel.Id != m.Id || !reflect.DeepEqual(rel.Refs, m.Refs) ||
+			!reflect.DeepEqual(rel.Tags, m.Tags) {
+			t.Fatalf("relation mismatch: %+v != %+v", rel, m)
+		}
+	}
+}
+
+func TestWriterMetadata(t *testing.T) {
+	uid := string(o5m.AppendUnsigned(nil, 1234))
+	nodes := []Node{
+		{Id: 1, Meta: Metadata{Version: 2, Timestamp: 1500000000,
+			Changeset: 100, Uid: uid, Author: "alice"}},
+		{Id: 2, Meta: Metadata{Version: 1, Timestamp: 1400000000,
+			Changeset: 90, Uid: uid, Author: "alice"}},
+		// No metadata keeps the delta encoding base
+		{Id: 3},
+		{Id: 4, Meta: Metadata{Version: 7, Timestamp: 1600000000,
+			Changeset: 120, Author: "bob"}},
+		// No timestamp, changeset and author are not inherited
+		{Id: 5, Meta: Metadata{Version: 2}},
+		{Id: 6, Meta: Metadata{Version: 1, Timestamp: 1600000002,
+			Changeset: 121, Author: "bob"}},
+	}
+	ways := []Way{
+		{Id: 10, Nodes: []int64{1, 2}, Meta: Metadata{Version: 3,
+			Timestamp: 1600000001, Changeset: 121, Uid: uid, Author: "alice"}},
+	}
+	fp, err := ioutil.TempFile("", "osm-*.o5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+	w, err := NewO5MWriter(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bbox := BoundingBox{X1: -1.5, Y1: -2.25, X2: 3.1234567, Y2: 4}
+	if err := w.WriteBoundingBox(bbox); err != nil {
+		t.Fatal(err)
+	}
+	for i := range nodes {
+		if err := w.WriteNode(&nodes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range ways {
+		if err := w.WriteWay(&ways[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	seq, err := NewO5MReader(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seq.Close()
+	par, err := NewParallelO5MReader(fp.Name(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer par.Close()
+	for _, r := range []OSMReader{seq, par} {
+		readNodes := []Node{}
+		var readBBox *BoundingBox
+		for r.Next() {
+			switch r.Kind() {
+			case BBoxKind:
+				bb := r.BoundingBox()
+				readBBox = &bb
+			case NodeKind:
+				readNodes = append(readNodes, *r.Node())
+			case WayKind:
+				if !reflect.DeepEqual(r.Way().Meta, ways[0].Meta) {
+					t.Fatalf("way metadata mismatch: %+v", r.Way().Meta)
+				}
+			}
+		}
+		if r.Err() != nil {
+			t.Fatal(r.Err())
+		}
+		if readBBox == nil || *readBBox != bbox {
+			t.Fatalf("bounding box mismatch: %+v", readBBox)
+		}
+		if len(readNodes) != len(nodes) {
+			t.Fatalf("unexpected nodes count: %d", len(readNodes))
+		}
+		for i, n := range nodes {
+			if !reflect.DeepEqual(n.Meta, readNodes[i].Meta) {
+				t.Fatalf("node %d metadata mismatch: %+v != %+v", n.Id, n.Meta,
+					readNodes[i].Meta)
+			}
+		}
+	}
+}
+
+func TestWriterLateBoundingBox(t *testing.T) {
+	w, err := NewO5MWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteNode(&Node{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteBoundingBox(BoundingBox{}); err == nil {
+		t.Fatalf("bounding box was written after elements")
+	}
+}
+
+func TestWriterStringsWraparound(t *testing.T) {
+	// Fill the strings table, skipping long pairs, then reuse the oldest
+	// pair at the last reference and once expired.
+	long := strings.Repeat("x", 251)
+	nodes := []Node{}
+	tags := [][]StringPair{}
+	for i := 0; i < o5m.StringsTableSize; i++ {
+		tags = append(tags, []StringPair{{Key: fmt.Sprintf("k%d", i), Value: "v"}})
+		if i%1000 == 0 {
+			tags = append(tags, []StringPair{{Key: long, Value: "v"}})
+		}
+	}
+	tags = append(tags,
+		[]StringPair{{Key: "k0", Value: "v"}},
+		[]StringPair{{Key: "new", Value: "v"}},
+		[]StringPair{{Key: "k0", Value: "v"}},
+		[]StringPair{{Key: "k1", Value: "v"}},
+	)
+	for i, nodeTags := range tags {
+		nodes = append(nodes, Node{Id: int64(i + 1), Tags: nodeTags})
+	}
+	path := writeTestFile(t, nodes, nil, nil)
+	defer os.Remove(path)
+
+	seq, err := NewO5MReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seq.Close()
+	par, err := NewParallelO5MReader(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer par.Close()
+	for _, r := range []OSMReader{seq, par} {
+		i := 0
+		for r.Next() {
+			if r.Kind() != NodeKind {
+				continue
+			}
+			if !reflect.DeepEqual(r.Node().Tags, nodes[i].Tags) {
+				t.Fatalf("node %d tags mismatch: %+v != %+v", i+1,
+					r.Node().Tags, nodes[i].Tags)
+			}
+			i++
+		}
+		if r.Err() != nil {
+			t.Fatal(r.Err())
+		}
+		if i != len(nodes) {
+			t.Fatalf("unexpected nodes count: %d", i)
+		}
+	}
+}
+
+// Reads osmconvert fixtures and writes them back, which must produce the
+// same bytes, strings table references and metadata deltas included.
+func TestWriterOsmconvertFixtures(t *testing.T) {
+	for _, name := range []string{"metadata.o5m", "strings.o5m"} {
+		t.Run(name, func(t *testing.T) {
+			testWriterFixture(t, filepath.Join("o5m", "testdata", name))
+		})
+	}
+}
+
+func testWriterFixture(t *testing.T, path string) {
+	expected, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Fatalf("%s is missing, generate it with o5m/testdata/gen.sh", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewO5MReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	out := &bytes.Buffer{}
+	w, err := NewO5MWriter(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r.Next() && err == nil {
+		switch r.Kind() {
+		case NodeKind:
+			err = w.WriteNode(r.Node())
+		case WayKind:
+			err = w.WriteWay(r.Way())
+		case RelationKind:
+			err = w.WriteRelation(r.Relation())
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	written := out.Bytes()
+	if !bytes.Equal(written, expected) {
+		i := 0
+		for i < len(written) && i < len(expected) && written[i] == expected[i] {
+			i++
+		}
+		t.Fatalf("output differs at offset %d of %d/%d bytes", i,
+			len(written), len(expected))
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+// OSMXMLReader reads OpenStreetMap XML files like O5MReader does: the
+// bounding box is reported first, then elements by kind, each kind being
+// preceded by a reset point, including empty ones. Elements must be sorted
+// by kind, like in planet and API dumps.
+type OSMXMLReader struct {
+	fp           *o5m.Input
+	indexer      fileIndexer
+	d            *xml.Decoder
+	err          error
+	kind         int
+	ignoredKinds []bool
+
+	// File offset where the decoder started
+	base          int
+	section       int
+	pending       int
+	pendingOffset int
+	resetPoint    ResetPoint
+	boundingBox   *BoundingBox
+	node          Node
+	way           Way
+	relation      Relation
+}
+
+func NewOSMXMLReader(path string, ignoredKind ...int) (*OSMXMLReader, error) {
+	fp, err := o5m.OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newOSMXMLReader(fp, ignoredKind...)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newOSMXMLReader(fp *o5m.Input, ignoredKind ...int) (*OSMXMLReader,
+	error) {
+
+	ignoredKinds, err := makeIgnoredKinds(ignoredKind)
+	if err != nil {
+		return nil, err
+	}
+	r := &OSMXMLReader{
+		fp:           fp,
+		indexer:      fileIndexer{path: fp.Path()},
+		ignoredKinds: ignoredKinds,
+	}
+	r.resetDecoder(0)
+	return r, nil
+}
+
+func (r *OSMXMLReader) Close() error {
+	return r.fp.Close()
+}
+
+func (r *OSMXMLReader) resetDecoder(offset int) {
+	r.base = offset
+	r.d = xml.NewDecoder(bufio.NewReaderSize(r.fp, 1024*1024))
+}
+
+func (r *OSMXMLReader) offset() int {
+	return r.base + int(r.d.InputOffset())
+}
+
+// Reads tokens until the end of the current element. RawToken is used
+// everywhere since the decoder may start in the middle of the document
+// after a Seek, where closing tags do not match anything.
+func (r *OSMXMLReader) skipElement() error {
+	depth := 1
+	for depth > 0 {
+		t, err := r.d.RawToken()
+		if err != nil {
+			return err
+		}
+		switch t.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+// Calls fn with the children start elements of the current element, then
+// consumes the end of the element.
+func (r *OSMXMLReader) readChildren(fn func(e *xml.StartElement) error) error {
+	for {
+		t, err := r.d.RawToken()
+		if err != nil {
+			return err
+		}
+		switch e := t.(type) {
+		case xml.StartElement:
+			if err := fn(&e); err != nil {
+				return err
+			}
+			if err := r.skipElement(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func getXmlAttr(e *xml.StartElement, name string) (string, bool) {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func parseXmlInt(e *xml.StartElement, name string, required bool) (int64, error) {
+	v, ok := getXmlAttr(e, name)
+	if !ok {
+		if required {
+			return 0, fmt.Errorf("<%s> is missing %s attribute", e.Name.Local, name)
+		}
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid <%s> %s: %q", e.Name.Local, name, v)
+	}
+	return n, nil
+}
+
+// Parses a coordinate attribute into o5m fixed point coordinates.
+func parseXmlCoord(e *xml.StartElement, name string) (int64, error) {
+	v, ok := getXmlAttr(e, name)
+	if !ok {
+		return 0, fmt.Errorf("<%s> is missing %s attribute", e.Name.Local, name)
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid <%s> %s: %q", e.Name.Local, name, v)
+	}
+	return int64(math.Round(f * 1e7)), nil
+}
+
+func parseXmlMeta(e *xml.StartElement) (Metadata, error) {
+	m := Metadata{}
+	version, err := parseXmlInt(e, "version", false)
+	if err != nil || version == 0 {
+		return m, err
+	}
+	m.Version = int(version)
+	if v, ok := getXmlAttr(e, "timestamp"); ok {
+		ts, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return m, fmt.Errorf("invalid timestamp: %q", v)
+		}
+		m.Timestamp = int(ts.Unix())
+	}
+	changeset, err := parseXmlInt(e, "changeset", false)
+	if err != nil {
+		return m, err
+	}
+	m.Changeset = int(changeset)
+	uid, err := parseXmlInt(e, "uid", false)
+	if err != nil {
+		return m, err
+	}
+	if uid > 0 {
+		m.Uid = string(o5m.AppendUnsigned(nil, uint64(uid)))
+	}
+	m.Author, _ = getXmlAttr(e, "user")
+	return m, nil
+}
+
+func parseXmlTag(e *xml.StartElement, tags []StringPair) []StringPair {
+	k, _ := getXmlAttr(e, "k")
+	v, _ := getXmlAttr(e, "v")
+	return append(tags, StringPair{
+		Key:   k,
+		Value: v,
+	})
+}
+
+func (r *OSMXMLReader) parseBounds(e *xml.StartElement) error {
+	box := []float64{}
+	for _, name := range []string{"minlon", "minlat", "maxlon", "maxlat"} {
+		v, err := parseXmlCoord(e, name)
+		if err != nil {
+			return err
+		}
+		box = append(box, float64(v)/1e7)
+	}
+	r.boundingBox = &BoundingBox{
+		X1: box[0],
+		Y1: box[1],
+		X2: box[2],
+		Y2: box[3],
+	}
+	return r.skipElement()
+}
+
+func (r *OSMXMLReader) parseNode(e *xml.StartElement) error {
+	n := &r.node
+	id, err := parseXmlInt(e, "id", true)
+	if err != nil {
+		return err
+	}
+	*n = Node{Id: id}
+	if r.ignoredKinds[NodeKind] {
+		return r.skipElement()
+	}
+	n.Lon, err = parseXmlCoord(e, "lon")
+	if err != nil {
+		return err
+	}
+	n.Lat, err = parseXmlCoord(e, "lat")
+	if err != nil {
+		return err
+	}
+	n.Meta, err = parseXmlMeta(e)
+	if err != nil {
+		return err
+	}
+	return r.readChildren(func(c *xml.StartElement) error {
+		if c.Name.Local == "tag" {
+			n.Tags = parseXmlTag(c, n.Tags)
+		}
+		return nil
+	})
+}
+
+func (r *OSMXMLReader) parseWay(e *xml.StartElement) error {
+	w := &r.way
+	id, err := parseXmlInt(e, "id", true)
+	if err != nil {
+		return err
+	}
+	*w = Way{Id: id}
+	if r.ignoredKinds[WayKind] {
+		return r.skipElement()
+	}
+	w.Meta, err = parseXmlMeta(e)
+	if err != nil {
+		return err
+	}
+	return r.readChildren(func(c *xml.StartElement) error {
+		switch c.Name.Local {
+		case "tag":
+			w.Tags = parseXmlTag(c, w.Tags)
+		case "nd":
+			ref, err := parseXmlInt(c, "ref", true)
+			if err != nil {
+				return err
+			}
+			w.Nodes = append(w.Nodes, ref)
+		}
+		return nil
+	})
+}
+
+var (
+	xmlMemberTypes = map[string]int{
+		"node":     0,
+		"way":      1,
+		"relation": 2,
+	}
+)
+
+func (r *OSMXMLReader) parseRelation(e *xml.StartElement) error {
+	rel := &r.relation
+	id, err := parseXmlInt(e, "id", true)
+	if err != nil {
+		return err
+	}
+	*rel = Relation{Id: id}
+	if r.ignoredKinds[RelationKind] {
+		return r.skipElement()
+	}
+	rel.Meta, err = parseXmlMeta(e)
+	if err != nil {
+		return err
+	}
+	return r.readChildren(func(c *xml.StartElement) error {
+		switch c.Name.Local {
+		case "tag":
+			rel.Tags = parseXmlTag(c, rel.Tags)
+		case "member":
+			ref, err := parseXmlInt(c, "ref", true)
+			if err != nil {
+				return err
+			}
+			typ, _ := getXmlAttr(c, "type")
+			t, ok := xmlMemberTypes[typ]
+			if !ok {
+				return fmt.Errorf("invalid member type: %q", typ)
+			}
+			role, _ := getXmlAttr(c, "role")
+			rel.Refs = append(rel.Refs, Ref{
+				Id:   ref,
+				Type: t,
+				Role: role,
+			})
+		}
+		return nil
+	})
+}
+
+// Parses the next bounding box or element and returns its kind and offset.
+// Returns io.EOF at end of input.
+func (r *OSMXMLReader) readElement() (int, int, error) {
+	for {
+		offset := r.offset()
+		t, err := r.d.RawToken()
+		if err != nil {
+			return 0, offset, err
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		kind := 0
+		switch e.Name.Local {
+		case "osm":
+			continue
+		case "bounds":
+			kind, err = BBoxKind, r.parseBounds(&e)
+		case "node":
+			kind, err = NodeKind, r.parseNode(&e)
+		case "way":
+			kind, err = WayKind, r.parseWay(&e)
+		case "relation":
+			kind, err = RelationKind, r.parseRelation(&e)
+		default:
+			err = r.skipElement()
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, offset, fmt.Errorf("cannot parse <%s> at %d: %s",
+				e.Name.Local, offset, err)
+		}
+		if kind != 0 {
+			return kind, offset, nil
+		}
+	}
+}
+
+func (r *OSMXMLReader) enterSection(offset int) bool {
+	r.section++
+	r.kind = ResetKind
+	r.resetPoint = ResetPoint{
+		Offset:  offset,
+		Section: r.section,
+	}
+	return true
+}
+
+func (r *OSMXMLReader) Next() bool {
+	if r.err != nil || r.kind == EndKind {
+		return false
+	}
+	if r.pending == 0 {
+		kind, offset, err := r.readElement()
+		if err == io.EOF {
+			if r.section < relationSection {
+				return r.enterSection(offset)
+			}
+			r.kind = EndKind
+			return false
+		}
+		if err != nil {
+			r.err = err
+			return false
+		}
+		r.pending = kind
+		r.pendingOffset = offset
+	}
+	if r.pending != BBoxKind {
+		section := kindSection(r.pending)
+		if section < r.section {
+			r.err = fmt.Errorf("elements are not sorted by kind at offset %d",
+				r.pendingOffset)
+			return false
+		}
+		if section > r.section {
+			return r.enterSection(r.pendingOffset)
+		}
+	}
+	r.kind = r.pending
+	r.pending = 0
+	return true
+}
+
+// Seek moves the reader back to target, the next call to Next() returns the
+// reset point again.
+func (r *OSMXMLReader) Seek(target ResetPoint) error {
+	_, err := r.fp.Seek(int64(target.Offset), 0)
+	if err != nil {
+		return err
+	}
+	r.resetDecoder(target.Offset)
+	r.err = nil
+	r.kind = ResetKind
+	r.pending = 0
+	r.section = target.Section - 1
+	return nil
+}
+
+// SeekToKind moves the reader to the reset point preceding the first element
+// of kind. The file is indexed on first call.
+func (r *OSMXMLReader) SeekToKind(kind int) error {
+	p, err := r.indexer.start(kind)
+	if err != nil {
+		return err
+	}
+	return r.Seek(p)
+}
+
+func (r *OSMXMLReader) Err() error {
+	return r.err
+}
+
+func (r *OSMXMLReader) Kind() int {
+	return r.kind
+}
+
+func (r *OSMXMLReader) ResetPoint() ResetPoint {
+	if r.kind != ResetKind {
+		panic("not a reset point")
+	}
+	return r.resetPoint
+}
+
+func (r *OSMXMLReader) BoundingBox() BoundingBox {
+	if r.kind != BBoxKind {
+		panic("not a bounding box")
+	}
+	return *r.boundingBox
+}
+
+func (r *OSMXMLReader) Node() *Node {
+	if r.kind != NodeKind {
+		panic("not a node")
+	}
+	return &r.node
+}
+
+func (r *OSMXMLReader) Way() *Way {
+	if r.kind != WayKind {
+		panic("not a way")
+	}
+	return &r.way
+}
+
+func (r *OSMXMLReader) Relation() *Relation {
+	if r.kind != RelationKind {
+		panic("not a relation")
+	}
+	return &r.relation
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pmezard/osm/o5m"
+)
+
+const testOsmXml = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6" generator="test">
+ <bounds minlat="-3" minlon="-1" maxlat="4" maxlon="2.5"/>
+ <node id="1" lat="45.191733" lon="5.7346073" version="2" timestamp="2020-01-02T03:04:05Z" changeset="10" uid="42" user="alice">
+  <tag k="name" v="A &amp; B"/>
+ </node>
+ <node id="2" lat="-0.5" lon="-179.9999999"/>
+ <way id="10">
+  <nd ref="1"/>
+  <nd ref="2"/>
+  <tag k="highway" v="road"/>
+ </way>
+ <relation id="5">
+  <member type="way" ref="10" role="outer"/>
+  <member type="node" ref="1" role="admin_centre"/>
+  <tag k="type" v="boundary"/>
+ </relation>
+</osm>
+`
+
+func writeTestXml(t *testing.T, data string) string {
+	fp, err := ioutil.TempFile("", "osm-*.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	if _, err := fp.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return fp.Name()
+}
+
+func TestOSMXMLReader(t *testing.T) {
+	path := writeTestXml(t, testOsmXml)
+	defer os.Remove(path)
+
+	r, err := OpenOSMReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, ok := r.(*OSMXMLReader); !ok {
+		t.Fatalf("unexpected reader: %T", r)
+	}
+	kinds := []int{}
+	nodes := []Node{}
+	ways := []Way{}
+	relations := []Relation{}
+	resets := []ResetPoint{}
+	for r.Next() {
+		kinds = append(kinds, r.Kind())
+		switch r.Kind() {
+		case BBoxKind:
+			bb := r.BoundingBox()
+			if bb != (BoundingBox{X1: -1, Y1: -3, X2: 2.5, Y2: 4}) {
+				t.Fatalf("unexpected bounding box: %+v", bb)
+			}
+		case ResetKind:
+			resets = append(resets, r.ResetPoint())
+		case NodeKind:
+			nodes = append(nodes, *r.Node())
+		case WayKind:
+			ways = append(ways, *r.Way())
+		case RelationKind:
+			relations = append(relations, *r.Relation().Clone())
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	wantKinds := []int{BBoxKind, ResetKind, NodeKind, NodeKind, ResetKind,
+		WayKind, ResetKind, RelationKind}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Fatalf("unexpected kinds: %v", kinds)
+	}
+	wantNodes := []Node{
+		{Id: 1, Lon: 57346073, Lat: 451917330,
+			Meta: Metadata{Version: 2, Timestamp: 1577934245, Changeset: 10,
+				Uid: string(o5m.AppendUnsigned(nil, 42)), Author: "alice"},
+			Tags: []StringPair{{Key: "name", Value: "A & B"}}},
+		{Id: 2, Lon: -1799999999, Lat: -5000000},
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Fatalf("unexpected nodes:\n%+v\n!=\n%+v", nodes, wantNodes)
+	}
+	wantWay := Way{Id: 10, Nodes: []int64{1, 2},
+		Tags: []StringPair{{Key: "highway", Value: "road"}}}
+	if len(ways) != 1 || !reflect.DeepEqual(ways[0], wantWay) {
+		t.Fatalf("unexpected ways: %+v", ways)
+	}
+	wantRel := Relation{Id: 5,
+		Refs: []Ref{{Id: 10, Type: 1, Role: "outer"}, {Id: 1, Type: 0, Role: "admin_centre"}},
+		Tags: []StringPair{{Key: "type", Value: "boundary"}}}
+	if len(relations) != 1 || !reflect.DeepEqual(relations[0], wantRel) {
+		t.Fatalf("unexpected relations: %+v", relations)
+	}
+
+	// Restart from the middle of the document, where </osm> is unmatched
+	err = r.Seek(resets[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds = []int{}
+	for r.Next() {
+		kinds = append(kinds, r.Kind())
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	wantKinds = []int{ResetKind, WayKind, ResetKind, RelationKind}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Fatalf("unexpected kinds after seek: %v", kinds)
+	}
+}
+
+func TestOSMXMLReaderErrors(t *testing.T) {
+	tests := []string{
+		// Unsorted
+		`<osm><way id="1"/><node id="2" lat="0" lon="0"/></osm>`,
+		`<osm><node id="1" lat="x" lon="0"/></osm>`,
+		`<osm><relation id="1"><member type="area" ref="1"/></relation></osm>`,
+		`<osm><node id="1" lat="0" lon="0">`,
+	}
+	for _, test := range tests {
+		path := writeTestXml(t, test)
+		r, err := NewOSMXMLReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for r.Next() {
+		}
+		r.Close()
+		os.Remove(path)
+		if r.Err() == nil {
+			t.Fatalf("error expected for %s", test)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	xmlMemberNames = []string{"node", "way", "relation"}
+)
+
+// OSMXMLWriter serializes nodes, ways and relations as an OpenStreetMap
+// XML document, the inverse of OSMXMLReader. Like O5MWriter, elements must
+// be written by kind, nodes first, then ways, then relations.
+type OSMXMLWriter struct {
+	w       *bufio.Writer
+	err     error
+	section int
+}
+
+func NewOSMXMLWriter(w io.Writer) (*OSMXMLWriter, error) {
+	xw := &OSMXMLWriter{
+		w: bufio.NewWriter(w),
+	}
+	_, err := xw.w.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		"<osm version=\"0.6\" generator=\"osm\">\n")
+	if err != nil {
+		return nil, err
+	}
+	return xw, nil
+}
+
+// Formats o5m fixed point coordinates, without losing precision.
+func formatXmlCoord(c int64) string {
+	return strconv.FormatFloat(float64(c)/1e7, 'f', 7, 64)
+}
+
+func (w *OSMXMLWriter) attr(name, value string) {
+	w.w.WriteString(" " + name + "=\"")
+	xml.EscapeText(w.w, []byte(value))
+	w.w.WriteByte('"')
+}
+
+func (w *OSMXMLWriter) intAttr(name string, value int64) {
+	w.w.WriteString(" " + name + "=\"" + strconv.FormatInt(value, 10) + "\"")
+}
+
+func (w *OSMXMLWriter) metaAttrs(m *Metadata) {
+	if m.Version <= 0 {
+		return
+	}
+	w.intAttr("version", int64(m.Version))
+	if m.Timestamp != 0 {
+		w.attr("timestamp", time.Unix(int64(m.Timestamp), 0).UTC().
+			Format(time.RFC3339))
+		w.intAttr("changeset", int64(m.Changeset))
+	}
+	if uid := metadataUid(m); uid > 0 {
+		w.intAttr("uid", uid)
+	}
+	if m.Author != "" {
+		w.attr("user", m.Author)
+	}
+}
+
+func (w *OSMXMLWriter) tags(tags []StringPair) {
+	for _, tag := range tags {
+		w.w.WriteString("  <tag")
+		w.attr("k", tag.Key)
+		w.attr("v", tag.Value)
+		w.w.WriteString("/>\n")
+	}
+}
+
+func (w *OSMXMLWriter) enterSection(section int) error {
+	if w.err != nil {
+		return w.err
+	}
+	if section < w.section {
+		w.err = fmt.Errorf("elements must be written as nodes, ways then relations")
+		return w.err
+	}
+	w.section = section
+	return nil
+}
+
+// Writes the end of an element. bufio.Writer errors are sticky, so this
+// also reports failures of previous writes.
+func (w *OSMXMLWriter) end(s string) error {
+	if _, err := w.w.WriteString(s); err != nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// WriteBoundingBox writes the file bounding box. It must be called before
+// any element is written.
+func (w *OSMXMLWriter) WriteBoundingBox(bb BoundingBox) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.section != noSection {
+		w.err = fmt.Errorf("bounding box must be written before elements")
+		return w.err
+	}
+	w.w.WriteString(" <bounds")
+	w.attr("minlat", strconv.FormatFloat(bb.Y1, 'f', 7, 64))
+	w.attr("minlon", strconv.FormatFloat(bb.X1, 'f', 7, 64))
+	w.attr("maxlat", strconv.FormatFloat(bb.Y2, 'f', 7, 64))
+	w.attr("maxlon", strconv.FormatFloat(bb.X2, 'f', 7, 64))
+	return w.end("/>\n")
+}
+
+func (w *OSMXMLWriter) WriteNode(n *Node) error {
+	if err := w.enterSection(nodeSection); err != nil {
+		return err
+	}
+	w.w.WriteString(" <node")
+	w.intAttr("id", n.Id)
+	w.metaAttrs(&n.Meta)
+	w.attr("lat", formatXmlCoord(n.Lat))
+	w.attr("lon", formatXmlCoord(n.Lon))
+	if len(n.Tags) == 0 {
+		return w.end("/>\n")
+	}
+	w.w.WriteString(">\n")
+	w.tags(n.Tags)
+	return w.end(" </node>\n")
+}
+
+func (w *OSMXMLWriter) WriteWay(way *Way) error {
+	if err := w.enterSection(waySection); err != nil {
+		return err
+	}
+	w.w.WriteString(" <way")
+	w.intAttr("id", way.Id)
+	w.metaAttrs(&way.Meta)
+	if len(way.Nodes) == 0 && len(way.Tags) == 0 {
+		return w.end("/>\n")
+	}
+	w.w.WriteString(">\n")
+	for _, id := range way.Nodes {
+		w.w.WriteString("  <nd")
+		w.intAttr("ref", id)
+		w.w.WriteString("/>\n")
+	}
+	w.tags(way.Tags)
+	return w.end(" </way>\n")
+}
+
+func (w *OSMXMLWriter) WriteRelation(r *Relation) error {
+	if err := w.enterSection(relationSection); err != nil {
+		return err
+	}
+	for _, ref := range r.Refs {
+		if ref.Type < 0 || ref.Type > 2 {
+			w.err = fmt.Errorf("invalid reference type: %d", ref.Type)
+			return w.err
+		}
+	}
+	w.w.WriteString(" <relation")
+	w.intAttr("id", r.Id)
+	w.metaAttrs(&r.Meta)
+	if len(r.Refs) == 0 && len(r.Tags) == 0 {
+		return w.end("/>\n")
+	}
+	w.w.WriteString(">\n")
+	for _, ref := range r.Refs {
+		w.w.WriteString("  <member")
+		w.attr("type", xmlMemberNames[ref.Type])
+		w.intAttr("ref", ref.Id)
+		w.attr("role", ref.Role)
+		w.w.WriteString("/>\n")
+	}
+	w.tags(r.Tags)
+	return w.end(" </relation>\n")
+}
+
+// Close ends the document and flushes buffered data. It does not close the
+// underlying writer.
+func (w *OSMXMLWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.end("</osm>\n"); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}